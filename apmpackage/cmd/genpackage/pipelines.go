@@ -22,6 +22,8 @@ import (
 	"strings"
 
 	"github.com/elastic/elastic-agent-libs/version"
+
+	"github.com/elastic/apm-server/internal/ingestpipeline"
 )
 
 // getCommonPipelines returns pipelines that may be inlined into our data stream ingest pipelines.
@@ -39,9 +41,9 @@ func getCommonPipeline(name string, version *version.V) []map[string]interface{}
 		"observer_version": getObserverVersionPipeline(version),
 		"observer_ids":     observerIDsPipeline,
 		"ecs_version":      ecsVersionPipeline,
-		"user_agent":       userAgentPipeline,
+		"user_agent":       ingestpipeline.UserAgentProcessors,
 		"process_ppid":     processPpidPipeline,
-		"client_geoip":     clientGeoIPPipeline,
+		"client_geoip":     ingestpipeline.ClientGeoIPProcessors,
 		"event_duration":   eventDurationPipeline,
 	}
 	return commonPipelines[name]
@@ -109,15 +111,6 @@ var ecsVersionPipeline = []map[string]interface{}{{
 	},
 }}
 
-var userAgentPipeline = []map[string]interface{}{{
-	"user_agent": map[string]interface{}{
-		"field":          "user_agent.original",
-		"target_field":   "user_agent",
-		"ignore_missing": true,
-		"ignore_failure": true,
-	},
-}}
-
 var processPpidPipeline = []map[string]interface{}{{
 	"rename": map[string]interface{}{
 		"field":          "process.ppid",
@@ -127,22 +120,6 @@ var processPpidPipeline = []map[string]interface{}{{
 	},
 }}
 
-var clientGeoIPPipeline = []map[string]interface{}{{
-	"geoip": map[string]interface{}{
-		"field":          "client.ip",
-		"target_field":   "client.geo",
-		"ignore_missing": true,
-		"database_file":  "GeoLite2-City.mmdb",
-		"on_failure": []map[string]interface{}{{
-			"remove": map[string]interface{}{
-				"field":          "client.ip",
-				"ignore_missing": true,
-				"ignore_failure": true,
-			},
-		}},
-	},
-}}
-
 // This pipeline translates `event.duration` (defaulting to zero if not
 // found) to `transaction.duration.us` or `span.duration.us` depending on
 // the event type, and then removes `event.duration`. Older versions of
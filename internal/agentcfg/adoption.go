@@ -0,0 +1,294 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package agentcfg
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// maxAdoptionEntries limits the number of agents, and the number of services
+// served agent configuration, tracked by Adoption, protecting against
+// unbounded memory usage due to arbitrary service metadata.
+const maxAdoptionEntries = 10000
+
+// Adoption tracks the propagation of agent central configuration.
+//
+// Adoption is a model.BatchProcessor which records the Etag of the agent
+// configuration that each agent reports having applied in intake metadata,
+// and wraps a Fetcher to record the Etag of the configuration most recently
+// served to each service. Agents are identified by their ephemeral ID, or
+// by service node name if agents do not report an ephemeral ID.
+type Adoption struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu         sync.Mutex
+	agents     map[adoptionAgentKey]*adoptionAgent
+	served     map[Service]*adoptionServed
+	overflowed int64
+}
+
+type adoptionAgentKey struct {
+	service  Service
+	instance string
+}
+
+type adoptionAgent struct {
+	etag     string
+	lastSeen time.Time
+}
+
+type adoptionServed struct {
+	etag       string
+	lastServed time.Time
+}
+
+// AdoptionEntry holds the adoption of agent configuration by the agents
+// of a service.
+type AdoptionEntry struct {
+	ServiceName        string `json:"service_name"`
+	ServiceEnvironment string `json:"service_environment,omitempty"`
+
+	// CurrentEtag holds the Etag of the agent configuration most recently
+	// served to the service's agents, if any was served within the window.
+	CurrentEtag string `json:"current_etag,omitempty"`
+
+	// Agents holds the number of agents which reported an applied
+	// configuration within the window.
+	Agents int `json:"agents"`
+
+	// AgentsCurrent holds the number of agents which reported having
+	// applied the configuration identified by CurrentEtag.
+	AgentsCurrent int `json:"agents_current"`
+
+	// Configs holds the number of agents which reported having applied
+	// each configuration, ordered by Etag.
+	Configs []AdoptionConfig `json:"configs"`
+}
+
+// AdoptionConfig holds the number of agents which applied a configuration.
+type AdoptionConfig struct {
+	Etag     string    `json:"etag"`
+	Agents   int       `json:"agents"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// NewAdoption returns an Adoption that tracks the configuration applied by
+// agents which sent events within the given window.
+func NewAdoption(window time.Duration) *Adoption {
+	return &Adoption{
+		window: window,
+		now:    time.Now,
+		agents: make(map[adoptionAgentKey]*adoptionAgent),
+		served: make(map[Service]*adoptionServed),
+	}
+}
+
+// ProcessBatch records the applied configuration Etag reported by the agents
+// of events in b. Events without a service name, configuration Etag, or agent
+// identifier are ignored.
+func (a *Adoption) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range *b {
+		event := &(*b)[i]
+		if event.Service.Name == "" || event.Agent.ConfigEtag == "" {
+			continue
+		}
+		instance := event.Agent.EphemeralID
+		if instance == "" {
+			instance = event.Service.Node.Name
+			if instance == "" {
+				continue
+			}
+		}
+		key := adoptionAgentKey{
+			service:  Service{Name: event.Service.Name, Environment: event.Service.Environment},
+			instance: instance,
+		}
+		agent, ok := a.agents[key]
+		if !ok {
+			if len(a.agents) >= maxAdoptionEntries {
+				a.expire(now)
+			}
+			if len(a.agents) >= maxAdoptionEntries {
+				a.overflowed++
+				continue
+			}
+			agent = &adoptionAgent{}
+			a.agents[key] = agent
+		}
+		agent.etag = event.Agent.ConfigEtag
+		agent.lastSeen = now
+	}
+	return nil
+}
+
+// Fetcher returns a Fetcher which fetches agent configuration with f, and
+// records the Etag of the configuration served for each service.
+func (a *Adoption) Fetcher(f Fetcher) Fetcher {
+	return adoptionFetcher{f: f, a: a}
+}
+
+type adoptionFetcher struct {
+	f Fetcher
+	a *Adoption
+}
+
+func (f adoptionFetcher) Fetch(ctx context.Context, query Query) (Result, error) {
+	result, err := f.f.Fetch(ctx, query)
+	if err != nil || query.Service.Name == "" {
+		return result, err
+	}
+	f.a.recordServed(query.Service, result.Source.Etag)
+	return result, err
+}
+
+func (a *Adoption) recordServed(service Service, etag string) {
+	now := a.now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if etag == EtagSentinel {
+		// No configuration is defined for the service.
+		delete(a.served, service)
+		return
+	}
+	served, ok := a.served[service]
+	if !ok {
+		if len(a.served) >= maxAdoptionEntries {
+			a.expire(now)
+		}
+		if len(a.served) >= maxAdoptionEntries {
+			return
+		}
+		served = &adoptionServed{}
+		a.served[service] = served
+	}
+	served.etag = etag
+	served.lastServed = now
+}
+
+// Services returns the adoption of agent configuration by the agents of each
+// service which reported an applied configuration, or was served one, within
+// the window. Services are ordered by name and environment. The number of
+// agents which could not be recorded due to the maximum number of entries
+// being reached is also returned.
+func (a *Adoption) Services() (services []AdoptionEntry, overflowed int64) {
+	now := a.now()
+	a.mu.Lock()
+	a.expire(now)
+	entries := make(map[Service]*AdoptionEntry)
+	configs := make(map[Service]map[string]*AdoptionConfig)
+	getEntry := func(service Service) *AdoptionEntry {
+		entry, ok := entries[service]
+		if !ok {
+			entry = &AdoptionEntry{
+				ServiceName:        service.Name,
+				ServiceEnvironment: service.Environment,
+				Configs:            []AdoptionConfig{},
+			}
+			entries[service] = entry
+			configs[service] = make(map[string]*AdoptionConfig)
+		}
+		return entry
+	}
+	for service, served := range a.served {
+		getEntry(service).CurrentEtag = served.etag
+	}
+	for key, agent := range a.agents {
+		getEntry(key.service).Agents++
+		config, ok := configs[key.service][agent.etag]
+		if !ok {
+			config = &AdoptionConfig{Etag: agent.etag}
+			configs[key.service][agent.etag] = config
+		}
+		config.Agents++
+		if agent.lastSeen.After(config.LastSeen) {
+			config.LastSeen = agent.lastSeen
+		}
+	}
+	overflowed = a.overflowed
+	a.mu.Unlock()
+
+	services = make([]AdoptionEntry, 0, len(entries))
+	for service, entry := range entries {
+		for _, config := range configs[service] {
+			if config.Etag == entry.CurrentEtag {
+				entry.AgentsCurrent = config.Agents
+			}
+			entry.Configs = append(entry.Configs, *config)
+		}
+		sort.Slice(entry.Configs, func(i, j int) bool {
+			return entry.Configs[i].Etag < entry.Configs[j].Etag
+		})
+		services = append(services, *entry)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].ServiceName != services[j].ServiceName {
+			return services[i].ServiceName < services[j].ServiceName
+		}
+		return services[i].ServiceEnvironment < services[j].ServiceEnvironment
+	})
+	return services, overflowed
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// The number of services and agents tracked is reported, along with the
+// number of agents which applied the configuration most recently served
+// to their service, and the number of agents which could not be recorded.
+func (a *Adoption) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	services, overflowed := a.Services()
+	var agents, agentsCurrent int64
+	for _, service := range services {
+		agents += int64(service.Agents)
+		agentsCurrent += int64(service.AgentsCurrent)
+	}
+	monitoring.ReportInt(V, "services", int64(len(services)))
+	monitoring.ReportInt(V, "agents", agents)
+	monitoring.ReportInt(V, "agents_current", agentsCurrent)
+	monitoring.ReportInt(V, "overflowed", overflowed)
+}
+
+// expire removes agents which have not sent events within the window, and
+// services which have not been served configuration within the window.
+// This must be called with a.mu held.
+func (a *Adoption) expire(now time.Time) {
+	for key, agent := range a.agents {
+		if now.Sub(agent.lastSeen) > a.window {
+			delete(a.agents, key)
+		}
+	}
+	for service, served := range a.served {
+		if now.Sub(served.lastServed) > a.window {
+			delete(a.served, service)
+		}
+	}
+}
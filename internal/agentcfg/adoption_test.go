@@ -0,0 +1,136 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package agentcfg
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestAdoption(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	adoption := NewAdoption(time.Minute)
+	adoption.now = func() time.Time { return now }
+
+	agentEvent := func(service, instance, etag string) model.APMEvent {
+		return model.APMEvent{
+			Service: model.Service{Name: service, Environment: "production"},
+			Agent:   model.Agent{Name: "go", EphemeralID: instance, ConfigEtag: etag},
+		}
+	}
+	process := func(events ...model.APMEvent) {
+		batch := model.Batch(events)
+		require.NoError(t, adoption.ProcessBatch(context.Background(), &batch))
+	}
+
+	fetcher := adoption.Fetcher(fauxFetcher{})
+	_, err := fetcher.Fetch(context.Background(), Query{
+		Service: Service{Name: "frontend", Environment: "production"},
+		Etag:    "old-etag",
+	})
+	require.NoError(t, err)
+	_, err = fetcher.Fetch(context.Background(), Query{
+		Service: Service{Name: "non_matching"},
+	})
+	require.NoError(t, err)
+
+	process(
+		agentEvent("frontend", "a", "old-etag"),
+		agentEvent("frontend", "b", "old-etag"),
+		agentEvent("backend", "c", "abc123"),
+		agentEvent("frontend", "", "old-etag"), // no agent identifier
+		agentEvent("frontend", "d", ""),        // no applied configuration
+	)
+	now = now.Add(30 * time.Second)
+	process(agentEvent("frontend", "a", "new-etag"))
+
+	services, overflowed := adoption.Services()
+	assert.Zero(t, overflowed)
+	assert.Equal(t, []AdoptionEntry{{
+		ServiceName:        "backend",
+		ServiceEnvironment: "production",
+		Agents:             1,
+		Configs:            []AdoptionConfig{{Etag: "abc123", Agents: 1, LastSeen: now.Add(-30 * time.Second)}},
+	}, {
+		ServiceName:        "frontend",
+		ServiceEnvironment: "production",
+		CurrentEtag:        "new-etag",
+		Agents:             2,
+		AgentsCurrent:      1,
+		Configs: []AdoptionConfig{
+			{Etag: "new-etag", Agents: 1, LastSeen: now},
+			{Etag: "old-etag", Agents: 1, LastSeen: now.Add(-30 * time.Second)},
+		},
+	}}, services)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "adoption", adoption.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"adoption.services":       2,
+		"adoption.agents":         3,
+		"adoption.agents_current": 1,
+		"adoption.overflowed":     0,
+	}, snapshot.Ints)
+
+	// Agents and services which have not been seen or served
+	// configuration within the window are removed.
+	now = now.Add(45 * time.Second)
+	services, _ = adoption.Services()
+	require.Len(t, services, 1)
+	assert.Equal(t, "frontend", services[0].ServiceName)
+	assert.Empty(t, services[0].CurrentEtag)
+	assert.Equal(t, 1, services[0].Agents)
+}
+
+func TestAdoptionServiceNodeName(t *testing.T) {
+	adoption := NewAdoption(time.Minute)
+	batch := model.Batch{{
+		Service: model.Service{Name: "frontend", Node: model.ServiceNode{Name: "node-1"}},
+		Agent:   model.Agent{ConfigEtag: "abc123"},
+	}}
+	require.NoError(t, adoption.ProcessBatch(context.Background(), &batch))
+	services, _ := adoption.Services()
+	require.Len(t, services, 1)
+	assert.Equal(t, 1, services[0].Agents)
+}
+
+func TestAdoptionOverflow(t *testing.T) {
+	adoption := NewAdoption(time.Minute)
+	batch := make(model.Batch, maxAdoptionEntries+1)
+	for i := range batch {
+		batch[i].Service.Name = "frontend"
+		batch[i].Agent.EphemeralID = fmt.Sprintf("agent_%d", i)
+		batch[i].Agent.ConfigEtag = "abc123"
+	}
+	require.NoError(t, adoption.ProcessBatch(context.Background(), &batch))
+
+	services, overflowed := adoption.Services()
+	require.Len(t, services, 1)
+	assert.Equal(t, maxAdoptionEntries, services[0].Agents)
+	assert.Equal(t, int64(1), overflowed)
+}
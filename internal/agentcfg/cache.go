@@ -18,6 +18,9 @@
 package agentcfg
 
 import (
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	gocache "github.com/patrickmn/go-cache"
@@ -30,28 +33,47 @@ const (
 )
 
 type cache struct {
+	hits   int64
+	misses int64
+
 	logger  *logp.Logger
 	gocache *gocache.Cache
+
+	mu       sync.Mutex
+	services map[string]Service
 }
 
 func newCache(logger *logp.Logger, exp time.Duration) *cache {
 	logger.Infof("Cache creation with expiration %v.", exp)
-	return &cache{
-		logger:  logger,
-		gocache: gocache.New(exp, cleanupInterval)}
+	c := &cache{
+		logger:   logger,
+		gocache:  gocache.New(exp, cleanupInterval),
+		services: make(map[string]Service),
+	}
+	c.gocache.OnEvicted(func(id string, _ interface{}) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.services, id)
+	})
+	return c
 }
 
 func (c *cache) fetch(query Query, fetch func() (Result, error)) (Result, error) {
 	// return from cache if possible
 	value, found := c.gocache.Get(query.id())
 	if found && value != nil {
+		atomic.AddInt64(&c.hits, 1)
 		return value.(Result), nil
 	}
+	atomic.AddInt64(&c.misses, 1)
 	// retrieve resource from external source
 	result, err := fetch()
 	if err != nil {
 		return result, err
 	}
+	c.mu.Lock()
+	c.services[query.id()] = query.Service
+	c.mu.Unlock()
 	c.gocache.SetDefault(query.id(), result)
 
 	if c.logger.IsDebug() {
@@ -59,3 +81,25 @@ func (c *cache) fetch(query Query, fetch func() (Result, error)) (Result, error)
 	}
 	return result, nil
 }
+
+// cachedServices returns the services for which agent configuration is
+// currently cached, sorted by name and environment.
+func (c *cache) cachedServices() []Service {
+	c.mu.Lock()
+	services := make([]Service, 0, len(c.services))
+	for id, service := range c.services {
+		// Expired items are only evicted periodically,
+		// so check that the item has not yet expired.
+		if _, found := c.gocache.Get(id); found {
+			services = append(services, service)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].Environment < services[j].Environment
+	})
+	return services
+}
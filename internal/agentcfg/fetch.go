@@ -24,11 +24,14 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/kibana"
 )
@@ -47,6 +50,15 @@ const TransactionSamplingRateKey = "transaction_sample_rate"
 
 const endpoint = "/api/apm/settings/agent-configuration/search"
 
+var (
+	registry         = monitoring.Default.NewRegistry("apm-server.agentcfg")
+	monitoredFetcher monitoredKibanaFetcher
+)
+
+func init() {
+	monitoring.NewFunc(registry, "kibana", monitoredFetcher.collect, monitoring.Report)
+}
+
 // Fetcher defines a common interface to retrieving agent config.
 type Fetcher interface {
 	Fetch(context.Context, Query) (Result, error)
@@ -55,6 +67,11 @@ type Fetcher interface {
 // KibanaFetcher holds static information and information shared between requests.
 // It implements the Fetch method to retrieve agent configuration information.
 type KibanaFetcher struct {
+	fetches           int64
+	fetchErrors       int64
+	fetchDuration     int64
+	lastFetchDuration int64
+
 	*cache
 	logger *logp.Logger
 	client *kibana.Client
@@ -68,11 +85,16 @@ func NewKibanaFetcher(client *kibana.Client, cacheExpiration time.Duration) *Kib
 		panic("client is required")
 	}
 	logger := logp.NewLogger("agentcfg")
-	return &KibanaFetcher{
+	f := &KibanaFetcher{
 		client: client,
 		logger: logger,
 		cache:  newCache(logger, cacheExpiration),
 	}
+	// TODO(axw) stop assuming we have only one KibanaFetcher running
+	// at any time, and instead aggregate metrics from fetchers that are
+	// dynamically registered and unregistered.
+	monitoredFetcher.set(f)
+	return f
 }
 
 // Fetch retrieves agent configuration, fetched from Kibana or a local temporary cache.
@@ -88,7 +110,18 @@ func (f *KibanaFetcher) Fetch(ctx context.Context, query Query) (Result, error)
 	return sanitize(query.InsecureAgents, result), err
 }
 
-func (f *KibanaFetcher) request(ctx context.Context, r io.Reader) ([]byte, error) {
+func (f *KibanaFetcher) request(ctx context.Context, r io.Reader) (_ []byte, err error) {
+	start := time.Now()
+	defer func() {
+		duration := time.Since(start)
+		atomic.AddInt64(&f.fetches, 1)
+		atomic.AddInt64(&f.fetchDuration, int64(duration))
+		atomic.StoreInt64(&f.lastFetchDuration, int64(duration))
+		if err != nil {
+			atomic.AddInt64(&f.fetchErrors, 1)
+		}
+	}()
+
 	resp, err := f.client.Send(ctx, http.MethodPost, endpoint, nil, nil, r)
 	if err != nil {
 		return nil, errors.Wrap(err, ErrMsgSendToKibanaFailed)
@@ -109,6 +142,69 @@ func (f *KibanaFetcher) request(ctx context.Context, r io.Reader) ([]byte, error
 	return result, nil
 }
 
+// CollectMonitoring may be called to collect monitoring metrics from the
+// fetcher. It is intended to be used with libbeat/monitoring.NewFunc.
+//
+// The names of services with cached agent configuration are only reported
+// in monitoring.Full mode, i.e. through the expvar endpoint.
+func (f *KibanaFetcher) CollectMonitoring(mode monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	hits := atomic.LoadInt64(&f.cache.hits)
+	misses := atomic.LoadInt64(&f.cache.misses)
+	var hitRatio float64
+	if total := hits + misses; total > 0 {
+		hitRatio = float64(hits) / float64(total)
+	}
+	services := f.cache.cachedServices()
+	monitoring.ReportNamespace(V, "cache", func() {
+		monitoring.ReportInt(V, "hits", hits)
+		monitoring.ReportInt(V, "misses", misses)
+		monitoring.ReportFloat(V, "hit_ratio", hitRatio)
+		monitoring.ReportInt(V, "entries", int64(len(services)))
+		if mode == monitoring.Full {
+			names := make([]string, len(services))
+			for i, service := range services {
+				names[i] = service.Name
+				if service.Environment != "" {
+					names[i] += "/" + service.Environment
+				}
+			}
+			monitoring.ReportStringSlice(V, "services", names)
+		}
+	})
+	monitoring.ReportNamespace(V, "fetch", func() {
+		monitoring.ReportInt(V, "count", atomic.LoadInt64(&f.fetches))
+		monitoring.ReportInt(V, "errors", atomic.LoadInt64(&f.fetchErrors))
+		monitoring.ReportInt(V, "duration.ms", time.Duration(atomic.LoadInt64(&f.fetchDuration)).Milliseconds())
+		monitoring.ReportInt(V, "last_duration.ms", time.Duration(atomic.LoadInt64(&f.lastFetchDuration)).Milliseconds())
+	})
+}
+
+type monitoredKibanaFetcher struct {
+	mu      sync.RWMutex
+	fetcher *KibanaFetcher
+}
+
+func (m *monitoredKibanaFetcher) set(f *KibanaFetcher) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.fetcher = f
+}
+
+func (m *monitoredKibanaFetcher) collect(mode monitoring.Mode, V monitoring.Visitor) {
+	m.mu.RLock()
+	f := m.fetcher
+	m.mu.RUnlock()
+	if f == nil {
+		V.OnRegistryStart()
+		V.OnRegistryFinished()
+		return
+	}
+	f.CollectMonitoring(mode, V)
+}
+
 func sanitize(insecureAgents []string, result Result) Result {
 	if len(insecureAgents) == 0 {
 		return result
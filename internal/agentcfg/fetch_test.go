@@ -28,6 +28,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
 	"github.com/elastic/apm-server/internal/kibana"
 )
 
@@ -106,6 +108,48 @@ func TestKibanaFetcher(t *testing.T) {
 	})
 }
 
+func TestKibanaFetcherMonitoring(t *testing.T) {
+	statusCode := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(statusCode)
+		json.NewEncoder(w).Encode(mockDoc(0.5))
+	}))
+	defer srv.Close()
+	client, err := kibana.NewClient(kibana.ClientConfig{Host: srv.URL})
+	require.NoError(t, err)
+
+	fetcher := NewKibanaFetcher(client, time.Minute)
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "kibana", fetcher.CollectMonitoring, monitoring.Report)
+
+	_, err = fetcher.Fetch(context.Background(), Query{Service: Service{Name: "b", Environment: "prod"}})
+	require.NoError(t, err)
+	_, err = fetcher.Fetch(context.Background(), Query{Service: Service{Name: "b", Environment: "prod"}})
+	require.NoError(t, err)
+	_, err = fetcher.Fetch(context.Background(), query("a"))
+	require.NoError(t, err)
+	statusCode = http.StatusInternalServerError
+	_, err = fetcher.Fetch(context.Background(), query("c"))
+	require.Error(t, err)
+
+	snapshot := monitoring.CollectStructSnapshot(registry, monitoring.Full, false)
+	kibanaSnapshot := snapshot["kibana"].(map[string]interface{})
+	cacheSnapshot := kibanaSnapshot["cache"].(map[string]interface{})
+	assert.Equal(t, int64(1), cacheSnapshot["hits"])
+	assert.Equal(t, int64(3), cacheSnapshot["misses"])
+	assert.Equal(t, 0.25, cacheSnapshot["hit_ratio"])
+	assert.Equal(t, int64(2), cacheSnapshot["entries"])
+	assert.Equal(t, []string{"a", "b/prod"}, cacheSnapshot["services"])
+	fetchSnapshot := kibanaSnapshot["fetch"].(map[string]interface{})
+	assert.Equal(t, int64(3), fetchSnapshot["count"])
+	assert.Equal(t, int64(1), fetchSnapshot["errors"])
+
+	// Service names are only reported in full mode.
+	snapshot = monitoring.CollectStructSnapshot(registry, monitoring.Reported, false)
+	cacheSnapshot = snapshot["kibana"].(map[string]interface{})["cache"].(map[string]interface{})
+	assert.NotContains(t, cacheSnapshot, "services")
+}
+
 func TestSanitize(t *testing.T) {
 	input := Result{Source: Source{
 		Agent:    "python",
@@ -0,0 +1,227 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package alerting provides threshold-based alerts for ingest health,
+// which notify a webhook when internal conditions persist, for environments
+// where APM Server metrics are not scraped frequently enough to alert on.
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"go.elastic.co/apm/module/apmhttp/v2"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/outbound"
+)
+
+const (
+	// StatusFiring is the status of alerts whose condition has
+	// persisted for the configured duration.
+	StatusFiring = "firing"
+
+	// StatusResolved is the status of previously firing alerts
+	// whose condition no longer holds.
+	StatusResolved = "resolved"
+)
+
+var (
+	monitoringRegistry = monitoring.Default.NewRegistry("apm-server.alerting")
+	firingGauge        = monitoring.NewInt(monitoringRegistry, "firing")
+	sentCounter        = monitoring.NewInt(monitoringRegistry, "webhook.sent")
+	failedCounter      = monitoring.NewInt(monitoringRegistry, "webhook.failed")
+)
+
+// Condition is a threshold-based condition evaluated against snapshots
+// of monitoring metrics.
+type Condition struct {
+	// Name identifies the condition in webhook notifications.
+	Name string
+
+	// Threshold holds the value above which the condition holds.
+	Threshold float64
+
+	// Value returns the current value of the condition, given the current
+	// and previous metrics snapshots. If the value cannot be determined,
+	// e.g. because the metrics are not reported, Value returns false and
+	// the condition is considered to not hold.
+	Value func(current, previous monitoring.FlatSnapshot) (float64, bool)
+}
+
+// Notification is the JSON body sent to the webhook when an alert
+// starts firing or is resolved.
+type Notification struct {
+	Alert     string    `json:"alert"`
+	Status    string    `json:"status"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Since     time.Time `json:"since"`
+	Timestamp time.Time `json:"@timestamp"`
+}
+
+// Config holds configuration for an Alerter.
+type Config struct {
+	// WebhookURL holds the URL to which notifications are POSTed.
+	WebhookURL string
+
+	// Interval holds the interval at which conditions are evaluated.
+	Interval time.Duration
+
+	// Duration holds the amount of time a condition must persist
+	// before its alert fires.
+	Duration time.Duration
+
+	// Timeout holds the timeout for webhook requests.
+	Timeout time.Duration
+
+	// Conditions holds the conditions to evaluate.
+	Conditions []Condition
+}
+
+// Alerter periodically evaluates conditions against monitoring metrics,
+// notifying a webhook when a condition has persisted for the configured
+// duration, and again when it no longer holds.
+type Alerter struct {
+	config   Config
+	registry *monitoring.Registry
+	client   *http.Client
+	logger   *logp.Logger
+	now      func() time.Time
+
+	previous monitoring.FlatSnapshot
+	states   []alertState
+}
+
+type alertState struct {
+	since  time.Time // zero if the condition does not hold
+	firing bool
+}
+
+// NewAlerter returns a new Alerter which evaluates conditions against
+// metrics in the given registry.
+func NewAlerter(config Config, registry *monitoring.Registry) *Alerter {
+	return &Alerter{
+		config:   config,
+		registry: registry,
+		client: &http.Client{
+			Timeout:   config.Timeout,
+			Transport: apmhttp.WrapRoundTripper(outbound.WrapRoundTripper(outbound.Webhook, nil)),
+		},
+		logger: logp.NewLogger(logs.Beater).Named("alerting"),
+		now:    time.Now,
+		states: make([]alertState, len(config.Conditions)),
+	}
+}
+
+// Run evaluates conditions at the configured interval until ctx is cancelled.
+func (a *Alerter) Run(ctx context.Context) error {
+	t := time.NewTicker(a.config.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		a.evaluate(ctx)
+	}
+}
+
+// evaluate evaluates all conditions once, notifying the webhook of
+// any alerts which have started firing or have been resolved. If the
+// webhook cannot be notified, the alert state is left unchanged so
+// the notification is retried at the next evaluation.
+func (a *Alerter) evaluate(ctx context.Context) {
+	now := a.now()
+	current := monitoring.CollectFlatSnapshot(a.registry, monitoring.Full, false)
+	previous := a.previous
+	a.previous = current
+
+	var firing int64
+	for i, condition := range a.config.Conditions {
+		state := &a.states[i]
+		value, ok := condition.Value(current, previous)
+		holds := ok && value > condition.Threshold
+		switch {
+		case holds && state.since.IsZero():
+			state.since = now
+		case !holds:
+			if state.firing {
+				if err := a.notify(ctx, condition, StatusResolved, value, state.since, now); err != nil {
+					a.logger.Warnf("failed to notify webhook of resolved alert %q: %v", condition.Name, err)
+					firing++
+					continue
+				}
+				state.firing = false
+			}
+			state.since = time.Time{}
+		}
+		if holds && !state.firing && now.Sub(state.since) >= a.config.Duration {
+			if err := a.notify(ctx, condition, StatusFiring, value, state.since, now); err != nil {
+				a.logger.Warnf("failed to notify webhook of firing alert %q: %v", condition.Name, err)
+				continue
+			}
+			state.firing = true
+		}
+		if state.firing {
+			firing++
+		}
+	}
+	firingGauge.Set(firing)
+}
+
+func (a *Alerter) notify(ctx context.Context, condition Condition, status string, value float64, since, now time.Time) error {
+	body, err := json.Marshal(Notification{
+		Alert:     condition.Name,
+		Status:    status,
+		Value:     value,
+		Threshold: condition.Threshold,
+		Since:     since,
+		Timestamp: now,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.config.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		failedCounter.Inc()
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		failedCounter.Inc()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook request failed with status code %d: %s", resp.StatusCode, body)
+	}
+	sentCounter.Inc()
+	a.logger.Infof("alert %q %s", condition.Name, status)
+	return nil
+}
@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestAlerter(t *testing.T) {
+	var mu sync.Mutex
+	var notifications []Notification
+	fail := false
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		mu.Lock()
+		defer mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var notification Notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&notification))
+		notifications = append(notifications, notification)
+	}))
+	defer srv.Close()
+
+	registry := monitoring.NewRegistry()
+	active := monitoring.NewInt(registry, "output.elasticsearch.failover.active")
+	alerter := NewAlerter(Config{
+		WebhookURL: srv.URL,
+		Interval:   time.Second,
+		Duration:   time.Minute,
+		Timeout:    time.Second,
+		Conditions: []Condition{IndexerFailover()},
+	}, registry)
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	alerter.now = func() time.Time { return now }
+	evaluate := func(d time.Duration) {
+		now = now.Add(d)
+		alerter.evaluate(context.Background())
+	}
+
+	evaluate(0)
+	active.Set(1)
+	evaluate(time.Second)
+	since := now
+	evaluate(30 * time.Second)
+	assert.Empty(t, notifications) // condition has not persisted long enough
+
+	// Failed notifications are retried at the next evaluation.
+	fail = true
+	evaluate(30 * time.Second)
+	assert.Empty(t, notifications)
+	assert.Equal(t, int64(0), firingGauge.Get())
+	fail = false
+	evaluate(time.Second)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, Notification{
+		Alert:     "indexer_failover",
+		Status:    StatusFiring,
+		Value:     1,
+		Threshold: 0,
+		Since:     since,
+		Timestamp: now,
+	}, notifications[0])
+	assert.Equal(t, int64(1), firingGauge.Get())
+
+	// Firing alerts are not notified again while the condition holds.
+	evaluate(time.Minute)
+	assert.Len(t, notifications, 1)
+
+	active.Set(0)
+	evaluate(time.Second)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, StatusResolved, notifications[1].Status)
+	assert.Equal(t, since, notifications[1].Since)
+	assert.Equal(t, int64(0), firingGauge.Get())
+
+	evaluate(time.Second)
+	assert.Len(t, notifications, 2)
+}
+
+func TestConditions(t *testing.T) {
+	snapshot := func(ints map[string]int64) monitoring.FlatSnapshot {
+		return monitoring.FlatSnapshot{Ints: ints}
+	}
+
+	_, ok := IndexerFailover().Value(snapshot(nil), snapshot(nil))
+	assert.False(t, ok)
+
+	failureRatio := IndexingFailureRatio(0.1)
+	previous := snapshot(map[string]int64{"libbeat.output.events.failed": 10, "libbeat.output.events.total": 100})
+	current := snapshot(map[string]int64{"libbeat.output.events.failed": 30, "libbeat.output.events.total": 180})
+	value, ok := failureRatio.Value(current, previous)
+	assert.True(t, ok)
+	assert.Equal(t, 0.25, value)
+	_, ok = failureRatio.Value(current, snapshot(nil))
+	assert.False(t, ok)
+	_, ok = failureRatio.Value(current, current)
+	assert.False(t, ok)
+
+	storageRatio := TailSamplingStorageRatio(0.9, 1000)
+	value, ok = storageRatio.Value(snapshot(map[string]int64{
+		"apm-server.sampling.tail.storage.lsm_size":       200,
+		"apm-server.sampling.tail.storage.value_log_size": 750,
+	}), snapshot(nil))
+	assert.True(t, ok)
+	assert.Equal(t, 0.95, value)
+	_, ok = storageRatio.Value(snapshot(nil), snapshot(nil))
+	assert.False(t, ok)
+}
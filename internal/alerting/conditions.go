@@ -0,0 +1,84 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package alerting
+
+import "github.com/elastic/elastic-agent-libs/monitoring"
+
+// IndexerFailover returns a Condition which holds while the Elasticsearch
+// indexer has failed over to the standby cluster, i.e. while the circuit to
+// the primary cluster is open.
+func IndexerFailover() Condition {
+	return Condition{
+		Name: "indexer_failover",
+		Value: func(current, _ monitoring.FlatSnapshot) (float64, bool) {
+			active, ok := current.Ints["output.elasticsearch.failover.active"]
+			return float64(active), ok
+		},
+	}
+}
+
+// IndexingFailureRatio returns a Condition which holds while the ratio of
+// events that failed to be indexed, to all events added to the indexer since
+// the previous evaluation, is above threshold.
+func IndexingFailureRatio(threshold float64) Condition {
+	return Condition{
+		Name:      "indexing_failure_ratio",
+		Threshold: threshold,
+		Value: func(current, previous monitoring.FlatSnapshot) (float64, bool) {
+			failed, total, ok := counterDeltas(current, previous,
+				"libbeat.output.events.failed", "libbeat.output.events.total",
+			)
+			if !ok || total <= 0 {
+				return 0, false
+			}
+			return float64(failed) / float64(total), true
+		},
+	}
+}
+
+// TailSamplingStorageRatio returns a Condition which holds while the ratio of
+// tail-based sampling local storage in use, to the storage limit, is above
+// threshold.
+func TailSamplingStorageRatio(threshold float64, limit uint64) Condition {
+	return Condition{
+		Name:      "tail_sampling_storage_ratio",
+		Threshold: threshold,
+		Value: func(current, _ monitoring.FlatSnapshot) (float64, bool) {
+			lsmSize, ok := current.Ints["apm-server.sampling.tail.storage.lsm_size"]
+			if !ok || limit == 0 {
+				return 0, false
+			}
+			valueLogSize := current.Ints["apm-server.sampling.tail.storage.value_log_size"]
+			return float64(lsmSize+valueLogSize) / float64(limit), true
+		},
+	}
+}
+
+// counterDeltas returns the change in the counters a and b between the
+// previous and current snapshots. If either counter is missing from either
+// snapshot, counterDeltas returns false.
+func counterDeltas(current, previous monitoring.FlatSnapshot, a, b string) (int64, int64, bool) {
+	currentA, okCurrentA := current.Ints[a]
+	currentB, okCurrentB := current.Ints[b]
+	previousA, okPreviousA := previous.Ints[a]
+	previousB, okPreviousB := previous.Ints[b]
+	if !okCurrentA || !okCurrentB || !okPreviousA || !okPreviousB {
+		return 0, 0, false
+	}
+	return currentA - previousA, currentB - previousB, true
+}
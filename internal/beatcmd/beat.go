@@ -47,7 +47,6 @@ import (
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/file"
 	"github.com/elastic/elastic-agent-libs/logp"
-	"github.com/elastic/elastic-agent-libs/logp/configure"
 	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/monitoring/report/buffer"
@@ -91,8 +90,11 @@ type BeatParams struct {
 }
 
 // NewBeat creates a new Beat.
-func NewBeat(args BeatParams) (*Beat, error) {
-	cfg, rawConfig, keystore, err := LoadConfig()
+//
+// Any LoadConfigOptions will be passed to LoadConfig, for example
+// to merge additional configuration.
+func NewBeat(args BeatParams, opts ...LoadConfigOption) (*Beat, error) {
+	cfg, rawConfig, keystore, err := LoadConfig(opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -129,9 +131,10 @@ func NewBeat(args BeatParams) (*Beat, error) {
 	return b, nil
 }
 
-// init initializes logging, config management, GOMAXPROCS, and GC percent.
+// init initializes logging, config management, GOMAXPROCS, GC percent,
+// and the Go runtime memory limit.
 func (b *Beat) init() error {
-	if err := configure.Logging(b.Info.Beat, b.Config.Logging); err != nil {
+	if err := configureLogging(b.Info.Beat, b.Config.Logging); err != nil {
 		return fmt.Errorf("error initializing logging: %w", err)
 	}
 	// log paths values to help with troubleshooting
@@ -159,6 +162,9 @@ func (b *Beat) init() error {
 		logp.Info("Set gc percentage to: %v", gcPercent)
 		debug.SetGCPercent(gcPercent)
 	}
+	if err := adjustMemoryLimit(b.Config, logp.NewLogger("")); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -340,9 +346,13 @@ func (b *Beat) Run(ctx context.Context) error {
 		}
 	}
 
-	g.Go(func() error {
-		return adjustMaxProcs(ctx, 30*time.Second, logger)
-	})
+	if b.Config.MaxProcs <= 0 {
+		// Only adjust GOMAXPROCS to the CPU quota if max_procs
+		// has not been configured explicitly.
+		g.Go(func() error {
+			return adjustMaxProcs(ctx, 30*time.Second, logger)
+		})
+	}
 
 	logSystemInfo(b.Info)
 
@@ -370,6 +380,9 @@ func (b *Beat) Run(ctx context.Context) error {
 		g.Go(func() error { return reloader.Run(ctx) })
 
 		b.Manager.SetStopCallback(cancel)
+		action := logLevelAction{}
+		b.Manager.RegisterAction(action)
+		defer b.Manager.UnregisterAction(action)
 		if err := b.Manager.Start(); err != nil {
 			return fmt.Errorf("failed to start manager: %w", err)
 		}
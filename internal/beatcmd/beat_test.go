@@ -34,6 +34,7 @@ import (
 	"github.com/elastic/beats/v7/libbeat/common/reload"
 	"github.com/elastic/beats/v7/libbeat/feature"
 	"github.com/elastic/beats/v7/libbeat/management"
+	"github.com/elastic/elastic-agent-client/v7/pkg/client"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/monitoring"
@@ -161,6 +162,8 @@ func TestRunManager(t *testing.T) {
 	// the stop callback registered with the manager.
 	expectEvent(t, manager.started, "manager should have been started")
 	expectNoEvent(t, manager.stopped, "manager should not have been stopped")
+	require.Len(t, manager.actions, 1)
+	assert.Equal(t, "set_log_level", manager.actions[0].Name())
 
 	err := reload.RegisterV2.GetInputList().Reload([]*reload.ConfigWithMeta{{
 		Config: config.MustNewConfigFrom(`{
@@ -279,6 +282,7 @@ type mockManager struct {
 	started            chan struct{}
 	stopped            chan struct{}
 	stopCallback       func()
+	actions            []client.Action
 }
 
 func newMockManager() *mockManager {
@@ -305,3 +309,16 @@ func (m *mockManager) Stop() {
 func (m *mockManager) SetStopCallback(f func()) {
 	m.stopCallback = f
 }
+
+func (m *mockManager) RegisterAction(action client.Action) {
+	m.actions = append(m.actions, action)
+}
+
+func (m *mockManager) UnregisterAction(action client.Action) {
+	for i, registered := range m.actions {
+		if registered.Name() == action.Name() {
+			m.actions = append(m.actions[:i], m.actions[i+1:]...)
+			break
+		}
+	}
+}
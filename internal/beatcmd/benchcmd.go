@@ -0,0 +1,361 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+	"github.com/elastic/apm-server/internal/version"
+)
+
+// benchEvents holds the default intake payload sent by "apm-server bench".
+//
+//go:embed bench_events.ndjson
+var benchEvents []byte
+
+type benchOptions struct {
+	mockElasticsearch bool
+	duration          time.Duration
+	reportInterval    time.Duration
+	agents            int
+	payloadFile       string
+}
+
+func genBenchCmd(beatParams BeatParams) *cobra.Command {
+	var opts benchOptions
+	benchCmd := &cobra.Command{
+		Use:   "bench",
+		Short: "Benchmark the ingestion pipeline",
+		Long: `Benchmark the ingestion pipeline.
+
+APM Server is started in-process using the current configuration, and
+simulated agents repeatedly send an intake payload to it for the given
+duration. Throughput, allocation rates, heap usage, and goroutine counts
+are reported periodically, and summarised at the end, for benchmarking
+and soak testing.
+
+With --mock-es, events are indexed into an in-process mock Elasticsearch,
+which accepts all documents; otherwise the configured output is used.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBench(cmd.Context(), beatParams, opts, cmd.OutOrStdout())
+		},
+	}
+	flags := benchCmd.Flags()
+	flags.BoolVar(&opts.mockElasticsearch, "mock-es", false, "Index events into an in-process mock Elasticsearch")
+	flags.DurationVar(&opts.duration, "duration", time.Minute, "Duration of the benchmark")
+	flags.DurationVar(&opts.reportInterval, "report-interval", 10*time.Second, "Interval at which to report statistics")
+	flags.IntVar(&opts.agents, "agents", runtime.GOMAXPROCS(0), "Number of simulated agents sending events concurrently")
+	flags.StringVar(&opts.payloadFile, "payload", "", "Path to an ndjson intake payload to send, instead of the built-in payload")
+	return benchCmd
+}
+
+func runBench(ctx context.Context, beatParams BeatParams, opts benchOptions, w io.Writer) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if opts.agents <= 0 {
+		return errors.New("--agents must be greater than zero")
+	}
+	if opts.reportInterval <= 0 {
+		return errors.New("--report-interval must be greater than zero")
+	}
+	payload := benchEvents
+	if opts.payloadFile != "" {
+		var err error
+		if payload, err = os.ReadFile(opts.payloadFile); err != nil {
+			return fmt.Errorf("failed to read payload: %w", err)
+		}
+	}
+	eventsPerRequest := int64(bytes.Count(bytes.TrimSpace(payload), []byte("\n")))
+
+	listenAddr, err := benchListenAddr()
+	if err != nil {
+		return err
+	}
+	overrides := map[string]interface{}{
+		"apm-server.host": listenAddr,
+		"apm-server.data_streams.wait_for_integration": false,
+	}
+	var mockES *benchMockElasticsearch
+	if opts.mockElasticsearch {
+		mockES = newBenchMockElasticsearch()
+		defer mockES.Close()
+		overrides["output.elasticsearch.hosts"] = []string{mockES.URL}
+	}
+	beat, err := NewBeat(beatParams, WithMergeConfig(config.MustNewConfigFrom(overrides)))
+	if err != nil {
+		return err
+	}
+	runner, err := beat.newRunner(RunnerParams{
+		Config: beat.rawConfig,
+		Info:   beat.Info,
+		Logger: logp.NewLogger(""),
+	})
+	if err != nil {
+		return err
+	}
+
+	runnerCtx, cancelRunner := context.WithCancel(ctx)
+	defer cancelRunner()
+	runnerDone := make(chan error, 1)
+	go func() { runnerDone <- runner.Run(runnerCtx) }()
+
+	serverURL := "http://" + listenAddr
+	if err := benchWaitReady(ctx, serverURL, runnerDone); err != nil {
+		return err
+	}
+
+	var stats benchStats
+	loadCtx, cancelLoad := context.WithTimeout(ctx, opts.duration)
+	defer cancelLoad()
+	var g errgroup.Group
+	client := &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: opts.agents}}
+	for i := 0; i < opts.agents; i++ {
+		g.Go(func() error {
+			benchAgent(loadCtx, client, serverURL+"/intake/v2/events", payload, eventsPerRequest, &stats)
+			return nil
+		})
+	}
+
+	reporter := newBenchReporter(w, mockES, &stats)
+	ticker := time.NewTicker(opts.reportInterval)
+	defer ticker.Stop()
+	for done := false; !done; {
+		select {
+		case <-loadCtx.Done():
+			done = true
+		case <-ticker.C:
+			reporter.report()
+		}
+	}
+	g.Wait()
+	elapsed := time.Since(reporter.start)
+
+	// Stop the server before summarising, so that all events
+	// are flushed to the output.
+	cancelRunner()
+	if err := <-runnerDone; err != nil && !errors.Is(err, context.Canceled) {
+		return err
+	}
+	reporter.summarise(elapsed)
+	return nil
+}
+
+// benchListenAddr returns an available address on the loopback interface.
+func benchListenAddr() (string, error) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return "", err
+	}
+	defer lis.Close()
+	return lis.Addr().String(), nil
+}
+
+// benchWaitReady waits for the server to accept requests.
+func benchWaitReady(ctx context.Context, serverURL string, runnerDone <-chan error) error {
+	timeout := time.After(30 * time.Second)
+	for {
+		resp, err := http.Get(serverURL)
+		if err == nil {
+			resp.Body.Close()
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-runnerDone:
+			return fmt.Errorf("server exited before becoming ready: %w", err)
+		case <-timeout:
+			return errors.New("timed out waiting for server to become ready")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// benchAgent sends payload to the intake URL until ctx is cancelled.
+func benchAgent(ctx context.Context, client *http.Client, url string, payload []byte, events int64, stats *benchStats) {
+	for ctx.Err() == nil {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+		if err != nil {
+			atomic.AddInt64(&stats.errors, 1)
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-ndjson")
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() == nil {
+				atomic.AddInt64(&stats.errors, 1)
+			}
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		atomic.AddInt64(&stats.requests, 1)
+		if resp.StatusCode == http.StatusAccepted {
+			atomic.AddInt64(&stats.events, events)
+		} else {
+			atomic.AddInt64(&stats.errors, 1)
+		}
+	}
+}
+
+type benchStats struct {
+	requests int64
+	events   int64
+	errors   int64
+}
+
+// benchReporter reports throughput and runtime statistics.
+type benchReporter struct {
+	w      io.Writer
+	mockES *benchMockElasticsearch
+	stats  *benchStats
+
+	start      time.Time
+	startMem   runtime.MemStats
+	startGs    int
+	maxHeap    uint64
+	maxGs      int
+	last       time.Time
+	lastEvents int64
+	lastDocs   int64
+	lastAlloc  uint64
+}
+
+func newBenchReporter(w io.Writer, mockES *benchMockElasticsearch, stats *benchStats) *benchReporter {
+	r := &benchReporter{w: w, mockES: mockES, stats: stats}
+	r.start = time.Now()
+	r.last = r.start
+	runtime.ReadMemStats(&r.startMem)
+	r.startGs = runtime.NumGoroutine()
+	r.maxHeap, r.maxGs = r.startMem.HeapInuse, r.startGs
+	r.lastAlloc = r.startMem.TotalAlloc
+	return r
+}
+
+func (r *benchReporter) docs() int64 {
+	if r.mockES == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&r.mockES.docs)
+}
+
+func (r *benchReporter) report() {
+	now := time.Now()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	goroutines := runtime.NumGoroutine()
+	if mem.HeapInuse > r.maxHeap {
+		r.maxHeap = mem.HeapInuse
+	}
+	if goroutines > r.maxGs {
+		r.maxGs = goroutines
+	}
+
+	seconds := now.Sub(r.last).Seconds()
+	events := atomic.LoadInt64(&r.stats.events)
+	docs := r.docs()
+	fmt.Fprintf(r.w,
+		"elapsed=%s events/s=%.0f indexed/s=%.0f alloc/s=%s heap=%s goroutines=%d\n",
+		now.Sub(r.start).Round(time.Second),
+		float64(events-r.lastEvents)/seconds,
+		float64(docs-r.lastDocs)/seconds,
+		humanize.IBytes(uint64(float64(mem.TotalAlloc-r.lastAlloc)/seconds)),
+		humanize.IBytes(mem.HeapInuse),
+		goroutines,
+	)
+	r.last, r.lastEvents, r.lastDocs, r.lastAlloc = now, events, docs, mem.TotalAlloc
+}
+
+// summarise reports statistics for the whole benchmark, which ran for
+// the given duration.
+func (r *benchReporter) summarise(elapsed time.Duration) {
+	seconds := elapsed.Seconds()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	goroutines := runtime.NumGoroutine()
+	events := atomic.LoadInt64(&r.stats.events)
+	docs := r.docs()
+
+	fmt.Fprintln(r.w)
+	fmt.Fprintf(r.w, "duration:       %s\n", elapsed.Round(time.Millisecond))
+	fmt.Fprintf(r.w, "requests:       %d (%d errors)\n", atomic.LoadInt64(&r.stats.requests), atomic.LoadInt64(&r.stats.errors))
+	fmt.Fprintf(r.w, "events:         %d (%.0f/s)\n", events, float64(events)/seconds)
+	if r.mockES != nil {
+		fmt.Fprintf(r.w, "indexed:        %d (%.0f/s)\n", docs, float64(docs)/seconds)
+	}
+	fmt.Fprintf(r.w, "allocated:      %s (%s/s, %d objects/s)\n",
+		humanize.IBytes(mem.TotalAlloc-r.startMem.TotalAlloc),
+		humanize.IBytes(uint64(float64(mem.TotalAlloc-r.startMem.TotalAlloc)/seconds)),
+		uint64(float64(mem.Mallocs-r.startMem.Mallocs)/seconds),
+	)
+	fmt.Fprintf(r.w, "heap in use:    start=%s end=%s max=%s\n",
+		humanize.IBytes(r.startMem.HeapInuse), humanize.IBytes(mem.HeapInuse), humanize.IBytes(r.maxHeap),
+	)
+	fmt.Fprintf(r.w, "goroutines:     start=%d end=%d max=%d\n", r.startGs, goroutines, r.maxGs)
+	fmt.Fprintf(r.w, "gc cycles:      %d\n", mem.NumGC-r.startMem.NumGC)
+}
+
+// benchMockElasticsearch is an in-process mock Elasticsearch which accepts
+// all bulk requests, counting the documents indexed.
+type benchMockElasticsearch struct {
+	*httptest.Server
+	docs int64
+}
+
+func newBenchMockElasticsearch() *benchMockElasticsearch {
+	es := &benchMockElasticsearch{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"cluster_uuid": "apm-server-bench",
+			"version":      map[string]interface{}{"number": version.Version},
+		})
+	})
+	modelindexertest.HandleBulk(mux, func(w http.ResponseWriter, r *http.Request) {
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		atomic.AddInt64(&es.docs, int64(len(docs)))
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	es.Server = httptest.NewServer(mux)
+	return es
+}
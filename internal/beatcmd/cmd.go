@@ -76,6 +76,8 @@ func NewRootCommand(beatParams BeatParams) *cobra.Command {
 	rootCommand.AddCommand(versionCommand)
 	rootCommand.AddCommand(genTestCmd(beatParams))
 	rootCommand.AddCommand(genApikeyCmd())
+	rootCommand.AddCommand(genBenchCmd(beatParams))
+	rootCommand.AddCommand(genReplayCmd())
 
 	return rootCommand
 }
@@ -35,8 +35,9 @@ type Config struct {
 	// APMServer holds apm-server.* configuration.
 	APMServer *config.C `config:"apm-server"`
 
-	MaxProcs  int `config:"max_procs"`
-	GCPercent int `config:"gc_percent"`
+	MaxProcs    int    `config:"max_procs"`
+	GCPercent   int    `config:"gc_percent"`
+	MemoryLimit string `config:"memory_limit"`
 
 	HTTP          *config.C     `config:"http"`
 	HTTPPprof     *pprof.Config `config:"http.pprof"`
@@ -0,0 +1,119 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"strings"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/logp/configure"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// configureLogging configures logging, routing all log entries through
+// logs.Levels so the log level and debug selectors may be changed at
+// runtime without restarting the server.
+//
+// logp applies the level and debug selectors when loggers are created, so
+// logp is first configured to write all entries to the configured output,
+// which is then wrapped with logs.Levels and installed as the only output.
+func configureLogging(beatName string, cfg *config.C) error {
+	loggingConfig := logp.DefaultConfig(logp.DefaultEnvironment)
+	raw := make(map[string]interface{})
+	if cfg != nil {
+		if err := cfg.Unpack(&loggingConfig); err != nil {
+			return err
+		}
+		if err := cfg.Unpack(&raw); err != nil {
+			return err
+		}
+	}
+	level, selectors := loggingFlags(loggingConfig.Level, loggingConfig.Selectors)
+
+	raw["level"] = "debug"
+	raw["selectors"] = []string{"*"}
+	unfilteredConfig, err := config.NewConfigFrom(raw)
+	if err != nil {
+		return err
+	}
+	if err := configure.Logging(beatName, unfilteredConfig); err != nil {
+		return err
+	}
+	output := logp.L().Core()
+
+	// The unfiltered output is installed in place of logp's own output,
+	// which discards entries. Only errors reach the discarding output,
+	// to avoid needlessly encoding entries.
+	rootConfig := logp.DefaultConfig(logp.DefaultEnvironment)
+	rootConfig.Beat = beatName
+	rootConfig.Level = logp.ErrorLevel
+	logp.ToDiscardOutput()(&rootConfig)
+	logs.Levels.SetDefaults(level, selectors)
+	return logp.ConfigureWithOutputs(rootConfig, logs.Levels.Core(output))
+}
+
+// loggingFlags returns the log level and debug selectors after applying
+// the -v and -d command line flags, as done by configure.Logging.
+func loggingFlags(level logp.Level, selectors []string) (logp.Level, []string) {
+	if f := flag.Lookup("v"); f != nil && f.Value.String() == "true" && level > logp.InfoLevel {
+		level = logp.InfoLevel
+	}
+	if f := flag.Lookup("d"); f != nil {
+		if getter, ok := f.Value.(flag.Getter); ok {
+			if debugSelectors, ok := getter.Get().([]string); ok && len(debugSelectors) > 0 {
+				for _, s := range debugSelectors {
+					selectors = append(selectors, strings.Split(s, ",")...)
+				}
+				level = logp.DebugLevel
+			}
+		}
+	}
+	return level, selectors
+}
+
+// logLevelAction is a Fleet action for changing the log level and debug
+// selectors at runtime. The action parameters are those of logs.LevelRequest.
+type logLevelAction struct{}
+
+func (logLevelAction) Name() string {
+	return "set_log_level"
+}
+
+func (logLevelAction) Execute(ctx context.Context, params map[string]interface{}) (map[string]interface{}, error) {
+	var req logs.LevelRequest
+	data, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, err
+	}
+	if err := logs.Levels.Apply(req); err != nil {
+		return nil, err
+	}
+	settings := logs.Levels.Settings()
+	return map[string]interface{}{
+		"level":     settings.Level,
+		"selectors": settings.Selectors,
+	}, nil
+}
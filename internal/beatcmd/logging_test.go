@@ -0,0 +1,99 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.MustNewConfigFrom(map[string]interface{}{
+		"level":           "info",
+		"to_files":        true,
+		"files.path":      dir,
+		"files.name":      "apm-server",
+		"metrics.enabled": false,
+	})
+	require.NoError(t, configureLogging("apm-server", cfg))
+	defer logs.Levels.SetDefaults(logp.InfoLevel, nil)
+	assert.Equal(t, logs.LevelSettings{Level: "info", Selectors: []string{}}, logs.Levels.Settings())
+
+	// Loggers are created before changing the settings,
+	// to show that existing loggers are affected.
+	request := logp.NewLogger("request")
+	indexer := logp.NewLogger("beater").Named("modelindexer")
+	other := logp.NewLogger("other")
+
+	request.Debug("request_debug_1")
+	other.Info("other_info_1")
+
+	logs.Levels.Set(logp.DebugLevel, []string{"request", "beater"}, 0)
+	request.Debug("request_debug_2")
+	indexer.Debug("indexer_debug_2")
+	other.Debug("other_debug_2")
+
+	logs.Levels.Set(logp.WarnLevel, nil, 0)
+	other.Info("other_info_3")
+	other.Warn("other_warn_3")
+	require.NoError(t, logp.Sync())
+
+	matches, err := filepath.Glob(filepath.Join(dir, "apm-server*"))
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	content, err := os.ReadFile(matches[0])
+	require.NoError(t, err)
+	for _, expected := range []string{"other_info_1", "request_debug_2", "indexer_debug_2", "other_warn_3"} {
+		assert.Contains(t, string(content), expected)
+	}
+	for _, unexpected := range []string{"request_debug_1", "other_debug_2", "other_info_3"} {
+		assert.NotContains(t, string(content), unexpected)
+	}
+}
+
+func TestLogLevelAction(t *testing.T) {
+	defer logs.Levels.SetDefaults(logp.InfoLevel, nil)
+	logs.Levels.SetDefaults(logp.InfoLevel, nil)
+
+	action := logLevelAction{}
+	result, err := action.Execute(context.Background(), map[string]interface{}{
+		"level":     "debug",
+		"selectors": []interface{}{"request"},
+		"duration":  "10m",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"level": "debug", "selectors": []string{"request"}}, result)
+
+	_, err = action.Execute(context.Background(), map[string]interface{}{"level": "verbose"})
+	assert.Error(t, err)
+
+	result, err = action.Execute(context.Background(), map[string]interface{}{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"level": "info", "selectors": []string{}}, result)
+}
@@ -0,0 +1,146 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/memlimit"
+)
+
+const (
+	// memoryLimitRatio is the fraction of the container memory limit
+	// used as the Go runtime soft memory limit when memory_limit is not
+	// configured, leaving headroom for non-heap memory.
+	memoryLimitRatio = 0.9
+
+	// memoryLimitGCPercent is the GC percent used when the soft memory
+	// limit is set automatically and neither gc_percent nor GOGC is set.
+	// With a memory limit in place, the GC will run more frequently as
+	// the heap approaches the limit, so we can afford to collect less
+	// often while the heap is small.
+	memoryLimitGCPercent = 200
+)
+
+var (
+	// effectiveGCPercent and effectiveMemoryLimit hold the GOGC and
+	// GOMEMLIMIT values applied at startup, for monitoring.
+	effectiveGCPercent   int64 = 100
+	effectiveMemoryLimit int64
+)
+
+func init() {
+	monitoring.NewFunc(monitoring.Default, "apm-server.runtime", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		monitoring.ReportInt(v, "gomaxprocs", int64(runtime.GOMAXPROCS(0)))
+		monitoring.ReportInt(v, "gogc", atomic.LoadInt64(&effectiveGCPercent))
+		monitoring.ReportInt(v, "gomemlimit", atomic.LoadInt64(&effectiveMemoryLimit))
+	}, monitoring.Report)
+}
+
+// adjustMemoryLimit sets the Go runtime soft memory limit, and the GC
+// percent if the memory limit is set automatically.
+//
+// If memory_limit is configured it is used as-is. Otherwise, if the
+// GOMEMLIMIT environment variable is not set and the process is running
+// within a cgroup with a memory limit, the soft memory limit is set to
+// 90% of the cgroup limit. An explicit gc_percent or GOGC takes precedence
+// over the automatically chosen GC percent.
+func adjustMemoryLimit(cfg *Config, logger *logp.Logger) error {
+	if gogc, ok := os.LookupEnv("GOGC"); ok {
+		if gogc == "off" {
+			atomic.StoreInt64(&effectiveGCPercent, -1)
+		} else if n, err := strconv.Atoi(gogc); err == nil {
+			atomic.StoreInt64(&effectiveGCPercent, int64(n))
+		}
+	}
+	if cfg.GCPercent > 0 {
+		atomic.StoreInt64(&effectiveGCPercent, int64(cfg.GCPercent))
+	}
+
+	var cgroupLimit, systemMemory uint64
+	if cfg.MemoryLimit == "" {
+		if _, ok := os.LookupEnv("GOMEMLIMIT"); ok {
+			atomic.StoreInt64(&effectiveMemoryLimit, currentMemoryLimit())
+			return nil
+		}
+		if rdr := memlimit.NewCgroupReader(); rdr != nil {
+			limit, err := memlimit.CgroupMemoryLimit(rdr)
+			if err != nil {
+				logger.Debugf("not setting memory limit: %s", err)
+				return nil
+			}
+			cgroupLimit = limit
+		}
+		if limit, err := memlimit.SystemMemoryLimit(); err == nil {
+			systemMemory = limit
+		}
+	}
+	limit, auto, err := memoryLimit(cfg.MemoryLimit, cgroupLimit, systemMemory)
+	if err != nil || limit <= 0 {
+		return err
+	}
+	if !setMemoryLimit(limit) {
+		logger.Warnf("memory_limit is not supported by this Go runtime, ignoring")
+		return nil
+	}
+	logger.Infof("Set memory limit to: %s", humanize.IBytes(uint64(limit)))
+	atomic.StoreInt64(&effectiveMemoryLimit, limit)
+
+	if _, ok := os.LookupEnv("GOGC"); auto && !ok && cfg.GCPercent <= 0 {
+		logger.Infof("Set gc percentage to: %v", memoryLimitGCPercent)
+		debug.SetGCPercent(memoryLimitGCPercent)
+		atomic.StoreInt64(&effectiveGCPercent, memoryLimitGCPercent)
+	}
+	return nil
+}
+
+// memoryLimit returns the soft memory limit to apply, and whether it was
+// derived automatically from the cgroup memory limit. A zero limit means
+// no limit should be applied.
+//
+// cgroupLimit is ignored if it is zero or not less than systemMemory, as
+// is the case for cgroup v1 hierarchies without a memory limit.
+func memoryLimit(configured string, cgroupLimit, systemMemory uint64) (int64, bool, error) {
+	if configured != "" {
+		n, err := humanize.ParseBytes(configured)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid memory_limit: %w", err)
+		}
+		if n > math.MaxInt64 {
+			return 0, false, fmt.Errorf("invalid memory_limit: %q is too large", configured)
+		}
+		return int64(n), false, nil
+	}
+	if cgroupLimit == 0 || (systemMemory > 0 && cgroupLimit >= systemMemory) {
+		return 0, false, nil
+	}
+	return int64(float64(cgroupLimit) * memoryLimitRatio), true, nil
+}
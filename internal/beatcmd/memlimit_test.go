@@ -0,0 +1,52 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryLimit(t *testing.T) {
+	for name, test := range map[string]struct {
+		configured   string
+		cgroupLimit  uint64
+		systemMemory uint64
+		expected     int64
+		expectedAuto bool
+	}{
+		"no_limit":             {systemMemory: 8 << 30},
+		"cgroup":               {cgroupLimit: 1 << 30, systemMemory: 8 << 30, expected: 966367641, expectedAuto: true},
+		"cgroup_unlimited":     {cgroupLimit: 9223372036854771712, systemMemory: 8 << 30},
+		"cgroup_no_system":     {cgroupLimit: 1000, expected: 900, expectedAuto: true},
+		"configured":           {configured: "512MiB", cgroupLimit: 1 << 30, expected: 512 << 20},
+		"configured_no_cgroup": {configured: "1gb", expected: 1000000000},
+	} {
+		t.Run(name, func(t *testing.T) {
+			limit, auto, err := memoryLimit(test.configured, test.cgroupLimit, test.systemMemory)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, limit)
+			assert.Equal(t, test.expectedAuto, auto)
+		})
+	}
+
+	_, _, err := memoryLimit("lots", 0, 0)
+	assert.EqualError(t, err, `invalid memory_limit: strconv.ParseFloat: parsing "": invalid syntax`)
+}
@@ -0,0 +1,270 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+	"github.com/tidwall/gjson"
+	"golang.org/x/time/rate"
+
+	es "github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+type replayOptions struct {
+	rate  float64
+	burst int
+}
+
+func genReplayCmd() *cobra.Command {
+	var opts replayOptions
+	replayCmd := &cobra.Command{
+		Use:   "replay [flags] FILE...",
+		Short: "Replay captured documents into Elasticsearch",
+		Long: `Replay captured documents into Elasticsearch.
+
+Each file is read as NDJSON, and its documents are indexed using the
+configured Elasticsearch output. Use "-" to read from standard input.
+Files may hold Elasticsearch bulk request bodies, such as those written
+by the file and console outputs, in which each action line is followed
+by its document; or dead letter documents exported from the dead letter
+index, in which case each document's event.original is indexed into its
+dead_letter.index.
+
+Use --rate to limit the number of documents indexed per second, e.g. to
+avoid overloading Elasticsearch while recovering from an outage.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer cancel()
+			return runReplay(ctx, opts, args, cmd.OutOrStdout())
+		},
+	}
+	flags := replayCmd.Flags()
+	flags.Float64Var(&opts.rate, "rate", 0, "Maximum number of documents indexed per second; zero means no limit")
+	flags.IntVar(&opts.burst, "burst", 0, "Number of documents that may be indexed at once when limited by --rate; defaults to --rate")
+	return replayCmd
+}
+
+func runReplay(ctx context.Context, opts replayOptions, files []string, w io.Writer) error {
+	if opts.rate < 0 {
+		return errors.New("--rate must not be negative")
+	}
+	limiter := rate.NewLimiter(rate.Inf, 0)
+	if opts.rate > 0 {
+		burst := opts.burst
+		if burst <= 0 {
+			if burst = int(opts.rate); burst < 1 {
+				burst = 1
+			}
+		}
+		limiter = rate.NewLimiter(rate.Limit(opts.rate), burst)
+	}
+
+	indexer, err := newReplayIndexer()
+	if err != nil {
+		return err
+	}
+	start := time.Now()
+	var read int64
+	replayErr := func() error {
+		for _, file := range files {
+			if err := replayFile(file, func(index string, doc []byte) error {
+				if err := limiter.Wait(ctx); err != nil {
+					return err
+				}
+				if err := indexer.IndexDocument(ctx, index, doc); err != nil {
+					return err
+				}
+				read++
+				return nil
+			}); err != nil {
+				return fmt.Errorf("failed to replay %s: %w", file, err)
+			}
+		}
+		return nil
+	}()
+
+	// Flush enqueued documents, even if replaying was interrupted.
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	closeErr := indexer.Close(closeCtx)
+	stats := indexer.Stats()
+	fmt.Fprintf(w, "duration:       %s\n", time.Since(start).Round(time.Millisecond))
+	fmt.Fprintf(w, "documents:      %d\n", read)
+	fmt.Fprintf(w, "indexed:        %d\n", stats.Indexed)
+	fmt.Fprintf(w, "failed:         %d\n", stats.Failed)
+	if replayErr != nil {
+		return replayErr
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to flush documents: %w", closeErr)
+	}
+	if stats.Failed > 0 {
+		return fmt.Errorf("%d documents failed to be indexed", stats.Failed)
+	}
+	return nil
+}
+
+// newReplayIndexer returns a modelindexer.Indexer for the configured
+// Elasticsearch output.
+func newReplayIndexer() (*modelindexer.Indexer, error) {
+	cfg, _, _, err := LoadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Output.Name() != "elasticsearch" {
+		return nil, fmt.Errorf("replay requires the elasticsearch output, got %q", cfg.Output.Name())
+	}
+	var esConfig struct {
+		*es.Config    `config:",inline"`
+		FlushBytes    string        `config:"flush_bytes"`
+		FlushInterval time.Duration `config:"flush_interval"`
+		MaxRequests   int           `config:"max_requests"`
+		Compression   string        `config:"compression"`
+	}
+	esConfig.FlushInterval = time.Second
+	esConfig.Config = es.DefaultConfig()
+	if err := cfg.Output.Config().Unpack(&esConfig); err != nil {
+		return nil, err
+	}
+	var flushBytes int
+	if esConfig.FlushBytes != "" {
+		b, err := humanize.ParseBytes(esConfig.FlushBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse flush_bytes: %w", err)
+		}
+		flushBytes = int(b)
+	}
+	client, err := es.NewClient(esConfig.Config)
+	if err != nil {
+		return nil, err
+	}
+	return modelindexer.New(client, modelindexer.Config{
+		FlushBytes:    flushBytes,
+		FlushInterval: esConfig.FlushInterval,
+		MaxRequests:   esConfig.MaxRequests,
+		Compression:   esConfig.Compression,
+	})
+}
+
+// replayFile reads documents from the named file, or standard input if
+// name is "-", calling index for each one.
+func replayFile(name string, index func(index string, doc []byte) error) error {
+	if name == "-" {
+		return readReplayDocuments(os.Stdin, index)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readReplayDocuments(f, index)
+}
+
+// readReplayDocuments reads NDJSON from r, calling index with each document
+// and the index into which it should be indexed. Documents are either
+// preceded by a bulk action line, or are dead letter documents.
+func readReplayDocuments(r io.Reader, index func(index string, doc []byte) error) error {
+	br := bufio.NewReader(r)
+	var lineno int
+	readLine := func() ([]byte, error) {
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(line) == 0 && err != nil {
+				return nil, err
+			}
+			lineno++
+			if line = bytes.TrimSpace(line); len(line) > 0 {
+				return line, nil
+			}
+		}
+	}
+	for {
+		line, err := readLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if !gjson.ValidBytes(line) {
+			return fmt.Errorf("line %d: invalid JSON", lineno)
+		}
+		if action, ok := bulkAction(line); ok {
+			if action.index == "" {
+				return fmt.Errorf("line %d: %s action has no _index", lineno, action.name)
+			}
+			doc, err := readLine()
+			if err == io.EOF {
+				return fmt.Errorf("line %d: %s action has no document", lineno, action.name)
+			} else if err != nil {
+				return err
+			}
+			if err := index(action.index, doc); err != nil {
+				return err
+			}
+			continue
+		}
+		original := gjson.GetBytes(line, "event.original")
+		target := gjson.GetBytes(line, "dead_letter.index")
+		if original.Type != gjson.String || target.Type != gjson.String {
+			return fmt.Errorf("line %d: expected a bulk action or dead letter document", lineno)
+		}
+		if err := index(target.String(), []byte(original.String())); err != nil {
+			return err
+		}
+	}
+}
+
+type replayBulkAction struct {
+	name  string
+	index string
+}
+
+// bulkAction returns the bulk action described by line, if line is a
+// "create" or "index" bulk action line.
+func bulkAction(line []byte) (replayBulkAction, bool) {
+	var action replayBulkAction
+	object := gjson.ParseBytes(line)
+	if !object.IsObject() {
+		return action, false
+	}
+	var n int
+	object.ForEach(func(key, value gjson.Result) bool {
+		n++
+		action.name = key.String()
+		action.index = value.Get("_index").String()
+		return true
+	})
+	if n != 1 || (action.name != "create" && action.name != "index") {
+		return action, false
+	}
+	return action, true
+}
@@ -0,0 +1,70 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatcmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadReplayDocuments(t *testing.T) {
+	input := `{"create":{"_index":"traces-apm-default"}}
+{"transaction":{"id":"abc"}}
+
+{"index":{"_index":"logs-apm.error-default"}}
+{"error":{"id":"def"}}
+{"@timestamp":"2022-01-01T00:00:00Z","event":{"original":"{\"message\":\"rejected\"}"},"dead_letter":{"index":"logs-apm.app-default","status":400}}
+`
+	type document struct {
+		index string
+		doc   string
+	}
+	var docs []document
+	err := readReplayDocuments(strings.NewReader(input), func(index string, doc []byte) error {
+		docs = append(docs, document{index: index, doc: string(doc)})
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []document{
+		{index: "traces-apm-default", doc: `{"transaction":{"id":"abc"}}`},
+		{index: "logs-apm.error-default", doc: `{"error":{"id":"def"}}`},
+		{index: "logs-apm.app-default", doc: `{"message":"rejected"}`},
+	}, docs)
+}
+
+func TestReadReplayDocumentsInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		input string
+		err   string
+	}{
+		"invalid_json":   {input: "{\n", err: "line 1: invalid JSON"},
+		"missing_index":  {input: `{"create":{}}` + "\n{}\n", err: "line 1: create action has no _index"},
+		"missing_doc":    {input: `{"create":{"_index":"logs-apm.app-default"}}` + "\n", err: "line 1: create action has no document"},
+		"unknown_object": {input: "\n" + `{"message":"hello"}`, err: "line 2: expected a bulk action or dead letter document"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := readReplayDocuments(strings.NewReader(test.input), func(string, []byte) error {
+				return nil
+			})
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
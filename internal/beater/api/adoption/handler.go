@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package adoption
+
+import (
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters
+	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
+	registry      = monitoring.Default.NewRegistry("apm-server.adoption")
+)
+
+type result struct {
+	Services   []agentcfg.AdoptionEntry `json:"services"`
+	Overflowed int64                    `json:"overflowed"`
+}
+
+// Handler returns a request.Handler that reports the adoption of agent
+// configuration by the agents of each service.
+func Handler(adoption *agentcfg.Adoption) request.Handler {
+	return func(c *request.Context) {
+		services, overflowed := adoption.Services()
+		c.Result.SetDefault(request.IDResponseValidOK)
+		c.Result.Body = result{Services: services, Overflowed: overflowed}
+		c.WriteResult()
+	}
+}
@@ -19,22 +19,29 @@ package intake
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go.elastic.co/apm/v2"
+	"go.elastic.co/fastjson"
 
 	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/middleware"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/processor/stream"
 	"github.com/elastic/apm-server/internal/publish"
 )
@@ -48,11 +55,40 @@ var (
 	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
 	registry      = monitoring.Default.NewRegistry("apm-server.server")
 
+	// AgentMonitoring holds intake request metrics dimensioned by agent name and version.
+	AgentMonitoring = middleware.NewAgentMonitoring()
+
+	// ValidationFailures holds counts of events failing validation,
+	// dimensioned by field and agent name and version.
+	ValidationFailures = stream.NewValidationFailures()
+
+	// Coerced holds the number of values coerced when decoding
+	// events leniently.
+	Coerced = monitoring.NewInt(registry, "decoding.coerced")
+
+	// MetadataCacheHits and MetadataCacheMisses hold the number of RUM
+	// metadata objects found and not found in the metadata cache.
+	MetadataCacheHits   = monitoring.NewInt(registry, "decoding.metadata_cache.hits")
+	MetadataCacheMisses = monitoring.NewInt(registry, "decoding.metadata_cache.misses")
+
+	serviceBytesMonitoring = newServiceMonitoring()
+
 	errMethodNotAllowed   = errors.New("only POST requests are supported")
 	errServerShuttingDown = errors.New("server is shutting down")
 	errInvalidContentType = errors.New("invalid content type")
+	errInvalidDeadline    = errors.New("invalid deadline")
+	errDeadlineExceeded   = errors.New("processing deadline exceeded")
+	errInvalidDryRun      = errors.New("invalid dry_run value")
+	errDryRunUnsupported  = errors.New("dry run is not supported by this endpoint")
+	errDryRunForbidden    = errors.New("dry run requires an authenticated client")
 )
 
+func init() {
+	monitoring.NewFunc(registry, "agents", AgentMonitoring.CollectMonitoring, monitoring.Report)
+	monitoring.NewFunc(registry, "services", serviceBytesMonitoring.CollectMonitoring, monitoring.Report)
+	monitoring.NewFunc(registry, "validation_failures", ValidationFailures.CollectMonitoring, monitoring.Report)
+}
+
 // StreamHandler is an interface for handling an Elastic APM agent ND-JSON event
 // stream, implemented by processor/stream.
 type StreamHandler interface {
@@ -73,13 +109,30 @@ type StreamHandler interface {
 type RequestMetadataFunc func(*request.Context) model.APMEvent
 
 // Handler returns a request.Handler for managing intake requests for backend and rum events.
-func Handler(handler StreamHandler, requestMetadataFunc RequestMetadataFunc, batchProcessor model.BatchProcessor) request.Handler {
+//
+// Requests with the query parameter `dry_run=true` are processed with
+// dryRunBatchProcessor rather than batchProcessor, and the resulting
+// documents are returned in the response instead of being indexed. Dry
+// run requests are rejected if dryRunBatchProcessor is nil, or if the
+// client is anonymous.
+func Handler(
+	handler StreamHandler,
+	requestMetadataFunc RequestMetadataFunc,
+	batchProcessor model.BatchProcessor,
+	dryRunBatchProcessor model.BatchProcessor,
+) request.Handler {
 	return func(c *request.Context) {
 		if err := validateRequest(c); err != nil {
 			writeError(c, err)
 			return
 		}
 
+		dryRun, err := dryRunRequest(c, dryRunBatchProcessor != nil)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+
 		// Async can be set by clients to request non-blocking event processing,
 		// returning immediately with an error `publish.ErrFull` when it can't be
 		// serviced.
@@ -87,7 +140,9 @@ func Handler(handler StreamHandler, requestMetadataFunc RequestMetadataFunc, bat
 		// errors while processing the batch cannot be communicated back to the
 		// client.
 		// Instead, errors are logged by the APM Server.
-		async := asyncRequest(c.Request)
+		// Dry run requests are always processed synchronously, so the
+		// resulting documents can be returned in the response.
+		async := !dryRun && asyncRequest(c.Request)
 
 		// Create a new detached context when asynchronous processing is set,
 		// decoupling the context from its deadline, which will finish when
@@ -99,6 +154,21 @@ func Handler(handler StreamHandler, requestMetadataFunc RequestMetadataFunc, bat
 			ctx = apm.DetachedContext(ctx)
 		}
 
+		// Agents may set a processing deadline for synchronous requests,
+		// relative to when the request is received. If the events cannot
+		// be processed before the deadline, a retryable error is returned
+		// rather than blocking until space is available.
+		deadline, err := requestDeadline(c.Request)
+		if err != nil {
+			writeError(c, err)
+			return
+		}
+		if deadline > 0 && !async {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, deadline)
+			defer cancel()
+		}
+
 		// If there was an error decoding the body, then it Result.Err
 		// will already be set. Reformat the error response.
 		if c.Result.Err != nil {
@@ -106,6 +176,13 @@ func Handler(handler StreamHandler, requestMetadataFunc RequestMetadataFunc, bat
 			return
 		}
 
+		processor := batchProcessor
+		var documents *dryRunDocuments
+		if dryRun {
+			documents = &dryRunDocuments{}
+			processor = modelprocessor.Chained{dryRunBatchProcessor, documents}
+		}
+
 		base := requestMetadataFunc(c)
 		var result stream.Result
 		if err := handler.HandleStream(
@@ -114,11 +191,18 @@ func Handler(handler StreamHandler, requestMetadataFunc RequestMetadataFunc, bat
 			base,
 			c.Request.Body,
 			batchSize,
-			batchProcessor,
+			processor,
 			&result,
 		); err != nil {
 			result.Add(err)
 		}
+		if dryRun {
+			writeDryRunResult(c, &result, documents)
+			return
+		}
+		if result.ServiceName != "" {
+			serviceBytesMonitoring.record(result.ServiceName, c.WireBytesRead(), c.DecodedBytesRead())
+		}
 		writeStreamResult(c, &result)
 	}
 }
@@ -143,6 +227,32 @@ func writeError(c *request.Context, err error) {
 }
 
 func writeStreamResult(c *request.Context, sr *stream.Result) {
+	id, statusCode, jsonResult, err := streamResult(c, sr)
+	c.Result.EventsAccepted = sr.Accepted
+	writeResult(c, id, statusCode, &jsonResult, err)
+}
+
+// writeDryRunResult writes the result of a dry run request, including
+// the documents that would have been indexed.
+func writeDryRunResult(c *request.Context, sr *stream.Result, documents *dryRunDocuments) {
+	id, statusCode, jsonResult, err := streamResult(c, sr)
+	if statusCode == http.StatusAccepted {
+		id, statusCode = request.IDResponseValidOK, http.StatusOK
+	}
+	if statusCode >= http.StatusBadRequest {
+		c.ResponseWriter.Header().Add(headers.Connection, "Close")
+	}
+	body := dryRunResult{jsonResult: jsonResult, Documents: documents.documents}
+	if body.Documents == nil {
+		body.Documents = []json.RawMessage{}
+	}
+	c.Result.Set(id, statusCode, request.MapResultIDToStatus[id].Keyword, body, err)
+	c.WriteResult()
+}
+
+// streamResult returns the result ID, status code, response body, and
+// combined error for the stream result.
+func streamResult(c *request.Context, sr *stream.Result) (request.ResultID, int, jsonResult, error) {
 	statusCode := http.StatusAccepted
 	id := request.IDResponseValidAccepted
 	jsonResult := jsonResult{Accepted: sr.Accepted}
@@ -171,15 +281,35 @@ func writeStreamResult(c *request.Context, sr *stream.Result) {
 				errID = request.IDResponseErrorsValidate
 			} else {
 				switch {
-				case errors.Is(err, publish.ErrChannelClosed):
+				case errors.Is(err, publish.ErrChannelClosed),
+					errors.Is(err, modelindexer.ErrClosed):
 					errID = request.IDResponseErrorsShuttingDown
 					err = errServerShuttingDown
-				case errors.Is(err, publish.ErrFull):
+				case errors.Is(err, publish.ErrFull),
+					errors.Is(err, modelindexer.ErrQueueFull):
 					errID = request.IDResponseErrorsFullQueue
+				case errors.Is(err, modelindexer.ErrUnavailable):
+					// Elasticsearch is unavailable, and bulk requests
+					// are paused; agents should back off and retry.
+					errID = request.IDResponseErrorsServiceUnavailable
+				case errors.Is(err, modelindexer.ErrIndexingFailed):
+					// Events were not durably accepted by Elasticsearch,
+					// so agents should retry the request.
+					errID = request.IDResponseErrorsServiceUnavailable
+				case errors.Is(err, modelindexer.ErrDocumentTooLarge):
+					errID = request.IDResponseErrorsRequestTooLarge
 				case errors.Is(err, errMethodNotAllowed):
 					errID = request.IDResponseErrorsMethodNotAllowed
-				case errors.Is(err, errInvalidContentType):
+				case errors.Is(err, context.DeadlineExceeded):
+					errID = request.IDResponseErrorsTimeout
+					err = errDeadlineExceeded
+				case errors.Is(err, errInvalidContentType),
+					errors.Is(err, errInvalidDeadline),
+					errors.Is(err, errInvalidDryRun),
+					errors.Is(err, errDryRunUnsupported):
 					errID = request.IDResponseErrorsValidate
+				case errors.Is(err, errDryRunForbidden):
+					errID = request.IDResponseErrorsForbidden
 				case errors.Is(err, ratelimit.ErrRateLimitExceeded):
 					errID = request.IDResponseErrorsRateLimit
 				case errors.Is(err, auth.ErrUnauthorized):
@@ -187,6 +317,12 @@ func writeStreamResult(c *request.Context, sr *stream.Result) {
 				}
 			}
 			jsonResult.Errors[i] = jsonError{Message: err.Error()}
+			var rateLimitErr *ratelimit.Error
+			if errors.As(err, &rateLimitErr) {
+				rateLimitErr.SetHeaders(c.ResponseWriter.Header())
+				jsonResult.Errors[i].Reason = ratelimit.ReasonRateLimitExceeded
+				jsonResult.Errors[i].RateLimit = rateLimitErr
+			}
 		}
 		errorMessages[i] = jsonResult.Errors[i].Message
 
@@ -211,7 +347,7 @@ func writeStreamResult(c *request.Context, sr *stream.Result) {
 	if len(errorMessages) > 0 {
 		err = errors.New(strings.Join(errorMessages, ", "))
 	}
-	writeResult(c, id, statusCode, &jsonResult, err)
+	return id, statusCode, jsonResult, err
 }
 
 func writeResult(c *request.Context, id request.ResultID, statusCode int, result *jsonResult, err error) {
@@ -238,9 +374,81 @@ type jsonResult struct {
 	Errors   []jsonError `json:"errors,omitempty"`
 }
 
+type dryRunResult struct {
+	jsonResult
+	Documents []json.RawMessage `json:"documents"`
+}
+
 type jsonError struct {
-	Message  string `json:"message"`
-	Document string `json:"document,omitempty"`
+	Message   string           `json:"message"`
+	Document  string           `json:"document,omitempty"`
+	Reason    string           `json:"reason,omitempty"`
+	RateLimit *ratelimit.Error `json:"rate_limit,omitempty"`
+}
+
+// requestDeadline returns the processing deadline requested by the agent
+// in the X-Elastic-Apm-Deadline header, as a duration relative to when the
+// request is received, e.g. "500ms". If the header is not set, zero is
+// returned.
+func requestDeadline(req *http.Request) (time.Duration, error) {
+	value := req.Header.Get(headers.XElasticAPMDeadline)
+	if value == "" {
+		return 0, nil
+	}
+	deadline, err := time.ParseDuration(value)
+	if err != nil || deadline <= 0 {
+		return 0, fmt.Errorf("%w: '%s'", errInvalidDeadline, value)
+	}
+	return deadline, nil
+}
+
+// dryRunRequest reports whether the request is a dry run, as requested
+// with the `dry_run` query parameter. Dry runs are only permitted for
+// authenticated clients, or when the server has no auth configured.
+func dryRunRequest(c *request.Context, supported bool) (bool, error) {
+	value := c.Request.URL.Query().Get("dry_run")
+	if value == "" {
+		return false, nil
+	}
+	dryRun, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("%w: '%s'", errInvalidDryRun, value)
+	}
+	switch {
+	case !dryRun:
+		return false, nil
+	case !supported:
+		return false, errDryRunUnsupported
+	case c.Authentication.Method == auth.MethodAnonymous:
+		return false, errDryRunForbidden
+	}
+	return true, nil
+}
+
+// dryRunDocuments is a model.BatchProcessor that encodes events as the
+// documents that would be indexed, for returning in dry run responses.
+type dryRunDocuments struct {
+	mu        sync.Mutex
+	documents []json.RawMessage
+}
+
+// ProcessBatch encodes the events in b, appending them to d.documents.
+func (d *dryRunDocuments) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	encoder, err := modelindexer.LookupEncoder(modelindexer.DefaultEncoder)
+	if err != nil {
+		return err
+	}
+	var w fastjson.Writer
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i := range *b {
+		w.Reset()
+		if err := encoder.Encode(&(*b)[i], &w); err != nil {
+			return err
+		}
+		d.documents = append(d.documents, append(json.RawMessage(nil), w.Bytes()...))
+	}
+	return nil
 }
 
 func asyncRequest(req *http.Request) bool {
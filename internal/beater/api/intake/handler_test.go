@@ -22,6 +22,8 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"context"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -31,11 +33,17 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"golang.org/x/time/rate"
+
 	"github.com/elastic/apm-server/internal/approvaltest"
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/processor/stream"
 	"github.com/elastic/apm-server/internal/publish"
@@ -107,6 +115,56 @@ func TestIntakeHandler(t *testing.T) {
 				return publish.ErrFull
 			}),
 			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsFullQueue},
+		"IndexerClosed": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return modelindexer.ErrClosed
+			}),
+			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsShuttingDown},
+		"IndexerQueueFull": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return fmt.Errorf("%w: %s", modelindexer.ErrQueueFull, context.DeadlineExceeded)
+			}),
+			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsFullQueue},
+		"IndexingFailed": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return fmt.Errorf("%w: 1 of 5 events: mapper_parsing_exception", modelindexer.ErrIndexingFailed)
+			}),
+			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsServiceUnavailable},
+		"IndexerUnavailable": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return modelindexer.ErrUnavailable
+			}),
+			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsServiceUnavailable},
+		"DeadlineExceeded": {
+			path:     "errors.ndjson",
+			deadline: "10ms",
+			batchProcessor: model.ProcessBatchFunc(func(ctx context.Context, _ *model.Batch) error {
+				<-ctx.Done()
+				return ctx.Err()
+			}),
+			code: http.StatusServiceUnavailable, id: request.IDResponseErrorsTimeout},
+		"InvalidDeadline": {
+			path:     "errors.ndjson",
+			deadline: "soon",
+			code:     http.StatusBadRequest, id: request.IDResponseErrorsValidate},
+		"IndexerDocumentTooLarge": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return &modelindexer.EventError{Index: 1, Err: fmt.Errorf(
+					"%w: 2048 bytes exceeds limit of 1024 bytes", modelindexer.ErrDocumentTooLarge,
+				)}
+			}),
+			code: http.StatusBadRequest, id: request.IDResponseErrorsRequestTooLarge},
+		"RateLimit": {
+			path: "errors.ndjson",
+			batchProcessor: model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+				return ratelimit.NewError(ratelimit.ScopeEvent, rate.NewLimiter(10, 20))
+			}),
+			code: http.StatusTooManyRequests, id: request.IDResponseErrorsRateLimit},
 		"InvalidEvent": {
 			path: "invalid-event.ndjson",
 			code: http.StatusBadRequest, id: request.IDResponseErrorsValidate},
@@ -135,7 +193,7 @@ func TestIntakeHandler(t *testing.T) {
 			tc.setup(t)
 
 			// call handler
-			h := Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor)
+			h := Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, nil)
 			h(tc.c)
 
 			require.Equal(t, string(tc.id), string(tc.c.Result.ID))
@@ -168,12 +226,97 @@ func TestIntakeHandlerContentType(t *testing.T) {
 		}
 
 		tc.setup(t)
-		h := Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor)
+		h := Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, nil)
 		h(tc.c)
 		assert.Equal(t, tc.code, tc.w.Code, tc.c.Result.Err)
 	}
 }
 
+func TestIntakeHandlerServiceBytesMonitoring(t *testing.T) {
+	defer func(m *serviceMonitoring) { serviceBytesMonitoring = m }(serviceBytesMonitoring)
+	serviceBytesMonitoring = newServiceMonitoring()
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "services", serviceBytesMonitoring.CollectMonitoring, monitoring.Report)
+
+	data, err := os.ReadFile("../../../../testdata/intake-v2/errors.ndjson")
+	require.NoError(t, err)
+	tc := testcaseIntakeHandler{
+		r:    compressedRequest(t, "gzip", true),
+		code: http.StatusAccepted,
+	}
+	wireBytes := tc.r.ContentLength
+	tc.setup(t)
+	Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, nil)(tc.c)
+	require.Equal(t, tc.code, tc.w.Code, tc.c.Result.Err)
+
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"services.1234_service-12a3.request.bytes.wire":    wireBytes,
+		"services.1234_service-12a3.request.bytes.decoded": int64(len(data)),
+		"services.overflowed":                              0,
+	}, snapshot.Ints)
+}
+
+func TestIntakeHandlerDryRun(t *testing.T) {
+	newTestcase := func(t *testing.T, dryRun string, method auth.Method) *testcaseIntakeHandler {
+		data, err := os.ReadFile("../../../../testdata/intake-v2/errors.ndjson")
+		require.NoError(t, err)
+		tc := &testcaseIntakeHandler{r: httptest.NewRequest("POST", "/?dry_run="+dryRun, bytes.NewReader(data))}
+		tc.batchProcessor = model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+			t.Error("events should not be published for dry run requests")
+			return nil
+		})
+		tc.setup(t)
+		tc.c.Authentication.Method = method
+		return tc
+	}
+	dryRunBatchProcessor := model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
+		for i := range *b {
+			(*b)[i].Labels = model.Labels{"dry_run": {Value: "true"}}
+		}
+		return nil
+	})
+
+	t.Run("success", func(t *testing.T) {
+		tc := newTestcase(t, "true", auth.MethodAPIKey)
+		Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, dryRunBatchProcessor)(tc.c)
+		require.Equal(t, http.StatusOK, tc.w.Code, tc.w.Body.String())
+
+		var result struct {
+			Accepted  int
+			Documents []map[string]interface{}
+		}
+		require.NoError(t, json.Unmarshal(tc.w.Body.Bytes(), &result))
+		assert.Equal(t, 5, result.Accepted)
+		require.Len(t, result.Documents, 5)
+		for _, doc := range result.Documents {
+			assert.Contains(t, doc, "@timestamp")
+			assert.Equal(t, map[string]interface{}{"dry_run": "true"}, doc["labels"])
+		}
+	})
+
+	t.Run("anonymous", func(t *testing.T) {
+		tc := newTestcase(t, "true", auth.MethodAnonymous)
+		Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, dryRunBatchProcessor)(tc.c)
+		assert.Equal(t, http.StatusForbidden, tc.w.Code)
+		assert.Contains(t, tc.w.Body.String(), errDryRunForbidden.Error())
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		tc := newTestcase(t, "true", auth.MethodNone)
+		Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, nil)(tc.c)
+		assert.Equal(t, http.StatusBadRequest, tc.w.Code)
+		assert.Contains(t, tc.w.Body.String(), errDryRunUnsupported.Error())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		tc := newTestcase(t, "maybe", auth.MethodNone)
+		Handler(tc.processor, emptyRequestMetadata, tc.batchProcessor, dryRunBatchProcessor)(tc.c)
+		assert.Equal(t, http.StatusBadRequest, tc.w.Code)
+		assert.Contains(t, tc.w.Body.String(), errInvalidDryRun.Error())
+	})
+}
+
 type testcaseIntakeHandler struct {
 	c              *request.Context
 	w              *httptest.ResponseRecorder
@@ -182,6 +325,7 @@ type testcaseIntakeHandler struct {
 	batchProcessor model.BatchProcessor
 	path           string
 	contentType    string
+	deadline       string
 
 	code int
 	id   request.ResultID
@@ -213,6 +357,9 @@ func (tc *testcaseIntakeHandler) setup(t *testing.T) {
 	if tc.contentType != "" {
 		tc.r.Header.Set("Content-Type", tc.contentType)
 	}
+	if tc.deadline != "" {
+		tc.r.Header.Set(headers.XElasticAPMDeadline, tc.deadline)
+	}
 
 	tc.w = httptest.NewRecorder()
 	tc.c = request.NewContext()
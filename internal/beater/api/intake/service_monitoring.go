@@ -0,0 +1,98 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package intake
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// maxServiceMonitoringEntries limits the number of distinct services tracked
+// by serviceMonitoring, protecting against unbounded memory usage due to
+// arbitrary service names.
+const maxServiceMonitoringEntries = 1000
+
+// serviceMonitoring holds intake request body sizes dimensioned by the
+// service name recorded in the request's metadata.
+type serviceMonitoring struct {
+	mu         sync.RWMutex
+	services   map[string]*serviceMetrics
+	overflowed int64
+}
+
+type serviceMetrics struct {
+	wireBytes    int64
+	decodedBytes int64
+}
+
+func newServiceMonitoring() *serviceMonitoring {
+	return &serviceMonitoring{services: make(map[string]*serviceMetrics)}
+}
+
+// record records the wire (compressed) and decoded (uncompressed) request
+// body sizes for service.
+func (m *serviceMonitoring) record(service string, wireBytes, decodedBytes int64) {
+	metrics := m.metrics(service)
+	if metrics == nil {
+		return
+	}
+	atomic.AddInt64(&metrics.wireBytes, wireBytes)
+	atomic.AddInt64(&metrics.decodedBytes, decodedBytes)
+}
+
+// metrics returns the serviceMetrics for service, creating it if necessary.
+// If the maximum number of entries has been reached, metrics returns nil.
+func (m *serviceMonitoring) metrics(service string) *serviceMetrics {
+	m.mu.RLock()
+	metrics, ok := m.services[service]
+	m.mu.RUnlock()
+	if ok {
+		return metrics
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if metrics, ok := m.services[service]; ok {
+		return metrics
+	}
+	if len(m.services) >= maxServiceMonitoringEntries {
+		atomic.AddInt64(&m.overflowed, 1)
+		return nil
+	}
+	metrics = &serviceMetrics{}
+	m.services[service] = metrics
+	return metrics
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+func (m *serviceMonitoring) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for service, metrics := range m.services {
+		monitoring.ReportNamespace(V, service, func() {
+			monitoring.ReportInt(V, "request.bytes.wire", atomic.LoadInt64(&metrics.wireBytes))
+			monitoring.ReportInt(V, "request.bytes.decoded", atomic.LoadInt64(&metrics.decodedBytes))
+		})
+	}
+	monitoring.ReportInt(V, "overflowed", atomic.LoadInt64(&m.overflowed))
+}
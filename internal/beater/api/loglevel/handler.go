@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package loglevel
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// maxRequestBodySize limits the size of log level request bodies.
+const maxRequestBodySize = 64 * 1024
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters
+	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
+	registry      = monitoring.Default.NewRegistry("apm-server.log_level")
+)
+
+// Handler returns a request.Handler for querying and changing the log level
+// and debug selectors of loggers at runtime.
+//
+// GET requests return the current settings. PUT requests apply a
+// logs.LevelRequest, encoded as JSON in the request body. DELETE requests
+// restore the default settings. All requests respond with the resulting
+// settings.
+//
+// Only requests authenticated with the secret token are accepted. Requests
+// authenticated with an API Key are rejected regardless of its privileges,
+// as are all requests when auth is disabled.
+func Handler(levels *logs.LevelController) request.Handler {
+	return func(c *request.Context) {
+		if c.Authentication.Method != auth.MethodSecretToken {
+			c.Result.SetDefault(request.IDResponseErrorsForbidden)
+			c.WriteResult()
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req logs.LevelRequest
+			body := io.LimitReader(c.Request.Body, maxRequestBodySize)
+			if err := json.NewDecoder(body).Decode(&req); err != nil {
+				c.Result.SetWithError(request.IDResponseErrorsDecode, err)
+				c.WriteResult()
+				return
+			}
+			if err := levels.Apply(req); err != nil {
+				c.Result.SetWithError(request.IDResponseErrorsValidate, err)
+				c.WriteResult()
+				return
+			}
+			c.Logger.Infof("log level changed to %s", levels.Settings().Level)
+		case http.MethodDelete:
+			levels.Reset()
+			c.Logger.Infof("log level reset to %s", levels.Settings().Level)
+		default:
+			c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+			c.WriteResult()
+			return
+		}
+		c.Result.SetWithBody(request.IDResponseValidOK, levels.Settings())
+		c.WriteResult()
+	}
+}
@@ -26,22 +26,32 @@ import (
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/api/adoption"
 	"github.com/elastic/apm-server/internal/beater/api/config/agent"
+	"github.com/elastic/apm-server/internal/beater/api/datastreams"
 	"github.com/elastic/apm-server/internal/beater/api/intake"
+	"github.com/elastic/apm-server/internal/beater/api/inventory"
+	"github.com/elastic/apm-server/internal/beater/api/loglevel"
+	"github.com/elastic/apm-server/internal/beater/api/queues"
 	"github.com/elastic/apm-server/internal/beater/api/root"
+	"github.com/elastic/apm-server/internal/beater/api/validationfailures"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/middleware"
 	"github.com/elastic/apm-server/internal/beater/otlp"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/datastreamstats"
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modeldecoder"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/processor/stream"
 	"github.com/elastic/apm-server/internal/sourcemap"
@@ -58,6 +68,8 @@ const (
 	AgentConfigPath = "/config/v1/agents"
 	// IntakePath defines the path to ingest monitored events
 	IntakePath = "/intake/v2/events"
+	// IntakeECSLogsPath defines the path to ingest ECS-JSON log lines
+	IntakeECSLogsPath = "/intake/v2/logs"
 
 	// RUM routes
 
@@ -74,33 +86,115 @@ const (
 	OTLPMetricsIntakePath = "/v1/metrics"
 	// OTLPLogsIntakePath defines the path to ingest OpenTelemetry logs (HTTP Collector)
 	OTLPLogsIntakePath = "/v1/logs"
+
+	// ValidationFailuresPath defines the path to query counts of events
+	// failing validation, when expvar is enabled
+	ValidationFailuresPath = "/debug/validation_failures"
+
+	// ServiceInventoryPath defines the path to query the services which
+	// recently sent events, when the service inventory is enabled
+	ServiceInventoryPath = "/service_inventory"
+
+	// AgentConfigAdoptionPath defines the path to query the adoption of
+	// agent config by agents, when agent config adoption tracking is enabled
+	AgentConfigAdoptionPath = "/config/v1/agents/adoption"
+
+	// DataStreamStatsPath defines the path to query the statistics of
+	// the APM data streams, when data stream stats reporting is enabled
+	DataStreamStatsPath = "/debug/data_streams"
+
+	// QueuesPath defines the path to query the state of the server's
+	// internal queues, when the queues endpoint is enabled
+	QueuesPath = "/debug/queues"
+
+	// LogLevelPath defines the path to query and change the log level
+	// and debug selectors, when the log level endpoint is enabled
+	LogLevelPath = "/debug/log_level"
 )
 
+// MuxParams holds optional parameters for NewMux. Routes whose
+// dependencies are nil are not registered, or reject requests.
+type MuxParams struct {
+	// DryRunBatchProcessor is used for processing events received by intake
+	// dry run requests, and must not publish them. If DryRunBatchProcessor
+	// is nil, dry runs are rejected.
+	DryRunBatchProcessor model.BatchProcessor
+
+	// ServiceInventory, if non-nil, is queried by the service inventory
+	// endpoint.
+	ServiceInventory *modelprocessor.ServiceInventory
+
+	// DataStreamStats, if non-nil, is queried by the data stream stats
+	// endpoint.
+	DataStreamStats *datastreamstats.Reporter
+
+	// AgentConfigAdoption, if non-nil, is queried by the agent config
+	// adoption endpoint.
+	AgentConfigAdoption *agentcfg.Adoption
+
+	// APIKeyRateLimitStore holds an API Key-based rate-limiter LRU cache,
+	// or nil if API Key rate limiting is disabled.
+	APIKeyRateLimitStore *ratelimit.Store
+
+	// SourcemapFetcher holds a sourcemap.Fetcher, or nil if source
+	// mapping is disabled.
+	SourcemapFetcher sourcemap.Fetcher
+
+	// BootstrapStatus, if non-nil, returns details of the server's
+	// progress towards being ready to publish events, for reporting by
+	// the root endpoint.
+	BootstrapStatus func() mapstr.M
+
+	// Capabilities, if non-nil, returns the features enabled by the
+	// Elasticsearch license, for reporting by the root endpoint.
+	Capabilities func() mapstr.M
+
+	// QueueState, if non-nil, returns the state of the server's internal
+	// queues, for reporting by the queues endpoint when it is enabled.
+	QueueState func() mapstr.M
+}
+
 // NewMux creates a new gorilla/mux router, with routes registered for handling the
-// APM Server API.
+// APM Server API. Optional dependencies of the routes are given in params.
 func NewMux(
 	beaterConfig *config.Config,
 	batchProcessor model.BatchProcessor,
 	authenticator *auth.Authenticator,
 	fetcher agentcfg.Fetcher,
 	ratelimitStore *ratelimit.Store,
-	sourcemapFetcher sourcemap.Fetcher,
 	fleetManaged bool,
 	publishReady func() bool,
-) (*mux.Router, error) {
+	draining func() bool,
+	params MuxParams) (*mux.Router, error) {
 	pool := request.NewContextPool()
 	logger := logp.NewLogger(logs.Handler)
 	router := mux.NewRouter()
 	router.NotFoundHandler = pool.HTTPHandler(notFoundHandler)
 
+	var concurrencyLimiter *ratelimit.ConcurrencyLimiter
+	if beaterConfig.MaxConcurrentRequestsPerClient > 0 {
+		var err error
+		concurrencyLimiter, err = ratelimit.NewConcurrencyLimiter(beaterConfig.MaxConcurrentRequestsPerClient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	builder := routeBuilder{
-		cfg:              beaterConfig,
-		authenticator:    authenticator,
-		batchProcessor:   batchProcessor,
-		ratelimitStore:   ratelimitStore,
-		sourcemapFetcher: sourcemapFetcher,
-		fleetManaged:     fleetManaged,
-		intakeSemaphore:  make(chan struct{}, beaterConfig.MaxConcurrentDecoders),
+		cfg:                  beaterConfig,
+		authenticator:        authenticator,
+		batchProcessor:       batchProcessor,
+		dryRunBatchProcessor: params.DryRunBatchProcessor,
+		ratelimitStore:       ratelimitStore,
+		apiKeyRateLimitStore: params.APIKeyRateLimitStore,
+		sourcemapFetcher:     params.SourcemapFetcher,
+		fleetManaged:         fleetManaged,
+		draining:             draining,
+		intakeSemaphore:      make(chan struct{}, beaterConfig.MaxConcurrentDecoders),
+		concurrencyLimiter:   concurrencyLimiter,
+	}
+	if n := beaterConfig.RumConfig.SourceMapping.MaxConcurrency; n > 0 {
+		builder.sourcemapSemaphore = make(chan struct{}, n)
 	}
 
 	type route struct {
@@ -114,16 +208,35 @@ func NewMux(
 	}
 
 	routeMap := []route{
-		{RootPath, builder.rootHandler(publishReady)},
+		{RootPath, builder.rootHandler(publishReady, params.BootstrapStatus, params.Capabilities)},
 		{AgentConfigPath, builder.backendAgentConfigHandler(fetcher)},
 		{AgentConfigRUMPath, builder.rumAgentConfigHandler(fetcher)},
 		{IntakeRUMPath, builder.rumIntakeHandler(stream.RUMV2Processor)},
 		{IntakeRUMV3Path, builder.rumIntakeHandler(stream.RUMV3Processor)},
-		{IntakePath, builder.backendIntakeHandler},
+		{IntakePath, builder.backendIntakeHandler(stream.BackendProcessor)},
+		{IntakeECSLogsPath, builder.backendIntakeHandler(stream.ECSLogsProcessor)},
 		{OTLPTracesIntakePath, builder.otlpHandler(otlpHandlers.TraceHandler, otlp.HTTPTracesMonitoringMap)},
 		{OTLPMetricsIntakePath, builder.otlpHandler(otlpHandlers.MetricsHandler, otlp.HTTPMetricsMonitoringMap)},
 		{OTLPLogsIntakePath, builder.otlpHandler(otlpHandlers.LogsHandler, otlp.HTTPLogsMonitoringMap)},
 	}
+	if params.ServiceInventory != nil {
+		routeMap = append(routeMap, route{ServiceInventoryPath, builder.serviceInventoryHandler(params.ServiceInventory)})
+	}
+	if params.DataStreamStats != nil {
+		routeMap = append(routeMap, route{DataStreamStatsPath, builder.dataStreamStatsHandler(params.DataStreamStats)})
+	}
+	if params.AgentConfigAdoption != nil {
+		routeMap = append(routeMap, route{AgentConfigAdoptionPath, builder.agentConfigAdoptionHandler(params.AgentConfigAdoption)})
+	}
+	if beaterConfig.LogLevelEndpoint.Enabled {
+		routeMap = append(routeMap, route{LogLevelPath, builder.logLevelHandler()})
+	}
+	if beaterConfig.QueuesEndpoint.Enabled {
+		routeMap = append(routeMap, route{QueuesPath, builder.queuesHandler(params.QueueState)})
+	}
+	if beaterConfig.Expvar.Enabled {
+		routeMap = append(routeMap, route{ValidationFailuresPath, builder.validationFailuresHandler()})
+	}
 
 	for _, route := range routeMap {
 		h, err := route.handlerFn()
@@ -138,6 +251,11 @@ func NewMux(
 		logger.Infof("Path %s added to request handler", path)
 		router.Handle(path, http.HandlerFunc(debugVarsHandler))
 	}
+	if beaterConfig.Prometheus.Enabled {
+		path := beaterConfig.Prometheus.URL
+		logger.Infof("Path %s added to request handler", path)
+		router.Handle(path, promhttp.Handler())
+	}
 	if beaterConfig.Pprof.Enabled {
 		const path = "/debug/pprof"
 		logger.Infof("Path %s added to request handler", path)
@@ -156,22 +274,40 @@ func NewMux(
 }
 
 type routeBuilder struct {
-	cfg              *config.Config
-	authenticator    *auth.Authenticator
-	batchProcessor   model.BatchProcessor
-	ratelimitStore   *ratelimit.Store
-	sourcemapFetcher sourcemap.Fetcher
-	fleetManaged     bool
-	intakeSemaphore  chan struct{}
+	cfg                  *config.Config
+	authenticator        *auth.Authenticator
+	batchProcessor       model.BatchProcessor
+	dryRunBatchProcessor model.BatchProcessor
+	ratelimitStore       *ratelimit.Store
+	apiKeyRateLimitStore *ratelimit.Store
+	sourcemapFetcher     sourcemap.Fetcher
+	fleetManaged         bool
+	draining             func() bool
+	intakeSemaphore      chan struct{}
+	sourcemapSemaphore   chan struct{}
+	concurrencyLimiter   *ratelimit.ConcurrencyLimiter
 }
 
-func (r *routeBuilder) backendIntakeHandler() (request.Handler, error) {
-	intakeProcessor := stream.BackendProcessor(stream.Config{
-		MaxEventSize: r.cfg.MaxEventSize,
-		Semaphore:    r.intakeSemaphore,
-	})
-	h := intake.Handler(intakeProcessor, backendRequestMetadataFunc(r.cfg), r.batchProcessor)
-	return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, intake.MonitoringMap)...)
+func (r *routeBuilder) backendIntakeHandler(newProcessor func(stream.Config) *stream.Processor) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		intakeProcessor := newProcessor(stream.Config{
+			MaxEventSize:       r.cfg.MaxEventSize,
+			Semaphore:          r.intakeSemaphore,
+			ValidationFailures: intake.ValidationFailures,
+			Lenient:            r.cfg.Decoding.Lenient(),
+			Coerced:            intake.Coerced,
+			TimestampPolicy:    modeldecoder.TimestampPolicy(r.cfg.TimestampPolicy.Intake),
+		})
+		h := intake.Handler(intakeProcessor, backendRequestMetadataFunc(r.cfg), r.batchProcessor, r.dryRunBatchProcessor)
+		// Agent monitoring wraps all other middleware, so that requests
+		// rejected by authentication or rate limiting are also recorded.
+		mw := append(
+			[]middleware.Middleware{middleware.AgentMonitoringMiddleware(intake.AgentMonitoring)},
+			backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, intake.MonitoringMap)...,
+		)
+		mw = append(mw, middleware.ConcurrencyLimitMiddleware(r.concurrencyLimiter))
+		return middleware.Wrap(h, mw...)
+	}
 }
 
 func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, monitoringMap map[request.ResultID]*monitoring.Int) func() (request.Handler, error) {
@@ -179,7 +315,7 @@ func (r *routeBuilder) otlpHandler(handler http.HandlerFunc, monitoringMap map[r
 		h := func(c *request.Context) {
 			handler(c.ResponseWriter, c.Request)
 		}
-		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, monitoringMap)...)
+		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, monitoringMap)...)
 	}
 }
 
@@ -190,8 +326,10 @@ func (r *routeBuilder) rumIntakeHandler(newProcessor func(stream.Config) *stream
 		// frames to exclude from error grouping; identifying library frames must happen before updating the error culprit.
 		if r.sourcemapFetcher != nil {
 			batchProcessors = append(batchProcessors, sourcemap.BatchProcessor{
-				Fetcher: r.sourcemapFetcher,
-				Timeout: r.cfg.RumConfig.SourceMapping.Timeout,
+				Fetcher:      r.sourcemapFetcher,
+				Timeout:      r.cfg.RumConfig.SourceMapping.Timeout,
+				EventTimeout: r.cfg.RumConfig.SourceMapping.EventTimeout,
+				Semaphore:    r.sourcemapSemaphore,
 			})
 		}
 		if r.cfg.RumConfig.LibraryPattern != "" {
@@ -211,49 +349,142 @@ func (r *routeBuilder) rumIntakeHandler(newProcessor func(stream.Config) *stream
 		if r.sourcemapFetcher != nil {
 			batchProcessors = append(batchProcessors, modelprocessor.SetCulprit{})
 		}
+		if cfg := r.cfg.RumConfig.URLGrouping; cfg.Enabled {
+			rules := make([]modelprocessor.URLGroupRule, len(cfg.Rules))
+			for i, rule := range cfg.Rules {
+				re, err := regexp.Compile(rule.Pattern)
+				if err != nil {
+					return nil, errors.Wrap(err, "invalid url grouping regex")
+				}
+				rules[i] = modelprocessor.URLGroupRule{Pattern: re, Replacement: rule.Replacement}
+			}
+			batchProcessors = append(batchProcessors, modelprocessor.SetURLGroup{
+				Templates:        cfg.Templates,
+				Rules:            rules,
+				ReplaceIDs:       cfg.ReplaceIDs,
+				StripQueryParams: cfg.StripQueryParams,
+			})
+		}
+		var dryRunBatchProcessors model.BatchProcessor
+		if r.dryRunBatchProcessor != nil {
+			// Copy the request-level processors, as batchProcessors is appended to below.
+			dryRunBatchProcessors = append(batchProcessors[:len(batchProcessors):len(batchProcessors)], r.dryRunBatchProcessor)
+		}
 		batchProcessors = append(batchProcessors, r.batchProcessor) // r.batchProcessor always goes last
 		intakeProcessor := newProcessor(stream.Config{
-			MaxEventSize: r.cfg.MaxEventSize,
-			Semaphore:    r.intakeSemaphore,
+			MaxEventSize:       r.cfg.MaxEventSize,
+			Semaphore:          r.intakeSemaphore,
+			ValidationFailures: intake.ValidationFailures,
+			Lenient:            r.cfg.Decoding.Lenient(),
+			Coerced:            intake.Coerced,
+			TimestampPolicy:    modeldecoder.TimestampPolicy(r.cfg.TimestampPolicy.RUM),
+			MetadataCache: stream.MetadataCacheConfig{
+				Size:       r.cfg.RumConfig.MetadataCache.Size,
+				Expiration: r.cfg.RumConfig.MetadataCache.Expiration,
+				Hits:       intake.MetadataCacheHits,
+				Misses:     intake.MetadataCacheMisses,
+			},
 		})
-		h := intake.Handler(intakeProcessor, rumRequestMetadataFunc(r.cfg), batchProcessors)
-		return middleware.Wrap(h, rumMiddleware(r.cfg, r.authenticator, r.ratelimitStore, intake.MonitoringMap)...)
+		h := intake.Handler(intakeProcessor, rumRequestMetadataFunc(r.cfg), batchProcessors, dryRunBatchProcessors)
+		mw := rumMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, intake.MonitoringMap)
+		mw = append(mw, middleware.ConcurrencyLimitMiddleware(r.concurrencyLimiter))
+		return middleware.Wrap(h, mw...)
 	}
 }
 
-func (r *routeBuilder) rootHandler(publishReady func() bool) func() (request.Handler, error) {
+func (r *routeBuilder) rootHandler(publishReady func() bool, bootstrapStatus, capabilities func() mapstr.M) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
 		h := root.Handler(root.HandlerConfig{
-			Version:      version.Version,
-			PublishReady: publishReady,
+			Version:         version.Version,
+			PublishReady:    publishReady,
+			BootstrapStatus: bootstrapStatus,
+			Capabilities:    capabilities,
 		})
-		return middleware.Wrap(h, rootMiddleware(r.cfg, r.authenticator)...)
+		return middleware.Wrap(h, rootMiddleware(r.cfg, r.authenticator, r.draining)...)
+	}
+}
+
+func (r *routeBuilder) serviceInventoryHandler(serviceInventory *modelprocessor.ServiceInventory) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := inventory.Handler(serviceInventory)
+		return middleware.Wrap(h, debugMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, inventory.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) dataStreamStatsHandler(reporter *datastreamstats.Reporter) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := datastreams.Handler(reporter)
+		return middleware.Wrap(h, debugMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, datastreams.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) agentConfigAdoptionHandler(agentConfigAdoption *agentcfg.Adoption) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := adoption.Handler(agentConfigAdoption)
+		return middleware.Wrap(h, debugMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, adoption.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) validationFailuresHandler() func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := validationfailures.Handler(intake.ValidationFailures)
+		return middleware.Wrap(h, debugMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, validationfailures.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) logLevelHandler() func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := loglevel.Handler(logs.Levels)
+		return middleware.Wrap(h, backendMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, loglevel.MonitoringMap)...)
+	}
+}
+
+func (r *routeBuilder) queuesHandler(queueState func() mapstr.M) func() (request.Handler, error) {
+	return func() (request.Handler, error) {
+		h := queues.Handler(func() mapstr.M {
+			state := mapstr.M{}
+			if queueState != nil {
+				state = queueState()
+			}
+			// Batches waiting for a decoder are blocked in
+			// request handlers, rather than queued.
+			state["intake"] = mapstr.M{
+				"decoders": mapstr.M{
+					"active": len(r.intakeSemaphore),
+					"limit":  cap(r.intakeSemaphore),
+				},
+			}
+			return state
+		})
+		return middleware.Wrap(h, debugMiddleware(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, queues.MonitoringMap)...)
 	}
 }
 
 func (r *routeBuilder) backendAgentConfigHandler(f agentcfg.Fetcher) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
-		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, backendMiddleware, f, r.fleetManaged)
+		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, backendMiddleware, f, r.fleetManaged)
 	}
 }
 
 func (r *routeBuilder) rumAgentConfigHandler(f agentcfg.Fetcher) func() (request.Handler, error) {
 	return func() (request.Handler, error) {
-		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, rumMiddleware, f, r.fleetManaged)
+		return agentConfigHandler(r.cfg, r.authenticator, r.ratelimitStore, r.apiKeyRateLimitStore, r.draining, rumMiddleware, f, r.fleetManaged)
 	}
 }
 
-type middlewareFunc func(*config.Config, *auth.Authenticator, *ratelimit.Store, map[request.ResultID]*monitoring.Int) []middleware.Middleware
+type middlewareFunc func(*config.Config, *auth.Authenticator, *ratelimit.Store, *ratelimit.Store, func() bool, map[request.ResultID]*monitoring.Int) []middleware.Middleware
 
 func agentConfigHandler(
 	cfg *config.Config,
 	authenticator *auth.Authenticator,
 	ratelimitStore *ratelimit.Store,
+	apiKeyRateLimitStore *ratelimit.Store,
+	draining func() bool,
 	middlewareFunc middlewareFunc,
 	f agentcfg.Fetcher,
 	fleetManaged bool,
 ) (request.Handler, error) {
-	mw := middlewareFunc(cfg, authenticator, ratelimitStore, agent.MonitoringMap)
+	mw := middlewareFunc(cfg, authenticator, ratelimitStore, apiKeyRateLimitStore, draining, agent.MonitoringMap)
 	h := agent.NewHandler(f, cfg.KibanaAgentConfig.Cache.Expiration, cfg.DefaultServiceEnvironment, cfg.AgentAuth.Anonymous.AllowAgent)
 
 	if !cfg.Kibana.Enabled && !fleetManaged {
@@ -267,40 +498,51 @@ func agentConfigHandler(
 	return middleware.Wrap(h, mw...)
 }
 
-func apmMiddleware(m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
+func apmMiddleware(cfg *config.Config, draining func() bool, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
 	return []middleware.Middleware{
 		middleware.LogMiddleware(),
 		middleware.TimeoutMiddleware(),
 		middleware.RecoverPanicMiddleware(),
 		middleware.MonitoringMiddleware(m),
+		middleware.ConnectionMiddleware(cfg.IdleTimeout, draining),
 	}
 }
 
-func backendMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore *ratelimit.Store, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
-	backendMiddleware := append(apmMiddleware(m),
+func backendMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore, apiKeyRateLimitStore *ratelimit.Store, draining func() bool, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
+	backendMiddleware := append(apmMiddleware(cfg, draining, m),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
 		middleware.AuthMiddleware(authenticator, true),
 		middleware.AnonymousRateLimitMiddleware(ratelimitStore),
+		middleware.APIKeyRateLimitMiddleware(apiKeyRateLimitStore),
 	)
 	return backendMiddleware
 }
 
-func rumMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore *ratelimit.Store, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
+// debugMiddleware returns the middleware for read-only debug endpoints,
+// which require authentication and accept only GET requests.
+func debugMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore, apiKeyRateLimitStore *ratelimit.Store, draining func() bool, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
+	return append(backendMiddleware(cfg, authenticator, ratelimitStore, apiKeyRateLimitStore, draining, m),
+		middleware.DebugMiddleware(),
+	)
+}
+
+func rumMiddleware(cfg *config.Config, authenticator *auth.Authenticator, ratelimitStore, apiKeyRateLimitStore *ratelimit.Store, draining func() bool, m map[request.ResultID]*monitoring.Int) []middleware.Middleware {
 	msg := "RUM endpoint is disabled. " +
 		"Configure the `apm-server.rum` section in apm-server.yml to enable ingestion of RUM events. " +
 		"If you are not using the RUM agent, you can safely ignore this error."
-	rumMiddleware := append(apmMiddleware(m),
+	rumMiddleware := append(apmMiddleware(cfg, draining, m),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
 		middleware.ResponseHeadersMiddleware(cfg.RumConfig.ResponseHeaders),
 		middleware.CORSMiddleware(cfg.RumConfig.AllowOrigins, cfg.RumConfig.AllowHeaders),
 		middleware.AuthMiddleware(authenticator, true),
 		middleware.AnonymousRateLimitMiddleware(ratelimitStore),
+		middleware.APIKeyRateLimitMiddleware(apiKeyRateLimitStore),
 	)
 	return append(rumMiddleware, middleware.KillSwitchMiddleware(cfg.RumConfig.Enabled, msg))
 }
 
-func rootMiddleware(cfg *config.Config, authenticator *auth.Authenticator) []middleware.Middleware {
-	return append(apmMiddleware(root.MonitoringMap),
+func rootMiddleware(cfg *config.Config, authenticator *auth.Authenticator, draining func() bool) []middleware.Middleware {
+	return append(apmMiddleware(cfg, draining, root.MonitoringMap),
 		middleware.ResponseHeadersMiddleware(cfg.ResponseHeaders),
 		middleware.AuthMiddleware(authenticator, false),
 	)
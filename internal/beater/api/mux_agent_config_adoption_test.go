@@ -0,0 +1,115 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/beater/api/adoption"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/monitoringtest"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestAgentConfigAdoptionHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+
+	agentConfigAdoption := agentcfg.NewAdoption(time.Minute)
+	batch := model.Batch{{
+		Service: model.Service{Name: "frontend", Environment: "production"},
+		Agent:   model.Agent{Name: "go", EphemeralID: "abc", ConfigEtag: "etag-1"},
+	}}
+	require.NoError(t, agentConfigAdoption.ProcessBatch(context.Background(), &batch))
+
+	mux, err := muxBuilder{AgentConfigAdoption: agentConfigAdoption}.build(cfg)
+	require.NoError(t, err)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, AgentConfigAdoptionPath, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, AgentConfigAdoptionPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var result struct {
+			Services   []agentcfg.AdoptionEntry
+			Overflowed int64
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		require.Len(t, result.Services, 1)
+		assert.Equal(t, "frontend", result.Services[0].ServiceName)
+		assert.Equal(t, 1, result.Services[0].Agents)
+		require.Len(t, result.Services[0].Configs, 1)
+		assert.Equal(t, "etag-1", result.Services[0].Configs[0].Etag)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, AgentConfigAdoptionPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestAgentConfigAdoptionHandler_Anonymous(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.AgentAuth.Anonymous.Enabled = true
+	cfg.AgentAuth.Anonymous.AllowAgent = []string{"rum-js"}
+
+	mux, err := muxBuilder{AgentConfigAdoption: agentcfg.NewAdoption(time.Minute)}.build(cfg)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, AgentConfigAdoptionPath, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestAgentConfigAdoptionHandler_Disabled(t *testing.T) {
+	rec, err := requestToMuxerWithHeader(config.DefaultConfig(), AgentConfigAdoptionPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAgentConfigAdoptionHandler_MonitoringMiddleware(t *testing.T) {
+	mux, err := muxBuilder{AgentConfigAdoption: agentcfg.NewAdoption(time.Minute)}.build(config.DefaultConfig())
+	require.NoError(t, err)
+	monitoringtest.ClearRegistry(adoption.MonitoringMap)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, AgentConfigAdoptionPath, nil))
+	assert.Equal(t, int64(1), adoption.MonitoringMap[request.IDResponseValidOK].Get())
+}
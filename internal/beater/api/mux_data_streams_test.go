@@ -0,0 +1,76 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/datastreamstats"
+)
+
+func TestDataStreamStatsHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+
+	reporter := datastreamstats.NewReporter(nil, "default", time.Minute)
+	mux, err := muxBuilder{DataStreamStats: reporter}.build(cfg)
+	require.NoError(t, err)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, DataStreamStatsPath, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, DataStreamStatsPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var result datastreamstats.Report
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		assert.Zero(t, result.Collected)
+		assert.Empty(t, result.DataStreams)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, DataStreamStatsPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestDataStreamStatsHandler_Disabled(t *testing.T) {
+	rec, err := requestToMuxerWithHeader(config.DefaultConfig(), DataStreamStatsPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
 )
 
 func TestExpvarDefaultDisabled(t *testing.T) {
@@ -48,3 +49,44 @@ func TestExpvarEnabled(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Contains(t, decoded, "memstats")
 }
+
+func TestValidationFailuresDefaultDisabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	recorder, err := requestToMuxerWithPattern(cfg, ValidationFailuresPath)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestValidationFailuresEnabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Expvar.Enabled = true
+	recorder, err := requestToMuxerWithHeader(cfg, ValidationFailuresPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	decoded := make(map[string]interface{})
+	err = json.NewDecoder(recorder.Body).Decode(&decoded)
+	assert.NoError(t, err)
+	assert.Contains(t, decoded, "failures")
+	assert.Contains(t, decoded, "overflowed")
+}
+
+func TestValidationFailuresAuthorization(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Expvar.Enabled = true
+	cfg.AgentAuth.SecretToken = "1234"
+
+	recorder, err := requestToMuxerWithHeader(cfg, ValidationFailuresPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	recorder, err = requestToMuxerWithHeader(cfg, ValidationFailuresPath, http.MethodGet,
+		map[string]string{headers.Authorization: "Bearer 1234"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	recorder, err = requestToMuxerWithHeader(cfg, ValidationFailuresPath, http.MethodPost,
+		map[string]string{headers.Authorization: "Bearer 1234"})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+}
@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/api/intake"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestIntakeECSLogsHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.MaxConcurrentDecoders = 10
+	body := `{"metadata": {"service": {"name": "testsvc", "agent": {"name": "java", "version": "1.0"}}}}
+{"@timestamp": "2022-09-08T06:02:51.123Z", "log.level": "INFO", "message": "hello", "ecs.version": "1.2.0"}
+`
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, IntakeECSLogsPath, strings.NewReader(body))
+		req.Header.Set(headers.ContentType, "application/x-ndjson")
+		return req
+	}
+
+	rec, err := requestToMuxer(cfg, newRequest())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec, err = requestToMuxer(cfg, requestWithHeader(newRequest(), map[string]string{headers.Authorization: "Bearer 1234"}))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+}
+
+func TestIntakeECSLogsHandler_MonitoringMiddleware(t *testing.T) {
+	// send GET request resulting in 405 MethodNotAllowed error
+	testMonitoringMiddleware(t, IntakeECSLogsPath, intake.MonitoringMap, map[request.ResultID]int{
+		request.IDRequestCount:                   1,
+		request.IDResponseCount:                  1,
+		request.IDResponseErrorsCount:            1,
+		request.IDResponseErrorsMethodNotAllowed: 1,
+	})
+}
@@ -49,7 +49,7 @@ func TestOPTIONS(t *testing.T) {
 			requestTaken <- struct{}{}
 			<-done
 		},
-		rumMiddleware(cfg, authenticator, ratelimitStore, intake.MonitoringMap)...)
+		rumMiddleware(cfg, authenticator, ratelimitStore, nil, func() bool { return false }, intake.MonitoringMap)...)
 
 	// use this to block the single allowed concurrent requests
 	go func() {
@@ -79,6 +79,16 @@ func TestRUMHandler_NoAuthorizationRequired(t *testing.T) {
 	approvaltest.ApproveJSON(t, approvalPathIntakeRUM(t.Name()), rec.Body.Bytes())
 }
 
+func TestRUMHandler_DryRunAnonymous(t *testing.T) {
+	cfg := cfgEnabledRUM()
+	cfg.AgentAuth.SecretToken = "1234"
+	req := httptest.NewRequest(http.MethodPost, IntakeRUMPath+"?dry_run=true", nil)
+	rec, err := requestToMuxer(cfg, req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), "dry run requires an authenticated client")
+}
+
 func TestRUMHandler_KillSwitchMiddleware(t *testing.T) {
 	t.Run("OffRum", func(t *testing.T) {
 		rec, err := requestToMuxerWithPattern(config.DefaultConfig(), IntakeRUMPath)
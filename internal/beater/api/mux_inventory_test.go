@@ -0,0 +1,114 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/api/inventory"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/monitoringtest"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestServiceInventoryHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+
+	serviceInventory := modelprocessor.NewServiceInventory(time.Minute)
+	batch := model.Batch{{
+		Service: model.Service{Name: "frontend", Environment: "production"},
+		Agent:   model.Agent{Name: "go", Version: "2.0.0"},
+	}}
+	require.NoError(t, serviceInventory.ProcessBatch(context.Background(), &batch))
+
+	mux, err := muxBuilder{ServiceInventory: serviceInventory}.build(cfg)
+	require.NoError(t, err)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, ServiceInventoryPath, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("Authorized", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, ServiceInventoryPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		var result struct {
+			Services   []modelprocessor.ServiceInventoryEntry
+			Overflowed int64
+		}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		require.Len(t, result.Services, 1)
+		assert.Equal(t, "frontend", result.Services[0].ServiceName)
+		assert.Equal(t, "production", result.Services[0].ServiceEnvironment)
+		assert.Equal(t, int64(1), result.Services[0].Events)
+	})
+
+	t.Run("MethodNotAllowed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, ServiceInventoryPath, nil)
+		req.Header.Set(headers.Authorization, "Bearer 1234")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	})
+}
+
+func TestServiceInventoryHandler_Anonymous(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.AgentAuth.Anonymous.Enabled = true
+	cfg.AgentAuth.Anonymous.AllowAgent = []string{"rum-js"}
+
+	mux, err := muxBuilder{ServiceInventory: modelprocessor.NewServiceInventory(time.Minute)}.build(cfg)
+	require.NoError(t, err)
+	req := httptest.NewRequest(http.MethodGet, ServiceInventoryPath, nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestServiceInventoryHandler_Disabled(t *testing.T) {
+	rec, err := requestToMuxerWithHeader(config.DefaultConfig(), ServiceInventoryPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServiceInventoryHandler_MonitoringMiddleware(t *testing.T) {
+	mux, err := muxBuilder{ServiceInventory: modelprocessor.NewServiceInventory(time.Minute)}.build(config.DefaultConfig())
+	require.NoError(t, err)
+	monitoringtest.ClearRegistry(inventory.MonitoringMap)
+	mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, ServiceInventoryPath, nil))
+	assert.Equal(t, int64(1), inventory.MonitoringMap[request.IDResponseValidOK].Get())
+}
@@ -0,0 +1,153 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+func TestLogLevelHandler(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.LogLevelEndpoint.Enabled = true
+	defer logs.Levels.SetDefaults(logp.InfoLevel, nil)
+	logs.Levels.SetDefaults(logp.InfoLevel, nil)
+
+	mux := newTestMux(t, cfg)
+	send := func(method, body string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, LogLevelPath, strings.NewReader(body))
+		if authorized {
+			req.Header.Set(headers.Authorization, "Bearer 1234")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+	decode := func(rec *httptest.ResponseRecorder) logs.LevelSettings {
+		var settings logs.LevelSettings
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &settings))
+		return settings
+	}
+
+	rec := send(http.MethodGet, "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = send(http.MethodGet, "", true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, logs.LevelSettings{Level: "info", Selectors: []string{}}, decode(rec))
+
+	rec = send(http.MethodPut, `{"level":"debug","selectors":["request"],"duration":"1m"}`, true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	settings := decode(rec)
+	assert.Equal(t, "debug", settings.Level)
+	assert.Equal(t, []string{"request"}, settings.Selectors)
+	assert.NotNil(t, settings.Expires)
+	assert.Equal(t, "debug", logs.Levels.Settings().Level)
+
+	rec = send(http.MethodPut, `{"level":"verbose"}`, true)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = send(http.MethodPut, `{`, true)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	rec = send(http.MethodPost, `{}`, true)
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+
+	rec = send(http.MethodDelete, "", true)
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, logs.LevelSettings{Level: "info", Selectors: []string{}}, decode(rec))
+}
+
+func TestLogLevelHandler_Anonymous(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.AgentAuth.Anonymous.Enabled = true
+	cfg.AgentAuth.Anonymous.AllowAgent = []string{"rum-js"}
+	cfg.LogLevelEndpoint.Enabled = true
+
+	rec, err := requestToMuxerWithHeader(cfg, LogLevelPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+}
+
+func TestLogLevelHandler_APIKey(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Write([]byte(`{
+			"username": "api_key_username",
+			"application": {
+				"apm": {
+					"-": {"config_agent:read": false, "event:write": true, "sourcemap:write": false}
+				}
+			}
+		}`))
+	}))
+	defer srv.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.AgentAuth.SecretToken = "1234"
+	cfg.AgentAuth.APIKey.Enabled = true
+	cfg.AgentAuth.APIKey.LimitPerMin = 100
+	cfg.AgentAuth.APIKey.ESConfig = elasticsearch.DefaultConfig()
+	cfg.AgentAuth.APIKey.ESConfig.Hosts = elasticsearch.Hosts{srv.URL}
+	cfg.LogLevelEndpoint.Enabled = true
+
+	// An API Key with the event:write privilege must not be able
+	// to change the log level.
+	credentials := base64.StdEncoding.EncodeToString([]byte("id_value:key_value"))
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		rec, err := requestToMuxerWithHeader(cfg, LogLevelPath, method, map[string]string{
+			headers.Authorization: headers.APIKey + " " + credentials,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, rec.Code, method)
+	}
+}
+
+func TestLogLevelHandler_AuthDisabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.LogLevelEndpoint.Enabled = true
+	defer logs.Levels.SetDefaults(logp.InfoLevel, nil)
+	logs.Levels.SetDefaults(logp.InfoLevel, nil)
+
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		rec, err := requestToMuxerWithHeader(cfg, LogLevelPath, method, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusForbidden, rec.Code, method)
+	}
+	assert.Equal(t, "info", logs.Levels.Settings().Level)
+}
+
+func TestLogLevelHandler_Disabled(t *testing.T) {
+	rec, err := requestToMuxerWithHeader(config.DefaultConfig(), LogLevelPath, http.MethodGet, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -0,0 +1,44 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package api
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+)
+
+func TestPrometheusDefaultDisabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	recorder, err := requestToMuxerWithPattern(cfg, "/metrics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, recorder.Code)
+}
+
+func TestPrometheusEnabled(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Prometheus.Enabled = true
+	recorder, err := requestToMuxerWithPattern(cfg, "/metrics")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "go_goroutines")
+}
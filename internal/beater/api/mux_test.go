@@ -36,8 +36,11 @@ import (
 	"github.com/elastic/apm-server/internal/beater/monitoringtest"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/datastreamstats"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/sourcemap"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
@@ -150,8 +153,12 @@ func newTestMux(t *testing.T, cfg *config.Config) http.Handler {
 }
 
 type muxBuilder struct {
-	SourcemapFetcher sourcemap.Fetcher
-	Managed          bool
+	SourcemapFetcher    sourcemap.Fetcher
+	ServiceInventory    *modelprocessor.ServiceInventory
+	DataStreamStats     *datastreamstats.Reporter
+	AgentConfigAdoption *agentcfg.Adoption
+	QueueState          func() mapstr.M
+	Managed             bool
 }
 
 func (m muxBuilder) build(cfg *config.Config) (http.Handler, error) {
@@ -164,9 +171,17 @@ func (m muxBuilder) build(cfg *config.Config) (http.Handler, error) {
 		authenticator,
 		agentcfg.NewDirectFetcher(nil),
 		ratelimitStore,
-		m.SourcemapFetcher,
 		m.Managed,
 		func() bool { return true },
+		func() bool { return false },
+		MuxParams{
+			DryRunBatchProcessor: nopBatchProcessor,
+			ServiceInventory:     m.ServiceInventory,
+			DataStreamStats:      m.DataStreamStats,
+			AgentConfigAdoption:  m.AgentConfigAdoption,
+			SourcemapFetcher:     m.SourcemapFetcher,
+			QueueState:           m.QueueState,
+		},
 	)
 }
 
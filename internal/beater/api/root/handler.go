@@ -39,6 +39,16 @@ type HandlerConfig struct {
 	// PublishReady reports whether or not the server is ready for publishing events.
 	PublishReady func() bool
 
+	// BootstrapStatus, if non-nil, returns details of the server's progress
+	// towards being ready for publishing events, which are reported to
+	// authenticated requests.
+	BootstrapStatus func() mapstr.M
+
+	// Capabilities, if non-nil, returns the Elasticsearch license and the
+	// features which require a license, along with whether or not they are
+	// enabled, which are reported to authenticated requests.
+	Capabilities func() mapstr.M
+
 	// Version holds the APM Server version.
 	Version string
 }
@@ -57,6 +67,12 @@ func Handler(cfg HandlerConfig) request.Handler {
 		if cfg.PublishReady != nil {
 			serverInfo["publish_ready"] = cfg.PublishReady()
 		}
+		if cfg.BootstrapStatus != nil {
+			serverInfo["bootstrap"] = cfg.BootstrapStatus()
+		}
+		if cfg.Capabilities != nil {
+			serverInfo["capabilities"] = cfg.Capabilities()
+		}
 
 		c.Result.SetDefault(request.IDResponseValidOK)
 		if c.Authentication.Method != auth.MethodAnonymous {
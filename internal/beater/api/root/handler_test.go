@@ -26,6 +26,7 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/elastic/beats/v7/libbeat/version"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/request"
@@ -75,6 +76,45 @@ func TestRootHandler(t *testing.T) {
 			version.Commit(),
 		), w.Body.String())
 	})
+
+	t.Run("bootstrap", func(t *testing.T) {
+		c, w := rootTestContext()
+		c.Authentication.Method = auth.MethodNone
+
+		Handler(HandlerConfig{
+			PublishReady: func() bool { return false },
+			BootstrapStatus: func() mapstr.M {
+				return mapstr.M{"state": "waiting", "intake": "buffering", "attempts": 1}
+			},
+			Version: "1.2.3",
+		})(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprintf(
+			`{"bootstrap":{"attempts":1,"intake":"buffering","state":"waiting"},"build_date":"0001-01-01T00:00:00Z","build_sha":%q,"publish_ready":false,"version":"1.2.3"}`+"\n",
+			version.Commit(),
+		), w.Body.String())
+	})
+
+	t.Run("capabilities", func(t *testing.T) {
+		c, w := rootTestContext()
+		c.Authentication.Method = auth.MethodNone
+
+		Handler(HandlerConfig{
+			Capabilities: func() mapstr.M {
+				return mapstr.M{"features": mapstr.M{
+					"tail_sampling": mapstr.M{"enabled": false, "required_license": "platinum"},
+				}}
+			},
+			Version: "1.2.3",
+		})(c)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, fmt.Sprintf(
+			`{"build_date":"0001-01-01T00:00:00Z","build_sha":%q,"capabilities":{"features":{"tail_sampling":{"enabled":false,"required_license":"platinum"}}},"version":"1.2.3"}`+"\n",
+			version.Commit(),
+		), w.Body.String())
+	})
 }
 
 func rootTestContext() (*request.Context, *httptest.ResponseRecorder) {
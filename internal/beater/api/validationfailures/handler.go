@@ -0,0 +1,46 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package validationfailures
+
+import (
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/processor/stream"
+)
+
+var (
+	// MonitoringMap holds a mapping for request.IDs to monitoring counters
+	MonitoringMap = request.DefaultMonitoringMapForRegistry(registry)
+	registry      = monitoring.Default.NewRegistry("apm-server.validation_failures")
+)
+
+// Handler returns a request.Handler that reports counts of events failing
+// validation, by field and agent name and version, ordered by descending
+// count.
+func Handler(failures *stream.ValidationFailures) request.Handler {
+	return func(c *request.Context) {
+		recorded, overflowed := failures.Failures()
+		c.Result.SetDefault(request.IDResponseValidOK)
+		c.Result.Body = struct {
+			Failures   []stream.ValidationFailure `json:"failures"`
+			Overflowed int64                      `json:"overflowed"`
+		}{recorded, overflowed}
+		c.WriteResult()
+	}
+}
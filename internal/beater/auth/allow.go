@@ -28,3 +28,10 @@ type allowAuth struct{}
 func (allowAuth) Authorize(context.Context, Action, Resource) error {
 	return nil
 }
+
+// eventTypeAllowed reports whether eventType is allowed, given a set of
+// allowed event types. If allowed is empty, or eventType is unknown, then
+// all event types are allowed.
+func eventTypeAllowed(allowed map[string]bool, eventType string) bool {
+	return len(allowed) == 0 || eventType == "" || allowed[eventType]
+}
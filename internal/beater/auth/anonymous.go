@@ -22,10 +22,11 @@ import (
 	"fmt"
 )
 
-func newAnonymousAuth(allowAgent, allowService []string) *anonymousAuth {
+func newAnonymousAuth(allowAgent, allowService, allowEventType []string) *anonymousAuth {
 	a := &anonymousAuth{
-		allowedAgents:   make(map[string]bool),
-		allowedServices: make(map[string]bool),
+		allowedAgents:     make(map[string]bool),
+		allowedServices:   make(map[string]bool),
+		allowedEventTypes: make(map[string]bool),
 	}
 	for _, name := range allowAgent {
 		a.allowedAgents[name] = true
@@ -33,14 +34,18 @@ func newAnonymousAuth(allowAgent, allowService []string) *anonymousAuth {
 	for _, name := range allowService {
 		a.allowedServices[name] = true
 	}
+	for _, eventType := range allowEventType {
+		a.allowedEventTypes[eventType] = true
+	}
 	return a
 }
 
 // anonymousAuth implements the Authorization interface, allowing anonymous access with
-// optional restriction on agent and service name.
+// optional restriction on agent name, service name, and event type.
 type anonymousAuth struct {
-	allowedAgents   map[string]bool
-	allowedServices map[string]bool
+	allowedAgents     map[string]bool
+	allowedServices   map[string]bool
+	allowedEventTypes map[string]bool
 }
 
 // Authorize checks if anonymous access is authorized for the given action and resource.
@@ -71,6 +76,12 @@ func (a *anonymousAuth) Authorize(ctx context.Context, action Action, resource R
 				ErrUnauthorized, resource.AgentName,
 			)
 		}
+		if !eventTypeAllowed(a.allowedEventTypes, resource.EventType) {
+			return fmt.Errorf(
+				"%w: anonymous access not permitted for event type %q",
+				ErrUnauthorized, resource.EventType,
+			)
+		}
 		return nil
 	case ActionSourcemapUpload:
 		return fmt.Errorf("%w: anonymous access not permitted for sourcemap uploads", ErrUnauthorized)
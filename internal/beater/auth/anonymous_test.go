@@ -32,11 +32,12 @@ import (
 
 func TestAnonymousAuthorizer(t *testing.T) {
 	for name, test := range map[string]struct {
-		allowAgent   []string
-		allowService []string
-		action       auth.Action
-		resource     auth.Resource
-		expectErr    error
+		allowAgent     []string
+		allowService   []string
+		allowEventType []string
+		action         auth.Action
+		resource       auth.Resource
+		expectErr      error
 	}{
 		"deny_sourcemap_upload": {
 			allowAgent:   nil,
@@ -105,6 +106,22 @@ func TestAnonymousAuthorizer(t *testing.T) {
 			resource:   auth.Resource{ServiceName: "opbeans-ios"},
 			expectErr:  fmt.Errorf(`%w: anonymous access not permitted for agent ""`, auth.ErrUnauthorized),
 		},
+		"allow_event_type": {
+			allowEventType: []string{"transaction", "span", "error"},
+			action:         auth.ActionEventIngest,
+			resource:       auth.Resource{AgentName: "rum-js", ServiceName: "opbeans-rum", EventType: "span"},
+		},
+		"deny_event_type": {
+			allowEventType: []string{"transaction", "span", "error"},
+			action:         auth.ActionEventIngest,
+			resource:       auth.Resource{AgentName: "rum-js", ServiceName: "opbeans-rum", EventType: "metric"},
+			expectErr:      fmt.Errorf(`%w: anonymous access not permitted for event type "metric"`, auth.ErrUnauthorized),
+		},
+		"allow_event_type_unspecified": {
+			allowEventType: []string{"transaction"},
+			action:         auth.ActionEventIngest,
+			resource:       auth.Resource{AgentName: "rum-js", ServiceName: "opbeans-rum"}, // EventType not yet known
+		},
 		"deny_event_ingest_service_unspecified": {
 			allowService: []string{"opbeans-ios"},
 			action:       auth.ActionAgentConfig,
@@ -114,9 +131,10 @@ func TestAnonymousAuthorizer(t *testing.T) {
 	} {
 		t.Run(name, func(t *testing.T) {
 			authorizer := getAnonymousAuthorizer(t, config.AnonymousAgentAuth{
-				Enabled:      true,
-				AllowAgent:   test.allowAgent,
-				AllowService: test.allowService,
+				Enabled:        true,
+				AllowAgent:     test.allowAgent,
+				AllowService:   test.allowService,
+				AllowEventType: test.allowEventType,
 			})
 			err := authorizer.Authorize(context.Background(), test.action, test.resource)
 			if test.expectErr != nil {
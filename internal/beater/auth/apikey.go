@@ -67,16 +67,26 @@ func AllPrivilegeActions() []es.PrivilegeAction {
 }
 
 type apikeyAuth struct {
-	esClient es.Client
-	cache    *privilegesCache
+	esClient          es.Client
+	cache             *privilegesCache
+	allowedEventTypes map[string]bool
 }
 
 type apikeyAuthorizer struct {
-	permissions es.Permissions
+	permissions       es.Permissions
+	allowedEventTypes map[string]bool
 }
 
-func newApikeyAuth(client es.Client, cache *privilegesCache) *apikeyAuth {
-	return &apikeyAuth{client, cache}
+func newApikeyAuth(client es.Client, cache *privilegesCache, allowEventType []string) *apikeyAuth {
+	a := &apikeyAuth{
+		esClient:          client,
+		cache:             cache,
+		allowedEventTypes: make(map[string]bool),
+	}
+	for _, eventType := range allowEventType {
+		a.allowedEventTypes[eventType] = true
+	}
+	return a
 }
 
 func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*APIKeyAuthenticationDetails, *apikeyAuthorizer, error) {
@@ -107,7 +117,10 @@ func (a *apikeyAuth) authenticate(ctx context.Context, credentials string) (*API
 		return nil, nil, ErrAuthFailed
 	}
 	details := &APIKeyAuthenticationDetails{ID: id, Username: response.Username}
-	return details, &apikeyAuthorizer{permissions}, nil
+	return details, &apikeyAuthorizer{
+		permissions:       permissions,
+		allowedEventTypes: a.allowedEventTypes,
+	}, nil
 }
 
 func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string, resource es.Resource) (*es.HasPrivilegesResponse, error) {
@@ -154,7 +167,7 @@ func (a *apikeyAuth) hasPrivileges(ctx context.Context, id, credentials string,
 // An API Key is considered to be authorized when the API Key has the configured privileges
 // for the requested resource. Permissions are fetched from Elasticsearch and then cached in
 // a global cache.
-func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, _ Resource) error {
+func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, resource Resource) error {
 	// TODO if resource is non-zero, map to different application resources in the privilege queries.
 	//
 	// For now, having any valid "apm" application API Key grants access to any agent and service.
@@ -171,10 +184,13 @@ func (a *apikeyAuthorizer) Authorize(ctx context.Context, action Action, _ Resou
 	default:
 		return fmt.Errorf("unknown action %q", action)
 	}
-	if a.permissions[apikeyPrivilegeAction] {
-		return nil
+	if !a.permissions[apikeyPrivilegeAction] {
+		return fmt.Errorf("%w: API Key not permitted action %q", ErrUnauthorized, apikeyPrivilegeAction)
+	}
+	if action == ActionEventIngest && !eventTypeAllowed(a.allowedEventTypes, resource.EventType) {
+		return fmt.Errorf("%w: API Key not permitted for event type %q", ErrUnauthorized, resource.EventType)
 	}
-	return fmt.Errorf("%w: API Key not permitted action %q", ErrUnauthorized, apikeyPrivilegeAction)
+	return nil
 }
 
 type privilegesCache struct {
@@ -70,4 +70,17 @@ func TestAPIKeyAuthorizer(t *testing.T) {
 
 	err = authz.Authorize(context.Background(), "unknown", Resource{})
 	assert.EqualError(t, err, `unknown action "unknown"`)
+
+	apikeyAuthConfig.AllowEventType = []string{"transaction", "span"}
+	authenticator, err = NewAuthenticator(config.AgentAuth{APIKey: apikeyAuthConfig})
+	require.NoError(t, err)
+	_, authz, err = authenticator.Authenticate(context.Background(), headers.APIKey, credentials)
+	require.NoError(t, err)
+
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{EventType: "span"})
+	assert.NoError(t, err)
+
+	err = authz.Authorize(context.Background(), ActionEventIngest, Resource{EventType: "log"})
+	assert.EqualError(t, err, `unauthorized: API Key not permitted for event type "log"`)
+	assert.True(t, errors.Is(err, ErrUnauthorized))
 }
@@ -113,6 +113,11 @@ type Resource struct {
 	// the request. This may be empty if the agent is unknown or irrelevant,
 	// such as in a request to the healthcheck endpoint.
 	ServiceName string
+
+	// EventType holds the type of event being ingested, for ActionEventIngest:
+	// one of "transaction", "span", "error", "metric", or "log". This may be
+	// empty if the event type is not yet known, in which case it is not checked.
+	EventType string
 }
 
 // AuthenticationDetails holds authentication details for a client.
@@ -155,10 +160,14 @@ func NewAuthenticator(cfg config.AgentAuth) (*Authenticator, error) {
 		}
 
 		cache := newPrivilegesCache(cacheTimeoutMinute, cfg.APIKey.LimitPerMin)
-		b.apikey = newApikeyAuth(client, cache)
+		b.apikey = newApikeyAuth(client, cache, cfg.APIKey.AllowEventType)
 	}
 	if cfg.Anonymous.Enabled {
-		b.anonymous = newAnonymousAuth(cfg.Anonymous.AllowAgent, cfg.Anonymous.AllowService)
+		b.anonymous = newAnonymousAuth(
+			cfg.Anonymous.AllowAgent,
+			cfg.Anonymous.AllowService,
+			cfg.Anonymous.AllowEventType,
+		)
 	}
 	return &b, nil
 }
@@ -127,11 +127,14 @@ func TestAuthenticatorAPIKey(t *testing.T) {
 			Username: "api_key_username",
 		},
 	}, details)
-	assert.Equal(t, &apikeyAuthorizer{permissions: elasticsearch.Permissions{
-		"config_agent:read": true,
-		"event:write":       true,
-		"sourcemap:write":   false,
-	}}, authz)
+	assert.Equal(t, &apikeyAuthorizer{
+		permissions: elasticsearch.Permissions{
+			"config_agent:read": true,
+			"event:write":       true,
+			"sourcemap:write":   false,
+		},
+		allowedEventTypes: map[string]bool{},
+	}, authz)
 
 	assert.Equal(t, "/_security/user/_has_privileges", requestURLPath)
 	assert.Equal(t, `{"application":[{"application":"apm","privileges":["config_agent:read","event:write","sourcemap:write"],"resources":["-"]}]}`+"\n", string(requestBody))
@@ -294,5 +297,5 @@ func TestAuthenticatorAnonymous(t *testing.T) {
 	details, authz, err = authenticator.Authenticate(context.Background(), "", "")
 	assert.NoError(t, err)
 	assert.Equal(t, AuthenticationDetails{Method: MethodAnonymous}, details)
-	assert.Equal(t, newAnonymousAuth(nil, nil), authz)
+	assert.Equal(t, newAnonymousAuth(nil, nil, nil), authz)
 }
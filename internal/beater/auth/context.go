@@ -27,6 +27,8 @@ var ErrNoAuthorizer = errors.New("no Authorizer in context")
 
 type authorizationKey struct{}
 
+type authenticationDetailsKey struct{}
+
 // ContextWithAuthorizer returns a copy of parent associated with auth.
 func ContextWithAuthorizer(parent context.Context, auth Authorizer) context.Context {
 	return context.WithValue(parent, authorizationKey{}, auth)
@@ -49,3 +51,15 @@ func Authorize(ctx context.Context, action Action, resource Resource) error {
 	}
 	return auth.Authorize(ctx, action, resource)
 }
+
+// ContextWithAuthenticationDetails returns a copy of parent associated with details.
+func ContextWithAuthenticationDetails(parent context.Context, details AuthenticationDetails) context.Context {
+	return context.WithValue(parent, authenticationDetailsKey{}, details)
+}
+
+// AuthenticationDetailsFromContext returns the AuthenticationDetails stored in ctx, if any,
+// and a boolean indicating whether they were found.
+func AuthenticationDetailsFromContext(ctx context.Context) (AuthenticationDetails, bool) {
+	details, ok := ctx.Value(authenticationDetailsKey{}).(AuthenticationDetails)
+	return details, ok
+}
@@ -0,0 +1,199 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+// BatchProcessorParams holds parameters for creating a batch processor
+// with a BatchProcessorFactory.
+type BatchProcessorParams struct {
+	// Config holds the APM Server configuration.
+	Config *config.Config
+
+	// Logger holds a logger for the batch processor to use.
+	Logger *logp.Logger
+}
+
+// BatchProcessorFactory creates a model.BatchProcessor for pre-processing
+// events decoded from agent payloads.
+//
+// If the factory returns a nil model.BatchProcessor and no error, e.g.
+// because the processor is not enabled in the configuration, then no
+// processor is added to the chain.
+type BatchProcessorFactory func(BatchProcessorParams) (model.BatchProcessor, error)
+
+var (
+	batchProcessorsMu       sync.RWMutex
+	batchProcessorFactories = make(map[string]BatchProcessorFactory)
+)
+
+// DefaultBatchProcessors holds the names of the batch processors which
+// are used, in order, when `apm-server.batch_processors` is not set.
+var DefaultBatchProcessors = []string{
+	"future_timestamps",
+	"set_host_hostname",
+	"set_service_node_name",
+	"set_metricset_name",
+	"set_grouping_key",
+	"set_error_message",
+	"set_unknown_span_type",
+	"transaction_duration_histograms",
+	"default_service_environment",
+	"global_labels",
+	"tenant_labels",
+	"string_labels",
+	"validate_span_hierarchy",
+}
+
+func init() {
+	registerStatic := func(name string, processor model.BatchProcessor) {
+		RegisterBatchProcessor(name, func(BatchProcessorParams) (model.BatchProcessor, error) {
+			return processor, nil
+		})
+	}
+	registerStatic("set_host_hostname", modelprocessor.SetHostHostname{})
+	registerStatic("set_service_node_name", modelprocessor.SetServiceNodeName{})
+	registerStatic("set_metricset_name", modelprocessor.SetMetricsetName{})
+	registerStatic("set_grouping_key", modelprocessor.SetGroupingKey{})
+	registerStatic("set_error_message", modelprocessor.SetErrorMessage{})
+	registerStatic("set_unknown_span_type", modelprocessor.SetUnknownSpanType{})
+	RegisterBatchProcessor("future_timestamps", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		processor := modelprocessor.NewFutureTimestamps(
+			modelprocessor.FutureTimestampAction(p.Config.FutureTimestamps.Action),
+			p.Config.FutureTimestamps.MaxSkew,
+		)
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("processor.future_timestamps")
+		monitoring.NewFunc(registry, "processor.future_timestamps", processor.CollectMonitoring, monitoring.Report)
+		return processor, nil
+	})
+	RegisterBatchProcessor("transaction_duration_histograms", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		return modelprocessor.NewTransactionDurationHistograms(
+			p.Config.Aggregation.Transactions.HDRHistogramSignificantFigures,
+		)
+	})
+	RegisterBatchProcessor("default_service_environment", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		if p.Config.DefaultServiceEnvironment == "" {
+			return nil, nil
+		}
+		return &modelprocessor.SetDefaultServiceEnvironment{
+			DefaultServiceEnvironment: p.Config.DefaultServiceEnvironment,
+		}, nil
+	})
+	RegisterBatchProcessor("global_labels", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		if len(p.Config.GlobalLabels) == 0 {
+			return nil, nil
+		}
+		return &modelprocessor.SetGlobalLabels{Labels: p.Config.GlobalLabels}, nil
+	})
+	RegisterBatchProcessor("tenant_labels", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		if !p.Config.AgentAuth.APIKey.Enabled || !p.Config.AgentAuth.APIKey.TenantLabels {
+			return nil, nil
+		}
+		return model.ProcessBatchFunc(tenantLabelsBatchProcessor), nil
+	})
+	RegisterBatchProcessor("string_labels", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		if len(p.Config.StringLabels) == 0 {
+			return nil, nil
+		}
+		return modelprocessor.NewStringLabels(p.Config.StringLabels), nil
+	})
+	RegisterBatchProcessor("validate_span_hierarchy", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		if !p.Config.SpanHierarchy.Enabled {
+			return nil, nil
+		}
+		return modelprocessor.NewSpanHierarchyValidator(
+			modelprocessor.SpanHierarchyAction(p.Config.SpanHierarchy.Action),
+			monitoring.Default.GetRegistry("apm-server"),
+		), nil
+	})
+}
+
+// RegisterBatchProcessor registers a BatchProcessorFactory with the given
+// name, enabling the processor to be added to the pre-processing chain by
+// listing its name in `apm-server.batch_processors`.
+//
+// RegisterBatchProcessor panics if factory is nil, or if a factory has
+// already been registered with the same name. It is intended to be called
+// from package init functions, e.g. by builds extending APM Server.
+func RegisterBatchProcessor(name string, factory BatchProcessorFactory) {
+	if factory == nil {
+		panic("beater: RegisterBatchProcessor factory is nil")
+	}
+	batchProcessorsMu.Lock()
+	defer batchProcessorsMu.Unlock()
+	if _, ok := batchProcessorFactories[name]; ok {
+		panic(fmt.Sprintf("beater: RegisterBatchProcessor called twice for batch processor %q", name))
+	}
+	batchProcessorFactories[name] = factory
+}
+
+// BatchProcessors returns the sorted names of the registered batch processors.
+func BatchProcessors() []string {
+	batchProcessorsMu.RLock()
+	defer batchProcessorsMu.RUnlock()
+	names := make([]string, 0, len(batchProcessorFactories))
+	for name := range batchProcessorFactories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newPreBatchProcessors returns the batch processors for pre-processing
+// events decoded from agent payloads, in the order configured by
+// `apm-server.batch_processors`, or DefaultBatchProcessors if unset.
+func newPreBatchProcessors(params BatchProcessorParams) (modelprocessor.Chained, error) {
+	names := params.Config.BatchProcessors
+	if names == nil {
+		names = DefaultBatchProcessors
+	}
+	batchProcessorsMu.RLock()
+	defer batchProcessorsMu.RUnlock()
+	processors := make(modelprocessor.Chained, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, fmt.Errorf("batch processor %q specified more than once", name)
+		}
+		seen[name] = true
+		factory, ok := batchProcessorFactories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown batch processor %q", name)
+		}
+		processor, err := factory(params)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create batch processor %q: %w", name, err)
+		}
+		if processor != nil {
+			processors = append(processors, processor)
+		}
+	}
+	return processors, nil
+}
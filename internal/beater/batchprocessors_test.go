@@ -0,0 +1,136 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestNewPreBatchProcessorsDefault(t *testing.T) {
+	cfg := config.DefaultConfig()
+	processors, err := newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	transactionDurationHistograms, err := modelprocessor.NewTransactionDurationHistograms(
+		cfg.Aggregation.Transactions.HDRHistogramSignificantFigures,
+	)
+	require.NoError(t, err)
+	require.NotEmpty(t, processors)
+	assert.IsType(t, &modelprocessor.FutureTimestamps{}, processors[0])
+	assert.Equal(t, modelprocessor.Chained{
+		modelprocessor.SetHostHostname{},
+		modelprocessor.SetServiceNodeName{},
+		modelprocessor.SetMetricsetName{},
+		modelprocessor.SetGroupingKey{},
+		modelprocessor.SetErrorMessage{},
+		modelprocessor.SetUnknownSpanType{},
+		transactionDurationHistograms,
+	}, processors[1:])
+
+	cfg.DefaultServiceEnvironment = "production"
+	cfg.GlobalLabels = map[string]string{"cluster": "eu-1"}
+	processors, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	require.Len(t, processors, 10)
+	assert.Equal(t, &modelprocessor.SetDefaultServiceEnvironment{
+		DefaultServiceEnvironment: "production",
+	}, processors[8])
+	assert.Equal(t, &modelprocessor.SetGlobalLabels{
+		Labels: map[string]string{"cluster": "eu-1"},
+	}, processors[9])
+
+	// tenant_labels requires API Key auth to be enabled.
+	cfg.AgentAuth.APIKey.TenantLabels = true
+	processors, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	require.Len(t, processors, 10)
+	cfg.AgentAuth.APIKey.Enabled = true
+	processors, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	require.Len(t, processors, 11)
+	assert.IsType(t, model.ProcessBatchFunc(nil), processors[10])
+}
+
+func TestNewPreBatchProcessorsSpanHierarchy(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.BatchProcessors = []string{"validate_span_hierarchy"}
+	processors, err := newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	assert.Empty(t, processors)
+
+	cfg.SpanHierarchy.Enabled = true
+	processors, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	require.Len(t, processors, 1)
+	assert.IsType(t, &modelprocessor.SpanHierarchyValidator{}, processors[0])
+}
+
+func TestNewPreBatchProcessorsConfigured(t *testing.T) {
+	var calls []string
+	RegisterBatchProcessor("test_processor", func(p BatchProcessorParams) (model.BatchProcessor, error) {
+		return model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			calls = append(calls, p.Config.DefaultServiceEnvironment)
+			return nil
+		}), nil
+	})
+	assert.Contains(t, BatchProcessors(), "test_processor")
+	assert.Panics(t, func() {
+		RegisterBatchProcessor("test_processor", func(BatchProcessorParams) (model.BatchProcessor, error) {
+			return nil, nil
+		})
+	})
+	RegisterBatchProcessor("test_processor_error", func(BatchProcessorParams) (model.BatchProcessor, error) {
+		return nil, errors.New("boom")
+	})
+
+	cfg := config.DefaultConfig()
+	cfg.DefaultServiceEnvironment = "production"
+	cfg.BatchProcessors = []string{"test_processor", "set_host_hostname"}
+	processors, err := newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	require.Len(t, processors, 2)
+	assert.Equal(t, modelprocessor.SetHostHostname{}, processors[1])
+	require.NoError(t, processors.ProcessBatch(context.Background(), &model.Batch{}))
+	assert.Equal(t, []string{"production"}, calls)
+
+	// An empty list disables all pre-processing batch processors.
+	cfg.BatchProcessors = []string{}
+	processors, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	require.NoError(t, err)
+	assert.Empty(t, processors)
+
+	cfg.BatchProcessors = []string{"unknown"}
+	_, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	assert.EqualError(t, err, `unknown batch processor "unknown"`)
+
+	cfg.BatchProcessors = []string{"set_host_hostname", "set_host_hostname"}
+	_, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	assert.EqualError(t, err, `batch processor "set_host_hostname" specified more than once`)
+
+	cfg.BatchProcessors = []string{"test_processor_error"}
+	_, err = newPreBatchProcessors(BatchProcessorParams{Config: cfg})
+	assert.EqualError(t, err, `failed to create batch processor "test_processor_error": boom`)
+}
@@ -19,23 +19,31 @@ package beater
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/dustin/go-humanize"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.elastic.co/apm/module/apmgrpc/v2"
 	"go.elastic.co/apm/module/apmhttp/v2"
 	"go.elastic.co/apm/v2"
 	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 
 	"github.com/elastic/beats/v7/libbeat/beat"
@@ -48,29 +56,53 @@ import (
 	"github.com/elastic/beats/v7/libbeat/publisher/pipeline"
 	"github.com/elastic/beats/v7/libbeat/publisher/pipetool"
 	agentconfig "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/file"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/transport"
 	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 	"github.com/elastic/go-ucfg"
 
 	"github.com/elastic/apm-server/internal/agentcfg"
+	"github.com/elastic/apm-server/internal/alerting"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/interceptors"
 	javaattacher "github.com/elastic/apm-server/internal/beater/java_attacher"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/datastreamstats"
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/fileoutput"
+	"github.com/elastic/apm-server/internal/forwarding"
 	"github.com/elastic/apm-server/internal/idxmgmt"
+	"github.com/elastic/apm-server/internal/ingestpipeline"
+	"github.com/elastic/apm-server/internal/kafkaoutput"
 	"github.com/elastic/apm-server/internal/kibana"
+	"github.com/elastic/apm-server/internal/licensing"
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/memlimit"
 	"github.com/elastic/apm-server/internal/model"
 	"github.com/elastic/apm-server/internal/model/modelindexer"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/internal/otlpoutput"
+	"github.com/elastic/apm-server/internal/outbound"
 	"github.com/elastic/apm-server/internal/publish"
+	"github.com/elastic/apm-server/internal/runtimemetrics"
+	"github.com/elastic/apm-server/internal/selfcheck"
 	"github.com/elastic/apm-server/internal/sourcemap"
 	"github.com/elastic/apm-server/internal/version"
 )
 
+var (
+	// indexerMetrics publishes the stats of the most recently created
+	// modelindexer.Indexer as expvar and Prometheus metrics. The metrics
+	// are registered once, as the indexer is recreated on reload.
+	indexerMetrics         modelindexer.Metrics
+	registerIndexerMetrics sync.Once
+)
+
 // Runner initialises and runs and orchestrates the APM Server
 // HTTP and gRPC servers, event processing pipeline, and output.
 type Runner struct {
@@ -82,6 +114,12 @@ type Runner struct {
 	fleetConfig               *config.Fleet
 	outputConfig              agentconfig.Namespace
 	elasticsearchOutputConfig *agentconfig.C
+	otlpOutputConfig          *agentconfig.C
+	apmServerOutputConfig     *agentconfig.C
+	kafkaOutputConfig         *agentconfig.C
+	fileOutputConfig          *agentconfig.C
+	consoleOutputConfig       *agentconfig.C
+	instrumentationConfig     instrumentationConfig
 
 	listener net.Listener
 }
@@ -105,10 +143,11 @@ type RunnerParams struct {
 // NewRunner returns a new Runner that runs APM Server with the given parameters.
 func NewRunner(args RunnerParams) (*Runner, error) {
 	var unpackedConfig struct {
-		APMServer  *agentconfig.C        `config:"apm-server"`
-		Output     agentconfig.Namespace `config:"output"`
-		Fleet      *config.Fleet         `config:"fleet"`
-		DataStream struct {
+		APMServer       *agentconfig.C        `config:"apm-server"`
+		Output          agentconfig.Namespace `config:"output"`
+		Fleet           *config.Fleet         `config:"fleet"`
+		Instrumentation instrumentationConfig `config:"instrumentation"`
+		DataStream      struct {
 			Namespace string `config:"namespace"`
 		} `config:"data_stream"`
 	}
@@ -116,9 +155,21 @@ func NewRunner(args RunnerParams) (*Runner, error) {
 		return nil, err
 	}
 
-	var elasticsearchOutputConfig *agentconfig.C
-	if unpackedConfig.Output.Name() == "elasticsearch" {
+	var elasticsearchOutputConfig, otlpOutputConfig, apmServerOutputConfig, kafkaOutputConfig *agentconfig.C
+	var fileOutputConfig, consoleOutputConfig *agentconfig.C
+	switch unpackedConfig.Output.Name() {
+	case "elasticsearch":
 		elasticsearchOutputConfig = unpackedConfig.Output.Config()
+	case "otlp":
+		otlpOutputConfig = unpackedConfig.Output.Config()
+	case "apm_server":
+		apmServerOutputConfig = unpackedConfig.Output.Config()
+	case "kafka":
+		kafkaOutputConfig = unpackedConfig.Output.Config()
+	case "file":
+		fileOutputConfig = unpackedConfig.Output.Config()
+	case "console":
+		consoleOutputConfig = unpackedConfig.Output.Config()
 	}
 	cfg, err := config.NewConfig(unpackedConfig.APMServer, elasticsearchOutputConfig)
 	if err != nil {
@@ -144,6 +195,12 @@ func NewRunner(args RunnerParams) (*Runner, error) {
 		fleetConfig:               unpackedConfig.Fleet,
 		outputConfig:              unpackedConfig.Output,
 		elasticsearchOutputConfig: elasticsearchOutputConfig,
+		otlpOutputConfig:          otlpOutputConfig,
+		apmServerOutputConfig:     apmServerOutputConfig,
+		kafkaOutputConfig:         kafkaOutputConfig,
+		fileOutputConfig:          fileOutputConfig,
+		consoleOutputConfig:       consoleOutputConfig,
+		instrumentationConfig:     unpackedConfig.Instrumentation,
 
 		listener: listener,
 	}, nil
@@ -180,8 +237,8 @@ func (s *Runner) Run(ctx context.Context) error {
 	// Obtain the memory limit for the APM Server process. Certain config
 	// values will be sized according to the maximum memory set for the server.
 	var memLimit float64
-	if cgroupReader := newCgroupReader(); cgroupReader != nil {
-		if limit, err := cgroupMemoryLimit(cgroupReader); err != nil {
+	if cgroupReader := memlimit.NewCgroupReader(); cgroupReader != nil {
+		if limit, err := memlimit.CgroupMemoryLimit(cgroupReader); err != nil {
 			s.logger.Warn(err)
 		} else {
 			// Limit the memory to 80% of the cgroup memory limit.
@@ -190,7 +247,7 @@ func (s *Runner) Run(ctx context.Context) error {
 	}
 	if memLimit <= 0 {
 		s.logger.Info("no cgroups detected, falling back to total system memory")
-		if limit, err := systemMemoryLimit(); err != nil {
+		if limit, err := memlimit.SystemMemoryLimit(); err != nil {
 			s.logger.Warn(err)
 		} else {
 			// If no cgroup limit is set, only return 50% of the total memory.
@@ -251,23 +308,36 @@ func (s *Runner) Run(ctx context.Context) error {
 		}
 	}
 
+	setInstrumentationEnv(s.instrumentationConfig)
 	instrumentation, err := instrumentation.New(s.rawConfig, "apm-server", version.Version)
 	if err != nil {
 		return err
 	}
 	tracer := instrumentation.Tracer()
+	applyInstrumentationConfig(tracer, s.instrumentationConfig)
 	tracerServerListener := instrumentation.Listener()
 	if tracerServerListener != nil {
 		defer tracerServerListener.Close()
 	}
 	defer tracer.Close()
 
+	// Send build and runtime information, and enabled inputs
+	// and features, to monitoring for auditing config drift.
+	if err := recordServerState(s.config, s.rawConfig, memLimit, tracerServerListener != nil); err != nil {
+		return err
+	}
+
 	// Ensure the libbeat output and go-elasticsearch clients do not index
 	// any events to Elasticsearch before the integration is ready.
 	publishReady := make(chan struct{})
 	drain := make(chan struct{})
+	bootstrap := &bootstrapStatus{}
+	licenseChecker, err := s.newLicenseChecker()
+	if err != nil {
+		return err
+	}
 	g.Go(func() error {
-		if err := s.waitReady(ctx, kibanaClient, tracer); err != nil {
+		if err := s.waitReady(ctx, kibanaClient, tracer, licenseChecker, bootstrap); err != nil {
 			// One or more preconditions failed; drop events.
 			close(drain)
 			return errors.Wrap(err, "error waiting for server to be ready")
@@ -275,6 +345,11 @@ func (s *Runner) Run(ctx context.Context) error {
 		// All preconditions have been met; start indexing documents
 		// into elasticsearch.
 		close(publishReady)
+		if licenseChecker != nil {
+			// Keep checking the license, enabling or disabling
+			// licensed features as it changes.
+			return licenseChecker.Run(ctx)
+		}
 		return nil
 	})
 	callbackUUID, err := esoutput.RegisterConnectCallback(func(*eslegclient.Connection) error {
@@ -313,18 +388,20 @@ func (s *Runner) Run(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
-		cachingFetcher, err := sourcemap.NewCachingFetcher(
-			fetcher, s.config.RumConfig.SourceMapping.Cache.Expiration,
-		)
+		cachingFetcher, err := sourcemap.NewCachingFetcher(fetcher, sourcemap.CachingFetcherConfig{
+			Expiration:        s.config.RumConfig.SourceMapping.Cache.Expiration,
+			MissExpiration:    s.config.RumConfig.SourceMapping.Cache.MissExpiration,
+			MaxFailureBackoff: s.config.RumConfig.SourceMapping.MaxFailureBackoff,
+		})
 		if err != nil {
 			return err
 		}
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("sourcemap")
+		monitoring.NewFunc(registry, "sourcemap", cachingFetcher.CollectMonitoring, monitoring.Report)
 		sourcemapFetcher = cachingFetcher
 	}
 
-	// Create the runServer function. We start with newBaseRunServer, and then
-	// wrap depending on the configuration in order to inject behaviour.
-	runServer := newBaseRunServer(s.listener)
 	authenticator, err := auth.NewAuthenticator(s.config.AgentAuth)
 	if err != nil {
 		return err
@@ -339,18 +416,35 @@ func (s *Runner) Run(ctx context.Context) error {
 		return err
 	}
 
-	// Note that we intentionally do not use a grpc.Creds ServerOption
-	// even if TLS is enabled, as TLS is handled by the net/http server.
+	var apiKeyRateLimitStore *ratelimit.Store
+	if apiKeyConfig := s.config.AgentAuth.APIKey; apiKeyConfig.Enabled && apiKeyConfig.RateLimit.EventLimit > 0 {
+		apiKeyRateLimitStore, err = ratelimit.NewStore(
+			apiKeyConfig.LimitPerMin,
+			apiKeyConfig.RateLimit.EventLimit,
+			3, // burst mulitiplier
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	// TLS is handled by the net/http server, so we do not use TLS
+	// credentials even if TLS is enabled. gmuxCredentials exposes the
+	// net/http server's TLS connection state to gRPC services instead.
 	gRPCLogger := s.logger.Named("grpc")
-	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(
-		apmgrpc.NewUnaryServerInterceptor(apmgrpc.WithRecovery(), apmgrpc.WithTracer(tracer)),
-		interceptors.ClientMetadata(),
-		interceptors.Logging(gRPCLogger),
-		interceptors.Metrics(gRPCLogger),
-		interceptors.Timeout(),
-		interceptors.Auth(authenticator),
-		interceptors.AnonymousRateLimit(ratelimitStore),
-	))
+	grpcServer := grpc.NewServer(
+		grpc.Creds(gmuxCredentials{}),
+		grpc.ChainUnaryInterceptor(
+			apmgrpc.NewUnaryServerInterceptor(apmgrpc.WithRecovery(), apmgrpc.WithTracer(tracer)),
+			interceptors.ClientMetadata(),
+			interceptors.Logging(gRPCLogger),
+			interceptors.Metrics(gRPCLogger),
+			interceptors.Timeout(),
+			interceptors.Auth(authenticator),
+			interceptors.AnonymousRateLimit(ratelimitStore),
+			interceptors.APIKeyRateLimit(apiKeyRateLimitStore),
+		),
+	)
 
 	// Create the BatchProcessor chain that is used to process all events,
 	// including the metrics aggregated by APM Server.
@@ -360,24 +454,7 @@ func (s *Runner) Run(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	batchProcessor := modelprocessor.Chained{
-		// Ensure all events have observer.*, ecs.*, and data_stream.* fields added,
-		// and are counted in metrics. This is done in the final processors to ensure
-		// aggregated metrics are also processed.
-		newObserverBatchProcessor(),
-		&modelprocessor.SetDataStream{Namespace: s.config.DataStreams.Namespace},
-		modelprocessor.NewEventCounter(monitoring.Default.GetRegistry("apm-server")),
-
-		// The server always drops non-RUM unsampled transactions. We store RUM unsampled
-		// transactions as they are needed by the User Experience app, which performs
-		// aggregations over dimensions that are not available in transaction metrics.
-		//
-		// It is important that this is done just before calling the publisher to
-		// avoid affecting aggregations.
-		modelprocessor.NewDropUnsampled(false /* don't drop RUM unsampled transactions*/),
-		modelprocessor.DroppedSpansStatsDiscarder{},
-		finalBatchProcessor,
-	}
+	batchProcessor := s.newPublishBatchProcessor(finalBatchProcessor)
 
 	agentConfigReporter := agentcfg.NewReporter(
 		newAgentConfigFetcher(s.config, kibanaClient),
@@ -387,8 +464,74 @@ func (s *Runner) Run(ctx context.Context) error {
 		return agentConfigReporter.Run(ctx)
 	})
 
+	if s.config.SelfCheck.Enabled {
+		if s.elasticsearchOutputConfig == nil {
+			s.logger.Warn("self-check requires the Elasticsearch output, disabling")
+		} else {
+			esConfig := elasticsearch.DefaultConfig()
+			if err := s.elasticsearchOutputConfig.Unpack(&esConfig); err != nil {
+				return err
+			}
+			esClient, err := newElasticsearchClient(esConfig)
+			if err != nil {
+				return err
+			}
+			checker := selfcheck.NewChecker(
+				esClient, batchProcessor, s.config.DataStreams.Namespace,
+				s.config.SelfCheck.Interval, s.config.SelfCheck.Timeout,
+			)
+			g.Go(func() error {
+				return checker.Run(ctx)
+			})
+		}
+	}
+
+	if s.config.Alerting.Enabled {
+		alerter := alerting.NewAlerter(newAlertingConfig(s.config), monitoring.Default)
+		g.Go(func() error {
+			return alerter.Run(ctx)
+		})
+	}
+
+	if s.config.RuntimeMetrics.Enabled {
+		publisher := runtimemetrics.NewPublisher(batchProcessor, s.config.RuntimeMetrics.Interval)
+		g.Go(func() error {
+			return publisher.Run(ctx)
+		})
+	}
+
+	var dataStreamStats *datastreamstats.Reporter
+	if s.config.DataStreamStats.Enabled {
+		if s.elasticsearchOutputConfig == nil {
+			s.logger.Warn("data stream stats require the Elasticsearch output, disabling")
+		} else {
+			esConfig := elasticsearch.DefaultConfig()
+			if err := s.elasticsearchOutputConfig.Unpack(&esConfig); err != nil {
+				return err
+			}
+			esClient, err := newElasticsearchClient(esConfig)
+			if err != nil {
+				return err
+			}
+			// Report the data streams of all namespaces when events
+			// are routed to namespaces by service environment.
+			namespace := s.config.DataStreams.Namespace
+			if s.config.DataStreams.NamespaceFromEnvironment {
+				namespace = ""
+			}
+			dataStreamStats = datastreamstats.NewReporter(esClient, namespace, s.config.DataStreamStats.Interval)
+			registry := monitoring.Default.GetRegistry("apm-server")
+			registry.Remove("data_streams")
+			monitoring.NewFunc(registry, "data_streams", dataStreamStats.CollectMonitoring, monitoring.Report)
+			g.Go(func() error {
+				return dataStreamStats.Run(ctx)
+			})
+		}
+	}
+
 	// Create the runServer function. We start with newBaseRunServer, and then
 	// wrap depending on the configuration in order to inject behaviour.
+	runServer := newBaseRunServer(s.listener)
 	serverParams := ServerParams{
 		Config:                 s.config,
 		Managed:                s.fleetConfig != nil,
@@ -397,13 +540,22 @@ func (s *Runner) Run(ctx context.Context) error {
 		Tracer:                 tracer,
 		Authenticator:          authenticator,
 		RateLimitStore:         ratelimitStore,
+		APIKeyRateLimitStore:   apiKeyRateLimitStore,
 		BatchProcessor:         batchProcessor,
 		AgentConfig:            agentConfigReporter,
 		SourcemapFetcher:       sourcemapFetcher,
 		PublishReady:           publishReady,
+		BootstrapStatus:        bootstrap.Status,
+		LicenseChecker:         licenseChecker,
 		KibanaClient:           kibanaClient,
 		NewElasticsearchClient: newElasticsearchClient,
 		GRPCServer:             grpcServer,
+		DataStreamStats:        dataStreamStats,
+	}
+	if indexer, ok := finalBatchProcessor.(*modelindexer.Indexer); ok {
+		serverParams.QueueState = func() mapstr.M {
+			return mapstr.M{"output": indexer.QueueState()}
+		}
 	}
 	if s.wrapServer != nil {
 		// Wrap the serverParams and runServer function, enabling
@@ -422,45 +574,128 @@ func (s *Runner) Run(ctx context.Context) error {
 		// processor chain.
 		model.ProcessBatchFunc(rateLimitBatchProcessor),
 		model.ProcessBatchFunc(authorizeEventIngestProcessor),
+	}
+	if len(s.config.Throttles) > 0 {
+		// Scheduled throttles are applied after authorization, so events
+		// from unauthorized clients do not consume the throttles' limits.
+		schedule, err := newThrottleSchedule(s.config.Throttles)
+		if err != nil {
+			return err
+		}
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("throttles")
+		monitoring.NewFunc(registry, "throttles", schedule.CollectMonitoring, monitoring.Report)
+		preBatchProcessors = append(preBatchProcessors, newThrottleBatchProcessor(schedule))
+	}
 
-		// Pre-process events before they are sent to the final processors for
-		// aggregation, sampling, and indexing.
-		modelprocessor.SetHostHostname{},
-		modelprocessor.SetServiceNodeName{},
-		modelprocessor.SetMetricsetName{},
-		modelprocessor.SetGroupingKey{},
-		modelprocessor.SetErrorMessage{},
-		modelprocessor.SetUnknownSpanType{},
-	}
-	if s.config.DefaultServiceEnvironment != "" {
-		preBatchProcessors = append(preBatchProcessors, &modelprocessor.SetDefaultServiceEnvironment{
-			DefaultServiceEnvironment: s.config.DefaultServiceEnvironment,
-		})
+	// Pre-process events before they are sent to the final processors for
+	// aggregation, sampling, and indexing. The processors and their order
+	// may be configured with `apm-server.batch_processors`.
+	configuredBatchProcessors, err := newPreBatchProcessors(BatchProcessorParams{
+		Config: s.config,
+		Logger: s.logger,
+	})
+	if err != nil {
+		return err
+	}
+	preBatchProcessors = append(preBatchProcessors, configuredBatchProcessors...)
+
+	// Record the services of pre-processed events in the service inventory,
+	// excluding events received by intake dry run requests.
+	publishBatchProcessors := preBatchProcessors[:len(preBatchProcessors):len(preBatchProcessors)]
+	if s.config.ServiceInventory.Enabled {
+		serviceInventory := modelprocessor.NewServiceInventory(s.config.ServiceInventory.Window)
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("service_inventory")
+		monitoring.NewFunc(registry, "service_inventory", serviceInventory.CollectMonitoring, monitoring.Report)
+		serverParams.ServiceInventory = serviceInventory
+		publishBatchProcessors = append(publishBatchProcessors, serviceInventory)
+	}
+
+	// Track the agent config applied by agents, as reported in the metadata
+	// of pre-processed events, and the agent config served to them.
+	if s.config.KibanaAgentConfig.Adoption.Enabled {
+		agentConfigAdoption := agentcfg.NewAdoption(s.config.KibanaAgentConfig.Adoption.Window)
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("agent_config_adoption")
+		monitoring.NewFunc(registry, "agent_config_adoption", agentConfigAdoption.CollectMonitoring, monitoring.Report)
+		serverParams.AgentConfigAdoption = agentConfigAdoption
+		serverParams.AgentConfig = agentConfigAdoption.Fetcher(serverParams.AgentConfig)
+		publishBatchProcessors = append(publishBatchProcessors, agentConfigAdoption)
+	}
+	serverParams.BatchProcessor = append(publishBatchProcessors, serverParams.BatchProcessor)
+
+	// Measure the cost of processing a sample of batches, by service and
+	// event type, through the whole processor chain.
+	if s.config.CostAccounting.Enabled {
+		costAccounting := modelprocessor.NewCostAccounting(
+			serverParams.BatchProcessor,
+			s.config.CostAccounting.SampleRate,
+			s.config.CostAccounting.MaxServices,
+		)
+		registry := monitoring.Default.GetRegistry("apm-server")
+		registry.Remove("cost")
+		monitoring.NewFunc(registry, "cost", costAccounting.CollectMonitoring, monitoring.Report)
+		serverParams.BatchProcessor = costAccounting
+	}
+
+	// Intake dry run requests are pre-processed like any other events,
+	// and then prepared for publishing, but are returned to the client
+	// rather than being aggregated, sampled, or indexed.
+	serverParams.DryRunBatchProcessor = modelprocessor.Chained{
+		preBatchProcessors,
+		s.newDryRunBatchProcessor(),
 	}
-	serverParams.BatchProcessor = append(preBatchProcessors, serverParams.BatchProcessor)
 
 	// Start the main server and the optional server for self-instrumentation.
+	//
+	// The self-instrumentation server is stopped after the main server, so
+	// the traces recorded while the main server is stopping are flushed and
+	// processed before the final batch processor is closed.
+	serverStopped := make(chan struct{})
 	g.Go(func() error {
+		defer close(serverStopped)
 		return runServer(ctx, serverParams)
 	})
+	closeSelfInstrumentationIndexer := func(context.Context) error { return nil }
 	if tracerServerListener != nil {
-		tracerServer, err := newTracerServer(s.config, tracerServerListener, s.logger, serverParams.BatchProcessor)
+		tracerBatchProcessor := serverParams.BatchProcessor
+		selfInstrumentationIndexer, err := s.newSelfInstrumentationIndexer(newElasticsearchClient)
+		if err != nil {
+			return fmt.Errorf("failed to create self-instrumentation indexer: %w", err)
+		}
+		if selfInstrumentationIndexer != nil {
+			// Self-instrumentation events are pre-processed like agent events,
+			// but bypass aggregation and sampling, and are published with their
+			// own indexer to isolate them from backpressure caused by agents.
+			closeSelfInstrumentationIndexer = selfInstrumentationIndexer.Close
+			tracerBatchProcessor = append(
+				preBatchProcessors[:len(preBatchProcessors):len(preBatchProcessors)],
+				s.newPublishBatchProcessor(selfInstrumentationIndexer),
+			)
+		}
+		// The in-process tracer only fetches agent configuration from
+		// Kibana if central configuration is enabled for it, allowing
+		// its settings to be adjusted at runtime.
+		tracerAgentConfig := newAgentConfigFetcher(s.config, nil /* kibana client */)
+		if s.instrumentationConfig.CentralConfig {
+			tracerAgentConfig = agentConfigReporter
+		}
+		tracerServer, err := newTracerServer(s.config, tracerServerListener, s.logger, tracerBatchProcessor, tracerAgentConfig)
 		if err != nil {
 			return fmt.Errorf("failed to create self-instrumentation server: %w", err)
 		}
+		g.Go(tracerServer.serve)
 		g.Go(func() error {
-			if err := tracerServer.Serve(tracerServerListener); err != http.ErrServerClosed {
-				return err
-			}
-			return nil
+			<-serverStopped
+			return tracerServer.stop(backgroundContext, tracer)
 		})
-		go func() {
-			<-ctx.Done()
-			tracerServer.Shutdown(backgroundContext)
-		}()
 	}
 
 	result := g.Wait()
+	if err := closeSelfInstrumentationIndexer(backgroundContext); err != nil {
+		result = multierror.Append(result, err)
+	}
 	if err := closeFinalBatchProcessor(backgroundContext); err != nil {
 		result = multierror.Append(result, err)
 	}
@@ -477,11 +712,42 @@ func maxConcurrentDecoders(memLimitGB float64) uint {
 	return decoders
 }
 
-// waitReady waits until the server is ready to index events.
+// newLicenseChecker returns a licensing.Checker for the configured features
+// which require a license level higher than Basic, or nil if there are none
+// or the Elasticsearch output is not configured.
+//
+// libbeat and go-elasticsearch both ensure a minimum level of Basic.
+func (s *Runner) newLicenseChecker() (*licensing.Checker, error) {
+	var features []licensing.Feature
+	if s.config.Sampling.Tail.Enabled {
+		features = append(features, licensing.Feature{
+			Name:        licensing.FeatureTailSampling,
+			Description: "tail-based sampling",
+			License:     licenser.Platinum,
+		})
+	}
+	if len(features) == 0 || s.elasticsearchOutputConfig == nil {
+		return nil, nil
+	}
+	esConfig := elasticsearch.DefaultConfig()
+	if err := s.elasticsearchOutputConfig.Unpack(&esConfig); err != nil {
+		return nil, err
+	}
+	client, err := elasticsearch.NewClient(esConfig)
+	if err != nil {
+		return nil, err
+	}
+	return licensing.NewChecker(client, licenseCheckInterval, s.logger, features...), nil
+}
+
+// waitReady waits until the server is ready to index events, recording
+// its progress in status.
 func (s *Runner) waitReady(
 	ctx context.Context,
 	kibanaClient *kibana.Client,
 	tracer *apm.Tracer,
+	licenseChecker *licensing.Checker,
+	status *bootstrapStatus,
 ) error {
 	var preconditions []func(context.Context) error
 	var esOutputClient elasticsearch.Client
@@ -497,53 +763,52 @@ func (s *Runner) waitReady(
 		}
 	}
 
-	// libbeat and go-elasticsearch both ensure a minimum level of Basic.
-	//
-	// If any configured features require a higher license level, add a
-	// precondition which checks this.
+	// Check the Elasticsearch license before indexing any events, so
+	// that features it does not cover are disabled from the outset.
+	if licenseChecker != nil {
+		preconditions = append(preconditions, func(ctx context.Context) error {
+			if err := licenseChecker.Check(ctx); err != nil {
+				return &preconditionError{failure: preconditionFailureLicense, err: err}
+			}
+			return nil
+		})
+	}
 	if esOutputClient != nil {
-		requiredLicenseLevel := licenser.Basic
-		licensedFeature := ""
-		if s.config.Sampling.Tail.Enabled {
-			requiredLicenseLevel = licenser.Platinum
-			licensedFeature = "tail-based sampling"
-		}
-		if requiredLicenseLevel > licenser.Basic {
-			preconditions = append(preconditions, func(ctx context.Context) error {
-				license, err := elasticsearch.GetLicense(ctx, esOutputClient)
-				if err != nil {
-					return errors.Wrap(err, "error getting Elasticsearch licensing information")
-				}
-				if licenser.IsExpired(license) {
-					return errors.New("Elasticsearch license is expired")
-				}
-				if license.Type == licenser.Trial || license.Cover(requiredLicenseLevel) {
-					return nil
-				}
-				return fmt.Errorf(
-					"invalid license level %s: %s requires license level %s",
-					license.Type, licensedFeature, requiredLicenseLevel,
-				)
-			})
-		}
 		preconditions = append(preconditions, func(ctx context.Context) error {
 			return queryClusterUUID(ctx, esOutputClient)
 		})
 	}
 
+	// When running standalone, optionally create or update the ingest
+	// pipelines we depend on before indexing any events.
+	fleetManaged := s.fleetConfig != nil
+	if !fleetManaged && s.config.Register.Ingest.Pipeline.Enabled {
+		if esOutputClient == nil {
+			return errors.New("cannot register ingest pipelines without Elasticsearch output config")
+		}
+		overwrite := s.config.Register.Ingest.Pipeline.Overwrite
+		logger := s.logger.Named(logs.Pipelines)
+		preconditions = append(preconditions, func(ctx context.Context) error {
+			return ingestpipeline.Setup(ctx, esOutputClient, overwrite, logger)
+		})
+	}
+
 	// When running standalone with data streams enabled, by default we will add
 	// a precondition that ensures the integration is installed.
-	fleetManaged := s.fleetConfig != nil
 	if !fleetManaged && s.config.DataStreams.WaitForIntegration {
 		if kibanaClient == nil && esOutputClient == nil {
 			return errors.New("cannot wait for integration without either Kibana or Elasticsearch config")
 		}
 		preconditions = append(preconditions, func(ctx context.Context) error {
-			return checkIntegrationInstalled(ctx, kibanaClient, esOutputClient, s.logger)
+			if err := checkIntegrationInstalled(ctx, kibanaClient, esOutputClient, s.logger); err != nil {
+				return &preconditionError{failure: preconditionFailureIntegration, err: err}
+			}
+			return nil
 		})
 	}
 
 	if len(preconditions) == 0 {
+		status.setReady(time.Now())
 		return nil
 	}
 	check := func(ctx context.Context) error {
@@ -554,7 +819,138 @@ func (s *Runner) waitReady(
 		}
 		return nil
 	}
-	return waitReady(ctx, s.config.WaitReadyInterval, tracer, s.logger, check)
+	return waitReady(
+		ctx, s.config.WaitReadyInterval, s.config.WaitReadyMaxInterval,
+		tracer, s.logger, status, check,
+	)
+}
+
+// licenseCheckInterval holds the interval at which the Elasticsearch license
+// is checked for changes, once the server is ready to index events.
+const licenseCheckInterval = time.Minute
+
+// selfInstrumentationEventBufferSize holds the number of self-instrumentation
+// events that may be buffered before they are added to a bulk request.
+const selfInstrumentationEventBufferSize = 100
+
+// minSigningKeyLength holds the minimum length of output.elasticsearch.signing.key,
+// matching the output size of the HMAC-SHA256 hash function.
+const minSigningKeyLength = 32
+
+// newAlertingConfig returns the alerting.Config for cfg, with the configured
+// ingest health conditions.
+func newAlertingConfig(cfg *config.Config) alerting.Config {
+	alertingConfig := alerting.Config{
+		WebhookURL: cfg.Alerting.Webhook.URL,
+		Interval:   cfg.Alerting.Interval,
+		Duration:   cfg.Alerting.Duration,
+		Timeout:    cfg.Alerting.Webhook.Timeout,
+	}
+	if cfg.Alerting.IndexerFailover {
+		alertingConfig.Conditions = append(alertingConfig.Conditions, alerting.IndexerFailover())
+	}
+	if ratio := cfg.Alerting.IndexingFailureRatio; ratio > 0 {
+		alertingConfig.Conditions = append(alertingConfig.Conditions, alerting.IndexingFailureRatio(ratio))
+	}
+	if ratio := cfg.Alerting.TailSamplingStorageRatio; ratio > 0 && cfg.Sampling.Tail.Enabled {
+		alertingConfig.Conditions = append(alertingConfig.Conditions, alerting.TailSamplingStorageRatio(
+			ratio, cfg.Sampling.Tail.StorageLimitParsed,
+		))
+	}
+	return alertingConfig
+}
+
+// newPublishBatchProcessor returns a model.BatchProcessor that prepares events
+// for publishing, and then passes them to finalBatchProcessor.
+func (s *Runner) newPublishBatchProcessor(finalBatchProcessor model.BatchProcessor) modelprocessor.Chained {
+	return modelprocessor.Chained{
+		// Ensure all events have observer.*, ecs.*, and data_stream.* fields added,
+		// and are counted in metrics. This is done in the final processors to ensure
+		// aggregated metrics are also processed.
+		newObserverBatchProcessor(s.config.Provenance.Enabled),
+		&modelprocessor.SetDataStream{
+			Namespace:                s.config.DataStreams.Namespace,
+			NamespaceFromEnvironment: s.config.DataStreams.NamespaceFromEnvironment,
+		},
+		modelprocessor.NewEventCounter(monitoring.Default.GetRegistry("apm-server")),
+
+		// The server always drops non-RUM unsampled transactions. We store RUM unsampled
+		// transactions as they are needed by the User Experience app, which performs
+		// aggregations over dimensions that are not available in transaction metrics.
+		//
+		// It is important that this is done just before calling the publisher to
+		// avoid affecting aggregations.
+		modelprocessor.NewDropUnsampled(false /* don't drop RUM unsampled transactions*/),
+		modelprocessor.DroppedSpansStatsDiscarder{},
+
+		// Trace the time spent handing events over to the output,
+		// for intake requests traced by self-instrumentation.
+		&modelprocessor.Traced{
+			Processor: finalBatchProcessor,
+			Name:      "Enqueue",
+			Type:      "output",
+		},
+	}
+}
+
+// newDryRunBatchProcessor returns a model.BatchProcessor that prepares events
+// for publishing like newPublishBatchProcessor, without publishing them or
+// recording them in metrics.
+func (s *Runner) newDryRunBatchProcessor() modelprocessor.Chained {
+	return modelprocessor.Chained{
+		newObserverBatchProcessor(s.config.Provenance.Enabled),
+		&modelprocessor.SetDataStream{
+			Namespace:                s.config.DataStreams.Namespace,
+			NamespaceFromEnvironment: s.config.DataStreams.NamespaceFromEnvironment,
+		},
+	}
+}
+
+// newSelfInstrumentationIndexer returns a modelindexer.Indexer for indexing
+// the server's own self-instrumentation events, or nil if the output is not
+// "elasticsearch".
+//
+// The indexer is deliberately small and is not traced: it is isolated from
+// the indexer used for events sent by agents, so self-instrumentation events
+// are still indexed when the server is overloaded by agent events, and it
+// does not generate further self-instrumentation events for its own requests.
+func (s *Runner) newSelfInstrumentationIndexer(
+	newElasticsearchClient func(cfg *elasticsearch.Config) (elasticsearch.Client, error),
+) (*modelindexer.Indexer, error) {
+	if s.elasticsearchOutputConfig == nil {
+		return nil, nil
+	}
+	esConfig := elasticsearch.DefaultConfig()
+	if err := s.elasticsearchOutputConfig.Unpack(&esConfig); err != nil {
+		return nil, err
+	}
+	client, err := newElasticsearchClient(esConfig)
+	if err != nil {
+		return nil, err
+	}
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		CompressionLevel: esConfig.CompressionLevel,
+		MaxRequests:      1,
+		FlushInterval:    time.Second,
+		EventBufferSize:  selfInstrumentationEventBufferSize,
+		Scaling:          modelindexer.ScalingConfig{Disabled: true},
+	})
+	if err != nil {
+		return nil, err
+	}
+	monitoring.Default.Remove("output.elasticsearch.self_instrumentation")
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.self_instrumentation", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("added")
+		v.OnInt(stats.Added)
+		v.OnKey("indexed")
+		v.OnInt(stats.Indexed)
+		v.OnKey("failed")
+		v.OnInt(stats.Failed)
+	})
+	return indexer, nil
 }
 
 // newFinalBatchProcessor returns the final model.BatchProcessor that publishes events,
@@ -568,6 +964,21 @@ func (s *Runner) newFinalBatchProcessor(
 
 	monitoring.Default.Remove("libbeat")
 	libbeatMonitoringRegistry := monitoring.Default.NewRegistry("libbeat")
+	if s.otlpOutputConfig != nil {
+		return s.newOTLPFinalBatchProcessor(libbeatMonitoringRegistry)
+	}
+	if s.apmServerOutputConfig != nil {
+		return s.newAPMServerFinalBatchProcessor(libbeatMonitoringRegistry)
+	}
+	if s.kafkaOutputConfig != nil {
+		return s.newKafkaFinalBatchProcessor(tracer, libbeatMonitoringRegistry, memLimit)
+	}
+	if s.fileOutputConfig != nil {
+		return s.newFileFinalBatchProcessor(tracer, libbeatMonitoringRegistry, memLimit)
+	}
+	if s.consoleOutputConfig != nil {
+		return s.newConsoleFinalBatchProcessor(tracer, libbeatMonitoringRegistry, memLimit)
+	}
 	if s.elasticsearchOutputConfig == nil {
 		return s.newLibbeatFinalBatchProcessor(tracer, libbeatMonitoringRegistry)
 	}
@@ -581,44 +992,24 @@ func (s *Runner) newFinalBatchProcessor(
 	}
 	monitoring.NewString(outputRegistry, "name").Set("elasticsearch")
 
-	var esConfig struct {
-		*elasticsearch.Config `config:",inline"`
-		FlushBytes            string        `config:"flush_bytes"`
-		FlushInterval         time.Duration `config:"flush_interval"`
-		MaxRequests           int           `config:"max_requests"`
-		Scaling               struct {
-			Enabled *bool `config:"enabled"`
-		} `config:"autoscaling"`
-	}
-	esConfig.FlushInterval = time.Second
-	esConfig.Config = elasticsearch.DefaultConfig()
+	esConfig := defaultElasticsearchOutputConfig()
 	if err := s.elasticsearchOutputConfig.Unpack(&esConfig); err != nil {
 		return nil, nil, err
 	}
-
-	var flushBytes int
-	if esConfig.FlushBytes != "" {
-		b, err := humanize.ParseBytes(esConfig.FlushBytes)
-		if err != nil {
-			return nil, nil, errors.Wrap(err, "failed to parse flush_bytes")
-		}
-		flushBytes = int(b)
+	opts, err := esConfig.indexerConfig(tracer)
+	if err != nil {
+		return nil, nil, err
 	}
 	client, err := newElasticsearchClient(esConfig.Config)
 	if err != nil {
 		return nil, nil, err
 	}
-	var scalingCfg modelindexer.ScalingConfig
-	if enabled := esConfig.Scaling.Enabled; enabled != nil {
-		scalingCfg.Disabled = !*enabled
-	}
-	opts := modelindexer.Config{
-		CompressionLevel: esConfig.CompressionLevel,
-		FlushBytes:       flushBytes,
-		FlushInterval:    esConfig.FlushInterval,
-		Tracer:           tracer,
-		MaxRequests:      esConfig.MaxRequests,
-		Scaling:          scalingCfg,
+	if esConfig.Failover.Elasticsearch != nil {
+		standbyClient, err := newElasticsearchClient(esConfig.Failover.Elasticsearch)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to create standby elasticsearch client")
+		}
+		opts.Failover.Client = standbyClient
 	}
 	opts = modelIndexerConfig(opts, memLimit, s.logger)
 	indexer, err := modelindexer.New(client, opts)
@@ -626,6 +1017,229 @@ func (s *Runner) newFinalBatchProcessor(
 		return nil, nil, err
 	}
 
+	registerIndexerMetrics.Do(func() {
+		expvar.Publish("apm-server.modelindexer", &indexerMetrics)
+		prometheus.MustRegister(&indexerMetrics)
+	})
+	indexerMetrics.SetIndexer(indexer)
+
+	registerModelIndexerMonitoring(indexer, libbeatMonitoringRegistry)
+	return indexer, indexer.Close, nil
+}
+
+// elasticsearchOutputConfig holds the configuration of the Elasticsearch
+// output, including the configuration of the modelindexer used for it.
+type elasticsearchOutputConfig struct {
+	*elasticsearch.Config `config:",inline"`
+	FlushBytes            string        `config:"flush_bytes"`
+	MaxRequestBytes       string        `config:"max_request_bytes"`
+	FlushDocs             int           `config:"flush_docs"`
+	FlushInterval         time.Duration `config:"flush_interval"`
+	FlushTimeout          time.Duration `config:"flush_timeout"`
+	FlushTimeoutAttempts  int           `config:"flush_timeout_max_attempts"`
+	MaxRequests           int           `config:"max_requests"`
+	OrderByTrace          bool          `config:"order_by_trace"`
+	Scaling               struct {
+		Enabled *bool `config:"enabled"`
+	} `config:"autoscaling"`
+	Failover struct {
+		Threshold     time.Duration         `config:"threshold"`
+		ProbeInterval time.Duration         `config:"probe_interval"`
+		Elasticsearch *elasticsearch.Config `config:"elasticsearch"`
+	} `config:"failover"`
+	CircuitBreaker struct {
+		Threshold int           `config:"threshold"`
+		Backoff   time.Duration `config:"backoff"`
+	} `config:"circuit_breaker"`
+	Signing struct {
+		Key   string `config:"key"`
+		KeyID string `config:"key_id"`
+	} `config:"signing"`
+	Encoder              string `config:"encoder"`
+	Compression          string `config:"compression"`
+	MappingErrorRollover struct {
+		Enabled   bool          `config:"enabled"`
+		Threshold int           `config:"threshold"`
+		Interval  time.Duration `config:"interval"`
+	} `config:"mapping_error_rollover"`
+	CreateDataStreams   bool              `config:"create_data_streams"`
+	WaitForIndexing     bool              `config:"wait_for_indexing"`
+	Pipeline            string            `config:"pipeline"`
+	DataStreamPipelines map[string]string `config:"data_stream_pipelines"`
+	DocumentRetry       struct {
+		MaxAttempts int `config:"max_attempts"`
+		Backoff     struct {
+			Init time.Duration `config:"init"`
+			Max  time.Duration `config:"max"`
+		} `config:"backoff"`
+	} `config:"document_retry"`
+	DeadLetter struct {
+		Index string `config:"index"`
+	} `config:"dead_letter"`
+	UnavailableBuffer struct {
+		MaxBytes      string        `config:"max_bytes"`
+		MaxAge        time.Duration `config:"max_age"`
+		RetryInterval time.Duration `config:"retry_interval"`
+	} `config:"unavailable_buffer"`
+	DataStreamFlush map[string]struct {
+		FlushBytes    string        `config:"flush_bytes"`
+		FlushInterval time.Duration `config:"flush_interval"`
+	} `config:"data_stream_flush"`
+	Priority struct {
+		DataStreamTypes []string `config:"data_stream_types"`
+		ProcessorEvents []string `config:"processor_events"`
+	} `config:"priority"`
+}
+
+func defaultElasticsearchOutputConfig() elasticsearchOutputConfig {
+	return elasticsearchOutputConfig{
+		Config:        elasticsearch.DefaultConfig(),
+		FlushInterval: time.Second,
+	}
+}
+
+// indexerConfig validates cfg and returns the modelindexer.Config for it,
+// excluding the Elasticsearch client used for failover.
+func (cfg elasticsearchOutputConfig) indexerConfig(tracer *apm.Tracer) (modelindexer.Config, error) {
+	var flushBytes int
+	if cfg.FlushBytes != "" {
+		b, err := humanize.ParseBytes(cfg.FlushBytes)
+		if err != nil {
+			return modelindexer.Config{}, errors.Wrap(err, "failed to parse flush_bytes")
+		}
+		flushBytes = int(b)
+	}
+	var maxRequestBytes int
+	if cfg.MaxRequestBytes != "" {
+		b, err := humanize.ParseBytes(cfg.MaxRequestBytes)
+		if err != nil {
+			return modelindexer.Config{}, errors.Wrap(err, "failed to parse max_request_bytes")
+		}
+		maxRequestBytes = int(b)
+	}
+	var unavailableBufferMaxBytes int
+	if cfg.UnavailableBuffer.MaxBytes != "" {
+		b, err := humanize.ParseBytes(cfg.UnavailableBuffer.MaxBytes)
+		if err != nil {
+			return modelindexer.Config{}, errors.Wrap(err, "failed to parse unavailable_buffer.max_bytes")
+		}
+		unavailableBufferMaxBytes = int(b)
+	}
+	var dataStreamFlush map[string]modelindexer.FlushConfig
+	if len(cfg.DataStreamFlush) > 0 {
+		dataStreamFlush = make(map[string]modelindexer.FlushConfig, len(cfg.DataStreamFlush))
+		for dataStreamType, flushCfg := range cfg.DataStreamFlush {
+			switch dataStreamType {
+			case "traces", "logs", "metrics":
+			default:
+				return modelindexer.Config{}, fmt.Errorf(
+					"invalid data_stream_flush data stream type %q, expected one of traces, logs, or metrics",
+					dataStreamType,
+				)
+			}
+			flush := modelindexer.FlushConfig{FlushInterval: flushCfg.FlushInterval}
+			if flushCfg.FlushBytes != "" {
+				b, err := humanize.ParseBytes(flushCfg.FlushBytes)
+				if err != nil {
+					return modelindexer.Config{}, errors.Wrapf(err, "failed to parse data_stream_flush.%s.flush_bytes", dataStreamType)
+				}
+				flush.FlushBytes = int(b)
+			}
+			dataStreamFlush[dataStreamType] = flush
+		}
+	}
+	for _, dataStreamType := range cfg.Priority.DataStreamTypes {
+		switch dataStreamType {
+		case "traces", "logs", "metrics":
+		default:
+			return modelindexer.Config{}, fmt.Errorf(
+				"invalid priority data stream type %q, expected one of traces, logs, or metrics",
+				dataStreamType,
+			)
+		}
+	}
+	for _, processorEvent := range cfg.Priority.ProcessorEvents {
+		switch processorEvent {
+		case "transaction", "span", "error", "metric", "log":
+		default:
+			return modelindexer.Config{}, fmt.Errorf(
+				"invalid priority processor event %q, expected one of transaction, span, error, metric, or log",
+				processorEvent,
+			)
+		}
+	}
+	if key := cfg.Signing.Key; key != "" && len(key) < minSigningKeyLength {
+		return modelindexer.Config{}, fmt.Errorf(
+			"invalid signing.key: must be at least %d bytes, got %d",
+			minSigningKeyLength, len(key),
+		)
+	}
+	var scalingCfg modelindexer.ScalingConfig
+	if enabled := cfg.Scaling.Enabled; enabled != nil {
+		scalingCfg.Disabled = !*enabled
+	}
+	failoverCfg := modelindexer.FailoverConfig{
+		Threshold:     cfg.Failover.Threshold,
+		ProbeInterval: cfg.Failover.ProbeInterval,
+	}
+	return modelindexer.Config{
+		CompressionLevel:   cfg.CompressionLevel,
+		Compression:        cfg.Compression,
+		FlushBytes:         flushBytes,
+		FlushDocs:          cfg.FlushDocs,
+		MaxRequestBytes:    maxRequestBytes,
+		FlushInterval:      cfg.FlushInterval,
+		Timeout:            cfg.FlushTimeout,
+		TimeoutMaxAttempts: cfg.FlushTimeoutAttempts,
+		DataStreamFlush:    dataStreamFlush,
+		Tracer:             tracer,
+		MaxRequests:        cfg.MaxRequests,
+		Scaling:            scalingCfg,
+		Failover:           failoverCfg,
+		OrderByTrace:       cfg.OrderByTrace,
+		Encoder:            cfg.Encoder,
+		Priority: modelindexer.PriorityConfig{
+			DataStreamTypes: cfg.Priority.DataStreamTypes,
+			ProcessorEvents: cfg.Priority.ProcessorEvents,
+		},
+		CircuitBreaker: modelindexer.CircuitBreakerConfig{
+			Threshold: cfg.CircuitBreaker.Threshold,
+			Backoff:   cfg.CircuitBreaker.Backoff,
+		},
+		Signing: modelindexer.SigningConfig{
+			Key:   []byte(cfg.Signing.Key),
+			KeyID: cfg.Signing.KeyID,
+		},
+		Rollover: modelindexer.RolloverConfig{
+			Enabled:   cfg.MappingErrorRollover.Enabled,
+			Threshold: cfg.MappingErrorRollover.Threshold,
+			Interval:  cfg.MappingErrorRollover.Interval,
+		},
+		DataStreams: modelindexer.DataStreamsConfig{
+			Create: cfg.CreateDataStreams,
+		},
+		WaitForIndexing:     cfg.WaitForIndexing,
+		Pipeline:            cfg.Pipeline,
+		DataStreamPipelines: cfg.DataStreamPipelines,
+		DocumentRetry: modelindexer.DocumentRetryConfig{
+			MaxAttempts:    cfg.DocumentRetry.MaxAttempts,
+			InitialBackoff: cfg.DocumentRetry.Backoff.Init,
+			MaxBackoff:     cfg.DocumentRetry.Backoff.Max,
+		},
+		DeadLetter: modelindexer.DeadLetterConfig{
+			Index: cfg.DeadLetter.Index,
+		},
+		UnavailableBuffer: modelindexer.UnavailableBufferConfig{
+			MaxBytes:      unavailableBufferMaxBytes,
+			MaxAge:        cfg.UnavailableBuffer.MaxAge,
+			RetryInterval: cfg.UnavailableBuffer.RetryInterval,
+		},
+	}, nil
+}
+
+// registerModelIndexerMonitoring registers monitoring metrics for the
+// Elasticsearch output's modelindexer.
+func registerModelIndexerMonitoring(indexer *modelindexer.Indexer, libbeatMonitoringRegistry *monitoring.Registry) {
 	// Install our own libbeat-compatible metrics callback which uses the modelindexer stats.
 	// All the metrics below are required to be reported to be able to display all relevant
 	// fields in the Stack Monitoring UI.
@@ -669,6 +1283,29 @@ func (s *Runner) newFinalBatchProcessor(
 		v.OnInt(stats.AvailableBulkRequests)
 		v.OnKey("completed")
 		v.OnInt(stats.BulkRequests)
+		v.OnKey("split")
+		v.OnInt(stats.BulkRequestsSplit)
+		v.OnKey("too_large")
+		v.OnInt(stats.BulkRequestsTooLarge)
+		v.OnKey("learned_max_bytes")
+		v.OnInt(stats.LearnedMaxRequestBytes)
+		v.OnKey("timed_out")
+		v.OnInt(stats.BulkRequestsTimedOut)
+	})
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.events", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("queued")
+		v.OnInt(stats.Queued)
+		v.OnKey("queued_priority")
+		v.OnInt(stats.QueuedPriority)
+		v.OnKey("retried")
+		v.OnInt(stats.Retried)
+		v.OnKey("held")
+		v.OnInt(stats.Held)
+		v.OnKey("active_oldest_age_ms")
+		v.OnInt(stats.ActiveOldestAge.Milliseconds())
 	})
 	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.indexers", func(_ monitoring.Mode, v monitoring.Visitor) {
 		v.OnRegistryStart()
@@ -681,7 +1318,571 @@ func (s *Runner) newFinalBatchProcessor(
 		v.OnKey("destroyed")
 		v.OnInt(stats.IndexersDestroyed)
 	})
-	return indexer, indexer.Close, nil
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.failover", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("active")
+		v.OnInt(stats.Failover.Active)
+		v.OnKey("failovers")
+		v.OnInt(stats.Failover.Failovers)
+	})
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.circuit_breaker", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("open")
+		v.OnInt(stats.CircuitBreaker.Open)
+		v.OnKey("opened")
+		v.OnInt(stats.CircuitBreaker.Opened)
+	})
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.rollover", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("rollovers")
+		v.OnInt(stats.Rollover.Rollovers)
+		v.OnKey("failed")
+		v.OnInt(stats.Rollover.Failed)
+	})
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.data_streams", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("created")
+		v.OnInt(stats.DataStreams.Created)
+		v.OnKey("failed")
+		v.OnInt(stats.DataStreams.Failed)
+	})
+	monitoring.NewFunc(monitoring.Default, "output.elasticsearch.dead_letter", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		stats := indexer.Stats()
+		v.OnKey("indexed")
+		v.OnInt(stats.DeadLetter.Indexed)
+		v.OnKey("failed")
+		v.OnInt(stats.DeadLetter.Failed)
+	})
+}
+
+// newOTLPFinalBatchProcessor returns a model.BatchProcessor which forwards
+// events over OTLP/gRPC to another APM Server or OTLP endpoint, for running
+// APM Server as an edge collector without direct access to Elasticsearch.
+func (s *Runner) newOTLPFinalBatchProcessor(
+	libbeatMonitoringRegistry *monitoring.Registry,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	var otlpConfig struct {
+		Endpoint    string            `config:"endpoint" validate:"required"`
+		Headers     map[string]string `config:"headers"`
+		APIKey      string            `config:"api_key"`
+		SecretToken string            `config:"secret_token"`
+		Timeout     time.Duration     `config:"timeout"`
+		TLS         *tlscommon.Config `config:"ssl"`
+	}
+	if err := s.otlpOutputConfig.Unpack(&otlpConfig); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse otlp output config")
+	}
+
+	opts := otlpoutput.Config{
+		Endpoint: otlpConfig.Endpoint,
+		Headers:  make(map[string]string, len(otlpConfig.Headers)+1),
+		Timeout:  otlpConfig.Timeout,
+	}
+	for k, v := range otlpConfig.Headers {
+		opts.Headers[k] = v
+	}
+	switch {
+	case otlpConfig.APIKey != "":
+		opts.Headers[headers.Authorization] = headers.APIKey + " " + otlpConfig.APIKey
+	case otlpConfig.SecretToken != "":
+		opts.Headers[headers.Authorization] = headers.Bearer + " " + otlpConfig.SecretToken
+	}
+	tlsConfig, err := loadForwardingOutputTLSConfig(otlpConfig.TLS, otlpConfig.Endpoint)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid otlp output config")
+	}
+	opts.TLS = tlsConfig
+	output, err := otlpoutput.New(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	registerForwardingOutputMonitoring(
+		libbeatMonitoringRegistry, "otlp",
+		func() (batches, acked, failed int64) {
+			stats := output.Stats()
+			return stats.Requests, stats.Forwarded, stats.Failed
+		},
+		output.CollectMonitoring,
+	)
+	return output, output.Close, nil
+}
+
+// newAPMServerFinalBatchProcessor returns a model.BatchProcessor which
+// forwards events to the forwarding service of an upstream APM Server,
+// without converting them to another representation.
+func (s *Runner) newAPMServerFinalBatchProcessor(
+	libbeatMonitoringRegistry *monitoring.Registry,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	var apmServerConfig struct {
+		Endpoint    string            `config:"endpoint" validate:"required"`
+		APIKey      string            `config:"api_key"`
+		SecretToken string            `config:"secret_token"`
+		Timeout     time.Duration     `config:"timeout"`
+		TLS         *tlscommon.Config `config:"ssl"`
+	}
+	if err := s.apmServerOutputConfig.Unpack(&apmServerConfig); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse apm_server output config")
+	}
+
+	opts := forwarding.OutputConfig{
+		Endpoint: apmServerConfig.Endpoint,
+		Timeout:  apmServerConfig.Timeout,
+	}
+	switch {
+	case apmServerConfig.APIKey != "":
+		opts.Authorization = headers.APIKey + " " + apmServerConfig.APIKey
+	case apmServerConfig.SecretToken != "":
+		opts.Authorization = headers.Bearer + " " + apmServerConfig.SecretToken
+	default:
+		return nil, nil, errors.New("apm_server output requires api_key or secret_token to be configured")
+	}
+	tlsConfig, err := loadForwardingOutputTLSConfig(apmServerConfig.TLS, apmServerConfig.Endpoint)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "invalid apm_server output config")
+	}
+	opts.TLS = tlsConfig
+	output, err := forwarding.NewOutput(opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	registerForwardingOutputMonitoring(
+		libbeatMonitoringRegistry, "apm_server",
+		func() (batches, acked, failed int64) {
+			stats := output.Stats()
+			return stats.Requests, stats.Forwarded, stats.Failed
+		},
+		output.CollectMonitoring,
+	)
+	return output, output.Close, nil
+}
+
+// newKafkaFinalBatchProcessor returns a model.BatchProcessor which produces
+// events to Kafka as ECS JSON documents, using modelindexer for buffering
+// and flushing batches of events.
+func (s *Runner) newKafkaFinalBatchProcessor(
+	tracer *apm.Tracer,
+	libbeatMonitoringRegistry *monitoring.Registry,
+	memLimit float64,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	var kafkaConfig struct {
+		Hosts            []string              `config:"hosts" validate:"required"`
+		Topic            string                `config:"topic"`
+		Topics           map[string]string     `config:"topics"`
+		Partition        agentconfig.Namespace `config:"partition"`
+		ClientID         string                `config:"client_id"`
+		Version          string                `config:"version"`
+		Username         string                `config:"username"`
+		Password         string                `config:"password"`
+		RequiredAcks     int                   `config:"required_acks"`
+		Compression      string                `config:"compression"`
+		CompressionLevel int                   `config:"compression_level"`
+		MaxRetries       int                   `config:"max_retries"`
+		MaxMessageBytes  int                   `config:"max_message_bytes"`
+		Timeout          time.Duration         `config:"timeout"`
+		BrokerTimeout    time.Duration         `config:"broker_timeout"`
+		KeepAlive        time.Duration         `config:"keep_alive"`
+		TLS              *tlscommon.Config     `config:"ssl"`
+		BulkMaxSize      int                   `config:"bulk_max_size"`
+		FlushBytes       string                `config:"flush_bytes"`
+		FlushInterval    time.Duration         `config:"flush_interval"`
+		MaxRequests      int                   `config:"max_requests"`
+	}
+	kafkaConfig.ClientID = "apm-server"
+	kafkaConfig.RequiredAcks = 1
+	kafkaConfig.Compression = "gzip"
+	kafkaConfig.CompressionLevel = sarama.CompressionLevelDefault
+	kafkaConfig.MaxRetries = 3
+	kafkaConfig.MaxMessageBytes = 1000000
+	kafkaConfig.Timeout = 30 * time.Second
+	kafkaConfig.BrokerTimeout = 10 * time.Second
+	kafkaConfig.BulkMaxSize = 2048
+	kafkaConfig.FlushInterval = time.Second
+	if err := s.kafkaOutputConfig.Unpack(&kafkaConfig); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse kafka output config")
+	}
+	if err := checkRemovedOutputSettings(
+		s.kafkaOutputConfig, "kafka",
+		"codec", "kerberos", "key", "metadata", "worker",
+	); err != nil {
+		return nil, nil, err
+	}
+	if kafkaConfig.Partition.Name() == "hash" && kafkaConfig.Partition.Config().HasField("hash") {
+		// Events are always keyed by trace ID.
+		return nil, nil, errors.New(`kafka output setting "partition.hash.hash" is no longer supported`)
+	}
+	if err := checkKafkaTopic(kafkaConfig.Topic); err != nil {
+		return nil, nil, err
+	}
+	for dataStreamType, topic := range kafkaConfig.Topics {
+		switch dataStreamType {
+		case "traces", "logs", "metrics":
+		default:
+			return nil, nil, fmt.Errorf(
+				"invalid kafka topics data stream type %q, expected one of traces, logs, or metrics",
+				dataStreamType,
+			)
+		}
+		if err := checkKafkaTopic(topic); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = kafkaConfig.ClientID
+	if kafkaConfig.Version != "" {
+		version, err := sarama.ParseKafkaVersion(kafkaConfig.Version)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid kafka output version")
+		}
+		saramaConfig.Version = version
+	}
+	if kafkaConfig.Username != "" {
+		saramaConfig.Net.SASL.Enable = true
+		saramaConfig.Net.SASL.User = kafkaConfig.Username
+		saramaConfig.Net.SASL.Password = kafkaConfig.Password
+	}
+	if kafkaConfig.TLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSConfig(kafkaConfig.TLS)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "invalid kafka output config")
+		}
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig.BuildModuleClientConfig("")
+	}
+	switch kafkaConfig.RequiredAcks {
+	case -1, 0, 1:
+		saramaConfig.Producer.RequiredAcks = sarama.RequiredAcks(kafkaConfig.RequiredAcks)
+	default:
+		return nil, nil, fmt.Errorf(
+			"invalid kafka required_acks %d, expected one of -1, 0, or 1",
+			kafkaConfig.RequiredAcks,
+		)
+	}
+	switch kafkaConfig.Compression {
+	case "none":
+		saramaConfig.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		saramaConfig.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		saramaConfig.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		saramaConfig.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		saramaConfig.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return nil, nil, fmt.Errorf(
+			"invalid kafka compression %q, expected one of none, gzip, snappy, lz4, or zstd",
+			kafkaConfig.Compression,
+		)
+	}
+	saramaConfig.Producer.CompressionLevel = kafkaConfig.CompressionLevel
+	switch kafkaConfig.Partition.Name() {
+	case "", "hash":
+		saramaConfig.Producer.Partitioner = sarama.NewHashPartitioner
+	case "random":
+		saramaConfig.Producer.Partitioner = sarama.NewRandomPartitioner
+	case "round_robin":
+		saramaConfig.Producer.Partitioner = sarama.NewRoundRobinPartitioner
+	default:
+		return nil, nil, fmt.Errorf(
+			"invalid kafka partition %q, expected one of hash, random, or round_robin",
+			kafkaConfig.Partition.Name(),
+		)
+	}
+	saramaConfig.Net.DialTimeout = kafkaConfig.Timeout
+	saramaConfig.Net.ReadTimeout = kafkaConfig.Timeout
+	saramaConfig.Net.WriteTimeout = kafkaConfig.Timeout
+	saramaConfig.Net.KeepAlive = kafkaConfig.KeepAlive
+	saramaConfig.Producer.Timeout = kafkaConfig.BrokerTimeout
+	saramaConfig.Producer.Retry.Max = kafkaConfig.MaxRetries
+	saramaConfig.Producer.MaxMessageBytes = kafkaConfig.MaxMessageBytes
+	saramaConfig.Producer.Return.Successes = true
+	saramaConfig.Producer.Return.Errors = true
+
+	var flushBytes int
+	if kafkaConfig.FlushBytes != "" {
+		b, err := humanize.ParseBytes(kafkaConfig.FlushBytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "failed to parse flush_bytes")
+		}
+		flushBytes = int(b)
+	}
+	producer, err := sarama.NewSyncProducer(kafkaConfig.Hosts, saramaConfig)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create kafka producer")
+	}
+	output, err := kafkaoutput.New(kafkaoutput.Config{
+		Producer: producer,
+		Topic:    kafkaConfig.Topic,
+		Topics:   kafkaConfig.Topics,
+	})
+	if err != nil {
+		producer.Close()
+		return nil, nil, err
+	}
+	opts := modelIndexerConfig(modelindexer.Config{
+		Tracer:           tracer,
+		FlushBytes:       flushBytes,
+		FlushDocs:        kafkaConfig.BulkMaxSize,
+		FlushInterval:    kafkaConfig.FlushInterval,
+		MaxRequests:      kafkaConfig.MaxRequests,
+		MaxDocumentBytes: kafkaConfig.MaxMessageBytes,
+		Output:           output,
+	}, memLimit, s.logger)
+	indexer, err := modelindexer.New(nil, opts)
+	if err != nil {
+		output.Close()
+		return nil, nil, err
+	}
+	registerForwardingOutputMonitoring(
+		libbeatMonitoringRegistry, "kafka",
+		func() (batches, acked, failed int64) {
+			stats := indexer.Stats()
+			return stats.BulkRequests, stats.Indexed, stats.Failed
+		},
+		output.CollectMonitoring,
+	)
+	return indexer, func(ctx context.Context) error {
+		err := indexer.Close(ctx)
+		if closeErr := output.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// checkKafkaTopic returns an error if topic is a libbeat format string,
+// which would otherwise be used as a literal topic name.
+func checkKafkaTopic(topic string) error {
+	if strings.Contains(topic, "%{") {
+		return fmt.Errorf(
+			"kafka topic %q contains a format string, which is no longer supported; use topics to set the topic for each data stream type",
+			topic,
+		)
+	}
+	return nil
+}
+
+// checkRemovedOutputSettings returns an error if cfg holds any of the named
+// settings, which were supported by the libbeat output replaced by APM Server's
+// own output. Unknown settings are otherwise ignored when unpacking, silently
+// changing the behaviour of existing configurations.
+func checkRemovedOutputSettings(cfg *agentconfig.C, output string, names ...string) error {
+	for _, name := range names {
+		if cfg.HasField(name) {
+			return fmt.Errorf("%s output setting %q is no longer supported", output, name)
+		}
+	}
+	return nil
+}
+
+// writerOutputConfig holds the batching configuration shared by the file
+// and console outputs.
+type writerOutputConfig struct {
+	BulkMaxSize   int           `config:"bulk_max_size"`
+	FlushBytes    string        `config:"flush_bytes"`
+	FlushInterval time.Duration `config:"flush_interval"`
+}
+
+func defaultWriterOutputConfig() writerOutputConfig {
+	return writerOutputConfig{
+		BulkMaxSize:   2048,
+		FlushInterval: time.Second,
+	}
+}
+
+// newFileFinalBatchProcessor returns a model.BatchProcessor which writes
+// events to rotating files as NDJSON bulk request bodies, using modelindexer
+// for buffering and flushing batches of events.
+func (s *Runner) newFileFinalBatchProcessor(
+	tracer *apm.Tracer,
+	libbeatMonitoringRegistry *monitoring.Registry,
+	memLimit float64,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	var fileConfig struct {
+		writerOutputConfig `config:",inline"`
+		Path               string `config:"path" validate:"required"`
+		Filename           string `config:"filename"`
+		RotateEveryKB      uint   `config:"rotate_every_kb" validate:"min=1"`
+		NumberOfFiles      uint   `config:"number_of_files"`
+		Permissions        uint32 `config:"permissions"`
+		RotateOnStartup    bool   `config:"rotate_on_startup"`
+	}
+	fileConfig.writerOutputConfig = defaultWriterOutputConfig()
+	fileConfig.Filename = "apm-server"
+	fileConfig.RotateEveryKB = 10 * 1024
+	fileConfig.NumberOfFiles = 7
+	fileConfig.Permissions = 0600
+	fileConfig.RotateOnStartup = true
+	if err := s.fileOutputConfig.Unpack(&fileConfig); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse file output config")
+	}
+	if err := checkRemovedOutputSettings(s.fileOutputConfig, "file", "codec"); err != nil {
+		return nil, nil, err
+	}
+	if fileConfig.NumberOfFiles < 2 || fileConfig.NumberOfFiles > file.MaxBackupsLimit {
+		return nil, nil, fmt.Errorf(
+			"invalid file number_of_files %d, expected a value between 2 and %d",
+			fileConfig.NumberOfFiles, file.MaxBackupsLimit,
+		)
+	}
+	rotator, err := file.NewFileRotator(
+		filepath.Join(fileConfig.Path, fileConfig.Filename),
+		file.MaxSizeBytes(fileConfig.RotateEveryKB*1024),
+		file.MaxBackups(fileConfig.NumberOfFiles),
+		file.Permissions(os.FileMode(fileConfig.Permissions)),
+		file.RotateOnStartup(fileConfig.RotateOnStartup),
+		file.WithLogger(s.logger),
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create file rotator")
+	}
+	return s.newWriterFinalBatchProcessor(
+		tracer, libbeatMonitoringRegistry, memLimit,
+		"file", fileConfig.writerOutputConfig, rotator, rotator.Close,
+	)
+}
+
+// newConsoleFinalBatchProcessor returns a model.BatchProcessor which writes
+// events to standard output as NDJSON bulk request bodies, using modelindexer
+// for buffering and flushing batches of events.
+func (s *Runner) newConsoleFinalBatchProcessor(
+	tracer *apm.Tracer,
+	libbeatMonitoringRegistry *monitoring.Registry,
+	memLimit float64,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	consoleConfig := defaultWriterOutputConfig()
+	if err := s.consoleOutputConfig.Unpack(&consoleConfig); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to parse console output config")
+	}
+	if err := checkRemovedOutputSettings(s.consoleOutputConfig, "console", "codec"); err != nil {
+		return nil, nil, err
+	}
+	return s.newWriterFinalBatchProcessor(
+		tracer, libbeatMonitoringRegistry, memLimit,
+		"console", consoleConfig, os.Stdout, nil,
+	)
+}
+
+// newWriterFinalBatchProcessor returns a model.BatchProcessor which writes
+// events to w, calling closeWriter, if non-nil, after the last events have
+// been written.
+func (s *Runner) newWriterFinalBatchProcessor(
+	tracer *apm.Tracer,
+	libbeatMonitoringRegistry *monitoring.Registry,
+	memLimit float64,
+	outputName string,
+	cfg writerOutputConfig,
+	w io.Writer,
+	closeWriter func() error,
+) (model.BatchProcessor, func(context.Context) error, error) {
+	if closeWriter == nil {
+		closeWriter = func() error { return nil }
+	}
+	var flushBytes int
+	if cfg.FlushBytes != "" {
+		b, err := humanize.ParseBytes(cfg.FlushBytes)
+		if err != nil {
+			closeWriter()
+			return nil, nil, errors.Wrap(err, "failed to parse flush_bytes")
+		}
+		flushBytes = int(b)
+	}
+	output, err := fileoutput.New(fileoutput.Config{Writer: w})
+	if err != nil {
+		closeWriter()
+		return nil, nil, err
+	}
+	opts := modelIndexerConfig(modelindexer.Config{
+		Tracer:        tracer,
+		FlushBytes:    flushBytes,
+		FlushDocs:     cfg.BulkMaxSize,
+		FlushInterval: cfg.FlushInterval,
+		Output:        output,
+	}, memLimit, s.logger)
+	indexer, err := modelindexer.New(nil, opts)
+	if err != nil {
+		closeWriter()
+		return nil, nil, err
+	}
+	registerForwardingOutputMonitoring(
+		libbeatMonitoringRegistry, outputName,
+		func() (batches, acked, failed int64) {
+			stats := indexer.Stats()
+			return stats.BulkRequests, stats.Indexed, stats.Failed
+		},
+		output.CollectMonitoring,
+	)
+	return indexer, func(ctx context.Context) error {
+		err := indexer.Close(ctx)
+		if closeErr := closeWriter(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+// loadForwardingOutputTLSConfig loads the TLS configuration for an output
+// which forwards events to endpoint, returning nil if TLS is not enabled.
+func loadForwardingOutputTLSConfig(cfg *tlscommon.Config, endpoint string) (*tls.Config, error) {
+	if !cfg.IsEnabled() {
+		return nil, nil
+	}
+	tlsConfig, err := tlscommon.LoadTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid endpoint")
+	}
+	return tlsConfig.BuildModuleClientConfig(host), nil
+}
+
+// registerForwardingOutputMonitoring registers monitoring metrics for an
+// output which forwards events rather than indexing them, in place of the
+// libbeat and Elasticsearch output metrics.
+func registerForwardingOutputMonitoring(
+	libbeatMonitoringRegistry *monitoring.Registry,
+	outputName string,
+	events func() (batches, acked, failed int64),
+	collect func(monitoring.Mode, monitoring.Visitor),
+) {
+	stateRegistry := monitoring.GetNamespace("state").GetRegistry()
+	outputRegistry := stateRegistry.GetRegistry("output")
+	if outputRegistry != nil {
+		outputRegistry.Clear()
+	} else {
+		outputRegistry = stateRegistry.NewRegistry("output")
+	}
+	monitoring.NewString(outputRegistry, "name").Set(outputName)
+	monitoring.NewFunc(libbeatMonitoringRegistry, "output.events", func(_ monitoring.Mode, v monitoring.Visitor) {
+		v.OnRegistryStart()
+		defer v.OnRegistryFinished()
+		batches, acked, failed := events()
+		v.OnKey("acked")
+		v.OnInt(acked)
+		v.OnKey("batches")
+		v.OnInt(batches)
+		v.OnKey("failed")
+		v.OnInt(failed)
+		v.OnKey("total")
+		v.OnInt(acked + failed)
+	})
+	outputType := monitoring.NewString(libbeatMonitoringRegistry.GetRegistry("output"), "type")
+	outputType.Set(outputName)
+	monitoring.Default.Remove("output")
+	monitoring.NewFunc(monitoring.Default, "output."+outputName, collect)
 }
 
 func modelIndexerConfig(
@@ -790,12 +1991,12 @@ func newSourcemapFetcher(
 		tlsDialer := transport.TLSDialer(dialer, tlsConfig, timeout)
 
 		client := *http.DefaultClient
-		client.Transport = apmhttp.WrapRoundTripper(&http.Transport{
+		client.Transport = apmhttp.WrapRoundTripper(outbound.WrapRoundTripper(outbound.FleetServer, &http.Transport{
 			Proxy:           http.ProxyFromEnvironment,
 			Dial:            dialer.Dial,
 			DialTLS:         tlsDialer.Dial,
 			TLSClientConfig: tlsConfig.ToConfig(),
-		})
+		}))
 
 		fleetServerURLs := make([]*url.URL, len(fleetCfg.Hosts))
 		for i, host := range fleetCfg.Hosts {
@@ -829,15 +2030,22 @@ func newSourcemapFetcher(
 	}
 
 	// For standalone, we query both Kibana and Elasticsearch for backwards compatibility.
-	var chained sourcemap.ChainedFetcher
-	if kibanaClient != nil {
-		chained = append(chained, sourcemap.NewKibanaFetcher(kibanaClient))
+	if cfg.KibanaMigration.Enabled && kibanaClient == nil {
+		return nil, errors.New("source map migration requires apm-server.kibana to be enabled")
 	}
 	esClient, err := newElasticsearchClient(cfg.ESConfig)
 	if err != nil {
 		return nil, err
 	}
 	index := strings.ReplaceAll(cfg.IndexPattern, "%{[observer.version]}", version.Version)
+	if cfg.KibanaMigration.Enabled {
+		migrationIndex := strings.ReplaceAll(cfg.KibanaMigration.Index, "%{[observer.version]}", version.Version)
+		return sourcemap.NewKibanaMigrationFetcher(kibanaClient, esClient, index, migrationIndex), nil
+	}
+	var chained sourcemap.ChainedFetcher
+	if kibanaClient != nil {
+		chained = append(chained, sourcemap.NewKibanaFetcher(kibanaClient))
+	}
 	esFetcher := sourcemap.NewElasticsearchFetcher(esClient, index)
 	chained = append(chained, esFetcher)
 	return chained, nil
@@ -887,7 +2095,10 @@ func queryClusterUUID(ctx context.Context, esClient elasticsearch.Client) error
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode > 299 {
-		return fmt.Errorf("error querying cluster_uuid: status_code=%d", resp.StatusCode)
+		return &httpStatusError{
+			statusCode: resp.StatusCode,
+			err:        fmt.Errorf("error querying cluster_uuid: status_code=%d", resp.StatusCode),
+		}
 	}
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
@@ -907,3 +2118,30 @@ func (nopProcessingSupporter) Close() error {
 func (nopProcessingSupporter) Create(cfg beat.ProcessingConfig, _ bool) (beat.Processor, error) {
 	return cfg.Processor, nil
 }
+
+// newThrottleSchedule returns a ratelimit.Schedule for the configured
+// scheduled throttles.
+func newThrottleSchedule(cfgs []config.ThrottleConfig) (*ratelimit.Schedule, error) {
+	throttles := make([]ratelimit.Throttle, len(cfgs))
+	for i, cfg := range cfgs {
+		location, err := cfg.Location()
+		if err != nil {
+			return nil, fmt.Errorf("throttle %q: %w", cfg.Name, err)
+		}
+		days := make([]time.Weekday, len(cfg.Days))
+		for j, day := range cfg.Days {
+			days[j] = time.Weekday(day)
+		}
+		throttles[i] = ratelimit.Throttle{
+			Name:       cfg.Name,
+			Days:       days,
+			Start:      time.Duration(cfg.Start),
+			End:        time.Duration(cfg.End),
+			Location:   location,
+			EventTypes: cfg.EventTypes,
+			Limit:      rate.Limit(cfg.EventLimit),
+			Burst:      cfg.EventLimit * 3, // burst multiplier
+		}
+	}
+	return ratelimit.NewSchedule(throttles), nil
+}
@@ -36,6 +36,8 @@ import (
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/version"
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
@@ -105,6 +107,66 @@ func TestStoreUsesRUMElasticsearchConfig(t *testing.T) {
 	assert.True(t, called)
 }
 
+func TestSourcemapKibanaMigrationRequiresKibana(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.RumConfig.Enabled = true
+	cfg.RumConfig.SourceMapping.KibanaMigration.Enabled = true
+
+	_, err := newSourcemapFetcher(
+		cfg.RumConfig.SourceMapping, nil,
+		nil, elasticsearch.NewClient,
+	)
+	assert.EqualError(t, err, "source map migration requires apm-server.kibana to be enabled")
+}
+
+func TestKafkaOutputRemovedSettings(t *testing.T) {
+	for name, test := range map[string]struct {
+		config map[string]interface{}
+		err    string
+	}{
+		"kerberos": {
+			config: map[string]interface{}{"kerberos.auth_type": "password"},
+			err:    `kafka output setting "kerberos" is no longer supported`,
+		},
+		"codec": {
+			config: map[string]interface{}{"codec.json.pretty": true},
+			err:    `kafka output setting "codec" is no longer supported`,
+		},
+		"partition_hash": {
+			config: map[string]interface{}{"partition.hash.hash": []string{"trace.id"}},
+			err:    `kafka output setting "partition.hash.hash" is no longer supported`,
+		},
+		"topic_format_string": {
+			config: map[string]interface{}{"topic": "%{[data_stream.type]}"},
+			err:    `kafka topic "%{[data_stream.type]}" contains a format string, which is no longer supported; use topics to set the topic for each data stream type`,
+		},
+		"topics_format_string": {
+			config: map[string]interface{}{"topics.traces": "apm-%{[service.name]}"},
+			err:    `kafka topic "apm-%{[service.name]}" contains a format string, which is no longer supported; use topics to set the topic for each data stream type`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cfg := agentconfig.MustNewConfigFrom(map[string]interface{}{"hosts": []string{"localhost:9092"}})
+			require.NoError(t, cfg.Merge(test.config))
+			s := &Runner{kafkaOutputConfig: cfg, logger: logp.NewLogger("")}
+			_, _, err := s.newKafkaFinalBatchProcessor(nil, monitoring.NewRegistry(), 0)
+			assert.EqualError(t, err, test.err)
+		})
+	}
+}
+
+func TestWriterOutputCodecRejected(t *testing.T) {
+	cfg := agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"path":              t.TempDir(),
+		"codec.json.pretty": true,
+	})
+	s := &Runner{fileOutputConfig: cfg, consoleOutputConfig: cfg, logger: logp.NewLogger("")}
+	_, _, err := s.newFileFinalBatchProcessor(nil, monitoring.NewRegistry(), 0)
+	assert.EqualError(t, err, `file output setting "codec" is no longer supported`)
+	_, _, err = s.newConsoleFinalBatchProcessor(nil, monitoring.NewRegistry(), 0)
+	assert.EqualError(t, err, `console output setting "codec" is no longer supported`)
+}
+
 func TestFleetStoreUsed(t *testing.T) {
 	var called bool
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,164 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beatertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
+)
+
+// IndexedDocument holds a document indexed into ElasticsearchServer.
+type IndexedDocument struct {
+	// Index holds the name of the data stream or index
+	// into which the document was indexed.
+	Index string
+
+	// Source holds the JSON-encoded document source.
+	Source json.RawMessage
+}
+
+// ElasticsearchServer is an in-process mock Elasticsearch server, which
+// records documents indexed by APM Server for black-box testing of the
+// server, from intake to output.
+//
+// Use WithElasticsearch to configure a Server to send documents to
+// an ElasticsearchServer.
+type ElasticsearchServer struct {
+	// URL holds the base URL of the mock Elasticsearch server.
+	URL string
+
+	mu         sync.Mutex
+	docs       []IndexedDocument
+	indexed    chan struct{}
+	itemStatus func(IndexedDocument) int
+}
+
+// NewElasticsearchServer returns a new started ElasticsearchServer.
+//
+// The server will be closed as a test cleanup.
+func NewElasticsearchServer(t testing.TB) *ElasticsearchServer {
+	es := &ElasticsearchServer{indexed: make(chan struct{})}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		// We must send a valid JSON response for the initial
+		// Elasticsearch cluster UUID query.
+		fmt.Fprintln(w, `{"version":{"number":"1.2.3"}}`)
+	})
+	modelindexertest.HandleBulk(mux, es.handleBulk)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	es.URL = srv.URL
+	return es
+}
+
+// OutputConfig returns "output.elasticsearch" configuration which will
+// send documents to es, flushing each document as soon as possible.
+func (es *ElasticsearchServer) OutputConfig() *agentconfig.C {
+	return agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"output.elasticsearch": map[string]interface{}{
+			"enabled":      true,
+			"hosts":        []string{es.URL},
+			"flush_bytes":  "1", // no delay
+			"max_requests": "1", // only 1 concurrent request, for event ordering
+		},
+	})
+}
+
+// SetItemStatus sets a function which is called for each document in a bulk
+// request, to determine the status code of the bulk item response. This may
+// be used to inject indexing failures. Documents are recorded only when the
+// returned status is 2xx.
+//
+// If f is nil, all documents are indexed successfully.
+func (es *ElasticsearchServer) SetItemStatus(f func(IndexedDocument) int) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.itemStatus = f
+}
+
+// Documents returns the documents successfully indexed so far.
+func (es *ElasticsearchServer) Documents() []IndexedDocument {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	return append([]IndexedDocument(nil), es.docs...)
+}
+
+// WaitDocuments waits for at least n documents to be successfully indexed,
+// returning all documents indexed so far. If fewer than n documents have
+// been indexed after 10 seconds, WaitDocuments fails the test.
+func (es *ElasticsearchServer) WaitDocuments(t testing.TB, n int) []IndexedDocument {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		es.mu.Lock()
+		docs := append([]IndexedDocument(nil), es.docs...)
+		indexed := es.indexed
+		es.mu.Unlock()
+		if len(docs) >= n {
+			return docs
+		}
+		select {
+		case <-indexed:
+		case <-timeout:
+			t.Fatalf("timed out waiting for %d documents, received %d", n, len(docs))
+		}
+	}
+}
+
+func (es *ElasticsearchServer) handleBulk(w http.ResponseWriter, r *http.Request) {
+	items := modelindexertest.DecodeBulkRequestItems(r)
+	var result elasticsearch.BulkIndexerResponse
+
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	var n int
+	for _, item := range items {
+		doc := IndexedDocument{Index: item.Index, Source: item.Document}
+		status := http.StatusCreated
+		if es.itemStatus != nil {
+			status = es.itemStatus(doc)
+		}
+		responseItem := esutil.BulkIndexerResponseItem{Index: item.Index, Status: status}
+		if status < 200 || status > 299 {
+			result.HasErrors = true
+			responseItem.Error.Type = "mock_error"
+			responseItem.Error.Reason = "rejected by mock Elasticsearch server"
+		} else {
+			es.docs = append(es.docs, doc)
+			n++
+		}
+		result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{item.Action: responseItem})
+	}
+	if n > 0 {
+		close(es.indexed)
+		es.indexed = make(chan struct{})
+	}
+	json.NewEncoder(w).Encode(result)
+}
@@ -20,6 +20,7 @@ package beatertest
 import (
 	"context"
 	"errors"
+	"io"
 	"net"
 	"net/http"
 	"net/url"
@@ -34,6 +35,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/elastic/apm-server/internal/beater"
+	"github.com/elastic/apm-server/internal/beater/api"
 	agentconfig "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 )
@@ -72,8 +74,8 @@ func NewServer(t testing.TB, opts ...option) *Server {
 // NewUnstartedServer returns a new unstarted APM Server with the given options.
 //
 // By default the server will not send documents to any output. To observe documents
-// as they would be sent to Elasticsearch, use ElasticsearchOutputConfig and pass the
-// configuration to New(Unstarted)Server.
+// as they would be sent to Elasticsearch, use WithElasticsearch, or use
+// ElasticsearchOutputConfig and pass the configuration to New(Unstarted)Server.
 //
 // The server's Start method should be called to start the server.
 func NewUnstartedServer(t testing.TB, opts ...option) *Server {
@@ -173,6 +175,17 @@ func (s *Server) waitListenAddr(exited <-chan struct{}) (string, error) {
 	}
 }
 
+// PostEvents sends body to the server's intake API, returning the response.
+// The body is expected to be ND-JSON encoded.
+func (s *Server) PostEvents(body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, s.URL+api.IntakePath, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	return s.Client.Do(req)
+}
+
 // Close stops the server.
 func (s *Server) Close() error {
 	s.cancel()
@@ -205,3 +218,9 @@ func WithWrapServer(wrapServer beater.WrapServerFunc) option {
 		opts.wrapServer = wrapServer
 	}
 }
+
+// WithElasticsearch is an option for configuring the server to send
+// documents to the given ElasticsearchServer.
+func WithElasticsearch(es *ElasticsearchServer) option {
+	return WithConfig(es.OutputConfig())
+}
@@ -92,7 +92,10 @@ func checkIntegrationInstalledKibana(ctx context.Context, kibanaClient *kibana.C
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return false, fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status, bytes.TrimSpace(body))
+		return false, &httpStatusError{
+			statusCode: resp.StatusCode,
+			err:        fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status, bytes.TrimSpace(body)),
+		}
 	}
 	var result struct {
 		Response struct {
@@ -131,7 +134,10 @@ func checkIntegrationInstalledElasticsearch(ctx context.Context, esClient elasti
 
 			if resp.IsError() {
 				body, _ := io.ReadAll(resp.Body)
-				return fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status(), bytes.TrimSpace(body))
+				return &httpStatusError{
+					statusCode: resp.StatusCode,
+					err:        fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status(), bytes.TrimSpace(body)),
+				}
 			}
 			return nil
 		})
@@ -20,6 +20,7 @@ package config
 import (
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -27,7 +28,8 @@ import (
 
 // KibanaAgentConfig holds remote agent config information
 type KibanaAgentConfig struct {
-	Cache Cache `config:"cache"`
+	Cache    Cache                     `config:"cache"`
+	Adoption AgentConfigAdoptionConfig `config:"adoption"`
 }
 
 // Cache holds config information about cache expiration
@@ -35,12 +37,35 @@ type Cache struct {
 	Expiration time.Duration `config:"expiration"`
 }
 
+// AgentConfigAdoptionConfig holds configuration related to tracking the
+// adoption of agent configuration, as reported by agents in intake metadata.
+type AgentConfigAdoptionConfig struct {
+	// Enabled controls whether agent configuration adoption is tracked
+	// and exposed through the agent configuration adoption endpoint.
+	Enabled bool `config:"enabled"`
+
+	// Window holds the amount of time for which agents are tracked after
+	// they last sent events.
+	Window time.Duration `config:"window"`
+}
+
+// Validate validates the agent configuration adoption configuration.
+func (c *AgentConfigAdoptionConfig) Validate() error {
+	if c.Window < time.Minute {
+		return errors.New("agent config adoption window must be at least 1m")
+	}
+	return nil
+}
+
 // defaultKibanaAgentConfig holds the default KibanaAgentConfig
 func defaultKibanaAgentConfig() KibanaAgentConfig {
 	return KibanaAgentConfig{
 		Cache: Cache{
 			Expiration: 30 * time.Second,
 		},
+		Adoption: AgentConfigAdoptionConfig{
+			Window: 10 * time.Minute,
+		},
 	}
 }
 
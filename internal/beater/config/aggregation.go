@@ -18,6 +18,8 @@
 package config
 
 import (
+	"fmt"
+	"regexp"
 	"time"
 )
 
@@ -31,6 +33,17 @@ const (
 
 	defaultServiceAggregationInterval  = time.Minute
 	defaultServiceAggregationMaxGroups = 10000
+
+	defaultErrorRateAggregationInterval  = time.Minute
+	defaultErrorRateAggregationMaxGroups = 10000
+
+	defaultMobileVitalsAggregationInterval    = time.Minute
+	defaultMobileVitalsAggregationMaxGroups   = 10000
+	defaultMobileVitalsAggregationMaxSessions = 10000
+
+	defaultWebVitalsAggregationInterval                       = time.Minute
+	defaultWebVitalsAggregationMaxGroups                      = 10000
+	defaultWebVitalsAggregationHDRHistogramSignificantFigures = 2
 )
 
 // AggregationConfig holds configuration related to various metrics aggregations.
@@ -38,6 +51,9 @@ type AggregationConfig struct {
 	Transactions        TransactionAggregationConfig        `config:"transactions"`
 	ServiceDestinations ServiceDestinationAggregationConfig `config:"service_destinations"`
 	Service             ServiceAggregationConfig            `config:"service"`
+	ErrorRate           ErrorRateAggregationConfig          `config:"error_rate"`
+	MobileVitals        MobileVitalsAggregationConfig       `config:"mobile_vitals"`
+	WebVitals           WebVitalsAggregationConfig          `config:"web_vitals"`
 }
 
 // TransactionAggregationConfig holds configuration related to transaction metrics aggregation.
@@ -60,6 +76,46 @@ type ServiceAggregationConfig struct {
 	MaxGroups int           `config:"max_groups" validate:"min=1"`
 }
 
+// ErrorRateAggregationConfig holds configuration related to error rate metrics aggregation.
+type ErrorRateAggregationConfig struct {
+	Enabled   bool          `config:"enabled"`
+	Interval  time.Duration `config:"interval" validate:"min=1"`
+	MaxGroups int           `config:"max_groups" validate:"min=1"`
+}
+
+// MobileVitalsAggregationConfig holds configuration related to mobile vitals metrics aggregation.
+type MobileVitalsAggregationConfig struct {
+	Enabled     bool          `config:"enabled"`
+	Interval    time.Duration `config:"interval" validate:"min=1"`
+	MaxGroups   int           `config:"max_groups" validate:"min=1"`
+	MaxSessions int           `config:"max_sessions" validate:"min=1"`
+}
+
+// WebVitalsAggregationConfig holds configuration related to RUM web vitals metrics aggregation.
+type WebVitalsAggregationConfig struct {
+	Enabled                        bool              `config:"enabled"`
+	Interval                       time.Duration     `config:"interval" validate:"min=1"`
+	MaxGroups                      int               `config:"max_groups" validate:"min=1"`
+	HDRHistogramSignificantFigures int               `config:"hdrhistogram_significant_figures" validate:"min=1, max=5"`
+	PageGroups                     []PageGroupConfig `config:"page_groups"`
+}
+
+// PageGroupConfig holds a rule for grouping the pages of RUM transactions
+// in web vitals metrics. Pages whose URL path matches Pattern are grouped
+// under Name.
+type PageGroupConfig struct {
+	Name    string `config:"name" validate:"required"`
+	Pattern string `config:"pattern" validate:"required"`
+}
+
+// Validate validates the page group configuration.
+func (c *PageGroupConfig) Validate() error {
+	if _, err := regexp.Compile(c.Pattern); err != nil {
+		return fmt.Errorf("page group %q: invalid pattern: %w", c.Name, err)
+	}
+	return nil
+}
+
 func defaultAggregationConfig() AggregationConfig {
 	return AggregationConfig{
 		Transactions: TransactionAggregationConfig{
@@ -79,5 +135,22 @@ func defaultAggregationConfig() AggregationConfig {
 			Interval:  defaultServiceAggregationInterval,
 			MaxGroups: defaultServiceAggregationMaxGroups,
 		},
+		ErrorRate: ErrorRateAggregationConfig{
+			Enabled:   false,
+			Interval:  defaultErrorRateAggregationInterval,
+			MaxGroups: defaultErrorRateAggregationMaxGroups,
+		},
+		MobileVitals: MobileVitalsAggregationConfig{
+			Enabled:     false,
+			Interval:    defaultMobileVitalsAggregationInterval,
+			MaxGroups:   defaultMobileVitalsAggregationMaxGroups,
+			MaxSessions: defaultMobileVitalsAggregationMaxSessions,
+		},
+		WebVitals: WebVitalsAggregationConfig{
+			Enabled:                        false,
+			Interval:                       defaultWebVitalsAggregationInterval,
+			MaxGroups:                      defaultWebVitalsAggregationMaxGroups,
+			HDRHistogramSignificantFigures: defaultWebVitalsAggregationHDRHistogramSignificantFigures,
+		},
 	}
 }
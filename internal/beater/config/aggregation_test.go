@@ -55,6 +55,14 @@ func TestAggregationConfigInvalid(t *testing.T) {
 		key:    "aggregation.transactions.hdrhistogram_significant_figures",
 		value:  float64(6),
 		expect: "Error processing configuration: requires value <= 5 accessing 'aggregation.transactions.hdrhistogram_significant_figures'",
+	}, {
+		name: "invalid web_vitals page group pattern",
+		key:  "aggregation.web_vitals.page_groups",
+		value: []map[string]interface{}{{
+			"name":    "products",
+			"pattern": "[",
+		}},
+		expect: "Error processing configuration: page group \"products\": invalid pattern: error parsing regexp: missing closing ]: `[` accessing 'aggregation.web_vitals.page_groups.0'",
 	}} {
 		t.Run(test.name, func(t *testing.T) {
 			_, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
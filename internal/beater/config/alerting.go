@@ -0,0 +1,71 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// AlertingConfig holds configuration related to threshold-based alerts for
+// ingest health, which notify a webhook when internal conditions persist.
+type AlertingConfig struct {
+	Enabled  bool          `config:"enabled"`
+	Interval time.Duration `config:"interval" validate:"min=1s"`
+	Duration time.Duration `config:"duration" validate:"min=0"`
+	Webhook  WebhookConfig `config:"webhook"`
+
+	// IndexerFailover controls whether an alert fires when the
+	// Elasticsearch indexer has failed over to the standby cluster.
+	IndexerFailover bool `config:"indexer_failover"`
+
+	// IndexingFailureRatio holds the ratio of events failing to be
+	// indexed above which an alert fires. Zero disables the alert.
+	IndexingFailureRatio float64 `config:"indexing_failure_ratio" validate:"min=0,max=1"`
+
+	// TailSamplingStorageRatio holds the ratio of tail-based sampling
+	// storage usage to the storage limit above which an alert fires.
+	// Zero disables the alert.
+	TailSamplingStorageRatio float64 `config:"tail_sampling_storage_ratio" validate:"min=0,max=1"`
+}
+
+// WebhookConfig holds configuration for the webhook notified of alerts.
+type WebhookConfig struct {
+	URL     string        `config:"url"`
+	Timeout time.Duration `config:"timeout" validate:"min=1s"`
+}
+
+// Validate validates the alerting configuration.
+func (c *AlertingConfig) Validate() error {
+	if c.Enabled && c.Webhook.URL == "" {
+		return errors.New("alerting.webhook.url must be specified when alerting is enabled")
+	}
+	return nil
+}
+
+func defaultAlertingConfig() AlertingConfig {
+	return AlertingConfig{
+		Enabled:                  false,
+		Interval:                 10 * time.Second,
+		Duration:                 time.Minute,
+		Webhook:                  WebhookConfig{Timeout: 10 * time.Second},
+		IndexerFailover:          true,
+		IndexingFailureRatio:     0.05,
+		TailSamplingStorageRatio: 0.9,
+	}
+}
@@ -18,6 +18,9 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/pkg/errors"
 
 	"github.com/elastic/elastic-agent-libs/config"
@@ -50,9 +53,12 @@ func (a *AgentAuth) setAnonymousDefaults(logger *logp.Logger, rumEnabled bool) e
 
 // APIKeyAgentAuth holds config related to API Key auth for agents.
 type APIKeyAgentAuth struct {
-	Enabled     bool                  `config:"enabled"`
-	LimitPerMin int                   `config:"limit"`
-	ESConfig    *elasticsearch.Config `config:"elasticsearch"`
+	Enabled        bool                  `config:"enabled"`
+	LimitPerMin    int                   `config:"limit"`
+	ESConfig       *elasticsearch.Config `config:"elasticsearch"`
+	AllowEventType []string              `config:"allow_event_type"`
+	TenantLabels   bool                  `config:"tenant_labels"`
+	RateLimit      APIKeyRateLimit       `config:"rate_limit"`
 
 	configured   bool // api_key explicitly defined
 	esConfigured bool // api_key.elasticsearch explicitly defined
@@ -63,6 +69,9 @@ func (a *APIKeyAgentAuth) Unpack(in *config.C) error {
 	if err := in.Unpack((*underlyingAPIKeyAgentAuth)(a)); err != nil {
 		return errors.Wrap(err, "error unpacking api_key config")
 	}
+	if err := validateEventTypes(a.AllowEventType); err != nil {
+		return errors.Wrap(err, "error unpacking api_key config")
+	}
 	a.configured = true
 	a.esConfigured = in.HasField("elasticsearch")
 	return nil
@@ -84,10 +93,11 @@ func (a *APIKeyAgentAuth) setup(log *logp.Logger, outputESCfg *config.C) error {
 // If RUM is enabled, and either secret_token or api_key auth is defined,
 // then anonymous auth will be enabled for RUM by default.
 type AnonymousAgentAuth struct {
-	Enabled      bool      `config:"enabled"`
-	AllowAgent   []string  `config:"allow_agent"`
-	AllowService []string  `config:"allow_service"`
-	RateLimit    RateLimit `config:"rate_limit"`
+	Enabled        bool      `config:"enabled"`
+	AllowAgent     []string  `config:"allow_agent"`
+	AllowService   []string  `config:"allow_service"`
+	AllowEventType []string  `config:"allow_event_type"`
+	RateLimit      RateLimit `config:"rate_limit"`
 
 	enabledSet bool // enabled explicitly set.
 }
@@ -97,10 +107,35 @@ func (a *AnonymousAgentAuth) Unpack(in *config.C) error {
 	if err := in.Unpack((*underlyingAnonymousAgentAuth)(a)); err != nil {
 		return errors.Wrap(err, "error unpacking anon config")
 	}
+	if err := validateEventTypes(a.AllowEventType); err != nil {
+		return errors.Wrap(err, "error unpacking anon config")
+	}
 	a.enabledSet = in.HasField("enabled")
 	return nil
 }
 
+// eventTypes holds the event types which may be specified in allow_event_type.
+var eventTypes = []string{"transaction", "span", "error", "metric", "log"}
+
+func validateEventTypes(allowEventType []string) error {
+	for _, eventType := range allowEventType {
+		var valid bool
+		for _, known := range eventTypes {
+			if eventType == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf(
+				"invalid event type %q in allow_event_type, expected one of [%s]",
+				eventType, strings.Join(eventTypes, ", "),
+			)
+		}
+	}
+	return nil
+}
+
 func defaultAgentAuth() AgentAuth {
 	return AgentAuth{
 		Anonymous: defaultAnonymousAgentAuth(),
@@ -123,6 +123,18 @@ func TestAnonymousAgentAuth(t *testing.T) {
 				enabledSet: false,
 			},
 		},
+		"allow_event_type": {
+			cfg: config.MustNewConfigFrom(`{"auth.anonymous.allow_event_type":["transaction","span","error"]}`),
+			expectedConfig: AnonymousAgentAuth{
+				AllowAgent:     []string{"rum-js", "js-base"},
+				AllowEventType: []string{"transaction", "span", "error"},
+				RateLimit: RateLimit{
+					EventLimit: 300,
+					IPLimit:    1000,
+				},
+				enabledSet: false,
+			},
+		},
 		"rum_enabled_anon_inferred": {
 			cfg: config.MustNewConfigFrom(`{"auth.secret_token": "abc","rum.enabled":true,"auth.anonymous.allow_service":["service-one"]}`),
 			expectedConfig: AnonymousAgentAuth{
@@ -160,6 +172,17 @@ func TestAnonymousAgentAuth(t *testing.T) {
 	}
 }
 
+func TestAllowEventTypeInvalid(t *testing.T) {
+	for _, key := range []string{"auth.anonymous", "auth.api_key"} {
+		input := config.MustNewConfigFrom(map[string]interface{}{
+			key + ".allow_event_type": []string{"transaction", "profile"},
+		})
+		_, err := NewConfig(input, nil)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `invalid event type "profile" in allow_event_type, expected one of [transaction, span, error, metric, log]`)
+	}
+}
+
 func TestSecretTokenAuth(t *testing.T) {
 	for name, tc := range map[string]struct {
 		cfg      *config.C
@@ -51,12 +51,17 @@ type Config struct {
 	ReadTimeout               time.Duration           `config:"read_timeout"`
 	WriteTimeout              time.Duration           `config:"write_timeout"`
 	MaxEventSize              int                     `config:"max_event_size"`
+	Decoding                  DecodingConfig          `config:"decoding"`
+	TimestampPolicy           TimestampPolicyConfig   `config:"timestamp_policy"`
 	ShutdownTimeout           time.Duration           `config:"shutdown_timeout"`
 	TLS                       *tlscommon.ServerConfig `config:"ssl"`
 	MaxConnections            int                     `config:"max_connections"`
 	ResponseHeaders           map[string][]string     `config:"response_headers"`
 	Expvar                    ExpvarConfig            `config:"expvar"`
+	Prometheus                PrometheusConfig        `config:"prometheus"`
 	Pprof                     PprofConfig             `config:"pprof"`
+	LogLevelEndpoint          LogLevelEndpointConfig  `config:"log_level_endpoint"`
+	QueuesEndpoint            QueuesEndpointConfig    `config:"queues_endpoint"`
 	AugmentEnabled            bool                    `config:"capture_personal_data"`
 	RumConfig                 RumConfig               `config:"rum"`
 	Kibana                    KibanaConfig            `config:"kibana"`
@@ -67,14 +72,43 @@ type Config struct {
 	DataStreams               DataStreamsConfig       `config:"data_streams"`
 	DefaultServiceEnvironment string                  `config:"default_service_environment"`
 	JavaAttacherConfig        JavaAttacherConfig      `config:"java_attacher"`
+	StringLabels              []string                `config:"string_labels"`
+	GlobalLabels              map[string]string       `config:"global_labels"`
+	BatchProcessors           []string                `config:"batch_processors"`
+	SpanHierarchy             SpanHierarchyConfig     `config:"span_hierarchy"`
+	FutureTimestamps          FutureTimestampsConfig  `config:"future_timestamps"`
+	Provenance                ProvenanceConfig        `config:"provenance"`
+	Forwarding                ForwardingConfig        `config:"forwarding"`
+	ServiceInventory          ServiceInventoryConfig  `config:"service_inventory"`
+	CostAccounting            CostAccountingConfig    `config:"cost_accounting"`
+	SelfCheck                 SelfCheckConfig         `config:"self_check"`
+	DataStreamStats           DataStreamStatsConfig   `config:"data_stream_stats"`
+	Alerting                  AlertingConfig          `config:"alerting"`
+	RuntimeMetrics            RuntimeMetricsConfig    `config:"runtime_metrics"`
+	Register                  RegisterConfig          `config:"register"`
 
 	AgentConfigs []AgentConfig `config:"agent_config"`
 
-	// WaitReadyInterval holds the interval for checks when waiting for
-	// the integration package to be installed, and for checking the
-	// Elasticsearch license level.
+	// Throttles holds scheduled throttles, which reduce the rate at which
+	// events are accepted during recurring time windows.
+	Throttles []ThrottleConfig `config:"throttles"`
+
+	// WaitReadyInterval holds the initial interval between checks when
+	// waiting for the integration package to be installed, and for checking
+	// the Elasticsearch license level. The interval doubles after each
+	// failed check, up to WaitReadyMaxInterval.
 	WaitReadyInterval time.Duration `config:"wait_ready_interval"`
 
+	// WaitReadyMaxInterval holds the maximum interval between checks when
+	// waiting for the server's indexing preconditions to be satisfied.
+	WaitReadyMaxInterval time.Duration `config:"wait_ready_max_interval"`
+
+	// MaxConcurrentRequestsPerClient limits the number of concurrent
+	// in-flight intake requests per client, identified by API Key ID or
+	// client IP. Requests beyond the limit are rejected with 429 Too Many
+	// Requests. If zero, the number of concurrent requests is not limited.
+	MaxConcurrentRequestsPerClient int `config:"max_concurrent_requests_per_client" validate:"min=0"`
+
 	// MaxConcurrentDecoders sets the limit on the number of concurrent batches
 	// that can be decoded at one time. This effectively limits the amount of
 	// memory consumed by the processors decodeing the incoming intake events.
@@ -102,6 +136,10 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 		}
 	}
 
+	if err := validateThrottles(c.Throttles); err != nil {
+		return nil, err
+	}
+
 	if err := c.RumConfig.setup(logger, outputESCfg); err != nil {
 		return nil, err
 	}
@@ -114,6 +152,10 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 		return nil, err
 	}
 
+	if err := c.Forwarding.setup(c.AgentAuth, c.TLS); err != nil {
+		return nil, err
+	}
+
 	if err := c.Sampling.Tail.setup(logger, outputESCfg); err != nil {
 		return nil, err
 	}
@@ -133,19 +175,29 @@ func NewConfig(ucfg *config.C, outputESCfg *config.C) (*Config, error) {
 // DefaultConfig returns a config with default settings for `apm-server` config options.
 func DefaultConfig() *Config {
 	return &Config{
-		Host:            net.JoinHostPort("localhost", DefaultPort),
-		MaxHeaderSize:   1 * 1024 * 1024, // 1mb
-		MaxConnections:  0,               // unlimited
-		IdleTimeout:     45 * time.Second,
-		ReadTimeout:     30 * time.Second,
-		WriteTimeout:    30 * time.Second,
-		MaxEventSize:    300 * 1024, // 300 kb
-		ShutdownTimeout: 30 * time.Second,
-		AugmentEnabled:  true,
+		Host:             net.JoinHostPort("localhost", DefaultPort),
+		MaxHeaderSize:    1 * 1024 * 1024, // 1mb
+		MaxConnections:   0,               // unlimited
+		IdleTimeout:      45 * time.Second,
+		ReadTimeout:      30 * time.Second,
+		WriteTimeout:     30 * time.Second,
+		MaxEventSize:     300 * 1024, // 300 kb
+		Decoding:         defaultDecodingConfig(),
+		TimestampPolicy:  defaultTimestampPolicyConfig(),
+		SpanHierarchy:    defaultSpanHierarchyConfig(),
+		FutureTimestamps: defaultFutureTimestampsConfig(),
+		Forwarding:       defaultForwardingConfig(),
+		ServiceInventory: defaultServiceInventoryConfig(),
+		ShutdownTimeout:  30 * time.Second,
+		AugmentEnabled:   true,
 		Expvar: ExpvarConfig{
 			Enabled: false,
 			URL:     "/debug/vars",
 		},
+		Prometheus: PrometheusConfig{
+			Enabled: false,
+			URL:     "/metrics",
+		},
 		Pprof:              PprofConfig{Enabled: false},
 		RumConfig:          defaultRum(),
 		Kibana:             defaultKibanaConfig(),
@@ -156,6 +208,14 @@ func DefaultConfig() *Config {
 		DataStreams:        defaultDataStreamsConfig(),
 		AgentAuth:          defaultAgentAuth(),
 		JavaAttacherConfig: defaultJavaAttacherConfig(),
-		WaitReadyInterval:  5 * time.Second,
+		SelfCheck:          defaultSelfCheckConfig(),
+		DataStreamStats:    defaultDataStreamStatsConfig(),
+		Alerting:           defaultAlertingConfig(),
+		RuntimeMetrics:     defaultRuntimeMetricsConfig(),
+		CostAccounting:     defaultCostAccountingConfig(),
+		Register:           defaultRegisterConfig(),
+		WaitReadyInterval:  time.Second,
+
+		WaitReadyMaxInterval: time.Minute,
 	}
 }
@@ -19,7 +19,6 @@ package config
 
 import (
 	"go/token"
-	"path/filepath"
 	"testing"
 	"time"
 
@@ -87,15 +86,18 @@ func TestUnpackConfig(t *testing.T) {
 		},
 		"overwrite default": {
 			inpCfg: map[string]interface{}{
-				"host":                    "localhost:3000",
-				"max_header_size":         8,
-				"max_event_size":          100,
-				"idle_timeout":            5 * time.Second,
-				"read_timeout":            3 * time.Second,
-				"write_timeout":           4 * time.Second,
-				"shutdown_timeout":        9 * time.Second,
-				"capture_personal_data":   true,
-				"max_concurrent_decoders": 100,
+				"host":                               "localhost:3000",
+				"max_header_size":                    8,
+				"max_event_size":                     100,
+				"decoding.mode":                      "lenient",
+				"timestamp_policy.rum":               "receive_time",
+				"idle_timeout":                       5 * time.Second,
+				"read_timeout":                       3 * time.Second,
+				"write_timeout":                      4 * time.Second,
+				"shutdown_timeout":                   9 * time.Second,
+				"capture_personal_data":              true,
+				"max_concurrent_decoders":            100,
+				"max_concurrent_requests_per_client": 10,
 				"auth": map[string]interface{}{
 					"secret_token": "1234random",
 					"api_key": map[string]interface{}{
@@ -127,31 +129,58 @@ func TestUnpackConfig(t *testing.T) {
 					"enabled": true,
 					"url":     "/debug/vars",
 				},
+				"prometheus": map[string]interface{}{
+					"enabled": true,
+					"url":     "/prometheus",
+				},
 				"rum": map[string]interface{}{
 					"enabled":       true,
 					"allow_origins": []string{"example*"},
 					"allow_headers": []string{"Authorization"},
 					"source_mapping": map[string]interface{}{
 						"cache": map[string]interface{}{
-							"expiration": 8 * time.Minute,
+							"expiration":      8 * time.Minute,
+							"miss_expiration": "2m",
 						},
 						"index_pattern":       "apm-test*",
 						"elasticsearch.hosts": []string{"localhost:9201", "localhost:9202"},
 						"timeout":             "2s",
+						"max_failure_backoff": "1m",
+						"event_timeout":       "500ms",
+						"max_concurrency":     4,
+						"kibana_migration": map[string]interface{}{
+							"enabled": true,
+							"index":   "apm-test-sourcemap",
+						},
 					},
 					"library_pattern":       "^custom",
 					"exclude_from_grouping": "^grouping",
+					"metadata_cache": map[string]interface{}{
+						"size":       10,
+						"expiration": "30s",
+					},
+					"url_grouping": map[string]interface{}{
+						"enabled":   true,
+						"templates": []string{"/users/:id"},
+						"rules": []map[string]interface{}{{
+							"pattern":     "^/blog/[^/]+$",
+							"replacement": "/blog/:slug",
+						}},
+						"replace_ids": false,
+					},
 				},
 				"register": map[string]interface{}{
 					"ingest": map[string]interface{}{
 						"pipeline": map[string]interface{}{
-							"overwrite": false,
-							"path":      filepath.Join("tmp", "definition.json"),
+							"enabled":   true,
+							"overwrite": true,
 						},
 					},
 				},
 				"kibana":                        map[string]interface{}{"enabled": "true"},
 				"agent.config.cache.expiration": "2m",
+				"agent.config.adoption.enabled": true,
+				"agent.config.adoption.window":  "5m",
 				"aggregation": map[string]interface{}{
 					"transactions": map[string]interface{}{
 						"interval":                         "1s",
@@ -164,23 +193,79 @@ func TestUnpackConfig(t *testing.T) {
 					"service": map[string]interface{}{
 						"max_groups": 457,
 					},
+					"error_rate": map[string]interface{}{
+						"enabled":  true,
+						"interval": "5m",
+					},
+					"mobile_vitals": map[string]interface{}{
+						"enabled":      true,
+						"max_sessions": 100,
+					},
+					"web_vitals": map[string]interface{}{
+						"enabled": true,
+						"page_groups": []map[string]interface{}{{
+							"name":    "products",
+							"pattern": "^/products/",
+						}},
+					},
 				},
+				"throttles": []map[string]interface{}{{
+					"name":        "nightly-logs",
+					"days":        []string{"mon", "Friday"},
+					"start":       "22:30",
+					"end":         "02:00",
+					"timezone":    "Europe/Berlin",
+					"event_types": []string{"log"},
+					"event_limit": 100,
+				}},
 				"default_service_environment":                     "overridden",
+				"string_labels":                                   []string{"build_number", "feature_flag"},
+				"global_labels":                                   map[string]interface{}{"cluster": "eu-1"},
+				"batch_processors":                                []string{"global_labels", "set_host_hostname"},
+				"span_hierarchy.enabled":                          true,
+				"span_hierarchy.action":                           "flag",
+				"future_timestamps.action":                        "clamp",
+				"future_timestamps.max_skew":                      "1m",
+				"provenance.enabled":                              true,
+				"forwarding.enabled":                              true,
+				"service_inventory.enabled":                       true,
+				"service_inventory.window":                        "5m",
+				"cost_accounting.enabled":                         true,
+				"cost_accounting.sample_rate":                     0.5,
+				"cost_accounting.max_services":                    10,
 				"profiling.enabled":                               true,
 				"profiling.metrics.elasticsearch.api_key":         "metrics_api_key",
 				"profiling.keyvalue_retention.age":                "4h",
 				"profiling.keyvalue_retention.size_bytes":         12345678,
 				"profiling.keyvalue_retention.execution_interval": "1s",
+				"self_check.enabled":                              true,
+				"self_check.interval":                             "10s",
+				"self_check.timeout":                              "5s",
+				"data_stream_stats.enabled":                       true,
+				"data_stream_stats.interval":                      "10m",
+				"alerting.enabled":                                true,
+				"alerting.interval":                               "30s",
+				"alerting.duration":                               "5m",
+				"alerting.webhook.url":                            "https://alerts.example.com",
+				"alerting.indexer_failover":                       false,
+				"alerting.indexing_failure_ratio":                 0.1,
+				"log_level_endpoint.enabled":                      true,
+				"queues_endpoint.enabled":                         true,
+				"runtime_metrics.enabled":                         true,
+				"runtime_metrics.interval":                        "10s",
 			},
 			outCfg: &Config{
-				Host:                  "localhost:3000",
-				MaxHeaderSize:         8,
-				MaxEventSize:          100,
-				IdleTimeout:           5000000000,
-				ReadTimeout:           3000000000,
-				WriteTimeout:          4000000000,
-				ShutdownTimeout:       9000000000,
-				MaxConcurrentDecoders: 100,
+				Host:                           "localhost:3000",
+				MaxHeaderSize:                  8,
+				MaxEventSize:                   100,
+				Decoding:                       DecodingConfig{Mode: DecodingModeLenient},
+				TimestampPolicy:                TimestampPolicyConfig{Intake: TimestampPolicyOffset, RUM: TimestampPolicyReceiveTime},
+				IdleTimeout:                    5000000000,
+				ReadTimeout:                    3000000000,
+				WriteTimeout:                   4000000000,
+				ShutdownTimeout:                9000000000,
+				MaxConcurrentDecoders:          100,
+				MaxConcurrentRequestsPerClient: 10,
 				AgentAuth: AgentAuth{
 					SecretToken: "1234random",
 					APIKey: APIKeyAgentAuth{
@@ -219,6 +304,10 @@ func TestUnpackConfig(t *testing.T) {
 					Enabled: true,
 					URL:     "/debug/vars",
 				},
+				Prometheus: PrometheusConfig{
+					Enabled: true,
+					URL:     "/prometheus",
+				},
 				Pprof: PprofConfig{
 					Enabled: false,
 				},
@@ -228,7 +317,7 @@ func TestUnpackConfig(t *testing.T) {
 					AllowHeaders: []string{"Authorization"},
 					SourceMapping: SourceMapping{
 						Enabled:      true,
-						Cache:        Cache{Expiration: 8 * time.Minute},
+						Cache:        SourceMapCache{Expiration: 8 * time.Minute, MissExpiration: 2 * time.Minute},
 						IndexPattern: "apm-test*",
 						ESConfig: &elasticsearch.Config{
 							Hosts:            elasticsearch.Hosts{"localhost:9201", "localhost:9202"},
@@ -238,18 +327,41 @@ func TestUnpackConfig(t *testing.T) {
 							CompressionLevel: 5,
 							Backoff:          elasticsearch.DefaultBackoffConfig,
 						},
-						Metadata:     []SourceMapMetadata{},
-						Timeout:      2 * time.Second,
-						esConfigured: true,
+						Metadata: []SourceMapMetadata{},
+						Timeout:  2 * time.Second,
+						KibanaMigration: SourceMapKibanaMigration{
+							Enabled: true,
+							Index:   "apm-test-sourcemap",
+						},
+						MaxFailureBackoff: time.Minute,
+						EventTimeout:      500 * time.Millisecond,
+						MaxConcurrency:    4,
+						esConfigured:      true,
 					},
 					LibraryPattern:      "^custom",
 					ExcludeFromGrouping: "^grouping",
+					MetadataCache: MetadataCache{
+						Size:       10,
+						Expiration: 30 * time.Second,
+					},
+					URLGrouping: URLGrouping{
+						Enabled:   true,
+						Templates: []string{"/users/:id"},
+						Rules: []URLGroupingRule{{
+							Pattern:     "^/blog/[^/]+$",
+							Replacement: "/blog/:slug",
+						}},
+						StripQueryParams: true,
+					},
 				},
 				Kibana: KibanaConfig{
 					Enabled:      true,
 					ClientConfig: defaultDecodedKibanaClientConfig,
 				},
-				KibanaAgentConfig: KibanaAgentConfig{Cache: Cache{Expiration: 2 * time.Minute}},
+				KibanaAgentConfig: KibanaAgentConfig{
+					Cache:    Cache{Expiration: 2 * time.Minute},
+					Adoption: AgentConfigAdoptionConfig{Enabled: true, Window: 5 * time.Minute},
+				},
 				Aggregation: AggregationConfig{
 					Transactions: TransactionAggregationConfig{
 						Interval:                       time.Second,
@@ -264,6 +376,27 @@ func TestUnpackConfig(t *testing.T) {
 						Interval:  time.Minute,
 						MaxGroups: 457,
 					},
+					ErrorRate: ErrorRateAggregationConfig{
+						Enabled:   true,
+						Interval:  5 * time.Minute,
+						MaxGroups: 10000,
+					},
+					MobileVitals: MobileVitalsAggregationConfig{
+						Enabled:     true,
+						Interval:    time.Minute,
+						MaxGroups:   10000,
+						MaxSessions: 100,
+					},
+					WebVitals: WebVitalsAggregationConfig{
+						Enabled:                        true,
+						Interval:                       time.Minute,
+						MaxGroups:                      10000,
+						HDRHistogramSignificantFigures: 2,
+						PageGroups: []PageGroupConfig{{
+							Name:    "products",
+							Pattern: "^/products/",
+						}},
+					},
 				},
 				Sampling: SamplingConfig{
 					Tail: TailSamplingConfig{
@@ -275,14 +408,41 @@ func TestUnpackConfig(t *testing.T) {
 						StorageLimit:          "3GB",
 						StorageLimitParsed:    3000000000,
 						TTL:                   30 * time.Minute,
+						Kafka:                 TailSamplingKafkaConfig{Topic: "apm-sampled-traces"},
+						Storage: TailSamplingStorageConfig{
+							ValueLogFileSize:       "64MiB",
+							ValueLogFileSizeParsed: 64 * 1024 * 1024,
+							MaxTableSize:           "16MiB",
+							MaxTableSizeParsed:     16 * 1024 * 1024,
+							NumMemtables:           4,
+						},
 					},
 				},
 				DefaultServiceEnvironment: "overridden",
+				StringLabels:              []string{"build_number", "feature_flag"},
+				GlobalLabels:              map[string]string{"cluster": "eu-1"},
+				BatchProcessors:           []string{"global_labels", "set_host_hostname"},
+				SpanHierarchy:             SpanHierarchyConfig{Enabled: true, Action: SpanHierarchyActionFlag},
+				FutureTimestamps:          FutureTimestampsConfig{Action: FutureTimestampsActionClamp, MaxSkew: time.Minute},
+				Provenance:                ProvenanceConfig{Enabled: true},
+				Forwarding:                ForwardingConfig{Enabled: true, RequireClientCertificate: true},
+				ServiceInventory:          ServiceInventoryConfig{Enabled: true, Window: 5 * time.Minute},
+				CostAccounting:            CostAccountingConfig{Enabled: true, SampleRate: 0.5, MaxServices: 10},
+				Throttles: []ThrottleConfig{{
+					Name:       "nightly-logs",
+					Days:       []Weekday{Weekday(time.Monday), Weekday(time.Friday)},
+					Start:      TimeOfDay(22*time.Hour + 30*time.Minute),
+					End:        TimeOfDay(2 * time.Hour),
+					Timezone:   "Europe/Berlin",
+					EventTypes: []string{"log"},
+					EventLimit: 100,
+				}},
 				DataStreams: DataStreamsConfig{
 					Namespace:          "default",
 					WaitForIntegration: true,
 				},
-				WaitReadyInterval: 5 * time.Second,
+				WaitReadyInterval:    time.Second,
+				WaitReadyMaxInterval: time.Minute,
 				Profiling: ProfilingConfig{
 					Enabled:  true,
 					ESConfig: elasticsearch.DefaultConfig(),
@@ -296,6 +456,41 @@ func TestUnpackConfig(t *testing.T) {
 						Interval:    time.Second,
 					},
 				},
+				SelfCheck: SelfCheckConfig{
+					Enabled:  true,
+					Interval: 10 * time.Second,
+					Timeout:  5 * time.Second,
+				},
+				DataStreamStats: DataStreamStatsConfig{
+					Enabled:  true,
+					Interval: 10 * time.Minute,
+				},
+				Alerting: AlertingConfig{
+					Enabled:  true,
+					Interval: 30 * time.Second,
+					Duration: 5 * time.Minute,
+					Webhook: WebhookConfig{
+						URL:     "https://alerts.example.com",
+						Timeout: 10 * time.Second,
+					},
+					IndexerFailover:          false,
+					IndexingFailureRatio:     0.1,
+					TailSamplingStorageRatio: 0.9,
+				},
+				LogLevelEndpoint: LogLevelEndpointConfig{Enabled: true},
+				QueuesEndpoint:   QueuesEndpointConfig{Enabled: true},
+				RuntimeMetrics: RuntimeMetricsConfig{
+					Enabled:  true,
+					Interval: 10 * time.Second,
+				},
+				Register: RegisterConfig{
+					Ingest: IngestRegisterConfig{
+						Pipeline: PipelineRegisterConfig{
+							Enabled:   true,
+							Overwrite: true,
+						},
+					},
+				},
 			},
 		},
 		"merge config with default": {
@@ -343,24 +538,39 @@ func TestUnpackConfig(t *testing.T) {
 				},
 				"sampling.tail": map[string]interface{}{
 					"enabled":           false,
+					"dry_run":           true,
 					"policies":          []map[string]interface{}{{"sample_rate": 0.5}},
 					"interval":          "2m",
 					"ingest_rate_decay": 1.0,
 					"storage_limit":     "1GB",
+					"storage": map[string]interface{}{
+						"compression":         true,
+						"value_log_file_size": "32MiB",
+						"max_table_size":      "8MiB",
+						"num_memtables":       2,
+					},
 				},
 				"data_streams": map[string]interface{}{
-					"namespace":            "foo",
-					"wait_for_integration": false,
+					"namespace":                  "foo",
+					"namespace_from_environment": true,
+					"wait_for_integration":       false,
 				},
 			},
 			outCfg: &Config{
-				Host:            "localhost:3000",
-				MaxHeaderSize:   1048576,
-				MaxEventSize:    307200,
-				IdleTimeout:     45000000000,
-				ReadTimeout:     30000000000,
-				WriteTimeout:    30000000000,
-				ShutdownTimeout: 30000000000,
+				Host:             "localhost:3000",
+				MaxHeaderSize:    1048576,
+				MaxEventSize:     307200,
+				Decoding:         DecodingConfig{Mode: DecodingModeStrict},
+				TimestampPolicy:  TimestampPolicyConfig{Intake: TimestampPolicyOffset, RUM: TimestampPolicyOffset},
+				SpanHierarchy:    SpanHierarchyConfig{Action: SpanHierarchyActionFix},
+				FutureTimestamps: FutureTimestampsConfig{Action: FutureTimestampsActionAccept, MaxSkew: 5 * time.Minute},
+				Forwarding:       ForwardingConfig{RequireClientCertificate: true},
+				ServiceInventory: ServiceInventoryConfig{Window: 10 * time.Minute},
+				CostAccounting:   defaultCostAccountingConfig(),
+				IdleTimeout:      45000000000,
+				ReadTimeout:      30000000000,
+				WriteTimeout:     30000000000,
+				ShutdownTimeout:  30000000000,
 				AgentAuth: AgentAuth{
 					SecretToken: "1234random",
 					APIKey: APIKeyAgentAuth{
@@ -388,6 +598,10 @@ func TestUnpackConfig(t *testing.T) {
 					Enabled: true,
 					URL:     "/debug/vars",
 				},
+				Prometheus: PrometheusConfig{
+					Enabled: false,
+					URL:     "/metrics",
+				},
 				Pprof: PprofConfig{
 					Enabled: true,
 				},
@@ -397,8 +611,9 @@ func TestUnpackConfig(t *testing.T) {
 					AllowHeaders: []string{},
 					SourceMapping: SourceMapping{
 						Enabled: true,
-						Cache: Cache{
-							Expiration: 7 * time.Second,
+						Cache: SourceMapCache{
+							Expiration:     7 * time.Second,
+							MissExpiration: time.Minute,
 						},
 						IndexPattern: "apm-*-sourcemap*",
 						ESConfig:     elasticsearch.DefaultConfig(),
@@ -411,12 +626,29 @@ func TestUnpackConfig(t *testing.T) {
 							},
 						},
 						Timeout: 5 * time.Second,
+						KibanaMigration: SourceMapKibanaMigration{
+							Index: "apm-%{[observer.version]}-sourcemap",
+						},
+						MaxFailureBackoff: 30 * time.Second,
+						EventTimeout:      time.Second,
+						MaxConcurrency:    10,
 					},
 					LibraryPattern:      "rum",
 					ExcludeFromGrouping: "^/webpack",
+					MetadataCache: MetadataCache{
+						Size:       1000,
+						Expiration: time.Minute,
+					},
+					URLGrouping: URLGrouping{
+						ReplaceIDs:       true,
+						StripQueryParams: true,
+					},
+				},
+				Kibana: defaultKibanaConfig(),
+				KibanaAgentConfig: KibanaAgentConfig{
+					Cache:    Cache{Expiration: 30 * time.Second},
+					Adoption: AgentConfigAdoptionConfig{Window: 10 * time.Minute},
 				},
-				Kibana:            defaultKibanaConfig(),
-				KibanaAgentConfig: KibanaAgentConfig{Cache: Cache{Expiration: 30 * time.Second}},
 				Aggregation: AggregationConfig{
 					Transactions: TransactionAggregationConfig{
 						Interval:                       time.Minute,
@@ -431,10 +663,25 @@ func TestUnpackConfig(t *testing.T) {
 						Interval:  time.Minute,
 						MaxGroups: 10000,
 					},
+					ErrorRate: ErrorRateAggregationConfig{
+						Interval:  time.Minute,
+						MaxGroups: 10000,
+					},
+					MobileVitals: MobileVitalsAggregationConfig{
+						Interval:    time.Minute,
+						MaxGroups:   10000,
+						MaxSessions: 10000,
+					},
+					WebVitals: WebVitalsAggregationConfig{
+						Interval:                       time.Minute,
+						MaxGroups:                      10000,
+						HDRHistogramSignificantFigures: 2,
+					},
 				},
 				Sampling: SamplingConfig{
 					Tail: TailSamplingConfig{
 						Enabled:               false,
+						DryRun:                true,
 						Policies:              []TailSamplingPolicy{{SampleRate: 0.5}},
 						ESConfig:              elasticsearch.DefaultConfig(),
 						Interval:              2 * time.Minute,
@@ -443,19 +690,35 @@ func TestUnpackConfig(t *testing.T) {
 						StorageLimit:          "1GB",
 						StorageLimitParsed:    1000000000,
 						TTL:                   30 * time.Minute,
+						Kafka:                 TailSamplingKafkaConfig{Topic: "apm-sampled-traces"},
+						Storage: TailSamplingStorageConfig{
+							Compression:            true,
+							ValueLogFileSize:       "32MiB",
+							ValueLogFileSizeParsed: 32 * 1024 * 1024,
+							MaxTableSize:           "8MiB",
+							MaxTableSizeParsed:     8 * 1024 * 1024,
+							NumMemtables:           2,
+						},
 					},
 				},
 				DataStreams: DataStreamsConfig{
-					Namespace:          "foo",
-					WaitForIntegration: false,
+					Namespace:                "foo",
+					NamespaceFromEnvironment: true,
+					WaitForIntegration:       false,
 				},
-				WaitReadyInterval: 5 * time.Second,
+				WaitReadyInterval:    time.Second,
+				WaitReadyMaxInterval: time.Minute,
 				Profiling: ProfilingConfig{
 					Enabled:         false,
 					ESConfig:        elasticsearch.DefaultConfig(),
 					MetricsESConfig: elasticsearch.DefaultConfig(),
 					ILMConfig:       defaultProfilingILMConfig(),
 				},
+				SelfCheck:       defaultSelfCheckConfig(),
+				DataStreamStats: defaultDataStreamStatsConfig(),
+				Alerting:        defaultAlertingConfig(),
+				RuntimeMetrics:  defaultRuntimeMetricsConfig(),
+				Register:        defaultRegisterConfig(),
 			},
 		},
 		"kibana trailing slash": {
@@ -625,6 +888,167 @@ func TestNewConfig_ESConfig(t *testing.T) {
 	assert.NotEqual(t, []string{"192.0.0.168:9200"}, []string(cfg.Profiling.MetricsESConfig.Hosts))
 }
 
+func TestNewConfig_InvalidDecodingMode(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"decoding.mode": "relaxed"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, `invalid decoding mode "relaxed"`)
+}
+
+func TestNewConfig_InvalidTimestampPolicy(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"timestamp_policy.intake": "now"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, `invalid timestamp policy "now"`)
+}
+
+func TestNewConfig_InvalidSpanHierarchyAction(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"span_hierarchy.action": "ignore"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, `invalid span hierarchy action "ignore"`)
+}
+
+func TestNewConfig_InvalidFutureTimestampsAction(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"future_timestamps.action": "reject"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, `invalid future timestamps action "reject"`)
+}
+
+func TestNewConfig_InvalidServiceInventoryWindow(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"service_inventory.window": "30s"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "service inventory window must be at least 1m")
+}
+
+func TestNewConfig_InvalidCostAccountingSampleRate(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"cost_accounting.sample_rate": 0})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "cost_accounting.sample_rate must be greater than 0 and at most 1")
+}
+
+func TestNewConfig_InvalidAgentConfigAdoptionWindow(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"agent.config.adoption.window": "30s"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "agent config adoption window must be at least 1m")
+}
+
+func TestNewConfig_InvalidAlerting(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"alerting.enabled": true})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "alerting.webhook.url must be specified when alerting is enabled")
+
+	ucfg = config.MustNewConfigFrom(map[string]interface{}{"alerting.indexing_failure_ratio": 1.5})
+	_, err = NewConfig(ucfg, nil)
+	assert.Error(t, err)
+}
+
+func TestNewConfig_InvalidRuntimeMetricsInterval(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"runtime_metrics.interval": "100ms"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "runtime_metrics.interval")
+}
+
+func TestNewConfig_InvalidDataStreamStatsInterval(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"data_stream_stats.interval": "10s"})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "data_stream_stats.interval")
+}
+
+func TestNewConfig_ForwardingRequiresAuth(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{"forwarding.enabled": true})
+	_, err := NewConfig(ucfg, nil)
+	assert.ErrorContains(t, err, "forwarding requires auth.api_key.enabled or auth.secret_token to be configured")
+
+	ucfg = config.MustNewConfigFrom(map[string]interface{}{
+		"forwarding.enabled":                    true,
+		"forwarding.require_client_certificate": false,
+		"auth.secret_token":                     "abc123",
+	})
+	_, err = NewConfig(ucfg, nil)
+	assert.NoError(t, err)
+}
+
+func TestNewConfig_ForwardingRequiresClientAuthentication(t *testing.T) {
+	ucfg := config.MustNewConfigFrom(map[string]interface{}{
+		"forwarding.enabled": true,
+		"auth.secret_token":  "abc123",
+		"ssl.certificate":    testdataCertificateConfig.Certificate,
+		"ssl.key":            testdataCertificateConfig.Key,
+	})
+	_, err := NewConfig(ucfg, nil)
+	assert.EqualError(t, err, "forwarding.require_client_certificate requires ssl.client_authentication to be optional or required")
+
+	for _, clientAuth := range []string{"optional", "required"} {
+		ucfg := config.MustNewConfigFrom(map[string]interface{}{
+			"forwarding.enabled":        true,
+			"auth.secret_token":         "abc123",
+			"ssl.certificate":           testdataCertificateConfig.Certificate,
+			"ssl.key":                   testdataCertificateConfig.Key,
+			"ssl.client_authentication": clientAuth,
+		})
+		_, err := NewConfig(ucfg, nil)
+		assert.NoError(t, err, clientAuth)
+	}
+}
+
+func TestNewConfig_Throttles(t *testing.T) {
+	throttle := func(overrides map[string]interface{}) map[string]interface{} {
+		m := map[string]interface{}{"name": "nightly", "start": "01:00", "end": "04:00"}
+		for k, v := range overrides {
+			m[k] = v
+		}
+		return m
+	}
+	for name, test := range map[string]struct {
+		throttles []map[string]interface{}
+		err       string
+	}{
+		"valid": {
+			throttles: []map[string]interface{}{throttle(nil)},
+		},
+		"missing_name": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"name": ""})},
+			err:       "string value is not set",
+		},
+		"invalid_start": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"start": "25:00"})},
+			err:       `invalid time of day "25:00", expected HH:MM`,
+		},
+		"start_equals_end": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"end": "01:00"})},
+			err:       `throttle "nightly": start and end must differ`,
+		},
+		"invalid_day": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"days": []string{"someday"}})},
+			err:       `invalid day of the week "someday"`,
+		},
+		"invalid_timezone": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"timezone": "Nowhere/Special"})},
+			err:       `throttle "nightly": unknown time zone Nowhere/Special`,
+		},
+		"invalid_event_type": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"event_types": []string{"logs"}})},
+			err:       `throttle "nightly": invalid event type "logs"`,
+		},
+		"negative_event_limit": {
+			throttles: []map[string]interface{}{throttle(map[string]interface{}{"event_limit": -1})},
+			err:       "requires value >= 0",
+		},
+		"duplicate_name": {
+			throttles: []map[string]interface{}{throttle(nil), throttle(nil)},
+			err:       `duplicate throttle name "nightly"`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			ucfg := config.MustNewConfigFrom(map[string]interface{}{"throttles": test.throttles})
+			_, err := NewConfig(ucfg, nil)
+			if test.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, test.err)
+			}
+		})
+	}
+}
+
 func newBool(v bool) *bool {
 	return &v
 }
@@ -0,0 +1,50 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "errors"
+
+// CostAccountingConfig holds configuration related to accounting the
+// processing cost of events, per service and event type.
+type CostAccountingConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// SampleRate holds the fraction of batches whose processing
+	// cost is measured, greater than 0 and at most 1.
+	SampleRate float64 `config:"sample_rate"`
+
+	// MaxServices holds the maximum number of services for which
+	// processing cost is tracked.
+	MaxServices int `config:"max_services" validate:"min=1"`
+}
+
+// Validate validates the cost accounting configuration.
+func (c *CostAccountingConfig) Validate() error {
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return errors.New("cost_accounting.sample_rate must be greater than 0 and at most 1")
+	}
+	return nil
+}
+
+func defaultCostAccountingConfig() CostAccountingConfig {
+	return CostAccountingConfig{
+		Enabled:     false,
+		SampleRate:  0.01,
+		MaxServices: 1000,
+	}
+}
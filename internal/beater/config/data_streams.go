@@ -21,6 +21,12 @@ package config
 type DataStreamsConfig struct {
 	Namespace string `config:"namespace"`
 
+	// NamespaceFromEnvironment controls whether events are routed to a data
+	// stream namespace derived from their service.environment. Namespace, or
+	// the Fleet policy namespace, is used for events with no environment,
+	// after applying `apm-server.default_service_environment`.
+	NamespaceFromEnvironment bool `config:"namespace_from_environment"`
+
 	// WaitForIntegration controls whether APM Server waits for the Fleet
 	// integration package to be installed before indexing events.
 	//
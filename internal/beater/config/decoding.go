@@ -0,0 +1,56 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "fmt"
+
+const (
+	// DecodingModeStrict rejects intake events which do not conform
+	// to the intake schema.
+	DecodingModeStrict = "strict"
+
+	// DecodingModeLenient coerces minor intake schema violations,
+	// such as numbers encoded as strings, rather than rejecting events.
+	DecodingModeLenient = "lenient"
+)
+
+// DecodingConfig holds configuration related to decoding intake events.
+type DecodingConfig struct {
+	// Mode controls how intake events which do not strictly conform to
+	// the intake schema are handled: either DecodingModeStrict, or
+	// DecodingModeLenient.
+	Mode string `config:"mode"`
+}
+
+// Validate validates the decoding configuration.
+func (c *DecodingConfig) Validate() error {
+	switch c.Mode {
+	case DecodingModeStrict, DecodingModeLenient:
+		return nil
+	}
+	return fmt.Errorf("invalid decoding mode %q, expected one of %q or %q", c.Mode, DecodingModeStrict, DecodingModeLenient)
+}
+
+// Lenient reports whether intake events should be decoded leniently.
+func (c *DecodingConfig) Lenient() bool {
+	return c.Mode == DecodingModeLenient
+}
+
+func defaultDecodingConfig() DecodingConfig {
+	return DecodingConfig{Mode: DecodingModeStrict}
+}
@@ -0,0 +1,60 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
+)
+
+// ForwardingConfig holds configuration related to accepting events
+// forwarded by other APM Server instances, which run with an output
+// forwarding events upstream rather than indexing them.
+type ForwardingConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// RequireClientCertificate controls whether forwarding APM Server
+	// instances must present a client certificate verified by the server's
+	// TLS configuration, in addition to a secret token or API Key.
+	RequireClientCertificate bool `config:"require_client_certificate"`
+}
+
+func defaultForwardingConfig() ForwardingConfig {
+	return ForwardingConfig{RequireClientCertificate: true}
+}
+
+// setup validates the forwarding configuration against the agent auth
+// and TLS configuration. Forwarded events are only accepted from
+// authenticated APM Server instances, so auth must be configured, and
+// client certificates must be verified unless explicitly disabled.
+func (c *ForwardingConfig) setup(auth AgentAuth, tlsConfig *tlscommon.ServerConfig) error {
+	if !c.Enabled {
+		return nil
+	}
+	if !auth.APIKey.Enabled && auth.SecretToken == "" {
+		return errors.New("forwarding requires auth.api_key.enabled or auth.secret_token to be configured")
+	}
+	if c.RequireClientCertificate {
+		if !tlsConfig.IsEnabled() || tls.ClientAuthType(tlsConfig.ClientAuth) < tls.VerifyClientCertIfGiven {
+			return errors.New("forwarding.require_client_certificate requires ssl.client_authentication to be optional or required")
+		}
+	}
+	return nil
+}
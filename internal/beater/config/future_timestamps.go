@@ -0,0 +1,67 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// FutureTimestampsActionAccept accepts events timestamped in the future.
+	FutureTimestampsActionAccept = "accept"
+
+	// FutureTimestampsActionClamp sets the timestamp of events timestamped
+	// in the future to the time they are received.
+	FutureTimestampsActionClamp = "clamp"
+
+	// FutureTimestampsActionDrop drops events timestamped in the future.
+	FutureTimestampsActionDrop = "drop"
+)
+
+// FutureTimestampsConfig holds configuration related to handling events
+// received with timestamps in the future.
+type FutureTimestampsConfig struct {
+	// Action defines how events timestamped more than MaxSkew in the
+	// future are handled.
+	Action string `config:"action"`
+
+	// MaxSkew holds the amount of time by which an event's timestamp may
+	// be in the future before it is handled according to Action, to allow
+	// for clock skew between agents and the server.
+	MaxSkew time.Duration `config:"max_skew" validate:"min=0"`
+}
+
+// Validate validates the future timestamps configuration.
+func (c *FutureTimestampsConfig) Validate() error {
+	switch c.Action {
+	case FutureTimestampsActionAccept, FutureTimestampsActionClamp, FutureTimestampsActionDrop:
+		return nil
+	}
+	return fmt.Errorf(
+		"invalid future timestamps action %q, expected one of %q, %q, or %q", c.Action,
+		FutureTimestampsActionAccept, FutureTimestampsActionClamp, FutureTimestampsActionDrop,
+	)
+}
+
+func defaultFutureTimestampsConfig() FutureTimestampsConfig {
+	return FutureTimestampsConfig{
+		Action:  FutureTimestampsActionAccept,
+		MaxSkew: 5 * time.Minute,
+	}
+}
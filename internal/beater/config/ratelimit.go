@@ -29,3 +29,10 @@ type RateLimit struct {
 	// done to avoid DDoS attacks.
 	IPLimit int `config:"ip_limit"`
 }
+
+// APIKeyRateLimit holds configuration related to API Key event rate limiting.
+type APIKeyRateLimit struct {
+	// EventLimit holds the event rate limit per API Key, measured in
+	// events per second. If zero, API Key rate limiting is disabled.
+	EventLimit int `config:"event_limit"`
+}
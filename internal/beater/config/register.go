@@ -0,0 +1,50 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+// RegisterConfig holds configuration related to registering Elasticsearch
+// resources on startup.
+type RegisterConfig struct {
+	Ingest IngestRegisterConfig `config:"ingest"`
+}
+
+// IngestRegisterConfig holds configuration related to registering ingest
+// resources.
+type IngestRegisterConfig struct {
+	Pipeline PipelineRegisterConfig `config:"pipeline"`
+}
+
+// PipelineRegisterConfig holds configuration related to registering the
+// ingest pipelines APM Server depends on.
+//
+// This config is ignored when running under Elastic Agent; it is intended
+// for running APM Server standalone without installing the integration
+// package, which would otherwise install the ingest pipelines.
+type PipelineRegisterConfig struct {
+	// Enabled controls whether APM Server creates or updates its ingest
+	// pipelines in Elasticsearch on startup.
+	Enabled bool `config:"enabled"`
+
+	// Overwrite controls whether existing ingest pipelines are updated
+	// regardless of their version.
+	Overwrite bool `config:"overwrite"`
+}
+
+func defaultRegisterConfig() RegisterConfig {
+	return RegisterConfig{}
+}
@@ -33,9 +33,16 @@ const (
 	allowAllOrigins                 = "*"
 	defaultExcludeFromGrouping      = "^/webpack"
 	defaultLibraryPattern           = "node_modules|bower_components|~"
+	defaultMetadataCacheExpiration  = time.Minute
+	defaultMetadataCacheSize        = 1000
 	defaultSourcemapCacheExpiration = 5 * time.Minute
+	defaultSourcemapMissExpiration  = time.Minute
+	defaultSourcemapFailureBackoff  = 30 * time.Second
 	defaultSourcemapIndexPattern    = "apm-*-sourcemap*"
+	defaultSourcemapMigrationIndex  = "apm-%{[observer.version]}-sourcemap"
 	defaultSourcemapTimeout         = 5 * time.Second
+	defaultSourcemapEventTimeout    = time.Second
+	defaultSourcemapMaxConcurrency  = 10
 )
 
 // RumConfig holds config information related to the RUM endpoint
@@ -47,17 +54,111 @@ type RumConfig struct {
 	LibraryPattern      string              `config:"library_pattern"`
 	ExcludeFromGrouping string              `config:"exclude_from_grouping"`
 	SourceMapping       SourceMapping       `config:"source_mapping"`
+	MetadataCache       MetadataCache       `config:"metadata_cache"`
+	URLGrouping         URLGrouping         `config:"url_grouping"`
+}
+
+// URLGrouping holds configuration for grouping the URL paths and
+// transaction names of RUM events at ingest, collapsing high cardinality
+// paths when agents cannot infer routes.
+type URLGrouping struct {
+	Enabled bool `config:"enabled"`
+
+	// Templates holds path templates, such as "/users/:id/orders/:id".
+	// Template segments that start with ':', or are '*', match any single
+	// path segment. Matching paths are replaced with the template.
+	Templates []string `config:"templates"`
+
+	// Rules holds regular expression replacement rules, applied to paths
+	// which match no template.
+	Rules []URLGroupingRule `config:"rules"`
+
+	// ReplaceIDs controls whether ID-like path segments, such as numbers,
+	// UUIDs, and hex strings, are replaced with ":id" in paths which match
+	// no template or rule.
+	ReplaceIDs bool `config:"replace_ids"`
+
+	// StripQueryParams controls whether the query string and fragment are
+	// stripped from transaction names.
+	StripQueryParams bool `config:"strip_query_params"`
+}
+
+// URLGroupingRule holds a regular expression replacement rule for grouping
+// URL paths. The first rule whose Pattern matches a path is applied.
+type URLGroupingRule struct {
+	Pattern     string `config:"pattern" validate:"required"`
+	Replacement string `config:"replacement"`
+}
+
+// Validate validates the URL grouping rule.
+func (r *URLGroupingRule) Validate() error {
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return errors.Wrapf(err, "Invalid regex for `url_grouping.rules.pattern`: ")
+	}
+	return nil
+}
+
+// MetadataCache holds configuration for caching decoded RUM metadata,
+// which is reused by successive requests with identical metadata.
+type MetadataCache struct {
+	// Size holds the maximum number of distinct metadata objects
+	// cached. Setting Size to zero disables the cache.
+	Size int `config:"size" validate:"min=0"`
+
+	// Expiration holds the amount of time for which decoded
+	// metadata is cached.
+	Expiration time.Duration `config:"expiration" validate:"min=1s"`
 }
 
 // SourceMapping holds sourcemap config information
 type SourceMapping struct {
-	Cache        Cache                 `config:"cache"`
+	Cache        SourceMapCache        `config:"cache"`
 	Enabled      bool                  `config:"enabled"`
 	IndexPattern string                `config:"index_pattern"`
 	ESConfig     *elasticsearch.Config `config:"elasticsearch"`
 	Metadata     []SourceMapMetadata   `config:"metadata"`
 	Timeout      time.Duration         `config:"timeout" validate:"positive"`
-	esConfigured bool
+	// MaxFailureBackoff holds the maximum duration for which fetching
+	// a source map is skipped after consecutive temporary failures
+	// to fetch it. Setting MaxFailureBackoff to zero disables backoff.
+	MaxFailureBackoff time.Duration `config:"max_failure_backoff" validate:"min=0"`
+	// EventTimeout holds the maximum duration for applying source maps
+	// to the stack traces of a single event, after which the event's
+	// frames are left unmapped. Setting EventTimeout to zero disables it.
+	EventTimeout time.Duration `config:"event_timeout" validate:"min=0"`
+	// MaxConcurrency holds the maximum number of events to which source
+	// maps are applied concurrently, across all requests. Setting
+	// MaxConcurrency to zero applies source maps to the events of each
+	// request sequentially, without a bound across requests.
+	MaxConcurrency int `config:"max_concurrency" validate:"min=0"`
+	// KibanaMigration holds configuration for migrating source maps
+	// stored by Kibana to Elasticsearch.
+	KibanaMigration SourceMapKibanaMigration `config:"kibana_migration"`
+	esConfigured    bool
+}
+
+// SourceMapCache holds configuration for caching source maps.
+type SourceMapCache struct {
+	// Expiration holds the duration for which fetched source maps are cached.
+	Expiration time.Duration `config:"expiration"`
+
+	// MissExpiration holds the duration for which missing source
+	// maps are cached, avoiding repeated lookups for bundles with
+	// no source map.
+	MissExpiration time.Duration `config:"miss_expiration" validate:"min=0"`
+}
+
+// SourceMapKibanaMigration holds configuration for migrating source maps
+// from Kibana to Elasticsearch.
+type SourceMapKibanaMigration struct {
+	// Enabled controls whether source maps fetched from Kibana are
+	// indexed into Elasticsearch.
+	Enabled bool `config:"enabled"`
+
+	// Index holds the name of the Elasticsearch index into which
+	// source maps are migrated. Index should match IndexPattern,
+	// so migrated source maps may be fetched from Elasticsearch.
+	Index string `config:"index"`
 }
 
 func (c *RumConfig) setup(log *logp.Logger, outputESCfg *config.C) error {
@@ -105,11 +206,17 @@ func (s *SourceMapping) Unpack(inp *config.C) error {
 func defaultSourcemapping() SourceMapping {
 	return SourceMapping{
 		Enabled:      true,
-		Cache:        Cache{Expiration: defaultSourcemapCacheExpiration},
+		Cache:        SourceMapCache{Expiration: defaultSourcemapCacheExpiration, MissExpiration: defaultSourcemapMissExpiration},
 		IndexPattern: defaultSourcemapIndexPattern,
 		ESConfig:     elasticsearch.DefaultConfig(),
 		Metadata:     []SourceMapMetadata{},
 		Timeout:      defaultSourcemapTimeout,
+		KibanaMigration: SourceMapKibanaMigration{
+			Index: defaultSourcemapMigrationIndex,
+		},
+		MaxFailureBackoff: defaultSourcemapFailureBackoff,
+		EventTimeout:      defaultSourcemapEventTimeout,
+		MaxConcurrency:    defaultSourcemapMaxConcurrency,
 	}
 }
 
@@ -120,5 +227,13 @@ func defaultRum() RumConfig {
 		SourceMapping:       defaultSourcemapping(),
 		LibraryPattern:      defaultLibraryPattern,
 		ExcludeFromGrouping: defaultExcludeFromGrouping,
+		MetadataCache: MetadataCache{
+			Size:       defaultMetadataCacheSize,
+			Expiration: defaultMetadataCacheExpiration,
+		},
+		URLGrouping: URLGrouping{
+			ReplaceIDs:       true,
+			StripQueryParams: true,
+		},
 	}
 }
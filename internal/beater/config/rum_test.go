@@ -49,3 +49,25 @@ func TestDefaultRum(t *testing.T) {
 	c := DefaultConfig()
 	assert.Equal(t, defaultRum(), c.RumConfig)
 }
+
+func TestRumMetadataCacheInvalid(t *testing.T) {
+	for name, test := range map[string]struct {
+		cfg map[string]interface{}
+		err string
+	}{
+		"negative_size": {
+			cfg: map[string]interface{}{"rum.metadata_cache.size": -1},
+			err: "requires value >= 0",
+		},
+		"zero_expiration": {
+			cfg: map[string]interface{}{"rum.metadata_cache.expiration": "0s"},
+			err: "requires duration >= 1s",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewConfig(config.MustNewConfigFrom(test.cfg), nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), test.err)
+		})
+	}
+}
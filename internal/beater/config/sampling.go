@@ -27,6 +27,7 @@ import (
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 )
 
 // SamplingConfig holds configuration related to sampling.
@@ -39,6 +40,11 @@ type SamplingConfig struct {
 type TailSamplingConfig struct {
 	Enabled bool `config:"enabled"`
 
+	// DryRun, if true, causes tail-sampling policies to be evaluated and
+	// sampling decisions to be recorded in metrics, but all events to be
+	// indexed regardless of the sampling decisions.
+	DryRun bool `config:"dry_run"`
+
 	// Policies holds tail-sampling policies.
 	//
 	// Policies must include at least one policy that matches all traces, to ensure
@@ -53,9 +59,67 @@ type TailSamplingConfig struct {
 	StorageLimit          string                `config:"storage_limit"`
 	StorageLimitParsed    uint64
 
+	// Storage holds options for the local storage of trace events.
+	Storage TailSamplingStorageConfig `config:"storage"`
+
+	// Kafka holds configuration for sharing sampling decisions between
+	// APM Server instances through Kafka, rather than Elasticsearch.
+	Kafka TailSamplingKafkaConfig `config:"kafka"`
+
+	// Cluster holds configuration for coordinating tail-sampling between
+	// APM Server instances, such that all events for a trace are forwarded
+	// to, and sampled by, a single instance.
+	Cluster TailSamplingClusterConfig `config:"cluster"`
+
 	esConfigured bool
 }
 
+// TailSamplingKafkaConfig holds configuration related to publishing and
+// subscribing to tail-sampling decisions through Kafka.
+type TailSamplingKafkaConfig struct {
+	Enabled  bool              `config:"enabled"`
+	Hosts    []string          `config:"hosts"`
+	Topic    string            `config:"topic"`
+	Username string            `config:"username"`
+	Password string            `config:"password"`
+	TLS      *tlscommon.Config `config:"ssl"`
+}
+
+// TailSamplingClusterConfig holds configuration related to forwarding trace
+// events between APM Server instances, based on ownership of trace IDs.
+type TailSamplingClusterConfig struct {
+	Enabled bool `config:"enabled"`
+
+	// Members holds the gRPC addresses (host:port) of all APM Server
+	// instances in the cluster, including this one.
+	Members []string `config:"members"`
+
+	// Self holds the address of this APM Server instance, as it
+	// appears in Members.
+	Self string `config:"self"`
+
+	// SecretToken and APIKey hold optional credentials for authenticating
+	// with other APM Server instances when forwarding events.
+	SecretToken string `config:"secret_token"`
+	APIKey      string `config:"api_key"`
+
+	TLS *tlscommon.Config `config:"ssl"`
+}
+
+// TailSamplingStorageConfig holds configuration related to the local
+// storage of trace events pending a tail-sampling decision.
+type TailSamplingStorageConfig struct {
+	// Compression controls whether stored events are compressed,
+	// reducing disk usage at the cost of CPU.
+	Compression bool `config:"compression"`
+
+	ValueLogFileSize       string `config:"value_log_file_size"`
+	ValueLogFileSizeParsed uint64
+	MaxTableSize           string `config:"max_table_size"`
+	MaxTableSizeParsed     uint64
+	NumMemtables           int `config:"num_memtables" validate:"min=1"`
+}
+
 // TailSamplingPolicy holds a tail-sampling policy.
 type TailSamplingPolicy struct {
 	// Service holds attributes of the service which this policy matches.
@@ -95,6 +159,14 @@ func (c *TailSamplingConfig) Unpack(in *config.C) error {
 		return err
 	}
 	cfg.StorageLimitParsed = limit
+	if cfg.Storage.ValueLogFileSizeParsed, err = humanize.ParseBytes(cfg.Storage.ValueLogFileSize); err != nil {
+		err = errors.Wrap(err, "invalid storage.value_log_file_size")
+		return nil
+	}
+	if cfg.Storage.MaxTableSizeParsed, err = humanize.ParseBytes(cfg.Storage.MaxTableSize); err != nil {
+		err = errors.Wrap(err, "invalid storage.max_table_size")
+		return nil
+	}
 	cfg.Enabled = in.Enabled()
 	*c = TailSamplingConfig(cfg)
 	c.esConfigured = in.HasField("elasticsearch")
@@ -121,6 +193,29 @@ func (c *TailSamplingConfig) Validate() error {
 	if !anyDefaultPolicy {
 		return errors.New("no default (empty criteria) policy specified")
 	}
+	if c.Kafka.Enabled {
+		if len(c.Kafka.Hosts) == 0 {
+			return errors.New("no kafka hosts specified")
+		}
+		if c.Kafka.Topic == "" {
+			return errors.New("no kafka topic specified")
+		}
+	}
+	if c.Cluster.Enabled {
+		if len(c.Cluster.Members) == 0 {
+			return errors.New("no cluster members specified")
+		}
+		var foundSelf bool
+		for _, member := range c.Cluster.Members {
+			if member == c.Cluster.Self {
+				foundSelf = true
+				break
+			}
+		}
+		if !foundSelf {
+			return errors.New("cluster self must be one of the cluster members")
+		}
+	}
 	return nil
 }
 
@@ -153,11 +248,23 @@ func defaultTailSamplingConfig() TailSamplingConfig {
 		StorageGCInterval:     5 * time.Minute,
 		TTL:                   30 * time.Minute,
 		StorageLimit:          "3GB",
+		Kafka:                 TailSamplingKafkaConfig{Topic: "apm-sampled-traces"},
+		Storage: TailSamplingStorageConfig{
+			ValueLogFileSize: "64MiB",
+			MaxTableSize:     "16MiB",
+			NumMemtables:     4,
+		},
 	}
 	parsed, err := humanize.ParseBytes(cfg.StorageLimit)
 	if err != nil {
 		panic(err)
 	}
 	cfg.StorageLimitParsed = parsed
+	if cfg.Storage.ValueLogFileSizeParsed, err = humanize.ParseBytes(cfg.Storage.ValueLogFileSize); err != nil {
+		panic(err)
+	}
+	if cfg.Storage.MaxTableSizeParsed, err = humanize.ParseBytes(cfg.Storage.MaxTableSize); err != nil {
+		panic(err)
+	}
 	return cfg
 }
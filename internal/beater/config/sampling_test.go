@@ -51,4 +51,51 @@ func TestSamplingPoliciesValidation(t *testing.T) {
 		assert.NoError(t, err)
 		assert.False(t, c.Sampling.Tail.Enabled)
 	})
+	t.Run("KafkaNoHosts", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies":      []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.kafka.enabled": true,
+		}), nil)
+		assert.NoError(t, err)
+		assert.False(t, c.Sampling.Tail.Enabled)
+	})
+	t.Run("Kafka", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies":      []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.kafka.enabled": true,
+			"sampling.tail.kafka.hosts":   []string{"localhost:9092"},
+		}), nil)
+		assert.NoError(t, err)
+		assert.True(t, c.Sampling.Tail.Enabled)
+		assert.Equal(t, TailSamplingKafkaConfig{
+			Enabled: true,
+			Hosts:   []string{"localhost:9092"},
+			Topic:   "apm-sampled-traces",
+		}, c.Sampling.Tail.Kafka)
+	})
+	t.Run("ClusterSelfNotMember", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies":        []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.cluster.enabled": true,
+			"sampling.tail.cluster.members": []string{"apm-1:8200", "apm-2:8200"},
+			"sampling.tail.cluster.self":    "apm-3:8200",
+		}), nil)
+		assert.NoError(t, err)
+		assert.False(t, c.Sampling.Tail.Enabled)
+	})
+	t.Run("Cluster", func(t *testing.T) {
+		c, err := NewConfig(config.MustNewConfigFrom(map[string]interface{}{
+			"sampling.tail.policies":        []map[string]interface{}{{"sample_rate": 0.5}},
+			"sampling.tail.cluster.enabled": true,
+			"sampling.tail.cluster.members": []string{"apm-1:8200", "apm-2:8200"},
+			"sampling.tail.cluster.self":    "apm-2:8200",
+		}), nil)
+		assert.NoError(t, err)
+		assert.True(t, c.Sampling.Tail.Enabled)
+		assert.Equal(t, TailSamplingClusterConfig{
+			Enabled: true,
+			Members: []string{"apm-1:8200", "apm-2:8200"},
+			Self:    "apm-2:8200",
+		}, c.Sampling.Tail.Cluster)
+	})
 }
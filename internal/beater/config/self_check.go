@@ -0,0 +1,37 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "time"
+
+// SelfCheckConfig holds configuration related to the read-your-own-ingest
+// self-check, which periodically indexes a marker document and searches
+// for it in Elasticsearch to measure the end-to-end ingestion lag.
+type SelfCheckConfig struct {
+	Enabled  bool          `config:"enabled"`
+	Interval time.Duration `config:"interval" validate:"min=1s"`
+	Timeout  time.Duration `config:"timeout" validate:"min=1s"`
+}
+
+func defaultSelfCheckConfig() SelfCheckConfig {
+	return SelfCheckConfig{
+		Enabled:  false,
+		Interval: time.Minute,
+		Timeout:  30 * time.Second,
+	}
+}
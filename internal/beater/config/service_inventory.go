@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"errors"
+	"time"
+)
+
+// ServiceInventoryConfig holds configuration related to the in-memory
+// inventory of services which recently sent events.
+type ServiceInventoryConfig struct {
+	// Enabled controls whether the service inventory is maintained and
+	// exposed through the service inventory endpoint.
+	Enabled bool `config:"enabled"`
+
+	// Window holds the amount of time for which services are kept in the
+	// inventory after they last sent events.
+	Window time.Duration `config:"window"`
+}
+
+// Validate validates the service inventory configuration.
+func (c *ServiceInventoryConfig) Validate() error {
+	if c.Window < time.Minute {
+		return errors.New("service inventory window must be at least 1m")
+	}
+	return nil
+}
+
+func defaultServiceInventoryConfig() ServiceInventoryConfig {
+	return ServiceInventoryConfig{Window: 10 * time.Minute}
+}
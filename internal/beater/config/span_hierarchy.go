@@ -0,0 +1,57 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "fmt"
+
+const (
+	// SpanHierarchyActionFix repairs spans which violate the span hierarchy.
+	SpanHierarchyActionFix = "fix"
+
+	// SpanHierarchyActionFlag labels spans which violate the span hierarchy.
+	SpanHierarchyActionFlag = "flag"
+
+	// SpanHierarchyActionDrop drops spans which violate the span hierarchy.
+	SpanHierarchyActionDrop = "drop"
+)
+
+// SpanHierarchyConfig holds configuration related to validating the
+// parent/child relationships of spans received from agents.
+type SpanHierarchyConfig struct {
+	// Enabled controls whether span hierarchies are validated.
+	Enabled bool `config:"enabled"`
+
+	// Action defines how spans which violate the span hierarchy are handled.
+	Action string `config:"action"`
+}
+
+// Validate validates the span hierarchy configuration.
+func (c *SpanHierarchyConfig) Validate() error {
+	switch c.Action {
+	case SpanHierarchyActionFix, SpanHierarchyActionFlag, SpanHierarchyActionDrop:
+		return nil
+	}
+	return fmt.Errorf(
+		"invalid span hierarchy action %q, expected one of %q, %q, or %q", c.Action,
+		SpanHierarchyActionFix, SpanHierarchyActionFlag, SpanHierarchyActionDrop,
+	)
+}
+
+func defaultSpanHierarchyConfig() SpanHierarchyConfig {
+	return SpanHierarchyConfig{Action: SpanHierarchyActionFix}
+}
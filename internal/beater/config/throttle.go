@@ -0,0 +1,132 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ThrottleConfig holds configuration for a scheduled throttle, which
+// reduces the rate at which events are accepted during a recurring
+// time window, such as a nightly batch or maintenance window.
+type ThrottleConfig struct {
+	// Name identifies the throttle in monitoring metrics and in the
+	// errors reported to clients.
+	Name string `config:"name" validate:"required"`
+
+	// Days holds the days of the week on which the throttle's window
+	// starts. If empty, the window starts every day.
+	Days []Weekday `config:"days"`
+
+	// Start and End hold the local time of day at which the throttle's
+	// window starts and ends. If End is before Start, the window ends on
+	// the following day.
+	Start TimeOfDay `config:"start"`
+	End   TimeOfDay `config:"end"`
+
+	// Timezone holds the IANA time zone name in which Start and End are
+	// evaluated. If empty, UTC is used.
+	Timezone string `config:"timezone"`
+
+	// EventTypes holds the event types to which the throttle applies.
+	// If empty, the throttle applies to all events.
+	EventTypes []string `config:"event_types"`
+
+	// EventLimit holds the maximum number of matching events accepted per
+	// second, across all clients, while the throttle is active. If zero,
+	// matching events are rejected while the throttle is active.
+	EventLimit int `config:"event_limit" validate:"min=0"`
+}
+
+// Validate validates the throttle configuration.
+func (c *ThrottleConfig) Validate() error {
+	if c.Start == c.End {
+		return fmt.Errorf("throttle %q: start and end must differ", c.Name)
+	}
+	if _, err := c.Location(); err != nil {
+		return fmt.Errorf("throttle %q: %w", c.Name, err)
+	}
+	for _, eventType := range c.EventTypes {
+		var valid bool
+		for _, known := range eventTypes {
+			if eventType == known {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return fmt.Errorf(
+				"throttle %q: invalid event type %q, expected one of [%s]",
+				c.Name, eventType, strings.Join(eventTypes, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// Location returns the time zone in which the throttle's window is evaluated.
+func (c *ThrottleConfig) Location() (*time.Location, error) {
+	if c.Timezone == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(c.Timezone)
+}
+
+// TimeOfDay holds a time of day, as an offset from midnight. TimeOfDay is
+// configured as a string of the form "HH:MM".
+type TimeOfDay time.Duration
+
+// Unpack parses s as a time of day of the form "HH:MM".
+func (t *TimeOfDay) Unpack(s string) error {
+	parsed, err := time.Parse("15:04", s)
+	if err != nil {
+		return fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	*t = TimeOfDay(time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute)
+	return nil
+}
+
+// Weekday holds a day of the week. Weekday is configured as a full or
+// three-letter English day name, such as "monday" or "mon".
+type Weekday time.Weekday
+
+// Unpack parses s as the name of a day of the week.
+func (d *Weekday) Unpack(s string) error {
+	lower := strings.ToLower(s)
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		name := strings.ToLower(day.String())
+		if lower == name || lower == name[:3] {
+			*d = Weekday(day)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid day of the week %q", s)
+}
+
+func validateThrottles(throttles []ThrottleConfig) error {
+	names := make(map[string]bool, len(throttles))
+	for _, throttle := range throttles {
+		if names[throttle.Name] {
+			return fmt.Errorf("duplicate throttle name %q", throttle.Name)
+		}
+		names[throttle.Name] = true
+	}
+	return nil
+}
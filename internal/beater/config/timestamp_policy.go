@@ -0,0 +1,66 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package config
+
+import "fmt"
+
+const (
+	// TimestampPolicyOffset sets missing event timestamps to the time the
+	// request was received, offset by any agent-provided offsets, such as
+	// span start offsets.
+	TimestampPolicyOffset = "offset"
+
+	// TimestampPolicyReceiveTime sets missing event timestamps to the time
+	// the request was received, ignoring any agent-provided offsets.
+	TimestampPolicyReceiveTime = "receive_time"
+
+	// TimestampPolicyReject rejects events without timestamps.
+	TimestampPolicyReject = "reject"
+)
+
+// TimestampPolicyConfig holds configuration controlling the timestamps
+// assigned to events received without a timestamp, per input type.
+type TimestampPolicyConfig struct {
+	// Intake holds the policy for events sent by backend agents.
+	Intake string `config:"intake"`
+
+	// RUM holds the policy for events sent by RUM agents.
+	RUM string `config:"rum"`
+}
+
+// Validate validates the timestamp policy configuration.
+func (c *TimestampPolicyConfig) Validate() error {
+	for _, policy := range []string{c.Intake, c.RUM} {
+		switch policy {
+		case TimestampPolicyOffset, TimestampPolicyReceiveTime, TimestampPolicyReject:
+		default:
+			return fmt.Errorf(
+				"invalid timestamp policy %q, expected one of %q, %q, or %q", policy,
+				TimestampPolicyOffset, TimestampPolicyReceiveTime, TimestampPolicyReject,
+			)
+		}
+	}
+	return nil
+}
+
+func defaultTimestampPolicyConfig() TimestampPolicyConfig {
+	return TimestampPolicyConfig{
+		Intake: TimestampPolicyOffset,
+		RUM:    TimestampPolicyOffset,
+	}
+}
@@ -34,3 +34,7 @@ func (e *actionableError) Error() string {
 	}
 	return e.Err.Error()
 }
+
+func (e *actionableError) Unwrap() error {
+	return e.Err
+}
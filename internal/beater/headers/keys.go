@@ -35,8 +35,13 @@ const (
 	ContentType                = "Content-Type"
 	Etag                       = "Etag"
 	IfNoneMatch                = "If-None-Match"
+	KeepAlive                  = "Keep-Alive"
 	Origin                     = "Origin"
 	UserAgent                  = "User-Agent"
 	Vary                       = "Vary"
 	XContentTypeOptions        = "X-Content-Type-Options"
+	XElasticAPMDeadline        = "X-Elastic-Apm-Deadline"
+	XRateLimitBurst            = "X-RateLimit-Burst"
+	XRateLimitLimit            = "X-RateLimit-Limit"
+	XRateLimitScope            = "X-RateLimit-Scope"
 )
@@ -19,6 +19,8 @@ package beater
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"log"
 	"net"
 	"net/http"
@@ -28,6 +30,8 @@ import (
 	"github.com/libp2p/go-reuseport"
 	"go.uber.org/zap"
 	"golang.org/x/net/netutil"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 
 	"github.com/elastic/apm-server/internal/beater/api"
 	"github.com/elastic/apm-server/internal/beater/config"
@@ -116,6 +120,42 @@ func (h *httpServer) stop() {
 	}
 }
 
+// gmuxCredentials is a credentials.TransportCredentials for a gRPC server
+// whose connections are accepted by the net/http server through gmux.
+//
+// TLS, if enabled, is terminated by the net/http server before connections
+// reach the gRPC server, so gmuxCredentials performs no handshake. Instead it
+// exposes the TLS connection state recorded by gmux as credentials.TLSInfo,
+// so that gRPC services may inspect verified client certificates.
+type gmuxCredentials struct{}
+
+func (gmuxCredentials) ServerHandshake(conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	tlsConn, ok := conn.(interface{ ConnectionState() tls.ConnectionState })
+	if !ok {
+		return insecure.NewCredentials().ServerHandshake(conn)
+	}
+	return conn, credentials.TLSInfo{
+		State:          tlsConn.ConnectionState(),
+		CommonAuthInfo: credentials.CommonAuthInfo{SecurityLevel: credentials.PrivacyAndIntegrity},
+	}, nil
+}
+
+func (gmuxCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	return nil, nil, errors.New("gmuxCredentials does not support client handshakes")
+}
+
+func (gmuxCredentials) Info() credentials.ProtocolInfo {
+	return credentials.ProtocolInfo{SecurityProtocol: "tls"}
+}
+
+func (c gmuxCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func (gmuxCredentials) OverrideServerName(string) error {
+	return nil
+}
+
 // listen starts the listener for bt.config.Host.
 func listen(cfg *config.Config, logger *logp.Logger) (net.Listener, error) {
 	var listener net.Listener
@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"go.elastic.co/apm/v2"
+)
+
+// instrumentationConfig holds configuration for the sampling and overhead
+// of the APM Server's own tracer, under `instrumentation.*`. The remaining
+// instrumentation configuration is handled by libbeat.
+//
+// Settings which are not configured are left to the tracer's defaults,
+// which may be overridden with ELASTIC_APM_* environment variables.
+type instrumentationConfig struct {
+	SampleRate          *float64                             `config:"sample_rate"`
+	TransactionMaxSpans *int                                 `config:"transaction_max_spans"`
+	SpanCompression     instrumentationSpanCompressionConfig `config:"span_compression"`
+	BreakdownMetrics    *bool                                `config:"breakdown_metrics"`
+	CaptureBody         string                               `config:"capture_body"`
+
+	// CentralConfig controls whether the in-process tracer fetches agent
+	// configuration for the `apm-server` service from Kibana, allowing
+	// the settings above to be adjusted at runtime. When disabled, only
+	// agent configuration defined in `apm-server.agent_config` is used.
+	CentralConfig bool `config:"central_config"`
+}
+
+type instrumentationSpanCompressionConfig struct {
+	Enabled               *bool          `config:"enabled"`
+	ExactMatchMaxDuration *time.Duration `config:"exact_match_max_duration"`
+	SameKindMaxDuration   *time.Duration `config:"same_kind_max_duration"`
+}
+
+var captureBodyModes = map[string]apm.CaptureBodyMode{
+	"off":          apm.CaptureBodyOff,
+	"errors":       apm.CaptureBodyErrors,
+	"transactions": apm.CaptureBodyTransactions,
+	"all":          apm.CaptureBodyAll,
+}
+
+func (c *instrumentationConfig) Validate() error {
+	if c.SampleRate != nil && (*c.SampleRate < 0 || *c.SampleRate > 1) {
+		return fmt.Errorf("invalid sample_rate %v, must be between 0 and 1", *c.SampleRate)
+	}
+	if c.TransactionMaxSpans != nil && *c.TransactionMaxSpans < -1 {
+		return fmt.Errorf("invalid transaction_max_spans %d, must be -1 (unlimited) or greater", *c.TransactionMaxSpans)
+	}
+	if c.CaptureBody != "" {
+		if _, ok := captureBodyModes[c.CaptureBody]; !ok {
+			return fmt.Errorf("invalid capture_body %q, must be one of off, errors, transactions, or all", c.CaptureBody)
+		}
+	}
+	return nil
+}
+
+// setInstrumentationEnv sets environment variables for settings which can
+// only be configured when the tracer is created. This must be called before
+// the tracer is created by libbeat.
+func setInstrumentationEnv(cfg instrumentationConfig) {
+	if cfg.BreakdownMetrics != nil {
+		os.Setenv("ELASTIC_APM_BREAKDOWN_METRICS", strconv.FormatBool(*cfg.BreakdownMetrics))
+	}
+}
+
+// applyInstrumentationConfig applies cfg to tracer. Settings applied this
+// way may be overridden by central configuration, and are restored when
+// the central configuration is removed.
+func applyInstrumentationConfig(tracer *apm.Tracer, cfg instrumentationConfig) {
+	if cfg.SampleRate != nil {
+		tracer.SetSampler(apm.NewRatioSampler(*cfg.SampleRate))
+	}
+	if cfg.TransactionMaxSpans != nil {
+		tracer.SetMaxSpans(*cfg.TransactionMaxSpans)
+	}
+	if cfg.SpanCompression.Enabled != nil {
+		tracer.SetSpanCompressionEnabled(*cfg.SpanCompression.Enabled)
+	}
+	if cfg.SpanCompression.ExactMatchMaxDuration != nil {
+		tracer.SetSpanCompressionExactMatchMaxDuration(*cfg.SpanCompression.ExactMatchMaxDuration)
+	}
+	if cfg.SpanCompression.SameKindMaxDuration != nil {
+		tracer.SetSpanCompressionSameKindMaxDuration(*cfg.SpanCompression.SameKindMaxDuration)
+	}
+	if cfg.CaptureBody != "" {
+		tracer.SetCaptureBody(captureBodyModes[cfg.CaptureBody])
+	}
+}
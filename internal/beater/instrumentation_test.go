@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
+)
+
+func TestInstrumentationConfig(t *testing.T) {
+	var cfg instrumentationConfig
+	err := agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"sample_rate":           0,
+		"transaction_max_spans": 1,
+		"capture_body":          "all",
+	}).Unpack(&cfg)
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	applyInstrumentationConfig(tracer.Tracer, cfg)
+
+	tx, spans, _ := tracer.WithTransaction(func(ctx context.Context) {
+		for i := 0; i < 2; i++ {
+			span, _ := apm.StartSpan(ctx, "name", "type")
+			span.End()
+		}
+	})
+	assert.False(t, tx.Sampled != nil && *tx.Sampled)
+	assert.Empty(t, spans)
+
+	// Sampled transactions are limited to transaction_max_spans.
+	applyInstrumentationConfig(tracer.Tracer, instrumentationConfig{SampleRate: newFloat64(1)})
+	tracer.ResetPayloads()
+	_, spans, _ = tracer.WithTransaction(func(ctx context.Context) {
+		for i := 0; i < 2; i++ {
+			span, _ := apm.StartSpan(ctx, "name", "type")
+			span.End()
+		}
+	})
+	assert.Len(t, spans, 1)
+}
+
+func TestInstrumentationConfigInvalid(t *testing.T) {
+	for _, test := range []struct {
+		config map[string]interface{}
+		err    string
+	}{{
+		config: map[string]interface{}{"sample_rate": 1.5},
+		err:    "invalid sample_rate 1.5, must be between 0 and 1",
+	}, {
+		config: map[string]interface{}{"transaction_max_spans": -2},
+		err:    "invalid transaction_max_spans -2, must be -1 (unlimited) or greater",
+	}, {
+		config: map[string]interface{}{"capture_body": "headers"},
+		err:    `invalid capture_body "headers", must be one of off, errors, transactions, or all`,
+	}} {
+		var cfg instrumentationConfig
+		err := agentconfig.MustNewConfigFrom(test.config).Unpack(&cfg)
+		assert.ErrorContains(t, err, test.err)
+	}
+}
+
+func newFloat64(v float64) *float64 {
+	return &v
+}
@@ -71,6 +71,11 @@ func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
 			}
 			return nil, err
 		}
+		if recorded, ok := ctx.Value(recordedAuthenticationDetailsKey{}).(*auth.AuthenticationDetails); ok {
+			// Record the authenticated identity for the Logging interceptor,
+			// which runs before Auth and cannot observe the handler context.
+			*recorded = details
+		}
 		ctx = ContextWithAuthenticationDetails(ctx, details)
 		ctx = auth.ContextWithAuthorizer(ctx, authz)
 		resp, err := handler(ctx, req)
@@ -85,6 +90,12 @@ func Auth(authenticator *auth.Authenticator) grpc.UnaryServerInterceptor {
 // AuthorizationMetadataAuthenticator is a UnaryAuthenticator which extracts
 // auth details from the incoming "authorization" metadata, and passes it to
 // the supplied Authenticator.
+//
+// If there are multiple "authorization" metadata values, the last one is used.
+// Per-RPC credentials are sent after any channel-level metadata, so this
+// allows clients such as multi-tenant OpenTelemetry Collectors to send
+// requests for many tenants, each with their own API Key, over a single
+// shared connection.
 type AuthorizationMetadataAuthenticator struct{}
 
 func (a AuthorizationMetadataAuthenticator) AuthenticateUnaryCall(
@@ -96,22 +107,25 @@ func (a AuthorizationMetadataAuthenticator) AuthenticateUnaryCall(
 	var authHeader string
 	if md, ok := metadata.FromIncomingContext(ctx); ok {
 		if values := md.Get(headers.Authorization); len(values) > 0 {
-			authHeader = values[0]
+			authHeader = values[len(values)-1]
 		}
 	}
 	kind, token := auth.ParseAuthorizationHeader(authHeader)
 	return authenticator.Authenticate(ctx, kind, token)
 }
 
-type authenticationDetailsKey struct{}
+type recordedAuthenticationDetailsKey struct{}
 
 // ContextWithAuthenticationDetails returns a copy of ctx with details.
+//
+// This is equivalent to auth.ContextWithAuthenticationDetails.
 func ContextWithAuthenticationDetails(ctx context.Context, details auth.AuthenticationDetails) context.Context {
-	return context.WithValue(ctx, authenticationDetailsKey{}, details)
+	return auth.ContextWithAuthenticationDetails(ctx, details)
 }
 
-// AuthenticationDetailsFromContext returns client metadata extracted by the ClientMetadata interceptor.
+// AuthenticationDetailsFromContext returns authentication details recorded by the Auth interceptor.
+//
+// This is equivalent to auth.AuthenticationDetailsFromContext.
 func AuthenticationDetailsFromContext(ctx context.Context) (auth.AuthenticationDetails, bool) {
-	details, ok := ctx.Value(authenticationDetailsKey{}).(auth.AuthenticationDetails)
-	return details, ok
+	return auth.AuthenticationDetailsFromContext(ctx)
 }
@@ -144,6 +144,20 @@ func TestAuthorizationMetadataAuthenticator(t *testing.T) {
 	})
 	assert.Equal(t, codes.Unauthenticated, status.Code(err))
 	assert.Nil(t, resp)
+
+	// Call with multiple authorization headers, showing that the last one,
+	// i.e. per-RPC credentials, takes precedence over channel-level ones.
+	perRPCContext := metadata.NewIncomingContext(ctx, metadata.Pairs(
+		"authorization", "Bearer invalid",
+		"authorization", "Bearer abc123",
+	))
+	resp, err = interceptor(perRPCContext, nil, &grpc.UnaryServerInfo{
+		Server: nil, // Server does not implement UnaryAuthenticator
+	}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return 123, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 123, resp)
 }
 
 type unaryAuthenticatorFunc func(
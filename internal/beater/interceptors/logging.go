@@ -25,13 +25,16 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
 )
 
 // Logging intercepts a gRPC request and provides logging processing. The
 // returned function implements grpc.UnaryServerInterceptor.
 //
 // Logging should be added after ClientMetadata to include `source.address`
-// in log records.
+// in log records, and before Auth to include the authenticated API Key's
+// `api_key.id` and `user.name`.
 func Logging(logger *logp.Logger) grpc.UnaryServerInterceptor {
 	return func(
 		ctx context.Context,
@@ -51,7 +54,15 @@ func Logging(logger *logp.Logger) grpc.UnaryServerInterceptor {
 			}
 		}
 
+		var details auth.AuthenticationDetails
+		ctx = context.WithValue(ctx, recordedAuthenticationDetailsKey{}, &details)
 		resp, err := handler(ctx, req)
+		if details.APIKey != nil {
+			logger = logger.With("api_key.id", details.APIKey.ID)
+			if details.APIKey.Username != "" {
+				logger = logger.With("user.name", details.APIKey.Username)
+			}
+		}
 		res, _ := status.FromError(err)
 		logger = logger.With(
 			"grpc.request.method", info.FullMethod,
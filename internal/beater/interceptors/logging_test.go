@@ -32,6 +32,8 @@ import (
 	agentconfig "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
 	"github.com/elastic/elastic-agent-libs/logp/configure"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
 )
 
 func TestLogging(t *testing.T) {
@@ -101,3 +103,55 @@ func TestLogging(t *testing.T) {
 		assert.Equal(t, tc.statusCode.String(), fields["grpc.response.status_code"])
 	}
 }
+
+func TestLoggingAPIKey(t *testing.T) {
+	configure.Logging(
+		"APM Server test",
+		agentconfig.MustNewConfigFrom(`{"ecs":true}`),
+	)
+	require.NoError(t, logp.DevelopmentSetup(logp.ToObserverOutput()))
+	logger := logp.NewLogger("interceptor.logging.test")
+
+	var authFunc unaryAuthenticatorFunc = func(
+		ctx context.Context,
+		req interface{},
+		fullMethod string,
+		authenticator *auth.Authenticator,
+	) (auth.AuthenticationDetails, auth.Authorizer, error) {
+		return auth.AuthenticationDetails{
+			Method: auth.MethodAPIKey,
+			APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id", Username: "tenant"},
+		}, nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "test_method_name", Server: authFunc}
+	authInterceptor := Auth(&auth.Authenticator{})
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return authInterceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+	}
+
+	_, err := Logging(logger)(context.Background(), nil, info, handler)
+	require.NoError(t, err)
+	entries := logp.ObserverLogs().TakeAll()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "key_id", fields["api_key.id"])
+	assert.Equal(t, "tenant", fields["user.name"])
+}
+
+type unaryAuthenticatorFunc func(
+	ctx context.Context,
+	req interface{},
+	fullMethod string,
+	authenticator *auth.Authenticator,
+) (auth.AuthenticationDetails, auth.Authorizer, error)
+
+func (f unaryAuthenticatorFunc) AuthenticateUnaryCall(
+	ctx context.Context,
+	req interface{},
+	fullMethod string,
+	authenticator *auth.Authenticator,
+) (auth.AuthenticationDetails, auth.Authorizer, error) {
+	return f(ctx, req, fullMethod, authenticator)
+}
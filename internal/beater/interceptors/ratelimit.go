@@ -25,6 +25,7 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 )
 
@@ -63,3 +64,41 @@ func AnonymousRateLimit(store *ratelimit.Store) grpc.UnaryServerInterceptor {
 		return result, err
 	}
 }
+
+// APIKeyRateLimit returns a grpc.UnaryServerInterceptor that adds a rate limiter
+// to the context of requests authenticated with an API Key, keyed by the API Key
+// ID. APIKeyRateLimit must be wrapped by the Authorization interceptor, as it
+// requires the client's authentication details.
+//
+// If store is nil, requests are not rate limited by API Key.
+func APIKeyRateLimit(store *ratelimit.Store) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if store == nil {
+			return handler(ctx, req)
+		}
+		details, ok := AuthenticationDetailsFromContext(ctx)
+		if !ok {
+			return nil, errors.New("authentication details not found in context")
+		}
+		if details.Method == auth.MethodAPIKey && details.APIKey != nil {
+			limiter := store.ForAPIKey(details.APIKey.ID)
+			if !limiter.Allow() {
+				return nil, status.Error(
+					codes.ResourceExhausted,
+					ratelimit.ErrRateLimitExceeded.Error(),
+				)
+			}
+			ctx = ratelimit.ContextWithLimiter(ctx, limiter)
+		}
+		result, err := handler(ctx, req)
+		if errors.Is(err, ratelimit.ErrRateLimitExceeded) {
+			err = status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return result, err
+	}
+}
@@ -114,3 +114,44 @@ func TestAnonymousRateLimitForIP(t *testing.T) {
 	// ratelimit.Store size is 2: the 3rd IP reuses an existing (depleted) rate limiter.
 	assert.Equal(t, status.Error(codes.ResourceExhausted, "rate limit exceeded"), requestWithIP("10.1.1.3"))
 }
+
+func TestAPIKeyRateLimit(t *testing.T) {
+	store, _ := ratelimit.NewStore(2, 1, 1)
+	interceptor := interceptors.APIKeyRateLimit(store)
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		details, _ := interceptors.AuthenticationDetailsFromContext(ctx)
+		_, ok := ratelimit.FromContext(ctx)
+		assert.Equal(t, details.Method == auth.MethodAPIKey, ok)
+		return nil, nil
+	}
+
+	requestWithAuth := func(details auth.AuthenticationDetails) error {
+		ctx := interceptors.ContextWithAuthenticationDetails(context.Background(), details)
+		_, err := interceptor(ctx, "request", &grpc.UnaryServerInfo{}, handler)
+		return err
+	}
+	apiKey := func(id string) auth.AuthenticationDetails {
+		return auth.AuthenticationDetails{
+			Method: auth.MethodAPIKey,
+			APIKey: &auth.APIKeyAuthenticationDetails{ID: id},
+		}
+	}
+	assert.NoError(t, requestWithAuth(apiKey("key_a")))
+	assert.Equal(t, status.Error(codes.ResourceExhausted, "rate limit exceeded"), requestWithAuth(apiKey("key_a")))
+	assert.NoError(t, requestWithAuth(apiKey("key_b")))
+
+	// Requests not authenticated with an API Key are not limited by API Key.
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, requestWithAuth(auth.AuthenticationDetails{Method: auth.MethodSecretToken}))
+	}
+
+	// A nil store disables API Key rate limiting.
+	interceptor = interceptors.APIKeyRateLimit(nil)
+	for i := 0; i < 2; i++ {
+		ctx := interceptors.ContextWithAuthenticationDetails(context.Background(), apiKey("key_a"))
+		_, err := interceptor(ctx, "request", &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		assert.NoError(t, err)
+	}
+}
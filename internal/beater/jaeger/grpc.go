@@ -26,7 +26,14 @@ import (
 	jaegermodel "github.com/jaegertracing/jaeger/model"
 	"github.com/jaegertracing/jaeger/proto-gen/api_v2"
 	jaegertranslator "github.com/open-telemetry/opentelemetry-collector-contrib/pkg/translator/jaeger"
+	"go.opentelemetry.io/collector/component"
+	collectorconfig "go.opentelemetry.io/collector/config"
+	"go.opentelemetry.io/collector/config/configtelemetry"
 	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/featuregate"
+	"go.opentelemetry.io/collector/obsreport"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -39,6 +46,7 @@ import (
 	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/otelmetrics"
 	"github.com/elastic/apm-server/internal/processor/otel"
 )
 
@@ -51,14 +59,34 @@ var (
 			request.IDResponseErrorsUnauthorized,
 		),
 	)
+
+	// obsreportMeterProvider records the collector's internal metrics,
+	// for reporting through libbeat monitoring.
+	obsreportMeterProvider = otelmetrics.NewMeterProvider()
 )
 
 const (
 	// elasticAuthTag is the name of the agent tag that will be used for auth.
 	// The tag value should be "Bearer <secret token" or "ApiKey <api key>".
 	elasticAuthTag = "elastic-apm-auth"
+
+	// useOtelForInternalMetricsGateID is the ID of the collector feature gate
+	// which controls whether obsreport records metrics with OpenTelemetry.
+	useOtelForInternalMetricsGateID = "telemetry.useOtelForInternalMetrics"
+
+	receiverTransport  = "grpc"
+	dataFormatProtobuf = "protobuf"
 )
 
+func init() {
+	if err := featuregate.GetRegistry().Apply(map[string]bool{
+		useOtelForInternalMetricsGateID: true,
+	}); err != nil {
+		panic(err)
+	}
+	monitoring.NewFunc(monitoring.Default, "apm-server.jaeger.obsreport", obsreportMeterProvider.CollectMonitoring, monitoring.Report)
+}
+
 // RegisterGRPCServices registers Jaeger gRPC services with srv.
 func RegisterGRPCServices(
 	srv *grpc.Server,
@@ -67,13 +95,26 @@ func RegisterGRPCServices(
 	fetcher agentcfg.Fetcher,
 ) {
 	traceConsumer := &otel.Consumer{Processor: processor}
-	api_v2.RegisterCollectorServiceServer(srv, &grpcCollector{traceConsumer})
+	obsrecv := obsreport.NewReceiver(obsreport.ReceiverSettings{
+		ReceiverID: collectorconfig.NewComponentID("jaeger"),
+		Transport:  receiverTransport,
+		ReceiverCreateSettings: component.ReceiverCreateSettings{
+			TelemetrySettings: component.TelemetrySettings{
+				Logger:         zap.NewNop(),
+				TracerProvider: trace.NewNoopTracerProvider(),
+				MeterProvider:  obsreportMeterProvider,
+				MetricsLevel:   configtelemetry.LevelBasic,
+			},
+		},
+	})
+	api_v2.RegisterCollectorServiceServer(srv, &grpcCollector{traceConsumer, obsrecv})
 	api_v2.RegisterSamplingManagerServer(srv, &grpcSampler{logger, fetcher})
 }
 
 // grpcCollector implements Jaeger api_v2 protocol for receiving tracing data
 type grpcCollector struct {
 	consumer consumer.Traces
+	obsrecv  *obsreport.Receiver
 }
 
 // AuthenticateUnaryCall authenticates CollectorService calls.
@@ -127,7 +168,10 @@ func (c *grpcCollector) postSpans(ctx context.Context, batch jaegermodel.Batch)
 	if err != nil {
 		return err
 	}
-	return c.consumer.ConsumeTraces(ctx, traces)
+	ctx = c.obsrecv.StartTracesOp(ctx)
+	err = c.consumer.ConsumeTraces(ctx, traces)
+	c.obsrecv.EndTracesOp(ctx, dataFormatProtobuf, int(spanCount), err)
+	return err
 }
 
 var (
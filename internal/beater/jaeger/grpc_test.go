@@ -38,6 +38,7 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/approvaltest"
@@ -54,6 +55,7 @@ func TestPostSpans(t *testing.T) {
 		return processorErr
 	}
 	conn := newServer(t, processor, nil)
+	accepted, refused := obsreportSpans()
 
 	client := api_v2.NewCollectorServiceClient(conn)
 	result, err := client.PostSpans(context.Background(), &api_v2.PostSpansRequest{})
@@ -92,6 +94,18 @@ func TestPostSpans(t *testing.T) {
 			}
 		})
 	}
+
+	// Spans are recorded by the collector's obsreport instrumentation.
+	newAccepted, newRefused := obsreportSpans()
+	assert.Equal(t, int64(2), newAccepted-accepted)
+	assert.Equal(t, int64(2), newRefused-refused)
+}
+
+func obsreportSpans() (accepted, refused int64) {
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	accepted = snapshot.Ints["apm-server.jaeger.obsreport.receiver.accepted_spans.jaeger.grpc"]
+	refused = snapshot.Ints["apm-server.jaeger.obsreport.receiver.refused_spans.jaeger.grpc"]
+	return accepted, refused
 }
 
 func newPostSpansRequest(t *testing.T) *api_v2.PostSpansRequest {
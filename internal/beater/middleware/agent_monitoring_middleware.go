@@ -0,0 +1,163 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// maxAgentMonitoringEntries limits the number of distinct agent name and
+// version combinations tracked by AgentMonitoring, protecting against
+// unbounded memory usage due to arbitrary User-Agent headers.
+const maxAgentMonitoringEntries = 1000
+
+// agentUserAgentPrefixes holds the User-Agent product name prefixes used by
+// Elastic APM agents, with the agent name following the prefix. The agent
+// spec prescribes "apm-agent-<name>"; older agents use the other forms.
+var agentUserAgentPrefixes = []string{"apm-agent-", "elasticapm-", "elastic-apm-"}
+
+// AgentMonitoring holds request metrics dimensioned by agent name and version.
+type AgentMonitoring struct {
+	mu         sync.RWMutex
+	agents     map[agentKey]*agentMetrics
+	overflowed int64
+}
+
+type agentKey struct {
+	name    string
+	version string
+}
+
+type agentMetrics struct {
+	requests int64
+	errors   int64
+	bytes    int64
+	events   int64
+}
+
+// NewAgentMonitoring returns a new AgentMonitoring.
+func NewAgentMonitoring() *AgentMonitoring {
+	return &AgentMonitoring{agents: make(map[agentKey]*agentMetrics)}
+}
+
+// AgentMonitoringMiddleware returns a middleware that records request metrics
+// in m, dimensioned by the agent name and version identified by the request's
+// User-Agent header. Requests that do not identify an Elastic APM agent are
+// not recorded.
+func AgentMonitoringMiddleware(m *AgentMonitoring) Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		return func(c *request.Context) {
+			h(c)
+
+			name, version, ok := parseAgentUserAgent(c.UserAgent)
+			if !ok {
+				return
+			}
+			metrics := m.metrics(agentKey{name: name, version: version})
+			if metrics == nil {
+				return
+			}
+			atomic.AddInt64(&metrics.requests, 1)
+			if c.Result.Failure() {
+				atomic.AddInt64(&metrics.errors, 1)
+			}
+			atomic.AddInt64(&metrics.bytes, c.WireBytesRead())
+			if c.Result.EventsAccepted > 0 {
+				atomic.AddInt64(&metrics.events, int64(c.Result.EventsAccepted))
+			}
+		}, nil
+	}
+}
+
+// metrics returns the agentMetrics for key, creating it if necessary.
+// If the maximum number of entries has been reached, metrics returns nil.
+func (m *AgentMonitoring) metrics(key agentKey) *agentMetrics {
+	m.mu.RLock()
+	metrics, ok := m.agents[key]
+	m.mu.RUnlock()
+	if ok {
+		return metrics
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if metrics, ok := m.agents[key]; ok {
+		return metrics
+	}
+	if len(m.agents) >= maxAgentMonitoringEntries {
+		atomic.AddInt64(&m.overflowed, 1)
+		return nil
+	}
+	metrics = &agentMetrics{}
+	m.agents[key] = metrics
+	return metrics
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+func (m *AgentMonitoring) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	versions := make(map[string][]string)
+	for key := range m.agents {
+		versions[key.name] = append(versions[key.name], key.version)
+	}
+	for name, agentVersions := range versions {
+		monitoring.ReportNamespace(V, name, func() {
+			for _, version := range agentVersions {
+				metrics := m.agents[agentKey{name: name, version: version}]
+				monitoring.ReportNamespace(V, version, func() {
+					monitoring.ReportInt(V, "request.count", atomic.LoadInt64(&metrics.requests))
+					monitoring.ReportInt(V, "request.bytes", atomic.LoadInt64(&metrics.bytes))
+					monitoring.ReportInt(V, "response.errors.count", atomic.LoadInt64(&metrics.errors))
+					monitoring.ReportInt(V, "event.accepted.count", atomic.LoadInt64(&metrics.events))
+				})
+			}
+		})
+	}
+	monitoring.ReportInt(V, "overflowed", atomic.LoadInt64(&m.overflowed))
+}
+
+// parseAgentUserAgent parses the agent name and version from the first
+// product in an Elastic APM agent's User-Agent header, for example
+// "apm-agent-java/1.34.0 (my-service 1.0)".
+func parseAgentUserAgent(userAgent string) (name, version string, ok bool) {
+	product := userAgent
+	if i := strings.IndexByte(product, ' '); i >= 0 {
+		product = product[:i]
+	}
+	product, version, ok = strings.Cut(product, "/")
+	if !ok || version == "" {
+		return "", "", false
+	}
+	for _, prefix := range agentUserAgentPrefixes {
+		if name := strings.TrimPrefix(product, prefix); name != product && name != "" {
+			return name, version, true
+		}
+	}
+	return "", "", false
+}
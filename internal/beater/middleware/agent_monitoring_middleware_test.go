@@ -0,0 +1,94 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestAgentMonitoringMiddleware(t *testing.T) {
+	m := NewAgentMonitoring()
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "agents", m.CollectMonitoring, monitoring.Report)
+
+	handle := func(h request.Handler, userAgent, body string) {
+		c := request.NewContext()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("User-Agent", userAgent)
+		c.Reset(httptest.NewRecorder(), req)
+		Apply(AgentMonitoringMiddleware(m), h)(c)
+	}
+	handler202WithEvents := func(c *request.Context) {
+		io.Copy(io.Discard, c.Request.Body)
+		c.Result.EventsAccepted = 3
+		Handler202(c)
+	}
+
+	handle(handler202WithEvents, "apm-agent-java/1.34.0 (my-service 1.0)", "abc")
+	handle(handler202WithEvents, "apm-agent-java/1.34.0", "de")
+	handle(Handler403, "apm-agent-java/1.34.0", "")
+	handle(handler202WithEvents, "elasticapm-go/2.1.0 go/1.19", "f")
+	handle(handler202WithEvents, "curl/7.64.1", "ignored")
+	handle(handler202WithEvents, "", "ignored")
+
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"agents.java.1.34.0.request.count":         3,
+		"agents.java.1.34.0.request.bytes":         5,
+		"agents.java.1.34.0.response.errors.count": 1,
+		"agents.java.1.34.0.event.accepted.count":  6,
+		"agents.go.2.1.0.request.count":            1,
+		"agents.go.2.1.0.request.bytes":            1,
+		"agents.go.2.1.0.response.errors.count":    0,
+		"agents.go.2.1.0.event.accepted.count":     3,
+		"agents.overflowed":                        0,
+	}, snapshot.Ints)
+}
+
+func TestParseAgentUserAgent(t *testing.T) {
+	for _, test := range []struct {
+		userAgent string
+		name      string
+		version   string
+		ok        bool
+	}{
+		{userAgent: "apm-agent-java/1.34.0 (my-service 1.0)", name: "java", version: "1.34.0", ok: true},
+		{userAgent: "apm-agent-php/1.7.0", name: "php", version: "1.7.0", ok: true},
+		{userAgent: "elasticapm-go/2.1.0 go/1.19", name: "go", version: "2.1.0", ok: true},
+		{userAgent: "elastic-apm-node/3.40.0 elastic-apm-http-client/11.0.1", name: "node", version: "3.40.0", ok: true},
+		{userAgent: "apm-agent-java/", ok: false},
+		{userAgent: "apm-agent-/1.0", ok: false},
+		{userAgent: "Mozilla/5.0 (X11; Linux x86_64)", ok: false},
+		{userAgent: "", ok: false},
+	} {
+		name, version, ok := parseAgentUserAgent(test.userAgent)
+		assert.Equal(t, test.ok, ok, test.userAgent)
+		assert.Equal(t, test.name, name, test.userAgent)
+		assert.Equal(t, test.version, version, test.userAgent)
+	}
+}
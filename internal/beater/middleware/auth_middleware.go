@@ -66,7 +66,8 @@ func AuthMiddleware(authenticator Authenticator, required bool) Middleware {
 				}
 			}
 			c.Authentication = details
-			c.Request = c.Request.WithContext(auth.ContextWithAuthorizer(c.Request.Context(), authorizer))
+			ctx := auth.ContextWithAuthenticationDetails(c.Request.Context(), details)
+			c.Request = c.Request.WithContext(auth.ContextWithAuthorizer(ctx, authorizer))
 			h(c)
 
 			// Processors may indicate that a request is unauthorized by returning auth.ErrUnauthorized.
@@ -76,11 +76,17 @@ func TestAuthMiddleware(t *testing.T) {
 				assert.Equal(t, tc.expectToken, token)
 				return auth.AuthenticationDetails{Method: auth.MethodSecretToken}, denyAll{}, tc.authError
 			}
+			var contextAuthentication auth.AuthenticationDetails
+			next := func(c *request.Context) {
+				contextAuthentication, _ = auth.AuthenticationDetailsFromContext(c.Request.Context())
+				Handler202(c)
+			}
 			m := AuthMiddleware(authenticator, tc.authRequired)
-			Apply(m, Handler202)(c)
+			Apply(m, next)(c)
 			assert.Equal(t, tc.expectStatus, rec.Code)
 			assert.Equal(t, tc.expectBody, rec.Body.String())
 			assert.Equal(t, tc.expectAuthentication, c.Authentication)
+			assert.Equal(t, tc.expectAuthentication, contextAuthentication)
 		})
 	}
 }
@@ -0,0 +1,73 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// concurrencyLimitErrorBody is the response body for requests rejected due
+// to the client exceeding its concurrent request limit.
+type concurrencyLimitErrorBody struct {
+	Error            string                      `json:"error"`
+	Reason           string                      `json:"reason"`
+	ConcurrencyLimit *ratelimit.ConcurrencyError `json:"concurrency_limit"`
+}
+
+// ConcurrencyLimitMiddleware limits the number of concurrent in-flight
+// requests per client, responding with 429 Too Many Requests to requests
+// exceeding the limit. Requests authenticated with an API Key are limited
+// by the API Key ID, and all other requests are limited by client IP.
+//
+// If limiter is nil, requests are not limited.
+//
+// This middleware must be wrapped by AuthorizationMiddleware, as it depends on
+// the value of c.Authentication.
+func ConcurrencyLimitMiddleware(limiter *ratelimit.ConcurrencyLimiter) Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		if limiter == nil {
+			return h, nil
+		}
+		return func(c *request.Context) {
+			var release func()
+			var ok bool
+			scope := ratelimit.ScopeIP
+			if c.Authentication.Method == auth.MethodAPIKey && c.Authentication.APIKey != nil {
+				scope = ratelimit.ScopeAPIKey
+				release, ok = limiter.AcquireAPIKey(c.Authentication.APIKey.ID)
+			} else {
+				release, ok = limiter.AcquireIP(c.ClientIP)
+			}
+			if !ok {
+				err := &ratelimit.ConcurrencyError{Scope: scope, Limit: limiter.Limit()}
+				c.Result.SetWithError(request.IDResponseErrorsRateLimit, err)
+				c.Result.Body = concurrencyLimitErrorBody{
+					Error:            c.Result.Err.Error(),
+					Reason:           ratelimit.ReasonConcurrencyLimitExceeded,
+					ConcurrencyLimit: err,
+				}
+				c.WriteResult()
+				return
+			}
+			defer release()
+			h(c)
+		}, nil
+	}
+}
@@ -0,0 +1,92 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	limiter, err := ratelimit.NewConcurrencyLimiter(1)
+	require.NoError(t, err)
+
+	// Requests to the "block" handler remain in flight until unblocked.
+	unblock := make(chan struct{})
+	wrapped, err := ConcurrencyLimitMiddleware(limiter)(func(c *request.Context) {
+		if c.Request.URL.Path == "/block" {
+			<-unblock
+		}
+	})
+	require.NoError(t, err)
+
+	doRequest := func(path, ip string, details auth.AuthenticationDetails) *httptest.ResponseRecorder {
+		c := request.NewContext()
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+		r.RemoteAddr = ip + ":5678"
+		c.Reset(w, r)
+		c.Authentication = details
+		wrapped(c)
+		return w
+	}
+	anonymous := auth.AuthenticationDetails{Method: auth.MethodAnonymous}
+	apiKey := auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_a"},
+	}
+
+	blocked := make(chan struct{}, 2)
+	go func() { doRequest("/block", "10.1.1.1", anonymous); blocked <- struct{}{} }()
+	go func() { doRequest("/block", "10.1.1.2", apiKey); blocked <- struct{}{} }()
+	assert.Eventually(t, func() bool {
+		return doRequest("/", "10.1.1.1", anonymous).Code == http.StatusTooManyRequests &&
+			doRequest("/", "10.1.1.3", apiKey).Code == http.StatusTooManyRequests
+	}, time.Second, 10*time.Millisecond)
+
+	// Other clients are not limited.
+	assert.Equal(t, http.StatusOK, doRequest("/", "10.1.1.2", anonymous).Code)
+
+	w := doRequest("/", "10.1.1.1", anonymous)
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.JSONEq(t, `{
+		"error": "too many requests: concurrent request limit exceeded",
+		"reason": "concurrency_limit_exceeded",
+		"concurrency_limit": {"scope": "ip", "limit": 1}
+	}`, w.Body.String())
+
+	close(unblock)
+	<-blocked
+	<-blocked
+	assert.Equal(t, http.StatusOK, doRequest("/", "10.1.1.1", anonymous).Code)
+	assert.Equal(t, http.StatusOK, doRequest("/", "10.1.1.3", apiKey).Code)
+
+	// A nil limiter disables concurrency limiting.
+	wrapped, err = ConcurrencyLimitMiddleware(nil)(func(c *request.Context) {})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, doRequest("/", "10.1.1.1", anonymous).Code)
+}
@@ -0,0 +1,51 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// ConnectionMiddleware returns a Middleware which advises clients whether
+// they should reuse their connection.
+//
+// While draining reports true, e.g. during server shutdown, responses include
+// "Connection: close" so that clients reconnect before the server stops, which
+// allows a load balancer to direct them to another server instance. Otherwise,
+// if idleTimeout is positive, responses include a "Keep-Alive" header advising
+// clients of the server's idle connection timeout.
+func ConnectionMiddleware(idleTimeout time.Duration, draining func() bool) Middleware {
+	var keepAlive string
+	if seconds := int(idleTimeout.Seconds()); seconds > 0 {
+		keepAlive = fmt.Sprintf("timeout=%d", seconds)
+	}
+	return func(h request.Handler) (request.Handler, error) {
+		return func(c *request.Context) {
+			if draining() {
+				c.ResponseWriter.Header().Set(headers.Connection, "close")
+			} else if keepAlive != "" {
+				c.ResponseWriter.Header().Set(headers.KeepAlive, keepAlive)
+			}
+			h(c)
+		}, nil
+	}
+}
@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+func TestConnectionMiddleware(t *testing.T) {
+	for name, tc := range map[string]struct {
+		idleTimeout time.Duration
+		draining    bool
+		connection  string
+		keepAlive   string
+	}{
+		"keep_alive": {
+			idleTimeout: 45 * time.Second,
+			keepAlive:   "timeout=45",
+		},
+		"no_idle_timeout": {},
+		"draining": {
+			idleTimeout: 45 * time.Second,
+			draining:    true,
+			connection:  "close",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := request.NewContext()
+			rec := httptest.NewRecorder()
+			c.Reset(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			m := ConnectionMiddleware(tc.idleTimeout, func() bool { return tc.draining })
+			Apply(m, Handler202)(c)
+
+			assert.Equal(t, http.StatusAccepted, rec.Code)
+			assert.Equal(t, tc.connection, rec.Header().Get(headers.Connection))
+			assert.Equal(t, tc.keepAlive, rec.Header().Get(headers.KeepAlive))
+		})
+	}
+}
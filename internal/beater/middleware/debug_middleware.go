@@ -0,0 +1,46 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/request"
+)
+
+// DebugMiddleware returns a Middleware for read-only debug endpoints, which
+// report details of the server or of all monitored services. Anonymous
+// requests are rejected, as are requests with any method other than GET.
+func DebugMiddleware() Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		return func(c *request.Context) {
+			if c.Authentication.Method == auth.MethodAnonymous {
+				c.Result.SetDefault(request.IDResponseErrorsForbidden)
+				c.WriteResult()
+				return
+			}
+			if c.Request.Method != http.MethodGet {
+				c.Result.SetDefault(request.IDResponseErrorsMethodNotAllowed)
+				c.WriteResult()
+				return
+			}
+			h(c)
+		}, nil
+	}
+}
@@ -34,6 +34,11 @@ func MonitoringMiddleware(m map[request.ResultID]*monitoring.Int) Middleware {
 				counter.Inc()
 			}
 		}
+		add := func(id request.ResultID, n int64) {
+			if counter, ok := m[id]; ok && n > 0 {
+				counter.Add(n)
+			}
+		}
 		return func(c *request.Context) {
 			inc(request.IDRequestCount)
 
@@ -47,6 +52,8 @@ func MonitoringMiddleware(m map[request.ResultID]*monitoring.Int) Middleware {
 			}
 
 			inc(c.Result.ID)
+			add(request.IDRequestBytesWire, c.WireBytesRead())
+			add(request.IDRequestBytesDecoded, c.DecodedBytesRead())
 		}, nil
 
 	}
@@ -18,6 +18,12 @@
 package middleware
 
 import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -99,3 +105,24 @@ func TestMonitoringHandler(t *testing.T) {
 			mockMonitoringNil)
 	})
 }
+
+func TestMonitoringMiddlewareRequestBytes(t *testing.T) {
+	monitoringtest.ClearRegistry(mockMonitoring)
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(strings.Repeat("a", 1000)))
+	zw.Close()
+	wireBytes := buf.Len()
+
+	c := request.NewContext()
+	c.Reset(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", &buf))
+	h := func(c *request.Context) {
+		io.Copy(io.Discard, c.Request.Body)
+		Handler202(c)
+	}
+	Apply(MonitoringMiddleware(mockMonitoring), h)(c)
+
+	assert.Equal(t, int64(wireBytes), mockMonitoring[request.IDRequestBytesWire].Get())
+	assert.Equal(t, int64(1000), mockMonitoring[request.IDRequestBytesDecoded].Get())
+}
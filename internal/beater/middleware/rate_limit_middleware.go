@@ -18,14 +18,25 @@
 package middleware
 
 import (
+	"golang.org/x/time/rate"
+
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 )
 
+// rateLimitErrorBody is the response body for requests rejected due to
+// the client exceeding its rate limit.
+type rateLimitErrorBody struct {
+	Error     string           `json:"error"`
+	Reason    string           `json:"reason"`
+	RateLimit *ratelimit.Error `json:"rate_limit"`
+}
+
 // AnonymousRateLimitMiddleware adds a rate.Limiter to the context of anonymous
 // requests, first ensuring the client is allowed to perform a single event and
-// responding with 429 Too Many Requests if it is not.
+// responding with 429 Too Many Requests if it is not. Rejected responses describe
+// the exceeded rate limit in the response body and headers.
 //
 // This middleware must be wrapped by AuthorizationMiddleware, as it depends on
 // the value of c.AuthResult.Anonymous.
@@ -33,20 +44,61 @@ func AnonymousRateLimitMiddleware(store *ratelimit.Store) Middleware {
 	return func(h request.Handler) (request.Handler, error) {
 		return func(c *request.Context) {
 			if c.Authentication.Method == auth.MethodAnonymous {
-				limiter := store.ForIP(c.ClientIP)
-				if !limiter.Allow() {
-					c.Result.SetWithError(
-						request.IDResponseErrorsRateLimit,
-						ratelimit.ErrRateLimitExceeded,
-					)
-					c.WriteResult()
+				if !allowRequest(c, store.ForIP(c.ClientIP), ratelimit.ScopeIP) {
 					return
 				}
-				ctx := c.Request.Context()
-				ctx = ratelimit.ContextWithLimiter(ctx, limiter)
-				c.Request = c.Request.WithContext(ctx)
 			}
 			h(c)
 		}, nil
 	}
 }
+
+// APIKeyRateLimitMiddleware adds a rate.Limiter to the context of requests
+// authenticated with an API Key, keyed by the API Key ID, first ensuring the
+// client is allowed to perform a single event and responding with 429 Too Many
+// Requests if it is not. Rejected responses describe the exceeded rate limit in
+// the response body and headers.
+//
+// If store is nil, requests are not rate limited by API Key.
+//
+// This middleware must be wrapped by AuthorizationMiddleware, as it depends on
+// the value of c.Authentication.
+func APIKeyRateLimitMiddleware(store *ratelimit.Store) Middleware {
+	return func(h request.Handler) (request.Handler, error) {
+		if store == nil {
+			return h, nil
+		}
+		return func(c *request.Context) {
+			if c.Authentication.Method == auth.MethodAPIKey && c.Authentication.APIKey != nil {
+				limiter := store.ForAPIKey(c.Authentication.APIKey.ID)
+				if !allowRequest(c, limiter, ratelimit.ScopeAPIKey) {
+					return
+				}
+			}
+			h(c)
+		}, nil
+	}
+}
+
+// allowRequest ensures limiter allows a single event, adding limiter to the
+// request context for limiting the events in the request. If the limit is
+// exceeded, allowRequest writes a 429 Too Many Requests response describing
+// the limit and returns false.
+func allowRequest(c *request.Context, limiter *rate.Limiter, scope ratelimit.Scope) bool {
+	if !limiter.Allow() {
+		err := ratelimit.NewError(scope, limiter)
+		err.SetHeaders(c.ResponseWriter.Header())
+		c.Result.SetWithError(request.IDResponseErrorsRateLimit, err)
+		c.Result.Body = rateLimitErrorBody{
+			Error:     c.Result.Err.Error(),
+			Reason:    ratelimit.ReasonRateLimitExceeded,
+			RateLimit: err,
+		}
+		c.WriteResult()
+		return false
+	}
+	ctx := c.Request.Context()
+	ctx = ratelimit.ContextWithLimiter(ctx, limiter)
+	c.Request = c.Request.WithContext(ctx)
+	return true
+}
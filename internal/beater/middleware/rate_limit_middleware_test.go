@@ -25,6 +25,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/headers"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
 	"github.com/elastic/apm-server/internal/beater/request"
 )
@@ -107,3 +109,70 @@ func TestAnonymousRateLimitMiddlewareForIP(t *testing.T) {
 	// ratelimit.Store size is 2: the 3rd IP reuses an existing (depleted) rate limiter.
 	assert.Equal(t, http.StatusTooManyRequests, requestWithIP("10.1.1.3"))
 }
+
+func TestAnonymousRateLimitMiddlewareResponse(t *testing.T) {
+	store, _ := ratelimit.NewStore(1, 2, 0)
+	wrapped, err := AnonymousRateLimitMiddleware(store)(func(c *request.Context) {})
+	require.NoError(t, err)
+
+	c := request.NewContext()
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set(headers.Accept, "application/json")
+	c.Reset(w, r)
+	wrapped(c)
+
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "2", w.Header().Get(headers.XRateLimitLimit))
+	assert.Equal(t, "0", w.Header().Get(headers.XRateLimitBurst))
+	assert.Equal(t, "ip", w.Header().Get(headers.XRateLimitScope))
+	assert.Equal(t, []string{
+		headers.XRateLimitLimit, headers.XRateLimitBurst, headers.XRateLimitScope,
+	}, w.Header().Values(headers.AccessControlExposeHeaders))
+	assert.JSONEq(t, `{
+		"error": "too many requests: rate limit exceeded",
+		"reason": "rate_limit_exceeded",
+		"rate_limit": {"scope": "ip", "limit": 2, "burst": 0}
+	}`, w.Body.String())
+}
+
+func TestAPIKeyRateLimitMiddleware(t *testing.T) {
+	store, _ := ratelimit.NewStore(2, 1, 1)
+	wrapped, err := APIKeyRateLimitMiddleware(store)(func(c *request.Context) {
+		_, ok := ratelimit.FromContext(c.Request.Context())
+		assert.Equal(t, c.Authentication.Method == auth.MethodAPIKey, ok)
+	})
+	require.NoError(t, err)
+
+	requestWithAuth := func(details auth.AuthenticationDetails) *httptest.ResponseRecorder {
+		c := request.NewContext()
+		w := httptest.NewRecorder()
+		c.Reset(w, httptest.NewRequest("GET", "/", nil))
+		c.Authentication = details
+		wrapped(c)
+		return w
+	}
+	apiKey := func(id string) auth.AuthenticationDetails {
+		return auth.AuthenticationDetails{
+			Method: auth.MethodAPIKey,
+			APIKey: &auth.APIKeyAuthenticationDetails{ID: id},
+		}
+	}
+	assert.Equal(t, http.StatusOK, requestWithAuth(apiKey("key_a")).Code)
+	w := requestWithAuth(apiKey("key_a"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "api_key", w.Header().Get(headers.XRateLimitScope))
+	assert.Equal(t, http.StatusOK, requestWithAuth(apiKey("key_b")).Code)
+
+	// Requests not authenticated with an API Key are not limited by API Key.
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, requestWithAuth(auth.AuthenticationDetails{Method: auth.MethodSecretToken}).Code)
+	}
+
+	// A nil store disables API Key rate limiting.
+	wrapped, err = APIKeyRateLimitMiddleware(nil)(func(c *request.Context) {})
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, http.StatusOK, requestWithAuth(apiKey("key_a")).Code)
+	}
+}
@@ -18,23 +18,44 @@
 package middleware
 
 import (
+	"fmt"
+	"regexp"
 	"runtime/debug"
 
-	"github.com/pkg/errors"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/beater/request"
 )
 
 const keywordPanic = "panic handling request"
 
+var (
+	panicRegistry = monitoring.Default.NewRegistry("apm-server.panics")
+
+	// panicsRecovered counts the panics recovered while handling requests.
+	panicsRecovered = monitoring.NewInt(panicRegistry, "recovered")
+
+	// stackArgsRegexp matches the argument values of a function call in
+	// a goroutine stack trace, e.g. "(0xc000123456, 0x2)". Arguments may
+	// hold data derived from the request payload, so they are removed.
+	stackArgsRegexp = regexp.MustCompile(`(?m)^(\S.*)\([0-9a-fx, .{}]+\)$`)
+)
+
 // RecoverPanicMiddleware returns a middleware ensuring that the Server recovers from panics,
 // while trying to write an according response.
+//
+// Recovered panics are counted in `apm-server.panics.recovered`, and reported as errors
+// to the server's self-instrumentation, if enabled, with a stack trace excluding function
+// argument values.
 func RecoverPanicMiddleware() Middleware {
 	return func(h request.Handler) (request.Handler, error) {
 		return func(c *request.Context) {
 
 			defer func() {
 				if r := recover(); r != nil {
+					panicsRecovered.Inc()
 
 					// recover again in case setting the context's result or writing the response itself
 					// is throwing the panic
@@ -45,14 +66,19 @@ func RecoverPanicMiddleware() Middleware {
 					id := request.IDResponseErrorsInternal
 					status := request.MapResultIDToStatus[id]
 
-					// set the context's result and write response
-					var ok bool
-					var err error
-					if err, ok = r.(error); !ok {
-						err = errors.Wrap(err, status.Keyword)
+					err, ok := r.(error)
+					if !ok {
+						err = fmt.Errorf("%s: %v", status.Keyword, r)
+					}
+					if e := apm.CaptureError(c.Request.Context(), err); e != nil && e.ErrorData != nil {
+						e.Handled = false
+						e.SetStacktrace(1)
+						e.Send()
 					}
+
+					// set the context's result and write response
 					c.Result.Set(id, status.Code, status.Keyword, keywordPanic, err)
-					c.Result.Stacktrace = string(debug.Stack())
+					c.Result.Stacktrace = sanitizeStack(debug.Stack())
 
 					c.WriteResult()
 				}
@@ -61,3 +87,9 @@ func RecoverPanicMiddleware() Middleware {
 		}, nil
 	}
 }
+
+// sanitizeStack returns the goroutine stack trace with the argument
+// values of function calls removed.
+func sanitizeStack(stack []byte) string {
+	return string(stackArgsRegexp.ReplaceAll(stack, []byte("$1(...)")))
+}
@@ -59,6 +59,17 @@ func TestPanicHandler(t *testing.T) {
 		assert.Equal(t, keywordPanic, c.Result.Body)
 	})
 
+	t.Run("HandleNonErrorPanic", func(t *testing.T) {
+		before := panicsRecovered.Get()
+		h := func(c *request.Context) { panic("panic xyz") }
+		c, w := DefaultContextWithResponseRecorder()
+		Apply(RecoverPanicMiddleware(), h)(c)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		assert.EqualError(t, c.Result.Err, "internal error: panic xyz")
+		assert.Equal(t, before+1, panicsRecovered.Get())
+	})
+
 	t.Run("SecondPanic", func(t *testing.T) {
 		w := &writerPanic{}
 		c := &request.Context{}
@@ -90,3 +101,18 @@ func (w *writerPanic) Write(b []byte) (int, error) {
 func (w *writerPanic) WriteHeader(statusCode int) {
 	panic(errors.New("panic writing header"))
 }
+
+func TestSanitizeStack(t *testing.T) {
+	stack := []byte(`goroutine 7 [running]:
+runtime/debug.Stack()
+	/usr/local/go/src/runtime/debug/stack.go:24 +0x65
+github.com/elastic/apm-server/internal/processor/stream.(*Processor).readBatch(0xc0001a2000, {0x1a2b3c0, 0xc000123450}, 0x1, ...)
+	/go/src/internal/processor/stream/processor.go:212 +0x2e5
+`)
+	assert.Equal(t, `goroutine 7 [running]:
+runtime/debug.Stack()
+	/usr/local/go/src/runtime/debug/stack.go:24 +0x65
+github.com/elastic/apm-server/internal/processor/stream.(*Processor).readBatch(...)
+	/go/src/internal/processor/stream/processor.go:212 +0x2e5
+`, sanitizeStack(stack))
+}
@@ -20,7 +20,10 @@ package otlp
 import (
 	"sync"
 
+	"go.opentelemetry.io/collector/receiver/otlpreceiver"
+
 	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/otelmetrics"
 	"github.com/elastic/apm-server/internal/processor/otel"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
@@ -33,6 +36,16 @@ var (
 	)
 )
 
+func init() {
+	// Record the OTLP receivers' internal metrics with OpenTelemetry
+	// instruments, and report them through libbeat monitoring.
+	meterProvider := otelmetrics.NewMeterProvider()
+	if err := otlpreceiver.SetMeterProvider(meterProvider); err != nil {
+		panic(err)
+	}
+	monitoring.NewFunc(monitoring.Default, "apm-server.otlp.obsreport", meterProvider.CollectMonitoring, monitoring.Report)
+}
+
 type monitoredConsumer struct {
 	mu       sync.RWMutex
 	consumer *otel.Consumer
@@ -88,6 +88,12 @@ func TestConsumeTracesGRPC(t *testing.T) {
 		"response.errors.timeout":      int64(0),
 		"response.errors.unauthorized": int64(0),
 	}, actual)
+
+	// The receiver's internal metrics are recorded with OpenTelemetry,
+	// and reported through libbeat monitoring.
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["apm-server.otlp.obsreport.receiver.accepted_spans.otlp.grpc"])
+	assert.Equal(t, int64(1), snapshot.Ints["apm-server.otlp.obsreport.receiver.refused_spans.otlp.grpc"])
 }
 
 func TestConsumeMetricsGRPC(t *testing.T) {
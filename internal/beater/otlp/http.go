@@ -30,12 +30,14 @@ import (
 )
 
 var (
+	httpMonitoringKeys = append(append([]request.ResultID{}, monitoringKeys...), request.RequestBytesResultIDs...)
+
 	httpMetricsRegistry      = monitoring.Default.NewRegistry("apm-server.otlp.http.metrics")
-	HTTPMetricsMonitoringMap = request.MonitoringMapForRegistry(httpMetricsRegistry, monitoringKeys)
+	HTTPMetricsMonitoringMap = request.MonitoringMapForRegistry(httpMetricsRegistry, httpMonitoringKeys)
 	httpTracesRegistry       = monitoring.Default.NewRegistry("apm-server.otlp.http.traces")
-	HTTPTracesMonitoringMap  = request.MonitoringMapForRegistry(httpTracesRegistry, monitoringKeys)
+	HTTPTracesMonitoringMap  = request.MonitoringMapForRegistry(httpTracesRegistry, httpMonitoringKeys)
 	httpLogsRegistry         = monitoring.Default.NewRegistry("apm-server.otlp.http.logs")
-	HTTPLogsMonitoringMap    = request.MonitoringMapForRegistry(httpLogsRegistry, monitoringKeys)
+	HTTPLogsMonitoringMap    = request.MonitoringMapForRegistry(httpLogsRegistry, httpMonitoringKeys)
 
 	httpMonitoredConsumer monitoredConsumer
 )
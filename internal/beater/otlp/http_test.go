@@ -79,6 +79,8 @@ func TestConsumeTracesHTTP(t *testing.T) {
 	})
 	assert.Equal(t, map[string]interface{}{
 		"request.count":                int64(1),
+		"request.bytes.wire":           int64(len(request)),
+		"request.bytes.decoded":        int64(len(request)),
 		"response.count":               int64(1),
 		"response.errors.count":        int64(0),
 		"response.valid.count":         int64(1),
@@ -86,6 +88,9 @@ func TestConsumeTracesHTTP(t *testing.T) {
 		"response.errors.timeout":      int64(0),
 		"response.errors.unauthorized": int64(0),
 	}, actual)
+
+	snapshot := monitoring.CollectFlatSnapshot(monitoring.Default, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["apm-server.otlp.obsreport.receiver.accepted_spans.otlp.http"])
 }
 
 func TestConsumeMetricsHTTP(t *testing.T) {
@@ -124,6 +129,8 @@ func TestConsumeMetricsHTTP(t *testing.T) {
 		"consumer.unsupported_dropped": int64(0),
 
 		"request.count":                int64(1),
+		"request.bytes.wire":           int64(len(request)),
+		"request.bytes.decoded":        int64(len(request)),
 		"response.count":               int64(1),
 		"response.errors.count":        int64(0),
 		"response.valid.count":         int64(1),
@@ -167,6 +174,8 @@ func TestConsumeLogsHTTP(t *testing.T) {
 	})
 	assert.Equal(t, map[string]interface{}{
 		"request.count":                int64(1),
+		"request.bytes.wire":           int64(len(request)),
+		"request.bytes.decoded":        int64(len(request)),
 		"response.count":               int64(1),
 		"response.errors.count":        int64(0),
 		"response.valid.count":         int64(1),
@@ -183,8 +192,8 @@ func newHTTPServer(t *testing.T, batchProcessor model.BatchProcessor) string {
 	auth, _ := auth.NewAuthenticator(cfg.AgentAuth)
 	ratelimitStore, _ := ratelimit.NewStore(1000, 1000, 1000)
 	router, err := api.NewMux(
-		cfg, batchProcessor, auth, agentcfg.NewDirectFetcher(nil),
-		ratelimitStore, nil, false, func() bool { return true })
+		cfg, batchProcessor, auth, agentcfg.NewDirectFetcher(nil), ratelimitStore, false,
+		func() bool { return true }, func() bool { return false }, api.MuxParams{})
 	require.NoError(t, err)
 	srv := http.Server{Handler: router}
 	go srv.Serve(lis)
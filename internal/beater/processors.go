@@ -22,9 +22,13 @@ import (
 	"os"
 	"time"
 
+	"github.com/gofrs/uuid"
+
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/ingestpipeline"
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/version"
 )
 
@@ -32,6 +36,11 @@ const (
 	rateLimitTimeout = time.Second
 )
 
+// instanceID uniquely identifies the APM Server process. It is set as
+// `observer.ephemeral_id` when provenance fields are enabled, so events
+// can be attributed to the server instance which processed them.
+var instanceID = uuid.Must(uuid.NewV4()).String()
+
 // authorizeEventIngestProcessor is a model.BatchProcessor that checks that the
 // client is authorized to ingest events for the given agent and service name.
 func authorizeEventIngestProcessor(ctx context.Context, batch *model.Batch) error {
@@ -39,6 +48,7 @@ func authorizeEventIngestProcessor(ctx context.Context, batch *model.Batch) erro
 		if err := auth.Authorize(ctx, auth.ActionEventIngest, auth.Resource{
 			AgentName:   event.Agent.Name,
 			ServiceName: event.Service.Name,
+			EventType:   event.Processor.Event,
 		}); err != nil {
 			return err
 		}
@@ -54,22 +64,67 @@ func rateLimitBatchProcessor(ctx context.Context, batch *model.Batch) error {
 		ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
 		defer cancel()
 		if err := limiter.WaitN(ctx, len(*batch)); err != nil {
-			return ratelimit.ErrRateLimitExceeded
+			return ratelimit.NewError(ratelimit.ScopeEvent, limiter)
 		}
 	}
 	return nil
 }
 
+// newThrottleBatchProcessor returns a model.BatchProcessor that applies the
+// scheduled throttles which are active at the time a batch is processed.
+func newThrottleBatchProcessor(schedule *ratelimit.Schedule) model.ProcessBatchFunc {
+	return func(ctx context.Context, batch *model.Batch) error {
+		ctx, cancel := context.WithTimeout(ctx, rateLimitTimeout)
+		defer cancel()
+		return schedule.Wait(ctx, time.Now(), batch)
+	}
+}
+
+// tenantLabelsBatchProcessor is a model.BatchProcessor that sets labels
+// identifying the API Key used to authenticate the request, so events are
+// attributable to a tenant even when agents do not send tenant metadata.
+//
+// The API Key's owner username is set as `labels.tenant`, and the API Key ID
+// as `labels.api_key_id`. Labels already set on an event are not overridden,
+// and events from requests not authenticated with an API Key are unmodified.
+func tenantLabelsBatchProcessor(ctx context.Context, batch *model.Batch) error {
+	details, ok := auth.AuthenticationDetailsFromContext(ctx)
+	if !ok || details.APIKey == nil {
+		return nil
+	}
+	labels := map[string]string{"api_key_id": details.APIKey.ID}
+	if details.APIKey.Username != "" {
+		labels["tenant"] = details.APIKey.Username
+	}
+	return (&modelprocessor.SetGlobalLabels{Labels: labels}).ProcessBatch(ctx, batch)
+}
+
 // newObserverBatchProcessor returns a model.BatchProcessor that sets
 // observer fields from information about the apm-server process.
-func newObserverBatchProcessor() model.ProcessBatchFunc {
+//
+// If provenance is true, events are additionally stamped with the
+// instance ID of the process as `observer.ephemeral_id`, the version
+// of its ingest pipelines as `observer.pipeline_version`, and the time
+// at which they were received as `event.created`, unless already set.
+func newObserverBatchProcessor(provenance bool) model.ProcessBatchFunc {
 	hostname, _ := os.Hostname()
 	return func(ctx context.Context, b *model.Batch) error {
+		var received time.Time
+		if provenance {
+			received = time.Now()
+		}
 		for i := range *b {
-			observer := &(*b)[i].Observer
-			observer.Hostname = hostname
-			observer.Type = "apm-server"
-			observer.Version = version.Version
+			event := &(*b)[i]
+			event.Observer.Hostname = hostname
+			event.Observer.Type = "apm-server"
+			event.Observer.Version = version.Version
+			if provenance {
+				event.Observer.EphemeralID = instanceID
+				event.Observer.PipelineVersion = ingestpipeline.Version
+				if event.Event.Created.IsZero() {
+					event.Event.Created = received
+				}
+			}
 		}
 		return nil
 	}
@@ -20,12 +20,16 @@ package beater
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/time/rate"
 
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/config"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/ingestpipeline"
 	"github.com/elastic/apm-server/internal/model"
 )
 
@@ -45,5 +49,109 @@ func TestRateLimitBatchProcessor(t *testing.T) {
 	// After the second batch, the rate limiter burst has been exhausted,
 	// and the limit is not high enough to allow another one.
 	err := rateLimitBatchProcessor(ctx, &batch)
-	assert.Equal(t, ratelimit.ErrRateLimitExceeded, err)
+	assert.ErrorIs(t, err, ratelimit.ErrRateLimitExceeded)
+	assert.Equal(t, &ratelimit.Error{Scope: ratelimit.ScopeEvent, Limit: 1, Burst: 10}, err)
+}
+
+func TestThrottleBatchProcessor(t *testing.T) {
+	// The throttle is always active, and rejects all log events.
+	schedule, err := newThrottleSchedule([]config.ThrottleConfig{{
+		Name:       "logs",
+		Start:      0,
+		End:        config.TimeOfDay(24*time.Hour - time.Nanosecond),
+		EventTypes: []string{"log"},
+	}})
+	require.NoError(t, err)
+	processor := newThrottleBatchProcessor(schedule)
+
+	batch := model.Batch{{Processor: model.TransactionProcessor}}
+	assert.NoError(t, processor(context.Background(), &batch))
+
+	batch = append(batch, model.APMEvent{Processor: model.LogProcessor})
+	err = processor(context.Background(), &batch)
+	assert.ErrorIs(t, err, ratelimit.ErrRateLimitExceeded)
+	assert.Equal(t, &ratelimit.Error{Scope: ratelimit.ScopeSchedule, Throttle: "logs"}, err)
+}
+
+func TestAuthorizeEventIngestProcessor(t *testing.T) {
+	var resources []auth.Resource
+	authorizer := authorizerFunc(func(ctx context.Context, action auth.Action, resource auth.Resource) error {
+		assert.Equal(t, auth.ActionEventIngest, action)
+		resources = append(resources, resource)
+		return nil
+	})
+	ctx := auth.ContextWithAuthorizer(context.Background(), authorizer)
+
+	batch := model.Batch{{
+		Agent:     model.Agent{Name: "rum-js"},
+		Service:   model.Service{Name: "opbeans-rum"},
+		Processor: model.TransactionProcessor,
+	}, {
+		Agent:     model.Agent{Name: "rum-js"},
+		Service:   model.Service{Name: "opbeans-rum"},
+		Processor: model.MetricsetProcessor,
+	}}
+	err := authorizeEventIngestProcessor(ctx, &batch)
+	require.NoError(t, err)
+	assert.Equal(t, []auth.Resource{
+		{AgentName: "rum-js", ServiceName: "opbeans-rum", EventType: "transaction"},
+		{AgentName: "rum-js", ServiceName: "opbeans-rum", EventType: "metric"},
+	}, resources)
+}
+
+func TestTenantLabelsBatchProcessor(t *testing.T) {
+	batch := model.Batch{{
+		Labels: model.Labels{"tenant": {Value: "agent_tenant"}},
+	}, {}}
+
+	// Events from requests not authenticated with an API Key are unmodified.
+	ctx := auth.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method: auth.MethodSecretToken,
+	})
+	require.NoError(t, tenantLabelsBatchProcessor(ctx, &batch))
+	assert.Nil(t, batch[1].Labels)
+
+	ctx = auth.ContextWithAuthenticationDetails(context.Background(), auth.AuthenticationDetails{
+		Method: auth.MethodAPIKey,
+		APIKey: &auth.APIKeyAuthenticationDetails{ID: "key_id", Username: "tenant_a"},
+	})
+	require.NoError(t, tenantLabelsBatchProcessor(ctx, &batch))
+	assert.Equal(t, model.Labels{
+		"tenant":     {Value: "agent_tenant"},
+		"api_key_id": {Value: "key_id", Global: true},
+	}, batch[0].Labels)
+	assert.Equal(t, model.Labels{
+		"tenant":     {Value: "tenant_a", Global: true},
+		"api_key_id": {Value: "key_id", Global: true},
+	}, batch[1].Labels)
+}
+
+type authorizerFunc func(context.Context, auth.Action, auth.Resource) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	return f(ctx, action, resource)
+}
+
+func TestObserverBatchProcessorProvenance(t *testing.T) {
+	created := time.Date(2022, 11, 22, 0, 0, 0, 0, time.UTC)
+	batch := model.Batch{{}, {Event: model.Event{Created: created}}}
+
+	err := newObserverBatchProcessor(false)(context.Background(), &batch)
+	require.NoError(t, err)
+	assert.Equal(t, "apm-server", batch[0].Observer.Type)
+	assert.Empty(t, batch[0].Observer.EphemeralID)
+	assert.Zero(t, batch[0].Observer.PipelineVersion)
+	assert.Zero(t, batch[0].Event.Created)
+
+	before := time.Now()
+	err = newObserverBatchProcessor(true)(context.Background(), &batch)
+	require.NoError(t, err)
+	for _, event := range batch {
+		assert.Equal(t, "apm-server", event.Observer.Type)
+		assert.Equal(t, instanceID, event.Observer.EphemeralID)
+		assert.Equal(t, ingestpipeline.Version, event.Observer.PipelineVersion)
+	}
+	assert.False(t, batch[0].Event.Created.Before(before))
+	assert.Equal(t, created, batch[1].Event.Created)
+	assert.NotEmpty(t, instanceID)
 }
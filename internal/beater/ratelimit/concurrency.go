@@ -0,0 +1,115 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"net/netip"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ReasonConcurrencyLimitExceeded is the machine-readable reason reported
+// to clients when a concurrent request limit is exceeded.
+const ReasonConcurrencyLimitExceeded = "concurrency_limit_exceeded"
+
+// ErrConcurrencyLimitExceeded is returned when a client has too many
+// requests in flight at once.
+var ErrConcurrencyLimitExceeded = errors.New("concurrent request limit exceeded")
+
+// ConcurrencyLimiter limits the number of concurrent in-flight requests
+// per client, identified by client IP or API Key ID.
+//
+// Clients are only tracked while they have requests in flight, so the
+// memory used by a ConcurrencyLimiter is bounded by the number of
+// concurrent requests.
+type ConcurrencyLimiter struct {
+	limit int
+
+	mu       sync.Mutex
+	inflight map[interface{}]int
+}
+
+// NewConcurrencyLimiter returns a new ConcurrencyLimiter, allowing up to
+// limit concurrent requests per client.
+func NewConcurrencyLimiter(limit int) (*ConcurrencyLimiter, error) {
+	if limit <= 0 {
+		return nil, errors.New("concurrent request limit must be greater than zero")
+	}
+	return &ConcurrencyLimiter{limit: limit, inflight: make(map[interface{}]int)}, nil
+}
+
+// Limit returns the maximum number of concurrent requests per client.
+func (l *ConcurrencyLimiter) Limit() int {
+	return l.limit
+}
+
+// AcquireIP attempts to acquire a request slot for the given IP. If the
+// IP has reached the limit, AcquireIP returns false. Otherwise AcquireIP
+// returns true and a function which must be called to release the slot
+// once the request has completed.
+func (l *ConcurrencyLimiter) AcquireIP(ip netip.Addr) (release func(), ok bool) {
+	return l.acquire(ip)
+}
+
+// AcquireAPIKey attempts to acquire a request slot for the API Key with
+// the given ID, in the same manner as AcquireIP.
+func (l *ConcurrencyLimiter) AcquireAPIKey(id string) (release func(), ok bool) {
+	return l.acquire(apiKeyID(id))
+}
+
+func (l *ConcurrencyLimiter) acquire(key interface{}) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.inflight[key] >= l.limit {
+		return nil, false
+	}
+	l.inflight[key]++
+	var once sync.Once
+	return func() { once.Do(func() { l.release(key) }) }, true
+}
+
+func (l *ConcurrencyLimiter) release(key interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n := l.inflight[key] - 1; n > 0 {
+		l.inflight[key] = n
+	} else {
+		delete(l.inflight, key)
+	}
+}
+
+// ConcurrencyError describes an exceeded concurrent request limit, and
+// may be encoded as JSON for reporting to clients.
+type ConcurrencyError struct {
+	// Scope identifies what was being limited: ScopeIP or ScopeAPIKey.
+	Scope Scope `json:"scope"`
+
+	// Limit holds the maximum number of concurrent requests per client.
+	Limit int `json:"limit"`
+}
+
+// Error returns the ErrConcurrencyLimitExceeded error message.
+func (e *ConcurrencyError) Error() string {
+	return ErrConcurrencyLimitExceeded.Error()
+}
+
+// Is returns true if target is ErrConcurrencyLimitExceeded.
+func (e *ConcurrencyError) Is(target error) bool {
+	return target == ErrConcurrencyLimitExceeded
+}
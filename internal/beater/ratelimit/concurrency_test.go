@@ -0,0 +1,62 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewConcurrencyLimiterInvalid(t *testing.T) {
+	_, err := NewConcurrencyLimiter(0)
+	assert.Error(t, err)
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	limiter, err := NewConcurrencyLimiter(2)
+	require.NoError(t, err)
+	ip := netip.MustParseAddr("10.1.1.1")
+
+	release1, ok := limiter.AcquireIP(ip)
+	require.True(t, ok)
+	release2, ok := limiter.AcquireIP(ip)
+	require.True(t, ok)
+	_, ok = limiter.AcquireIP(ip)
+	assert.False(t, ok)
+
+	// Other clients, including an API Key with an ID equal to the
+	// IP's string representation, are limited independently.
+	_, ok = limiter.AcquireIP(netip.MustParseAddr("10.1.1.2"))
+	assert.True(t, ok)
+	_, ok = limiter.AcquireAPIKey(ip.String())
+	assert.True(t, ok)
+
+	// Releasing more than once has no further effect.
+	release1()
+	release1()
+	_, ok = limiter.AcquireIP(ip)
+	assert.True(t, ok)
+	_, ok = limiter.AcquireIP(ip)
+	assert.False(t, ok)
+
+	release2()
+	assert.Len(t, limiter.inflight, 3)
+}
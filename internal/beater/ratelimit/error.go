@@ -0,0 +1,96 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+)
+
+// ReasonRateLimitExceeded is the machine-readable reason reported to
+// clients when a rate limit is exceeded.
+const ReasonRateLimitExceeded = "rate_limit_exceeded"
+
+// Scope identifies what was being rate limited when a limit was exceeded.
+type Scope string
+
+const (
+	// ScopeIP indicates that a request was rejected before any events were
+	// processed, as the client IP exceeded its rate limit.
+	ScopeIP Scope = "ip"
+
+	// ScopeAPIKey indicates that a request was rejected before any events
+	// were processed, as the client's API Key exceeded its rate limit.
+	ScopeAPIKey Scope = "api_key"
+
+	// ScopeEvent indicates that events within a request were rejected, as
+	// the number of events exceeded the client IP's or API Key's rate limit.
+	ScopeEvent Scope = "event"
+
+	// ScopeSchedule indicates that events within a request were rejected,
+	// as the number of events exceeded the rate limit of an active
+	// scheduled throttle.
+	ScopeSchedule Scope = "schedule"
+)
+
+// Error describes an exceeded rate limit. Error matches ErrRateLimitExceeded
+// when using errors.Is, and may be encoded as JSON for reporting to clients.
+type Error struct {
+	// Scope identifies what was being rate limited.
+	Scope Scope `json:"scope"`
+
+	// Limit holds the maximum sustained number of events per second.
+	Limit float64 `json:"limit"`
+
+	// Burst holds the maximum number of events allowed in a burst.
+	Burst int `json:"burst"`
+
+	// Throttle holds the name of the scheduled throttle whose limit was
+	// exceeded, for ScopeSchedule.
+	Throttle string `json:"throttle,omitempty"`
+}
+
+// NewError returns a new Error for scope, describing the limits of limiter.
+func NewError(scope Scope, limiter *rate.Limiter) *Error {
+	return &Error{Scope: scope, Limit: float64(limiter.Limit()), Burst: limiter.Burst()}
+}
+
+// Error returns the ErrRateLimitExceeded error message.
+func (e *Error) Error() string {
+	return ErrRateLimitExceeded.Error()
+}
+
+// Is returns true if target is ErrRateLimitExceeded.
+func (e *Error) Is(target error) bool {
+	return target == ErrRateLimitExceeded
+}
+
+// SetHeaders sets response headers describing the exceeded rate limit,
+// and exposes them to cross-origin clients such as the RUM agent.
+func (e *Error) SetHeaders(h http.Header) {
+	h.Set(headers.XRateLimitLimit, strconv.FormatFloat(e.Limit, 'f', -1, 64))
+	h.Set(headers.XRateLimitBurst, strconv.Itoa(e.Burst))
+	h.Set(headers.XRateLimitScope, string(e.Scope))
+	h.Add(headers.AccessControlExposeHeaders, headers.XRateLimitLimit)
+	h.Add(headers.AccessControlExposeHeaders, headers.XRateLimitBurst)
+	h.Add(headers.AccessControlExposeHeaders, headers.XRateLimitScope)
+}
@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// Throttle describes a scheduled reduction of the rate at which events
+// are accepted, during a recurring time window.
+type Throttle struct {
+	// Name identifies the throttle.
+	Name string
+
+	// Days holds the days of the week on which the window starts.
+	// If empty, the window starts every day.
+	Days []time.Weekday
+
+	// Start and End hold the offsets from midnight at which the window
+	// starts and ends, in Location. If End is before Start, the window
+	// ends on the following day.
+	Start    time.Duration
+	End      time.Duration
+	Location *time.Location
+
+	// EventTypes holds the processor event types to which the throttle
+	// applies. If empty, the throttle applies to all events.
+	EventTypes []string
+
+	// Limit and Burst hold the rate limit applied to matching events,
+	// across all clients, while the throttle is active.
+	Limit rate.Limit
+	Burst int
+}
+
+// Active reports whether now is within the throttle's window.
+func (t *Throttle) Active(now time.Time) bool {
+	now = now.In(t.Location)
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, t.Location)
+	offset := now.Sub(midnight)
+	if t.Start < t.End {
+		return offset >= t.Start && offset < t.End && t.startsOn(now.Weekday())
+	}
+	// The window spans midnight: it is active from its start until the
+	// end of the day, and then until its end on the following day.
+	if offset >= t.Start {
+		return t.startsOn(now.Weekday())
+	}
+	return offset < t.End && t.startsOn((now.Weekday()+6)%7)
+}
+
+func (t *Throttle) startsOn(day time.Weekday) bool {
+	if len(t.Days) == 0 {
+		return true
+	}
+	for _, d := range t.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Throttle) matches(event *model.APMEvent) bool {
+	if len(t.EventTypes) == 0 {
+		return true
+	}
+	for _, eventType := range t.EventTypes {
+		if event.Processor.Event == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Schedule applies scheduled throttles to batches of events. Each throttle
+// has a single rate limiter shared by all clients, which is only consulted
+// while the throttle is active.
+type Schedule struct {
+	throttles []*scheduledThrottle
+}
+
+type scheduledThrottle struct {
+	Throttle
+	limiter  *rate.Limiter
+	rejected int64
+}
+
+// NewSchedule returns a new Schedule for the given throttles.
+func NewSchedule(throttles []Throttle) *Schedule {
+	s := &Schedule{throttles: make([]*scheduledThrottle, len(throttles))}
+	for i, t := range throttles {
+		s.throttles[i] = &scheduledThrottle{
+			Throttle: t,
+			limiter:  rate.NewLimiter(t.Limit, t.Burst),
+		}
+	}
+	return s
+}
+
+// Wait waits until the events in batch matching the throttles active at
+// now are permitted by the throttles' rate limiters. If the events are not
+// permitted before ctx is done, Wait returns an *Error with ScopeSchedule,
+// and no further throttles are consulted.
+func (s *Schedule) Wait(ctx context.Context, now time.Time, batch *model.Batch) error {
+	for _, t := range s.throttles {
+		if !t.Active(now) {
+			continue
+		}
+		var n int
+		for i := range *batch {
+			if t.matches(&(*batch)[i]) {
+				n++
+			}
+		}
+		if n == 0 {
+			continue
+		}
+		if err := t.limiter.WaitN(ctx, n); err != nil {
+			atomic.AddInt64(&t.rejected, int64(n))
+			err := NewError(ScopeSchedule, t.limiter)
+			err.Throttle = t.Name
+			return err
+		}
+	}
+	return nil
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// For each throttle, whether it is currently active (1 or 0) and the number
+// of events it has rejected are reported, keyed by the throttle's name.
+func (s *Schedule) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	now := time.Now()
+	for _, t := range s.throttles {
+		monitoring.ReportNamespace(V, t.Name, func() {
+			var active int64
+			if t.Active(now) {
+				active = 1
+			}
+			monitoring.ReportInt(V, "active", active)
+			monitoring.ReportInt(V, "rejected", atomic.LoadInt64(&t.rejected))
+		})
+	}
+}
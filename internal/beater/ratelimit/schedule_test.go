@@ -0,0 +1,105 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestThrottleActive(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	daytime := Throttle{Start: 9 * time.Hour, End: 17 * time.Hour, Location: time.UTC}
+	assert.False(t, daytime.Active(time.Date(2022, 6, 6, 8, 59, 0, 0, time.UTC)))
+	assert.True(t, daytime.Active(time.Date(2022, 6, 6, 9, 0, 0, 0, time.UTC)))
+	assert.True(t, daytime.Active(time.Date(2022, 6, 6, 16, 59, 0, 0, time.UTC)))
+	assert.False(t, daytime.Active(time.Date(2022, 6, 6, 17, 0, 0, 0, time.UTC)))
+
+	// The window is evaluated in the throttle's location: 09:00 in
+	// Berlin is 07:00 UTC in summer.
+	daytime.Location = berlin
+	assert.True(t, daytime.Active(time.Date(2022, 6, 6, 7, 0, 0, 0, time.UTC)))
+	assert.False(t, daytime.Active(time.Date(2022, 6, 6, 15, 0, 0, 0, time.UTC)))
+
+	// A window spanning midnight belongs to the day on which it starts.
+	// 2022-06-06 is a Monday.
+	nightly := Throttle{
+		Days:     []time.Weekday{time.Monday},
+		Start:    22 * time.Hour,
+		End:      2 * time.Hour,
+		Location: time.UTC,
+	}
+	assert.False(t, nightly.Active(time.Date(2022, 6, 6, 1, 0, 0, 0, time.UTC)))
+	assert.True(t, nightly.Active(time.Date(2022, 6, 6, 22, 0, 0, 0, time.UTC)))
+	assert.True(t, nightly.Active(time.Date(2022, 6, 7, 1, 59, 0, 0, time.UTC)))
+	assert.False(t, nightly.Active(time.Date(2022, 6, 7, 2, 0, 0, 0, time.UTC)))
+	assert.False(t, nightly.Active(time.Date(2022, 6, 7, 22, 0, 0, 0, time.UTC)))
+}
+
+func TestScheduleWait(t *testing.T) {
+	schedule := NewSchedule([]Throttle{{
+		Name:       "logs",
+		Start:      time.Hour,
+		End:        2 * time.Hour,
+		Location:   time.UTC,
+		EventTypes: []string{"log"},
+		Limit:      1,
+		Burst:      2,
+	}})
+	batch := model.Batch{
+		{Processor: model.LogProcessor},
+		{Processor: model.LogProcessor},
+		{Processor: model.TransactionProcessor},
+		{Processor: model.LogProcessor},
+	}
+	active := time.Date(2022, 6, 6, 1, 30, 0, 0, time.UTC)
+	inactive := time.Date(2022, 6, 6, 3, 0, 0, 0, time.UTC)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	// The throttle is inactive, so the batch is not limited.
+	assert.NoError(t, schedule.Wait(ctx, inactive, &batch))
+
+	// Only the log events are counted towards the throttle's limit,
+	// which exceeds its burst.
+	err := schedule.Wait(ctx, active, &batch)
+	assert.Equal(t, &Error{Scope: ScopeSchedule, Limit: 1, Burst: 2, Throttle: "logs"}, err)
+	assert.ErrorIs(t, err, ErrRateLimitExceeded)
+
+	// Batches without matching events are not limited.
+	transactions := batch[2:3]
+	assert.NoError(t, schedule.Wait(ctx, active, &transactions))
+	logs := batch[:2]
+	assert.NoError(t, schedule.Wait(ctx, active, &logs))
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "throttles", schedule.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(3), snapshot.Ints["throttles.logs.rejected"])
+	assert.Contains(t, snapshot.Ints, "throttles.logs.active")
+}
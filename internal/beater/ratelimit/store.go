@@ -63,17 +63,30 @@ func NewStore(size, rateLimit, burstFactor int) (*Store, error) {
 
 // ForIP returns a rate limiter for the given IP.
 func (s *Store) ForIP(ip netip.Addr) *rate.Limiter {
+	return s.forKey(ip)
+}
+
+// ForAPIKey returns a rate limiter for the API Key with the given ID.
+func (s *Store) ForAPIKey(id string) *rate.Limiter {
+	return s.forKey(apiKeyID(id))
+}
+
+// apiKeyID is used as the cache key for API Key rate limiters, ensuring
+// they are distinct from any other string keys.
+type apiKeyID string
+
+func (s *Store) forKey(key interface{}) *rate.Limiter {
 	// lock get and add action for cache to allow proper eviction handling without
 	// race conditions.
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if l, ok := s.cache.Get(ip); ok {
+	if l, ok := s.cache.Get(key); ok {
 		return *l.(**rate.Limiter)
 	}
 
 	var limiter *rate.Limiter
-	if evicted := s.cache.Add(ip, &limiter); evicted {
+	if evicted := s.cache.Add(key, &limiter); evicted {
 		limiter = s.evictedLimiter
 	} else {
 		limiter = rate.NewLimiter(rate.Limit(s.limit), s.limit*s.burstFactor)
@@ -79,3 +79,14 @@ func TestCacheOk(t *testing.T) {
 	limiter := store.ForIP(netip.MustParseAddr("127.0.0.1"))
 	assert.NotNil(t, limiter)
 }
+
+func TestCacheAPIKey(t *testing.T) {
+	store, err := NewStore(2, 1, 1)
+	require.NoError(t, err)
+
+	limiter := store.ForAPIKey("key_a")
+	assert.True(t, limiter.Allow())
+	assert.False(t, limiter.Allow())
+	assert.Equal(t, limiter, store.ForAPIKey("key_a"))
+	assert.True(t, store.ForAPIKey("key_b").Allow())
+}
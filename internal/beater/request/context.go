@@ -57,6 +57,11 @@ type Context struct {
 	gzipReader                  *gzip.Reader
 	zlibReader                  zlibReadCloseResetter
 
+	// wireBody and decodedBody count the bytes read from the request
+	// body before and after decompression, respectively.
+	wireBody    countingReadCloser
+	decodedBody countingReadCloser
+
 	Request        *http.Request
 	Logger         *logp.Logger
 	Authentication auth.AuthenticationDetails
@@ -165,6 +170,21 @@ func (c *Context) setRequest(r *http.Request) {
 	}
 }
 
+// WireBytesRead returns the number of request body bytes read so far, as
+// received on the wire, i.e. before decompression.
+//
+// Unlike Request.ContentLength, this is also accurate for requests using
+// chunked transfer encoding.
+func (c *Context) WireBytesRead() int64 {
+	return c.wireBody.n
+}
+
+// DecodedBytesRead returns the number of request body bytes read so far,
+// after decompression.
+func (c *Context) DecodedBytesRead() int64 {
+	return c.decodedBody.n
+}
+
 // MultipleWriteAttempts returns a boolean set to true if WriteResult() was called multiple times.
 func (c *Context) MultipleWriteAttempts() bool {
 	return c.writeAttempts > 1
@@ -182,6 +202,12 @@ func (c *Context) WriteResult() {
 	c.writeAttempts++
 
 	c.ResponseWriter.Header().Set(headers.XContentTypeOptions, "nosniff")
+	if c.Result.StatusCode == http.StatusServiceUnavailable {
+		// The server is overloaded or shutting down; advise the client
+		// to reconnect, possibly to another server instance.
+		c.ResponseWriter.Header().Del(headers.KeepAlive)
+		c.ResponseWriter.Header().Set(headers.Connection, "close")
+	}
 
 	body := c.Result.Body
 	if body == nil {
@@ -249,6 +275,8 @@ func (c *Context) decodeRequestBody() error {
 	if c.Request.ContentLength == 0 {
 		return nil
 	}
+	c.wireBody.ReadCloser = c.Request.Body
+	c.Request.Body = &c.wireBody
 
 	var reader io.ReadCloser
 	var err error
@@ -290,8 +318,9 @@ func (c *Context) decodeRequestBody() error {
 		return err
 	}
 
+	c.decodedBody.ReadCloser = reader
 	c.Request.ContentLength = -1
-	c.Request.Body = reader
+	c.Request.Body = &c.decodedBody
 	return nil
 }
 
@@ -333,3 +362,15 @@ func (r *compressedRequestReadCloser) Read(p []byte) (int, error) {
 	n, err := r.ReadCloser.Read(p[nmagic:])
 	return n + nmagic, err
 }
+
+// countingReadCloser wraps an io.ReadCloser, counting the bytes read.
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
@@ -127,6 +127,10 @@ func TestContext_Reset(t *testing.T) {
 			assert.Nil(t, c.zlibReader)
 		case "gzipReader":
 			assert.Nil(t, c.gzipReader)
+		case "wireBody":
+			assert.Zero(t, c.wireBody)
+		case "decodedBody":
+			assert.Zero(t, c.decodedBody)
 		default:
 			assert.Empty(t, cVal.Field(i).Interface(), cType.Field(i).Name)
 		}
@@ -235,8 +239,11 @@ func TestContextResetContentEncoding(t *testing.T) {
 				Result:         Result{StatusCode: http.StatusOK},
 			}
 
+			wireLength := r.ContentLength
 			c.Reset(w, r)
 			assertReaderContents(t, expectedBody, c.Request.Body)
+			assert.Equal(t, wireLength, c.WireBytesRead())
+			assert.Equal(t, int64(len(expectedBody)), c.DecodedBytesRead())
 		})
 	}
 
@@ -327,6 +334,17 @@ func TestContext_Write(t *testing.T) {
 		assert.Empty(t, w.Body.String())
 	})
 
+	t.Run("ServiceUnavailableClosesConnection", func(t *testing.T) {
+		c, w := mockContextAccept("*/*")
+		w.Header().Set(headers.KeepAlive, "timeout=45")
+		c.Result = Result{Body: nil, StatusCode: http.StatusServiceUnavailable}
+		c.WriteResult()
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Equal(t, "close", w.Header().Get(headers.Connection))
+		assert.Empty(t, w.Header().Get(headers.KeepAlive))
+	})
+
 	t.Run("WrapStringBodyInMap", func(t *testing.T) {
 		c, w := mockContextAccept("")
 		body := "bar"
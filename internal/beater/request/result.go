@@ -38,6 +38,12 @@ const (
 	IDResponseErrorsCount ResultID = "response.errors.count"
 	// IDResponseValidCount identifies all successful responses
 	IDResponseValidCount ResultID = "response.valid.count"
+	// IDRequestBytesWire identifies the number of request body bytes read,
+	// as received on the wire
+	IDRequestBytesWire ResultID = "request.bytes.wire"
+	// IDRequestBytesDecoded identifies the number of request body bytes read,
+	// after decompression
+	IDRequestBytesDecoded ResultID = "request.bytes.decoded"
 	// IDEventReceivedCount identifies amount of received events
 	IDEventReceivedCount ResultID = "event.received.count"
 	// IDEventDroppedCount identifies amount of dropped events
@@ -105,6 +111,10 @@ var (
 
 	// DefaultResultIDs is a list of the default result IDs used by the package.
 	DefaultResultIDs = []ResultID{IDRequestCount, IDResponseCount, IDResponseErrorsCount, IDResponseValidCount}
+
+	// RequestBytesResultIDs is a list of the result IDs used for counting
+	// HTTP request body bytes.
+	RequestBytesResultIDs = []ResultID{IDRequestBytesWire, IDRequestBytesDecoded}
 )
 
 // ResultID unique string identifying a requests Result
@@ -124,11 +134,16 @@ type Result struct {
 	Body       interface{}
 	Err        error
 	Stacktrace string
+
+	// EventsAccepted holds the number of events accepted for processing
+	// by an intake request.
+	EventsAccepted int
 }
 
 // DefaultMonitoringMapForRegistry returns map matching resultIDs to monitoring counters for given registry.
 func DefaultMonitoringMapForRegistry(r *monitoring.Registry) map[ResultID]*monitoring.Int {
 	ids := append(DefaultResultIDs, IDUnset)
+	ids = append(ids, RequestBytesResultIDs...)
 	for id := range MapResultIDToStatus {
 		ids = append(ids, id)
 	}
@@ -155,6 +170,7 @@ func (r *Result) Reset() {
 	r.Body = nil
 	r.Err = nil
 	r.Stacktrace = ""
+	r.EventsAccepted = 0
 }
 
 // Failure returns a bool indicating whether it is describing a successful result or not
@@ -29,6 +29,8 @@ import (
 
 	"github.com/elastic/beats/v7/libbeat/version"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/beater/api"
@@ -37,13 +39,22 @@ import (
 	"github.com/elastic/apm-server/internal/beater/jaeger"
 	"github.com/elastic/apm-server/internal/beater/otlp"
 	"github.com/elastic/apm-server/internal/beater/ratelimit"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/datastreamstats"
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/forwarding"
 	"github.com/elastic/apm-server/internal/kibana"
+	"github.com/elastic/apm-server/internal/licensing"
 	"github.com/elastic/apm-server/internal/model"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/sourcemap"
 )
 
+var forwardingMonitoringMap = request.MonitoringMapForRegistry(
+	monitoring.Default.NewRegistry("apm-server.forwarding.grpc"),
+	forwarding.MonitoringResultIDs,
+)
+
 // WrapServerFunc is a function for injecting behaviour into ServerParams
 // and RunServerFunc.
 //
@@ -91,6 +102,10 @@ type ServerParams struct {
 	// RateLimitStore holds an IP-based rate-limiter LRU cache.
 	RateLimitStore *ratelimit.Store
 
+	// APIKeyRateLimitStore holds an API Key-based rate-limiter LRU
+	// cache, or nil if API Key rate limiting is disabled.
+	APIKeyRateLimitStore *ratelimit.Store
+
 	// SourcemapFetcher holds a sourcemap.Fetcher, or nil if source
 	// mapping is disabled.
 	SourcemapFetcher sourcemap.Fetcher
@@ -102,6 +117,25 @@ type ServerParams struct {
 	// for publishing events to the output, such as Elasticsearch.
 	BatchProcessor model.BatchProcessor
 
+	// DryRunBatchProcessor is the model.BatchProcessor that is used for
+	// processing events received by intake dry run requests, which are
+	// returned to the client rather than published. If this is nil, dry
+	// run requests are rejected.
+	DryRunBatchProcessor model.BatchProcessor
+
+	// ServiceInventory holds the inventory of services which recently
+	// sent events. If this is nil, the service inventory endpoint is
+	// not registered.
+	ServiceInventory *modelprocessor.ServiceInventory
+
+	// DataStreamStats holds the reporter of APM data stream statistics.
+	// If this is nil, the data stream stats endpoint is not registered.
+	DataStreamStats *datastreamstats.Reporter
+
+	// AgentConfigAdoption holds the tracker of agent config adoption. If
+	// this is nil, the agent config adoption endpoint is not registered.
+	AgentConfigAdoption *agentcfg.Adoption
+
 	// PublishReady holds a channel which will be signalled when the serve
 	// is ready to publish events. Readiness means that preconditions for
 	// event publication have been met, including icense checks for some
@@ -112,6 +146,22 @@ type ServerParams struct {
 	// accept events and enqueue them for later publication.
 	PublishReady <-chan struct{}
 
+	// BootstrapStatus, if non-nil, returns details of the server's progress
+	// towards being ready to publish events, for reporting by the readiness
+	// endpoint.
+	BootstrapStatus func() mapstr.M
+
+	// QueueState, if non-nil, returns the state of the server's internal
+	// queues, such as those of the Elasticsearch output, for reporting by
+	// the queues endpoint.
+	QueueState func() mapstr.M
+
+	// LicenseChecker holds the checker of the Elasticsearch license, for
+	// determining whether or not features which require a license level
+	// higher than Basic are enabled. If this is nil, no such features
+	// are configured, or the Elasticsearch output is not configured.
+	LicenseChecker *licensing.Checker
+
 	// KibanaClient holds a Kibana client if the server has Kibana
 	// configuration. If the server has no Kibana configuration, this
 	// field will be nil.
@@ -151,6 +201,7 @@ type server struct {
 
 	httpServer *httpServer
 	grpcServer *grpc.Server
+	drain      chan struct{}
 }
 
 func newServer(args ServerParams, listener net.Listener) (server, error) {
@@ -163,11 +214,38 @@ func newServer(args ServerParams, listener net.Listener) (server, error) {
 		}
 	}
 
+	// drain is closed when the server begins shutting down, after which
+	// clients are advised to close their connections.
+	drain := make(chan struct{})
+	draining := func() bool {
+		select {
+		case <-drain:
+			return true
+		default:
+			return false
+		}
+	}
+
+	var capabilities func() mapstr.M
+	if args.LicenseChecker != nil {
+		capabilities = args.LicenseChecker.Capabilities
+	}
+
 	// Create an HTTP server for serving Elastic APM agent requests.
 	router, err := api.NewMux(
-		args.Config, args.BatchProcessor,
-		args.Authenticator, args.AgentConfig, args.RateLimitStore,
-		args.SourcemapFetcher, args.Managed, publishReady,
+		args.Config, args.BatchProcessor, args.Authenticator, args.AgentConfig,
+		args.RateLimitStore, args.Managed, publishReady, draining,
+		api.MuxParams{
+			DryRunBatchProcessor: args.DryRunBatchProcessor,
+			ServiceInventory:     args.ServiceInventory,
+			DataStreamStats:      args.DataStreamStats,
+			AgentConfigAdoption:  args.AgentConfigAdoption,
+			APIKeyRateLimitStore: args.APIKeyRateLimitStore,
+			SourcemapFetcher:     args.SourcemapFetcher,
+			BootstrapStatus:      args.BootstrapStatus,
+			Capabilities:         capabilities,
+			QueueState:           args.QueueState,
+		},
 	)
 	if err != nil {
 		return server{}, err
@@ -188,12 +266,25 @@ func newServer(args ServerParams, listener net.Listener) (server, error) {
 	}
 	otlp.RegisterGRPCServices(args.GRPCServer, otlpBatchProcessor)
 	jaeger.RegisterGRPCServices(args.GRPCServer, args.Logger, args.BatchProcessor, args.AgentConfig)
+	if args.Config.Forwarding.Enabled {
+		// Events forwarded by other APM Server instances have already
+		// been decoded, so they are passed directly to the processor.
+		// This is the same processor chain as for events sent directly
+		// by agents, including any processors wrapped by WrapServerFunc
+		// which require a license.
+		forwarding.RegisterServer(args.GRPCServer, forwarding.ServerConfig{
+			Processor:                args.BatchProcessor,
+			MonitoringMap:            forwardingMonitoringMap,
+			RequireClientCertificate: args.Config.Forwarding.RequireClientCertificate,
+		})
+	}
 
 	return server{
 		logger:     args.Logger,
 		cfg:        args.Config,
 		httpServer: httpServer,
 		grpcServer: args.GRPCServer,
+		drain:      drain,
 	}, nil
 }
 
@@ -208,6 +299,7 @@ func (s server) run(ctx context.Context) error {
 	})
 	g.Go(func() error {
 		<-ctx.Done()
+		close(s.drain)
 		s.grpcServer.GracefulStop()
 		s.httpServer.stop()
 		return nil
@@ -43,6 +43,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tidwall/gjson"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
@@ -538,7 +540,6 @@ func TestServerFailedPreconditionDoesNotIndex(t *testing.T) {
 
 func TestTailSamplingPlatinumLicense(t *testing.T) {
 	bulkCh := make(chan struct{}, 1)
-	licenseReq := make(chan struct{})
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("X-Elastic-Product", "Elasticsearch")
@@ -547,10 +548,6 @@ func TestTailSamplingPlatinumLicense(t *testing.T) {
 		fmt.Fprintln(w, `{"version":{"number":"1.2.3"}}`)
 	})
 	mux.HandleFunc("/_license", func(w http.ResponseWriter, r *http.Request) {
-		select {
-		case <-r.Context().Done():
-		case licenseReq <- struct{}{}:
-		}
 		w.Header().Set("X-Elastic-Product", "Elasticsearch")
 		fmt.Fprintln(w, `{"license":{"uid":"cbff45e7-c553-41f7-ae4f-9205eabd80xx","type":"basic","status":"active"}}`)
 	})
@@ -582,31 +579,30 @@ func TestTailSamplingPlatinumLicense(t *testing.T) {
 	assert.Equal(t, http.StatusAccepted, resp.StatusCode)
 	resp.Body.Close()
 
-	// Wait for two license queries, after which the server should definitely
-	// have processed the first license response, and should have logged an
-	// error message about the license level being invalid.
-	for i := 0; i < 2; i++ {
-		<-licenseReq
+	// The Basic license does not cover tail-based sampling, which should
+	// be disabled rather than preventing the server from becoming ready.
+	// The events should then be indexed.
+	select {
+	case <-bulkCh:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for bulk request")
 	}
-	logs := srv.Logs.FilterMessageSnippet("invalid license level Basic: tail-based sampling requires license level Platinum")
+	logs := srv.Logs.FilterMessageSnippet("tail-based sampling disabled: requires license level Platinum, Elasticsearch license is Basic")
 	assert.NotZero(t, logs.Len())
 
-	// Healthcheck should report that the server is not publish-ready.
+	// Healthcheck should report that the server is publish-ready,
+	// and that tail-based sampling is disabled.
 	resp, err = srv.Client.Get(srv.URL + api.RootPath)
 	require.NoError(t, err)
 	out := decodeJSONMap(t, resp.Body)
 	resp.Body.Close()
-	assert.Equal(t, false, out["publish_ready"])
-
-	// Stop the server.
-	srv.Close()
-
-	// No documents should be indexed.
-	select {
-	case <-bulkCh:
-		t.Fatal("unexpected bulk request")
-	case <-time.After(50 * time.Millisecond):
-	}
+	assert.Equal(t, true, out["publish_ready"])
+	assert.Equal(t, map[string]interface{}{
+		"license": map[string]interface{}{"type": "basic", "status": "active"},
+		"features": map[string]interface{}{
+			"tail_sampling": map[string]interface{}{"enabled": false, "required_license": "platinum"},
+		},
+	}, out["capabilities"])
 }
 
 func TestServerElasticsearchOutput(t *testing.T) {
@@ -687,17 +683,52 @@ func TestServerElasticsearchOutput(t *testing.T) {
 	}, snapshot)
 
 	snapshot = monitoring.CollectStructSnapshot(monitoring.Default.GetRegistry("output"), monitoring.Full, false)
+	// The age of the oldest active event depends on timing,
+	// so we just check that it is reported.
+	eventsSnapshot := snapshot["elasticsearch"].(map[string]interface{})["events"].(map[string]interface{})
+	assert.Contains(t, eventsSnapshot, "active_oldest_age_ms")
+	delete(eventsSnapshot, "active_oldest_age_ms")
 	assert.Equal(t, map[string]interface{}{
 		"elasticsearch": map[string]interface{}{
 			"bulk_requests": map[string]interface{}{
-				"available": int64(9),
-				"completed": int64(0),
+				"available":         int64(9),
+				"completed":         int64(0),
+				"split":             int64(0),
+				"too_large":         int64(0),
+				"learned_max_bytes": int64(0),
+				"timed_out":         int64(0),
+			},
+			"events": map[string]interface{}{
+				"queued":          int64(0),
+				"queued_priority": int64(0),
+				"retried":         int64(0),
+				"held":            int64(0),
+			},
+			"failover": map[string]interface{}{
+				"active":    int64(0),
+				"failovers": int64(0),
+			},
+			"circuit_breaker": map[string]interface{}{
+				"open":   int64(0),
+				"opened": int64(0),
 			},
 			"indexers": map[string]interface{}{
 				"active":    int64(1),
 				"destroyed": int64(0),
 				"created":   int64(0),
 			},
+			"rollover": map[string]interface{}{
+				"failed":    int64(0),
+				"rollovers": int64(0),
+			},
+			"data_streams": map[string]interface{}{
+				"created": int64(0),
+				"failed":  int64(0),
+			},
+			"dead_letter": map[string]interface{}{
+				"indexed": int64(0),
+				"failed":  int64(0),
+			},
 		},
 	}, snapshot)
 }
@@ -752,6 +783,165 @@ func TestWrapServer(t *testing.T) {
 	assert.Equal(t, "true", field.String())
 }
 
+func TestServerIndexIntakeEvents(t *testing.T) {
+	es := beatertest.NewElasticsearchServer(t)
+	srv := beatertest.NewServer(t, beatertest.WithElasticsearch(es))
+
+	res, err := srv.PostEvents(bytes.NewReader(testData))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, body(t, res))
+
+	docs := es.WaitDocuments(t, 4)
+	require.Len(t, docs, 4)
+	for _, doc := range docs {
+		assert.Equal(t, "traces-apm-default", doc.Index)
+		assert.Equal(t, "transaction", gjson.GetBytes(doc.Source, "processor.event").String())
+	}
+}
+
+type otlpTracesReceiver struct {
+	spans         chan ptrace.Span
+	authorization chan string
+}
+
+func (r otlpTracesReceiver) Export(ctx context.Context, req ptraceotlp.ExportRequest) (ptraceotlp.ExportResponse, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.authorization <- strings.Join(md.Get("authorization"), ",")
+	rss := req.Traces().ResourceSpans()
+	for i := 0; i < rss.Len(); i++ {
+		sss := rss.At(i).ScopeSpans()
+		for j := 0; j < sss.Len(); j++ {
+			spans := sss.At(j).Spans()
+			for k := 0; k < spans.Len(); k++ {
+				r.spans <- spans.At(k)
+			}
+		}
+	}
+	return ptraceotlp.NewExportResponse(), nil
+}
+
+func TestServerOTLPOutput(t *testing.T) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	grpcServer := grpc.NewServer()
+	receiver := otlpTracesReceiver{
+		spans:         make(chan ptrace.Span, 10),
+		authorization: make(chan string, 10),
+	}
+	ptraceotlp.RegisterGRPCServer(grpcServer, receiver)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"output.otlp": map[string]interface{}{
+			"endpoint":     lis.Addr().String(),
+			"secret_token": "abc123",
+		},
+	})))
+	res, err := srv.PostEvents(bytes.NewReader(testData))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, body(t, res))
+
+	var names []string
+	for len(names) < 5 {
+		select {
+		case span := <-receiver.spans:
+			names = append(names, span.Name())
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for forwarded spans")
+		}
+	}
+	assert.Equal(t, "Bearer abc123", <-receiver.authorization)
+
+	snapshot := monitoring.CollectStructSnapshot(monitoring.Default.GetRegistry("output"), monitoring.Full, false)
+	assert.Equal(t, map[string]interface{}{
+		"otlp": map[string]interface{}{
+			"requests":  int64(1),
+			"forwarded": int64(5),
+			"failed":    int64(0),
+			"skipped":   int64(0),
+		},
+	}, snapshot)
+}
+
+func TestServerAPMServerOutput(t *testing.T) {
+	es := beatertest.NewElasticsearchServer(t)
+	upstream := beatertest.NewServer(t, beatertest.WithElasticsearch(es), beatertest.WithConfig(
+		agentconfig.MustNewConfigFrom(map[string]interface{}{
+			"apm-server.forwarding.enabled":                    true,
+			"apm-server.forwarding.require_client_certificate": false,
+			"apm-server.auth.secret_token":                     "abc123",
+		}),
+	))
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"output.apm_server": map[string]interface{}{
+			"endpoint":     upstreamURL.Host,
+			"secret_token": "abc123",
+		},
+	})))
+	res, err := srv.PostEvents(bytes.NewReader(testData))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, body(t, res))
+
+	docs := es.WaitDocuments(t, 4)
+	require.Len(t, docs, 4)
+	for _, doc := range docs {
+		assert.Equal(t, "traces-apm-default", doc.Index)
+		assert.Equal(t, "transaction", gjson.GetBytes(doc.Source, "processor.event").String())
+	}
+	// Output metrics are not checked here, as both servers register their
+	// output metrics in the global registry; the forwarding package tests
+	// cover them instead.
+}
+
+func TestServerFileOutput(t *testing.T) {
+	dir := t.TempDir()
+	srv := beatertest.NewServer(t, beatertest.WithConfig(agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"output.file": map[string]interface{}{
+			"path":           dir,
+			"flush_interval": "10ms",
+		},
+	})))
+	res, err := srv.PostEvents(bytes.NewReader(testData))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode, body(t, res))
+
+	var lines []string
+	deadline := time.Now().Add(10 * time.Second)
+	for len(lines) < 10 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for events to be written")
+		}
+		time.Sleep(10 * time.Millisecond)
+		files, err := filepath.Glob(filepath.Join(dir, "apm-server-*.ndjson"))
+		require.NoError(t, err)
+		if len(files) != 1 {
+			continue
+		}
+		data, err := os.ReadFile(files[0])
+		require.NoError(t, err)
+		lines = strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+	require.Len(t, lines, 10)
+	for i := 0; i < len(lines); i += 2 {
+		assert.Equal(t, `{"create":{"_index":"traces-apm-default"}}`, lines[i])
+		assert.Equal(t, "transaction", gjson.Get(lines[i+1], "processor.event").String())
+	}
+
+	snapshot := monitoring.CollectStructSnapshot(monitoring.Default.GetRegistry("output"), monitoring.Full, false)
+	assert.Equal(t, map[string]interface{}{
+		"file": map[string]interface{}{
+			"events": map[string]interface{}{
+				"written": int64(5),
+				"failed":  int64(0),
+			},
+		},
+	}, snapshot)
+}
+
 var testData = func() []byte {
 	b, err := os.ReadFile("../../testdata/intake-v2/transactions.ndjson")
 	if err != nil {
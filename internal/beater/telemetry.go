@@ -18,8 +18,17 @@
 package beater
 
 import (
-	"github.com/elastic/apm-server/internal/beater/config"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"runtime"
+	"sync"
+
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/version"
 )
 
 var apmRegistry = monitoring.GetNamespace("state").GetRegistry().NewRegistry("apm-server")
@@ -52,3 +61,121 @@ func recordAPMServerConfig(cfg *config.Config) {
 	configMonitors.tailSamplingEnabled.Set(cfg.Sampling.Tail.Enabled)
 	configMonitors.tailSamplingPolicies.Set(int64(len(cfg.Sampling.Tail.Policies)))
 }
+
+// serverState holds build and runtime information, and the enabled inputs
+// and features of the server, for auditing fleets of APM Servers for
+// configuration drift. The state is reported periodically along with
+// the rest of the "state" monitoring namespace.
+type serverState struct {
+	mu          sync.RWMutex
+	inputs      []string
+	features    []string
+	gomaxprocs  int
+	memoryLimit int64
+	configHash  string
+}
+
+var serverStateMonitor = &serverState{}
+
+func init() {
+	monitoring.NewFunc(apmRegistry, "server", serverStateMonitor.collect, monitoring.Report)
+}
+
+// recordServerState records the state of the server for monitoring.
+// This should be called once each time runServer is called.
+//
+// memLimit holds the memory limit used for sizing the server, in GB.
+// instrumentation reports whether the self-instrumentation input is
+// enabled. rawConfig holds the full, raw, configuration, of which the
+// apm-server.* and output.* attributes are hashed.
+func recordServerState(cfg *config.Config, rawConfig *agentconfig.C, memLimit float64, instrumentation bool) error {
+	inputs := []string{"intake", "otlp_grpc", "otlp_http"}
+	if cfg.RumConfig.Enabled {
+		inputs = append(inputs, "rum")
+	}
+	if instrumentation {
+		inputs = append(inputs, "instrumentation")
+	}
+	configHash, err := hashConfig(rawConfig)
+	if err != nil {
+		return err
+	}
+
+	serverStateMonitor.mu.Lock()
+	defer serverStateMonitor.mu.Unlock()
+	serverStateMonitor.inputs = inputs
+	serverStateMonitor.features = enabledFeatures(cfg)
+	serverStateMonitor.gomaxprocs = runtime.GOMAXPROCS(0)
+	serverStateMonitor.memoryLimit = int64(memLimit * 1024 * 1024 * 1024)
+	serverStateMonitor.configHash = configHash
+	return nil
+}
+
+// enabledFeatures returns the names of the optional features enabled by cfg.
+func enabledFeatures(cfg *config.Config) []string {
+	var features []string
+	for _, feature := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"api_key", cfg.AgentAuth.APIKey.Enabled},
+		{"api_key_rate_limit", cfg.AgentAuth.APIKey.Enabled && cfg.AgentAuth.APIKey.RateLimit.EventLimit > 0},
+		{"tenant_labels", cfg.AgentAuth.APIKey.Enabled && cfg.AgentAuth.APIKey.TenantLabels},
+		{"anonymous_auth", cfg.AgentAuth.Anonymous.Enabled},
+		{"ssl", cfg.TLS.IsEnabled()},
+		{"kibana", cfg.Kibana.Enabled},
+		{"tail_sampling", cfg.Sampling.Tail.Enabled},
+		{"profiling", cfg.Profiling.Enabled},
+		{"java_attacher", cfg.JavaAttacherConfig.Enabled},
+		{"self_check", cfg.SelfCheck.Enabled},
+		{"lenient_decoding", cfg.Decoding.Lenient()},
+		{"namespace_from_environment", cfg.DataStreams.NamespaceFromEnvironment},
+	} {
+		if feature.enabled {
+			features = append(features, feature.name)
+		}
+	}
+	return features
+}
+
+// hashConfig returns a hex-encoded SHA-256 hash of the apm-server.* and
+// output.* attributes of rawConfig, such that servers running with the
+// same configuration report the same hash.
+func hashConfig(rawConfig *agentconfig.C) (string, error) {
+	var unpacked struct {
+		APMServer map[string]interface{} `config:"apm-server" json:"apm-server"`
+		Output    map[string]interface{} `config:"output" json:"output"`
+	}
+	if rawConfig != nil {
+		if err := rawConfig.Unpack(&unpacked); err != nil {
+			return "", err
+		}
+	}
+	// encoding/json sorts map keys, so the encoding is deterministic.
+	encoded, err := json.Marshal(unpacked)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (s *serverState) collect(m monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	monitoring.ReportNamespace(V, "build", func() {
+		monitoring.ReportString(V, "version", version.Version)
+		monitoring.ReportString(V, "commit", version.CommitHash())
+		monitoring.ReportString(V, "go_version", runtime.Version())
+	})
+	monitoring.ReportNamespace(V, "runtime", func() {
+		monitoring.ReportInt(V, "gomaxprocs", int64(s.gomaxprocs))
+		monitoring.ReportInt(V, "memory_limit", s.memoryLimit)
+	})
+	monitoring.ReportStringSlice(V, "inputs", s.inputs)
+	monitoring.ReportStringSlice(V, "features", s.features)
+	monitoring.ReportString(V, "config_hash", s.configHash)
+}
@@ -18,11 +18,17 @@
 package beater
 
 import (
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/version"
 )
 
 func TestRecordConfigs(t *testing.T) {
@@ -40,6 +46,45 @@ func TestRecordConfigs(t *testing.T) {
 	assert.Equal(t, configMonitors.sslEnabled.Get(), false)
 }
 
+func TestRecordServerState(t *testing.T) {
+	apmCfg := config.DefaultConfig()
+	apmCfg.RumConfig.Enabled = true
+	apmCfg.AgentAuth.APIKey.Enabled = true
+	apmCfg.Decoding.Mode = config.DecodingModeLenient
+	rawConfig := agentconfig.MustNewConfigFrom(map[string]interface{}{
+		"apm-server.rum.enabled": true,
+		"output.elasticsearch":   map[string]interface{}{"hosts": []string{"localhost:9200"}},
+		"path.data":              "/tmp/data",
+	})
+	require.NoError(t, recordServerState(apmCfg, rawConfig, 2, false))
+
+	snapshot := monitoring.CollectStructSnapshot(apmRegistry, monitoring.Full, false)
+	state := snapshot["server"].(map[string]interface{})
+	assert.Equal(t, []string{"intake", "otlp_grpc", "otlp_http", "rum"}, state["inputs"])
+	assert.Equal(t, []string{"api_key", "lenient_decoding"}, state["features"])
+	assert.Equal(t, map[string]interface{}{
+		"gomaxprocs":   int64(runtime.GOMAXPROCS(0)),
+		"memory_limit": int64(2 * 1024 * 1024 * 1024),
+	}, state["runtime"])
+	assert.Equal(t, version.Version, state["build"].(map[string]interface{})["version"])
+	configHash := state["config_hash"]
+	assert.Len(t, configHash, 64)
+
+	// The config hash is not affected by attributes other than
+	// apm-server.* and output.*, but is affected by those.
+	rawConfig.SetString("path.data", -1, "/tmp/other")
+	require.NoError(t, recordServerState(apmCfg, rawConfig, 2, false))
+	snapshot = monitoring.CollectStructSnapshot(apmRegistry, monitoring.Full, false)
+	assert.Equal(t, configHash, snapshot["server"].(map[string]interface{})["config_hash"])
+
+	rawConfig.SetBool("apm-server.rum.enabled", -1, false)
+	require.NoError(t, recordServerState(apmCfg, rawConfig, 2, true))
+	snapshot = monitoring.CollectStructSnapshot(apmRegistry, monitoring.Full, false)
+	state = snapshot["server"].(map[string]interface{})
+	assert.NotEqual(t, configHash, state["config_hash"])
+	assert.Contains(t, state["inputs"], "instrumentation")
+}
+
 func resetCounters() {
 	configMonitors.rumEnabled.Set(false)
 	configMonitors.apiKeysEnabled.Set(false)
@@ -18,11 +18,17 @@
 package beater
 
 import (
+	"context"
+	"fmt"
 	"net"
 	"net/http"
+	"sync/atomic"
+
+	"go.elastic.co/apm/v2"
 
 	"github.com/elastic/elastic-agent-libs/logp"
 
+	"github.com/elastic/apm-server/internal/agentcfg"
 	"github.com/elastic/apm-server/internal/beater/api"
 	"github.com/elastic/apm-server/internal/beater/auth"
 	"github.com/elastic/apm-server/internal/beater/config"
@@ -30,7 +36,28 @@ import (
 	"github.com/elastic/apm-server/internal/model"
 )
 
-func newTracerServer(cfg *config.Config, listener net.Listener, logger *logp.Logger, batchProcessor model.BatchProcessor) (*http.Server, error) {
+// tracerServer is an HTTP server which receives self-instrumentation
+// events from the in-memory tracer, and processes them with a batch
+// processor.
+type tracerServer struct {
+	server   *http.Server
+	listener net.Listener
+	logger   *logp.Logger
+
+	// processed and failed hold the number of events processed by
+	// the batch processor, and the number for which it returned an
+	// error, and must be accessed atomically.
+	processed int64
+	failed    int64
+}
+
+func newTracerServer(
+	cfg *config.Config,
+	listener net.Listener,
+	logger *logp.Logger,
+	batchProcessor model.BatchProcessor,
+	agentConfig agentcfg.Fetcher,
+) (*tracerServer, error) {
 	ratelimitStore, err := ratelimit.NewStore(1, 1, 1) // unused, arbitrary params
 	if err != nil {
 		return nil, err
@@ -39,24 +66,67 @@ func newTracerServer(cfg *config.Config, listener net.Listener, logger *logp.Log
 	if err != nil {
 		return nil, err
 	}
+	s := &tracerServer{listener: listener, logger: logger}
+	countingBatchProcessor := model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
+		n := int64(len(*b))
+		if err := batchProcessor.ProcessBatch(ctx, b); err != nil {
+			atomic.AddInt64(&s.failed, n)
+			return err
+		}
+		atomic.AddInt64(&s.processed, n)
+		return nil
+	})
 	mux, err := api.NewMux(
 		cfg,
-		batchProcessor,
+		countingBatchProcessor,
 		authenticator,
-		newAgentConfigFetcher(cfg, nil /* kibana client */),
+		agentConfig,
 		ratelimitStore,
-		nil,                         // no sourcemap store
-		false,                       // not managed
-		func() bool { return true }, // ready for publishing
+		false,                        // not managed
+		func() bool { return true },  // ready for publishing
+		func() bool { return false }, // never draining
+		api.MuxParams{},
 	)
 	if err != nil {
 		return nil, err
 	}
-	return &http.Server{
+	s.server = &http.Server{
 		Handler:        mux,
 		IdleTimeout:    cfg.IdleTimeout,
 		ReadTimeout:    cfg.ReadTimeout,
 		WriteTimeout:   cfg.WriteTimeout,
 		MaxHeaderBytes: cfg.MaxHeaderSize,
-	}, nil
+	}
+	return s, nil
+}
+
+// serve serves requests until stop is called.
+func (s *tracerServer) serve() error {
+	if err := s.server.Serve(s.listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// stop stops the server, after flushing the pending events of tracer
+// so the traces recorded while shutting down are not lost. Once flushed,
+// the server stops accepting requests and waits for in-flight requests
+// to be processed, until ctx is cancelled.
+//
+// stop should be called after the main server has stopped, and before
+// the batch processor is closed.
+func (s *tracerServer) stop(ctx context.Context, tracer *apm.Tracer) error {
+	processed := atomic.LoadInt64(&s.processed)
+	tracer.Flush(ctx.Done())
+	err := s.server.Shutdown(ctx)
+	if err != nil {
+		s.server.Close()
+		err = fmt.Errorf("failed to stop self-instrumentation server: %w", err)
+	}
+	total := atomic.LoadInt64(&s.processed)
+	s.logger.Infof(
+		"self-instrumentation server stopped: processed %d events (%d while stopping), %d failed",
+		total, total-processed, atomic.LoadInt64(&s.failed),
+	)
+	return err
 }
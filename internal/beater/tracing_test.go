@@ -23,10 +23,12 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/tidwall/gjson"
 
 	"github.com/elastic/apm-server/internal/beater/beatertest"
 	agentconfig "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 func TestServerTracingEnabled(t *testing.T) {
@@ -38,19 +40,6 @@ func TestServerTracingEnabled(t *testing.T) {
 			srv := beatertest.NewServer(t, beatertest.WithConfig(escfg,
 				agentconfig.MustNewConfigFrom(map[string]interface{}{
 					"instrumentation.enabled": enabled,
-
-					// The output instrumentation may send transactions for
-					// bulk operations, e.g. there will be "flush" transactions
-					// send by modelindexer for _bulk requests. When the server
-					// sends traces to itself, it will enter a state where it
-					// continues to regularly send traces to itself from the
-					// traced output.
-					//
-					// TODO(axw) we should consider having a separate processor
-					// pipeline (including output) with no tracing. For now, we
-					// set a short shutdown timeout so that if an trace events
-					// are not consumed, they will not block shutdown.
-					"apm-server.shutdown_timeout": "1ns",
 				}),
 			))
 
@@ -76,6 +65,11 @@ func TestServerTracingEnabled(t *testing.T) {
 						break
 					}
 				}
+
+				// Self-instrumentation events are indexed by a dedicated indexer.
+				snapshot := monitoring.CollectStructSnapshot(monitoring.Default.GetRegistry("output"), monitoring.Full, false)
+				selfInstrumentation := snapshot["elasticsearch"].(map[string]interface{})["self_instrumentation"]
+				assert.NotZero(t, selfInstrumentation.(map[string]interface{})["added"])
 			}
 
 			// There should be no more "request" transactions: there may be ongoing
@@ -100,3 +94,53 @@ func TestServerTracingEnabled(t *testing.T) {
 		})
 	}
 }
+
+func TestServerTracingShutdownFlush(t *testing.T) {
+	// Set a long request time so that events are only sent
+	// by the tracer when it is flushed during shutdown.
+	t.Setenv("ELASTIC_APM_API_REQUEST_TIME", "1m")
+
+	escfg, docs := beatertest.ElasticsearchOutputConfig(t)
+	srv := beatertest.NewServer(t, beatertest.WithConfig(escfg,
+		agentconfig.MustNewConfigFrom(map[string]interface{}{
+			"instrumentation.enabled": true,
+		}),
+	))
+	assert.Eventually(t, func() bool {
+		return srv.Logs.FilterMessageSnippet("no longer blocking ingestion").Len() > 0
+	}, 10*time.Second, 10*time.Millisecond)
+
+	resp, err := srv.Client.Get(srv.URL + "/foo")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		srv.Close()
+	}()
+	for {
+		var doc []byte
+		select {
+		case doc = <-docs:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for event")
+		}
+		if gjson.GetBytes(doc, "transaction.name").String() == "GET unknown route" {
+			break
+		}
+	}
+	go func() {
+		// Consume any remaining documents so the server can stop.
+		for range docs {
+		}
+	}()
+	select {
+	case <-closed:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for server to stop")
+	}
+
+	logs := srv.Logs.FilterMessageSnippet("self-instrumentation server stopped")
+	assert.Equal(t, 1, logs.Len())
+}
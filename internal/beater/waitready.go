@@ -20,54 +20,197 @@ package beater
 import (
 	"context"
 	"errors"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"go.elastic.co/apm/v2"
 
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// Kinds of precondition failure, reported by the readiness endpoint so
+// operators can tell what needs fixing while the server is waiting.
+const (
+	preconditionFailureAuth        = "auth"
+	preconditionFailureNetwork     = "network"
+	preconditionFailureLicense     = "license"
+	preconditionFailureIntegration = "integration"
+	preconditionFailureOther       = "other"
 )
 
-// waitReady waits for preconditions to be satisfied, by calling check
-// in a loop every interval until ctx is cancelled or check returns nil.
+// waitReady waits for preconditions to be satisfied, by calling check in
+// a loop until ctx is cancelled or check returns nil. After each failed
+// check, waitReady waits before checking again, starting with interval
+// and doubling up to maxInterval.
+//
+// The outcome of each check is recorded in status.
 func waitReady(
 	ctx context.Context,
-	interval time.Duration,
+	interval, maxInterval time.Duration,
 	tracer *apm.Tracer,
 	logger *logp.Logger,
+	status *bootstrapStatus,
 	check func(context.Context) error,
 ) error {
 	logger.Info("blocking ingestion until all preconditions are satisfied")
 	tx := tracer.StartTransaction("wait_for_preconditions", "init")
 	defer tx.End()
 	ctx = apm.ContextWithTransaction(ctx, tx)
-	var ticker *time.Ticker
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	backoff := interval
 	for {
-		if ticker == nil {
-			// We start the ticker on the first iteration, rather than
-			// before the loop, so we don't have to wait for a tick
-			// (5 seconds by default) before peforming the first check.
-			ticker = time.NewTicker(interval)
-			defer ticker.Stop()
+		err := check(ctx)
+		if err == nil {
+			status.setReady(time.Now())
+			logger.Info("no longer blocking ingestion as all precondition checks are now satisfied")
+			return nil
+		}
+		failure := preconditionFailure(err)
+		now := time.Now()
+		status.recordFailure(now, now.Add(backoff), failure, err)
+
+		logger := logger.With("precondition.failure", failure)
+		var e *actionableError
+		if errors.As(err, &e) {
+			logger.Errorf("precondition '%s' failed: %s", e.Name, e.Error())
 		} else {
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-ticker.C:
-			}
+			logger.Errorf("precondition failed: %s", err)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxInterval {
+			backoff = maxInterval
 		}
-		if err := check(ctx); err != nil {
-			var e *actionableError
-			if errors.As(err, &e) {
-				logger.Errorf("precondition '%s' failed: %s", e.Name, e.Error())
-			} else {
-				logger.Errorf("precondition failed: %s", err)
-			}
-			continue
+	}
+}
+
+// preconditionError associates an error returned by a precondition
+// with the kind of failure, when it cannot be determined from the
+// error itself.
+type preconditionError struct {
+	failure string
+	err     error
+}
+
+func (e *preconditionError) Error() string {
+	return e.err.Error()
+}
+
+func (e *preconditionError) Unwrap() error {
+	return e.err
+}
+
+// httpStatusError is returned by preconditions when Elasticsearch or
+// Kibana responds with an unexpected HTTP status code.
+type httpStatusError struct {
+	statusCode int
+	err        error
+}
+
+func (e *httpStatusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *httpStatusError) Unwrap() error {
+	return e.err
+}
+
+// preconditionFailure returns the kind of precondition failure for err.
+// Authentication and network failures take precedence over the kind of
+// precondition which failed, as they are the cause of the failure.
+func preconditionFailure(err error) string {
+	var statusErr *httpStatusError
+	var esErr *elasticsearch.Error
+	switch {
+	case errors.As(err, &statusErr) && isAuthStatusCode(statusErr.statusCode),
+		errors.As(err, &esErr) && isAuthStatusCode(esErr.StatusCode):
+		return preconditionFailureAuth
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return preconditionFailureNetwork
+	}
+	var preconditionErr *preconditionError
+	if errors.As(err, &preconditionErr) {
+		return preconditionErr.failure
+	}
+	return preconditionFailureOther
+}
+
+func isAuthStatusCode(statusCode int) bool {
+	return statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden
+}
+
+// bootstrapStatus records the progress of waiting for the server's
+// indexing preconditions to be satisfied, for reporting by the
+// readiness endpoint. While the server is not ready, events are
+// accepted and buffered until they can be indexed.
+type bootstrapStatus struct {
+	mu          sync.RWMutex
+	ready       bool
+	attempts    int
+	readyTime   time.Time
+	lastAttempt time.Time
+	nextAttempt time.Time
+	failure     string
+	err         error
+}
+
+func (s *bootstrapStatus) setReady(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ready = true
+	s.attempts++
+	s.readyTime = now
+	s.lastAttempt = now
+	s.nextAttempt = time.Time{}
+	s.failure, s.err = "", nil
+}
+
+func (s *bootstrapStatus) recordFailure(now, next time.Time, failure string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attempts++
+	s.lastAttempt = now
+	s.nextAttempt = next
+	s.failure, s.err = failure, err
+}
+
+// Status returns the bootstrap status for the readiness endpoint.
+func (s *bootstrapStatus) Status() mapstr.M {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.ready {
+		return mapstr.M{
+			"state":      "ready",
+			"attempts":   s.attempts,
+			"ready_time": s.readyTime.UTC().Format(time.RFC3339),
 		}
-		logger.Info("no longer blocking ingestion as all precondition checks are now satisfied")
-		return nil
 	}
+	status := mapstr.M{
+		"state":    "waiting",
+		"intake":   "buffering",
+		"attempts": s.attempts,
+	}
+	if s.attempts > 0 {
+		status["last_attempt"] = s.lastAttempt.UTC().Format(time.RFC3339)
+		status["next_attempt"] = s.nextAttempt.UTC().Format(time.RFC3339)
+		status["failure"] = mapstr.M{"type": s.failure, "message": s.err.Error()}
+	}
+	return status
 }
 
 // waitReadyRoundTripper wraps a *net/http.Transport, ensuring the server's
@@ -0,0 +1,141 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package beater
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2/apmtest"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+)
+
+func TestWaitReadyBackoff(t *testing.T) {
+	var checks []time.Time
+	var statuses []string
+	status := &bootstrapStatus{}
+	check := func(ctx context.Context) error {
+		checks = append(checks, time.Now())
+		if len(checks) > 1 {
+			statuses = append(statuses, status.Status()["state"].(string))
+		}
+		if len(checks) < 5 {
+			return &httpStatusError{statusCode: http.StatusUnauthorized, err: errors.New("unauthorized")}
+		}
+		return nil
+	}
+	err := waitReady(
+		context.Background(), 10*time.Millisecond, 40*time.Millisecond,
+		apmtest.DiscardTracer, logp.NewLogger(""), status, check,
+	)
+	require.NoError(t, err)
+	require.Len(t, checks, 5)
+
+	// The interval between checks doubles, up to the maximum.
+	for i, min := range []time.Duration{
+		10 * time.Millisecond,
+		20 * time.Millisecond,
+		40 * time.Millisecond,
+		40 * time.Millisecond,
+	} {
+		assert.GreaterOrEqual(t, checks[i+1].Sub(checks[i]), min)
+	}
+	assert.Equal(t, []string{"waiting", "waiting", "waiting", "waiting"}, statuses)
+
+	result := status.Status()
+	assert.Equal(t, "ready", result["state"])
+	assert.Equal(t, 5, result["attempts"])
+	assert.NotContains(t, result, "failure")
+}
+
+func TestWaitReadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	status := &bootstrapStatus{}
+	check := func(ctx context.Context) error {
+		cancel()
+		return &preconditionError{failure: preconditionFailureLicense, err: errors.New("license expired")}
+	}
+	err := waitReady(ctx, time.Minute, time.Minute, apmtest.DiscardTracer, logp.NewLogger(""), status, check)
+	assert.Equal(t, context.Canceled, err)
+
+	result := status.Status()
+	assert.Equal(t, "waiting", result["state"])
+	assert.Equal(t, "buffering", result["intake"])
+	assert.Equal(t, 1, result["attempts"])
+	assert.Equal(t, mapstr.M{
+		"type":    "license",
+		"message": "license expired",
+	}, result["failure"])
+}
+
+func TestPreconditionFailure(t *testing.T) {
+	netErr := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	for _, test := range []struct {
+		err     error
+		failure string
+	}{{
+		err:     errors.New("boom"),
+		failure: preconditionFailureOther,
+	}, {
+		err:     &httpStatusError{statusCode: http.StatusForbidden, err: errors.New("forbidden")},
+		failure: preconditionFailureAuth,
+	}, {
+		err:     &httpStatusError{statusCode: http.StatusServiceUnavailable, err: errors.New("unavailable")},
+		failure: preconditionFailureOther,
+	}, {
+		err:     fmt.Errorf("error querying cluster_uuid: %w", netErr),
+		failure: preconditionFailureNetwork,
+	}, {
+		err:     &preconditionError{failure: preconditionFailureLicense, err: errors.New("expired")},
+		failure: preconditionFailureLicense,
+	}, {
+		// Authentication and network failures take precedence over the
+		// kind of precondition which failed.
+		err: &preconditionError{
+			failure: preconditionFailureIntegration,
+			err: &actionableError{
+				Name: "apm integration installed",
+				Err:  &httpStatusError{statusCode: http.StatusUnauthorized, err: errors.New("unauthorized")},
+			},
+		},
+		failure: preconditionFailureAuth,
+	}, {
+		err: &preconditionError{
+			failure: preconditionFailureIntegration,
+			err: &actionableError{
+				Name: "apm integration installed",
+				Err:  &httpStatusError{statusCode: http.StatusNotFound, err: errors.New("not found")},
+			},
+		},
+		failure: preconditionFailureIntegration,
+	}, {
+		err:     &preconditionError{failure: preconditionFailureIntegration, err: netErr},
+		failure: preconditionFailureNetwork,
+	}} {
+		assert.Equal(t, test.failure, preconditionFailure(test.err), test.err.Error())
+	}
+}
@@ -0,0 +1,357 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package datastreamstats provides periodic reporting of the size, document
+// counts, and lifecycle state of the APM data streams, giving operators early
+// warning of retention misconfiguration from the ingest side.
+package datastreamstats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// unmanagedPhase is the phase reported for backing indices which
+// are not managed by ILM.
+const unmanagedPhase = "unmanaged"
+
+// Report holds the most recently collected data stream statistics.
+type Report struct {
+	// Collected holds the time at which the statistics were collected,
+	// or the zero time if they have not been collected yet.
+	Collected time.Time `json:"collected"`
+
+	// DataStreams holds the statistics of each APM data stream,
+	// ordered by name.
+	DataStreams []DataStream `json:"data_streams"`
+}
+
+// DataStream holds the statistics of a single data stream.
+type DataStream struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Health    string `json:"health"`
+	ILMPolicy string `json:"ilm_policy,omitempty"`
+
+	// BackingIndices holds the number of backing indices.
+	BackingIndices int `json:"backing_indices"`
+
+	// Docs holds the number of documents in the primary shards
+	// of the backing indices.
+	Docs int64 `json:"docs"`
+
+	// SizeBytes holds the total store size of the backing indices,
+	// including replicas.
+	SizeBytes int64 `json:"size_bytes"`
+
+	// Phases holds the number of backing indices in each ILM phase.
+	// Backing indices not managed by ILM are counted as "unmanaged".
+	Phases map[string]int `json:"phases,omitempty"`
+
+	// Warnings describes lifecycle configuration which is likely to
+	// cause data to be retained indefinitely, or not as configured.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// Reporter periodically collects statistics of the APM data streams in
+// Elasticsearch, and reports them as monitoring metrics.
+type Reporter struct {
+	client   elasticsearch.Client
+	patterns []string
+	interval time.Duration
+	logger   *logp.Logger
+
+	succeeded int64
+	failed    int64
+
+	mu     sync.RWMutex
+	report Report
+}
+
+// NewReporter returns a new Reporter which collects statistics of the APM
+// data streams in the given namespace at the given interval. If namespace
+// is empty, the APM data streams in all namespaces are reported.
+func NewReporter(client elasticsearch.Client, namespace string, interval time.Duration) *Reporter {
+	if namespace == "" {
+		namespace = "*"
+	}
+	return &Reporter{
+		client: client,
+		patterns: []string{
+			"traces-apm*-" + namespace,
+			"metrics-apm*-" + namespace,
+			"logs-apm*-" + namespace,
+		},
+		interval: interval,
+		logger:   logp.NewLogger(logs.Beater).Named("datastreamstats"),
+	}
+}
+
+// Run collects statistics immediately, and then periodically until ctx
+// is cancelled.
+func (r *Reporter) Run(ctx context.Context) error {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		report, err := r.collect(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			atomic.AddInt64(&r.failed, 1)
+			r.logger.Warnf("failed to collect data stream statistics: %v", err)
+		} else {
+			atomic.AddInt64(&r.succeeded, 1)
+			for _, ds := range report.DataStreams {
+				for _, warning := range ds.Warnings {
+					r.logger.Warnf("data stream %s: %s", ds.Name, warning)
+				}
+			}
+			r.mu.Lock()
+			r.report = report
+			r.mu.Unlock()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Report returns the most recently collected statistics.
+func (r *Reporter) Report() Report {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.report
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// The statistics of the data streams are aggregated by namespace.
+func (r *Reporter) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	monitoring.ReportInt(V, "succeeded", atomic.LoadInt64(&r.succeeded))
+	monitoring.ReportInt(V, "failed", atomic.LoadInt64(&r.failed))
+
+	type namespaceStats struct {
+		dataStreams int64
+		docs        int64
+		sizeBytes   int64
+		warnings    int64
+		phases      map[string]int64
+	}
+	namespaces := make(map[string]*namespaceStats)
+	var names []string
+	for _, ds := range r.Report().DataStreams {
+		stats, ok := namespaces[ds.Namespace]
+		if !ok {
+			stats = &namespaceStats{phases: make(map[string]int64)}
+			namespaces[ds.Namespace] = stats
+			names = append(names, ds.Namespace)
+		}
+		stats.dataStreams++
+		stats.docs += ds.Docs
+		stats.sizeBytes += ds.SizeBytes
+		stats.warnings += int64(len(ds.Warnings))
+		for phase, n := range ds.Phases {
+			stats.phases[phase] += int64(n)
+		}
+	}
+	sort.Strings(names)
+	monitoring.ReportNamespace(V, "namespaces", func() {
+		for _, name := range names {
+			stats := namespaces[name]
+			monitoring.ReportNamespace(V, name, func() {
+				monitoring.ReportInt(V, "data_streams", stats.dataStreams)
+				monitoring.ReportInt(V, "docs", stats.docs)
+				monitoring.ReportInt(V, "size.bytes", stats.sizeBytes)
+				monitoring.ReportInt(V, "warnings", stats.warnings)
+				monitoring.ReportNamespace(V, "phases", func() {
+					phases := make([]string, 0, len(stats.phases))
+					for phase := range stats.phases {
+						phases = append(phases, phase)
+					}
+					sort.Strings(phases)
+					for _, phase := range phases {
+						monitoring.ReportInt(V, phase, stats.phases[phase])
+					}
+				})
+			})
+		}
+	})
+}
+
+// collect queries Elasticsearch for the data streams matching r.patterns,
+// and their backing indices' statistics and lifecycle state.
+func (r *Reporter) collect(ctx context.Context) (Report, error) {
+	report := Report{Collected: time.Now()}
+
+	var dataStreamsResult struct {
+		DataStreams []struct {
+			Name      string `json:"name"`
+			Status    string `json:"status"`
+			ILMPolicy string `json:"ilm_policy"`
+			Indices   []struct {
+				IndexName string `json:"index_name"`
+			} `json:"indices"`
+		} `json:"data_streams"`
+	}
+	if err := r.do(ctx, esapi.IndicesGetDataStreamRequest{
+		Name: r.patterns,
+	}, &dataStreamsResult); err != nil {
+		return Report{}, errors.Wrap(err, "failed to get data streams")
+	}
+	if len(dataStreamsResult.DataStreams) == 0 {
+		return report, nil
+	}
+
+	var statsResult struct {
+		Indices map[string]struct {
+			Primaries struct {
+				Docs struct {
+					Count int64 `json:"count"`
+				} `json:"docs"`
+			} `json:"primaries"`
+			Total struct {
+				Store struct {
+					SizeInBytes int64 `json:"size_in_bytes"`
+				} `json:"store"`
+			} `json:"total"`
+		} `json:"indices"`
+	}
+	if err := r.do(ctx, esapi.IndicesStatsRequest{
+		Index:  r.patterns,
+		Metric: []string{"docs", "store"},
+	}, &statsResult); err != nil {
+		return Report{}, errors.Wrap(err, "failed to get index stats")
+	}
+
+	var explainResult struct {
+		Indices map[string]struct {
+			Managed bool   `json:"managed"`
+			Phase   string `json:"phase"`
+			Step    string `json:"step"`
+		} `json:"indices"`
+	}
+	if err := r.do(ctx, esapi.ILMExplainLifecycleRequest{
+		Index: strings.Join(r.patterns, ","),
+	}, &explainResult); err != nil {
+		return Report{}, errors.Wrap(err, "failed to explain index lifecycle")
+	}
+
+	var policiesResult map[string]struct {
+		Policy struct {
+			Phases map[string]json.RawMessage `json:"phases"`
+		} `json:"policy"`
+	}
+	if err := r.do(ctx, esapi.ILMGetLifecycleRequest{}, &policiesResult); err != nil {
+		return Report{}, errors.Wrap(err, "failed to get lifecycle policies")
+	}
+
+	for _, result := range dataStreamsResult.DataStreams {
+		ds := DataStream{
+			Name:           result.Name,
+			Namespace:      result.Name[strings.LastIndexByte(result.Name, '-')+1:],
+			Health:         strings.ToLower(result.Status),
+			ILMPolicy:      result.ILMPolicy,
+			BackingIndices: len(result.Indices),
+			Phases:         make(map[string]int),
+		}
+		var unmanaged, lifecycleErrors int
+		for _, index := range result.Indices {
+			stats := statsResult.Indices[index.IndexName]
+			ds.Docs += stats.Primaries.Docs.Count
+			ds.SizeBytes += stats.Total.Store.SizeInBytes
+
+			explain := explainResult.Indices[index.IndexName]
+			if !explain.Managed {
+				unmanaged++
+				ds.Phases[unmanagedPhase]++
+				continue
+			}
+			ds.Phases[explain.Phase]++
+			if explain.Step == "ERROR" {
+				lifecycleErrors++
+			}
+		}
+
+		if ds.ILMPolicy == "" {
+			ds.Warnings = append(ds.Warnings, "no lifecycle policy is configured; data will be retained indefinitely")
+		} else if policy, ok := policiesResult[ds.ILMPolicy]; !ok {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf(
+				"lifecycle policy %q does not exist; data will be retained indefinitely", ds.ILMPolicy,
+			))
+		} else if _, ok := policy.Policy.Phases["delete"]; !ok {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf(
+				"lifecycle policy %q has no delete phase; data will be retained indefinitely", ds.ILMPolicy,
+			))
+		}
+		if ds.ILMPolicy != "" && unmanaged > 0 {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf(
+				"%d of %d backing indices are not managed by a lifecycle policy", unmanaged, ds.BackingIndices,
+			))
+		}
+		if lifecycleErrors > 0 {
+			ds.Warnings = append(ds.Warnings, fmt.Sprintf(
+				"%d of %d backing indices failed a lifecycle step", lifecycleErrors, ds.BackingIndices,
+			))
+		}
+		report.DataStreams = append(report.DataStreams, ds)
+	}
+	sort.Slice(report.DataStreams, func(i, j int) bool {
+		return report.DataStreams[i].Name < report.DataStreams[j].Name
+	})
+	return report, nil
+}
+
+func (r *Reporter) do(ctx context.Context, req esapi.Request, out interface{}) error {
+	resp, err := req.Do(ctx, r.client)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// No indices or policies exist.
+		return nil
+	}
+	if resp.IsError() {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("request failed with status code %d: %s", resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,184 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package datastreamstats
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func newTestClient(t testing.TB, handler http.HandlerFunc) elasticsearch.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+	return client
+}
+
+func TestReporter(t *testing.T) {
+	const patterns = "traces-apm*-testing,metrics-apm*-testing,logs-apm*-testing"
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_data_stream/" + patterns:
+			fmt.Fprint(w, `{"data_streams":[{
+				"name": "traces-apm-testing",
+				"status": "GREEN",
+				"ilm_policy": "traces-apm.traces-default_policy",
+				"indices": [{"index_name": ".ds-traces-1"}, {"index_name": ".ds-traces-2"}]
+			}, {
+				"name": "logs-apm.error-testing",
+				"status": "YELLOW",
+				"ilm_policy": "logs-apm.error-custom",
+				"indices": [{"index_name": ".ds-logs-1"}]
+			}, {
+				"name": "metrics-apm.internal-testing",
+				"status": "GREEN",
+				"indices": [{"index_name": ".ds-metrics-1"}]
+			}]}`)
+		case "/" + patterns + "/_stats/docs,store":
+			fmt.Fprint(w, `{"indices":{
+				".ds-traces-1": {"primaries":{"docs":{"count":10}},"total":{"store":{"size_in_bytes":2000}}},
+				".ds-traces-2": {"primaries":{"docs":{"count":5}},"total":{"store":{"size_in_bytes":1000}}},
+				".ds-logs-1": {"primaries":{"docs":{"count":1}},"total":{"store":{"size_in_bytes":100}}},
+				".ds-metrics-1": {"primaries":{"docs":{"count":2}},"total":{"store":{"size_in_bytes":200}}}
+			}}`)
+		case "/" + patterns + "/_ilm/explain":
+			fmt.Fprint(w, `{"indices":{
+				".ds-traces-1": {"managed":true,"phase":"warm","step":"complete"},
+				".ds-traces-2": {"managed":true,"phase":"hot","step":"check-rollover-ready"},
+				".ds-logs-1": {"managed":true,"phase":"hot","step":"ERROR"},
+				".ds-metrics-1": {"managed":false}
+			}}`)
+		case "/_ilm/policy":
+			fmt.Fprint(w, `{
+				"traces-apm.traces-default_policy": {"policy":{"phases":{"hot":{},"warm":{},"delete":{}}}},
+				"logs-apm.error-custom": {"policy":{"phases":{"hot":{}}}}
+			}`)
+		default:
+			t.Errorf("unexpected request path %q", r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	})
+
+	reporter := NewReporter(client, "testing", time.Minute)
+	report, err := reporter.collect(context.Background())
+	require.NoError(t, err)
+	assert.NotZero(t, report.Collected)
+	assert.Equal(t, []DataStream{{
+		Name:           "logs-apm.error-testing",
+		Namespace:      "testing",
+		Health:         "yellow",
+		ILMPolicy:      "logs-apm.error-custom",
+		BackingIndices: 1,
+		Docs:           1,
+		SizeBytes:      100,
+		Phases:         map[string]int{"hot": 1},
+		Warnings: []string{
+			`lifecycle policy "logs-apm.error-custom" has no delete phase; data will be retained indefinitely`,
+			"1 of 1 backing indices failed a lifecycle step",
+		},
+	}, {
+		Name:           "metrics-apm.internal-testing",
+		Namespace:      "testing",
+		Health:         "green",
+		BackingIndices: 1,
+		Docs:           2,
+		SizeBytes:      200,
+		Phases:         map[string]int{"unmanaged": 1},
+		Warnings:       []string{"no lifecycle policy is configured; data will be retained indefinitely"},
+	}, {
+		Name:           "traces-apm-testing",
+		Namespace:      "testing",
+		Health:         "green",
+		ILMPolicy:      "traces-apm.traces-default_policy",
+		BackingIndices: 2,
+		Docs:           15,
+		SizeBytes:      3000,
+		Phases:         map[string]int{"hot": 1, "warm": 1},
+	}}, report.DataStreams)
+
+	reporter.report = report
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "data_streams", reporter.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"data_streams.succeeded":                           0,
+		"data_streams.failed":                              0,
+		"data_streams.namespaces.testing.data_streams":     3,
+		"data_streams.namespaces.testing.docs":             18,
+		"data_streams.namespaces.testing.size.bytes":       3300,
+		"data_streams.namespaces.testing.warnings":         3,
+		"data_streams.namespaces.testing.phases.hot":       2,
+		"data_streams.namespaces.testing.phases.warm":      1,
+		"data_streams.namespaces.testing.phases.unmanaged": 1,
+	}, snapshot.Ints)
+}
+
+func TestReporterAllNamespaces(t *testing.T) {
+	var paths []string
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		fmt.Fprint(w, `{"data_streams":[]}`)
+	})
+	reporter := NewReporter(client, "", time.Minute)
+	report, err := reporter.collect(context.Background())
+	require.NoError(t, err)
+	assert.Empty(t, report.DataStreams)
+
+	// No further requests are made when there are no data streams.
+	assert.Equal(t, []string{"/_data_stream/traces-apm*-*,metrics-apm*-*,logs-apm*-*"}, paths)
+}
+
+func TestReporterRunFailed(t *testing.T) {
+	client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	})
+	reporter := NewReporter(client, "default", 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- reporter.Run(ctx) }()
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "data_streams", reporter.CollectMonitoring)
+	assert.Eventually(t, func() bool {
+		snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+		return snapshot.Ints["data_streams.failed"] >= 2
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+	assert.Zero(t, reporter.Report().Collected)
+}
@@ -23,14 +23,25 @@ import (
 	jsoniter "github.com/json-iterator/go"
 )
 
-//TODO(simitt): look into config options for performance tuning
-// ConfigCompatibleWithStandardLibrary + UseNumber
-var json = jsoniter.Config{
-	EscapeHTML:             true,
-	SortMapKeys:            true,
-	ValidateJsonRawMessage: true,
-	UseNumber:              true,
-}.Froze()
+var json = NewJSONAPI()
+
+// NewJSONAPI returns a jsoniter.API configured like the one used by
+// NewJSONDecoder and NewNDJSONStreamDecoder, with the given extensions
+// registered.
+func NewJSONAPI(extensions ...jsoniter.Extension) jsoniter.API {
+	//TODO(simitt): look into config options for performance tuning
+	// ConfigCompatibleWithStandardLibrary + UseNumber
+	api := jsoniter.Config{
+		EscapeHTML:             true,
+		SortMapKeys:            true,
+		ValidateJsonRawMessage: true,
+		UseNumber:              true,
+	}.Froze()
+	for _, extension := range extensions {
+		api.RegisterExtension(extension)
+	}
+	return api
+}
 
 type Decoder interface {
 	Decode(v interface{}) error
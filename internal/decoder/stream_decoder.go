@@ -28,7 +28,13 @@ import (
 // NewNDJSONStreamDecoder returns a new NDJSONStreamDecoder which decodes
 // ND-JSON lines from r, with a maximum line length of maxLineLength.
 func NewNDJSONStreamDecoder(r io.Reader, maxLineLength int) *NDJSONStreamDecoder {
-	var dec NDJSONStreamDecoder
+	return NewNDJSONStreamDecoderAPI(r, maxLineLength, json)
+}
+
+// NewNDJSONStreamDecoderAPI returns a new NDJSONStreamDecoder like
+// NewNDJSONStreamDecoder, decoding lines using the given jsoniter.API.
+func NewNDJSONStreamDecoderAPI(r io.Reader, maxLineLength int, api jsoniter.API) *NDJSONStreamDecoder {
+	dec := NDJSONStreamDecoder{api: api}
 	dec.bufioReader = bufio.NewReaderSize(r, maxLineLength)
 	dec.lineReader = NewLineReader(dec.bufioReader, maxLineLength)
 	dec.resetDecoder()
@@ -45,6 +51,7 @@ type NDJSONStreamDecoder struct {
 	latestLine       []byte
 	latestLineReader bytes.Reader
 	decoder          *jsoniter.Decoder
+	api              jsoniter.API
 }
 
 // Reset sets sr's underlying io.Reader to r, and resets any reading/decoding state.
@@ -57,7 +64,7 @@ func (dec *NDJSONStreamDecoder) Reset(r io.Reader) {
 }
 
 func (dec *NDJSONStreamDecoder) resetDecoder() {
-	dec.decoder = json.NewDecoder(&dec.latestLineReader)
+	dec.decoder = dec.api.NewDecoder(&dec.latestLineReader)
 }
 
 // Decode decodes the next line into v.
@@ -87,6 +94,12 @@ func (dec *NDJSONStreamDecoder) ReadAhead() ([]byte, error) {
 	return line, readErr
 }
 
+// Skip discards the line buffered by ReadAhead without decoding it,
+// such that a subsequent call to Decode decodes the following line.
+func (dec *NDJSONStreamDecoder) Skip() {
+	dec.resetLatestLineReader()
+}
+
 func (dec *NDJSONStreamDecoder) resetLatestLineReader() {
 	dec.latestLineReader.Reset(nil)
 	dec.latestError = nil
@@ -112,3 +112,22 @@ func TestNDStreamReaderReadAhead(t *testing.T) {
 		}
 	}
 }
+
+func TestNDStreamReaderSkip(t *testing.T) {
+	lines := []string{
+		`{"key":"value1"}`,
+		`{"a": "b"}`,
+	}
+	buf := bytes.NewBufferString(strings.Join(lines, "\n"))
+	n := NewNDJSONStreamDecoder(buf, 100)
+
+	// Skip discards the line buffered by ReadAhead
+	b, err := n.ReadAhead()
+	require.NoError(t, err)
+	assert.Equal(t, lines[0], string(b))
+	n.Skip()
+
+	var out map[string]interface{}
+	assert.Equal(t, io.EOF, n.Decode(&out))
+	assert.Equal(t, map[string]interface{}{"a": "b"}, out)
+}
@@ -73,6 +73,7 @@ type BulkIndexerItem struct {
 	Index           string
 	Action          string
 	DocumentID      string
+	Pipeline        string
 	Body            io.ReadSeeker
 	RetryOnConflict *int
 
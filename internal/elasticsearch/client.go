@@ -24,9 +24,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"time"
 
 	"go.elastic.co/apm/module/apmelasticsearch/v2"
 
+	"github.com/elastic/apm-server/internal/outbound"
 	"github.com/elastic/apm-server/internal/version"
 	esv8 "github.com/elastic/go-elasticsearch/v8"
 	esapiv8 "github.com/elastic/go-elasticsearch/v8/esapi"
@@ -134,13 +136,19 @@ func NewClientParams(args ClientParams) (Client, error) {
 		apikey = base64.StdEncoding.EncodeToString([]byte(args.Config.APIKey))
 	}
 
+	metrics := outbound.ClientMetrics(outbound.Elasticsearch)
+	backoff := exponentialBackoff(args.Config.Backoff)
 	return newV8Client(
 		apikey, args.Config.Username, args.Config.Password,
 		addrs,
 		headers,
-		apmelasticsearch.WrapRoundTripper(transport),
+		apmelasticsearch.WrapRoundTripper(metrics.WrapRoundTripper(transport)),
 		args.Config.MaxRetries,
-		exponentialBackoff(args.Config.Backoff),
+		func(attempts int) time.Duration {
+			// The backoff function is called before each retry.
+			metrics.RecordRetry()
+			return backoff(attempts)
+		},
 		args.RetryOnError,
 	)
 }
@@ -0,0 +1,257 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package fileoutput provides a modelindexer.Output which writes events
+// to a file or the console as NDJSON, rather than indexing them into
+// Elasticsearch.
+package fileoutput
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+var newline = []byte("\n")
+
+// Config holds configuration for Output.
+type Config struct {
+	// Writer holds the io.Writer to which events are written.
+	//
+	// Each event is written with a single call to Write, so a rotating
+	// file writer never splits an event across files. Calls to Write
+	// are serialized by Output.
+	Writer io.Writer
+}
+
+// Output is a modelindexer.Output which writes each event as a bulk
+// request action line followed by its ECS JSON document, exactly as the
+// event would be sent to Elasticsearch. The output can be inspected to
+// validate event content, or replayed later with the bulk API.
+type Output struct {
+	mu     sync.Mutex
+	writer io.Writer
+
+	written int64
+	failed  int64
+}
+
+// Stats holds Output statistics.
+type Stats struct {
+	// Written holds the number of events successfully written.
+	Written int64
+
+	// Failed holds the number of events which could not be written.
+	Failed int64
+}
+
+// New returns a new Output with the given configuration.
+func New(cfg Config) (*Output, error) {
+	if cfg.Writer == nil {
+		return nil, errors.New("fileoutput: Writer is required")
+	}
+	return &Output{writer: cfg.Writer}, nil
+}
+
+// Stats returns the most recent statistics about the events written.
+func (o *Output) Stats() Stats {
+	return Stats{
+		Written: atomic.LoadInt64(&o.written),
+		Failed:  atomic.LoadInt64(&o.failed),
+	}
+}
+
+// CollectMonitoring may be called to collect monitoring metrics from the
+// output. It is intended to be used with libbeat/monitoring.NewFunc.
+//
+// The metrics should be added to the "apm-server.output.file" or
+// "apm-server.output.console" registry.
+func (o *Output) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	stats := o.Stats()
+	monitoring.ReportNamespace(V, "events", func() {
+		monitoring.ReportInt(V, "written", stats.Written)
+		monitoring.ReportInt(V, "failed", stats.Failed)
+	})
+}
+
+func (o *Output) write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.writer.Write(p)
+}
+
+// NewBuffer returns a new, empty buffer of events.
+func (o *Output) NewBuffer() modelindexer.OutputBuffer {
+	return &buffer{output: o}
+}
+
+// buffer holds encoded events to be written in a single call to Deliver.
+type buffer struct {
+	// firstAdded holds the time at which the first buffered event was
+	// added, in nanoseconds since the Unix epoch, or zero if there are
+	// no buffered events. It is accessed atomically.
+	firstAdded int64
+
+	output *Output
+	buf    bytes.Buffer
+	jsonw  fastjson.Writer
+	// offsets holds the end offset in buf of each buffered event.
+	offsets []int
+	items   []elasticsearch.BulkIndexerItem
+}
+
+func (b *buffer) Add(item elasticsearch.BulkIndexerItem) error {
+	start := b.buf.Len()
+	encodeMeta(&b.jsonw, item)
+	b.buf.Write(b.jsonw.Bytes())
+	b.jsonw.Reset()
+	if _, err := b.buf.ReadFrom(item.Body); err != nil {
+		b.buf.Truncate(start)
+		return err
+	}
+	b.buf.Write(newline)
+	// The body has been written, and must not be retained.
+	item.Body = nil
+	if len(b.items) == 0 {
+		atomic.StoreInt64(&b.firstAdded, time.Now().UnixNano())
+	}
+	b.offsets = append(b.offsets, b.buf.Len())
+	b.items = append(b.items, item)
+	return nil
+}
+
+func (b *buffer) ItemLen(item elasticsearch.BulkIndexerItem) (int, error) {
+	offset, err := item.Body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := item.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := item.Body.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	encodeMeta(&b.jsonw, item)
+	metaLen := b.jsonw.Size()
+	b.jsonw.Reset()
+	return metaLen + int(end-offset) + len(newline), nil
+}
+
+func (b *buffer) Items() int {
+	return len(b.items)
+}
+
+func (b *buffer) Len() int {
+	return b.buf.Len()
+}
+
+func (b *buffer) UncompressedLen() int {
+	return b.buf.Len()
+}
+
+func (b *buffer) FirstAdded() time.Time {
+	firstAdded := atomic.LoadInt64(&b.firstAdded)
+	if firstAdded == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, firstAdded)
+}
+
+// Deliver writes the buffered events, one at a time, and notifies the
+// callbacks of the corresponding items. Events which fail to be written
+// are reported as failed without returning an error.
+func (b *buffer) Deliver(ctx context.Context) (modelindexer.OutputResult, error) {
+	var result modelindexer.OutputResult
+	data := b.buf.Bytes()
+	var start int
+	for i, item := range b.items {
+		end := b.offsets[i]
+		n, err := b.output.write(data[start:end])
+		result.BytesFlushed += int64(n)
+		start = end
+		if err != nil {
+			result.Failed++
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, elasticsearch.BulkIndexerResponseItem{}, err)
+			}
+			continue
+		}
+		result.Indexed++
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, item, elasticsearch.BulkIndexerResponseItem{})
+		}
+	}
+	atomic.AddInt64(&b.output.written, result.Indexed)
+	atomic.AddInt64(&b.output.failed, result.Failed)
+	return result, nil
+}
+
+func (b *buffer) Reset() {
+	for i := range b.items {
+		b.items[i] = elasticsearch.BulkIndexerItem{}
+	}
+	b.items = b.items[:0]
+	b.offsets = b.offsets[:0]
+	b.buf.Reset()
+	atomic.StoreInt64(&b.firstAdded, 0)
+}
+
+// encodeMeta encodes the bulk request action line for item, including
+// the trailing newline.
+func encodeMeta(w *fastjson.Writer, item elasticsearch.BulkIndexerItem) {
+	action := item.Action
+	if action == "" {
+		action = "create"
+	}
+	w.RawByte('{')
+	w.String(action)
+	w.RawString(":{")
+	if item.DocumentID != "" {
+		w.RawString(`"_id":`)
+		w.String(item.DocumentID)
+	}
+	if item.Index != "" {
+		if item.DocumentID != "" {
+			w.RawByte(',')
+		}
+		w.RawString(`"_index":`)
+		w.String(item.Index)
+	}
+	if item.Pipeline != "" {
+		if item.DocumentID != "" || item.Index != "" {
+			w.RawByte(',')
+		}
+		w.RawString(`"pipeline":`)
+		w.String(item.Pipeline)
+	}
+	w.RawString("}}\n")
+}
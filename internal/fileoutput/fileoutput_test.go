@@ -0,0 +1,131 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package fileoutput_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/fileoutput"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+func TestOutput(t *testing.T) {
+	var buf bytes.Buffer
+	output, err := fileoutput.New(fileoutput.Config{Writer: &buf})
+	require.NoError(t, err)
+	indexer := newIndexer(t, output)
+
+	batch := model.Batch{
+		newEvent("traces", "apm"),
+		newEvent("logs", "apm.error"),
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	written := int64(buf.Len())
+	var lines []string
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, lines, 4)
+	assert.Equal(t, `{"create":{"_index":"traces-apm-default"}}`, lines[0])
+	assert.Equal(t, "traces", gjson.Get(lines[1], `data_stream\.type`).String())
+	assert.Equal(t, `{"create":{"_index":"logs-apm.error-default"}}`, lines[2])
+	assert.Equal(t, "logs", gjson.Get(lines[3], `data_stream\.type`).String())
+
+	assert.Equal(t, fileoutput.Stats{Written: 2}, output.Stats())
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), stats.Indexed)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.Equal(t, written, stats.BytesTotal)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "file", output.CollectMonitoring)
+	assert.Equal(t, map[string]int64{
+		"file.events.written": 2,
+		"file.events.failed":  0,
+	}, monitoring.CollectFlatSnapshot(registry, monitoring.Full, false).Ints)
+}
+
+func TestOutputFailed(t *testing.T) {
+	output, err := fileoutput.New(fileoutput.Config{Writer: &failingWriter{failures: 1}})
+	require.NoError(t, err)
+	indexer := newIndexer(t, output)
+
+	batch := model.Batch{
+		newEvent("traces", "apm"),
+		newEvent("logs", "apm.error"),
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+	assert.Equal(t, fileoutput.Stats{Written: 1, Failed: 1}, output.Stats())
+	assert.Equal(t, int64(1), indexer.Stats().Failed)
+}
+
+func TestNewMissingWriter(t *testing.T) {
+	_, err := fileoutput.New(fileoutput.Config{})
+	assert.EqualError(t, err, "fileoutput: Writer is required")
+}
+
+type failingWriter struct {
+	failures int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.failures > 0 {
+		w.failures--
+		return 0, errors.New("disk full")
+	}
+	return len(p), nil
+}
+
+func newIndexer(t testing.TB, output *fileoutput.Output) *modelindexer.Indexer {
+	indexer, err := modelindexer.New(nil, modelindexer.Config{
+		FlushInterval: time.Minute,
+		MaxRequests:   1,
+		Output:        output,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { indexer.Close(context.Background()) })
+	return indexer
+}
+
+func newEvent(dataStreamType, dataset string) model.APMEvent {
+	return model.APMEvent{
+		Timestamp: time.Now(),
+		DataStream: model.DataStream{
+			Type:      dataStreamType,
+			Dataset:   dataset,
+			Namespace: "default",
+		},
+	}
+}
@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// ClientConfig holds configuration for Client.
+type ClientConfig struct {
+	// ServiceName holds the gRPC service name of the forwarding
+	// service registered by other APM Server instances.
+	//
+	// If ServiceName is empty, DefaultServiceName will be used.
+	ServiceName string
+
+	// TLS holds optional TLS configuration for connecting to other
+	// APM Server instances, including a client certificate if they
+	// require one. If TLS is nil, connections are insecure.
+	TLS *tls.Config
+
+	// Authorization holds an optional Authorization header value,
+	// e.g. "Bearer <secret_token>" or "ApiKey <api_key>", for
+	// authenticating with other APM Server instances.
+	Authorization string
+}
+
+// Client forwards events to other APM Server instances, identified
+// by their gRPC addresses. Client is safe for concurrent use.
+type Client struct {
+	config ClientConfig
+	method string
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewClient returns a new Client with the given configuration.
+func NewClient(config ClientConfig) *Client {
+	if config.ServiceName == "" {
+		config.ServiceName = DefaultServiceName
+	}
+	return &Client{
+		config: config,
+		method: forwardEventsMethod(config.ServiceName),
+		conns:  make(map[string]*grpc.ClientConn),
+	}
+}
+
+// ForwardEvents forwards events to the APM Server instance at addr.
+func (c *Client) ForwardEvents(ctx context.Context, addr string, events model.Batch) error {
+	conn, err := c.conn(addr)
+	if err != nil {
+		return err
+	}
+	if c.config.Authorization != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, headers.Authorization, c.config.Authorization)
+	}
+	req, err := encodeForwardEventsRequest(&forwardEventsRequest{Events: events})
+	if err != nil {
+		return err
+	}
+	return conn.Invoke(ctx, c.method, req, &emptypb.Empty{})
+}
+
+func (c *Client) conn(addr string) (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	creds := insecure.NewCredentials()
+	if c.config.TLS != nil {
+		creds = credentials.NewTLS(c.config.TLS)
+	}
+	// grpc.Dial does not block; connections are established lazily.
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// Close closes all connections.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var result error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil {
+			result = multierror.Append(result, err)
+		}
+		delete(c.conns, addr)
+	}
+	return result
+}
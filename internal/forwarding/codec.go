@@ -0,0 +1,55 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/elastic/apm-server/internal/model/modelpb"
+)
+
+// Forwarded events are encoded using the canonical protobuf representation
+// defined by modelpb, and sent as the value of a google.protobuf.BytesValue.
+// Forwarded events are decoded directly into the model, rather than through
+// the intake or OTLP decoders.
+//
+// Requests are encoded with gRPC's default codec, rather than a codec of our
+// own, as gmux only routes requests with the default "application/grpc"
+// content type to the gRPC server when TLS is enabled.
+
+func encodeForwardEventsRequest(req *forwardEventsRequest) (*wrapperspb.BytesValue, error) {
+	data, err := modelpb.MarshalBatch(req.Events)
+	if err != nil {
+		return nil, err
+	}
+	return wrapperspb.Bytes(data), nil
+}
+
+func decodeForwardEventsRequest(dec func(interface{}) error) (*forwardEventsRequest, error) {
+	var body wrapperspb.BytesValue
+	if err := dec(&body); err != nil {
+		return nil, err
+	}
+	req := new(forwardEventsRequest)
+	if err := modelpb.UnmarshalBatch(body.Value, &req.Events); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to decode events: %s", err)
+	}
+	return req, nil
+}
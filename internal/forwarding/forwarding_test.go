@@ -0,0 +1,355 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	agentconfig "github.com/elastic/elastic-agent-libs/config"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/beatertest"
+	"github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/forwarding"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestForwardEvents(t *testing.T) {
+	received := make(chan model.Batch, 1)
+	addr, monitoringRegistry := newServer(t, config.AgentAuth{SecretToken: "abc123"}, forwarding.ServerConfig{
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			received <- *batch
+			return nil
+		}),
+	})
+
+	client := forwarding.NewClient(forwarding.ClientConfig{Authorization: "Bearer abc123"})
+	defer client.Close()
+
+	events := model.Batch{{
+		Timestamp: time.Unix(123, 0).UTC(),
+		Processor: model.TransactionProcessor,
+		Service:   model.Service{Name: "edge-service", Environment: "production"},
+		Labels:    model.Labels{"region": {Value: "eu-1"}},
+		Trace:     model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Transaction: &model.Transaction{
+			ID:      "0102030405060708",
+			Type:    "request",
+			Sampled: true,
+		},
+	}, {
+		Processor: model.SpanProcessor,
+		Trace:     model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Span:      &model.Span{ID: "0102030405060709", Name: "SELECT FROM table"},
+	}}
+	err := client.ForwardEvents(context.Background(), addr, events)
+	require.NoError(t, err)
+	assert.Equal(t, events, <-received)
+
+	snapshot := monitoring.CollectFlatSnapshot(monitoringRegistry, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["request.count"])
+	assert.Equal(t, int64(1), snapshot.Ints["response.valid.count"])
+}
+
+func TestForwardEventsServiceName(t *testing.T) {
+	const serviceName = "elastic.apm.sampling.v1.Forwarding"
+	received := make(chan model.Batch, 1)
+	addr, _ := newServer(t, config.AgentAuth{SecretToken: "abc123"}, forwarding.ServerConfig{
+		ServiceName: serviceName,
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			received <- *batch
+			return nil
+		}),
+	})
+
+	// Events are only forwarded to a service registered with the same name.
+	client := forwarding.NewClient(forwarding.ClientConfig{Authorization: "Bearer abc123"})
+	defer client.Close()
+	err := client.ForwardEvents(context.Background(), addr, model.Batch{{}})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	client = forwarding.NewClient(forwarding.ClientConfig{
+		ServiceName:   serviceName,
+		Authorization: "Bearer abc123",
+	})
+	defer client.Close()
+	err = client.ForwardEvents(context.Background(), addr, model.Batch{{}})
+	require.NoError(t, err)
+	assert.Len(t, <-received, 1)
+}
+
+func TestForwardEventsAuthorizeEvents(t *testing.T) {
+	var resources []auth.Resource
+	authorizer := authorizerFunc(func(ctx context.Context, action auth.Action, resource auth.Resource) error {
+		resources = append(resources, resource)
+		if resource.EventType == "error" {
+			return fmt.Errorf("%w: event type %q not permitted", auth.ErrUnauthorized, resource.EventType)
+		}
+		return nil
+	})
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(
+		ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		return handler(auth.ContextWithAuthorizer(ctx, authorizer), req)
+	}))
+	forwarding.RegisterServer(srv, forwarding.ServerConfig{
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			panic("unexpected call")
+		}),
+	})
+	addr := serve(t, srv)
+
+	client := forwarding.NewClient(forwarding.ClientConfig{Authorization: "Bearer abc123"})
+	defer client.Close()
+
+	// Forwarded events are authorized individually, so restrictions on
+	// agent names, service names, and event types apply to them.
+	err := client.ForwardEvents(context.Background(), addr, model.Batch{{
+		Processor: model.TransactionProcessor,
+		Agent:     model.Agent{Name: "go"},
+		Service:   model.Service{Name: "allowed"},
+	}, {
+		Processor: model.ErrorProcessor,
+		Agent:     model.Agent{Name: "go"},
+		Service:   model.Service{Name: "restricted"},
+	}})
+	assert.ErrorContains(t, err, `event type "error" not permitted`)
+	assert.Equal(t, []auth.Resource{
+		{AgentName: "go", ServiceName: "allowed", EventType: "transaction"},
+		{AgentName: "go", ServiceName: "restricted", EventType: "error"},
+	}, resources)
+}
+
+func TestForwardEventsUnauthenticated(t *testing.T) {
+	addr, _ := newServer(t, config.AgentAuth{SecretToken: "abc123"}, forwarding.ServerConfig{
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			panic("unexpected call")
+		}),
+	})
+
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		client := forwarding.NewClient(forwarding.ClientConfig{Authorization: authorization})
+		defer client.Close()
+		err := client.ForwardEvents(context.Background(), addr, model.Batch{{}})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err), authorization)
+	}
+}
+
+func TestForwardEventsAnonymous(t *testing.T) {
+	addr, _ := newServer(t, config.AgentAuth{
+		SecretToken: "abc123",
+		Anonymous:   config.AnonymousAgentAuth{Enabled: true},
+	}, forwarding.ServerConfig{
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			panic("unexpected call")
+		}),
+	})
+
+	client := forwarding.NewClient(forwarding.ClientConfig{})
+	defer client.Close()
+	err := client.ForwardEvents(context.Background(), addr, model.Batch{{}})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestForwardEventsRequireClientCertificate(t *testing.T) {
+	ca := newCertificateAuthority(t)
+	serverCert := ca.issue(t, "localhost")
+	// With the default "full" verification mode, client certificates
+	// are verified against the server name just like server certificates.
+	clientCert := ca.issue(t, "localhost")
+	untrustedCert := newCertificateAuthority(t).issue(t, "localhost")
+
+	newClient := func(t testing.TB, certs ...tls.Certificate) *forwarding.Client {
+		client := forwarding.NewClient(forwarding.ClientConfig{
+			TLS: &tls.Config{
+				RootCAs:      ca.pool,
+				ServerName:   "localhost",
+				Certificates: certs,
+			},
+			Authorization: "Bearer abc123",
+		})
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	events := model.Batch{{
+		Timestamp:   time.Unix(123, 0).UTC(),
+		Processor:   model.TransactionProcessor,
+		Service:     model.Service{Name: "edge-service"},
+		Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Transaction: &model.Transaction{ID: "0102030405060708", Type: "request", Sampled: true},
+	}}
+
+	for _, clientAuthentication := range []string{"optional", "required"} {
+		t.Run(clientAuthentication, func(t *testing.T) {
+			es := beatertest.NewElasticsearchServer(t)
+			srv := beatertest.NewServer(t, beatertest.WithElasticsearch(es), beatertest.WithConfig(
+				agentconfig.MustNewConfigFrom(map[string]interface{}{
+					"apm-server": map[string]interface{}{
+						"auth.secret_token":  "abc123",
+						"forwarding.enabled": true,
+						"ssl": map[string]interface{}{
+							"enabled":                 true,
+							"certificate":             writeCertificatePEM(t, serverCert.Certificate[0]),
+							"key":                     writePrivateKeyPEM(t, serverCert.PrivateKey),
+							"certificate_authorities": []string{writeCertificatePEM(t, ca.cert.Raw)},
+							"client_authentication":   clientAuthentication,
+						},
+					},
+				}),
+			))
+			serverURL, err := url.Parse(srv.URL)
+			require.NoError(t, err)
+			addr := serverURL.Host
+
+			// Clients without a certificate are rejected, even with a valid
+			// secret token: by the forwarding service if client certificates
+			// are optional, or the TLS handshake if they are required.
+			err = newClient(t).ForwardEvents(context.Background(), addr, events)
+			if clientAuthentication == "optional" {
+				assert.Equal(t, codes.Unauthenticated, status.Code(err))
+			} else {
+				assert.Error(t, err)
+			}
+
+			// Clients with a certificate which is not trusted by the server
+			// fail the TLS handshake.
+			err = newClient(t, untrustedCert).ForwardEvents(context.Background(), addr, events)
+			assert.Error(t, err)
+
+			err = newClient(t, clientCert).ForwardEvents(context.Background(), addr, events)
+			require.NoError(t, err)
+			docs := es.WaitDocuments(t, 1)
+			assert.Len(t, docs, 1)
+		})
+	}
+}
+
+func newServer(t testing.TB, authConfig config.AgentAuth, cfg forwarding.ServerConfig) (string, *monitoring.Registry) {
+	authenticator, err := auth.NewAuthenticator(authConfig)
+	require.NoError(t, err)
+
+	registry := monitoring.NewRegistry()
+	cfg.MonitoringMap = request.MonitoringMapForRegistry(registry, forwarding.MonitoringResultIDs)
+	srv := grpc.NewServer(grpc.ChainUnaryInterceptor(
+		interceptors.Metrics(nil),
+		interceptors.Auth(authenticator),
+	))
+	forwarding.RegisterServer(srv, cfg)
+	return serve(t, srv), registry
+}
+
+func serve(t testing.TB, srv *grpc.Server) string {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
+
+type authorizerFunc func(context.Context, auth.Action, auth.Resource) error
+
+func (f authorizerFunc) Authorize(ctx context.Context, action auth.Action, resource auth.Resource) error {
+	return f(ctx, action, resource)
+}
+
+type certificateAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newCertificateAuthority(t testing.TB) *certificateAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &certificateAuthority{cert: cert, key: key, pool: pool}
+}
+
+func (ca *certificateAuthority) issue(t testing.TB, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writeCertificatePEM(t testing.TB, der []byte) string {
+	return writePEM(t, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func writePrivateKeyPEM(t testing.TB, key crypto.PrivateKey) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return writePEM(t, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func writePEM(t testing.TB, block *pem.Block) string {
+	f, err := os.CreateTemp(t.TempDir(), "*.pem")
+	require.NoError(t, err)
+	defer f.Close()
+	require.NoError(t, pem.Encode(f, block))
+	return f.Name()
+}
@@ -0,0 +1,147 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// OutputConfig holds configuration for Output.
+type OutputConfig struct {
+	// Endpoint holds the gRPC address (host:port) of the upstream
+	// APM Server.
+	Endpoint string
+
+	// TLS holds optional TLS configuration for connecting to the
+	// upstream APM Server, including a client certificate if the
+	// server requires one. If TLS is nil, connections are insecure.
+	TLS *tls.Config
+
+	// Authorization holds the Authorization header value, e.g.
+	// "Bearer <secret_token>" or "ApiKey <api_key>", for authenticating
+	// with the upstream APM Server.
+	Authorization string
+
+	// Timeout holds the maximum amount of time to wait for a batch
+	// of events to be forwarded.
+	//
+	// If Timeout is zero, the default of 30 seconds will be used.
+	Timeout time.Duration
+}
+
+// Output is a model.BatchProcessor which forwards events to an upstream
+// APM Server with the forwarding service enabled.
+//
+// Events are forwarded synchronously by ProcessBatch, so backpressure from
+// the upstream APM Server is propagated to the clients sending the events.
+type Output struct {
+	client   *Client
+	endpoint string
+	timeout  time.Duration
+
+	requests  int64
+	forwarded int64
+	failed    int64
+}
+
+// NewOutput returns a new Output with the given configuration.
+func NewOutput(cfg OutputConfig) (*Output, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint must be specified")
+	}
+	if cfg.Authorization == "" {
+		return nil, errors.New("authorization must be specified")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &Output{
+		client: NewClient(ClientConfig{
+			TLS:           cfg.TLS,
+			Authorization: cfg.Authorization,
+		}),
+		endpoint: cfg.Endpoint,
+		timeout:  cfg.Timeout,
+	}, nil
+}
+
+// ProcessBatch forwards the events in batch, returning once the upstream
+// APM Server has processed them.
+func (o *Output) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	if len(*batch) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	err := o.client.ForwardEvents(ctx, o.endpoint, *batch)
+	atomic.AddInt64(&o.requests, 1)
+	if err != nil {
+		atomic.AddInt64(&o.failed, int64(len(*batch)))
+		return fmt.Errorf("failed to forward events: %w", err)
+	}
+	atomic.AddInt64(&o.forwarded, int64(len(*batch)))
+	return nil
+}
+
+// Close closes the connection to the upstream APM Server.
+func (o *Output) Close(context.Context) error {
+	return o.client.Close()
+}
+
+// Stats holds statistics about the events processed by Output.
+type Stats struct {
+	// Requests holds the number of forwarding requests made.
+	Requests int64
+
+	// Forwarded holds the number of events accepted by the upstream
+	// APM Server.
+	Forwarded int64
+
+	// Failed holds the number of events in forwarding requests that failed.
+	Failed int64
+}
+
+// Stats returns the current statistics.
+func (o *Output) Stats() Stats {
+	return Stats{
+		Requests:  atomic.LoadInt64(&o.requests),
+		Forwarded: atomic.LoadInt64(&o.forwarded),
+		Failed:    atomic.LoadInt64(&o.failed),
+	}
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+func (o *Output) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	stats := o.Stats()
+	monitoring.ReportInt(V, "requests", stats.Requests)
+	monitoring.ReportInt(V, "forwarded", stats.Forwarded)
+	monitoring.ReportInt(V, "failed", stats.Failed)
+}
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestOutputProcessBatch(t *testing.T) {
+	type request struct {
+		authorization []string
+		events        model.Batch
+	}
+	requests := make(chan request, 1)
+	addr := newTestServer(t, func(ctx context.Context, req *forwardEventsRequest) (*forwardEventsResponse, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		requests <- request{authorization: md.Get(headers.Authorization), events: req.Events}
+		return &forwardEventsResponse{}, nil
+	})
+
+	output, err := NewOutput(OutputConfig{Endpoint: addr, Authorization: "Bearer abc123"})
+	require.NoError(t, err)
+	defer output.Close(context.Background())
+
+	events := model.Batch{{
+		Timestamp:   time.Unix(123, 0).UTC(),
+		Processor:   model.TransactionProcessor,
+		Service:     model.Service{Name: "edge-service"},
+		Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Transaction: &model.Transaction{ID: "0102030405060708", Sampled: true},
+	}}
+	err = output.ProcessBatch(context.Background(), &events)
+	require.NoError(t, err)
+	req := <-requests
+	assert.Equal(t, []string{"Bearer abc123"}, req.authorization)
+	assert.Equal(t, events, req.events)
+
+	// Empty batches are not forwarded.
+	err = output.ProcessBatch(context.Background(), &model.Batch{})
+	require.NoError(t, err)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "forwarding", output.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"forwarding.requests":  1,
+		"forwarding.forwarded": 1,
+		"forwarding.failed":    0,
+	}, snapshot.Ints)
+}
+
+func TestOutputProcessBatchError(t *testing.T) {
+	addr := newTestServer(t, func(ctx context.Context, req *forwardEventsRequest) (*forwardEventsResponse, error) {
+		return nil, status.Error(codes.ResourceExhausted, "queue is full")
+	})
+
+	output, err := NewOutput(OutputConfig{Endpoint: addr, Authorization: "Bearer abc123"})
+	require.NoError(t, err)
+	defer output.Close(context.Background())
+
+	err = output.ProcessBatch(context.Background(), &model.Batch{{}, {}})
+	assert.ErrorContains(t, err, "failed to forward events")
+	assert.Equal(t, codes.ResourceExhausted, status.Code(errors.Unwrap(err)))
+	assert.Equal(t, Stats{Requests: 1, Failed: 2}, output.Stats())
+}
+
+func TestNewOutputInvalidConfig(t *testing.T) {
+	_, err := NewOutput(OutputConfig{Authorization: "Bearer abc123"})
+	assert.EqualError(t, err, "endpoint must be specified")
+
+	_, err = NewOutput(OutputConfig{Endpoint: "localhost:8200"})
+	assert.EqualError(t, err, "authorization must be specified")
+}
+
+type forwardEventsFunc func(context.Context, *forwardEventsRequest) (*forwardEventsResponse, error)
+
+func (f forwardEventsFunc) ForwardEvents(ctx context.Context, req *forwardEventsRequest) (*forwardEventsResponse, error) {
+	return f(ctx, req)
+}
+
+func newTestServer(t testing.TB, f forwardEventsFunc) string {
+	srv := grpc.NewServer()
+	srv.RegisterService(newServiceDesc(DefaultServiceName), f)
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return lis.Addr().String()
+}
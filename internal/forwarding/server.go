@@ -0,0 +1,126 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package forwarding
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/beater/auth"
+	"github.com/elastic/apm-server/internal/beater/interceptors"
+	"github.com/elastic/apm-server/internal/beater/request"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// MonitoringResultIDs holds the request.ResultIDs for which request
+// metrics should be recorded for the forwarding service.
+var MonitoringResultIDs = append(request.DefaultResultIDs,
+	request.IDResponseErrorsRateLimit,
+	request.IDResponseErrorsTimeout,
+	request.IDResponseErrorsUnauthorized,
+)
+
+// ServerConfig holds configuration for RegisterServer.
+type ServerConfig struct {
+	// ServiceName holds the gRPC service name to register.
+	//
+	// If ServiceName is empty, DefaultServiceName will be used.
+	ServiceName string
+
+	// Processor holds the model.BatchProcessor for processing
+	// forwarded events.
+	Processor model.BatchProcessor
+
+	// MonitoringMap holds the request metrics for the service,
+	// as returned by request.MonitoringMapForRegistry with
+	// MonitoringResultIDs.
+	MonitoringMap map[request.ResultID]*monitoring.Int
+
+	// RequireClientCertificate controls whether clients must present
+	// a client certificate verified by the server's TLS configuration,
+	// in addition to authenticating with a secret token or API Key.
+	RequireClientCertificate bool
+}
+
+// RegisterServer registers a forwarding service with srv.
+func RegisterServer(srv *grpc.Server, cfg ServerConfig) {
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = DefaultServiceName
+	}
+	srv.RegisterService(newServiceDesc(cfg.ServiceName), &server{config: cfg})
+}
+
+type server struct {
+	config ServerConfig
+}
+
+// RequestMetrics returns the request metrics registry for the forwarding service.
+func (s *server) RequestMetrics(fullMethodName string) map[request.ResultID]*monitoring.Int {
+	return s.config.MonitoringMap
+}
+
+// ForwardEvents processes events forwarded by another APM Server instance.
+//
+// Anonymous clients may not forward events, as forwarded events are not
+// subject to the restrictions applied to anonymous (e.g. RUM) agents.
+// Each forwarded event is authorized for its agent name, service name,
+// and event type, as events sent directly by agents are.
+func (s *server) ForwardEvents(ctx context.Context, req *forwardEventsRequest) (*forwardEventsResponse, error) {
+	if s.config.RequireClientCertificate && !hasVerifiedClientCertificate(ctx) {
+		return nil, status.Error(codes.Unauthenticated, "a verified client certificate is required")
+	}
+	if details, ok := interceptors.AuthenticationDetailsFromContext(ctx); ok && details.Method == auth.MethodAnonymous {
+		return nil, auth.ErrUnauthorized
+	}
+	for _, event := range req.Events {
+		if err := auth.Authorize(ctx, auth.ActionEventIngest, auth.Resource{
+			AgentName:   event.Agent.Name,
+			ServiceName: event.Service.Name,
+			EventType:   event.Processor.Event,
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.config.Processor.ProcessBatch(ctx, &req.Events); err != nil {
+		return nil, err
+	}
+	return &forwardEventsResponse{}, nil
+}
+
+// hasVerifiedClientCertificate reports whether the client connected over
+// TLS and presented a certificate which the server verified.
+//
+// The TLS connection state is available from the request's peer when the
+// gRPC server's transport credentials provide credentials.TLSInfo. APM Server
+// terminates TLS in its net/http server, and exposes the connection state to
+// gRPC services using transport credentials which are aware of this.
+func hasVerifiedClientCertificate(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	return ok && len(tlsInfo.State.VerifiedChains) > 0
+}
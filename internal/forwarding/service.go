@@ -0,0 +1,93 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package forwarding provides a gRPC service for accepting events forwarded
+// by other APM Server instances, and clients for forwarding events to it.
+//
+// The service is used for hierarchical deployments, where edge APM Servers
+// without access to Elasticsearch forward already decoded and processed
+// events to an upstream APM Server, and for forwarding trace events between
+// the members of a tail-based sampling cluster. Each use registers the
+// service under its own name, so both may be served by the same gRPC server.
+package forwarding
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// DefaultServiceName holds the gRPC service name for forwarding events
+// between APM Servers, used unless another service name is configured.
+const DefaultServiceName = "elastic.apm.forwarding.v1.Forwarding"
+
+type forwardEventsRequest struct {
+	Events model.Batch
+}
+
+type forwardEventsResponse struct{}
+
+// forwardingServer is the interface implemented by the forwarding service.
+type forwardingServer interface {
+	ForwardEvents(context.Context, *forwardEventsRequest) (*forwardEventsResponse, error)
+}
+
+// forwardEventsMethod returns the full gRPC method name for forwarding
+// events with the named service.
+func forwardEventsMethod(serviceName string) string {
+	return "/" + serviceName + "/ForwardEvents"
+}
+
+// newServiceDesc returns a grpc.ServiceDesc for the forwarding service
+// with the given name.
+func newServiceDesc(serviceName string) *grpc.ServiceDesc {
+	fullMethod := forwardEventsMethod(serviceName)
+	return &grpc.ServiceDesc{
+		ServiceName: serviceName,
+		HandlerType: (*forwardingServer)(nil),
+		Methods: []grpc.MethodDesc{{
+			MethodName: "ForwardEvents",
+			Handler: func(
+				srv interface{},
+				ctx context.Context,
+				dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor,
+			) (interface{}, error) {
+				in, err := decodeForwardEventsRequest(dec)
+				if err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					_, err := srv.(forwardingServer).ForwardEvents(ctx, req.(*forwardEventsRequest))
+					if err != nil {
+						return nil, err
+					}
+					return &emptypb.Empty{}, nil
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}
+				return interceptor(ctx, in, info, handler)
+			},
+		}},
+		Streams: []grpc.StreamDesc{},
+	}
+}
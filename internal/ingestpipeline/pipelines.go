@@ -0,0 +1,79 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ingestpipeline
+
+// Version is the version of the ingest pipelines defined in this package.
+//
+// Version must be incremented whenever a pipeline definition is changed,
+// so that Setup will update pipelines previously installed in Elasticsearch.
+const Version = 1
+
+// Pipeline holds an ingest pipeline definition.
+type Pipeline struct {
+	ID          string
+	Description string
+	Processors  []map[string]interface{}
+}
+
+// UserAgentProcessors holds ingest processors for parsing user_agent.original.
+var UserAgentProcessors = []map[string]interface{}{{
+	"user_agent": map[string]interface{}{
+		"field":          "user_agent.original",
+		"target_field":   "user_agent",
+		"ignore_missing": true,
+		"ignore_failure": true,
+	},
+}}
+
+// ClientGeoIPProcessors holds ingest processors for enriching client.ip
+// with geographical information in client.geo.
+var ClientGeoIPProcessors = []map[string]interface{}{{
+	"geoip": map[string]interface{}{
+		"field":          "client.ip",
+		"target_field":   "client.geo",
+		"ignore_missing": true,
+		"database_file":  "GeoLite2-City.mmdb",
+		"on_failure": []map[string]interface{}{{
+			"remove": map[string]interface{}{
+				"field":          "client.ip",
+				"ignore_missing": true,
+				"ignore_failure": true,
+			},
+		}},
+	},
+}}
+
+// Pipelines holds the ingest pipelines installed by Setup. The final "apm"
+// pipeline invokes the others, and is intended to be set as the default
+// pipeline for APM data streams when the integration package is not installed.
+var Pipelines = []Pipeline{{
+	ID:          "apm@user_agent",
+	Description: "Parses user_agent.original into user_agent fields",
+	Processors:  UserAgentProcessors,
+}, {
+	ID:          "apm@client_geoip",
+	Description: "Enriches client.ip with geographical information",
+	Processors:  ClientGeoIPProcessors,
+}, {
+	ID:          "apm",
+	Description: "Default ingest pipeline for APM events",
+	Processors: []map[string]interface{}{
+		{"pipeline": map[string]interface{}{"name": "apm@user_agent"}},
+		{"pipeline": map[string]interface{}{"name": "apm@client_geoip"}},
+	},
+}}
@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ingestpipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// Setup creates or updates the ingest pipelines in Pipelines.
+//
+// Pipelines that do not exist are created. Existing pipelines are updated
+// if their version is older than Version, or if overwrite is true; newer
+// pipelines are left alone, so that older servers do not downgrade them.
+func Setup(ctx context.Context, client elasticsearch.Client, overwrite bool, logger *logp.Logger) error {
+	for _, pipeline := range Pipelines {
+		version, exists, err := getPipelineVersion(ctx, client, pipeline.ID)
+		if err != nil {
+			return errors.Wrapf(err, "error getting ingest pipeline %q", pipeline.ID)
+		}
+		if exists && version >= Version && !overwrite {
+			logger.Debugf("ingest pipeline %q version %d is up to date", pipeline.ID, version)
+			continue
+		}
+		if err := putPipeline(ctx, client, pipeline); err != nil {
+			return errors.Wrapf(err, "error putting ingest pipeline %q", pipeline.ID)
+		}
+		if exists {
+			logger.Infof("updated ingest pipeline %q from version %d to %d", pipeline.ID, version, Version)
+		} else {
+			logger.Infof("created ingest pipeline %q version %d", pipeline.ID, Version)
+		}
+	}
+	return nil
+}
+
+// getPipelineVersion returns the version of the ingest pipeline with the
+// given ID, and whether or not it exists. Pipelines without a version are
+// reported as version zero.
+func getPipelineVersion(ctx context.Context, client elasticsearch.Client, id string) (int, bool, error) {
+	req := esapi.IngestGetPipelineRequest{PipelineID: id}
+	resp, err := req.Do(ctx, client)
+	if err != nil {
+		return 0, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, false, nil
+	} else if resp.IsError() {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, false, fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status(), bytes.TrimSpace(body))
+	}
+	var result map[string]struct {
+		Version int `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return 0, false, errors.Wrap(err, "error decoding ingest pipeline response")
+	}
+	pipeline, ok := result[id]
+	return pipeline.Version, ok, nil
+}
+
+func putPipeline(ctx context.Context, client elasticsearch.Client, pipeline Pipeline) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"description": pipeline.Description,
+		"version":     Version,
+		"processors":  pipeline.Processors,
+	})
+	if err != nil {
+		return err
+	}
+	req := esapi.IngestPutPipelineRequest{PipelineID: pipeline.ID, Body: bytes.NewReader(body)}
+	resp, err := req.Do(ctx, client)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.IsError() {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("unexpected HTTP status: %s (%s)", resp.Status(), bytes.TrimSpace(body))
+	}
+	return nil
+}
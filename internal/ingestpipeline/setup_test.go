@@ -0,0 +1,122 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package ingestpipeline
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func TestSetup(t *testing.T) {
+	for name, test := range map[string]struct {
+		existing  map[string]int
+		overwrite bool
+		expected  []string
+	}{
+		"none_exist": {
+			expected: []string{"apm@user_agent", "apm@client_geoip", "apm"},
+		},
+		"version_checks": {
+			existing: map[string]int{
+				"apm@user_agent":   Version - 1,
+				"apm@client_geoip": Version,
+				"apm":              Version + 1,
+			},
+			expected: []string{"apm@user_agent"},
+		},
+		"overwrite": {
+			existing: map[string]int{
+				"apm@user_agent":   Version,
+				"apm@client_geoip": Version,
+				"apm":              Version + 1,
+			},
+			overwrite: true,
+			expected:  []string{"apm@user_agent", "apm@client_geoip", "apm"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var put []string
+			client := newMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+				id := strings.TrimPrefix(r.URL.Path, "/_ingest/pipeline/")
+				switch r.Method {
+				case http.MethodGet:
+					version, ok := test.existing[id]
+					if !ok {
+						w.WriteHeader(http.StatusNotFound)
+						w.Write([]byte("{}"))
+						return
+					}
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						id: map[string]interface{}{"version": version},
+					})
+				case http.MethodPut:
+					var body struct {
+						Version    int                      `json:"version"`
+						Processors []map[string]interface{} `json:"processors"`
+					}
+					require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+					assert.Equal(t, Version, body.Version)
+					assert.NotEmpty(t, body.Processors)
+					put = append(put, id)
+					w.Write([]byte(`{"acknowledged":true}`))
+				}
+			})
+			err := Setup(context.Background(), client, test.overwrite, logp.NewLogger(""))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, put)
+		})
+	}
+}
+
+func TestSetupError(t *testing.T) {
+	client := newMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error":"forbidden"}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	err := Setup(context.Background(), client, false, logp.NewLogger(""))
+	assert.EqualError(t, err, `error putting ingest pipeline "apm@user_agent": unexpected HTTP status: 403 Forbidden ({"error":"forbidden"})`)
+}
+
+func newMockElasticsearchClient(t testing.TB, handler http.HandlerFunc) elasticsearch.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		handler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+	return client
+}
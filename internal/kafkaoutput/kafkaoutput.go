@@ -0,0 +1,261 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package kafkaoutput provides a modelindexer.Output which produces events
+// to Kafka topics as ECS JSON documents, rather than indexing them into
+// Elasticsearch.
+package kafkaoutput
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/tidwall/gjson"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+// Config holds configuration for Output.
+type Config struct {
+	// Producer holds the sarama.SyncProducer used for producing messages.
+	// Producer is closed by Output.Close.
+	Producer sarama.SyncProducer
+
+	// Topics holds the topic to which events are produced, keyed by
+	// `data_stream.type`: "traces", "logs", or "metrics".
+	Topics map[string]string
+
+	// Topic holds the topic to which events are produced if their data
+	// stream type is not in Topics.
+	//
+	// If Topic is empty, every data stream type must be in Topics.
+	Topic string
+}
+
+// Output is a modelindexer.Output which produces each event as a Kafka
+// message, with the event's ECS JSON document as the message value and
+// its trace ID, if any, as the message key. Keying by trace ID ensures
+// events of a trace are produced to the same partition when the producer
+// uses a hash partitioner.
+type Output struct {
+	producer sarama.SyncProducer
+	topics   map[string]string
+	topic    string
+
+	produced int64
+	failed   int64
+}
+
+// Stats holds Output statistics.
+type Stats struct {
+	// Produced holds the number of messages successfully produced.
+	Produced int64
+
+	// Failed holds the number of messages which could not be produced.
+	Failed int64
+}
+
+// New returns a new Output with the given configuration.
+func New(cfg Config) (*Output, error) {
+	if cfg.Producer == nil {
+		return nil, errors.New("kafkaoutput: Producer is required")
+	}
+	if cfg.Topic == "" {
+		for _, dataStreamType := range []string{"traces", "logs", "metrics"} {
+			if cfg.Topics[dataStreamType] == "" {
+				return nil, errors.New("kafkaoutput: no topic specified for data stream type " + dataStreamType)
+			}
+		}
+	}
+	return &Output{producer: cfg.Producer, topics: cfg.Topics, topic: cfg.Topic}, nil
+}
+
+// Close closes the Kafka producer.
+func (o *Output) Close() error {
+	return o.producer.Close()
+}
+
+// Stats returns the most recent statistics about the messages produced.
+func (o *Output) Stats() Stats {
+	return Stats{
+		Produced: atomic.LoadInt64(&o.produced),
+		Failed:   atomic.LoadInt64(&o.failed),
+	}
+}
+
+// CollectMonitoring may be called to collect monitoring metrics from the
+// output. It is intended to be used with libbeat/monitoring.NewFunc.
+//
+// The metrics should be added to the "apm-server.output.kafka" registry.
+func (o *Output) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	stats := o.Stats()
+	monitoring.ReportNamespace(V, "messages", func() {
+		monitoring.ReportInt(V, "produced", stats.Produced)
+		monitoring.ReportInt(V, "failed", stats.Failed)
+	})
+}
+
+// NewBuffer returns a new, empty buffer of messages.
+func (o *Output) NewBuffer() modelindexer.OutputBuffer {
+	return &buffer{output: o}
+}
+
+// topicFor returns the topic for events indexed into the given data stream.
+func (o *Output) topicFor(index string) string {
+	dataStreamType := index
+	if i := strings.IndexByte(index, '-'); i >= 0 {
+		dataStreamType = index[:i]
+	}
+	if topic, ok := o.topics[dataStreamType]; ok {
+		return topic
+	}
+	return o.topic
+}
+
+// buffer holds messages to be produced in a single call to SendMessages.
+type buffer struct {
+	// firstAdded holds the time at which the first buffered message was
+	// added, in nanoseconds since the Unix epoch, or zero if there are
+	// no buffered messages. It is accessed atomically.
+	firstAdded int64
+
+	output   *Output
+	messages []*sarama.ProducerMessage
+	items    []elasticsearch.BulkIndexerItem
+	size     int
+}
+
+func (b *buffer) Add(item elasticsearch.BulkIndexerItem) error {
+	doc, err := io.ReadAll(item.Body)
+	if err != nil {
+		return err
+	}
+	msg := &sarama.ProducerMessage{
+		Topic:    b.output.topicFor(item.Index),
+		Value:    sarama.ByteEncoder(doc),
+		Metadata: len(b.items),
+	}
+	if traceID := gjson.GetBytes(doc, "trace.id").String(); traceID != "" {
+		msg.Key = sarama.StringEncoder(traceID)
+	}
+	// The body has been read, and must not be retained.
+	item.Body = nil
+	if len(b.messages) == 0 {
+		atomic.StoreInt64(&b.firstAdded, time.Now().UnixNano())
+	}
+	b.messages = append(b.messages, msg)
+	b.items = append(b.items, item)
+	b.size += len(doc)
+	return nil
+}
+
+func (b *buffer) ItemLen(item elasticsearch.BulkIndexerItem) (int, error) {
+	offset, err := item.Body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := item.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := item.Body.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return int(end - offset), nil
+}
+
+func (b *buffer) Items() int {
+	return len(b.messages)
+}
+
+func (b *buffer) Len() int {
+	return b.size
+}
+
+func (b *buffer) UncompressedLen() int {
+	return b.size
+}
+
+func (b *buffer) FirstAdded() time.Time {
+	firstAdded := atomic.LoadInt64(&b.firstAdded)
+	if firstAdded == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, firstAdded)
+}
+
+// Deliver produces the buffered messages, and notifies the callbacks of
+// the corresponding items. If only some messages fail to be produced,
+// Deliver reports them as failed without returning an error.
+func (b *buffer) Deliver(ctx context.Context) (modelindexer.OutputResult, error) {
+	result := modelindexer.OutputResult{BytesFlushed: int64(b.size)}
+	failed := make([]error, len(b.items))
+	err := b.output.producer.SendMessages(b.messages)
+	var producerErrors sarama.ProducerErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &producerErrors):
+		for _, perr := range producerErrors {
+			if i, ok := perr.Msg.Metadata.(int); ok && i < len(failed) {
+				failed[i] = perr.Err
+			}
+		}
+		err = nil
+	default:
+		for i := range failed {
+			failed[i] = err
+		}
+	}
+	for i, item := range b.items {
+		if failed[i] != nil {
+			result.Failed++
+			if item.OnFailure != nil {
+				item.OnFailure(ctx, item, elasticsearch.BulkIndexerResponseItem{}, failed[i])
+			}
+			continue
+		}
+		result.Indexed++
+		if item.OnSuccess != nil {
+			item.OnSuccess(ctx, item, elasticsearch.BulkIndexerResponseItem{})
+		}
+	}
+	atomic.AddInt64(&b.output.produced, result.Indexed)
+	atomic.AddInt64(&b.output.failed, result.Failed)
+	return result, err
+}
+
+func (b *buffer) Reset() {
+	for i := range b.messages {
+		b.messages[i] = nil
+		b.items[i] = elasticsearch.BulkIndexerItem{}
+	}
+	b.messages = b.messages[:0]
+	b.items = b.items[:0]
+	b.size = 0
+	atomic.StoreInt64(&b.firstAdded, 0)
+}
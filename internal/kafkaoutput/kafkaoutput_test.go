@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package kafkaoutput_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/kafkaoutput"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+func TestOutput(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	published := make(chan *sarama.ProducerMessage, 3)
+	for i := 0; i < cap(published); i++ {
+		producer.ExpectSendMessageWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			published <- msg
+			return nil
+		})
+	}
+	output, err := kafkaoutput.New(kafkaoutput.Config{
+		Producer: producer,
+		Topics:   map[string]string{"traces": "apm-traces"},
+		Topic:    "apm-events",
+	})
+	require.NoError(t, err)
+	indexer := newIndexer(t, output)
+
+	batch := model.Batch{
+		newEvent("traces", "apm", &model.Trace{ID: "trace_1"}),
+		newEvent("traces", "apm", &model.Trace{ID: "trace_2"}),
+		newEvent("logs", "apm.error", nil),
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	for _, expected := range []struct {
+		topic, key, dataStreamType string
+	}{
+		{topic: "apm-traces", key: "trace_1", dataStreamType: "traces"},
+		{topic: "apm-traces", key: "trace_2", dataStreamType: "traces"},
+		{topic: "apm-events", dataStreamType: "logs"},
+	} {
+		msg := <-published
+		assert.Equal(t, expected.topic, msg.Topic)
+		if expected.key != "" {
+			assert.Equal(t, sarama.StringEncoder(expected.key), msg.Key)
+		} else {
+			assert.Nil(t, msg.Key)
+		}
+		value, err := msg.Value.Encode()
+		require.NoError(t, err)
+		assert.Equal(t, expected.dataStreamType, gjson.GetBytes(value, `data_stream\.type`).String())
+	}
+
+	assert.Equal(t, kafkaoutput.Stats{Produced: 3}, output.Stats())
+	stats := indexer.Stats()
+	assert.Equal(t, int64(3), stats.Indexed)
+	assert.Equal(t, int64(0), stats.Failed)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "kafka", output.CollectMonitoring)
+	assert.Equal(t, map[string]int64{
+		"kafka.messages.produced": 3,
+		"kafka.messages.failed":   0,
+	}, monitoring.CollectFlatSnapshot(registry, monitoring.Full, false).Ints)
+}
+
+func TestOutputFailed(t *testing.T) {
+	producer := mocks.NewSyncProducer(t, nil)
+	producer.ExpectSendMessageAndFail(errors.New("broker unavailable"))
+	output, err := kafkaoutput.New(kafkaoutput.Config{Producer: producer, Topic: "apm-events"})
+	require.NoError(t, err)
+	indexer := newIndexer(t, output)
+
+	batch := model.Batch{newEvent("logs", "apm.error", nil)}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	assert.EqualError(t, indexer.Close(context.Background()), "broker unavailable")
+	assert.Equal(t, kafkaoutput.Stats{Failed: 1}, output.Stats())
+	assert.Equal(t, int64(1), indexer.Stats().Failed)
+}
+
+func TestNewMissingTopic(t *testing.T) {
+	_, err := kafkaoutput.New(kafkaoutput.Config{
+		Producer: mocks.NewSyncProducer(t, nil),
+		Topics:   map[string]string{"traces": "apm-traces", "logs": "apm-logs"},
+	})
+	assert.EqualError(t, err, "kafkaoutput: no topic specified for data stream type metrics")
+}
+
+func newIndexer(t testing.TB, output *kafkaoutput.Output) *modelindexer.Indexer {
+	indexer, err := modelindexer.New(nil, modelindexer.Config{
+		FlushInterval: time.Minute,
+		MaxRequests:   1,
+		Output:        output,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { indexer.Close(context.Background()) })
+	return indexer
+}
+
+func newEvent(dataStreamType, dataset string, trace *model.Trace) model.APMEvent {
+	event := model.APMEvent{
+		Timestamp: time.Now(),
+		DataStream: model.DataStream{
+			Type:      dataStreamType,
+			Dataset:   dataset,
+			Namespace: "default",
+		},
+	}
+	if trace != nil {
+		event.Trace = *trace
+	}
+	return event
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/elastic/elastic-agent-libs/kibana"
 
+	"github.com/elastic/apm-server/internal/outbound"
 	"github.com/elastic/apm-server/internal/version"
 )
 
@@ -50,6 +51,7 @@ func NewClient(cfg ClientConfig) (*Client, error) {
 	if err != nil {
 		return nil, err
 	}
+	client.HTTP.Transport = outbound.WrapRoundTripper(outbound.Kibana, client.HTTP.Transport)
 	client.HTTP = apmhttp.WrapClient(client.HTTP)
 	return &Client{client: client}, nil
 }
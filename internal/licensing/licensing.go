@@ -0,0 +1,185 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package licensing provides detection of the Elasticsearch license level,
+// enabling and disabling features which require a specific license level
+// as the license changes.
+package licensing
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/beats/v7/libbeat/licenser"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// FeatureTailSampling is the name of the tail-based sampling feature.
+const FeatureTailSampling = "tail_sampling"
+
+// Feature describes a feature which requires a minimum license level.
+type Feature struct {
+	// Name holds the name of the feature, used for checking whether
+	// or not the feature is enabled, and for reporting.
+	Name string
+
+	// Description holds a human readable description of the
+	// feature, used in log messages.
+	Description string
+
+	// License holds the minimum license level required by the feature.
+	License licenser.LicenseType
+}
+
+// Checker checks the Elasticsearch license, and reports whether or not
+// features are enabled by it.
+type Checker struct {
+	client   elasticsearch.Client
+	interval time.Duration
+	features []Feature
+	logger   *logp.Logger
+
+	mu      sync.RWMutex
+	license *licenser.License
+	enabled map[string]bool
+}
+
+// NewChecker returns a new Checker which checks the Elasticsearch license
+// for the given features, logging changes to logger.
+func NewChecker(client elasticsearch.Client, interval time.Duration, logger *logp.Logger, features ...Feature) *Checker {
+	return &Checker{
+		client:   client,
+		interval: interval,
+		features: features,
+		logger:   logger,
+	}
+}
+
+// Check gets the Elasticsearch license, and enables or disables features
+// according to it, logging any changes.
+func (c *Checker) Check(ctx context.Context) error {
+	license, err := elasticsearch.GetLicense(ctx, c.client)
+	if err != nil {
+		return errors.Wrap(err, "error getting Elasticsearch licensing information")
+	}
+	expired := licenser.IsExpired(license)
+	enabled := make(map[string]bool, len(c.features))
+	for _, feature := range c.features {
+		enabled[feature.Name] = !expired && (license.Type == licenser.Trial || license.Cover(feature.License))
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := c.license
+	switch {
+	case previous == nil:
+		c.logger.Infof("Elasticsearch license is %s (%s)", license.Type, license.Status)
+	case !previous.EqualTo(&license):
+		c.logger.Infof(
+			"Elasticsearch license changed from %s (%s) to %s (%s)",
+			previous.Type, previous.Status, license.Type, license.Status,
+		)
+	}
+	for _, feature := range c.features {
+		wasEnabled, known := c.enabled[feature.Name]
+		if known && wasEnabled == enabled[feature.Name] {
+			continue
+		}
+		if enabled[feature.Name] {
+			if known {
+				c.logger.Infof("%s enabled by Elasticsearch license %s", feature.Description, license.Type)
+			}
+			continue
+		}
+		if expired {
+			c.logger.Warnf(
+				"%s disabled: Elasticsearch license %s is expired",
+				feature.Description, license.Type,
+			)
+		} else {
+			c.logger.Warnf(
+				"%s disabled: requires license level %s, Elasticsearch license is %s",
+				feature.Description, feature.License, license.Type,
+			)
+		}
+	}
+	c.license = &license
+	c.enabled = enabled
+	return nil
+}
+
+// Run calls Check periodically until ctx is cancelled, starting after
+// one interval. Errors are logged, and features remain enabled or
+// disabled according to the most recently checked license.
+func (c *Checker) Run(ctx context.Context) error {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		if err := c.Check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			c.logger.Warn(err)
+		}
+	}
+}
+
+// Enabled reports whether or not the named feature is enabled by the
+// Elasticsearch license. Features are considered enabled until the
+// license has been checked, and features unknown to c are always enabled.
+func (c *Checker) Enabled(name string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	enabled, ok := c.enabled[name]
+	return !ok || enabled
+}
+
+// Capabilities returns the Elasticsearch license, and the features which
+// require a license along with whether or not they are enabled, for
+// reporting by the server information endpoint.
+func (c *Checker) Capabilities() mapstr.M {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	features := make(mapstr.M, len(c.features))
+	for _, feature := range c.features {
+		enabled, ok := c.enabled[feature.Name]
+		features[feature.Name] = mapstr.M{
+			"enabled":          !ok || enabled,
+			"required_license": strings.ToLower(feature.License.String()),
+		}
+	}
+	capabilities := mapstr.M{"features": features}
+	if c.license != nil {
+		capabilities["license"] = mapstr.M{
+			"type":   strings.ToLower(c.license.Type.String()),
+			"status": strings.ToLower(c.license.Status.String()),
+		}
+	}
+	return capabilities
+}
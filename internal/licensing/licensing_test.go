@@ -0,0 +1,118 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package licensing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/beats/v7/libbeat/licenser"
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func newTestClient(t testing.TB, licenseType *atomic.Value) elasticsearch.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path != "/_license" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintf(w, `{"license":{"uid":"abc","type":%q,"status":"active"}}`, licenseType.Load())
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+	return client
+}
+
+func TestChecker(t *testing.T) {
+	var licenseType atomic.Value
+	licenseType.Store("basic")
+	checker := NewChecker(newTestClient(t, &licenseType), time.Minute, logp.NewLogger(""), Feature{
+		Name:        FeatureTailSampling,
+		Description: "tail-based sampling",
+		License:     licenser.Platinum,
+	})
+
+	// Features are enabled until the license is known.
+	assert.True(t, checker.Enabled(FeatureTailSampling))
+	assert.Equal(t, mapstr.M{
+		"features": mapstr.M{
+			"tail_sampling": mapstr.M{"enabled": true, "required_license": "platinum"},
+		},
+	}, checker.Capabilities())
+
+	require.NoError(t, checker.Check(context.Background()))
+	assert.False(t, checker.Enabled(FeatureTailSampling))
+	assert.True(t, checker.Enabled("unknown"))
+	assert.Equal(t, mapstr.M{
+		"license": mapstr.M{"type": "basic", "status": "active"},
+		"features": mapstr.M{
+			"tail_sampling": mapstr.M{"enabled": false, "required_license": "platinum"},
+		},
+	}, checker.Capabilities())
+
+	for _, licenseTypeName := range []string{"platinum", "enterprise"} {
+		licenseType.Store(licenseTypeName)
+		require.NoError(t, checker.Check(context.Background()))
+		assert.True(t, checker.Enabled(FeatureTailSampling), licenseTypeName)
+	}
+
+	licenseType.Store("gold")
+	require.NoError(t, checker.Check(context.Background()))
+	assert.False(t, checker.Enabled(FeatureTailSampling))
+}
+
+func TestCheckerRun(t *testing.T) {
+	var licenseType atomic.Value
+	licenseType.Store("basic")
+	checker := NewChecker(newTestClient(t, &licenseType), 10*time.Millisecond, logp.NewLogger(""), Feature{
+		Name:        FeatureTailSampling,
+		Description: "tail-based sampling",
+		License:     licenser.Platinum,
+	})
+	require.NoError(t, checker.Check(context.Background()))
+	assert.False(t, checker.Enabled(FeatureTailSampling))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- checker.Run(ctx) }()
+
+	// Upgrading the license should enable the feature without restarting.
+	licenseType.Store("platinum")
+	assert.Eventually(t, func() bool {
+		return checker.Enabled(FeatureTailSampling)
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+}
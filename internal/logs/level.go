@@ -0,0 +1,238 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// Levels holds the process-wide LevelController, used for changing
+// log levels and debug selectors at runtime.
+var Levels = NewLevelController(logp.InfoLevel, nil)
+
+// LevelSettings holds the log level and debug selectors applied by
+// a LevelController.
+type LevelSettings struct {
+	// Level holds the minimum level of logged entries.
+	Level string `json:"level"`
+
+	// Selectors holds the names of loggers whose debug entries are logged,
+	// when Level is debug. Selectors match loggers with the same name, and
+	// their named children. If Selectors is empty, or contains "*", debug
+	// entries from all loggers are logged.
+	Selectors []string `json:"selectors"`
+
+	// Expires holds the time at which the settings revert to the defaults,
+	// or nil if the settings are the defaults or do not expire.
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// LevelRequest holds a request to change the log level and debug selectors,
+// received through the log level endpoint or a Fleet action.
+type LevelRequest struct {
+	// Level holds the log level: debug, info, warning, or error.
+	// If Level is empty, the default settings are restored.
+	Level string `json:"level"`
+
+	// Selectors holds the names of loggers whose debug entries are logged.
+	Selectors []string `json:"selectors"`
+
+	// Duration holds the amount of time after which the defaults are
+	// restored, e.g. "10m". If empty, the settings do not expire.
+	Duration string `json:"duration"`
+}
+
+// LevelController filters log entries by level and logger name, according
+// to settings that may be changed at runtime without restarting the server.
+type LevelController struct {
+	current atomic.Value // *levelSettings
+
+	mu       sync.Mutex
+	defaults levelSettings
+	revert   *time.Timer
+}
+
+// NewLevelController returns a LevelController with the given default
+// level and debug selectors.
+func NewLevelController(level logp.Level, selectors []string) *LevelController {
+	c := &LevelController{}
+	c.SetDefaults(level, selectors)
+	return c
+}
+
+// SetDefaults sets the default level and debug selectors, which are
+// applied immediately and restored when temporary settings expire.
+func (c *LevelController) SetDefaults(level logp.Level, selectors []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopRevert()
+	c.defaults = levelSettings{level: level, selectors: selectors}
+	c.store(c.defaults)
+}
+
+// Set sets the level and debug selectors. If duration is positive, the
+// defaults are restored once it elapses.
+func (c *LevelController) Set(level logp.Level, selectors []string, duration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopRevert()
+	settings := levelSettings{level: level, selectors: selectors}
+	if duration > 0 {
+		expires := time.Now().Add(duration)
+		settings.expires = &expires
+		var revert *time.Timer
+		revert = time.AfterFunc(duration, func() {
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			// Settings may have been changed since the timer fired.
+			if c.revert == revert {
+				c.revert = nil
+				c.store(c.defaults)
+			}
+		})
+		c.revert = revert
+	}
+	c.store(settings)
+}
+
+// Apply validates and applies req.
+func (c *LevelController) Apply(req LevelRequest) error {
+	if req.Level == "" {
+		c.Reset()
+		return nil
+	}
+	var level logp.Level
+	if err := level.Unpack(req.Level); err != nil {
+		return err
+	}
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil {
+			return fmt.Errorf("invalid duration: %w", err)
+		}
+		if duration < 0 {
+			return fmt.Errorf("invalid duration %q: must not be negative", req.Duration)
+		}
+	}
+	selectors := make([]string, 0, len(req.Selectors))
+	for _, selector := range req.Selectors {
+		if selector = strings.TrimSpace(selector); selector != "" {
+			selectors = append(selectors, selector)
+		}
+	}
+	c.Set(level, selectors, duration)
+	return nil
+}
+
+// Reset restores the default level and debug selectors.
+func (c *LevelController) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stopRevert()
+	c.store(c.defaults)
+}
+
+// Settings returns the current level and debug selectors.
+func (c *LevelController) Settings() LevelSettings {
+	settings := c.load()
+	return LevelSettings{
+		Level:     settings.level.String(),
+		Selectors: append([]string{}, settings.selectors...),
+		Expires:   settings.expires,
+	}
+}
+
+// Core returns a zapcore.Core which writes entries to core if they are
+// enabled by the current settings.
+func (c *LevelController) Core(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core, controller: c}
+}
+
+// stopRevert stops any pending reversion to the defaults.
+// This must be called with c.mu held.
+func (c *LevelController) stopRevert() {
+	if c.revert != nil {
+		c.revert.Stop()
+		c.revert = nil
+	}
+}
+
+// store stores settings as the current settings.
+// This must be called with c.mu held.
+func (c *LevelController) store(settings levelSettings) {
+	c.current.Store(&settings)
+}
+
+func (c *LevelController) load() *levelSettings {
+	return c.current.Load().(*levelSettings)
+}
+
+func (c *LevelController) enabled(level zapcore.Level) bool {
+	return c.load().level.ZapLevel().Enabled(level)
+}
+
+func (c *LevelController) enabledEntry(entry zapcore.Entry) bool {
+	settings := c.load()
+	if !settings.level.ZapLevel().Enabled(entry.Level) {
+		return false
+	}
+	if entry.Level != zapcore.DebugLevel || len(settings.selectors) == 0 {
+		return true
+	}
+	for _, selector := range settings.selectors {
+		if selector == "*" || selector == entry.LoggerName || strings.HasPrefix(entry.LoggerName, selector+".") {
+			return true
+		}
+	}
+	return false
+}
+
+type levelSettings struct {
+	level     logp.Level
+	selectors []string
+	expires   *time.Time
+}
+
+type levelCore struct {
+	zapcore.Core
+	controller *LevelController
+}
+
+func (c *levelCore) Enabled(level zapcore.Level) bool {
+	return c.controller.enabled(level) && c.Core.Enabled(level)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), controller: c.controller}
+}
+
+func (c *levelCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.controller.enabledEntry(entry) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
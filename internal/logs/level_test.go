@@ -0,0 +1,83 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package logs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+func TestLevelController(t *testing.T) {
+	controller := NewLevelController(logp.InfoLevel, nil)
+	core, observed := observer.New(zap.DebugLevel)
+	logger := zap.New(controller.Core(core))
+
+	logger.Named("request").Debug("request_debug_1")
+	logger.Named("other").Info("other_info_1")
+
+	require.NoError(t, controller.Apply(LevelRequest{Level: "debug", Selectors: []string{" request ", ""}}))
+	assert.Equal(t, []string{"request"}, controller.Settings().Selectors)
+	logger.Named("request").Debug("request_debug_2")
+	logger.Named("request").Named("child").Debug("request_child_debug_2")
+	logger.Named("requests").Debug("requests_debug_2")
+	logger.Named("other").With(zap.String("k", "v")).Debug("other_debug_2")
+
+	require.NoError(t, controller.Apply(LevelRequest{Level: "error"}))
+	assert.False(t, logger.Core().Enabled(zap.WarnLevel))
+	logger.Warn("warn_3")
+	logger.Error("error_3")
+
+	var messages []string
+	for _, entry := range observed.All() {
+		messages = append(messages, entry.Message)
+	}
+	assert.Equal(t, []string{"other_info_1", "request_debug_2", "request_child_debug_2", "error_3"}, messages)
+}
+
+func TestLevelControllerApplyInvalid(t *testing.T) {
+	controller := NewLevelController(logp.InfoLevel, nil)
+	assert.Error(t, controller.Apply(LevelRequest{Level: "verbose"}))
+	assert.Error(t, controller.Apply(LevelRequest{Level: "debug", Duration: "soon"}))
+	assert.Error(t, controller.Apply(LevelRequest{Level: "debug", Duration: "-1m"}))
+	assert.Equal(t, "info", controller.Settings().Level)
+}
+
+func TestLevelControllerExpiry(t *testing.T) {
+	controller := NewLevelController(logp.WarnLevel, []string{"beater"})
+	require.NoError(t, controller.Apply(LevelRequest{Level: "debug", Duration: "10ms"}))
+	settings := controller.Settings()
+	assert.Equal(t, "debug", settings.Level)
+	require.NotNil(t, settings.Expires)
+
+	assert.Eventually(t, func() bool {
+		return controller.Settings().Level == "warning"
+	}, 10*time.Second, 10*time.Millisecond)
+	assert.Equal(t, LevelSettings{Level: "warning", Selectors: []string{"beater"}}, controller.Settings())
+
+	// Applying a request with no level restores the defaults, cancelling expiry.
+	require.NoError(t, controller.Apply(LevelRequest{Level: "error", Duration: "1h"}))
+	require.NoError(t, controller.Apply(LevelRequest{}))
+	assert.Equal(t, LevelSettings{Level: "warning", Selectors: []string{"beater"}}, controller.Settings())
+}
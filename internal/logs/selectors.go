@@ -35,6 +35,9 @@ const (
 	Stacktrace         = "stacktrace"
 	TransactionMetrics = "txmetrics"
 	ServiceMetrics     = "servicemetrics"
+	ErrorRateMetrics   = "errorratemetrics"
+	MobileMetrics      = "mobilemetrics"
+	WebVitalsMetrics   = "webvitalsmetrics"
 	SpanMetrics        = "spanmetrics"
 	Transform          = "transform"
 	Sampling           = "sampling"
@@ -15,7 +15,9 @@
 // specific language governing permissions and limitations
 // under the License.
 
-package beater
+// Package memlimit discovers the memory available to the APM Server
+// process, from cgroup limits or the total system memory.
+package memlimit
 
 import (
 	"fmt"
@@ -27,7 +29,9 @@ import (
 	"github.com/elastic/elastic-agent-system-metrics/metric/system/resolve"
 )
 
-func newCgroupReader() *cgroup.Reader {
+// NewCgroupReader returns a cgroup.Reader for reading the limits of the
+// process's cgroup, or nil if cgroups are not available.
+func NewCgroupReader() *cgroup.Reader {
 	cgroupOpts := cgroup.ReaderOptions{
 		RootfsMountpoint:  resolve.NewTestResolver(""),
 		IgnoreRootCgroups: true,
@@ -41,9 +45,9 @@ func newCgroupReader() *cgroup.Reader {
 	return reader
 }
 
-// Returns the cgroup maximum memory if running within a cgroup in GigaBytes,
-// otherwise, it returns 0 and an error.
-func cgroupMemoryLimit(rdr *cgroup.Reader) (uint64, error) {
+// CgroupMemoryLimit returns the cgroup maximum memory in bytes if running
+// within a cgroup, otherwise, it returns 0 and an error.
+func CgroupMemoryLimit(rdr *cgroup.Reader) (uint64, error) {
 	pid := os.Getpid()
 	vers, err := rdr.CgroupsVersion(pid)
 	if err != nil {
@@ -55,12 +59,18 @@ func cgroupMemoryLimit(rdr *cgroup.Reader) (uint64, error) {
 		if err != nil {
 			return 0, fmt.Errorf("unable to read cgroup limits: %w", err)
 		}
+		if stats.Memory == nil {
+			return 0, errors.New("unable to read cgroup limits: memory controller not available")
+		}
 		return stats.Memory.Mem.Limit.Bytes, nil
 	case cgroup.CgroupsV2:
 		stats, err := rdr.GetV2StatsForProcess(pid)
 		if err != nil {
 			return 0, fmt.Errorf("unable to read cgroup limits: %w", err)
 		}
+		if stats.Memory == nil {
+			return 0, errors.New("unable to read cgroup limits: memory controller not available")
+		}
 		return stats.Memory.Mem.Max.Bytes.ValueOr(0), nil
 	}
 	return 0, errors.New("unsupported cgroup version")
@@ -15,14 +15,14 @@
 // specific language governing permissions and limitations
 // under the License.
 
-package beater
+package memlimit
 
 import (
 	"github.com/elastic/go-sysinfo"
 )
 
-// systemMemoryLimit returns the total system memory.
-func systemMemoryLimit() (uint64, error) {
+// SystemMemoryLimit returns the total system memory in bytes.
+func SystemMemoryLimit() (uint64, error) {
 	host, err := sysinfo.Host()
 	if err != nil {
 		return 0, err
@@ -26,6 +26,12 @@ type Agent struct {
 	Name        string
 	Version     string
 	EphemeralID string
+
+	// ConfigEtag holds the Etag of the central agent configuration most
+	// recently applied by the agent, as reported in intake metadata. It
+	// is used for tracking the adoption of agent configuration, and is
+	// not indexed.
+	ConfigEtag string
 }
 
 func (a *Agent) fields() mapstr.M {
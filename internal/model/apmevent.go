@@ -72,6 +72,10 @@ type APMEvent struct {
 	// Supports slice values.
 	NumericLabels NumericLabels
 
+	// BooleanLabels holds the boolean labels to apply to the event.
+	// Supports slice values.
+	BooleanLabels BooleanLabels
+
 	// Message holds the message for log events.
 	//
 	// See https://www.elastic.co/guide/en/ecs/current/ecs-base.html#field-message
@@ -89,8 +93,17 @@ func (e *APMEvent) BeatEvent() beat.Event {
 		Timestamp: e.Timestamp,
 		Fields:    make(mapstr.M),
 	}
-	fields := (*mapStr)(&event.Fields)
+	e.VisitFields(func(k string, v interface{}) {
+		event.Fields[k] = v
+	})
+	return event
+}
 
+// VisitFields calls visit for each top-level field of the event's document,
+// excluding @timestamp. The fields are the same as those of the event returned
+// by BeatEvent, without allocating a map to hold the top-level fields.
+func (e *APMEvent) VisitFields(visit func(k string, v interface{})) {
+	fields := visitMapStr(visit)
 	if e.Transaction != nil {
 		fields.maybeSetMapStr("transaction", e.Transaction.fields())
 	}
@@ -98,7 +111,11 @@ func (e *APMEvent) BeatEvent() beat.Event {
 		fields.maybeSetMapStr("span", e.Span.fields())
 	}
 	if e.Metricset != nil {
-		e.Metricset.setFields((*mapStr)(&event.Fields))
+		var metricsetFields mapStr
+		e.Metricset.setFields(&metricsetFields)
+		for k, v := range metricsetFields {
+			visit(k, v)
+		}
 	}
 	if e.Error != nil {
 		fields.maybeSetMapStr("error", e.Error.fields())
@@ -110,12 +127,11 @@ func (e *APMEvent) BeatEvent() beat.Event {
 	if !e.Timestamp.IsZero() {
 		switch e.Processor {
 		case TransactionProcessor, SpanProcessor, ErrorProcessor:
-			event.Fields["timestamp"] = mapstr.M{"us": int(e.Timestamp.UnixNano() / 1000)}
+			visit("timestamp", mapstr.M{"us": int(e.Timestamp.UnixNano() / 1000)})
 		}
 	}
 
 	// Set top-level field sets.
-	event.Timestamp = e.Timestamp
 	e.DataStream.setFields(fields)
 	fields.maybeSetMapStr("service", e.Service.Fields())
 	fields.maybeSetMapStr("agent", e.Agent.fields())
@@ -134,6 +150,7 @@ func (e *APMEvent) BeatEvent() beat.Event {
 	fields.maybeSetMapStr("network", e.Network.fields())
 	fields.maybeSetMapStr("labels", e.Labels.fields())
 	fields.maybeSetMapStr("numeric_labels", e.NumericLabels.fields())
+	fields.maybeSetMapStr("boolean_labels", e.BooleanLabels.fields())
 	fields.maybeSetMapStr("event", e.Event.fields())
 	fields.maybeSetMapStr("url", e.URL.fields())
 	fields.maybeSetMapStr("session", e.Session.fields())
@@ -145,7 +162,6 @@ func (e *APMEvent) BeatEvent() beat.Event {
 	fields.maybeSetMapStr("http", e.HTTP.fields())
 	fields.maybeSetMapStr("faas", e.FAAS.fields())
 	fields.maybeSetMapStr("log", e.Log.fields())
-	return event
 }
 
 // MarkGlobalLabels marks the current labels as "Global". This is only done
@@ -159,4 +175,8 @@ func (e *APMEvent) MarkGlobalLabels() {
 		v.Global = true
 		e.Labels[key] = v
 	}
+	for key, v := range e.BooleanLabels {
+		v.Global = true
+		e.BooleanLabels[key] = v
+	}
 }
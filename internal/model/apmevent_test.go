@@ -94,6 +94,10 @@ func TestAPMEventFields(t *testing.T) {
 				"e": {Value: float64(1234)},
 				"f": {Values: []float64{1234, 12311}},
 			},
+			BooleanLabels: map[string]BooleanLabelValue{
+				"g": {Value: true},
+				"h": {Values: []bool{true, false}},
+			},
 			Message:     "bottle",
 			Transaction: &Transaction{},
 			Timestamp:   time.Date(2019, 1, 3, 15, 17, 4, 908.596*1e6, time.FixedZone("+0100", 3600)),
@@ -165,6 +169,10 @@ func TestAPMEventFields(t *testing.T) {
 				"e": float64(1234),
 				"f": []float64{1234, 12311},
 			},
+			"boolean_labels": mapstr.M{
+				"g": true,
+				"h": []bool{true, false},
+			},
 			"message": "bottle",
 			"trace": mapstr.M{
 				"id": traceID,
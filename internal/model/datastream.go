@@ -29,7 +29,7 @@ type DataStream struct {
 	Namespace string
 }
 
-func (d *DataStream) setFields(fields *mapStr) {
+func (d *DataStream) setFields(fields visitMapStr) {
 	fields.maybeSetString("data_stream.type", d.Type)
 	fields.maybeSetString("data_stream.dataset", d.Dataset)
 	fields.maybeSetString("data_stream.namespace", d.Namespace)
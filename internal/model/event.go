@@ -23,6 +23,10 @@ import (
 	"github.com/elastic/elastic-agent-libs/mapstr"
 )
 
+// createdFormat holds the format of the event.created field, matching
+// the format used for @timestamp.
+const createdFormat = "2006-01-02T15:04:05.000Z07:00"
+
 // Event holds information about an event, in ECS terms.
 //
 // https://www.elastic.co/guide/en/ecs/current/ecs-event.html
@@ -45,6 +49,11 @@ type Event struct {
 	// source publishes more than one type of log or events (e.g. access log,
 	// error log), the dataset is used to specify which one the event comes from.
 	Dataset string
+
+	// Created holds the time at which the event was received by the server.
+	//
+	// Created is only added as a field (`created`) if non-zero.
+	Created time.Time
 }
 
 func (e *Event) fields() mapstr.M {
@@ -58,5 +67,8 @@ func (e *Event) fields() mapstr.M {
 	if e.Duration > 0 {
 		fields.set("duration", e.Duration.Nanoseconds())
 	}
+	if !e.Created.IsZero() {
+		fields.set("created", e.Created.UTC().Format(createdFormat))
+	}
 	return mapstr.M(fields)
 }
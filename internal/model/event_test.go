@@ -67,6 +67,12 @@ func TestEventFields(t *testing.T) {
 				"duration": int64(60000000000),
 			},
 		},
+		"withCreated": {
+			Event: Event{Created: time.Date(2022, 11, 22, 1, 2, 3, 456789000, time.FixedZone("", 3600))},
+			Output: mapstr.M{
+				"created": "2022-11-22T00:02:03.456Z",
+			},
+		},
 		"withOutcomeActionDataset": {
 			Event: Event{Outcome: "success", Action: "process-started", Dataset: "access-log"},
 			Output: mapstr.M{
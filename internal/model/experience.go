@@ -35,6 +35,10 @@ type UserExperience struct {
 	// or a negative value if TBT is unknown. See https://web.dev/tbt/
 	TotalBlockingTime float64
 
+	// InteractionToNextPaint holds the Interaction to Next Paint (INP) metric
+	// value, or a negative value if INP is unknown. See https://web.dev/inp/
+	InteractionToNextPaint float64
+
 	// Longtask holds longtask metrics. If Longtask.Count is negative,
 	// then Longtask is considered unset. See https://www.w3.org/TR/longtasks/
 	Longtask LongtaskMetrics
@@ -66,6 +70,9 @@ func (u *UserExperience) Fields() mapstr.M {
 	if u.TotalBlockingTime >= 0 {
 		fields.set("tbt", u.TotalBlockingTime)
 	}
+	if u.InteractionToNextPaint >= 0 {
+		fields.set("inp", u.InteractionToNextPaint)
+	}
 	if u.Longtask.Count >= 0 {
 		fields.set("longtask", mapstr.M{
 			"count": u.Longtask.Count,
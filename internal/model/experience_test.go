@@ -34,17 +34,19 @@ func TestUserExperienceFields(t *testing.T) {
 		Expected: nil,
 	}, {
 		Input: &UserExperience{
-			CumulativeLayoutShift: -1,
-			FirstInputDelay:       -1,
-			TotalBlockingTime:     -1,
-			Longtask:              LongtaskMetrics{Count: -1},
+			CumulativeLayoutShift:  -1,
+			FirstInputDelay:        -1,
+			TotalBlockingTime:      -1,
+			InteractionToNextPaint: -1,
+			Longtask:               LongtaskMetrics{Count: -1},
 		},
 		Expected: nil,
 	}, {
 		Input: &UserExperience{
-			CumulativeLayoutShift: 1,
-			FirstInputDelay:       2.3,
-			TotalBlockingTime:     4.56,
+			CumulativeLayoutShift:  1,
+			FirstInputDelay:        2.3,
+			TotalBlockingTime:      4.56,
+			InteractionToNextPaint: 78.9,
 			Longtask: LongtaskMetrics{
 				Count: 3,
 				Sum:   2,
@@ -55,6 +57,7 @@ func TestUserExperienceFields(t *testing.T) {
 			"cls": 1.0,
 			"fid": 2.3,
 			"tbt": 4.56,
+			"inp": 78.9,
 			"longtask": mapstr.M{
 				"count": 3,
 				"sum":   2.0,
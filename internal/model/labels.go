@@ -133,6 +133,61 @@ func (l NumericLabels) fields() mapstr.M {
 	return sanitizeLabels(result)
 }
 
+// BooleanLabels wraps a map[string]bool or map[string][]bool with utility
+// methods.
+type BooleanLabels map[string]BooleanLabelValue
+
+// BooleanLabelValue wraps a `bool` or `[]bool` to be set as a value for a
+// key. Only one should be set, in cases where both are set, the `Values` field
+// will be used and `Value` will be ignored.
+type BooleanLabelValue struct {
+	// Values holds holds the label `[]bool` value.
+	Values []bool
+	// Value holds the label `bool` value.
+	Value bool
+	// Global is `true` when the label is defined at the agent level, rather
+	// than being event-specific.
+	Global bool
+}
+
+// Set sets the label k to value v. If there existed a label in l with the same
+// key, it will be replaced and its Global field will be set to false.
+func (l BooleanLabels) Set(k string, v bool) {
+	l[k] = BooleanLabelValue{Value: v}
+}
+
+// SetSlice sets the label k to value v. If there existed a label in l with the
+// same key, it will be replaced and its Global field will be set to false.
+func (l BooleanLabels) SetSlice(k string, v []bool) {
+	l[k] = BooleanLabelValue{Values: v}
+}
+
+// Clone creates a deep copy of BooleanLabels.
+func (l BooleanLabels) Clone() BooleanLabels {
+	cp := make(BooleanLabels)
+	for k, v := range l {
+		to := BooleanLabelValue{Global: v.Global, Value: v.Value}
+		if len(v.Values) > 0 {
+			to.Values = make([]bool, len(v.Values))
+			copy(to.Values, v.Values)
+		}
+		cp[k] = to
+	}
+	return cp
+}
+
+func (l BooleanLabels) fields() mapstr.M {
+	result := mapstr.M{}
+	for k, v := range l {
+		if v.Values != nil {
+			result[k] = v.Values
+		} else {
+			result[k] = v.Value
+		}
+	}
+	return sanitizeLabels(result)
+}
+
 // Label keys are sanitized, replacing the reserved characters '.', '*' and '"'
 // with '_'. Null-valued labels are omitted.
 func sanitizeLabels(labels mapstr.M) mapstr.M {
@@ -59,3 +59,23 @@ func (m *mapStr) maybeSetMapStr(k string, v mapstr.M) bool {
 	}
 	return false
 }
+
+// visitMapStr provides mapStr-like methods for setting fields
+// by calling a function rather than storing them in a map.
+type visitMapStr func(k string, v interface{})
+
+func (f visitMapStr) maybeSetString(k, v string) bool {
+	if v != "" {
+		f(k, v)
+		return true
+	}
+	return false
+}
+
+func (f visitMapStr) maybeSetMapStr(k string, v mapstr.M) bool {
+	if len(v) > 0 {
+		f(k, v)
+		return true
+	}
+	return false
+}
@@ -39,6 +39,26 @@ const (
 	MetricTypeSummary   MetricType = "summary"
 )
 
+// Valid reports whether t is one of the valid MetricType values.
+func (t MetricType) Valid() bool {
+	switch t {
+	case MetricTypeGauge, MetricTypeCounter, MetricTypeHistogram, MetricTypeSummary:
+		return true
+	}
+	return false
+}
+
+// ValidMetricUnit reports whether unit is one of the units permitted
+// for MetricsetSample.Unit. These are the units supported by the `unit`
+// field mapping parameter in Elasticsearch.
+func ValidMetricUnit(unit string) bool {
+	switch unit {
+	case "percent", "byte", "nanos", "micros", "ms", "s", "m", "h", "d":
+		return true
+	}
+	return false
+}
+
 // Metricset describes a set of metrics and associated metadata.
 type Metricset struct {
 	// Samples holds the metrics in the set.
@@ -156,10 +176,18 @@ func (me *Metricset) setFields(fields *mapStr) {
 	for _, sample := range me.Samples {
 		sample.set(sample.Name, fields)
 
+		// Invalid types and units are omitted, so they do not
+		// cause metrics to be mapped incorrectly downstream.
 		var md mapStr
-		md.maybeSetString("type", string(sample.Type))
-		md.maybeSetString("unit", sample.Unit)
-		metricDescriptions.set(sample.Name, mapstr.M(md))
+		if sample.Type.Valid() {
+			md.set("type", string(sample.Type))
+		}
+		if ValidMetricUnit(sample.Unit) {
+			md.set("unit", sample.Unit)
+		}
+		if md != nil {
+			metricDescriptions.set(sample.Name, mapstr.M(md))
+		}
 	}
 	fields.maybeSetMapStr("_metric_descriptions", mapstr.M(metricDescriptions))
 }
@@ -55,10 +55,6 @@ func TestMetricset(t *testing.T) {
 			Output: mapstr.M{
 				"a.counter":  612.0,
 				"some.gauge": 9.16,
-				"_metric_descriptions": mapstr.M{
-					"a.counter":  mapstr.M(nil),
-					"some.gauge": mapstr.M(nil),
-				},
 			},
 			Msg: "Payload with valid metric.",
 		},
@@ -101,6 +97,12 @@ func TestMetricset(t *testing.T) {
 						Unit:  "percent",
 						Value: 0.99,
 					},
+					{
+						Name:  "invalid_type_and_unit",
+						Type:  "meter",
+						Unit:  "furlongs",
+						Value: 1,
+					},
 				},
 			},
 			Output: mapstr.M{
@@ -112,8 +114,9 @@ func TestMetricset(t *testing.T) {
 					"sum":         123.456,
 					"value_count": int64(10),
 				},
-				"just_type": 123.0,
-				"just_unit": 0.99,
+				"just_type":             123.0,
+				"just_unit":             0.99,
+				"invalid_type_and_unit": 1.0,
 				"_metric_descriptions": mapstr.M{
 					"latency_histogram": mapstr.M{
 						"type": "histogram",
@@ -59,11 +59,24 @@ func (g *JSONSchemaGenerator) Generate(idPath string, rootType string) (bytes.Bu
 }
 
 func (g *JSONSchemaGenerator) generate(st structType, key string, prop *property) error {
+	parentKey := key
 	if key != "" {
 		key += "."
 	}
 	for _, f := range st.fields {
 		var err error
+		if f.Embedded() {
+			// fields of embedded structs are flattened into the parent object,
+			// in the same way they are decoded
+			child, ok := g.parsed.structTypes[f.Type().String()]
+			if !ok {
+				return fmt.Errorf("unhandled embedded type for field %s", f.Name())
+			}
+			if err := g.generate(child, parentKey, prop); err != nil {
+				return err
+			}
+			continue
+		}
 		name := jsonSchemaName(f)
 		childProp := property{Properties: make(map[string]*property), Type: &propertyType{}, Description: f.comment}
 		tags, err := validationTag(f.tag)
@@ -18,11 +18,44 @@
 package modeldecoder
 
 import (
+	"time"
+
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modeldecoder/nullable"
 )
 
 // Input holds the input required for decoding an event.
 type Input struct {
 	// Base holds the base for decoding events.
 	Base model.APMEvent
+
+	// ValidationDuration, if non-nil, is incremented by the time spent
+	// validating the decoded event. This is used for tracing the time
+	// spent in validation across a batch of events.
+	ValidationDuration *time.Duration
+
+	// Coerced, if non-nil, enables lenient decoding of values which would
+	// otherwise fail validation, such as unrecognised enum values. Coerced
+	// is called with the path and original value of each coerced field.
+	Coerced func(field, value string)
+
+	// TimestampPolicy controls the timestamps assigned to events decoded
+	// without a valid timestamp. If empty, TimestampPolicyOffset is used.
+	TimestampPolicy TimestampPolicy
+}
+
+// CoerceEnum sets v to fallback if input enables lenient decoding and v
+// is set to a value other than one of values, reporting the coercion
+// to input.Coerced.
+func CoerceEnum(input *Input, field string, v *nullable.String, values []string, fallback string) {
+	if input.Coerced == nil || !v.IsSet() {
+		return
+	}
+	for _, value := range values {
+		if v.Val == value {
+			return
+		}
+	}
+	input.Coerced(field, v.Val)
+	v.Set(fallback)
 }
@@ -46,6 +46,7 @@ type Values struct {
 	HTTPHeader      http.Header
 	LabelVal        model.LabelValue
 	NumericLabelVal model.NumericLabelValue
+	BooleanLabelVal model.BooleanLabelValue
 	// N controls how many elements are added to a slice or a map
 	N int
 }
@@ -64,6 +65,7 @@ func DefaultValues() *Values {
 		HTTPHeader:      http.Header{http.CanonicalHeaderKey("user-agent"): []string{"a", "b", "c"}},
 		LabelVal:        model.LabelValue{Value: "init"},
 		NumericLabelVal: model.NumericLabelValue{Value: 0.5},
+		BooleanLabelVal: model.BooleanLabelValue{Value: true},
 		N:               3,
 	}
 }
@@ -82,6 +84,7 @@ func NonDefaultValues() *Values {
 		HTTPHeader:      http.Header{http.CanonicalHeaderKey("user-agent"): []string{"d", "e"}},
 		LabelVal:        model.LabelValue{Value: "overwritten"},
 		NumericLabelVal: model.NumericLabelValue{Value: 3.5},
+		BooleanLabelVal: model.BooleanLabelValue{Value: false},
 		N:               2,
 	}
 }
@@ -170,6 +173,8 @@ func SetStructValues(in interface{}, values *Values, opts ...SetStructValuesOpti
 				elemVal = reflect.ValueOf(values.LabelVal)
 			case model.NumericLabels:
 				elemVal = reflect.ValueOf(values.NumericLabelVal)
+			case model.BooleanLabels:
+				elemVal = reflect.ValueOf(values.BooleanLabelVal)
 			default:
 				if f.Type().Elem().Kind() != reflect.Struct {
 					panic(fmt.Sprintf("unhandled type %s for key %s", v, key))
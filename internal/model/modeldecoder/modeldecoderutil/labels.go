@@ -19,18 +19,18 @@ package modeldecoderutil
 
 import (
 	"encoding/json"
-	"strconv"
 
 	"github.com/elastic/elastic-agent-libs/mapstr"
 
 	"github.com/elastic/apm-server/internal/model"
 )
 
-// GlobalLabelsFrom populates the Labels and NumericLabels from global labels
-// in the metadata object.
+// GlobalLabelsFrom populates the Labels, NumericLabels, and BooleanLabels
+// from global labels in the metadata object.
 func GlobalLabelsFrom(from mapstr.M, to *model.APMEvent) {
 	to.NumericLabels = make(model.NumericLabels)
 	to.Labels = make(model.Labels)
+	to.BooleanLabels = make(model.BooleanLabels)
 	MergeLabels(from, to)
 	to.MarkGlobalLabels()
 }
@@ -38,7 +38,8 @@ func GlobalLabelsFrom(from mapstr.M, to *model.APMEvent) {
 // MergeLabels merges eventLabels into the APMEvent. This is used for
 // combining event-specific labels onto (metadata) global labels.
 //
-// If eventLabels is non-nil, it is first cloned.
+// If eventLabels is non-nil, it is first cloned. An event label replaces
+// any global label with the same key, regardless of the labels' types.
 func MergeLabels(eventLabels mapstr.M, to *model.APMEvent) {
 	if to.NumericLabels == nil {
 		to.NumericLabels = make(model.NumericLabels)
@@ -46,16 +47,23 @@ func MergeLabels(eventLabels mapstr.M, to *model.APMEvent) {
 	if to.Labels == nil {
 		to.Labels = make(model.Labels)
 	}
+	if to.BooleanLabels == nil {
+		to.BooleanLabels = make(model.BooleanLabels)
+	}
 	for k, v := range eventLabels {
 		switch v := v.(type) {
 		case string:
+			deleteLabel(k, to)
 			to.Labels.Set(k, v)
 		case bool:
-			to.Labels.Set(k, strconv.FormatBool(v))
+			deleteLabel(k, to)
+			to.BooleanLabels.Set(k, v)
 		case float64:
+			deleteLabel(k, to)
 			to.NumericLabels.Set(k, v)
 		case json.Number:
 			if floatVal, err := v.Float64(); err == nil {
+				deleteLabel(k, to)
 				to.NumericLabels.Set(k, floatVal)
 			}
 		}
@@ -66,6 +74,16 @@ func MergeLabels(eventLabels mapstr.M, to *model.APMEvent) {
 	if len(to.Labels) == 0 {
 		to.Labels = nil
 	}
+	if len(to.BooleanLabels) == 0 {
+		to.BooleanLabels = nil
+	}
+}
+
+// deleteLabel deletes the label k from all of the event's labels.
+func deleteLabel(k string, event *model.APMEvent) {
+	delete(event.Labels, k)
+	delete(event.NumericLabels, k)
+	delete(event.BooleanLabels, k)
 }
 
 // NormalizeLabelValues transforms the values in labels, replacing any
@@ -0,0 +1,108 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nullable
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/modern-go/reflect2"
+)
+
+// NewLenientExtension returns a jsoniter.Extension which coerces string
+// values into Int, Float64, and Bool values, rather than failing to decode
+// them. For each coerced value, coerced is called with the name of the
+// target type and the original string value.
+//
+// The extension must be registered with a jsoniter.API, rather than globally,
+// so that lenient decoding may be enabled independently of strict decoding.
+func NewLenientExtension(coerced func(typ, value string)) jsoniter.Extension {
+	return &lenientExtension{coerced: coerced}
+}
+
+type lenientExtension struct {
+	jsoniter.DummyExtension
+	coerced func(typ, value string)
+}
+
+// DecorateDecoder decorates the decoders for Int, Float64, and Bool.
+func (e *lenientExtension) DecorateDecoder(typ reflect2.Type, decoder jsoniter.ValDecoder) jsoniter.ValDecoder {
+	var parse func(ptr unsafe.Pointer, s string) error
+	switch typ.String() {
+	case "nullable.Int":
+		parse = func(ptr unsafe.Pointer, s string) error {
+			v, err := strconv.Atoi(s)
+			if err != nil {
+				return err
+			}
+			(*Int)(ptr).Set(v)
+			return nil
+		}
+	case "nullable.Float64":
+		parse = func(ptr unsafe.Pointer, s string) error {
+			v, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return err
+			}
+			(*Float64)(ptr).Set(v)
+			return nil
+		}
+	case "nullable.Bool":
+		parse = func(ptr unsafe.Pointer, s string) error {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return err
+			}
+			(*Bool)(ptr).Set(v)
+			return nil
+		}
+	default:
+		return decoder
+	}
+	return &lenientDecoder{
+		ValDecoder: decoder,
+		typ:        typ.String(),
+		parse:      parse,
+		coerced:    e.coerced,
+	}
+}
+
+type lenientDecoder struct {
+	jsoniter.ValDecoder
+	typ     string
+	parse   func(ptr unsafe.Pointer, s string) error
+	coerced func(typ, value string)
+}
+
+func (d *lenientDecoder) Decode(ptr unsafe.Pointer, iter *jsoniter.Iterator) {
+	if iter.WhatIsNext() != jsoniter.StringValue {
+		d.ValDecoder.Decode(ptr, iter)
+		return
+	}
+	value := iter.ReadString()
+	if err := d.parse(ptr, strings.TrimSpace(value)); err != nil {
+		iter.ReportError("decode "+d.typ, fmt.Sprintf("cannot coerce %q to %s", value, d.typ))
+		return
+	}
+	if d.coerced != nil {
+		d.coerced(d.typ, value)
+	}
+}
@@ -0,0 +1,65 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package nullable
+
+import (
+	"testing"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLenientExtension(t *testing.T) {
+	var coerced []string
+	lenientJSON := jsoniter.Config{}.Froze()
+	lenientJSON.RegisterExtension(NewLenientExtension(func(typ, value string) {
+		coerced = append(coerced, typ+"="+value)
+	}))
+
+	var out testType
+	err := lenientJSON.UnmarshalFromString(`{"s":"foo","i":"123","f":" 1.5 ","b":"true"}`, &out)
+	require.NoError(t, err)
+	assert.Equal(t, "foo", out.S.Val)
+	assert.Equal(t, 123, out.I.Val)
+	assert.True(t, out.I.IsSet())
+	assert.Equal(t, 1.5, out.F.Val)
+	assert.True(t, out.F.IsSet())
+	assert.True(t, out.B.Val)
+	assert.True(t, out.B.IsSet())
+	assert.Equal(t, []string{"nullable.Int=123", "nullable.Float64= 1.5 ", "nullable.Bool=true"}, coerced)
+
+	// Values which are not strings are decoded as usual.
+	coerced = nil
+	out = testType{}
+	err = lenientJSON.UnmarshalFromString(`{"i":456,"f":null,"b":false}`, &out)
+	require.NoError(t, err)
+	assert.Equal(t, 456, out.I.Val)
+	assert.False(t, out.F.IsSet())
+	assert.True(t, out.B.IsSet())
+	assert.Empty(t, coerced)
+
+	// Strings which cannot be coerced fail decoding.
+	err = lenientJSON.UnmarshalFromString(`{"i":"abc"}`, &testType{})
+	assert.ErrorContains(t, err, `cannot coerce "abc" to nullable.Int`)
+
+	// Strict decoding is unaffected by the lenient extension.
+	err = json.UnmarshalFromString(`{"i":"123"}`, &testType{})
+	assert.Error(t, err)
+	assert.Empty(t, coerced)
+}
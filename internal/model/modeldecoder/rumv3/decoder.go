@@ -92,6 +92,49 @@ func DecodeNestedMetadata(d decoder.Decoder, out *model.APMEvent) error {
 	return nil
 }
 
+// DecodeNestedMetadataFunc decodes and validates metadata from d, returning
+// a function which maps the metadata onto an event.
+//
+// The returned function may be called any number of times, concurrently,
+// and so may be cached to avoid decoding identical metadata repeatedly.
+func DecodeNestedMetadataFunc(d decoder.Decoder) (func(*model.APMEvent), error) {
+	var root metadataRoot
+	if err := d.Decode(&root); err != nil && err != io.EOF {
+		return nil, modeldecoder.NewDecoderErrFromJSONIter(err)
+	}
+	if err := root.validate(); err != nil {
+		return nil, modeldecoder.NewValidationErr(err)
+	}
+	return func(out *model.APMEvent) {
+		mapToMetadataModel(&root.Metadata, out)
+	}, nil
+}
+
+type validator interface {
+	validate() error
+}
+
+// validate validates v, adding the time spent to input.ValidationDuration
+// if it is non-nil.
+func validate(input *modeldecoder.Input, v validator) error {
+	if input.ValidationDuration == nil {
+		return v.validate()
+	}
+	start := time.Now()
+	err := v.validate()
+	*input.ValidationDuration += time.Since(start)
+	return err
+}
+
+// coerceTransaction coerces invalid transaction and span values
+// when decoding leniently.
+func coerceTransaction(input *modeldecoder.Input, tx *transaction) {
+	modeldecoder.CoerceEnum(input, "x.o", &tx.Outcome, enumOutcome, "unknown")
+	for i := range tx.Spans {
+		modeldecoder.CoerceEnum(input, "x.y.o", &tx.Spans[i].Outcome, enumOutcome, "unknown")
+	}
+}
+
 // DecodeNestedError decodes an error from d, appending it to batch.
 //
 // DecodeNestedError should be used when the stream in the decoder contains the `error` key
@@ -101,11 +144,14 @@ func DecodeNestedError(d decoder.Decoder, input *modeldecoder.Input, batch *mode
 	if err := d.Decode(root); err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
 	mapToErrorModel(&root.Error, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Error.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, event)
 	return nil
 }
@@ -120,12 +166,19 @@ func DecodeNestedTransaction(d decoder.Decoder, input *modeldecoder.Input, batch
 	if err := d.Decode(root); err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	coerceTransaction(input, &root.Transaction)
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 
+	// RUM v3 transactions and spans do not hold timestamps, and are always
+	// timestamped relative to the time the request was received.
+	var noTimestamp nullable.TimeMicrosUnix
 	transaction := input.Base
 	mapToTransactionModel(&root.Transaction, &transaction)
+	if err := modeldecoder.SetTimestamp(input, &transaction, noTimestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, transaction)
 
 	for _, m := range root.Transaction.Metricsets {
@@ -143,6 +196,9 @@ func DecodeNestedTransaction(d decoder.Decoder, input *modeldecoder.Input, batch
 	for _, s := range root.Transaction.Spans {
 		event := input.Base
 		mapToSpanModel(&s, &event)
+		if err := modeldecoder.SetTimestamp(input, &event, noTimestamp, modeldecoder.MillisecondsOffset(s.Start)); err != nil {
+			return modeldecoder.NewValidationErr(err)
+		}
 		event.Transaction = &model.Transaction{ID: transaction.Transaction.ID}
 		event.Parent.ID = transaction.Transaction.ID // may be overridden later
 		event.Trace = transaction.Trace
@@ -736,10 +792,11 @@ func mapToTransactionModel(from *transaction, event *model.APMEvent) {
 	}
 	if from.UserExperience.IsSet() {
 		out.UserExperience = &model.UserExperience{
-			CumulativeLayoutShift: -1,
-			FirstInputDelay:       -1,
-			TotalBlockingTime:     -1,
-			Longtask:              model.LongtaskMetrics{Count: -1},
+			CumulativeLayoutShift:  -1,
+			FirstInputDelay:        -1,
+			TotalBlockingTime:      -1,
+			InteractionToNextPaint: -1,
+			Longtask:               model.LongtaskMetrics{Count: -1},
 		}
 		if from.UserExperience.CumulativeLayoutShift.IsSet() {
 			out.UserExperience.CumulativeLayoutShift = from.UserExperience.CumulativeLayoutShift.Val
@@ -750,6 +807,9 @@ func mapToTransactionModel(from *transaction, event *model.APMEvent) {
 		if from.UserExperience.TotalBlockingTime.IsSet() {
 			out.UserExperience.TotalBlockingTime = from.UserExperience.TotalBlockingTime.Val
 		}
+		if from.UserExperience.InteractionToNextPaint.IsSet() {
+			out.UserExperience.InteractionToNextPaint = from.UserExperience.InteractionToNextPaint.Val
+		}
 		if from.UserExperience.Longtask.IsSet() {
 			out.UserExperience.Longtask = model.LongtaskMetrics{
 				Count: from.UserExperience.Longtask.Count.Val,
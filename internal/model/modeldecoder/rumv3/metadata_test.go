@@ -93,6 +93,7 @@ func metadataExceptions(keys ...string) func(key string) bool {
 
 		// Dedicated test for it.
 		"NumericLabels",
+		"BooleanLabels",
 		"Labels",
 		"GlobalLabels",
 		"GlobalNumericLabels",
@@ -157,12 +158,14 @@ func TestDecodeNestedMetadata(t *testing.T) {
 			Agent:   model.Agent{Name: "go", Version: "1.0.0"},
 			Labels: model.Labels{
 				"a": {Global: true, Value: "b"},
-				"c": {Global: true, Value: "true"},
 			},
 			NumericLabels: model.NumericLabels{
 				"d": {Global: true, Value: float64(1234)},
 				"e": {Global: true, Value: float64(1234.11)},
 			},
+			BooleanLabels: model.BooleanLabels{
+				"c": {Global: true, Value: true},
+			},
 		}, out)
 	})
 }
@@ -618,6 +618,9 @@ type transactionUserExperience struct {
 	// TotalBlockingTime holds the Total Blocking Time (TBT) metric value,
 	// or a negative value if TBT is unknown. See https://web.dev/tbt/
 	TotalBlockingTime nullable.Float64 `json:"tbt" validate:"min=0"`
+	// InteractionToNextPaint holds the Interaction to Next Paint (INP) metric
+	// value, or a negative value if INP is unknown. See https://web.dev/inp/
+	InteractionToNextPaint nullable.Float64 `json:"inp" validate:"min=0"`
 	// Longtask holds longtask duration/count metrics.
 	Longtask longtaskMetrics `json:"lt"`
 }
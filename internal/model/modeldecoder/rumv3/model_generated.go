@@ -1647,13 +1647,14 @@ func (val *spanContextService) processNestedSource() error {
 }
 
 func (val *transactionUserExperience) IsSet() bool {
-	return val.CumulativeLayoutShift.IsSet() || val.FirstInputDelay.IsSet() || val.TotalBlockingTime.IsSet() || val.Longtask.IsSet()
+	return val.CumulativeLayoutShift.IsSet() || val.FirstInputDelay.IsSet() || val.TotalBlockingTime.IsSet() || val.InteractionToNextPaint.IsSet() || val.Longtask.IsSet()
 }
 
 func (val *transactionUserExperience) Reset() {
 	val.CumulativeLayoutShift.Reset()
 	val.FirstInputDelay.Reset()
 	val.TotalBlockingTime.Reset()
+	val.InteractionToNextPaint.Reset()
 	val.Longtask.Reset()
 }
 
@@ -1670,6 +1671,9 @@ func (val *transactionUserExperience) validate() error {
 	if val.TotalBlockingTime.IsSet() && val.TotalBlockingTime.Val < 0 {
 		return fmt.Errorf("'tbt': validation rule 'min(0)' violated")
 	}
+	if val.InteractionToNextPaint.IsSet() && val.InteractionToNextPaint.Val < 0 {
+		return fmt.Errorf("'inp': validation rule 'min(0)' violated")
+	}
 	if err := val.Longtask.validate(); err != nil {
 		return errors.Wrapf(err, "lt")
 	}
@@ -396,11 +396,13 @@ func TestDecodeMapToTransactionModel(t *testing.T) {
 		mapToTransactionModel(&input, &out)
 		assert.Equal(t, model.Labels{
 			"a": {Value: "b"},
-			"e": {Value: "true"},
 		}, out.Labels)
 		assert.Equal(t, model.NumericLabels{
 			"c": {Value: float64(12315124131)},
 			"d": {Value: float64(12315124131.12315124131)},
 		}, out.NumericLabels)
+		assert.Equal(t, model.BooleanLabels{
+			"e": {Value: true},
+		}, out.BooleanLabels)
 	})
 }
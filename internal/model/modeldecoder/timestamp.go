@@ -0,0 +1,86 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modeldecoder
+
+import (
+	"errors"
+	"time"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modeldecoder/nullable"
+)
+
+// TimestampPolicy controls the timestamps assigned to events decoded
+// without a timestamp. Events with timestamps which cannot be parsed
+// are always rejected by the decoder.
+type TimestampPolicy string
+
+const (
+	// TimestampPolicyOffset sets missing timestamps to the time the
+	// request was received, back-dated or forward-dated by any offset
+	// provided by the agent, such as a span's start offset relative to
+	// its transaction. This is the default policy.
+	TimestampPolicyOffset TimestampPolicy = "offset"
+
+	// TimestampPolicyReceiveTime sets missing timestamps to the time
+	// the request was received, ignoring any agent-provided offsets.
+	TimestampPolicyReceiveTime TimestampPolicy = "receive_time"
+
+	// TimestampPolicyReject rejects events without a timestamp.
+	TimestampPolicyReject TimestampPolicy = "reject"
+)
+
+// ErrMissingTimestamp is returned for events without a timestamp,
+// when using TimestampPolicyReject.
+var ErrMissingTimestamp = errors.New("missing timestamp")
+
+// SetTimestamp sets event.Timestamp according to input.TimestampPolicy.
+//
+// If ts is set, event.Timestamp is set to ts. Otherwise the timestamp is
+// missing, and is set relative to input.Base.Timestamp, which holds the
+// time the request was received.
+// If offset is non-nil, it holds an agent-provided offset from the time
+// the request was received.
+func SetTimestamp(input *Input, event *model.APMEvent, ts nullable.TimeMicrosUnix, offset *time.Duration) error {
+	if ts.IsSet() {
+		event.Timestamp = ts.Val
+		return nil
+	}
+	switch input.TimestampPolicy {
+	case TimestampPolicyReject:
+		return ErrMissingTimestamp
+	case TimestampPolicyReceiveTime:
+		event.Timestamp = input.Base.Timestamp
+	default:
+		event.Timestamp = input.Base.Timestamp
+		if offset != nil {
+			event.Timestamp = event.Timestamp.Add(*offset)
+		}
+	}
+	return nil
+}
+
+// MillisecondsOffset returns a pointer to the duration of the given
+// number of milliseconds if v is set, and nil otherwise.
+func MillisecondsOffset(v nullable.Float64) *time.Duration {
+	if !v.IsSet() {
+		return nil
+	}
+	d := time.Duration(float64(time.Millisecond) * v.Val)
+	return &d
+}
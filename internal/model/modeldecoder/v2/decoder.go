@@ -150,6 +150,25 @@ func DecodeNestedMetadata(d decoder.Decoder, out *model.APMEvent) error {
 	return decodeMetadata(decodeIntoMetadataRoot, d, out)
 }
 
+// DecodeNestedMetadataFunc decodes and validates metadata from d, returning
+// a function which maps the metadata onto an event.
+//
+// The returned function may be called any number of times, concurrently,
+// and so may be cached to avoid decoding identical metadata repeatedly.
+func DecodeNestedMetadataFunc(d decoder.Decoder) (func(*model.APMEvent), error) {
+	var root metadataRoot
+	err := decodeIntoMetadataRoot(d, &root)
+	if err != nil && err != io.EOF {
+		return nil, modeldecoder.NewDecoderErrFromJSONIter(err)
+	}
+	if err := root.validate(); err != nil {
+		return nil, modeldecoder.NewValidationErr(err)
+	}
+	return func(out *model.APMEvent) {
+		mapToMetadataModel(&root.Metadata, out)
+	}, err
+}
+
 // DecodeNestedError decodes an error from d, appending it to batch.
 //
 // DecodeNestedError should be used when the stream in the decoder contains the `error` key
@@ -160,11 +179,14 @@ func DecodeNestedError(d decoder.Decoder, input *modeldecoder.Input, batch *mode
 	if err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
 	mapToErrorModel(&root.Error, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Error.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, event)
 	return err
 }
@@ -179,11 +201,15 @@ func DecodeNestedMetricset(d decoder.Decoder, input *modeldecoder.Input, batch *
 	if err = d.Decode(root); err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
-	if mapToMetricsetModel(&root.Metricset, &event) {
+	ok := mapToMetricsetModel(&root.Metricset, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Metricset.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
+	if ok {
 		*batch = append(*batch, event)
 	}
 	return err
@@ -199,11 +225,15 @@ func DecodeNestedSpan(d decoder.Decoder, input *modeldecoder.Input, batch *model
 	if err = d.Decode(root); err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	coerceSpan(input, &root.Span)
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
 	mapToSpanModel(&root.Span, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Span.Timestamp, modeldecoder.MillisecondsOffset(root.Span.Start)); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, event)
 	return err
 }
@@ -218,11 +248,15 @@ func DecodeNestedTransaction(d decoder.Decoder, input *modeldecoder.Input, batch
 	if err = d.Decode(root); err != nil && err != io.EOF {
 		return modeldecoder.NewDecoderErrFromJSONIter(err)
 	}
-	if err := root.validate(); err != nil {
+	coerceTransaction(input, &root.Transaction)
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
 	mapToTransactionModel(&root.Transaction, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Transaction.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, event)
 	return err
 }
@@ -241,11 +275,42 @@ func DecodeNestedLog(d decoder.Decoder, input *modeldecoder.Input, batch *model.
 	if err := root.processNestedSource(); err != nil {
 		return err
 	}
-	if err := root.validate(); err != nil {
+	if err := validate(input, root); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
+	event := input.Base
+	mapToLogModel(&root.Log, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Log.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
+	*batch = append(*batch, event)
+	return err
+}
+
+// DecodeLog decodes a log event from d, appending it to batch.
+//
+// DecodeLog should be used when the stream in the decoder does not contain the
+// `log` key, but only the log data, such as log lines produced by ECS logging
+// libraries.
+func DecodeLog(d decoder.Decoder, input *modeldecoder.Input, batch *model.Batch) error {
+	root := fetchLogRoot()
+	defer releaseLogRoot(root)
+	var err error
+	if err = d.Decode(&root.Log); err != nil && err != io.EOF {
+		return modeldecoder.NewDecoderErrFromJSONIter(err)
+	}
+	// Flatten any nested source to set the values for the flat fields
+	if err := root.processNestedSource(); err != nil {
+		return err
+	}
+	if err := validate(input, root); err != nil {
 		return modeldecoder.NewValidationErr(err)
 	}
 	event := input.Base
 	mapToLogModel(&root.Log, &event)
+	if err := modeldecoder.SetTimestamp(input, &event, root.Log.Timestamp, nil); err != nil {
+		return modeldecoder.NewValidationErr(err)
+	}
 	*batch = append(*batch, event)
 	return err
 }
@@ -264,6 +329,38 @@ func decodeMetadata(decFn func(d decoder.Decoder, m *metadataRoot) error, d deco
 	return err
 }
 
+type validator interface {
+	validate() error
+}
+
+// validate validates v, adding the time spent to input.ValidationDuration
+// if it is non-nil.
+func validate(input *modeldecoder.Input, v validator) error {
+	if input.ValidationDuration == nil {
+		return v.validate()
+	}
+	start := time.Now()
+	err := v.validate()
+	*input.ValidationDuration += time.Since(start)
+	return err
+}
+
+// coerceSpan coerces invalid span values when decoding leniently.
+func coerceSpan(input *modeldecoder.Input, span *span) {
+	modeldecoder.CoerceEnum(input, "span.outcome", &span.Outcome, enumOutcome, "unknown")
+}
+
+// coerceTransaction coerces invalid transaction values when decoding leniently.
+func coerceTransaction(input *modeldecoder.Input, tx *transaction) {
+	modeldecoder.CoerceEnum(input, "transaction.outcome", &tx.Outcome, enumOutcome, "unknown")
+	for i := range tx.DroppedSpanStats {
+		modeldecoder.CoerceEnum(
+			input, "transaction.dropped_spans_stats.outcome",
+			&tx.DroppedSpanStats[i].Outcome, enumOutcome, "unknown",
+		)
+	}
+}
+
 func decodeIntoMetadata(d decoder.Decoder, m *metadataRoot) error {
 	return d.Decode(&m.Metadata)
 }
@@ -563,6 +660,9 @@ func mapToMetadataModel(from *metadata, out *model.APMEvent) {
 	}
 
 	// Service
+	if from.Service.Agent.ConfigEtag.IsSet() {
+		out.Agent.ConfigEtag = from.Service.Agent.ConfigEtag.Val
+	}
 	if from.Service.Agent.EphemeralID.IsSet() {
 		out.Agent.EphemeralID = from.Service.Agent.EphemeralID.Val
 	}
@@ -1281,10 +1381,11 @@ func mapToTransactionModel(from *transaction, event *model.APMEvent) {
 	}
 	if from.UserExperience.IsSet() {
 		out.UserExperience = &model.UserExperience{
-			CumulativeLayoutShift: -1,
-			FirstInputDelay:       -1,
-			TotalBlockingTime:     -1,
-			Longtask:              model.LongtaskMetrics{Count: -1},
+			CumulativeLayoutShift:  -1,
+			FirstInputDelay:        -1,
+			TotalBlockingTime:      -1,
+			InteractionToNextPaint: -1,
+			Longtask:               model.LongtaskMetrics{Count: -1},
 		}
 		if from.UserExperience.CumulativeLayoutShift.IsSet() {
 			out.UserExperience.CumulativeLayoutShift = from.UserExperience.CumulativeLayoutShift.Val
@@ -1296,6 +1397,9 @@ func mapToTransactionModel(from *transaction, event *model.APMEvent) {
 		if from.UserExperience.TotalBlockingTime.IsSet() {
 			out.UserExperience.TotalBlockingTime = from.UserExperience.TotalBlockingTime.Val
 		}
+		if from.UserExperience.InteractionToNextPaint.IsSet() {
+			out.UserExperience.InteractionToNextPaint = from.UserExperience.InteractionToNextPaint.Val
+		}
 		if from.UserExperience.Longtask.IsSet() {
 			out.UserExperience.Longtask = model.LongtaskMetrics{
 				Count: from.UserExperience.Longtask.Count.Val,
@@ -1402,6 +1506,9 @@ func mapOTelAttributesTransaction(from otel, out *model.APMEvent) {
 	if out.NumericLabels == nil {
 		out.NumericLabels = make(model.NumericLabels)
 	}
+	if out.BooleanLabels == nil {
+		out.BooleanLabels = make(model.BooleanLabels)
+	}
 	// TODO: Does this work? Is there a way we can infer the status code,
 	// potentially in the actual attributes map?
 	spanStatus := ptrace.NewStatus()
@@ -1430,6 +1537,9 @@ func mapOTelAttributesSpan(from otel, out *model.APMEvent) {
 	if out.NumericLabels == nil {
 		out.NumericLabels = make(model.NumericLabels)
 	}
+	if out.BooleanLabels == nil {
+		out.BooleanLabels = make(model.BooleanLabels)
+	}
 	var spanKind ptrace.SpanKind
 	if from.SpanKind.IsSet() {
 		switch from.SpanKind.Val {
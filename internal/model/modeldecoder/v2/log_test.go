@@ -175,6 +175,49 @@ func TestDecodeNestedLog(t *testing.T) {
 	})
 }
 
+func TestDecodeLog(t *testing.T) {
+	t.Run("ecs", func(t *testing.T) {
+		// A log line as produced by ECS logging libraries, using a mix of
+		// flat and nested fields, and including fields which are ignored.
+		input := modeldecoder.Input{Base: model.APMEvent{Service: model.Service{Name: "metadata-service"}}}
+		str := `{"@timestamp":"2022-09-08T06:02:51.123Z","log.level":"INFO","message":"something happened","ecs.version":"1.2.0","service.name":"testsvc","process.thread.name":"main","log":{"logger":"testLogger","origin":{"file":{"name":"testFile","line":10}}},"trace.id":"trace-id","transaction.id":"transaction-id","span.id":"span-id"}`
+		dec := decoder.NewJSONDecoder(strings.NewReader(str))
+		var batch model.Batch
+		require.NoError(t, DecodeLog(dec, &input, &batch))
+		require.Len(t, batch, 1)
+		assert.Equal(t, model.LogProcessor, batch[0].Processor)
+		assert.Equal(t, "something happened", batch[0].Message)
+		assert.Equal(t, "2022-09-08 06:02:51.123 +0000 UTC", batch[0].Timestamp.String())
+		assert.Equal(t, "INFO", batch[0].Log.Level)
+		assert.Equal(t, "testLogger", batch[0].Log.Logger)
+		assert.Equal(t, "testFile", batch[0].Log.Origin.File.Name)
+		assert.Equal(t, 10, batch[0].Log.Origin.File.Line)
+		assert.Equal(t, "testsvc", batch[0].Service.Name)
+		assert.Equal(t, "main", batch[0].Process.Thread.Name)
+		assert.Equal(t, "trace-id", batch[0].Trace.ID)
+		assert.Equal(t, "transaction-id", batch[0].Transaction.ID)
+		assert.Equal(t, "span-id", batch[0].Span.ID)
+	})
+
+	t.Run("metadata", func(t *testing.T) {
+		input := modeldecoder.Input{Base: model.APMEvent{Service: model.Service{Name: "metadata-service"}}}
+		dec := decoder.NewJSONDecoder(strings.NewReader(`{"message":"something happened"}`))
+		var batch model.Batch
+		require.NoError(t, DecodeLog(dec, &input, &batch))
+		require.Len(t, batch, 1)
+		assert.Equal(t, "metadata-service", batch[0].Service.Name)
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		var batch model.Batch
+		err := DecodeLog(decoder.NewJSONDecoder(strings.NewReader(`{"log.level":1}`)), &modeldecoder.Input{}, &batch)
+		require.Error(t, err)
+		err = DecodeLog(decoder.NewJSONDecoder(strings.NewReader(`{}`)), &modeldecoder.Input{}, &batch)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "validation")
+	})
+}
+
 func TestDecodeMapToLogModel(t *testing.T) {
 	t.Run("log", func(t *testing.T) {
 		var input log
@@ -223,12 +266,14 @@ func TestDecodeMapToLogModel(t *testing.T) {
 		}
 		mapToLogModel(&input, &out)
 		assert.Equal(t, model.Labels{
-			"str":  {Value: "str"},
-			"bool": {Value: "true"},
+			"str": {Value: "str"},
 		}, out.Labels)
 		assert.Equal(t, model.NumericLabels{
 			"float":   {Value: 1.1},
 			"float64": {Value: 1.1},
 		}, out.NumericLabels)
+		assert.Equal(t, model.BooleanLabels{
+			"bool": {Value: true},
+		}, out.BooleanLabels)
 	})
 }
@@ -36,7 +36,7 @@ func isMetadataException(key string) bool {
 }
 
 func isIgnoredPrefix(key string) bool {
-	ignore := []string{"Labels", "NumericLabels", "GlobalLabels", "GlobalNumericLabels"}
+	ignore := []string{"Labels", "NumericLabels", "BooleanLabels", "GlobalLabels", "GlobalNumericLabels"}
 	for _, k := range ignore {
 		if strings.HasPrefix(key, k) {
 			return true
@@ -95,6 +95,7 @@ func isUnmappedMetadataField(key string) bool {
 		"Observer.Hostname",
 		"Observer.ID",
 		"Observer.Name",
+		"Observer.PipelineVersion",
 		"Observer.Type",
 		"Observer.Version",
 		"Observer.VersionMajor",
@@ -130,6 +131,7 @@ func isUnmappedMetadataField(key string) bool {
 		"Event.Dataset",
 		"Event.Severity",
 		"Event.Action",
+		"Event.Created",
 		"Log",
 		"Log.Level",
 		"Log.Logger",
@@ -217,12 +219,14 @@ func TestDecodeMetadata(t *testing.T) {
 				Agent:   model.Agent{Name: "go", Version: "1.0.0"},
 				Labels: model.Labels{
 					"a": {Global: true, Value: "b"},
-					"c": {Global: true, Value: "true"},
 				},
 				NumericLabels: model.NumericLabels{
 					"d": {Global: true, Value: float64(1234)},
 					"e": {Global: true, Value: float64(1234.11)},
 				},
+				BooleanLabels: model.BooleanLabels{
+					"c": {Global: true, Value: true},
+				},
 			}, out)
 
 			err := tc.decodeFn(decoder.NewJSONDecoder(strings.NewReader(`malformed`)), &out)
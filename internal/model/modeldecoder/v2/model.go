@@ -537,6 +537,9 @@ type metadataService struct {
 }
 
 type metadataServiceAgent struct {
+	// ConfigEtag holds the Etag of the central agent configuration
+	// most recently applied by the agent.
+	ConfigEtag nullable.String `json:"config_etag" validate:"maxLength=1024"`
 	// EphemeralID is a free format ID used for metrics correlation by agents
 	EphemeralID nullable.String `json:"ephemeral_id" validate:"maxLength=1024"`
 	// Name of the APM agent capturing information.
@@ -956,6 +959,8 @@ type transaction struct {
 	Links []spanLink `json:"links"`
 }
 
+// log represents a structured log line, captured by an APM agent in a
+// monitored service and correlated with traces.
 type log struct {
 	// Timestamp holds the recorded time of the event, UTC based and formatted
 	// as microseconds since Unix epoch
@@ -1094,6 +1099,9 @@ type transactionUserExperience struct {
 	// FirstInputDelay holds the First Input Delay (FID) metric value,
 	// or a negative value if FID is unknown. See https://web.dev/fid/
 	FirstInputDelay nullable.Float64 `json:"fid" validate:"min=0"`
+	// InteractionToNextPaint holds the Interaction to Next Paint (INP) metric
+	// value, or a negative value if INP is unknown. See https://web.dev/inp/
+	InteractionToNextPaint nullable.Float64 `json:"inp" validate:"min=0"`
 	// Longtask holds longtask duration/count metrics.
 	Longtask longtaskMetrics `json:"longtask"`
 	// TotalBlockingTime holds the Total Blocking Time (TBT) metric value,
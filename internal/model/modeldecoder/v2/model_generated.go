@@ -435,10 +435,11 @@ func (val *metadataService) processNestedSource() error {
 }
 
 func (val *metadataServiceAgent) IsSet() bool {
-	return val.EphemeralID.IsSet() || val.Name.IsSet() || val.Version.IsSet()
+	return val.ConfigEtag.IsSet() || val.EphemeralID.IsSet() || val.Name.IsSet() || val.Version.IsSet()
 }
 
 func (val *metadataServiceAgent) Reset() {
+	val.ConfigEtag.Reset()
 	val.EphemeralID.Reset()
 	val.Name.Reset()
 	val.Version.Reset()
@@ -448,6 +449,9 @@ func (val *metadataServiceAgent) validate() error {
 	if !val.IsSet() {
 		return nil
 	}
+	if val.ConfigEtag.IsSet() && utf8.RuneCountInString(val.ConfigEtag.Val) > 1024 {
+		return fmt.Errorf("'config_etag': validation rule 'maxLength(1024)' violated")
+	}
 	if val.EphemeralID.IsSet() && utf8.RuneCountInString(val.EphemeralID.Val) > 1024 {
 		return fmt.Errorf("'ephemeral_id': validation rule 'maxLength(1024)' violated")
 	}
@@ -2922,12 +2926,13 @@ func (val *transactionSpanCount) processNestedSource() error {
 }
 
 func (val *transactionUserExperience) IsSet() bool {
-	return val.CumulativeLayoutShift.IsSet() || val.FirstInputDelay.IsSet() || val.Longtask.IsSet() || val.TotalBlockingTime.IsSet()
+	return val.CumulativeLayoutShift.IsSet() || val.FirstInputDelay.IsSet() || val.InteractionToNextPaint.IsSet() || val.Longtask.IsSet() || val.TotalBlockingTime.IsSet()
 }
 
 func (val *transactionUserExperience) Reset() {
 	val.CumulativeLayoutShift.Reset()
 	val.FirstInputDelay.Reset()
+	val.InteractionToNextPaint.Reset()
 	val.Longtask.Reset()
 	val.TotalBlockingTime.Reset()
 }
@@ -2942,6 +2947,9 @@ func (val *transactionUserExperience) validate() error {
 	if val.FirstInputDelay.IsSet() && val.FirstInputDelay.Val < 0 {
 		return fmt.Errorf("'fid': validation rule 'min(0)' violated")
 	}
+	if val.InteractionToNextPaint.IsSet() && val.InteractionToNextPaint.Val < 0 {
+		return fmt.Errorf("'inp': validation rule 'min(0)' violated")
+	}
 	if err := val.Longtask.validate(); err != nil {
 		return errors.Wrapf(err, "longtask")
 	}
@@ -611,10 +611,6 @@ type setter interface {
 	Reset()
 }
 
-type validator interface {
-	validate() error
-}
-
 type testcase struct {
 	name     string
 	errorKey string
@@ -66,6 +66,29 @@ func TestDecodeNestedSpan(t *testing.T) {
 		assert.Contains(t, err.Error(), "decode")
 	})
 
+	t.Run("timestamp-policy", func(t *testing.T) {
+		now := time.Now()
+		str := `{"span":{"duration":100,"id":"a-b-c","name":"s","parent_id":"parent-123","trace_id":"trace-ab","type":"db","start":143}}`
+		decode := func(policy modeldecoder.TimestampPolicy) (model.Batch, error) {
+			input := modeldecoder.Input{Base: model.APMEvent{Timestamp: now}, TimestampPolicy: policy}
+			var batch model.Batch
+			err := DecodeNestedSpan(decoder.NewJSONDecoder(strings.NewReader(str)), &input, &batch)
+			return batch, err
+		}
+
+		batch, err := decode(modeldecoder.TimestampPolicyOffset)
+		require.NoError(t, err)
+		assert.Equal(t, now.Add(143*time.Millisecond), batch[0].Timestamp)
+
+		batch, err = decode(modeldecoder.TimestampPolicyReceiveTime)
+		require.NoError(t, err)
+		assert.Equal(t, now, batch[0].Timestamp)
+
+		batch, err = decode(modeldecoder.TimestampPolicyReject)
+		assert.ErrorContains(t, err, "validation error: missing timestamp")
+		assert.Empty(t, batch)
+	})
+
 	t.Run("validate", func(t *testing.T) {
 		var batch model.Batch
 		err := DecodeNestedSpan(decoder.NewJSONDecoder(strings.NewReader(`{}`)), &modeldecoder.Input{}, &batch)
@@ -484,12 +507,14 @@ func TestDecodeMapToSpanModel(t *testing.T) {
 		mapToSpanModel(&input, &out)
 		assert.Equal(t, model.Labels{
 			"a": {Value: "b"},
-			"e": {Value: "true"},
 		}, out.Labels)
 		assert.Equal(t, model.NumericLabels{
 			"c": {Value: float64(12315124131)},
 			"d": {Value: float64(12315124131.12315124131)},
 		}, out.NumericLabels)
+		assert.Equal(t, model.BooleanLabels{
+			"e": {Value: true},
+		}, out.BooleanLabels)
 	})
 
 	t.Run("links", func(t *testing.T) {
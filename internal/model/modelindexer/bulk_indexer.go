@@ -24,8 +24,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync/atomic"
+	"time"
 
 	jsoniter "github.com/json-iterator/go"
+	"github.com/klauspost/compress/zstd"
 	"github.com/pkg/errors"
 	"go.elastic.co/fastjson"
 
@@ -37,7 +40,21 @@ import (
 var (
 	esHeader   = http.Header{"X-Elastic-Product-Origin": []string{"observability"}}
 	gzipHeader = http.Header{"Content-Encoding": []string{"gzip"}, "X-Elastic-Product-Origin": []string{"observability"}}
-	newline    = []byte("\n")
+	zstdHeader = http.Header{
+		"Content-Encoding":         []string{"zstd"},
+		"Accept-Encoding":          []string{"zstd, gzip"},
+		"X-Elastic-Product-Origin": []string{"observability"},
+	}
+	newline = []byte("\n")
+
+	// bulkFilterPath limits bulk responses to the fields of each item
+	// that are used, reducing the cost of reading and parsing them.
+	bulkFilterPath = []string{"items.*.status", "items.*.error"}
+
+	// bulkFilterPathWithIndex additionally includes the index of each
+	// item, for identifying the data streams to roll over after mapping
+	// errors.
+	bulkFilterPathWithIndex = []string{"items.*._index", "items.*.status", "items.*.error"}
 )
 
 // NOTE(axw) please avoid introducing apm-server specific details to this code;
@@ -58,39 +75,121 @@ var (
 // maximum possible size, based on configuration and throughput.
 
 type bulkIndexer struct {
+	// firstAdded holds the time at which the first buffered item was
+	// added, in nanoseconds since the Unix epoch, or zero if there are
+	// no buffered items. It is accessed atomically, so it can be read
+	// concurrently with Add and Reset.
+	firstAdded int64
+
 	client       elasticsearch.Client
 	itemsAdded   int
 	bytesFlushed int
 	jsonw        fastjson.Writer
 	gzipw        *gzip.Writer
+	zstdw        *zstd.Encoder
+	zstdr        *zstd.Decoder
 	copybuf      [32 * 1024]byte
 	writer       io.Writer
 	buf          bytes.Buffer
 	respBuf      bytes.Buffer
 	resp         elasticsearch.BulkIndexerResponse
+	filterPath   []string
+
+	// uncompressedLen holds the number of buffered bytes before
+	// compression, which is what Elasticsearch compares against
+	// http.max_content_length.
+	uncompressedLen int
+
+	// compressionLevel holds the configured compression level, used for
+	// gzip if Elasticsearch does not accept zstd-compressed requests.
+	compressionLevel int
+
+	// callbacks holds the buffered items which have OnSuccess or
+	// OnFailure callbacks, along with their position in the request.
+	callbacks []itemCallbacks
+
+	// retainDocs, if true, makes Add retain a copy of each item's
+	// document in docs, so items may be inspected with Document, or
+	// taken with Take to be sent again. retained holds the buffered
+	// items, in request order.
+	retainDocs bool
+	docs       bytes.Buffer
+	retained   []retainedItem
 }
 
-func newBulkIndexer(client elasticsearch.Client, compressionLevel int) *bulkIndexer {
-	b := &bulkIndexer{client: client}
-	if compressionLevel != gzip.NoCompression {
-		b.gzipw, _ = gzip.NewWriterLevel(&b.buf, compressionLevel)
-		b.writer = b.gzipw
-	} else {
+type itemCallbacks struct {
+	position int
+	item     elasticsearch.BulkIndexerItem
+}
+
+type retainedItem struct {
+	item  elasticsearch.BulkIndexerItem
+	start int
+	end   int
+	taken bool
+}
+
+func newBulkIndexer(
+	client elasticsearch.Client,
+	compression string, compressionLevel int,
+	retainDocs bool, filterPath []string,
+) *bulkIndexer {
+	b := &bulkIndexer{
+		client:           client,
+		retainDocs:       retainDocs,
+		compressionLevel: compressionLevel,
+		filterPath:       filterPath,
+	}
+	switch {
+	case compressionLevel == gzip.NoCompression:
 		b.writer = &b.buf
+	case compression == CompressionZstd:
+		level := zstd.SpeedDefault
+		if compressionLevel > 0 {
+			level = zstd.EncoderLevelFromZstd(compressionLevel)
+		}
+		// Options are valid, so NewWriter cannot fail.
+		b.zstdw, _ = zstd.NewWriter(&b.buf,
+			zstd.WithEncoderLevel(level),
+			zstd.WithEncoderConcurrency(1),
+		)
+		b.writer = b.zstdw
+	default:
+		b.useGzip()
 	}
 	b.Reset()
 	return b
 }
 
+// useGzip makes b compress requests with gzip.
+func (b *bulkIndexer) useGzip() {
+	b.zstdw = nil
+	b.gzipw, _ = gzip.NewWriterLevel(&b.buf, b.compressionLevel)
+	b.writer = b.gzipw
+}
+
 // BulkIndexer resets b, ready for a new request.
 func (b *bulkIndexer) Reset() {
-	b.itemsAdded, b.bytesFlushed = 0, 0
+	b.itemsAdded, b.bytesFlushed, b.uncompressedLen = 0, 0, 0
+	atomic.StoreInt64(&b.firstAdded, 0)
 	b.buf.Reset()
 	if b.gzipw != nil {
 		b.gzipw.Reset(&b.buf)
 	}
+	if b.zstdw != nil {
+		b.zstdw.Reset(&b.buf)
+	}
 	b.respBuf.Reset()
 	b.resp = elasticsearch.BulkIndexerResponse{Items: b.resp.Items[:0]}
+	for i := range b.callbacks {
+		b.callbacks[i] = itemCallbacks{}
+	}
+	b.callbacks = b.callbacks[:0]
+	b.docs.Reset()
+	for i := range b.retained {
+		b.retained[i] = retainedItem{}
+	}
+	b.retained = b.retained[:0]
 }
 
 // Added returns the number of buffered items.
@@ -103,25 +202,134 @@ func (b *bulkIndexer) Len() int {
 	return b.buf.Len()
 }
 
+// UncompressedLen returns the number of buffered bytes before compression.
+func (b *bulkIndexer) UncompressedLen() int {
+	return b.uncompressedLen
+}
+
+// FirstAdded returns the time at which the first buffered item was added,
+// or the zero time if there are no buffered items. FirstAdded is safe for
+// concurrent use.
+func (b *bulkIndexer) FirstAdded() time.Time {
+	if nanos := atomic.LoadInt64(&b.firstAdded); nanos != 0 {
+		return time.Unix(0, nanos)
+	}
+	return time.Time{}
+}
+
 // BytesFlushed returns the number of bytes flushed by the bulk indexer.
 func (b *bulkIndexer) BytesFlushed() int {
 	return b.bytesFlushed
 }
 
+// ItemLen returns the number of bytes, before compression, that adding
+// item would add to the buffer.
+func (b *bulkIndexer) ItemLen(item elasticsearch.BulkIndexerItem) (int, error) {
+	b.encodeMeta(item)
+	n := b.jsonw.Size()
+	b.jsonw.Reset()
+
+	offset, err := item.Body.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	end, err := item.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := item.Body.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return n + int(end-offset) + len(newline), nil
+}
+
 // Add encodes an item in the buffer.
 func (b *bulkIndexer) Add(item elasticsearch.BulkIndexerItem) error {
-	b.writeMeta(item)
-	if _, err := io.CopyBuffer(b.writer, item.Body, b.copybuf[:]); err != nil {
+	b.uncompressedLen += b.writeMeta(item)
+	var n int64
+	var err error
+	if b.retainDocs {
+		n, err = b.writeRetained(item)
+	} else {
+		n, err = io.CopyBuffer(b.writer, item.Body, b.copybuf[:])
+	}
+	if err != nil {
 		return err
 	}
 	if _, err := b.writer.Write(newline); err != nil {
 		return err
 	}
+	b.uncompressedLen += int(n) + len(newline)
+	if item.OnSuccess != nil || item.OnFailure != nil {
+		// The body has been written, and must not be retained.
+		item.Body = nil
+		b.callbacks = append(b.callbacks, itemCallbacks{position: b.itemsAdded, item: item})
+	}
+	if b.itemsAdded == 0 {
+		atomic.StoreInt64(&b.firstAdded, time.Now().UnixNano())
+	}
 	b.itemsAdded++
 	return nil
 }
 
-func (b *bulkIndexer) writeMeta(item elasticsearch.BulkIndexerItem) {
+// writeRetained writes item's document to the buffer, retaining a copy
+// of it in b.docs. The item is retained as added, including its body.
+func (b *bulkIndexer) writeRetained(item elasticsearch.BulkIndexerItem) (int64, error) {
+	start := b.docs.Len()
+	n, err := b.docs.ReadFrom(item.Body)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := b.writer.Write(b.docs.Bytes()[start:]); err != nil {
+		return 0, err
+	}
+	b.retained = append(b.retained, retainedItem{
+		item:  item,
+		start: start,
+		end:   b.docs.Len(),
+	})
+	return n, nil
+}
+
+// Document returns the item at the given position in the request, as it
+// was added, and its document. The item's body has already been read, and
+// must not be read again; it is returned so callers can identify the kind
+// of body the item was added with. The document is only valid until b is
+// reset. Document returns false if documents are not retained.
+func (b *bulkIndexer) Document(position int) (elasticsearch.BulkIndexerItem, []byte, bool) {
+	if position >= len(b.retained) {
+		return elasticsearch.BulkIndexerItem{}, nil, false
+	}
+	r := &b.retained[position]
+	return r.item, b.docs.Bytes()[r.start:r.end], true
+}
+
+// Take returns the item at the given position in the request, as it was
+// added, and a copy of its document, for sending the item again in another
+// request. Take returns false if documents are not retained.
+//
+// The callbacks of taken items are not called by NotifyItems; the caller
+// is responsible for calling them.
+func (b *bulkIndexer) Take(position int) (elasticsearch.BulkIndexerItem, []byte, bool) {
+	if position >= len(b.retained) {
+		return elasticsearch.BulkIndexerItem{}, nil, false
+	}
+	r := &b.retained[position]
+	r.taken = true
+	doc := make([]byte, r.end-r.start)
+	copy(doc, b.docs.Bytes()[r.start:r.end])
+	return r.item, doc, true
+}
+
+func (b *bulkIndexer) writeMeta(item elasticsearch.BulkIndexerItem) int {
+	b.encodeMeta(item)
+	n := b.jsonw.Size()
+	b.writer.Write(b.jsonw.Bytes())
+	b.jsonw.Reset()
+	return n
+}
+
+func (b *bulkIndexer) encodeMeta(item elasticsearch.BulkIndexerItem) {
 	b.jsonw.RawByte('{')
 	b.jsonw.String(item.Action)
 	b.jsonw.RawString(":{")
@@ -136,9 +344,14 @@ func (b *bulkIndexer) writeMeta(item elasticsearch.BulkIndexerItem) {
 		b.jsonw.RawString(`"_index":`)
 		b.jsonw.String(item.Index)
 	}
+	if item.Pipeline != "" {
+		if item.DocumentID != "" || item.Index != "" {
+			b.jsonw.RawByte(',')
+		}
+		b.jsonw.RawString(`"pipeline":`)
+		b.jsonw.String(item.Pipeline)
+	}
 	b.jsonw.RawString("}}\n")
-	b.writer.Write(b.jsonw.Bytes())
-	b.jsonw.Reset()
 }
 
 // Flush executes a bulk request if there are any items buffered, and clears out the buffer.
@@ -153,10 +366,24 @@ func (b *bulkIndexer) Flush(ctx context.Context) (elasticsearch.BulkIndexerRespo
 			)
 		}
 	}
+	if b.zstdw != nil {
+		if err := b.zstdw.Close(); err != nil {
+			return elasticsearch.BulkIndexerResponse{}, fmt.Errorf(
+				"failed closing the zstd writer: %w", err,
+			)
+		}
+	}
 
-	req := esapi.BulkRequest{Body: &b.buf, Header: esHeader}
-	if b.gzipw != nil {
+	req := esapi.BulkRequest{
+		Body:       bytes.NewReader(b.buf.Bytes()),
+		Header:     esHeader,
+		FilterPath: b.filterPath,
+	}
+	switch {
+	case b.gzipw != nil:
 		req.Header = gzipHeader
+	case b.zstdw != nil:
+		req.Header = zstdHeader
 	}
 
 	bytesFlushed := b.buf.Len()
@@ -164,18 +391,35 @@ func (b *bulkIndexer) Flush(ctx context.Context) (elasticsearch.BulkIndexerRespo
 	if err != nil {
 		return elasticsearch.BulkIndexerResponse{}, err
 	}
+	if res.StatusCode == http.StatusUnsupportedMediaType && b.zstdw != nil {
+		// Elasticsearch does not accept zstd-compressed requests, so
+		// compress this and subsequent requests with gzip instead.
+		res.Body.Close()
+		if err := b.recompressGzip(); err != nil {
+			return elasticsearch.BulkIndexerResponse{}, err
+		}
+		req.Body = bytes.NewReader(b.buf.Bytes())
+		req.Header = gzipHeader
+		bytesFlushed = b.buf.Len()
+		if res, err = req.Do(ctx, b.client); err != nil {
+			return elasticsearch.BulkIndexerResponse{}, err
+		}
+	}
 	defer res.Body.Close()
 	// Record the number of flushed bytes only when err == nil. The body may
 	// not have been sent otherwise.
 	b.bytesFlushed = bytesFlushed
 	if res.IsError() {
-		if res.StatusCode == http.StatusTooManyRequests {
+		switch res.StatusCode {
+		case http.StatusTooManyRequests:
 			return elasticsearch.BulkIndexerResponse{}, errorTooManyRequests{res: res}
+		case http.StatusRequestEntityTooLarge:
+			return elasticsearch.BulkIndexerResponse{}, errorRequestEntityTooLarge{res: res}
 		}
 		return elasticsearch.BulkIndexerResponse{}, fmt.Errorf("flush failed: %s", res.String())
 	}
 
-	if _, err := b.respBuf.ReadFrom(res.Body); err != nil {
+	if err := b.readResponse(res); err != nil {
 		return elasticsearch.BulkIndexerResponse{}, err
 	}
 
@@ -198,9 +442,77 @@ func (b *bulkIndexer) Flush(ctx context.Context) (elasticsearch.BulkIndexerRespo
 			iter.Skip()
 		}
 	}
+	if !b.resp.HasErrors {
+		// "errors" is excluded by filter_path, so derive it from the items.
+		b.resp.HasErrors = hasItemErrors(b.resp)
+	}
 	return b.resp, errors.Wrap(iter.Error, "error decoding bulk response")
 }
 
+// hasItemErrors reports whether any of the items in resp failed.
+func hasItemErrors(resp elasticsearch.BulkIndexerResponse) bool {
+	for _, item := range resp.Items {
+		for _, info := range item {
+			if info.Error.Type != "" || info.Status > 201 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Deliver flushes the buffered items, notifies their callbacks of the
+// results, and returns the number of items indexed and failed. Failed
+// items are not retried.
+func (b *bulkIndexer) Deliver(ctx context.Context) (OutputResult, error) {
+	resp, err := b.Flush(ctx)
+	b.NotifyItems(ctx, resp, err)
+	result := OutputResult{BytesFlushed: int64(b.BytesFlushed())}
+	if err != nil {
+		return result, err
+	}
+	for _, item := range resp.Items {
+		for _, info := range item {
+			if info.Error.Type != "" || info.Status > 201 {
+				result.Failed++
+			} else {
+				result.Indexed++
+			}
+		}
+	}
+	return result, nil
+}
+
+// NotifyItems calls the OnSuccess or OnFailure callbacks of the buffered
+// items, given the results of Flush. If err is non-nil, the OnFailure
+// callback of every item is called with err. Items passed to Take are
+// skipped.
+func (b *bulkIndexer) NotifyItems(ctx context.Context, resp elasticsearch.BulkIndexerResponse, err error) {
+	for _, c := range b.callbacks {
+		if c.position < len(b.retained) && b.retained[c.position].taken {
+			continue
+		}
+		var info elasticsearch.BulkIndexerResponseItem
+		itemErr := err
+		if itemErr == nil {
+			if c.position < len(resp.Items) {
+				for _, item := range resp.Items[c.position] {
+					info = elasticsearch.BulkIndexerResponseItem(item)
+				}
+			} else {
+				itemErr = errors.New("item missing from bulk response")
+			}
+		}
+		if itemErr != nil || info.Error.Type != "" || info.Status > 201 {
+			if c.item.OnFailure != nil {
+				c.item.OnFailure(ctx, c.item, info, itemErr)
+			}
+		} else if c.item.OnSuccess != nil {
+			c.item.OnSuccess(ctx, c.item, info)
+		}
+	}
+}
+
 type errorTooManyRequests struct {
 	res *esapi.Response
 }
@@ -208,3 +520,133 @@ type errorTooManyRequests struct {
 func (e errorTooManyRequests) Error() string {
 	return fmt.Sprintf("flush failed: %s", e.res.String())
 }
+
+type errorRequestEntityTooLarge struct {
+	res *esapi.Response
+}
+
+func (e errorRequestEntityTooLarge) Error() string {
+	return fmt.Sprintf("flush failed: %s", e.res.String())
+}
+
+// SplitItems returns copies of the buffered items, for sending again in
+// smaller requests after Elasticsearch rejected the request as too large.
+// The callbacks of the buffered items are carried over to the returned
+// items, and are not called by NotifyItems.
+//
+// SplitItems must only be called after Flush. The buffered request body is
+// decompressed to recover the documents, so they need not be retained.
+func (b *bulkIndexer) SplitItems() ([]elasticsearch.BulkIndexerItem, error) {
+	var body io.Reader = bytes.NewReader(b.buf.Bytes())
+	switch {
+	case b.gzipw != nil:
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed decompressing gzip request: %w", err)
+		}
+		defer r.Close()
+		body = r
+	case b.zstdw != nil:
+		if err := b.zstdDecoder(body); err != nil {
+			return nil, err
+		}
+		body = b.zstdr
+	}
+	var uncompressed bytes.Buffer
+	if _, err := uncompressed.ReadFrom(body); err != nil {
+		return nil, fmt.Errorf("failed decompressing request: %w", err)
+	}
+
+	items := make([]elasticsearch.BulkIndexerItem, 0, b.itemsAdded)
+	lines := bytes.Split(bytes.TrimSuffix(uncompressed.Bytes(), newline), newline)
+	for len(lines) >= 2 && len(items) < b.itemsAdded {
+		var meta map[string]struct {
+			Index    string `json:"_index"`
+			ID       string `json:"_id"`
+			Pipeline string `json:"pipeline"`
+		}
+		if err := jsoniter.ConfigFastest.Unmarshal(lines[0], &meta); err != nil {
+			return nil, fmt.Errorf("failed decoding bulk action: %w", err)
+		}
+		doc := make([]byte, len(lines[1]))
+		copy(doc, lines[1])
+		var item elasticsearch.BulkIndexerItem
+		for action, meta := range meta {
+			item.Action = action
+			item.Index = meta.Index
+			item.DocumentID = meta.ID
+			item.Pipeline = meta.Pipeline
+		}
+		item.Body = bytes.NewReader(doc)
+		items = append(items, item)
+		lines = lines[2:]
+	}
+	if len(items) != b.itemsAdded {
+		return nil, fmt.Errorf("expected %d items in request, found %d", b.itemsAdded, len(items))
+	}
+	for k, c := range b.callbacks {
+		items[c.position].OnSuccess = c.item.OnSuccess
+		items[c.position].OnFailure = c.item.OnFailure
+		b.callbacks[k] = itemCallbacks{}
+	}
+	b.callbacks = b.callbacks[:0]
+	return items, nil
+}
+
+// readResponse reads the body of res into b.respBuf, decompressing it
+// if Elasticsearch compressed it as requested by zstdHeader.
+func (b *bulkIndexer) readResponse(res *esapi.Response) error {
+	var body io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "zstd":
+		if err := b.zstdDecoder(res.Body); err != nil {
+			return err
+		}
+		body = b.zstdr
+	case "gzip":
+		r, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return fmt.Errorf("failed decompressing bulk response: %w", err)
+		}
+		defer r.Close()
+		body = r
+	}
+	if _, err := b.respBuf.ReadFrom(body); err != nil {
+		return err
+	}
+	return nil
+}
+
+// recompressGzip replaces the zstd-compressed request body buffered in
+// b.buf with a gzip-compressed one, and makes b use gzip from then on.
+func (b *bulkIndexer) recompressGzip() error {
+	if err := b.zstdDecoder(bytes.NewReader(b.buf.Bytes())); err != nil {
+		return err
+	}
+	var uncompressed bytes.Buffer
+	if _, err := uncompressed.ReadFrom(b.zstdr); err != nil {
+		return fmt.Errorf("failed decompressing zstd request: %w", err)
+	}
+	b.buf.Reset()
+	b.useGzip()
+	if _, err := b.gzipw.Write(uncompressed.Bytes()); err != nil {
+		return err
+	}
+	if err := b.gzipw.Close(); err != nil {
+		return fmt.Errorf("failed closing the gzip writer: %w", err)
+	}
+	return nil
+}
+
+// zstdDecoder resets b.zstdr to read from r, creating it if necessary.
+func (b *bulkIndexer) zstdDecoder(r io.Reader) error {
+	if b.zstdr == nil {
+		zstdr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		b.zstdr = zstdr
+		return nil
+	}
+	return b.zstdr.Reset(r)
+}
@@ -0,0 +1,124 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// checkpoint tracks the events of a batch passed to ProcessBatch when
+// Config.WaitForIndexing is enabled. The bulk item of each event holds
+// callbacks which record the outcome of indexing the event, and the
+// checkpoint is reached once all of them have been called.
+type checkpoint struct {
+	mu      sync.Mutex
+	pending int
+	events  int
+	failed  int
+	err     error
+	done    chan struct{}
+}
+
+func newCheckpoint() *checkpoint {
+	// pending starts at one, and is only decremented by wait once all
+	// events have been added, so the checkpoint cannot be reached while
+	// events are still being added.
+	return &checkpoint{pending: 1, done: make(chan struct{})}
+}
+
+// track sets callbacks on item for recording the outcome of indexing it.
+func (c *checkpoint) track(item *elasticsearch.BulkIndexerItem) {
+	c.mu.Lock()
+	c.pending++
+	c.events++
+	c.mu.Unlock()
+	item.OnSuccess = func(context.Context, elasticsearch.BulkIndexerItem, elasticsearch.BulkIndexerResponseItem) {
+		c.release(nil)
+	}
+	item.OnFailure = func(_ context.Context, _ elasticsearch.BulkIndexerItem, info elasticsearch.BulkIndexerResponseItem, err error) {
+		if err == nil {
+			err = fmt.Errorf("%s: %s", info.Error.Type, info.Error.Reason)
+		}
+		c.release(err)
+	}
+}
+
+// untrack reverses the effect of track, for items that could not be added.
+func (c *checkpoint) untrack(item *elasticsearch.BulkIndexerItem) {
+	item.OnSuccess, item.OnFailure = nil, nil
+	c.mu.Lock()
+	c.events--
+	c.mu.Unlock()
+	c.release(nil)
+}
+
+func (c *checkpoint) release(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		c.failed++
+		if c.err == nil {
+			c.err = err
+		}
+	}
+	if c.pending--; c.pending == 0 {
+		close(c.done)
+	}
+}
+
+// wait waits until all tracked events have been flushed, or ctx is done.
+// If any of the events could not be indexed, wait returns an error
+// matching ErrIndexingFailed.
+func (c *checkpoint) wait(ctx context.Context) error {
+	c.release(nil)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.done:
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failed > 0 {
+		return &indexingError{failed: c.failed, events: c.events, err: c.err}
+	}
+	return nil
+}
+
+// indexingError is returned by checkpoint.wait when events could not
+// be indexed. It matches ErrIndexingFailed, and holds the first error.
+type indexingError struct {
+	failed int
+	events int
+	err    error
+}
+
+func (e *indexingError) Error() string {
+	return fmt.Sprintf("%s: %d of %d events: %s", ErrIndexingFailed, e.failed, e.events, e.err)
+}
+
+func (e *indexingError) Unwrap() error {
+	return e.err
+}
+
+func (e *indexingError) Is(target error) bool {
+	return target == ErrIndexingFailed
+}
@@ -0,0 +1,119 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+// CircuitBreakerConfig holds configuration for pausing bulk requests while
+// Elasticsearch is unavailable.
+type CircuitBreakerConfig struct {
+	// Threshold holds the number of consecutive failed bulk requests after
+	// which the circuit breaker opens. While the circuit breaker is open,
+	// flushes are paused and ProcessBatch returns ErrUnavailable.
+	//
+	// If Threshold is zero, the circuit breaker is disabled.
+	Threshold int
+
+	// Backoff holds the amount of time for which the circuit breaker stays
+	// open. Once Backoff elapses, paused flushes are resumed; if the next
+	// bulk request also fails, the circuit breaker opens again.
+	//
+	// If Backoff is zero, the default of 30 seconds will be used.
+	Backoff time.Duration
+}
+
+// circuitBreaker tracks consecutive bulk request failures, opening after
+// the configured threshold is reached.
+//
+// Bulk requests rejected with 429 Too Many Requests or 413 Request Entity
+// Too Large do not count as failures, as they indicate that the cluster is
+// available.
+type circuitBreaker struct {
+	config CircuitBreakerConfig
+	logger *logp.Logger
+
+	// openUntil holds the time, in Unix nanoseconds, until which the
+	// circuit breaker is open.
+	openUntil int64
+	opened    int64
+
+	mu       sync.Mutex
+	failures int
+}
+
+func newCircuitBreaker(cfg CircuitBreakerConfig, logger *logp.Logger) *circuitBreaker {
+	return &circuitBreaker{config: cfg, logger: logger}
+}
+
+// isOpen reports whether the circuit breaker is open at the given time.
+func (c *circuitBreaker) isOpen(now time.Time) bool {
+	return now.UnixNano() < atomic.LoadInt64(&c.openUntil)
+}
+
+// wait blocks while the circuit breaker is open, returning ctx.Err() if
+// ctx is done first.
+func (c *circuitBreaker) wait(ctx context.Context) error {
+	for {
+		d := time.Duration(atomic.LoadInt64(&c.openUntil) - time.Now().UnixNano())
+		if d <= 0 {
+			return nil
+		}
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// recordFlush records the result of a bulk request flush, opening the
+// circuit breaker if the threshold of consecutive failures is reached.
+func (c *circuitBreaker) recordFlush(err error, now time.Time) {
+	if errors.Is(err, context.Canceled) {
+		// The indexer is being closed.
+		return
+	}
+	var errTooMany errorTooManyRequests
+	var errTooLarge errorRequestEntityTooLarge
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil || errors.As(err, &errTooMany) || errors.As(err, &errTooLarge) {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures < c.config.Threshold || c.isOpen(now) {
+		return
+	}
+	atomic.StoreInt64(&c.openUntil, now.Add(c.config.Backoff).UnixNano())
+	atomic.AddInt64(&c.opened, 1)
+	c.logger.Errorf(
+		"%d consecutive bulk requests failed, pausing bulk requests for %s: %v",
+		c.failures, c.config.Backoff, err,
+	)
+}
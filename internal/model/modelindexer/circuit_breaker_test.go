@@ -0,0 +1,97 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestModelIndexerCircuitBreaker(t *testing.T) {
+	var healthy, requests int64
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if atomic.LoadInt64(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Millisecond,
+		CircuitBreaker: modelindexer.CircuitBreakerConfig{
+			Threshold: 2,
+			Backoff:   200 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	waitFor := func(cond func() bool, msg string) {
+		timeout := time.After(10 * time.Second)
+		for !cond() {
+			select {
+			case <-time.After(time.Millisecond):
+			case <-timeout:
+				t.Fatal(msg)
+			}
+		}
+	}
+
+	// The circuit breaker opens after 2 consecutive failures.
+	for n := int64(1); n <= 2; n++ {
+		require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+		waitFor(func() bool { return atomic.LoadInt64(&requests) == n }, "timed out waiting for bulk request")
+	}
+	waitFor(func() bool {
+		return indexer.Stats().CircuitBreaker.Open == 1
+	}, "timed out waiting for circuit breaker to open")
+	assert.Equal(t, modelindexer.CircuitBreakerStats{Open: 1, Opened: 1}, indexer.Stats().CircuitBreaker)
+
+	// While the circuit breaker is open, events are rejected.
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	assert.ErrorIs(t, err, modelindexer.ErrUnavailable)
+
+	// Once the backoff elapses, events are accepted again, and
+	// a successful bulk request keeps the circuit breaker closed.
+	atomic.StoreInt64(&healthy, 1)
+	waitFor(func() bool {
+		return indexer.Stats().CircuitBreaker.Open == 0
+	}, "timed out waiting for circuit breaker to close")
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	waitFor(func() bool { return indexer.Stats().Indexed == 1 }, "timed out waiting for event to be indexed")
+	assert.Equal(t, modelindexer.CircuitBreakerStats{Open: 0, Opened: 1}, indexer.Stats().CircuitBreaker)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&requests))
+}
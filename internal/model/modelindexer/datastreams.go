@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// DataStreamsConfig holds configuration for creating data streams before
+// indexing events into them.
+//
+// By default, data streams are created by Elasticsearch when the first
+// document is indexed, which requires the cluster to permit automatic
+// index creation. Some hardened clusters disable this with the
+// "action.auto_create_index" setting.
+type DataStreamsConfig struct {
+	// Create controls whether data streams are explicitly created, using
+	// the matching index templates, the first time an event is indexed
+	// into them. Creating data streams requires the "create_index"
+	// privilege for the data streams.
+	//
+	// If a data stream cannot be created, events are still indexed, and
+	// creation is retried after RetryInterval.
+	//
+	// Data streams are not created by default.
+	Create bool
+
+	// RetryInterval holds the minimum amount of time between attempts to
+	// create a data stream after a failed attempt.
+	//
+	// If RetryInterval is zero, the default of 1 minute will be used.
+	RetryInterval time.Duration
+}
+
+// dataStreamCreator creates data streams the first time they are used,
+// caching the data streams which are known to exist.
+type dataStreamCreator struct {
+	client elasticsearch.Client
+	config DataStreamsConfig
+	logger *logp.Logger
+
+	created int64
+	failed  int64
+
+	mu          sync.RWMutex
+	dataStreams map[string]*dataStreamState
+}
+
+type dataStreamState struct {
+	exists int32 // accessed atomically
+
+	mu         sync.Mutex
+	retryAfter time.Time
+}
+
+func newDataStreamCreator(client elasticsearch.Client, cfg DataStreamsConfig, logger *logp.Logger) *dataStreamCreator {
+	return &dataStreamCreator{
+		client:      client,
+		config:      cfg,
+		logger:      logger,
+		dataStreams: make(map[string]*dataStreamState),
+	}
+}
+
+// ensure creates the named data stream if it is not already known to exist.
+// Failure to create the data stream is logged, and does not prevent events
+// from being indexed.
+func (c *dataStreamCreator) ensure(ctx context.Context, dataStream string) {
+	state := c.state(dataStream)
+	if atomic.LoadInt32(&state.exists) == 1 {
+		return
+	}
+
+	// Hold the lock while creating the data stream, so concurrent
+	// events for the same data stream wait for it to be created.
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	now := time.Now()
+	if atomic.LoadInt32(&state.exists) == 1 || now.Before(state.retryAfter) {
+		return
+	}
+	exists, err := c.create(ctx, dataStream)
+	if err != nil {
+		state.retryAfter = now.Add(c.config.RetryInterval)
+		atomic.AddInt64(&c.failed, 1)
+		c.logger.Errorf("failed to create data stream %q: %v", dataStream, err)
+		return
+	}
+	atomic.StoreInt32(&state.exists, 1)
+	if !exists {
+		atomic.AddInt64(&c.created, 1)
+		c.logger.Infof("created data stream %q", dataStream)
+	}
+}
+
+func (c *dataStreamCreator) state(dataStream string) *dataStreamState {
+	c.mu.RLock()
+	state, ok := c.dataStreams[dataStream]
+	c.mu.RUnlock()
+	if ok {
+		return state
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.dataStreams[dataStream]; ok {
+		return state
+	}
+	state = &dataStreamState{}
+	c.dataStreams[dataStream] = state
+	return state
+}
+
+// create creates the named data stream, returning true if the data
+// stream already exists.
+func (c *dataStreamCreator) create(ctx context.Context, dataStream string) (exists bool, err error) {
+	resp, err := esapi.IndicesCreateDataStreamRequest{Name: dataStream}.Do(ctx, c.client)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if !resp.IsError() {
+		return false, nil
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "resource_already_exists_exception") {
+		return true, nil
+	}
+	return false, errors.New(resp.Status() + " " + string(body))
+}
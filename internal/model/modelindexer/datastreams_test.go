@@ -0,0 +1,113 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestModelIndexerCreateDataStreams(t *testing.T) {
+	var mu sync.Mutex
+	var created []string
+	var indexed int
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/_data_stream/") {
+			mu.Lock()
+			defer mu.Unlock()
+			name := strings.TrimPrefix(r.URL.Path, "/_data_stream/")
+			created = append(created, name)
+			switch name {
+			case "logs-apm_server-existing":
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":{"type":"resource_already_exists_exception"},"status":400}`))
+			case "logs-apm_server-forbidden":
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte(`{"error":{"type":"security_exception"},"status":403}`))
+			default:
+				w.Write([]byte(`{"acknowledged":true}`))
+			}
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	modelindexertest.HandleBulk(mux, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		mu.Lock()
+		indexed += len(result.Items)
+		mu.Unlock()
+		json.NewEncoder(w).Encode(result)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	config := elasticsearch.DefaultConfig()
+	config.Hosts = elasticsearch.Hosts{srv.URL}
+	config.Backoff.Max = time.Nanosecond
+	client, err := elasticsearch.NewClient(config)
+	require.NoError(t, err)
+
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		DataStreams: modelindexer.DataStreamsConfig{
+			Create:        true,
+			RetryInterval: time.Hour,
+		},
+	})
+	require.NoError(t, err)
+
+	var batch model.Batch
+	for _, namespace := range []string{"new", "existing", "forbidden"} {
+		for i := 0; i < 2; i++ {
+			batch = append(batch, model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: namespace,
+			}})
+		}
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	// Each data stream should be created at most once, and events should
+	// be indexed even when a data stream could not be created.
+	assert.Equal(t, []string{
+		"logs-apm_server-new",
+		"logs-apm_server-existing",
+		"logs-apm_server-forbidden",
+	}, created)
+	assert.Equal(t, 12, indexed)
+	stats := indexer.Stats()
+	assert.Equal(t, modelindexer.DataStreamStats{Created: 1, Failed: 1}, stats.DataStreams)
+}
@@ -0,0 +1,139 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// DeadLetterConfig holds configuration for indexing documents which
+// Elasticsearch permanently rejects, e.g. due to mapping errors, into
+// a dead letter index so they can be inspected and reprocessed.
+type DeadLetterConfig struct {
+	// Index holds the name of the index or data stream into which rejected
+	// documents are indexed, e.g. "logs-apm.dlq-default". Each dead letter
+	// document holds the original document in event.original, and the
+	// reason it was rejected in error.type and error.message.
+	//
+	// If Index is empty, rejected documents are counted as failed and
+	// logged, but not indexed elsewhere.
+	Index string
+}
+
+// DeadLetterStats holds statistics for documents indexed into the dead
+// letter index.
+type DeadLetterStats struct {
+	// Indexed holds the number of rejected documents indexed into the
+	// dead letter index.
+	Indexed int64
+
+	// Failed holds the number of rejected documents which could not be
+	// indexed into the dead letter index either.
+	Failed int64
+}
+
+// isDeadLetterStatus reports whether a bulk response item with the given
+// status will never succeed if the document is sent again unmodified:
+// any 4xx status other than 429 Too Many Requests.
+func isDeadLetterStatus(status int) bool {
+	return status >= 400 && status < 500 && status != http.StatusTooManyRequests
+}
+
+// deadLetterBody is the body of a bulk item holding a dead letter document.
+type deadLetterBody struct {
+	*bytes.Reader
+}
+
+// newDeadLetterItem returns a bulk item for indexing a dead letter document
+// into index, recording that the document doc of the original item was
+// rejected with the given response.
+func newDeadLetterItem(
+	index string,
+	original elasticsearch.BulkIndexerItem,
+	doc []byte,
+	info elasticsearch.BulkIndexerResponseItem,
+	now time.Time,
+) elasticsearch.BulkIndexerItem {
+	var w fastjson.Writer
+	w.RawString(`{"@timestamp":"`)
+	w.Time(now.UTC(), timestampFormat)
+	w.RawByte('"')
+	if typ, dataset, namespace, ok := splitDataStreamName(index); ok {
+		w.RawString(`,"data_stream":{"type":`)
+		w.String(typ)
+		w.RawString(`,"dataset":`)
+		w.String(dataset)
+		w.RawString(`,"namespace":`)
+		w.String(namespace)
+		w.RawByte('}')
+	}
+	w.RawString(`,"event":{"original":`)
+	w.String(string(doc))
+	w.RawString(`},"error":{"type":`)
+	w.String(info.Error.Type)
+	w.RawString(`,"message":`)
+	w.String(info.Error.Reason)
+	w.RawString(`},"dead_letter":{"index":`)
+	w.String(original.Index)
+	w.RawString(`,"status":`)
+	w.Int64(int64(info.Status))
+	w.RawString(`}}`)
+	return elasticsearch.BulkIndexerItem{
+		Index:  index,
+		Action: "create",
+		Body:   &deadLetterBody{Reader: bytes.NewReader(w.Bytes())},
+	}
+}
+
+// splitDataStreamName splits a data stream name of the form
+// "<type>-<dataset>-<namespace>" into its components.
+func splitDataStreamName(name string) (typ, dataset, namespace string, ok bool) {
+	i := strings.IndexByte(name, '-')
+	j := strings.LastIndexByte(name, '-')
+	if i <= 0 || j <= i+1 || j == len(name)-1 {
+		return "", "", "", false
+	}
+	return name[:i], name[i+1 : j], name[j+1:], true
+}
+
+// deadLetterItems enqueues dead letter items to be added to a later bulk
+// request. If the Indexer is closed first, they are counted as failed.
+func (i *Indexer) deadLetterItems(items []elasticsearch.BulkIndexerItem) {
+	atomic.AddInt64(&i.eventsActive, int64(len(items)))
+	i.errgroup.Go(func() error {
+		// Dead letter documents have no trace ID; when ordering
+		// by trace, they are distributed across partitions.
+		remaining := i.requeueItems(items, func(elasticsearch.BulkIndexerItem) chan<- elasticsearch.BulkIndexerItem {
+			return i.bulkItemsChannel(&model.APMEvent{})
+		})
+		if n := int64(len(remaining)); n > 0 {
+			atomic.AddInt64(&i.eventsActive, -n)
+			atomic.AddInt64(&i.deadLetterFailed, n)
+		}
+		return nil
+	})
+}
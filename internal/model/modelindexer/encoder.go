@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/beats/v7/libbeat/beat"
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	// DefaultEncoder holds the name of the Encoder used by Indexer
+	// when Config.Encoder is empty.
+	DefaultEncoder = "fastjson"
+
+	// JSONEncoder holds the name of an Encoder which encodes field
+	// values with encoding/json. It is slower than DefaultEncoder,
+	// and is intended for debugging encoding issues.
+	JSONEncoder = "json"
+
+	// DirectEncoder holds the name of an experimental Encoder which
+	// encodes events without first converting them to beat.Events,
+	// avoiding the allocation of a map for each event's top-level
+	// fields. This benefits small events such as logs the most, as
+	// the top-level map makes up a larger share of their cost.
+	DirectEncoder = "direct"
+)
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = make(map[string]Encoder)
+)
+
+func init() {
+	RegisterEncoder(DefaultEncoder, EncoderFunc(encodeFastJSON))
+	RegisterEncoder(JSONEncoder, EncoderFunc(encodeJSON))
+	RegisterEncoder(DirectEncoder, EncoderFunc(encodeDirect))
+}
+
+// Encoder encodes events as Elasticsearch documents.
+type Encoder interface {
+	// Encode encodes event as a JSON document, writing it to out.
+	Encode(event *model.APMEvent, out *fastjson.Writer) error
+}
+
+// EncoderFunc is a function type that implements Encoder.
+type EncoderFunc func(*model.APMEvent, *fastjson.Writer) error
+
+// Encode calls f(event, out).
+func (f EncoderFunc) Encode(event *model.APMEvent, out *fastjson.Writer) error {
+	return f(event, out)
+}
+
+// RegisterEncoder registers an Encoder with the given name, which may then
+// be selected with Config.Encoder.
+//
+// RegisterEncoder panics if encoder is nil, or if an Encoder has already
+// been registered with the same name. It is intended to be called from
+// package init functions.
+func RegisterEncoder(name string, encoder Encoder) {
+	if encoder == nil {
+		panic("modelindexer: RegisterEncoder encoder is nil")
+	}
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if _, ok := encoders[name]; ok {
+		panic(fmt.Sprintf("modelindexer: RegisterEncoder called twice for encoder %q", name))
+	}
+	encoders[name] = encoder
+}
+
+// Encoders returns the sorted names of the registered encoders.
+func Encoders() []string {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	names := make([]string, 0, len(encoders))
+	for name := range encoders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupEncoder returns the Encoder registered with the given name. If name
+// is empty, the Encoder registered as DefaultEncoder is returned.
+func LookupEncoder(name string) (Encoder, error) {
+	if name == "" {
+		name = DefaultEncoder
+	}
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	encoder, ok := encoders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown encoder %q", name)
+	}
+	return encoder, nil
+}
+
+func encodeFastJSON(event *model.APMEvent, out *fastjson.Writer) error {
+	return encodeBeatEvent(event.BeatEvent(), out)
+}
+
+func encodeJSON(event *model.APMEvent, out *fastjson.Writer) error {
+	beatEvent := event.BeatEvent()
+	out.RawByte('{')
+	out.RawString(`"@timestamp":"`)
+	out.Time(beatEvent.Timestamp, timestampFormat)
+	out.RawByte('"')
+	for k, v := range beatEvent.Fields {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		out.RawByte(',')
+		out.String(k)
+		out.RawByte(':')
+		out.RawBytes(data)
+	}
+	out.RawByte('}')
+	return nil
+}
+
+func encodeDirect(event *model.APMEvent, out *fastjson.Writer) error {
+	var err error
+	out.RawByte('{')
+	out.RawString(`"@timestamp":"`)
+	out.Time(event.Timestamp, timestampFormat)
+	out.RawByte('"')
+	event.VisitFields(func(k string, v interface{}) {
+		if err != nil {
+			return
+		}
+		out.RawByte(',')
+		out.String(k)
+		out.RawByte(':')
+		err = encodeAny(v, out)
+	})
+	out.RawByte('}')
+	return err
+}
+
+func encodeBeatEvent(in beat.Event, out *fastjson.Writer) error {
+	out.RawByte('{')
+	out.RawString(`"@timestamp":"`)
+	out.Time(in.Timestamp, timestampFormat)
+	out.RawByte('"')
+	for k, v := range in.Fields {
+		out.RawByte(',')
+		out.String(k)
+		out.RawByte(':')
+		if err := encodeAny(v, out); err != nil {
+			return err
+		}
+	}
+	out.RawByte('}')
+	return nil
+}
+
+func encodeAny(v interface{}, out *fastjson.Writer) error {
+	switch v := v.(type) {
+	case mapstr.M:
+		return encodeMap(v, out)
+	case map[string]interface{}:
+		return encodeMap(v, out)
+	default:
+		return fastjson.Marshal(out, v)
+	}
+}
+
+func encodeMap(v map[string]interface{}, out *fastjson.Writer) error {
+	out.RawByte('{')
+	first := true
+	for k, v := range v {
+		if first {
+			first = false
+		} else {
+			out.RawByte(',')
+		}
+		out.String(k)
+		out.RawByte(':')
+		if err := encodeAny(v, out); err != nil {
+			return err
+		}
+	}
+	out.RawByte('}')
+	return nil
+}
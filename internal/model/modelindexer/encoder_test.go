@@ -0,0 +1,156 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/fastjson"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestEncoders(t *testing.T) {
+	builtin := []string{"direct", "fastjson", "json"}
+	assert.Subset(t, modelindexer.Encoders(), builtin)
+
+	defaultEncoder, err := modelindexer.LookupEncoder("")
+	require.NoError(t, err)
+	for _, name := range builtin {
+		encoder, err := modelindexer.LookupEncoder(name)
+		require.NoError(t, err)
+		for _, event := range encoderTestEvents() {
+			var expected, actual fastjson.Writer
+			require.NoError(t, defaultEncoder.Encode(&event, &expected))
+			require.NoError(t, encoder.Encode(&event, &actual))
+
+			var expectedDoc, actualDoc map[string]interface{}
+			require.NoError(t, json.Unmarshal(expected.Bytes(), &expectedDoc))
+			require.NoError(t, json.Unmarshal(actual.Bytes(), &actualDoc))
+			assert.Equal(t, expectedDoc, actualDoc, name)
+		}
+	}
+}
+
+func TestLookupEncoderUnknown(t *testing.T) {
+	_, err := modelindexer.LookupEncoder("unknown")
+	assert.EqualError(t, err, `unknown encoder "unknown"`)
+
+	client := modelindexertest.NewMockElasticsearchClient(t, nil)
+	_, err = modelindexer.New(client, modelindexer.Config{Encoder: "unknown"})
+	assert.EqualError(t, err, `unknown encoder "unknown"`)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	encoder := modelindexer.EncoderFunc(func(event *model.APMEvent, out *fastjson.Writer) error {
+		out.RawString(`{"message":"custom"}`)
+		return nil
+	})
+	name := "custom-" + uuid.Must(uuid.NewV4()).String()
+	modelindexer.RegisterEncoder(name, encoder)
+	assert.Contains(t, modelindexer.Encoders(), name)
+	assert.Panics(t, func() { modelindexer.RegisterEncoder(name, encoder) })
+	assert.Panics(t, func() { modelindexer.RegisterEncoder("nil", nil) })
+
+	registered, err := modelindexer.LookupEncoder(name)
+	require.NoError(t, err)
+	var w fastjson.Writer
+	require.NoError(t, registered.Encode(&model.APMEvent{}, &w))
+	assert.Equal(t, `{"message":"custom"}`, string(w.Bytes()))
+}
+
+func BenchmarkEncoder(b *testing.B) {
+	for _, name := range []string{"fastjson", "json", "direct"} {
+		encoder, err := modelindexer.LookupEncoder(name)
+		require.NoError(b, err)
+		b.Run(name, func(b *testing.B) {
+			b.Run("log", func(b *testing.B) {
+				benchmarkEncoder(b, encoder, encoderTestLogEvent())
+			})
+			b.Run("transaction", func(b *testing.B) {
+				benchmarkEncoder(b, encoder, encoderTestEvents()[1])
+			})
+		})
+	}
+}
+
+func benchmarkEncoder(b *testing.B, encoder modelindexer.Encoder, event model.APMEvent) {
+	b.ReportAllocs()
+	var w fastjson.Writer
+	for i := 0; i < b.N; i++ {
+		if err := encoder.Encode(&event, &w); err != nil {
+			b.Fatal(err)
+		}
+		w.Reset()
+	}
+}
+
+func encoderTestLogEvent() model.APMEvent {
+	return model.APMEvent{
+		Timestamp: time.Unix(1, 2).UTC(),
+		DataStream: model.DataStream{
+			Type:      "logs",
+			Dataset:   "apm.app",
+			Namespace: "default",
+		},
+		Processor: model.LogProcessor,
+		Agent:     model.Agent{Name: "go", Version: "1.0.0"},
+		Service:   model.Service{Name: "opbeans", Environment: "production"},
+		Host:      model.Host{Hostname: "host1"},
+		Labels:    model.Labels{"cluster": {Value: "eu-1"}},
+		Log:       model.Log{Level: "info", Logger: "main"},
+		Message:   "request handled",
+	}
+}
+
+func encoderTestEvents() []model.APMEvent {
+	logEvent := encoderTestLogEvent()
+	transaction := model.APMEvent{
+		Timestamp:     time.Unix(1, 2).UTC(),
+		Processor:     model.TransactionProcessor,
+		Trace:         model.Trace{ID: "trace_id"},
+		Service:       model.Service{Name: "opbeans"},
+		NumericLabels: model.NumericLabels{"count": {Value: 1.5}},
+		BooleanLabels: model.BooleanLabels{"flag": {Value: true}},
+		Transaction: &model.Transaction{
+			ID:      "transaction_id",
+			Name:    "GET /",
+			Type:    "request",
+			Sampled: true,
+		},
+	}
+	metricset := model.APMEvent{
+		Timestamp: time.Unix(1, 2).UTC(),
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name: "app",
+			Samples: []model.MetricsetSample{
+				{Name: "system.memory.total", Type: model.MetricTypeGauge, Value: 1024},
+				{Name: "requests", Type: model.MetricTypeCounter, Unit: "1", Value: 10},
+			},
+		},
+	}
+	return []model.APMEvent{logEvent, transaction, metricset, {}}
+}
@@ -0,0 +1,184 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// FailoverConfig holds configuration for routing bulk requests to a warm
+// standby Elasticsearch cluster while the primary cluster is unavailable.
+type FailoverConfig struct {
+	// Client holds the elasticsearch.Client for the standby cluster.
+	//
+	// If Client is nil, failover is disabled.
+	Client elasticsearch.Client
+
+	// Threshold holds the amount of time bulk requests to the primary
+	// cluster must have been failing before traffic is routed to the
+	// standby cluster.
+	//
+	// If Threshold is zero, the default of 1 minute will be used.
+	Threshold time.Duration
+
+	// ProbeInterval holds the interval at which the primary cluster is
+	// probed while traffic is being routed to the standby cluster. Once
+	// the primary cluster responds successfully, traffic is routed back
+	// to it.
+	//
+	// If ProbeInterval is zero, the default of 10 seconds will be used.
+	ProbeInterval time.Duration
+}
+
+// failoverClient is an elasticsearch.Client which routes requests to either
+// a primary or a standby client.
+//
+// Requests are routed to the primary client until recordFlush has observed
+// flush failures for longer than the configured threshold. Requests are then
+// routed to the standby client until a probe to the primary succeeds.
+type failoverClient struct {
+	primary elasticsearch.Client
+	standby elasticsearch.Client
+	config  FailoverConfig
+	logger  *logp.Logger
+
+	// standbyActive is 1 when requests are being routed to the standby client.
+	standbyActive int64
+	failovers     int64
+
+	mu           sync.Mutex
+	failingSince time.Time
+}
+
+func newFailoverClient(primary elasticsearch.Client, cfg FailoverConfig, logger *logp.Logger) *failoverClient {
+	return &failoverClient{
+		primary: primary,
+		standby: cfg.Client,
+		config:  cfg,
+		logger:  logger,
+	}
+}
+
+// NewBulkIndexer returns a new BulkIndexer using the currently active client.
+func (c *failoverClient) NewBulkIndexer(cfg elasticsearch.BulkIndexerConfig) (elasticsearch.BulkIndexer, error) {
+	return c.current().NewBulkIndexer(cfg)
+}
+
+// Perform sends the request using the currently active client.
+func (c *failoverClient) Perform(req *http.Request) (*http.Response, error) {
+	return c.current().Perform(req)
+}
+
+func (c *failoverClient) current() elasticsearch.Client {
+	if c.usingStandby() {
+		return c.standby
+	}
+	return c.primary
+}
+
+func (c *failoverClient) usingStandby() bool {
+	return atomic.LoadInt64(&c.standbyActive) == 1
+}
+
+// recordFlush records the result of a bulk request flush. If flushes to the
+// primary have been failing for longer than the configured threshold, then
+// subsequent requests will be routed to the standby client.
+//
+// Flushes rejected with 429 Too Many Requests or 413 Request Entity Too Large
+// do not count towards failover, as they indicate that the primary cluster is
+// available, but overloaded or unwilling to accept a request that large.
+func (c *failoverClient) recordFlush(err error, now time.Time) {
+	if c.usingStandby() {
+		// Flushes that were started prior to failing over, or that were
+		// sent to the standby cluster, do not affect the decision to
+		// return to the primary; that is decided by probing.
+		return
+	}
+	var errTooMany errorTooManyRequests
+	var errTooLarge errorRequestEntityTooLarge
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil || errors.As(err, &errTooMany) || errors.As(err, &errTooLarge) {
+		c.failingSince = time.Time{}
+		return
+	}
+	if c.failingSince.IsZero() {
+		c.failingSince = now
+	}
+	if failing := now.Sub(c.failingSince); failing >= c.config.Threshold {
+		if atomic.CompareAndSwapInt64(&c.standbyActive, 0, 1) {
+			atomic.AddInt64(&c.failovers, 1)
+			c.logger.Errorf(
+				"bulk requests to primary Elasticsearch failing for %s, failing over to standby: %v",
+				failing, err,
+			)
+		}
+	}
+}
+
+// run probes the primary client while requests are being routed to the
+// standby client, until ctx is cancelled or closed is signalled.
+func (c *failoverClient) run(ctx context.Context, closed <-chan struct{}) {
+	ticker := time.NewTicker(c.config.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-ticker.C:
+		}
+		if !c.usingStandby() {
+			continue
+		}
+		if err := c.probe(ctx); err != nil {
+			c.logger.Debugf("primary Elasticsearch still unavailable: %v", err)
+			continue
+		}
+		c.mu.Lock()
+		c.failingSince = time.Time{}
+		atomic.StoreInt64(&c.standbyActive, 0)
+		c.mu.Unlock()
+		c.logger.Info("primary Elasticsearch available again, routing bulk requests to primary")
+	}
+}
+
+func (c *failoverClient) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.ProbeInterval)
+	defer cancel()
+	resp, err := esapi.InfoRequest{}.Do(ctx, c.primary)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return errors.New(resp.String())
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestModelIndexerFailover(t *testing.T) {
+	var primaryHealthy, primaryRequests, standbyRequests int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if atomic.LoadInt64(&primaryHealthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	modelindexertest.HandleBulk(mux, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt64(&primaryHealthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		atomic.AddInt64(&primaryRequests, 1)
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	primaryConfig := elasticsearch.DefaultConfig()
+	primaryConfig.Hosts = elasticsearch.Hosts{srv.URL}
+	primaryConfig.Backoff.Max = time.Nanosecond
+	primary, err := elasticsearch.NewClient(primaryConfig)
+	require.NoError(t, err)
+
+	standby := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&standbyRequests, 1)
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+
+	indexer, err := modelindexer.New(primary, modelindexer.Config{
+		FlushBytes: 1,
+		Failover: modelindexer.FailoverConfig{
+			Client:        standby,
+			Threshold:     time.Nanosecond,
+			ProbeInterval: 10 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	waitFor := func(cond func() bool, msg string) {
+		timeout := time.After(10 * time.Second)
+		for !cond() {
+			err := indexer.ProcessBatch(context.Background(), &batch)
+			require.NoError(t, err)
+			select {
+			case <-time.After(10 * time.Millisecond):
+			case <-timeout:
+				t.Fatal(msg)
+			}
+		}
+	}
+
+	waitFor(func() bool {
+		return atomic.LoadInt64(&standbyRequests) > 0
+	}, "timed out waiting for failover to standby")
+	stats := indexer.Stats()
+	assert.Equal(t, modelindexer.FailoverStats{Active: 1, Failovers: 1}, stats.Failover)
+	assert.Zero(t, atomic.LoadInt64(&primaryRequests))
+
+	// Once the primary is healthy again, the probe will detect it
+	// and bulk requests will be routed back to the primary.
+	atomic.StoreInt64(&primaryHealthy, 1)
+	waitFor(func() bool {
+		return atomic.LoadInt64(&primaryRequests) > 0
+	}, "timed out waiting for bulk requests to return to primary")
+	stats = indexer.Stats()
+	assert.Equal(t, modelindexer.FailoverStats{Active: 0, Failovers: 1}, stats.Failover)
+}
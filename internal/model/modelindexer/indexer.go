@@ -31,14 +31,13 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/cespare/xxhash/v2"
 	"go.elastic.co/apm/module/apmzap/v2"
 	"go.elastic.co/apm/v2"
 	"go.elastic.co/fastjson"
 	"golang.org/x/sync/errgroup"
 
-	"github.com/elastic/beats/v7/libbeat/beat"
 	"github.com/elastic/elastic-agent-libs/logp"
-	"github.com/elastic/elastic-agent-libs/mapstr"
 
 	"github.com/elastic/apm-server/internal/elasticsearch"
 	"github.com/elastic/apm-server/internal/logs"
@@ -52,15 +51,99 @@ const (
 	// strict_date_optional_time date format, which includes a fractional
 	// seconds component.
 	timestampFormat = "2006-01-02T15:04:05.000Z07:00"
+
+	// CompressionGzip compresses bulk requests with gzip.
+	CompressionGzip = "gzip"
+
+	// CompressionZstd compresses bulk requests with zstd, falling back to
+	// gzip if Elasticsearch does not accept zstd-compressed requests.
+	CompressionZstd = "zstd"
 )
 
-// ErrClosed is returned from methods of closed Indexers.
-var ErrClosed = errors.New("model indexer closed")
+var (
+	// indexerIDs is used for assigning a unique ID to each Indexer,
+	// for detecting stats snapshots taken from a different Indexer.
+	indexerIDs uint64
+
+	// statsSequence holds the sequence number of the most recent
+	// stats snapshot, across all Indexers.
+	statsSequence uint64
+)
+
+var (
+	// ErrClosed is returned from methods of closed Indexers.
+	ErrClosed = errors.New("model indexer closed")
+
+	// ErrQueueFull is returned from ProcessBatch when the context is
+	// done while waiting for space in the Indexer's queue. Errors
+	// matching ErrQueueFull also match the context's error.
+	ErrQueueFull = errors.New("model indexer queue is full")
+
+	// ErrDocumentTooLarge is returned from ProcessBatch, wrapped in an
+	// EventError, when an event's encoded document exceeds
+	// Config.MaxDocumentBytes.
+	ErrDocumentTooLarge = errors.New("document too large")
+
+	// ErrEncoding is returned from ProcessBatch, wrapped in an EventError,
+	// when an event cannot be encoded as a document.
+	ErrEncoding = errors.New("failed to encode document")
+
+	// ErrUnavailable is returned from ProcessBatch while the circuit
+	// breaker is open, after consecutive bulk requests have failed.
+	ErrUnavailable = errors.New("model indexer output unavailable")
+
+	// ErrIndexingFailed is returned from ProcessBatch when
+	// Config.WaitForIndexing is enabled, and one or more events in the
+	// batch could not be indexed.
+	ErrIndexingFailed = errors.New("failed to index events")
+)
+
+// EventError is returned from ProcessBatch when a specific event in the
+// batch could not be added to the Indexer. Events preceding Index in the
+// batch have been added.
+type EventError struct {
+	// Index holds the index of the event in the batch.
+	Index int
+
+	// Err holds the reason the event could not be added, which
+	// matches either ErrDocumentTooLarge or ErrEncoding.
+	Err error
+}
+
+// Error returns the error message.
+func (e *EventError) Error() string {
+	return fmt.Sprintf("event %d: %s", e.Index, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *EventError) Unwrap() error {
+	return e.Err
+}
+
+// queueFullError is returned when the context is done while waiting
+// for space in the queue. It matches both ErrQueueFull and the context
+// error, so callers checking for context cancellation are unaffected.
+type queueFullError struct {
+	err error
+}
+
+func (e queueFullError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrQueueFull, e.err)
+}
+
+func (e queueFullError) Unwrap() error {
+	return e.err
+}
+
+func (e queueFullError) Is(target error) bool {
+	return target == ErrQueueFull
+}
 
 // Indexer is a model.BatchProcessor which bulk indexes events as Elasticsearch documents.
 //
 // Indexer buffers events in their JSON encoding until either the accumulated buffer reaches
-// `config.FlushBytes`, or `config.FlushInterval` elapses.
+// `config.FlushBytes`, the number of buffered events reaches `config.FlushDocs`, or
+// `config.FlushInterval` elapses.
 //
 // Indexer fills a single bulk request buffer at a time to ensure bulk requests are optimally
 // sized, avoiding sparse bulk requests as much as possible. After a bulk request is flushed,
@@ -71,28 +154,77 @@ var ErrClosed = errors.New("model indexer closed")
 // server to make progress encoding while Elasticsearch is busy servicing flushed bulk requests.
 type Indexer struct {
 	bulkRequests          int64
+	bulkRequestsSplit     int64
+	bulkRequestsTooLarge  int64
+	bulkRequestsTimedOut  int64
 	eventsAdded           int64
 	eventsActive          int64
 	eventsFailed          int64
 	eventsIndexed         int64
+	eventsRetried         int64
+	eventsHeld            int64
+	heldBytes             int64
+	deadLetterIndexed     int64
+	deadLetterFailed      int64
 	tooManyRequests       int64
 	bytesTotal            int64
 	availableBulkRequests int64
 	activeCreated         int64
 	activeDestroyed       int64
+	unorderedItems        uint64
+
+	// learnedMaxRequestBytes holds the request size limit learned from
+	// bulk requests rejected with 413 Request Entity Too Large, or zero
+	// if no request has been rejected. It only ever decreases.
+	learnedMaxRequestBytes int64
 
 	scalingInfo atomic.Value
 
+	// scalingConfig holds the current ScalingConfig, which may be
+	// changed at runtime by SetScalingConfig.
+	scalingConfig atomic.Value
+
+	// id uniquely identifies the Indexer in stats snapshots.
+	// statsMu serialises stats snapshots, ensuring snapshot
+	// sequence numbers are ordered consistently with the
+	// counters they hold.
+	id      uint64
+	statsMu sync.Mutex
+
+	// failedByStatus holds the number of indexing operations that
+	// failed, keyed by the HTTP status code returned for each.
+	failedByStatusMu sync.Mutex
+	failedByStatus   map[int]int64
+
 	config                Config
+	encoder               Encoder
 	logger                *logp.Logger
-	available             chan *bulkIndexer
+	failover              *failoverClient
+	breaker               *circuitBreaker
+	signer                *signer
+	rollover              *rolloverManager
+	dataStreams           *dataStreamCreator
+	available             chan OutputBuffer
+	bulkIndexers          []OutputBuffer
 	bulkItems             chan elasticsearch.BulkIndexerItem
+	priorityItems         chan elasticsearch.BulkIndexerItem
+	partitions            []chan elasticsearch.BulkIndexerItem
+	dataStreamItems       map[string]chan elasticsearch.BulkIndexerItem
+	activeStatesMu        sync.Mutex
+	activeStates          map[*activeIndexerState]struct{}
 	errgroup              errgroup.Group
 	errgroupContext       context.Context
 	cancelErrgroupContext context.CancelFunc
 
 	mu     sync.Mutex
 	closed chan struct{}
+
+	// requeueMu guards requeueStopped, which is set once the Indexer
+	// starts draining its queues on close. Items are sent to the queues
+	// by requeueItems while holding a read lock, so no item is sent
+	// after the queues have been drained.
+	requeueMu      sync.RWMutex
+	requeueStopped bool
 }
 
 // Config holds configuration for Indexer.
@@ -109,6 +241,17 @@ type Config struct {
 	// default compression level.
 	CompressionLevel int
 
+	// Compression holds the algorithm used to compress bulk requests when
+	// CompressionLevel is non-zero: CompressionGzip or CompressionZstd.
+	// If Compression is empty, gzip is used.
+	//
+	// With CompressionZstd, CompressionLevel is mapped to the closest zstd
+	// encoder level, with -1 selecting the default level. If Elasticsearch
+	// rejects a zstd-compressed request with 415 Unsupported Media Type,
+	// the request is sent again compressed with gzip, and gzip is used for
+	// subsequent requests. Responses are also requested with zstd.
+	Compression string
+
 	// MaxRequests holds the maximum number of bulk index requests to execute concurrently.
 	// The maximum memory usage of Indexer is thus approximately MaxRequests*FlushBytes.
 	//
@@ -121,23 +264,216 @@ type Config struct {
 	// If FlushBytes is zero, the default of 1MB will be used.
 	FlushBytes int
 
+	// FlushDocs holds the flush threshold as a number of documents. Bulk
+	// requests are flushed when either FlushBytes or FlushDocs is reached.
+	//
+	// If FlushDocs is less than or equal to zero, the number of documents
+	// in a bulk request is unlimited.
+	FlushDocs int
+
+	// MaxRequestBytes holds the maximum size of a bulk request body before
+	// compression, corresponding to Elasticsearch's http.max_content_length.
+	// If adding an event would cause a bulk request to exceed this size, the
+	// bulk request is flushed first and the event is added to a new one,
+	// rather than having Elasticsearch reject the whole request with 413.
+	//
+	// A single event larger than MaxRequestBytes is sent in a bulk request
+	// on its own; use MaxDocumentBytes to reject such events.
+	//
+	// If MaxRequestBytes is zero, the default of 100MB will be used.
+	MaxRequestBytes int
+
 	// FlushInterval holds the flush threshold as a duration.
 	//
 	// If FlushInterval is zero, the default of 30 seconds will be used.
 	FlushInterval time.Duration
 
+	// Timeout holds the maximum amount of time to wait for a bulk request
+	// to complete, so that a hung Elasticsearch connection cannot occupy a
+	// bulk indexer indefinitely. The events of timed out requests are
+	// retried up to TimeoutMaxAttempts, with the backoff configured by
+	// DocumentRetry, and otherwise counted as failed.
+	//
+	// If Timeout is zero, bulk requests do not time out.
+	Timeout time.Duration
+
+	// TimeoutMaxAttempts holds the maximum number of times the events of
+	// timed out bulk requests will be sent, including the first attempt.
+	// It is independent of DocumentRetry.MaxAttempts, so that timed out
+	// requests are retried even when documents rejected by Elasticsearch
+	// are not.
+	//
+	// Retrying timed out requests gives at-least-once delivery: Elasticsearch
+	// may have indexed some or all of the events of a request before it timed
+	// out, and events are created without document IDs, so their retries may
+	// be indexed as duplicates.
+	//
+	// If TimeoutMaxAttempts is zero, the default of 3 will be used. If it
+	// is negative or 1, the events of timed out requests are not retried.
+	TimeoutMaxAttempts int
+
+	// DataStreamFlush holds flush thresholds for events of specific data
+	// stream types, keyed by `data_stream.type`: "traces", "logs", or
+	// "metrics". Events of each type in DataStreamFlush are queued and
+	// flushed separately from other events, by their own active indexer,
+	// so low-volume data streams are not held back by the thresholds of
+	// high-volume ones, and vice versa. Zero thresholds default to
+	// FlushBytes and FlushInterval.
+	//
+	// Active indexers for the data stream types in DataStreamFlush are not
+	// scaled, and share the MaxRequests bulk requests with other events.
+	DataStreamFlush map[string]FlushConfig
+
+	// Priority holds optional configuration for a priority lane. Events
+	// matching Priority are queued separately from other events, and are
+	// added to bulk requests ahead of other queued events, so they are
+	// flushed first when the Indexer is under pressure. Priority takes
+	// precedence over DataStreamFlush: priority events are flushed with
+	// FlushBytes and FlushInterval.
+	//
+	// If Priority has no data stream types or processor events, or
+	// OrderByTrace is enabled, events are not prioritised.
+	Priority PriorityConfig
+
 	// EventBufferSize sets the number of events that can be buffered before
 	// they are stored in the active indexer buffer.
 	//
 	// If EventBufferSize is zero, the default 1024 will be used.
 	EventBufferSize int
 
+	// MaxDocumentBytes holds the maximum size of an encoded document.
+	// Events whose documents exceed this size are rejected by ProcessBatch
+	// with ErrDocumentTooLarge, rather than being sent to Elasticsearch.
+	//
+	// If MaxDocumentBytes is less than or equal to zero, the size of
+	// documents is unlimited.
+	MaxDocumentBytes int
+
+	// Encoder holds the name of the registered Encoder to use for
+	// encoding events as documents. See RegisterEncoder.
+	//
+	// If Encoder is empty, DefaultEncoder will be used.
+	Encoder string
+
 	// Tracer holds an optional apm.Tracer to use for tracing bulk requests
 	// to Elasticsearch. Each bulk request is traced as a transaction.
 	// Scaling configuration for the modelindexer.
 	//
 	// If unspecified, scaling is enabled by default.
 	Scaling ScalingConfig
+
+	// Failover holds optional configuration for a warm standby cluster,
+	// to which bulk requests are routed while the primary is unavailable.
+	//
+	// If Failover.Client is nil, failover is disabled.
+	Failover FailoverConfig
+
+	// CircuitBreaker holds optional configuration for pausing bulk requests
+	// after consecutive failures, rather than continuing to send requests to
+	// an unavailable cluster.
+	//
+	// If CircuitBreaker.Threshold is zero, the circuit breaker is disabled.
+	CircuitBreaker CircuitBreakerConfig
+
+	// Signing holds optional configuration for signing documents with
+	// an HMAC over their canonical JSON encoding.
+	//
+	// If Signing.Key is empty, documents are not signed.
+	Signing SigningConfig
+
+	// OrderByTrace, if true, guarantees that documents belonging to the
+	// same trace are indexed in the order in which they were added.
+	//
+	// Events are partitioned by trace.id across a fixed number of active
+	// indexers, and each active indexer waits for its previous bulk request
+	// to complete before flushing the next one. This significantly reduces
+	// throughput, as bulk requests for a partition are never executed
+	// concurrently, and disables active indexer scaling.
+	//
+	// OrderByTrace is disabled by default.
+	OrderByTrace bool
+
+	// Rollover holds optional configuration for rolling over data streams
+	// after repeated mapping errors.
+	//
+	// If Rollover.Enabled is false, data streams are never rolled over.
+	Rollover RolloverConfig
+
+	// DataStreams holds optional configuration for creating data streams
+	// the first time events are indexed into them.
+	//
+	// If DataStreams.Create is false, data streams are created automatically
+	// by Elasticsearch.
+	DataStreams DataStreamsConfig
+
+	// Route holds an optional function for overriding the index and ingest
+	// pipeline of each event. Events are still queued and flushed according
+	// to their data stream type, regardless of the index returned. If
+	// DataStreams.Create is true, the returned index is created as a data
+	// stream.
+	//
+	// If Route is nil, events are indexed into their data streams.
+	Route RouteFunc
+
+	// Pipeline holds the name of an optional ingest pipeline through which
+	// events are processed, set on each bulk create action. Pipelines
+	// allow custom processing, such as enrichment or redaction, without
+	// modifying the index templates.
+	//
+	// If Pipeline is empty, no pipeline is set, and the index's default
+	// pipeline applies.
+	Pipeline string
+
+	// DataStreamPipelines holds ingest pipelines for events of specific
+	// data streams, overriding Pipeline. Keys are either data stream types,
+	// e.g. "logs", or data stream names, e.g. "logs-apm.app-default"; data
+	// stream names take precedence. An empty pipeline disables Pipeline
+	// for the data stream.
+	DataStreamPipelines map[string]string
+
+	// Output holds an optional Output to which events are delivered,
+	// in place of Elasticsearch.
+	//
+	// If Output is nil, events are indexed into Elasticsearch with bulk
+	// requests, using the client passed to New. Failover, CircuitBreaker,
+	// Rollover, DataStreams, DocumentRetry, and DeadLetter apply only to
+	// the Elasticsearch output.
+	Output Output
+
+	// WaitForIndexing, if true, makes ProcessBatch block until all events
+	// in the batch have been flushed to Elasticsearch, and return an error
+	// matching ErrIndexingFailed if any of them could not be indexed. This
+	// provides at-least-once delivery to callers which only acknowledge
+	// events once ProcessBatch returns, at the cost of latency: each batch
+	// may wait for up to FlushInterval before it is flushed.
+	//
+	// WaitForIndexing is disabled by default.
+	WaitForIndexing bool
+
+	// DocumentRetry holds optional configuration for retrying documents
+	// which fail to be indexed with a 429 or 503 status in the bulk
+	// response. Retried documents are re-enqueued, and added to a later
+	// bulk request.
+	//
+	// If DocumentRetry.MaxAttempts is less than or equal to 1, or
+	// OrderByTrace is enabled, documents are not retried.
+	DocumentRetry DocumentRetryConfig
+
+	// DeadLetter holds optional configuration for indexing documents
+	// rejected with a 4xx status other than 429 in the bulk response,
+	// such as mapping errors, into a dead letter index.
+	//
+	// If DeadLetter.Index is empty, rejected documents are not indexed.
+	DeadLetter DeadLetterConfig
+
+	// UnavailableBuffer holds optional configuration for holding documents
+	// in memory while Elasticsearch refuses connections, e.g. during a
+	// restart, and sending them again once it is available, rather than
+	// counting them as failed.
+	//
+	// If UnavailableBuffer.MaxBytes is zero, or OrderByTrace is enabled,
+	// documents are not held.
+	UnavailableBuffer UnavailableBufferConfig
 }
 
 // ScalingConfig holds the modelindexer autoscaling configuration.
@@ -179,6 +515,39 @@ type ScalingConfig struct {
 	//
 	// When unspecified, the default of 30 seconds will be used.
 	IdleInterval time.Duration
+
+	// OnScale, if non-nil, is called after each scaling action, for example
+	// to log or record custom metrics. OnScale is called synchronously by
+	// the active indexer performing the action, and must not block.
+	OnScale func(ScaleEvent)
+}
+
+// ScaleAction identifies the kind of a scaling action.
+type ScaleAction string
+
+const (
+	// ScaleUp is the action of creating an active indexer.
+	ScaleUp ScaleAction = "up"
+
+	// ScaleDown is the action of destroying an active indexer.
+	ScaleDown ScaleAction = "down"
+)
+
+// ScaleEvent describes a scaling action, passed to ScalingConfig.OnScale.
+type ScaleEvent struct {
+	// Action holds the kind of scaling action.
+	Action ScaleAction
+
+	// Reason describes why the action was taken: "full_flush" for scaling
+	// up, and "active_limit", "too_many_requests", or "timed_flush" for
+	// scaling down.
+	Reason string
+
+	// ActiveIndexers holds the number of active indexers after the action.
+	ActiveIndexers int64
+
+	// Time holds the time at which the action was taken.
+	Time time.Time
 }
 
 // ScaleActionConfig holds the configuration for a scaling action
@@ -192,6 +561,60 @@ type ScaleActionConfig struct {
 	CoolDown time.Duration
 }
 
+// FlushConfig holds flush thresholds for the events of a data stream type.
+type FlushConfig struct {
+	// FlushBytes holds the flush threshold in bytes.
+	//
+	// If FlushBytes is zero, Config.FlushBytes will be used.
+	FlushBytes int
+
+	// FlushInterval holds the flush threshold as a duration.
+	//
+	// If FlushInterval is zero, Config.FlushInterval will be used.
+	FlushInterval time.Duration
+}
+
+// PriorityConfig holds configuration for prioritising events.
+type PriorityConfig struct {
+	// DataStreamTypes holds the data stream types, such as "logs",
+	// of the events to prioritise.
+	DataStreamTypes []string
+
+	// ProcessorEvents holds the processor events, such as "error",
+	// of the events to prioritise.
+	ProcessorEvents []string
+}
+
+// prioritised reports whether event matches the priority configuration.
+func (c PriorityConfig) prioritised(event *model.APMEvent) bool {
+	for _, dataStreamType := range c.DataStreamTypes {
+		if event.DataStream.Type == dataStreamType {
+			return true
+		}
+	}
+	for _, processorEvent := range c.ProcessorEvents {
+		if event.Processor.Event == processorEvent {
+			return true
+		}
+	}
+	return false
+}
+
+// flushPolicy holds the flush thresholds of an active indexer.
+type flushPolicy struct {
+	flushBytes    int
+	flushInterval time.Duration
+
+	// dedicated is true for active indexers which consume the queue of a
+	// data stream type in Config.DataStreamFlush. Dedicated active indexers
+	// are not scaled.
+	dedicated bool
+
+	// queue names the queue consumed by the active indexer, as reported
+	// in QueueState.
+	queue string
+}
+
 // New returns a new Indexer that indexes events directly into data streams.
 func New(client elasticsearch.Client, cfg Config) (*Indexer, error) {
 	logger := logp.NewLogger("modelindexer", logs.WithRateLimit(logRateLimit))
@@ -201,48 +624,167 @@ func New(client elasticsearch.Client, cfg Config) (*Indexer, error) {
 			cfg.CompressionLevel,
 		)
 	}
+	switch cfg.Compression {
+	case "", CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf(
+			"expected Compression to be one of %q or %q, got %q",
+			CompressionGzip, CompressionZstd, cfg.Compression,
+		)
+	}
+	encoder, err := LookupEncoder(cfg.Encoder)
+	if err != nil {
+		return nil, err
+	}
 	if cfg.MaxRequests <= 0 {
 		cfg.MaxRequests = 10
 	}
 	if cfg.FlushBytes <= 0 {
 		cfg.FlushBytes = 1 * 1024 * 1024
 	}
+	if cfg.MaxRequestBytes <= 0 {
+		cfg.MaxRequestBytes = 100 * 1024 * 1024
+	}
 	if cfg.FlushInterval <= 0 {
 		cfg.FlushInterval = 30 * time.Second
 	}
 	if cfg.EventBufferSize <= 0 {
 		cfg.EventBufferSize = 1024
 	}
-	if !cfg.Scaling.Disabled {
-		if cfg.Scaling.ScaleDown.Threshold == 0 {
-			cfg.Scaling.ScaleDown.Threshold = 30
+	for dataStreamType, flushCfg := range cfg.DataStreamFlush {
+		if flushCfg.FlushBytes < 0 || flushCfg.FlushInterval < 0 {
+			return nil, fmt.Errorf(
+				"expected non-negative flush thresholds for data stream type %q", dataStreamType,
+			)
+		}
+	}
+	for key := range cfg.DataStreamPipelines {
+		if _, _, _, ok := splitDataStreamName(key); !ok && (key == "" || strings.Contains(key, "-")) {
+			return nil, fmt.Errorf(
+				"expected data stream type or name for data stream pipeline, got %q", key,
+			)
 		}
-		if cfg.Scaling.ScaleDown.CoolDown <= 0 {
-			cfg.Scaling.ScaleDown.CoolDown = 30 * time.Second
+	}
+	if cfg.OrderByTrace {
+		// Active indexers are pinned to partitions when ordering by trace,
+		// so they cannot be scaled up or down.
+		cfg.Scaling.Disabled = true
+
+		// Retried documents would be indexed after documents added
+		// later, so they are not retried when ordering by trace.
+		cfg.DocumentRetry.MaxAttempts = 0
+		cfg.TimeoutMaxAttempts = 1
+
+		// Likewise, prioritised documents would be indexed ahead
+		// of documents of the same trace added earlier, and held
+		// documents after documents added later.
+		cfg.Priority = PriorityConfig{}
+		cfg.UnavailableBuffer = UnavailableBufferConfig{}
+	}
+	if cfg.UnavailableBuffer.MaxBytes > 0 {
+		if cfg.UnavailableBuffer.MaxAge <= 0 {
+			cfg.UnavailableBuffer.MaxAge = 30 * time.Second
 		}
-		if cfg.Scaling.ScaleUp.Threshold == 0 {
-			cfg.Scaling.ScaleUp.Threshold = 60
+		if cfg.UnavailableBuffer.RetryInterval <= 0 {
+			cfg.UnavailableBuffer.RetryInterval = time.Second
 		}
-		if cfg.Scaling.ScaleUp.CoolDown <= 0 {
-			cfg.Scaling.ScaleUp.CoolDown = time.Minute
+	}
+	if cfg.TimeoutMaxAttempts == 0 {
+		cfg.TimeoutMaxAttempts = 3
+	}
+	retryTimeouts := cfg.Timeout > 0 && cfg.TimeoutMaxAttempts > 1
+	retainDocs := cfg.DocumentRetry.MaxAttempts > 1 || cfg.DeadLetter.Index != "" ||
+		cfg.UnavailableBuffer.MaxBytes > 0 || retryTimeouts
+	if cfg.DocumentRetry.MaxAttempts > 1 || retryTimeouts {
+		if cfg.DocumentRetry.InitialBackoff <= 0 {
+			cfg.DocumentRetry.InitialBackoff = time.Second
 		}
-		if cfg.Scaling.IdleInterval <= 0 {
-			cfg.Scaling.IdleInterval = 30 * time.Second
+		if cfg.DocumentRetry.MaxBackoff <= 0 {
+			cfg.DocumentRetry.MaxBackoff = time.Minute
 		}
 	}
-	available := make(chan *bulkIndexer, cfg.MaxRequests)
-	for i := 0; i < cfg.MaxRequests; i++ {
-		available <- newBulkIndexer(client, cfg.CompressionLevel)
+	cfg.Scaling = scalingConfigWithDefaults(cfg.Scaling)
+	var failover *failoverClient
+	if cfg.Failover.Client != nil {
+		if cfg.Failover.Threshold <= 0 {
+			cfg.Failover.Threshold = time.Minute
+		}
+		if cfg.Failover.ProbeInterval <= 0 {
+			cfg.Failover.ProbeInterval = 10 * time.Second
+		}
+		failover = newFailoverClient(client, cfg.Failover, logger)
+		client = failover
+	}
+	var breaker *circuitBreaker
+	if cfg.CircuitBreaker.Threshold > 0 && cfg.Output == nil {
+		if cfg.CircuitBreaker.Backoff <= 0 {
+			cfg.CircuitBreaker.Backoff = 30 * time.Second
+		}
+		breaker = newCircuitBreaker(cfg.CircuitBreaker, logger)
+	}
+	var signer *signer
+	if len(cfg.Signing.Key) > 0 {
+		signer = newSigner(cfg.Signing)
+	}
+	var rollover *rolloverManager
+	if cfg.Rollover.Enabled {
+		if cfg.Rollover.Threshold <= 0 {
+			cfg.Rollover.Threshold = 100
+		}
+		if cfg.Rollover.Interval <= 0 {
+			cfg.Rollover.Interval = time.Hour
+		}
+		rollover = newRolloverManager(client, cfg.Rollover, logger)
+	}
+	var dataStreams *dataStreamCreator
+	if cfg.DataStreams.Create {
+		if cfg.DataStreams.RetryInterval <= 0 {
+			cfg.DataStreams.RetryInterval = time.Minute
+		}
+		dataStreams = newDataStreamCreator(client, cfg.DataStreams, logger)
+	}
+	output := cfg.Output
+	if output == nil {
+		filterPath := bulkFilterPath
+		if rollover != nil {
+			filterPath = bulkFilterPathWithIndex
+		}
+		output = elasticsearchOutput{
+			client:           client,
+			compression:      cfg.Compression,
+			compressionLevel: cfg.CompressionLevel,
+			retainDocs:       retainDocs,
+			filterPath:       filterPath,
+		}
+	}
+	available := make(chan OutputBuffer, cfg.MaxRequests)
+	bulkIndexers := make([]OutputBuffer, cfg.MaxRequests)
+	for i := range bulkIndexers {
+		bulkIndexers[i] = output.NewBuffer()
+		available <- bulkIndexers[i]
 	}
 	indexer := &Indexer{
+		id:                    atomic.AddUint64(&indexerIDs, 1),
 		availableBulkRequests: int64(len(available)),
 		config:                cfg,
+		encoder:               encoder,
 		logger:                logger,
+		failover:              failover,
+		breaker:               breaker,
+		signer:                signer,
+		rollover:              rollover,
+		dataStreams:           dataStreams,
 		available:             available,
+		bulkIndexers:          bulkIndexers,
+		activeStates:          make(map[*activeIndexerState]struct{}),
 		closed:                make(chan struct{}),
 		// NOTE(marclop) This channel size is arbitrary.
 		bulkItems: make(chan elasticsearch.BulkIndexerItem, cfg.EventBufferSize),
 	}
+	indexer.scalingConfig.Store(cfg.Scaling)
+	if len(cfg.Priority.DataStreamTypes) > 0 || len(cfg.Priority.ProcessorEvents) > 0 {
+		indexer.priorityItems = make(chan elasticsearch.BulkIndexerItem, cfg.EventBufferSize)
+	}
 
 	// We create a cancellable context for the errgroup.Group for unblocking
 	// flushes when Close returns. We intentionally do not use errgroup.WithContext,
@@ -250,11 +792,61 @@ func New(client elasticsearch.Client, cfg Config) (*Indexer, error) {
 	indexer.errgroupContext, indexer.cancelErrgroupContext = context.WithCancel(
 		context.Background(),
 	)
-	indexer.scalingInfo.Store(scalingInfo{activeIndexers: 1})
-	indexer.errgroup.Go(func() error {
-		indexer.runActiveIndexer()
-		return nil
-	})
+	defaultPolicy := flushPolicy{flushBytes: cfg.FlushBytes, flushInterval: cfg.FlushInterval, queue: "default"}
+	if len(cfg.DataStreamFlush) > 0 {
+		indexer.dataStreamItems = make(map[string]chan elasticsearch.BulkIndexerItem, len(cfg.DataStreamFlush))
+		for dataStreamType, flushCfg := range cfg.DataStreamFlush {
+			policy := flushPolicy{
+				flushBytes:    flushCfg.FlushBytes,
+				flushInterval: flushCfg.FlushInterval,
+				dedicated:     true,
+				queue:         dataStreamType,
+			}
+			if policy.flushBytes == 0 {
+				policy.flushBytes = cfg.FlushBytes
+			}
+			if policy.flushInterval == 0 {
+				policy.flushInterval = cfg.FlushInterval
+			}
+			items := make(chan elasticsearch.BulkIndexerItem, cfg.EventBufferSize)
+			indexer.dataStreamItems[dataStreamType] = items
+			indexer.errgroup.Go(func() error {
+				indexer.runActiveIndexer(items, policy)
+				return nil
+			})
+		}
+	}
+	if cfg.OrderByTrace {
+		n := int(activeLimit())
+		bufferSize := cfg.EventBufferSize / n
+		if bufferSize < 1 {
+			bufferSize = 1
+		}
+		indexer.partitions = make([]chan elasticsearch.BulkIndexerItem, n)
+		indexer.scalingInfo.Store(scalingInfo{activeIndexers: int64(n)})
+		for p := range indexer.partitions {
+			items := make(chan elasticsearch.BulkIndexerItem, bufferSize)
+			indexer.partitions[p] = items
+			policy := defaultPolicy
+			policy.queue = fmt.Sprintf("partition.%d", p)
+			indexer.errgroup.Go(func() error {
+				indexer.runActiveIndexer(items, policy)
+				return nil
+			})
+		}
+	} else {
+		indexer.scalingInfo.Store(scalingInfo{activeIndexers: 1})
+		indexer.errgroup.Go(func() error {
+			indexer.runActiveIndexer(indexer.bulkItems, defaultPolicy)
+			return nil
+		})
+	}
+	if failover != nil {
+		indexer.errgroup.Go(func() error {
+			failover.run(indexer.errgroupContext, indexer.closed)
+			return nil
+		})
+	}
 	return indexer, nil
 }
 
@@ -282,41 +874,215 @@ func (i *Indexer) Close(ctx context.Context) error {
 	return i.errgroup.Wait()
 }
 
+// recordFailedByStatus adds the per-status failure counts in failed
+// to the indexer's totals.
+func (i *Indexer) recordFailedByStatus(failed map[int]int64) {
+	i.failedByStatusMu.Lock()
+	defer i.failedByStatusMu.Unlock()
+	if i.failedByStatus == nil {
+		i.failedByStatus = make(map[int]int64, len(failed))
+	}
+	for status, n := range failed {
+		i.failedByStatus[status] += n
+	}
+}
+
 // Stats returns the bulk indexing stats.
 func (i *Indexer) Stats() Stats {
+	var failoverStats FailoverStats
+	if i.failover != nil {
+		failoverStats.Active = atomic.LoadInt64(&i.failover.standbyActive)
+		failoverStats.Failovers = atomic.LoadInt64(&i.failover.failovers)
+	}
+	var circuitBreakerStats CircuitBreakerStats
+	if i.breaker != nil {
+		if i.breaker.isOpen(time.Now()) {
+			circuitBreakerStats.Open = 1
+		}
+		circuitBreakerStats.Opened = atomic.LoadInt64(&i.breaker.opened)
+	}
+	var rolloverStats RolloverStats
+	if i.rollover != nil {
+		rolloverStats.Rollovers = atomic.LoadInt64(&i.rollover.rollovers)
+		rolloverStats.Failed = atomic.LoadInt64(&i.rollover.failed)
+	}
+	var dataStreamStats DataStreamStats
+	if i.dataStreams != nil {
+		dataStreamStats.Created = atomic.LoadInt64(&i.dataStreams.created)
+		dataStreamStats.Failed = atomic.LoadInt64(&i.dataStreams.failed)
+	}
+	queuedPriority := int64(len(i.priorityItems))
+	queued := int64(len(i.bulkItems)) + queuedPriority
+	for _, partition := range i.partitions {
+		queued += int64(len(partition))
+	}
+	for _, items := range i.dataStreamItems {
+		queued += int64(len(items))
+	}
+	var failedByStatus map[int]int64
+	i.failedByStatusMu.Lock()
+	if len(i.failedByStatus) > 0 {
+		failedByStatus = make(map[int]int64, len(i.failedByStatus))
+		for status, n := range i.failedByStatus {
+			failedByStatus[status] = n
+		}
+	}
+	i.failedByStatusMu.Unlock()
+	var oldestActiveAge time.Duration
+	now := time.Now()
+	for _, bulkIndexer := range i.bulkIndexers {
+		firstAdded := bulkIndexer.FirstAdded()
+		if firstAdded.IsZero() {
+			continue
+		}
+		if age := now.Sub(firstAdded); age > oldestActiveAge {
+			oldestActiveAge = age
+		}
+	}
 	return Stats{
-		Added:                 atomic.LoadInt64(&i.eventsAdded),
-		Active:                atomic.LoadInt64(&i.eventsActive),
-		BulkRequests:          atomic.LoadInt64(&i.bulkRequests),
-		Failed:                atomic.LoadInt64(&i.eventsFailed),
-		Indexed:               atomic.LoadInt64(&i.eventsIndexed),
-		TooManyRequests:       atomic.LoadInt64(&i.tooManyRequests),
-		BytesTotal:            atomic.LoadInt64(&i.bytesTotal),
-		AvailableBulkRequests: atomic.LoadInt64(&i.availableBulkRequests),
-		IndexersActive:        i.scalingInformation().activeIndexers,
-		IndexersCreated:       atomic.LoadInt64(&i.activeCreated),
-		IndexersDestroyed:     atomic.LoadInt64(&i.activeDestroyed),
+		Added:                  atomic.LoadInt64(&i.eventsAdded),
+		Active:                 atomic.LoadInt64(&i.eventsActive),
+		ActiveOldestAge:        oldestActiveAge,
+		Queued:                 queued,
+		QueuedPriority:         queuedPriority,
+		BulkRequests:           atomic.LoadInt64(&i.bulkRequests),
+		BulkRequestsSplit:      atomic.LoadInt64(&i.bulkRequestsSplit),
+		BulkRequestsTooLarge:   atomic.LoadInt64(&i.bulkRequestsTooLarge),
+		LearnedMaxRequestBytes: atomic.LoadInt64(&i.learnedMaxRequestBytes),
+		BulkRequestsTimedOut:   atomic.LoadInt64(&i.bulkRequestsTimedOut),
+		Failed:                 atomic.LoadInt64(&i.eventsFailed),
+		FailedByStatus:         failedByStatus,
+		Indexed:                atomic.LoadInt64(&i.eventsIndexed),
+		Retried:                atomic.LoadInt64(&i.eventsRetried),
+		Held:                   atomic.LoadInt64(&i.eventsHeld),
+		TooManyRequests:        atomic.LoadInt64(&i.tooManyRequests),
+		BytesTotal:             atomic.LoadInt64(&i.bytesTotal),
+		AvailableBulkRequests:  atomic.LoadInt64(&i.availableBulkRequests),
+		IndexersActive:         i.scalingInformation().activeIndexers,
+		IndexersCreated:        atomic.LoadInt64(&i.activeCreated),
+		IndexersDestroyed:      atomic.LoadInt64(&i.activeDestroyed),
+		Failover:               failoverStats,
+		CircuitBreaker:         circuitBreakerStats,
+		Rollover:               rolloverStats,
+		DataStreams:            dataStreamStats,
+		DeadLetter: DeadLetterStats{
+			Indexed: atomic.LoadInt64(&i.deadLetterIndexed),
+			Failed:  atomic.LoadInt64(&i.deadLetterFailed),
+		},
 	}
 }
 
+// StatsDelta returns a snapshot of the bulk indexing stats, with counters
+// holding the change since the snapshot since. Gauges, such as Active and
+// Queued, hold their current values.
+//
+// Snapshots are assigned a monotonically increasing sequence number, and
+// the counters of successive snapshots are guaranteed never to decrease,
+// even when StatsDelta is called concurrently. If since is the zero value,
+// or was returned by a different Indexer (e.g. one that has since been
+// replaced), the snapshot's Reset field is set and its counters hold their
+// totals since the Indexer was created.
+//
+// Callers computing rates should pass the previously returned snapshot
+// as since:
+//
+//	var last modelindexer.StatsSnapshot
+//	for range ticker.C {
+//		last = indexer.StatsDelta(last)
+//		...
+//	}
+func (i *Indexer) StatsDelta(since StatsSnapshot) StatsSnapshot {
+	i.statsMu.Lock()
+	defer i.statsMu.Unlock()
+	stats := i.Stats()
+	snapshot := StatsSnapshot{
+		Stats:     stats,
+		Sequence:  atomic.AddUint64(&statsSequence, 1),
+		indexerID: i.id,
+		total:     stats,
+	}
+	if since.indexerID != i.id {
+		snapshot.Reset = true
+		return snapshot
+	}
+	snapshot.Added -= since.total.Added
+	snapshot.BulkRequests -= since.total.BulkRequests
+	snapshot.BulkRequestsSplit -= since.total.BulkRequestsSplit
+	snapshot.BulkRequestsTooLarge -= since.total.BulkRequestsTooLarge
+	snapshot.BulkRequestsTimedOut -= since.total.BulkRequestsTimedOut
+	snapshot.Failed -= since.total.Failed
+	if len(stats.FailedByStatus) > 0 {
+		failedByStatus := make(map[int]int64, len(stats.FailedByStatus))
+		for status, n := range stats.FailedByStatus {
+			if delta := n - since.total.FailedByStatus[status]; delta > 0 {
+				failedByStatus[status] = delta
+			}
+		}
+		snapshot.FailedByStatus = failedByStatus
+	}
+	snapshot.Indexed -= since.total.Indexed
+	snapshot.Retried -= since.total.Retried
+	snapshot.TooManyRequests -= since.total.TooManyRequests
+	snapshot.BytesTotal -= since.total.BytesTotal
+	snapshot.IndexersCreated -= since.total.IndexersCreated
+	snapshot.IndexersDestroyed -= since.total.IndexersDestroyed
+	snapshot.Failover.Failovers -= since.total.Failover.Failovers
+	snapshot.CircuitBreaker.Opened -= since.total.CircuitBreaker.Opened
+	snapshot.Rollover.Rollovers -= since.total.Rollover.Rollovers
+	snapshot.Rollover.Failed -= since.total.Rollover.Failed
+	snapshot.DataStreams.Created -= since.total.DataStreams.Created
+	snapshot.DataStreams.Failed -= since.total.DataStreams.Failed
+	snapshot.DeadLetter.Indexed -= since.total.DeadLetter.Indexed
+	snapshot.DeadLetter.Failed -= since.total.DeadLetter.Failed
+	return snapshot
+}
+
 // ProcessBatch creates a document for each event in batch, and adds them to the
 // Elasticsearch bulk indexer.
 //
-// If Close is called, then ProcessBatch will return ErrClosed.
+// If Close is called, then ProcessBatch will return ErrClosed. If ctx is done
+// while waiting for space in the queue, ProcessBatch will return an error
+// matching both ErrQueueFull and ctx.Err(). If an event cannot be encoded, or
+// its document is too large, ProcessBatch will return an *EventError. While
+// the circuit breaker is open, ProcessBatch will return ErrUnavailable.
+//
+// If Config.WaitForIndexing is enabled, ProcessBatch will additionally wait
+// for the events to be flushed, returning an error matching ErrIndexingFailed
+// if any of them could not be indexed, or ctx.Err() if ctx is done first.
 func (i *Indexer) ProcessBatch(ctx context.Context, batch *model.Batch) error {
-	for _, event := range *batch {
-		if err := i.processEvent(ctx, &event); err != nil {
+	if i.breaker != nil && i.breaker.isOpen(time.Now()) {
+		return ErrUnavailable
+	}
+	var cp *checkpoint
+	if i.config.WaitForIndexing {
+		cp = newCheckpoint()
+	}
+	for index, event := range *batch {
+		if err := i.processEvent(ctx, &event, index, cp); err != nil {
 			return err
 		}
 	}
+	if cp != nil {
+		return cp.wait(ctx)
+	}
 	return nil
 }
 
-func (i *Indexer) processEvent(ctx context.Context, event *model.APMEvent) error {
+func (i *Indexer) processEvent(ctx context.Context, event *model.APMEvent, index int, cp *checkpoint) error {
 	r := getPooledReader()
-	beatEvent := event.BeatEvent()
-	if err := encodeBeatEvent(beatEvent, &r.jsonw); err != nil {
-		return err
+	if err := i.encoder.Encode(event, &r.jsonw); err != nil {
+		return &EventError{Index: index, Err: fmt.Errorf("%w: %s", ErrEncoding, err)}
+	}
+	if i.signer != nil {
+		if err := i.signer.sign(&r.jsonw); err != nil {
+			return &EventError{Index: index, Err: fmt.Errorf("%w: failed to sign document: %s", ErrEncoding, err)}
+		}
+	}
+	if i.config.MaxDocumentBytes > 0 && r.jsonw.Size() > i.config.MaxDocumentBytes {
+		return &EventError{Index: index, Err: fmt.Errorf(
+			"%w: %d bytes exceeds limit of %d bytes",
+			ErrDocumentTooLarge, r.jsonw.Size(), i.config.MaxDocumentBytes,
+		)}
 	}
 	r.reader.Reset(r.jsonw.Bytes())
 
@@ -325,76 +1091,150 @@ func (i *Indexer) processEvent(ctx context.Context, event *model.APMEvent) error
 	r.indexBuilder.WriteString(event.DataStream.Dataset)
 	r.indexBuilder.WriteByte('-')
 	r.indexBuilder.WriteString(event.DataStream.Namespace)
+	indexName := r.indexBuilder.String()
+	pipeline := i.pipeline(event.DataStream.Type, indexName)
+	if i.config.Route != nil {
+		routedIndex, routedPipeline := i.config.Route(event)
+		if routedIndex != "" {
+			indexName = routedIndex
+		}
+		if routedPipeline != "" {
+			pipeline = routedPipeline
+		}
+	}
+	if i.dataStreams != nil {
+		i.dataStreams.ensure(ctx, indexName)
+	}
 
 	// Send the BulkIndexerItem to the internal channel, allowing individual
 	// events to be processed by an active bulk indexer in a dedicated goroutine,
 	// which in turn speeds up event processing.
+	item := elasticsearch.BulkIndexerItem{
+		Index:    indexName,
+		Action:   "create",
+		Pipeline: pipeline,
+		Body:     r,
+	}
+	if cp != nil {
+		cp.track(&item)
+	}
+	if err := i.sendBulkItem(ctx, event, item); err != nil {
+		if cp != nil {
+			cp.untrack(&item)
+		}
+		return err
+	}
+	atomic.AddInt64(&i.eventsAdded, 1)
+	atomic.AddInt64(&i.eventsActive, 1)
+	return nil
+}
+
+// IndexDocument enqueues an already encoded document for indexing into
+// the given index or data stream, waiting for space in the queue if
+// necessary. IndexDocument is intended for replaying documents that were
+// previously encoded, e.g. by the file output; doc is copied, and may be
+// reused once IndexDocument returns.
+func (i *Indexer) IndexDocument(ctx context.Context, index string, doc []byte) error {
+	if i.config.MaxDocumentBytes > 0 && len(doc) > i.config.MaxDocumentBytes {
+		return fmt.Errorf(
+			"%w: %d bytes exceeds limit of %d bytes",
+			ErrDocumentTooLarge, len(doc), i.config.MaxDocumentBytes,
+		)
+	}
+	r := getPooledReader()
+	r.jsonw.RawBytes(doc)
+	r.reader.Reset(r.jsonw.Bytes())
+	r.indexBuilder.WriteString(index)
+	if i.dataStreams != nil {
+		i.dataStreams.ensure(ctx, index)
+	}
+
 	item := elasticsearch.BulkIndexerItem{
 		Index:  r.indexBuilder.String(),
 		Action: "create",
 		Body:   r,
 	}
-	select {
-	case <-ctx.Done():
-		return ctx.Err()
-	case <-i.closed:
-		return ErrClosed
-	case i.bulkItems <- item:
+	if err := i.sendBulkItem(ctx, indexEvent(index), item); err != nil {
+		return err
 	}
 	atomic.AddInt64(&i.eventsAdded, 1)
 	atomic.AddInt64(&i.eventsActive, 1)
 	return nil
 }
 
-func encodeBeatEvent(in beat.Event, out *fastjson.Writer) error {
-	out.RawByte('{')
-	out.RawString(`"@timestamp":"`)
-	out.Time(in.Timestamp, timestampFormat)
-	out.RawByte('"')
-	for k, v := range in.Fields {
-		out.RawByte(',')
-		out.String(k)
-		out.RawByte(':')
-		if err := encodeAny(v, out); err != nil {
-			return err
+// pipeline returns the ingest pipeline configured for events of the given
+// data stream type and name.
+func (i *Indexer) pipeline(dataStreamType, dataStreamName string) string {
+	if len(i.config.DataStreamPipelines) > 0 {
+		if pipeline, ok := i.config.DataStreamPipelines[dataStreamName]; ok {
+			return pipeline
+		}
+		if pipeline, ok := i.config.DataStreamPipelines[dataStreamType]; ok {
+			return pipeline
 		}
 	}
-	out.RawByte('}')
-	return nil
+	return i.config.Pipeline
 }
 
-func encodeAny(v interface{}, out *fastjson.Writer) error {
-	switch v := v.(type) {
-	case mapstr.M:
-		return encodeMap(v, out)
-	case map[string]interface{}:
-		return encodeMap(v, out)
-	default:
-		return fastjson.Marshal(out, v)
+// indexEvent returns an event with the data stream of the given index, for
+// routing a document as an event of its data stream would be routed, so
+// that per data stream type flush settings apply.
+func indexEvent(index string) *model.APMEvent {
+	var event model.APMEvent
+	if typ, dataset, namespace, ok := splitDataStreamName(index); ok {
+		event.DataStream = model.DataStream{Type: typ, Dataset: dataset, Namespace: namespace}
 	}
+	return &event
 }
 
-func encodeMap(v map[string]interface{}, out *fastjson.Writer) error {
-	out.RawByte('{')
-	first := true
-	for k, v := range v {
-		if first {
-			first = false
-		} else {
-			out.RawByte(',')
-		}
-		out.String(k)
-		out.RawByte(':')
-		if err := encodeAny(v, out); err != nil {
-			return err
+// sendBulkItem sends item to the event's bulk items channel, waiting for
+// space in the queue if necessary.
+func (i *Indexer) sendBulkItem(ctx context.Context, event *model.APMEvent, item elasticsearch.BulkIndexerItem) error {
+	bulkItems := i.bulkItemsChannel(event)
+	select {
+	case <-i.closed:
+		return ErrClosed
+	case bulkItems <- item:
+	default:
+		// The queue is full; wait for space.
+		select {
+		case <-ctx.Done():
+			return queueFullError{err: ctx.Err()}
+		case <-i.closed:
+			return ErrClosed
+		case bulkItems <- item:
 		}
 	}
-	out.RawByte('}')
 	return nil
 }
 
-func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
-	n := bulkIndexer.Items()
+// bulkItemsChannel returns the channel to which the event's bulk item
+// should be sent. Events matching the Priority configuration are sent to
+// the priority channel, and events of the data stream types in
+// DataStreamFlush are sent to their type's channel. Otherwise, if
+// OrderByTrace is enabled, events are partitioned by trace ID; events
+// without a trace ID are distributed round-robin.
+func (i *Indexer) bulkItemsChannel(event *model.APMEvent) chan<- elasticsearch.BulkIndexerItem {
+	if i.priorityItems != nil && i.config.Priority.prioritised(event) {
+		return i.priorityItems
+	}
+	if items, ok := i.dataStreamItems[event.DataStream.Type]; ok {
+		return items
+	}
+	if len(i.partitions) == 0 {
+		return i.bulkItems
+	}
+	var p uint64
+	if event.Trace.ID != "" {
+		p = xxhash.Sum64String(event.Trace.ID)
+	} else {
+		p = atomic.AddUint64(&i.unorderedItems, 1)
+	}
+	return i.partitions[p%uint64(len(i.partitions))]
+}
+
+func (i *Indexer) flush(ctx context.Context, buf OutputBuffer) error {
+	n := buf.Items()
 	if n == 0 {
 		return nil
 	}
@@ -416,13 +1256,60 @@ func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
 		}
 	}
 
-	resp, err := bulkIndexer.Flush(ctx)
+	// Pause while the circuit breaker is open, rather than sending
+	// requests to a cluster that is known to be unavailable. The pause
+	// does not count towards the flush timeout.
+	var err error
+	if i.breaker != nil {
+		err = i.breaker.wait(ctx)
+	}
+
+	if i.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, i.config.Timeout)
+		defer cancel()
+	}
+
+	bulkIndexer, ok := buf.(*bulkIndexer)
+	if !ok {
+		return i.deliver(ctx, logger, tx, buf, n)
+	}
+	var resp elasticsearch.BulkIndexerResponse
+	if err == nil {
+		resp, err = bulkIndexer.Flush(ctx)
+		if i.failover != nil {
+			i.failover.recordFlush(err, time.Now())
+		}
+		if i.breaker != nil {
+			i.breaker.recordFlush(err, time.Now())
+		}
+	}
 	// Record the bulkIndexer buffer's length as the bytesTotal metric after
 	// the request has been flushed.
 	if flushed := bulkIndexer.BytesFlushed(); flushed > 0 {
 		atomic.AddInt64(&i.bytesTotal, int64(flushed))
 	}
 	if err != nil {
+		var errTooLarge errorRequestEntityTooLarge
+		if errors.As(err, &errTooLarge) {
+			atomic.AddInt64(&i.bulkRequestsTooLarge, 1)
+			if i.splitTooLarge(logger, bulkIndexer) {
+				return nil
+			}
+		}
+		if i.config.UnavailableBuffer.MaxBytes > 0 && isUnavailableError(err) {
+			if n = i.holdUnavailable(bulkIndexer); n == 0 {
+				logger.With(logp.Error(err)).Warn("elasticsearch unavailable, holding events to retry")
+				return nil
+			}
+		}
+		if i.config.Timeout > 0 && errors.Is(err, context.DeadlineExceeded) {
+			atomic.AddInt64(&i.bulkRequestsTimedOut, 1)
+			if n = i.retryTimedOut(bulkIndexer); n == 0 {
+				logger.With(logp.Error(err)).Warn("bulk indexing request timed out, retrying events")
+				return nil
+			}
+		}
 		atomic.AddInt64(&i.eventsFailed, int64(n))
 		logger.With(logp.Error(err)).Error("bulk indexing request failed")
 		if tx != nil {
@@ -434,17 +1321,68 @@ func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
 		// 429 may be returned as errors from the bulk indexer.
 		if errors.As(err, &errTooMany) {
 			atomic.AddInt64(&i.tooManyRequests, int64(n))
+			i.recordFailedByStatus(map[int]int64{http.StatusTooManyRequests: int64(n)})
 		}
+		bulkIndexer.NotifyItems(ctx, resp, err)
 		return err
 	}
 	var eventsFailed, eventsIndexed, tooManyRequests int64
-	for _, item := range resp.Items {
+	var deadLetterIndexed, deadLetterFailed int64
+	var mappingErrors map[string]int
+	var failedByStatus map[int]int64
+	var retryItems, deadLetterItems []elasticsearch.BulkIndexerItem
+	var retryAttempts int
+	for position, item := range resp.Items {
+		if isDeadLetter(bulkIndexer, position) {
+			for _, info := range item {
+				if info.Error.Type != "" || info.Status > 201 {
+					deadLetterFailed++
+					logger.Errorf(
+						"failed to index event into dead letter index (%s): %s",
+						info.Error.Type, info.Error.Reason,
+					)
+				} else {
+					deadLetterIndexed++
+				}
+			}
+			continue
+		}
 		for _, info := range item {
 			if info.Error.Type != "" || info.Status > 201 {
-				eventsFailed++
 				if info.Status == http.StatusTooManyRequests {
 					tooManyRequests++
 				}
+				if isRetryableStatus(info.Status) {
+					retry, attempts, ok := retryItem(
+						bulkIndexer, position, i.config.DocumentRetry.MaxAttempts,
+					)
+					if ok {
+						retryItems = append(retryItems, retry)
+						if attempts > retryAttempts {
+							retryAttempts = attempts
+						}
+						continue
+					}
+				}
+				eventsFailed++
+				if failedByStatus == nil {
+					failedByStatus = make(map[int]int64)
+				}
+				failedByStatus[info.Status]++
+				if i.rollover != nil && isMappingError(info.Error.Type) {
+					if mappingErrors == nil {
+						mappingErrors = make(map[string]int)
+					}
+					mappingErrors[dataStreamName(info.Index)]++
+				}
+				if i.config.DeadLetter.Index != "" && isDeadLetterStatus(info.Status) {
+					if original, doc, ok := bulkIndexer.Document(position); ok {
+						deadLetterItems = append(deadLetterItems, newDeadLetterItem(
+							i.config.DeadLetter.Index, original, doc,
+							elasticsearch.BulkIndexerResponseItem(info), time.Now(),
+						))
+					}
+				}
 				logger.Errorf(
 					"failed to index event (%s): %s",
 					info.Error.Type, info.Error.Reason,
@@ -456,6 +1394,7 @@ func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
 	}
 	if eventsFailed > 0 {
 		atomic.AddInt64(&i.eventsFailed, eventsFailed)
+		i.recordFailedByStatus(failedByStatus)
 	}
 	if eventsIndexed > 0 {
 		atomic.AddInt64(&i.eventsIndexed, eventsIndexed)
@@ -463,9 +1402,54 @@ func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
 	if tooManyRequests > 0 {
 		atomic.AddInt64(&i.tooManyRequests, tooManyRequests)
 	}
+	if deadLetterIndexed > 0 {
+		atomic.AddInt64(&i.deadLetterIndexed, deadLetterIndexed)
+	}
+	if deadLetterFailed > 0 {
+		atomic.AddInt64(&i.deadLetterFailed, deadLetterFailed)
+	}
+	if len(mappingErrors) > 0 {
+		i.rollover.recordMappingErrors(ctx, mappingErrors, time.Now())
+	}
 	logger.Debugf(
-		"bulk request completed: %d indexed, %d failed (%d exceeded capacity)",
-		eventsIndexed, eventsFailed, tooManyRequests,
+		"bulk request completed: %d indexed, %d failed, %d retried (%d exceeded capacity)",
+		eventsIndexed, eventsFailed, len(retryItems), tooManyRequests,
+	)
+	bulkIndexer.NotifyItems(ctx, resp, nil)
+	if len(retryItems) > 0 {
+		i.retryItems(retryItems, retryAttempts)
+	}
+	if len(deadLetterItems) > 0 {
+		i.deadLetterItems(deadLetterItems)
+	}
+	return nil
+}
+
+// deliver delivers the items buffered for a custom Output, recording the
+// outcome in the indexer's stats. Failed items are not retried.
+func (i *Indexer) deliver(ctx context.Context, logger *logp.Logger, tx *apm.Transaction, buf OutputBuffer, n int) error {
+	result, err := buf.Deliver(ctx)
+	if result.BytesFlushed > 0 {
+		atomic.AddInt64(&i.bytesTotal, result.BytesFlushed)
+	}
+	if err != nil {
+		atomic.AddInt64(&i.eventsFailed, int64(n))
+		logger.With(logp.Error(err)).Error("output request failed")
+		if tx != nil {
+			tx.Outcome = "failure"
+			apm.CaptureError(ctx, err).Send()
+		}
+		return err
+	}
+	if result.Failed > 0 {
+		atomic.AddInt64(&i.eventsFailed, result.Failed)
+	}
+	if result.Indexed > 0 {
+		atomic.AddInt64(&i.eventsIndexed, result.Indexed)
+	}
+	logger.Debugf(
+		"output request completed: %d indexed, %d failed",
+		result.Indexed, result.Failed,
 	)
 	return nil
 }
@@ -475,24 +1459,77 @@ func (i *Indexer) flush(ctx context.Context, bulkIndexer *bulkIndexer) error {
 // will be pulled out of the queue, but also the more likely it is that the
 // outgoing Elasticsearch bulk requests are flushed due to the idle timer,
 // rather than due to being full.
-func (i *Indexer) runActiveIndexer() {
+//
+// If OrderByTrace is enabled, each active indexer pulls items from its own
+// partition, and bulk requests are flushed synchronously.
+//
+// The active indexer flushes bulk requests according to policy. Dedicated
+// active indexers, for data stream types in DataStreamFlush, are not scaled.
+// Other active indexers also pull items from the priority queue, if Priority
+// is configured, ahead of items in bulkItems.
+func (i *Indexer) runActiveIndexer(bulkItems chan elasticsearch.BulkIndexerItem, policy flushPolicy) {
+	var priorityItems chan elasticsearch.BulkIndexerItem
+	if !policy.dedicated {
+		priorityItems = i.priorityItems
+	}
 	var closed bool
-	var active *bulkIndexer
+	var active OutputBuffer
 	var timedFlush uint
 	var fullFlush uint
-	flushTimer := time.NewTimer(i.config.FlushInterval)
+	// Scaling may be enabled or disabled at runtime, except for
+	// dedicated active indexers, which are never scaled.
+	scaling := func() bool {
+		return !policy.dedicated && !i.ScalingConfig().Disabled
+	}
+	state := i.addActiveIndexerState(policy.queue)
+	defer i.removeActiveIndexerState(state)
+	flushTimer := time.NewTimer(policy.flushInterval)
 	if !flushTimer.Stop() {
 		<-flushTimer.C
 	}
+	flushActive := func() {
+		indexer := active
+		active = nil
+		state.set(0, 0)
+		flush := func() error {
+			err := i.flush(i.errgroupContext, indexer)
+			indexer.Reset()
+			i.available <- indexer
+			atomic.AddInt64(&i.availableBulkRequests, 1)
+			return err
+		}
+		if i.config.OrderByTrace {
+			// Wait for the flush to complete before adding more
+			// items, to preserve ordering within the partition.
+			// Errors are returned from Close, as they would be
+			// for asynchronous flushes.
+			if err := flush(); err != nil {
+				i.errgroup.Go(func() error { return err })
+			}
+		} else {
+			i.errgroup.Go(flush)
+		}
+	}
 	handleBulkItem := func(event elasticsearch.BulkIndexerItem) {
+		if active != nil && i.exceedsMaxRequestBytes(active, event) {
+			// Adding the event would make the bulk request too large
+			// for Elasticsearch to accept, so flush the buffered events
+			// and add the event to a new bulk request.
+			if !flushTimer.Stop() {
+				<-flushTimer.C
+			}
+			atomic.AddInt64(&i.bulkRequestsSplit, 1)
+			flushActive()
+		}
 		if active == nil {
 			active = <-i.available
 			atomic.AddInt64(&i.availableBulkRequests, -1)
-			flushTimer.Reset(i.config.FlushInterval)
+			flushTimer.Reset(policy.flushInterval)
 		}
 		if err := active.Add(event); err != nil {
 			i.logger.Errorf("failed adding event to bulk indexer: %v", err)
 		}
+		state.set(active.Items(), active.Len())
 	}
 	for !closed {
 		select {
@@ -503,54 +1540,64 @@ func (i *Indexer) runActiveIndexer() {
 			// When there's no active indexer and queue utilization is below 5%,
 			// reset the flushTimer with IdleInterval so excess active indexers
 			// that remain idle can be scaled down.
-			if !i.config.Scaling.Disabled && active == nil {
+			if active == nil && scaling() {
 				if i.scalingInformation().activeIndexers > 1 &&
-					float64(len(i.bulkItems))/float64(cap(i.bulkItems)) <= 0.05 {
-					flushTimer.Reset(i.config.Scaling.IdleInterval)
+					float64(len(bulkItems))/float64(cap(bulkItems)) <= 0.05 {
+					flushTimer.Reset(i.ScalingConfig().IdleInterval)
 				}
 			}
+			// Take prioritised items ahead of any other items, or
+			// wait for the next item of either kind.
+			var event elasticsearch.BulkIndexerItem
+			var received bool
 			select {
-			case <-i.closed:
-				// Consume whatever bulk items have been buffered,
-				// and then flush a last time below.
-				for len(i.bulkItems) > 0 {
-					select {
-					case event := <-i.bulkItems:
-						handleBulkItem(event)
-					default:
-						// Another goroutine took the item.
+			case event = <-priorityItems:
+				received = true
+			default:
+				select {
+				case <-i.closed:
+					// Consume whatever bulk items have been buffered,
+					// and then flush a last time below.
+					i.stopRequeue()
+					for _, items := range [...]chan elasticsearch.BulkIndexerItem{priorityItems, bulkItems} {
+						for len(items) > 0 {
+							select {
+							case event := <-items:
+								handleBulkItem(event)
+							default:
+								// Another goroutine took the item.
+							}
+						}
 					}
+					closed = true
+				case <-flushTimer.C:
+					timedFlush++
+					fullFlush = 0
+				case event = <-priorityItems:
+					received = true
+				case event = <-bulkItems:
+					received = true
 				}
-				closed = true
-			case <-flushTimer.C:
-				timedFlush++
-				fullFlush = 0
-			case event := <-i.bulkItems:
+			}
+			if received {
 				handleBulkItem(event)
-				if active.Len() < i.config.FlushBytes {
+				if active.Len() < policy.flushBytes &&
+					(i.config.FlushDocs <= 0 || active.Items() < i.config.FlushDocs) {
 					continue
 				}
 				fullFlush++
 				timedFlush = 0
 				// The active indexer is at or exceeds the configured FlushBytes
-				// threshold, so flush it.
+				// or FlushDocs threshold, so flush it.
 				if !flushTimer.Stop() {
 					<-flushTimer.C
 				}
 			}
 		}
 		if active != nil {
-			indexer := active
-			active = nil
-			i.errgroup.Go(func() error {
-				err := i.flush(i.errgroupContext, indexer)
-				indexer.Reset()
-				i.available <- indexer
-				atomic.AddInt64(&i.availableBulkRequests, 1)
-				return err
-			})
+			flushActive()
 		}
-		if i.config.Scaling.Disabled {
+		if !scaling() {
 			continue
 		}
 		now := time.Now()
@@ -562,11 +1609,15 @@ func (i *Indexer) runActiveIndexer() {
 		if i.maybeScaleUp(now, info, &fullFlush) {
 			atomic.AddInt64(&i.activeCreated, 1)
 			i.errgroup.Go(func() error {
-				i.runActiveIndexer()
+				i.runActiveIndexer(bulkItems, policy)
 				return nil
 			})
 		}
 	}
+	if policy.dedicated {
+		// Dedicated active indexers are not counted in scalingInfo.
+		return
+	}
 	// Decrement the active bulk requests when Indexer is closed.
 	for {
 		info := i.scalingInformation()
@@ -579,10 +1630,122 @@ func (i *Indexer) runActiveIndexer() {
 	}
 }
 
+// exceedsMaxRequestBytes reports whether adding item to the non-empty bulk
+// indexer b would cause its request body to exceed MaxRequestBytes, or the
+// limit learned from requests rejected as too large if that is smaller.
+func (i *Indexer) exceedsMaxRequestBytes(b OutputBuffer, item elasticsearch.BulkIndexerItem) bool {
+	if b.Items() == 0 {
+		return false
+	}
+	n, err := b.ItemLen(item)
+	if err != nil {
+		i.logger.Errorf("failed to determine bulk item size: %v", err)
+		return false
+	}
+	return b.UncompressedLen()+n > i.maxRequestBytes()
+}
+
+// maxRequestBytes returns the effective maximum size of a bulk request body:
+// the smaller of MaxRequestBytes and the learned limit, if any.
+func (i *Indexer) maxRequestBytes() int {
+	limit := i.config.MaxRequestBytes
+	if learned := int(atomic.LoadInt64(&i.learnedMaxRequestBytes)); learned > 0 && learned < limit {
+		limit = learned
+	}
+	return limit
+}
+
+// learnMaxRequestBytes lowers the learned request size limit to limit,
+// unless it is already lower, and returns the resulting learned limit.
+func (i *Indexer) learnMaxRequestBytes(limit int) int {
+	for {
+		learned := atomic.LoadInt64(&i.learnedMaxRequestBytes)
+		if learned > 0 && learned <= int64(limit) {
+			return int(learned)
+		}
+		if atomic.CompareAndSwapInt64(&i.learnedMaxRequestBytes, learned, int64(limit)) {
+			return limit
+		}
+	}
+}
+
+// retryTimedOut re-enqueues the items of a bulk request that timed out, up
+// to Config.TimeoutMaxAttempts, and returns the number of items that could
+// not be retried. Dead letter items are not retried.
+func (i *Indexer) retryTimedOut(b *bulkIndexer) int {
+	var items []elasticsearch.BulkIndexerItem
+	var attempts int
+	for position := 0; position < b.Items(); position++ {
+		if isDeadLetter(b, position) {
+			continue
+		}
+		item, n, ok := retryItem(b, position, i.config.TimeoutMaxAttempts)
+		if !ok {
+			continue
+		}
+		items = append(items, item)
+		if n > attempts {
+			attempts = n
+		}
+	}
+	if len(items) > 0 {
+		i.retryItems(items, attempts)
+	}
+	return b.Items() - len(items)
+}
+
+// splitTooLarge handles a bulk request that Elasticsearch rejected with
+// 413 Request Entity Too Large, e.g. due to http.max_content_length. The
+// effective maximum request size is halved to below the rejected request's
+// size, and the request's items are re-enqueued so they are sent again in
+// smaller requests.
+//
+// splitTooLarge returns false if the items cannot be split, because the
+// request holds a single item, or because ordering by trace is enabled;
+// the caller should then count the items as failed.
+func (i *Indexer) splitTooLarge(logger *logp.Logger, b *bulkIndexer) bool {
+	n := b.Items()
+	if n < 2 {
+		return false
+	}
+	size := b.UncompressedLen()
+	limit := i.learnMaxRequestBytes(size / 2)
+	if i.config.OrderByTrace {
+		// Re-enqueued items would be indexed after items added later,
+		// so they are not split when ordering by trace.
+		return false
+	}
+	items, err := b.SplitItems()
+	if err != nil {
+		logger.With(logp.Error(err)).Error("failed to split bulk request")
+		return false
+	}
+	for position := range items {
+		// Preserve the retry attempts, dead letter status,
+		// and hold time of retained items.
+		if original, doc, ok := b.Document(position); ok {
+			items[position].Body = retainedStateOf(original.Body).body(append([]byte(nil), doc...))
+		}
+	}
+	logger.Warnf(
+		"bulk request of %d bytes rejected as too large, splitting %d events into requests of at most %d bytes",
+		size, n, limit,
+	)
+	atomic.AddInt64(&i.eventsActive, int64(len(items)))
+	i.errgroup.Go(func() error {
+		if remaining := i.requeueItems(items, i.retainedItemChannel); len(remaining) > 0 {
+			i.failRetryItems(remaining)
+		}
+		return nil
+	})
+	return true
+}
+
 // maybeScaleDown returns true if the caller (assumed to be active indexer) needs
 // to be scaled down. It automatically updates the scaling information with a
 // decremented `activeBulkRequests` and timestamp of the action when true.
 func (i *Indexer) maybeScaleDown(now time.Time, info scalingInfo, timedFlush *uint) bool {
+	cfg := i.ScalingConfig()
 	// Only downscale when there is more than 1 active indexer.
 	if info.activeIndexers == 1 {
 		return false
@@ -597,15 +1760,16 @@ func (i *Indexer) maybeScaleDown(now time.Time, info scalingInfo, timedFlush *ui
 	for info.activeIndexers > limit {
 		// Avoid having more than 1 concurrent downscale, by using a compare
 		// and swap operation.
-		if i.scalingInfo.CompareAndSwap(info, info.ScaleDown(now)) {
+		if new := info.ScaleDown(now); i.scalingInfo.CompareAndSwap(info, new) {
 			i.logger.Infof("active indexers (%d) > active limit (%d), scaling down",
 				info.activeIndexers, limit,
 			)
+			notifyScale(cfg, ScaleDown, "active_limit", new)
 			return true
 		}
 		info = i.scalingInformation() // refresh scaling info if CAS failed.
 	}
-	if info.withinCoolDown(i.config.Scaling.ScaleDown.CoolDown, now) {
+	if info.withinCoolDown(cfg.ScaleDown.CoolDown, now) {
 		return false
 	}
 	// If more than 1% of the requests result in 429, scale down the current
@@ -616,18 +1780,20 @@ func (i *Indexer) maybeScaleDown(now time.Time, info scalingInfo, timedFlush *ui
 				"elasticsearch 429 response rate exceeded 1%%, scaling down to: %d",
 				new.activeIndexers,
 			)
+			notifyScale(cfg, ScaleDown, "too_many_requests", new)
 			return true
 		}
 		return false
 	}
-	if *timedFlush < i.config.Scaling.ScaleDown.Threshold {
+	if *timedFlush < cfg.ScaleDown.Threshold {
 		return false
 	}
 	// Reset timedFlush after it has exceeded the threshold
 	// it avoids unnecessary precociousness to scale down.
 	*timedFlush = 0
 	if new := info.ScaleDown(now); i.scalingInfo.CompareAndSwap(info, new) {
-		i.logger.Infof("timed flush threshold exceeded, scaling down to: %d", new)
+		i.logger.Infof("timed flush threshold exceeded, scaling down to: %d", new.activeIndexers)
+		notifyScale(cfg, ScaleDown, "timed_flush", new)
 		return true
 	}
 	return false
@@ -638,7 +1804,8 @@ func (i *Indexer) maybeScaleDown(now time.Time, info scalingInfo, timedFlush *ui
 // updates the scaling information with an incremented `activeBulkRequests` and
 // timestamp of the action when true.
 func (i *Indexer) maybeScaleUp(now time.Time, info scalingInfo, fullFlush *uint) bool {
-	if *fullFlush < i.config.Scaling.ScaleUp.Threshold {
+	cfg := i.ScalingConfig()
+	if *fullFlush < cfg.ScaleUp.Threshold {
 		return false
 	}
 	if info.activeIndexers >= activeLimit() {
@@ -651,7 +1818,7 @@ func (i *Indexer) maybeScaleUp(now time.Time, info scalingInfo, fullFlush *uint)
 	if i.indexFailureRate() >= 0.01 {
 		return false
 	}
-	if info.withinCoolDown(i.config.Scaling.ScaleUp.CoolDown, now) {
+	if info.withinCoolDown(cfg.ScaleUp.CoolDown, now) {
 		return false
 	}
 	// Avoid having more than 1 concurrent upscale, by using a compare
@@ -660,6 +1827,7 @@ func (i *Indexer) maybeScaleUp(now time.Time, info scalingInfo, fullFlush *uint)
 		i.logger.Infof("full flush threshold exceeded, scaling up to: %d",
 			new.activeIndexers,
 		)
+		notifyScale(cfg, ScaleUp, "full_flush", new)
 		return true
 	}
 	return false
@@ -669,6 +1837,63 @@ func (i *Indexer) scalingInformation() scalingInfo {
 	return i.scalingInfo.Load().(scalingInfo)
 }
 
+// ScalingConfig returns the current scaling configuration, with defaults
+// applied.
+func (i *Indexer) ScalingConfig() ScalingConfig {
+	return i.scalingConfig.Load().(ScalingConfig)
+}
+
+// SetScalingConfig replaces the scaling configuration at runtime, applying
+// defaults to unspecified thresholds and intervals. SetScalingConfig is safe
+// for concurrent use, and takes effect from the next scaling decision of each
+// active indexer.
+//
+// Disabling scaling stops active indexers from being created or destroyed,
+// leaving the current number of active indexers in place. Scaling cannot be
+// enabled when OrderByTrace is enabled, as active indexers are then pinned
+// to partitions.
+func (i *Indexer) SetScalingConfig(cfg ScalingConfig) error {
+	if i.config.OrderByTrace && !cfg.Disabled {
+		return errors.New("scaling cannot be enabled when ordering by trace")
+	}
+	i.scalingConfig.Store(scalingConfigWithDefaults(cfg))
+	return nil
+}
+
+// scalingConfigWithDefaults returns cfg with defaults applied to its
+// unspecified thresholds and intervals.
+func scalingConfigWithDefaults(cfg ScalingConfig) ScalingConfig {
+	if cfg.ScaleDown.Threshold == 0 {
+		cfg.ScaleDown.Threshold = 30
+	}
+	if cfg.ScaleDown.CoolDown <= 0 {
+		cfg.ScaleDown.CoolDown = 30 * time.Second
+	}
+	if cfg.ScaleUp.Threshold == 0 {
+		cfg.ScaleUp.Threshold = 60
+	}
+	if cfg.ScaleUp.CoolDown <= 0 {
+		cfg.ScaleUp.CoolDown = time.Minute
+	}
+	if cfg.IdleInterval <= 0 {
+		cfg.IdleInterval = 30 * time.Second
+	}
+	return cfg
+}
+
+// notifyScale calls cfg.OnScale, if non-nil, for a scaling action which
+// resulted in info.
+func notifyScale(cfg ScalingConfig, action ScaleAction, reason string, info scalingInfo) {
+	if cfg.OnScale != nil {
+		cfg.OnScale(ScaleEvent{
+			Action:         action,
+			Reason:         reason,
+			ActiveIndexers: info.activeIndexers,
+			Time:           info.lastAction,
+		})
+	}
+}
+
 // indexFailureRate returns the decimal percentage of 429 / total events.
 func (i *Indexer) indexFailureRate() float64 {
 	return float64(atomic.LoadInt64(&i.tooManyRequests)) /
@@ -744,19 +1969,67 @@ type Stats struct {
 	// Active holds the active number of items waiting in the indexer's queue.
 	Active int64
 
+	// ActiveOldestAge holds the age of the oldest item that has been added
+	// to a bulk request, but not yet flushed. ActiveOldestAge is zero when
+	// there are no buffered or in-flight bulk request items.
+	ActiveOldestAge time.Duration
+
+	// Queued holds the number of items waiting in the internal queue to be
+	// added to a bulk request.
+	Queued int64
+
+	// QueuedPriority holds the number of prioritised items waiting in the
+	// priority queue to be added to a bulk request, included in Queued.
+	QueuedPriority int64
+
 	// Added holds the number of items added to the indexer.
 	Added int64
 
 	// BulkRequests holds the number of bulk requests completed.
 	BulkRequests int64
 
+	// BulkRequestsSplit holds the number of times a bulk request was flushed
+	// early, because adding an event would have exceeded MaxRequestBytes.
+	BulkRequestsSplit int64
+
+	// BulkRequestsTooLarge holds the number of bulk requests rejected by
+	// Elasticsearch with 413 Request Entity Too Large. The events of such
+	// requests are split into smaller requests where possible.
+	BulkRequestsTooLarge int64
+
+	// LearnedMaxRequestBytes holds the maximum bulk request size learned
+	// from requests rejected with 413 Request Entity Too Large, or zero if
+	// no request has been rejected. Bulk requests are flushed early so as
+	// not to exceed the smaller of this and Config.MaxRequestBytes.
+	LearnedMaxRequestBytes int64
+
+	// BulkRequestsTimedOut holds the number of bulk requests that did not
+	// complete within Config.Timeout.
+	BulkRequestsTimedOut int64
+
 	// Failed holds the number of indexing operations that failed.
 	Failed int64
 
+	// FailedByStatus holds the number of indexing operations that failed,
+	// keyed by the HTTP status code returned for each. Failed requests for
+	// which Elasticsearch returned no per-document status, other than 429
+	// Too Many Requests, are not included. FailedByStatus is nil if there
+	// have been no such failures.
+	FailedByStatus map[int]int64
+
 	// Indexed holds the number of indexing operations that have completed
 	// successfully.
 	Indexed int64
 
+	// Retried holds the number of indexing operations that failed with a
+	// retryable status, and whose documents were re-enqueued to be sent
+	// again. Documents retried more than once are counted each time.
+	Retried int64
+
+	// Held holds the number of documents currently held in memory while
+	// Elasticsearch is unavailable, waiting to be sent again.
+	Held int64
+
 	// TooManyRequests holds the number of indexing operations that failed due
 	// to Elasticsearch responding with 429 Too many Requests.
 	TooManyRequests int64
@@ -782,4 +2055,82 @@ type Stats struct {
 
 	// Downscales represents the number of times an active indexer was destroyed.
 	IndexersDestroyed int64
+
+	// Failover holds statistics for the warm standby failover, if configured.
+	Failover FailoverStats
+
+	// CircuitBreaker holds statistics for the circuit breaker, if enabled.
+	CircuitBreaker CircuitBreakerStats
+
+	// Rollover holds statistics for data stream rollovers triggered by
+	// mapping errors, if enabled.
+	Rollover RolloverStats
+
+	// DataStreams holds statistics for data streams created before
+	// indexing, if enabled.
+	DataStreams DataStreamStats
+
+	// DeadLetter holds statistics for rejected documents indexed into
+	// the dead letter index, if configured.
+	DeadLetter DeadLetterStats
+}
+
+// FailoverStats holds warm standby failover statistics.
+type FailoverStats struct {
+	// Active is 1 when bulk requests are being routed to the standby
+	// cluster, and 0 otherwise.
+	Active int64
+
+	// Failovers holds the number of times bulk requests have been routed
+	// from the primary cluster to the standby cluster.
+	Failovers int64
+}
+
+// CircuitBreakerStats holds circuit breaker statistics.
+type CircuitBreakerStats struct {
+	// Open is 1 when the circuit breaker is open, and bulk requests
+	// are paused, and 0 otherwise.
+	Open int64
+
+	// Opened holds the number of times the circuit breaker has opened.
+	Opened int64
+}
+
+// RolloverStats holds statistics for data stream rollovers triggered by
+// mapping errors.
+type RolloverStats struct {
+	// Rollovers holds the number of data stream rollovers performed.
+	Rollovers int64
+
+	// Failed holds the number of data stream rollover requests that failed.
+	Failed int64
+}
+
+// DataStreamStats holds statistics for data streams created before indexing.
+type DataStreamStats struct {
+	// Created holds the number of data streams created.
+	Created int64
+
+	// Failed holds the number of data stream creation requests that failed.
+	Failed int64
+}
+
+// StatsSnapshot holds a snapshot of bulk indexing statistics returned by
+// Indexer.StatsDelta.
+type StatsSnapshot struct {
+	// Stats holds the change in counters since the previous snapshot,
+	// and the current values of gauges.
+	Stats
+
+	// Sequence holds the snapshot's sequence number. Sequence numbers
+	// increase monotonically across all Indexers in the process.
+	Sequence uint64
+
+	// Reset is true if the previous snapshot was the zero value or was
+	// taken from a different Indexer, in which case Stats holds totals
+	// since the Indexer was created rather than a change.
+	Reset bool
+
+	indexerID uint64
+	total     Stats
 }
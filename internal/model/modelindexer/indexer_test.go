@@ -23,6 +23,7 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"runtime"
@@ -33,8 +34,10 @@ import (
 	"time"
 
 	"github.com/gofrs/uuid"
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
 	"go.elastic.co/apm/v2/apmtest"
 	"go.elastic.co/fastjson"
 	"go.uber.org/zap/zaptest/observer"
@@ -98,8 +101,9 @@ loop:
 		case <-time.After(10 * time.Millisecond):
 			// Because the internal channel is buffered to increase performance,
 			// the available indexer may not take events right away, loop until
-			// the available bulk requests has been lowered.
-			if indexer.Stats().AvailableBulkRequests < available {
+			// the available bulk requests has been lowered and the queue has
+			// been drained.
+			if stats := indexer.Stats(); stats.AvailableBulkRequests < available && stats.Queued == 0 {
 				break loop
 			}
 		case <-timeout:
@@ -107,12 +111,20 @@ loop:
 		}
 	}
 	// Indexer has not been flushed, there is one active bulk indexer.
-	assert.Equal(t, modelindexer.Stats{Added: N, Active: N, AvailableBulkRequests: 9, IndexersActive: 1}, indexer.Stats())
+	stats := indexer.Stats()
+	assert.NotZero(t, stats.ActiveOldestAge)
+	stats.ActiveOldestAge = 0
+	assert.Equal(t, modelindexer.Stats{Added: N, Active: N, AvailableBulkRequests: 9, IndexersActive: 1}, stats)
 
 	// Closing the indexer flushes enqueued events.
 	err = indexer.Close(context.Background())
 	require.NoError(t, err)
-	stats := indexer.Stats()
+	stats = indexer.Stats()
+	assert.Equal(t, map[int]int64{
+		http.StatusInternalServerError: 1,
+		http.StatusTooManyRequests:     1,
+	}, stats.FailedByStatus)
+	stats.FailedByStatus = nil
 	assert.Equal(t, modelindexer.Stats{
 		Added:                 N,
 		Active:                0,
@@ -126,6 +138,43 @@ loop:
 	assert.Equal(t, "observability", productOriginHeader)
 }
 
+func TestModelIndexerIndexDocument(t *testing.T) {
+	requests := make(chan []modelindexertest.BulkRequestItem, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		requests <- items
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute, MaxDocumentBytes: 32})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	doc := []byte(`{"message":"replayed"}`)
+	err = indexer.IndexDocument(context.Background(), "logs-apm_server-testing", doc)
+	require.NoError(t, err)
+	copy(doc, "modified") // doc may be reused once IndexDocument returns
+
+	err = indexer.IndexDocument(context.Background(), "logs-apm_server-testing", []byte(`{"message":"much too large to be indexed"}`))
+	assert.ErrorIs(t, err, modelindexer.ErrDocumentTooLarge)
+
+	require.NoError(t, indexer.Close(context.Background()))
+	items := <-requests
+	require.Len(t, items, 1)
+	assert.Equal(t, "create", items[0].Action)
+	assert.Equal(t, "logs-apm_server-testing", items[0].Index)
+	assert.Equal(t, `{"message":"replayed"}`, string(items[0].Document))
+
+	stats := indexer.Stats()
+	assert.Equal(t, int64(1), stats.Added)
+	assert.Equal(t, int64(1), stats.Indexed)
+}
+
 func TestModelIndexerAvailableBulkIndexers(t *testing.T) {
 	unblockRequests := make(chan struct{})
 	receivedFlush := make(chan struct{})
@@ -162,6 +211,8 @@ func TestModelIndexerAvailableBulkIndexers(t *testing.T) {
 	stats := indexer.Stats()
 	// FlushBytes is set arbitrarily low, forcing a flush on each new
 	// event. There should be no available bulk indexers.
+	assert.NotZero(t, stats.ActiveOldestAge)
+	stats.ActiveOldestAge = 0
 	assert.Equal(t, modelindexer.Stats{Added: N, Active: N, AvailableBulkRequests: 0, IndexersActive: 1}, stats)
 
 	close(unblockRequests)
@@ -177,6 +228,42 @@ func TestModelIndexerAvailableBulkIndexers(t *testing.T) {
 	}, stats)
 }
 
+func TestModelIndexerActiveOldestAge(t *testing.T) {
+	unblockRequests := make(chan struct{})
+	receivedFlush := make(chan struct{}, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		receivedFlush <- struct{}{}
+		<-unblockRequests
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute, FlushBytes: 1})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+	assert.Zero(t, indexer.Stats().ActiveOldestAge)
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	select {
+	case <-receivedFlush:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for request")
+	}
+
+	// The flushing bulk request is blocked, so the age of its oldest
+	// item must keep increasing.
+	time.Sleep(50 * time.Millisecond)
+	assert.GreaterOrEqual(t, indexer.Stats().ActiveOldestAge, 50*time.Millisecond)
+
+	close(unblockRequests)
+	require.NoError(t, indexer.Close(context.Background()))
+	assert.Zero(t, indexer.Stats().ActiveOldestAge)
+}
+
 func TestModelIndexerEncoding(t *testing.T) {
 	var indexed [][]byte
 	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
@@ -258,53 +345,921 @@ func TestModelIndexerCompressionLevel(t *testing.T) {
 	}, stats)
 }
 
-func TestModelIndexerFlushInterval(t *testing.T) {
-	requests := make(chan struct{}, 1)
+func TestModelIndexerCompressionZstd(t *testing.T) {
+	var contentEncodings, acceptEncodings []string
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		contentEncodings = append(contentEncodings, r.Header.Get("Content-Encoding"))
+		acceptEncodings = append(acceptEncodings, r.Header.Get("Accept-Encoding"))
+		_, result := modelindexertest.DecodeBulkRequest(r)
+
+		// Compress the response, as Elasticsearch may when zstd is accepted.
+		w.Header().Set("Content-Encoding", "zstd")
+		zw, err := zstd.NewWriter(w)
+		require.NoError(t, err)
+		json.NewEncoder(zw).Encode(result)
+		require.NoError(t, zw.Close())
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		Compression:      modelindexer.CompressionZstd,
+		CompressionLevel: gzip.DefaultCompression,
+		FlushInterval:    time.Minute,
+		FlushDocs:        1,
+		MaxRequests:      1,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	// Each event is sent in its own bulk request.
+	for i := 1; i <= 2; i++ {
+		batch := model.Batch{{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type: "logs", Dataset: "apm_server", Namespace: "testing",
+		}}}
+		err = indexer.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return indexer.Stats().Indexed == int64(i)
+		}, 10*time.Second, time.Millisecond)
+	}
+	err = indexer.Close(context.Background())
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"zstd", "zstd"}, contentEncodings)
+	assert.Equal(t, []string{"zstd, gzip", "zstd, gzip"}, acceptEncodings)
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), stats.Indexed)
+	assert.Equal(t, int64(0), stats.Failed)
+}
+
+func TestModelIndexerCompressionZstdUnsupported(t *testing.T) {
+	var contentEncodings []string
+	var indexed int
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		contentEncodings = append(contentEncodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") == "zstd" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		indexed += len(docs)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		Compression:      modelindexer.CompressionZstd,
+		CompressionLevel: gzip.BestSpeed,
+		FlushInterval:    time.Minute,
+		FlushDocs:        1,
+		MaxRequests:      1,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	// Each event is sent in its own bulk request.
+	for i := 1; i <= 2; i++ {
+		batch := model.Batch{{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type: "logs", Dataset: "apm_server", Namespace: "testing",
+		}}}
+		err = indexer.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			return indexer.Stats().Indexed == int64(i)
+		}, 10*time.Second, time.Millisecond)
+	}
+	err = indexer.Close(context.Background())
+	require.NoError(t, err)
+
+	// The rejected zstd request is sent again with gzip, which is then
+	// used for subsequent requests.
+	assert.Equal(t, []string{"zstd", "gzip", "gzip"}, contentEncodings)
+	assert.Equal(t, 2, indexed)
+	assert.Equal(t, int64(2), indexer.Stats().Indexed)
+}
+
+func TestModelIndexerInvalidCompression(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, err := modelindexer.New(client, modelindexer.Config{Compression: "brotli"})
+	assert.EqualError(t, err, `expected Compression to be one of "gzip" or "zstd", got "brotli"`)
+}
+
+func TestModelIndexerFlushInterval(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case requests <- struct{}{}:
+		}
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		// Default flush bytes is 5MB
+		FlushInterval: time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	select {
+	case <-requests:
+		t.Fatal("unexpected request, no events buffered")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	select {
+	case <-requests:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for request, flush interval elapsed")
+	}
+}
+
+func TestModelIndexerDataStreamFlush(t *testing.T) {
+	requests := make(chan []string, 10)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		var indices []string
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range modelindexertest.DecodeBulkRequestItems(r) {
+			indices = append(indices, item.Index)
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+		requests <- indices
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		DataStreamFlush: map[string]modelindexer.FlushConfig{
+			// Logs are flushed quickly, while metrics are flushed as soon
+			// as a single event has been added.
+			"logs":    {FlushInterval: time.Millisecond},
+			"metrics": {FlushBytes: 1},
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(dataStreamType string) model.APMEvent {
+		return model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type: dataStreamType, Dataset: "apm_server", Namespace: "testing",
+		}}
+	}
+	expectRequest := func(expected ...string) {
+		select {
+		case indices := <-requests:
+			assert.Equal(t, expected, indices)
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for request with %v", expected)
+		}
+	}
+
+	// The traces event is held back by the global flush interval,
+	// while the logs and metrics events are flushed separately.
+	batch := model.Batch{newEvent("traces"), newEvent("logs")}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	expectRequest("logs-apm_server-testing")
+
+	batch = model.Batch{newEvent("metrics")}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	expectRequest("metrics-apm_server-testing")
+
+	// Closing the indexer flushes the traces event.
+	err = indexer.Close(context.Background())
+	require.NoError(t, err)
+	expectRequest("traces-apm_server-testing")
+	assert.Equal(t, int64(3), indexer.Stats().Indexed)
+}
+
+func TestModelIndexerPriority(t *testing.T) {
+	requests := make(chan string, 10)
+	release := make(chan struct{})
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		require.Len(t, items, 1)
+		requests <- items[0].Index
+		<-release
+		var result elasticsearch.BulkIndexerResponse
+		result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+			items[0].Action: {Index: items[0].Index, Status: http.StatusCreated},
+		})
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		MaxRequests:   1,
+		FlushDocs:     1,
+		FlushInterval: time.Minute,
+		Priority:      modelindexer.PriorityConfig{DataStreamTypes: []string{"logs"}},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(dataStreamType string) model.APMEvent {
+		return model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type: dataStreamType, Dataset: "apm_server", Namespace: "testing",
+		}}
+	}
+	processBatch := func(batch ...model.APMEvent) {
+		require.NoError(t, indexer.ProcessBatch(context.Background(), (*model.Batch)(&batch)))
+	}
+
+	// The first event is flushed in the only bulk request, which blocks,
+	// and the active indexer waits for the bulk request with the second.
+	processBatch(newEvent("metrics"))
+	assert.Equal(t, "metrics-apm_server-testing", <-requests)
+	processBatch(newEvent("metrics"))
+	deadline := time.Now().Add(10 * time.Second)
+	for indexer.Stats().Queued != 0 {
+		require.True(t, time.Now().Before(deadline), "timed out waiting for event to be dequeued")
+		time.Sleep(time.Millisecond)
+	}
+
+	// The logs event is queued after the metrics events, but is flushed
+	// ahead of them.
+	processBatch(newEvent("metrics"), newEvent("metrics"), newEvent("logs"))
+	stats := indexer.Stats()
+	assert.Equal(t, int64(3), stats.Queued)
+	assert.Equal(t, int64(1), stats.QueuedPriority)
+	close(release)
+
+	var indices []string
+	for i := 0; i < 4; i++ {
+		select {
+		case index := <-requests:
+			indices = append(indices, index)
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for request")
+		}
+	}
+	assert.Equal(t, []string{
+		"metrics-apm_server-testing",
+		"logs-apm_server-testing",
+		"metrics-apm_server-testing",
+		"metrics-apm_server-testing",
+	}, indices)
+}
+
+func TestModelIndexerQueueState(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"items":[]}`))
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		MaxRequests:     2,
+		FlushInterval:   time.Minute,
+		EventBufferSize: 10,
+		DataStreamFlush: map[string]modelindexer.FlushConfig{"logs": {}},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(dataStreamType string) model.APMEvent {
+		return model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type: dataStreamType, Dataset: "apm_server", Namespace: "testing",
+		}}
+	}
+	batch := model.Batch{newEvent("metrics"), newEvent("logs"), newEvent("metrics")}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+
+	// Wait for the events to be dequeued and buffered by the
+	// active indexers, which flush only after FlushInterval.
+	var state modelindexer.QueueState
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		state = indexer.QueueState()
+		var buffered int64
+		for _, active := range state.ActiveIndexers {
+			buffered += active.Events
+		}
+		if buffered == 3 {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "timed out waiting for events to be buffered")
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, map[string]modelindexer.QueueLength{
+		"default": {Length: 0, Capacity: 10},
+		"logs":    {Length: 0, Capacity: 10},
+	}, state.Queues)
+	assert.Equal(t, int64(0), state.AvailableBulkRequests)
+	require.Len(t, state.ActiveIndexers, 2)
+	assert.Equal(t, "default", state.ActiveIndexers[0].Queue)
+	assert.Equal(t, int64(2), state.ActiveIndexers[0].Events)
+	assert.NotZero(t, state.ActiveIndexers[0].Bytes)
+	assert.Equal(t, "logs", state.ActiveIndexers[1].Queue)
+	assert.Equal(t, int64(1), state.ActiveIndexers[1].Events)
+	assert.NotZero(t, state.ActiveIndexers[1].Bytes)
+}
+
+func TestModelIndexerDataStreamFlushInvalid(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, err := modelindexer.New(client, modelindexer.Config{
+		DataStreamFlush: map[string]modelindexer.FlushConfig{"logs": {FlushBytes: -1}},
+	})
+	assert.EqualError(t, err, `expected non-negative flush thresholds for data stream type "logs"`)
+}
+
+func TestModelIndexerFlushBytes(t *testing.T) {
+	requests := make(chan struct{}, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requests <- struct{}{}:
+		default:
+		}
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushBytes: 1024,
+		// Default flush interval is 30 seconds
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	select {
+	case <-requests:
+		t.Fatal("unexpected request, flush bytes not exceeded")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	for i := 0; i < 100; i++ {
+		err = indexer.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+	}
+
+	select {
+	case <-requests:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for request, flush bytes exceeded")
+	}
+}
+
+func TestModelIndexerFlushDocs(t *testing.T) {
+	requests := make(chan int, 10)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		requests <- len(docs)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushDocs: 3,
+		// Default flush bytes is 1MB, and flush interval is 30 seconds.
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	for i := 0; i < 2; i++ {
+		err = indexer.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+	}
+
+	select {
+	case <-requests:
+		t.Fatal("unexpected request, flush docs not reached")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	select {
+	case n := <-requests:
+		assert.Equal(t, 3, n)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for request, flush docs reached")
+	}
+}
+
+func TestModelIndexerMaxRequestBytes(t *testing.T) {
+	var mu sync.Mutex
+	var requestDocs []int
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		mu.Lock()
+		requestDocs = append(requestDocs, len(docs))
+		mu.Unlock()
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		// The events are highly compressible, so the compressed bulk
+		// request never reaches FlushBytes; MaxRequestBytes applies to
+		// the uncompressed request body.
+		CompressionLevel: gzip.BestSpeed,
+		MaxRequestBytes:  5000,
+		FlushInterval:    time.Minute,
+	})
+	require.NoError(t, err)
+
+	batch := make(model.Batch, 5)
+	for i := range batch {
+		batch[i] = model.APMEvent{
+			Timestamp:  time.Now(),
+			DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+			Message:    strings.Repeat("x", 2000),
+		}
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	require.NoError(t, indexer.Close(context.Background()))
+
+	// Each bulk request holds at most two events, rather than all five
+	// events being sent in a single request that would be rejected.
+	assert.ElementsMatch(t, []int{2, 2, 1}, requestDocs)
+	stats := indexer.Stats()
+	assert.Equal(t, int64(3), stats.BulkRequests)
+	assert.Equal(t, int64(2), stats.BulkRequestsSplit)
+	assert.Equal(t, int64(5), stats.Indexed)
+}
+
+func TestModelIndexerRequestEntityTooLarge(t *testing.T) {
+	const maxContentLength = 5000
+	var mu sync.Mutex
+	var requestDocs []int
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		mu.Lock()
+		requestDocs = append(requestDocs, len(items))
+		mu.Unlock()
+		var size int
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			size += len(item.Document)
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		if size > maxContentLength {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		CompressionLevel: gzip.BestSpeed,
+		FlushDocs:        5,
+		FlushInterval:    50 * time.Millisecond,
+		// WaitForIndexing ensures the items' callbacks are carried
+		// over to the split requests.
+		WaitForIndexing: true,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := make(model.Batch, 5)
+	for i := range batch {
+		batch[i] = model.APMEvent{
+			Timestamp:  time.Now(),
+			DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+			Message:    strings.Repeat("x", 2000),
+		}
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	// The first request holding all five events is rejected, and
+	// its events are split into requests below the learned limit.
+	mu.Lock()
+	assert.Equal(t, []int{5, 2, 2, 1}, requestDocs)
+	mu.Unlock()
+	stats := indexer.Stats()
+	assert.Equal(t, int64(5), stats.Indexed)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.Equal(t, int64(1), stats.BulkRequestsTooLarge)
+	assert.Greater(t, stats.LearnedMaxRequestBytes, int64(maxContentLength))
+	assert.Less(t, stats.LearnedMaxRequestBytes, int64(2*maxContentLength))
+
+	// A single event that is too large cannot be split, and fails.
+	batch = model.Batch{batch[0]}
+	batch[0].Message = strings.Repeat("x", 2*maxContentLength)
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	assert.ErrorIs(t, err, modelindexer.ErrIndexingFailed)
+	stats = indexer.Stats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(2), stats.BulkRequestsTooLarge)
+}
+
+func TestModelIndexerTimeout(t *testing.T) {
+	var requests int64
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		if atomic.AddInt64(&requests, 1) == 1 {
+			// Hang until the client gives up on the first request.
+			<-r.Context().Done()
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	// Timed out requests are retried by default, even though
+	// DocumentRetry is not configured.
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Millisecond,
+		Timeout:       50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{{
+		Timestamp:  time.Now(),
+		DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+	}}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	// The event is retried in a new request after the first times out.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Indexed == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&requests))
+	assert.Equal(t, int64(1), stats.BulkRequestsTimedOut)
+	assert.Equal(t, int64(1), stats.Retried)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.NoError(t, indexer.Close(context.Background()))
+}
+
+func TestModelIndexerTimeoutMaxAttempts(t *testing.T) {
+	var requests int64
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		modelindexertest.DecodeBulkRequest(r)
+		atomic.AddInt64(&requests, 1)
+		<-r.Context().Done()
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval:      time.Millisecond,
+		Timeout:            10 * time.Millisecond,
+		TimeoutMaxAttempts: 2,
+		DocumentRetry: modelindexer.DocumentRetryConfig{
+			InitialBackoff: time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{{
+		Timestamp:  time.Now(),
+		DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+	}}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+
+	// The event is counted as failed once it has been sent
+	// TimeoutMaxAttempts times.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Failed == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), atomic.LoadInt64(&requests))
+	assert.Equal(t, int64(2), stats.BulkRequestsTimedOut)
+	assert.Equal(t, int64(1), stats.Retried)
+}
+
+func TestModelIndexerWaitForIndexing(t *testing.T) {
+	var failItems bool
+	var serverError bool
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if serverError {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		if failItems {
+			result.HasErrors = true
+			for action, item := range result.Items[1] {
+				item.Status = http.StatusBadRequest
+				item.Error.Type = "mapper_parsing_exception"
+				item.Error.Reason = "failed to parse"
+				result.Items[1][action] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval:   10 * time.Millisecond,
+		WaitForIndexing: true,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newBatch := func() *model.Batch {
+		batch := make(model.Batch, 3)
+		for i := range batch {
+			batch[i] = model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: "testing",
+			}}
+		}
+		return &batch
+	}
+
+	// ProcessBatch returns once the events have been indexed.
+	err = indexer.ProcessBatch(context.Background(), newBatch())
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), indexer.Stats().Indexed)
+
+	failItems = true
+	err = indexer.ProcessBatch(context.Background(), newBatch())
+	assert.ErrorIs(t, err, modelindexer.ErrIndexingFailed)
+	assert.EqualError(t, err, "failed to index events: 1 of 3 events: mapper_parsing_exception: failed to parse")
+	assert.Equal(t, int64(5), indexer.Stats().Indexed)
+
+	serverError = true
+	err = indexer.ProcessBatch(context.Background(), newBatch())
+	assert.ErrorIs(t, err, modelindexer.ErrIndexingFailed)
+	assert.ErrorContains(t, err, "3 of 3 events: flush failed")
+
+	// ProcessBatch stops waiting when the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = indexer.ProcessBatch(ctx, newBatch())
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestModelIndexerDocumentRetry(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		mu.Lock()
+		defer mu.Unlock()
+		for i, doc := range docs {
+			var event struct {
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &event))
+			attempts[event.Message]++
+
+			var status int
+			switch {
+			case event.Message == "unavailable" && attempts[event.Message] < 3:
+				status = http.StatusServiceUnavailable
+			case event.Message == "too_many_requests":
+				status = http.StatusTooManyRequests
+			default:
+				continue
+			}
+			result.HasErrors = true
+			for action, item := range result.Items[i] {
+				item.Status = status
+				item.Error.Type = "es_rejected_execution_exception"
+				item.Error.Reason = "rejected"
+				result.Items[i][action] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval:   10 * time.Millisecond,
+		WaitForIndexing: true,
+		DocumentRetry: modelindexer.DocumentRetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	var batch model.Batch
+	for _, message := range []string{"indexed", "unavailable", "too_many_requests"} {
+		batch = append(batch, model.APMEvent{
+			Timestamp: time.Now(),
+			Message:   message,
+			DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: "testing",
+			},
+		})
+	}
+
+	// ProcessBatch waits for retries to complete, and only fails
+	// for events which have exhausted their attempts.
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	assert.ErrorIs(t, err, modelindexer.ErrIndexingFailed)
+	assert.EqualError(t, err, "failed to index events: 1 of 3 events: es_rejected_execution_exception: rejected")
+
+	mu.Lock()
+	assert.Equal(t, map[string]int{
+		"indexed":           1,
+		"unavailable":       3,
+		"too_many_requests": 3,
+	}, attempts)
+	mu.Unlock()
+
+	stats := indexer.Stats()
+	assert.Equal(t, int64(3), stats.Added)
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, int64(2), stats.Indexed)
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(4), stats.Retried)
+	assert.Equal(t, int64(3), stats.TooManyRequests)
+}
+
+func TestModelIndexerDeadLetter(t *testing.T) {
+	deadLetters := make(chan []byte, 10)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			var event struct {
+				Message string `json:"message"`
+			}
+			require.NoError(t, json.Unmarshal(item.Document, &event))
+			responseItem := esutil.BulkIndexerResponseItem{Index: item.Index, Status: http.StatusCreated}
+			switch {
+			case item.Index == "logs-apm.dlq-testing":
+				deadLetters <- item.Document
+			case event.Message == "mapping":
+				responseItem.Status = http.StatusBadRequest
+				responseItem.Error.Type = "mapper_parsing_exception"
+				responseItem.Error.Reason = "failed to parse field [message]"
+			case event.Message == "too_many_requests":
+				responseItem.Status = http.StatusTooManyRequests
+				responseItem.Error.Type = "es_rejected_execution_exception"
+				responseItem.Error.Reason = "rejected"
+			}
+			if responseItem.Status != http.StatusCreated {
+				result.HasErrors = true
+			}
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: responseItem,
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: 10 * time.Millisecond,
+		DeadLetter:    modelindexer.DeadLetterConfig{Index: "logs-apm.dlq-testing"},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	var batch model.Batch
+	for _, message := range []string{"indexed", "mapping", "too_many_requests"} {
+		batch = append(batch, model.APMEvent{
+			Timestamp: time.Unix(123, 0).UTC(),
+			Message:   message,
+			DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: "testing",
+			},
+		})
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	// Only the document rejected with a 4xx status other than 429
+	// is indexed into the dead letter index.
+	var deadLetter []byte
+	select {
+	case deadLetter = <-deadLetters:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for dead letter document")
+	}
+	assert.Equal(t, "logs", gjson.GetBytes(deadLetter, "data_stream.type").String())
+	assert.Equal(t, "apm.dlq", gjson.GetBytes(deadLetter, "data_stream.dataset").String())
+	assert.Equal(t, "testing", gjson.GetBytes(deadLetter, "data_stream.namespace").String())
+	assert.Equal(t, "mapper_parsing_exception", gjson.GetBytes(deadLetter, "error.type").String())
+	assert.Equal(t, "failed to parse field [message]", gjson.GetBytes(deadLetter, "error.message").String())
+	assert.Equal(t, "logs-apm_server-testing", gjson.GetBytes(deadLetter, "dead_letter.index").String())
+	assert.Equal(t, int64(http.StatusBadRequest), gjson.GetBytes(deadLetter, "dead_letter.status").Int())
+	assert.NotZero(t, gjson.GetBytes(deadLetter, "@timestamp").String())
+	original := gjson.GetBytes(deadLetter, "event.original").String()
+	assert.Equal(t, "mapping", gjson.Get(original, "message").String())
+
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().DeadLetter.Indexed == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	select {
+	case deadLetter := <-deadLetters:
+		t.Fatalf("unexpected dead letter document: %s", deadLetter)
+	default:
+	}
+
+	stats := indexer.Stats()
+	assert.Equal(t, int64(3), stats.Added)
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, int64(1), stats.Indexed)
+	assert.Equal(t, int64(2), stats.Failed)
+	assert.Equal(t, modelindexer.DeadLetterStats{Indexed: 1}, stats.DeadLetter)
+}
+
+func TestModelIndexerDeadLetterFailed(t *testing.T) {
+	var requests int64
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		result := elasticsearch.BulkIndexerResponse{HasErrors: true}
+		for _, item := range items {
+			// Reject every document, including dead letter documents.
+			responseItem := esutil.BulkIndexerResponseItem{Index: item.Index, Status: http.StatusBadRequest}
+			responseItem.Error.Type = "mapper_parsing_exception"
+			responseItem.Error.Reason = "failed to parse"
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: responseItem,
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: 10 * time.Millisecond,
+		DeadLetter:    modelindexer.DeadLetterConfig{Index: "logs-apm.dlq-testing"},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	err = indexer.ProcessBatch(context.Background(), &model.Batch{{Timestamp: time.Now()}})
+	require.NoError(t, err)
+
+	// Dead letter documents which are rejected are not dead lettered again.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().DeadLetter.Failed == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(2), atomic.LoadInt64(&requests))
+
+	stats := indexer.Stats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, modelindexer.DeadLetterStats{Failed: 1}, stats.DeadLetter)
+}
+
+func TestModelIndexerDocumentRetryClosed(t *testing.T) {
 	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
-		select {
-		case <-r.Context().Done():
-		case requests <- struct{}{}:
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		result.HasErrors = true
+		for i := range result.Items {
+			for action, item := range result.Items[i] {
+				item.Status = http.StatusTooManyRequests
+				result.Items[i][action] = item
+			}
 		}
+		json.NewEncoder(w).Encode(result)
 	})
 	indexer, err := modelindexer.New(client, modelindexer.Config{
-		// Default flush bytes is 5MB
-		FlushInterval: time.Millisecond,
+		FlushInterval: time.Minute,
+		DocumentRetry: modelindexer.DocumentRetryConfig{
+			MaxAttempts:    3,
+			InitialBackoff: time.Hour,
+		},
 	})
 	require.NoError(t, err)
-	defer indexer.Close(context.Background())
-
-	select {
-	case <-requests:
-		t.Fatal("unexpected request, no events buffered")
-	case <-time.After(50 * time.Millisecond):
-	}
 
 	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
 		Type:      "logs",
 		Dataset:   "apm_server",
 		Namespace: "testing",
 	}}}
-	err = indexer.ProcessBatch(context.Background(), &batch)
-	require.NoError(t, err)
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
 
-	select {
-	case <-requests:
-	case <-time.After(10 * time.Second):
-		t.Fatal("timed out waiting for request, flush interval elapsed")
-	}
+	// Closing the indexer flushes the event, which fails and is awaiting
+	// retry; it is then counted as failed rather than retried again.
+	require.NoError(t, indexer.Close(context.Background()))
+	stats := indexer.Stats()
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, int64(1), stats.Retried)
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(1), stats.TooManyRequests)
 }
 
-func TestModelIndexerFlushBytes(t *testing.T) {
-	requests := make(chan struct{}, 1)
+func TestModelIndexerDocumentRetryDataStreamFlush(t *testing.T) {
+	var attempts int64
 	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
-		select {
-		case requests <- struct{}{}:
-		default:
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		if atomic.AddInt64(&attempts, 1) == 1 {
+			result.HasErrors = true
+			for i := range result.Items {
+				for action, item := range result.Items[i] {
+					item.Status = http.StatusTooManyRequests
+					result.Items[i][action] = item
+				}
+			}
 		}
+		json.NewEncoder(w).Encode(result)
 	})
 	indexer, err := modelindexer.New(client, modelindexer.Config{
-		FlushBytes: 1024,
-		// Default flush interval is 30 seconds
+		FlushInterval: time.Hour,
+		DataStreamFlush: map[string]modelindexer.FlushConfig{
+			"logs": {FlushInterval: time.Millisecond},
+		},
+		DocumentRetry: modelindexer.DocumentRetryConfig{
+			MaxAttempts:    2,
+			InitialBackoff: time.Millisecond,
+		},
 	})
 	require.NoError(t, err)
 	defer indexer.Close(context.Background())
@@ -314,24 +1269,58 @@ func TestModelIndexerFlushBytes(t *testing.T) {
 		Dataset:   "apm_server",
 		Namespace: "testing",
 	}}}
-	err = indexer.ProcessBatch(context.Background(), &batch)
-	require.NoError(t, err)
-
-	select {
-	case <-requests:
-		t.Fatal("unexpected request, flush bytes not exceeded")
-	case <-time.After(50 * time.Millisecond):
-	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+
+	// The retried event is queued with other logs events, and flushed
+	// at the logs flush interval rather than the default.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Indexed == 1
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, int64(1), indexer.Stats().Retried)
+}
 
-	for i := 0; i < 100; i++ {
-		err = indexer.ProcessBatch(context.Background(), &batch)
+func TestModelIndexerDocumentRetryClosing(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		result.HasErrors = true
+		for i := range result.Items {
+			for action, item := range result.Items[i] {
+				item.Status = http.StatusTooManyRequests
+				result.Items[i][action] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	for n := 0; n < 20; n++ {
+		indexer, err := modelindexer.New(client, modelindexer.Config{
+			FlushInterval: time.Millisecond,
+			DocumentRetry: modelindexer.DocumentRetryConfig{
+				MaxAttempts:    1000,
+				InitialBackoff: time.Microsecond,
+				MaxBackoff:     time.Microsecond,
+			},
+		})
 		require.NoError(t, err)
-	}
 
-	select {
-	case <-requests:
-	case <-time.After(10 * time.Second):
-		t.Fatal("timed out waiting for request, flush bytes exceeded")
+		batch := make(model.Batch, 10)
+		for i := range batch {
+			batch[i] = model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: "testing",
+			}}
+		}
+		require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+		assert.Eventually(t, func() bool {
+			return indexer.Stats().Retried > 0
+		}, 10*time.Second, time.Millisecond)
+
+		// Events being retried while the indexer closes are
+		// counted as failed, and never left in the queues.
+		require.NoError(t, indexer.Close(context.Background()))
+		stats := indexer.Stats()
+		assert.Equal(t, int64(0), stats.Active)
+		assert.Equal(t, int64(10), stats.Failed)
 	}
 }
 
@@ -367,6 +1356,56 @@ func TestModelIndexerServerError(t *testing.T) {
 	}, stats)
 }
 
+func TestModelIndexerStatsDelta(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	snapshot1 := indexer.StatsDelta(modelindexer.StatsSnapshot{})
+	assert.True(t, snapshot1.Reset)
+	assert.Zero(t, snapshot1.Added)
+
+	batch := model.Batch{
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	err = indexer.Close(context.Background())
+	require.NoError(t, err)
+
+	snapshot2 := indexer.StatsDelta(snapshot1)
+	assert.False(t, snapshot2.Reset)
+	assert.Greater(t, snapshot2.Sequence, snapshot1.Sequence)
+	assert.Equal(t, int64(2), snapshot2.Added)
+	assert.Equal(t, int64(2), snapshot2.Indexed)
+	assert.Equal(t, int64(1), snapshot2.BulkRequests)
+
+	// Counters report the change since the previous snapshot,
+	// while gauges report their current values.
+	snapshot3 := indexer.StatsDelta(snapshot2)
+	assert.False(t, snapshot3.Reset)
+	assert.Greater(t, snapshot3.Sequence, snapshot2.Sequence)
+	assert.Zero(t, snapshot3.Added)
+	assert.Zero(t, snapshot3.Indexed)
+	assert.Zero(t, snapshot3.BulkRequests)
+	assert.Equal(t, int64(10), snapshot3.AvailableBulkRequests)
+
+	// A snapshot from a different indexer resets the counters,
+	// such as when an indexer is replaced.
+	indexer2, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute})
+	require.NoError(t, err)
+	defer indexer2.Close(context.Background())
+	snapshot4 := indexer2.StatsDelta(snapshot3)
+	assert.True(t, snapshot4.Reset)
+	assert.Greater(t, snapshot4.Sequence, snapshot3.Sequence)
+	assert.Zero(t, snapshot4.Added)
+}
+
 func TestModelIndexerServerErrorTooManyRequests(t *testing.T) {
 	var bytesTotal int64
 	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
@@ -391,6 +1430,8 @@ func TestModelIndexerServerErrorTooManyRequests(t *testing.T) {
 	err = indexer.Close(context.Background())
 	require.EqualError(t, err, "flush failed: [429 Too Many Requests] ")
 	stats := indexer.Stats()
+	assert.Equal(t, map[int]int64{http.StatusTooManyRequests: 1}, stats.FailedByStatus)
+	stats.FailedByStatus = nil
 	assert.Equal(t, modelindexer.Stats{
 		Added:                 1,
 		Active:                0,
@@ -603,6 +1644,102 @@ func (f marshalJSONFunc) MarshalJSON() ([]byte, error) {
 	return f()
 }
 
+func TestModelIndexerProcessBatchQueueFull(t *testing.T) {
+	srvctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-srvctx.Done():
+		case <-r.Context().Done():
+		}
+	})
+	eventBufferSize := 10
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		// Set FlushBytes to 1 so a single event causes a flush.
+		FlushBytes:      1,
+		EventBufferSize: eventBufferSize,
+	})
+	require.NoError(t, err)
+
+	// Fill up all the bulk requests and the buffered channel.
+	for n := indexer.Stats().AvailableBulkRequests + int64(eventBufferSize); n >= 0; n-- {
+		batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type:      "logs",
+			Dataset:   "apm_server",
+			Namespace: "testing",
+		}}}
+		err := indexer.ProcessBatch(context.Background(), &batch)
+		require.NoError(t, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	err = indexer.ProcessBatch(ctx, &batch)
+	assert.ErrorIs(t, err, modelindexer.ErrQueueFull)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The bulk requests never complete, so close the indexer
+	// with a cancelled context to avoid waiting for them.
+	closeContext, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, indexer.Close(closeContext), context.Canceled)
+}
+
+func TestModelIndexerProcessBatchEventError(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval:    time.Minute,
+		MaxDocumentBytes: 1024,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(labelValue string) model.APMEvent {
+		return model.APMEvent{
+			Timestamp: time.Now(),
+			DataStream: model.DataStream{
+				Type:      "logs",
+				Dataset:   "apm_server",
+				Namespace: "testing",
+			},
+			Labels: model.Labels{"value": {Value: labelValue}},
+		}
+	}
+
+	batch := model.Batch{newEvent("small"), newEvent(strings.Repeat("x", 1024))}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	var eventErr *modelindexer.EventError
+	require.ErrorAs(t, err, &eventErr)
+	assert.Equal(t, 1, eventErr.Index)
+	assert.ErrorIs(t, err, modelindexer.ErrDocumentTooLarge)
+
+	unencodable := newEvent("small")
+	unencodable.Transaction = &model.Transaction{
+		Custom: mapstr.M{
+			"custom": marshalJSONFunc(func() ([]byte, error) {
+				return nil, errors.New("boom")
+			}),
+		},
+	}
+	batch = model.Batch{unencodable}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.ErrorAs(t, err, &eventErr)
+	assert.Equal(t, 0, eventErr.Index)
+	assert.ErrorIs(t, err, modelindexer.ErrEncoding)
+	assert.EqualError(t, err, "event 0: failed to encode document: json: error calling MarshalJSON for type *modelindexer_test.marshalJSONFunc: boom")
+
+	// Only the first event should have been added.
+	assert.Equal(t, int64(1), indexer.Stats().Added)
+}
+
 func TestModelIndexerFlushGoroutineStopped(t *testing.T) {
 	bulkHandler := func(w http.ResponseWriter, r *http.Request) {}
 	config := modelindexertest.NewMockElasticsearchClientConfig(t, bulkHandler)
@@ -692,6 +1829,70 @@ func TestModelIndexerCloseBusyIndexer(t *testing.T) {
 		IndexersActive:        0}, indexer.Stats())
 }
 
+func TestModelIndexerOrderByTrace(t *testing.T) {
+	// Set the gomaxprocs to 16, which should result in 4 partitions.
+	setGOMAXPROCS(t, 16)
+
+	var mu sync.Mutex
+	received := make(map[string][]int)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		docs, result := modelindexertest.DecodeBulkRequest(r)
+		mu.Lock()
+		for _, doc := range docs {
+			var event struct {
+				Message string `json:"message"`
+				Trace   struct {
+					ID string `json:"id"`
+				} `json:"trace"`
+			}
+			require.NoError(t, json.Unmarshal(doc, &event))
+			var seq int
+			fmt.Sscanf(event.Message, "%d", &seq)
+			received[event.Trace.ID] = append(received[event.Trace.ID], seq)
+		}
+		mu.Unlock()
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		FlushBytes:    1,
+		OrderByTrace:  true,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+	assert.Equal(t, int64(4), indexer.Stats().IndexersActive)
+
+	const traces = 8
+	const eventsPerTrace = 50
+	for i := 0; i < eventsPerTrace; i++ {
+		batch := make(model.Batch, traces)
+		for j := range batch {
+			batch[j] = model.APMEvent{
+				Timestamp: time.Now(),
+				Message:   fmt.Sprint(i),
+				Trace:     model.Trace{ID: fmt.Sprintf("trace-%d", j)},
+				DataStream: model.DataStream{
+					Type:      "logs",
+					Dataset:   "apm_server",
+					Namespace: "testing",
+				},
+			}
+		}
+		require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	}
+	require.NoError(t, indexer.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, traces)
+	for traceID, seqs := range received {
+		require.Len(t, seqs, eventsPerTrace, traceID)
+		for i, seq := range seqs {
+			assert.Equal(t, i, seq, traceID)
+		}
+	}
+}
+
 func TestModelIndexerScaling(t *testing.T) {
 	newIndexer := func(t *testing.T, cfg modelindexer.Config) *modelindexer.Indexer {
 		t.Helper()
@@ -793,6 +1994,70 @@ func TestModelIndexerScaling(t *testing.T) {
 			IndexersActive:        1,
 		}, stats)
 	})
+	t.Run("RuntimeConfig", func(t *testing.T) {
+		// Override the default GOMAXPROCS, ensuring the active indexers can scale up.
+		setGOMAXPROCS(t, 12)
+		indexer := newIndexer(t, modelindexer.Config{
+			FlushInterval: time.Millisecond,
+			FlushBytes:    1,
+			Scaling:       modelindexer.ScalingConfig{Disabled: true},
+		})
+		sendEvents(t, indexer, 20)
+		waitForBulkRequests(t, indexer, 20)
+		assert.Equal(t, int64(1), indexer.Stats().IndexersActive)
+		assert.Zero(t, indexer.Stats().IndexersCreated)
+		assert.Equal(t, uint(60), indexer.ScalingConfig().ScaleUp.Threshold) // default
+
+		var mu sync.Mutex
+		var scaleEvents []modelindexer.ScaleEvent
+		err := indexer.SetScalingConfig(modelindexer.ScalingConfig{
+			ScaleUp: modelindexer.ScaleActionConfig{
+				Threshold: 1, CoolDown: 1,
+			},
+			ScaleDown: modelindexer.ScaleActionConfig{
+				Threshold: 2, CoolDown: time.Millisecond,
+			},
+			IdleInterval: 50 * time.Millisecond,
+			OnScale: func(event modelindexer.ScaleEvent) {
+				mu.Lock()
+				defer mu.Unlock()
+				scaleEvents = append(scaleEvents, event)
+			},
+		})
+		require.NoError(t, err)
+
+		sendEvents(t, indexer, 20)
+		waitForScaleUp(t, indexer, 3)
+		waitForScaleDown(t, indexer, 1)
+
+		// OnScale is called after the scaling action is recorded.
+		var actions []string
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			mu.Lock()
+			actions = actions[:0]
+			for _, event := range scaleEvents {
+				assert.False(t, event.Time.IsZero())
+				actions = append(actions, fmt.Sprintf("%s/%s", event.Action, event.Reason))
+			}
+			mu.Unlock()
+			if len(actions) >= 4 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		assert.ElementsMatch(t, []string{
+			"up/full_flush", "up/full_flush",
+			"down/timed_flush", "down/timed_flush",
+		}, actions)
+	})
+	t.Run("RuntimeConfigOrderByTrace", func(t *testing.T) {
+		indexer := newIndexer(t, modelindexer.Config{OrderByTrace: true})
+		assert.True(t, indexer.ScalingConfig().Disabled)
+		err := indexer.SetScalingConfig(modelindexer.ScalingConfig{})
+		assert.EqualError(t, err, "scaling cannot be enabled when ordering by trace")
+		assert.NoError(t, indexer.SetScalingConfig(modelindexer.ScalingConfig{Disabled: true}))
+	})
 	t.Run("DownscaleActiveLimit", func(t *testing.T) {
 		// Override the default GOMAXPROCS, ensuring the active indexers can scale up.
 		setGOMAXPROCS(t, 12)
@@ -1165,3 +2430,161 @@ func benchmarkModelIndexer(b *testing.B, cfg modelindexer.Config) {
 	}
 	assert.Equal(b, int64(b.N), indexed)
 }
+
+func TestModelIndexerRoute(t *testing.T) {
+	requests := make(chan []modelindexertest.BulkRequestItem, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		requests <- items
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		Route: func(event *model.APMEvent) (string, string) {
+			switch event.Service.Name {
+			case "tenant-a":
+				return "logs-apm.tenant_a-default", "tenant-a-pipeline"
+			case "tenant-b":
+				return "", "tenant-b-pipeline"
+			}
+			return "", ""
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(serviceName string) model.APMEvent {
+		return model.APMEvent{
+			Timestamp:  time.Now(),
+			DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+			Service:    model.Service{Name: serviceName},
+		}
+	}
+	batch := model.Batch{newEvent("tenant-a"), newEvent("tenant-b"), newEvent("other")}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	items := <-requests
+	require.Len(t, items, 3)
+	assert.Equal(t, "logs-apm.tenant_a-default", items[0].Index)
+	assert.Equal(t, "tenant-a-pipeline", items[0].Pipeline)
+	assert.Equal(t, "logs-apm_server-testing", items[1].Index)
+	assert.Equal(t, "tenant-b-pipeline", items[1].Pipeline)
+	assert.Equal(t, "logs-apm_server-testing", items[2].Index)
+	assert.Empty(t, items[2].Pipeline)
+}
+
+func TestModelIndexerPipeline(t *testing.T) {
+	requests := make(chan []modelindexertest.BulkRequestItem, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		requests <- items
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		Pipeline:      "global",
+		DataStreamPipelines: map[string]string{
+			"logs":                  "logs-pipeline",
+			"logs-apm.app-redacted": "redaction",
+			"metrics-apm.app-raw":   "",
+		},
+		Route: func(event *model.APMEvent) (string, string) {
+			if event.Service.Name == "routed" {
+				return "", "routed-pipeline"
+			}
+			return "", ""
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	newEvent := func(dataStreamType, dataset, namespace string) model.APMEvent {
+		return model.APMEvent{
+			Timestamp:  time.Now(),
+			DataStream: model.DataStream{Type: dataStreamType, Dataset: dataset, Namespace: namespace},
+		}
+	}
+	routed := newEvent("logs", "apm.app", "redacted")
+	routed.Service.Name = "routed"
+	batch := model.Batch{
+		newEvent("traces", "apm", "default"),
+		newEvent("logs", "apm.app", "default"),
+		newEvent("logs", "apm.app", "redacted"),
+		newEvent("metrics", "apm.app", "raw"),
+		routed,
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	items := <-requests
+	require.Len(t, items, 5)
+	pipelines := make([]string, len(items))
+	for i, item := range items {
+		pipelines[i] = item.Pipeline
+	}
+	assert.Equal(t, []string{"global", "logs-pipeline", "redaction", "", "routed-pipeline"}, pipelines)
+}
+
+func TestModelIndexerPipelineInvalid(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {})
+	_, err := modelindexer.New(client, modelindexer.Config{
+		DataStreamPipelines: map[string]string{"logs-apm": "pipeline"},
+	})
+	assert.EqualError(t, err, `expected data stream type or name for data stream pipeline, got "logs-apm"`)
+}
+
+func TestModelIndexerFilterPath(t *testing.T) {
+	for name, test := range map[string]struct {
+		config     modelindexer.Config
+		filterPath string
+	}{
+		"default": {
+			filterPath: "items.*.status,items.*.error",
+		},
+		"rollover": {
+			config:     modelindexer.Config{Rollover: modelindexer.RolloverConfig{Enabled: true}},
+			filterPath: "items.*._index,items.*.status,items.*.error",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			filterPaths := make(chan string, 1)
+			client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+				filterPaths <- r.URL.Query().Get("filter_path")
+				// Respond as Elasticsearch would with the filter applied.
+				fmt.Fprint(w, `{"items":[{"create":{"status":201}},{"create":{"status":400,"error":{"type":"x","reason":"y"}}}]}`)
+			})
+			config := test.config
+			config.FlushInterval = time.Minute
+			indexer, err := modelindexer.New(client, config)
+			require.NoError(t, err)
+			defer indexer.Close(context.Background())
+
+			event := model.APMEvent{
+				Timestamp:  time.Now(),
+				DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+			}
+			batch := model.Batch{event, event}
+			require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+			require.NoError(t, indexer.Close(context.Background()))
+
+			assert.Equal(t, test.filterPath, <-filterPaths)
+			stats := indexer.Stats()
+			assert.Equal(t, int64(1), stats.Indexed)
+			assert.Equal(t, int64(1), stats.Failed)
+			assert.Equal(t, map[int]int64{http.StatusBadRequest: 1}, stats.FailedByStatus)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"encoding/json"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "apm_server_modelindexer"
+
+// Metrics publishes the stats of an Indexer as expvar and Prometheus
+// metrics, implementing both expvar.Var and prometheus.Collector.
+//
+// Stats are read from the Indexer whenever the metrics are reported or
+// collected, so the published values are always current. The Indexer may
+// be replaced with SetIndexer, e.g. when the output is reloaded, without
+// registering the metrics again. The zero value reports no metrics until
+// SetIndexer is called.
+type Metrics struct {
+	indexer atomic.Value // *Indexer
+}
+
+// SetIndexer sets the Indexer whose stats are published by m.
+func (m *Metrics) SetIndexer(indexer *Indexer) {
+	m.indexer.Store(indexer)
+}
+
+func (m *Metrics) stats() (Stats, bool) {
+	indexer, _ := m.indexer.Load().(*Indexer)
+	if indexer == nil {
+		return Stats{}, false
+	}
+	return indexer.Stats(), true
+}
+
+// String returns the Indexer's stats encoded as a JSON object.
+func (m *Metrics) String() string {
+	stats, ok := m.stats()
+	if !ok {
+		return "{}"
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return "{}"
+	}
+	return string(data)
+}
+
+// Describe sends the descriptors of all metrics published by m to ch.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range indexerMetrics {
+		ch <- metric.desc
+	}
+	ch <- failedByStatusDesc
+}
+
+// Collect sends the current value of each metric published by m to ch.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	stats, ok := m.stats()
+	if !ok {
+		return
+	}
+	for _, metric := range indexerMetrics {
+		ch <- prometheus.MustNewConstMetric(metric.desc, metric.valueType, metric.value(stats))
+	}
+	for status, n := range stats.FailedByStatus {
+		ch <- prometheus.MustNewConstMetric(
+			failedByStatusDesc, prometheus.CounterValue, float64(n), strconv.Itoa(status),
+		)
+	}
+}
+
+type indexerMetric struct {
+	desc      *prometheus.Desc
+	valueType prometheus.ValueType
+	value     func(Stats) float64
+}
+
+func newIndexerMetric(name, help string, valueType prometheus.ValueType, value func(Stats) float64) indexerMetric {
+	return indexerMetric{
+		desc:      prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", name), help, nil, nil),
+		valueType: valueType,
+		value:     value,
+	}
+}
+
+var failedByStatusDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(metricsNamespace, "", "events_failed_by_status_total"),
+	"Number of indexing operations that failed, by HTTP status code.",
+	[]string{"status"}, nil,
+)
+
+var indexerMetrics = []indexerMetric{
+	newIndexerMetric(
+		"events_added_total", "Number of events added to the indexer.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.Added) },
+	),
+	newIndexerMetric(
+		"events_active", "Number of events buffered or in flight in bulk requests.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.Active) },
+	),
+	newIndexerMetric(
+		"events_active_oldest_age_seconds", "Age of the oldest event buffered or in flight in a bulk request.",
+		prometheus.GaugeValue, func(s Stats) float64 { return s.ActiveOldestAge.Seconds() },
+	),
+	newIndexerMetric(
+		"events_queued", "Number of events waiting to be added to a bulk request.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.Queued) },
+	),
+	newIndexerMetric(
+		"events_queued_priority", "Number of prioritised events waiting to be added to a bulk request.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.QueuedPriority) },
+	),
+	newIndexerMetric(
+		"events_held", "Number of events held in memory while Elasticsearch is unavailable.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.Held) },
+	),
+	newIndexerMetric(
+		"events_indexed_total", "Number of indexing operations that completed successfully.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.Indexed) },
+	),
+	newIndexerMetric(
+		"events_failed_total", "Number of indexing operations that failed.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.Failed) },
+	),
+	newIndexerMetric(
+		"events_retried_total", "Number of indexing operations re-enqueued after failing with a retryable status.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.Retried) },
+	),
+	newIndexerMetric(
+		"events_too_many_requests_total", "Number of indexing operations that failed with 429 Too Many Requests.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.TooManyRequests) },
+	),
+	newIndexerMetric(
+		"bulk_requests_total", "Number of bulk requests completed.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.BulkRequests) },
+	),
+	newIndexerMetric(
+		"bulk_requests_split_total", "Number of bulk requests flushed early to avoid exceeding the maximum request size.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.BulkRequestsSplit) },
+	),
+	newIndexerMetric(
+		"bulk_requests_too_large_total", "Number of bulk requests rejected with 413 Request Entity Too Large.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.BulkRequestsTooLarge) },
+	),
+	newIndexerMetric(
+		"bulk_requests_timed_out_total", "Number of bulk requests that did not complete within the timeout.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.BulkRequestsTimedOut) },
+	),
+	newIndexerMetric(
+		"learned_max_request_bytes", "Maximum bulk request size learned from requests rejected as too large, or zero.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.LearnedMaxRequestBytes) },
+	),
+	newIndexerMetric(
+		"bulk_requests_available", "Number of bulk indexers available for making bulk requests.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.AvailableBulkRequests) },
+	),
+	newIndexerMetric(
+		"bytes_total", "Number of bytes written to bulk request bodies.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.BytesTotal) },
+	),
+	newIndexerMetric(
+		"indexers_active", "Number of active bulk indexers.",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.IndexersActive) },
+	),
+	newIndexerMetric(
+		"indexers_created_total", "Number of active bulk indexers created.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.IndexersCreated) },
+	),
+	newIndexerMetric(
+		"indexers_destroyed_total", "Number of active bulk indexers destroyed.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.IndexersDestroyed) },
+	),
+	newIndexerMetric(
+		"circuit_breaker_open", "Whether the circuit breaker is open and bulk requests are paused (1) or not (0).",
+		prometheus.GaugeValue, func(s Stats) float64 { return float64(s.CircuitBreaker.Open) },
+	),
+	newIndexerMetric(
+		"circuit_breaker_opened_total", "Number of times the circuit breaker opened after consecutive bulk request failures.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.CircuitBreaker.Opened) },
+	),
+	newIndexerMetric(
+		"dead_letter_indexed_total", "Number of rejected documents indexed into the dead letter index.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.DeadLetter.Indexed) },
+	),
+	newIndexerMetric(
+		"dead_letter_failed_total", "Number of rejected documents that failed to be indexed into the dead letter index.",
+		prometheus.CounterValue, func(s Stats) float64 { return float64(s.DeadLetter.Failed) },
+	),
+}
@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestMetrics(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		result.HasErrors = true
+		// Reject all but the last item, with a mix of statuses.
+		for i := 0; i < len(result.Items)-1; i++ {
+			status := http.StatusBadRequest
+			if i == 0 {
+				status = http.StatusNotFound
+			}
+			for action, item := range result.Items[i] {
+				item.Status = status
+				result.Items[i][action] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	var metrics modelindexer.Metrics
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(&metrics)
+
+	// No metrics are published until the indexer is set.
+	assert.Equal(t, "{}", metrics.String())
+	count, err := testutil.GatherAndCount(registry)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	metrics.SetIndexer(indexer)
+	batch := model.Batch{
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	// Metrics are read from the indexer's stats when collected.
+	err = testutil.GatherAndCompare(registry, strings.NewReader(`
+# HELP apm_server_modelindexer_events_added_total Number of events added to the indexer.
+# TYPE apm_server_modelindexer_events_added_total counter
+apm_server_modelindexer_events_added_total 4
+# HELP apm_server_modelindexer_events_failed_by_status_total Number of indexing operations that failed, by HTTP status code.
+# TYPE apm_server_modelindexer_events_failed_by_status_total counter
+apm_server_modelindexer_events_failed_by_status_total{status="400"} 2
+apm_server_modelindexer_events_failed_by_status_total{status="404"} 1
+# HELP apm_server_modelindexer_events_failed_total Number of indexing operations that failed.
+# TYPE apm_server_modelindexer_events_failed_total counter
+apm_server_modelindexer_events_failed_total 3
+# HELP apm_server_modelindexer_events_indexed_total Number of indexing operations that completed successfully.
+# TYPE apm_server_modelindexer_events_indexed_total counter
+apm_server_modelindexer_events_indexed_total 1
+`),
+		"apm_server_modelindexer_events_added_total",
+		"apm_server_modelindexer_events_failed_by_status_total",
+		"apm_server_modelindexer_events_failed_total",
+		"apm_server_modelindexer_events_indexed_total",
+	)
+	assert.NoError(t, err)
+
+	var decoded struct {
+		Added          int64
+		Failed         int64
+		FailedByStatus map[string]int64
+		Indexed        int64
+	}
+	require.NoError(t, json.Unmarshal([]byte(metrics.String()), &decoded))
+	assert.Equal(t, int64(4), decoded.Added)
+	assert.Equal(t, int64(3), decoded.Failed)
+	assert.Equal(t, map[string]int64{"400": 2, "404": 1}, decoded.FailedByStatus)
+	assert.Equal(t, int64(1), decoded.Indexed)
+}
+
+func TestStatsDeltaFailedByStatus(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		result.HasErrors = true
+		for action, item := range result.Items[0] {
+			item.Status = http.StatusBadRequest
+			result.Items[0][action] = item
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Minute})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	snapshot1 := indexer.StatsDelta(modelindexer.StatsSnapshot{})
+	assert.Equal(t, map[int]int64{http.StatusBadRequest: 1}, snapshot1.FailedByStatus)
+
+	// Per-status counters report the change since the previous snapshot.
+	snapshot2 := indexer.StatsDelta(snapshot1)
+	assert.Empty(t, snapshot2.FailedByStatus)
+	assert.Equal(t, map[int]int64{http.StatusBadRequest: 1}, indexer.Stats().FailedByStatus)
+}
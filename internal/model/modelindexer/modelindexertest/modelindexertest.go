@@ -29,6 +29,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/stretchr/testify/require"
 
 	"github.com/elastic/go-elasticsearch/v8/esutil"
@@ -57,8 +58,39 @@ func AppendEncodedBatch(t testing.TB, out [][]byte, batch model.Batch) [][]byte
 	return out
 }
 
+// BulkRequestItem holds a single item decoded from a /_bulk request.
+type BulkRequestItem struct {
+	// Action holds the bulk action type, e.g. "create".
+	Action string
+
+	// Index holds the name of the index or data stream
+	// into which the document is to be indexed.
+	Index string
+
+	// Pipeline holds the name of the ingest pipeline specified
+	// for the document, if any.
+	Pipeline string
+
+	// Document holds the JSON-encoded document.
+	Document []byte
+}
+
 // DecodeBulkRequest decodes a /_bulk request's body, returning the decoded documents and a response body.
 func DecodeBulkRequest(r *http.Request) ([][]byte, elasticsearch.BulkIndexerResponse) {
+	items := DecodeBulkRequestItems(r)
+	indexed := make([][]byte, len(items))
+	var result elasticsearch.BulkIndexerResponse
+	for i, item := range items {
+		indexed[i] = item.Document
+		result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+			item.Action: {Index: item.Index, Status: http.StatusCreated},
+		})
+	}
+	return indexed, result
+}
+
+// DecodeBulkRequestItems decodes a /_bulk request's body, returning the decoded items.
+func DecodeBulkRequestItems(r *http.Request) []BulkRequestItem {
 	body := r.Body
 	switch r.Header.Get("Content-Encoding") {
 	case "gzip":
@@ -68,18 +100,30 @@ func DecodeBulkRequest(r *http.Request) ([][]byte, elasticsearch.BulkIndexerResp
 		}
 		defer r.Close()
 		body = r
+	case "zstd":
+		r, err := zstd.NewReader(body)
+		if err != nil {
+			panic(err)
+		}
+		defer r.Close()
+		body = r.IOReadCloser()
 	}
 
 	scanner := bufio.NewScanner(body)
-	var indexed [][]byte
-	var result elasticsearch.BulkIndexerResponse
+	var items []BulkRequestItem
 	for scanner.Scan() {
-		action := make(map[string]interface{})
+		action := make(map[string]struct {
+			Index    string `json:"_index"`
+			Pipeline string `json:"pipeline"`
+		})
 		if err := json.NewDecoder(strings.NewReader(scanner.Text())).Decode(&action); err != nil {
 			panic(err)
 		}
-		var actionType string
-		for actionType = range action {
+		var item BulkRequestItem
+		for actionType, meta := range action {
+			item.Action = actionType
+			item.Index = meta.Index
+			item.Pipeline = meta.Pipeline
 		}
 		if !scanner.Scan() {
 			panic("expected source")
@@ -89,12 +133,10 @@ func DecodeBulkRequest(r *http.Request) ([][]byte, elasticsearch.BulkIndexerResp
 		if !json.Valid(doc) {
 			panic(fmt.Errorf("invalid JSON: %s", doc))
 		}
-		indexed = append(indexed, doc)
-
-		item := esutil.BulkIndexerResponseItem{Status: http.StatusCreated}
-		result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{actionType: item})
+		item.Document = doc
+		items = append(items, item)
 	}
-	return indexed, result
+	return items
 }
 
 // NewMockElasticsearchClient returns an elasticsearch.Client which sends /_bulk requests to bulkHandler.
@@ -0,0 +1,110 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"time"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// Output is a destination for the events indexed by Indexer.
+//
+// Indexer encodes events as bulk items, adds them to buffers obtained
+// from the Output, and delivers each buffer when it reaches the flush
+// thresholds. Buffering, flushing, and scaling of active indexers are
+// handled by Indexer, so alternative sinks need only implement encoding
+// of a batch of items and its delivery.
+type Output interface {
+	// NewBuffer returns a new, empty OutputBuffer. NewBuffer is called
+	// once for each of Config.MaxRequests concurrent requests, and the
+	// buffers are reused after being reset.
+	NewBuffer() OutputBuffer
+}
+
+// OutputBuffer accumulates items to be delivered to an Output in a
+// single request.
+//
+// An OutputBuffer is used by one goroutine at a time, with the exception
+// of FirstAdded, which may be called concurrently with other methods.
+type OutputBuffer interface {
+	// Add adds item to the buffer.
+	Add(item elasticsearch.BulkIndexerItem) error
+
+	// ItemLen returns the number of bytes item would add to the
+	// uncompressed request, without adding it to the buffer.
+	ItemLen(item elasticsearch.BulkIndexerItem) (int, error)
+
+	// Items returns the number of buffered items.
+	Items() int
+
+	// Len returns the number of buffered bytes, which is compared with
+	// the FlushBytes threshold. If the buffer is compressed, Len returns
+	// the compressed length.
+	Len() int
+
+	// UncompressedLen returns the number of buffered bytes before any
+	// compression, which is compared with MaxRequestBytes.
+	UncompressedLen() int
+
+	// FirstAdded returns the time at which the first buffered item was
+	// added, or the zero time if there are no buffered items.
+	FirstAdded() time.Time
+
+	// Deliver sends the buffered items to the output, notifying the
+	// OnSuccess and OnFailure callbacks of each item of the outcome.
+	//
+	// If Deliver returns an error, all buffered items are considered
+	// to have failed.
+	Deliver(ctx context.Context) (OutputResult, error)
+
+	// Reset empties the buffer, so it can be reused after Deliver.
+	Reset()
+}
+
+// OutputResult holds the outcome of delivering an OutputBuffer.
+type OutputResult struct {
+	// Indexed holds the number of items successfully delivered.
+	Indexed int64
+
+	// Failed holds the number of items that could not be delivered.
+	Failed int64
+
+	// BytesFlushed holds the number of bytes sent to the output.
+	BytesFlushed int64
+}
+
+// elasticsearchOutput is the default Output, which indexes events into
+// Elasticsearch with bulk requests.
+//
+// Buffers created by elasticsearchOutput are flushed by Indexer itself,
+// rather than through OutputBuffer.Deliver, so failed documents can be
+// retried, sent to the dead letter index, and trigger data stream rollover.
+type elasticsearchOutput struct {
+	client           elasticsearch.Client
+	compression      string
+	compressionLevel int
+	retainDocs       bool
+	filterPath       []string
+}
+
+// NewBuffer returns a new bulk request buffer.
+func (o elasticsearchOutput) NewBuffer() OutputBuffer {
+	return newBulkIndexer(o.client, o.compression, o.compressionLevel, o.retainDocs, o.filterPath)
+}
@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/tidwall/gjson"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+)
+
+func TestModelIndexerOutput(t *testing.T) {
+	output := &memoryOutput{}
+	indexer, err := modelindexer.New(nil, modelindexer.Config{
+		FlushInterval: time.Minute,
+		Output:        output,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{
+		model.APMEvent{Timestamp: time.Now(), Message: "first", DataStream: model.DataStream{
+			Type:      "logs",
+			Dataset:   "apm_server",
+			Namespace: "testing",
+		}},
+		model.APMEvent{Timestamp: time.Now(), Message: "second", DataStream: model.DataStream{
+			Type:      "logs",
+			Dataset:   "apm_server",
+			Namespace: "testing",
+		}},
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	// Closing the indexer flushes enqueued events.
+	err = indexer.Close(context.Background())
+	require.NoError(t, err)
+
+	docs := output.delivered()
+	require.Len(t, docs, 2)
+	for i, doc := range docs {
+		assert.Equal(t, "logs-apm_server-testing", doc.index)
+		assert.Equal(t, batch[i].Message, gjson.GetBytes(doc.body, "message").String())
+	}
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), stats.Added)
+	assert.Equal(t, int64(2), stats.Indexed)
+	assert.Equal(t, int64(1), stats.BulkRequests)
+	assert.Equal(t, int64(10), stats.AvailableBulkRequests)
+	assert.NotZero(t, stats.BytesTotal)
+}
+
+func TestModelIndexerOutputError(t *testing.T) {
+	output := &memoryOutput{err: errors.New("output unavailable")}
+	indexer, err := modelindexer.New(nil, modelindexer.Config{
+		FlushInterval: time.Minute,
+		Output:        output,
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	err = indexer.Close(context.Background())
+	require.EqualError(t, err, "output unavailable")
+	stats := indexer.Stats()
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, int64(0), stats.Indexed)
+}
+
+type memoryDocument struct {
+	index string
+	body  []byte
+}
+
+// memoryOutput is a modelindexer.Output which records delivered documents.
+type memoryOutput struct {
+	err  error
+	mu   sync.Mutex
+	docs []memoryDocument
+}
+
+func (o *memoryOutput) NewBuffer() modelindexer.OutputBuffer {
+	return &memoryBuffer{output: o}
+}
+
+func (o *memoryOutput) delivered() []memoryDocument {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]memoryDocument(nil), o.docs...)
+}
+
+type memoryBuffer struct {
+	output     *memoryOutput
+	docs       []memoryDocument
+	size       int
+	firstAdded time.Time
+}
+
+func (b *memoryBuffer) Add(item elasticsearch.BulkIndexerItem) error {
+	body, err := io.ReadAll(item.Body)
+	if err != nil {
+		return err
+	}
+	if len(b.docs) == 0 {
+		b.firstAdded = time.Now()
+	}
+	b.docs = append(b.docs, memoryDocument{index: item.Index, body: body})
+	b.size += len(body)
+	return nil
+}
+
+func (b *memoryBuffer) ItemLen(item elasticsearch.BulkIndexerItem) (int, error) {
+	n, err := item.Body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = item.Body.Seek(0, io.SeekStart)
+	return int(n), err
+}
+
+func (b *memoryBuffer) Items() int            { return len(b.docs) }
+func (b *memoryBuffer) Len() int              { return b.size }
+func (b *memoryBuffer) UncompressedLen() int  { return b.size }
+func (b *memoryBuffer) FirstAdded() time.Time { return b.firstAdded }
+
+func (b *memoryBuffer) Deliver(ctx context.Context) (modelindexer.OutputResult, error) {
+	if b.output.err != nil {
+		return modelindexer.OutputResult{}, b.output.err
+	}
+	b.output.mu.Lock()
+	defer b.output.mu.Unlock()
+	b.output.docs = append(b.output.docs, b.docs...)
+	return modelindexer.OutputResult{
+		Indexed:      int64(len(b.docs)),
+		BytesFlushed: int64(b.size),
+	}, nil
+}
+
+func (b *memoryBuffer) Reset() {
+	b.docs = nil
+	b.size = 0
+	b.firstAdded = time.Time{}
+}
@@ -0,0 +1,127 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+)
+
+// QueueState holds a point-in-time view of the events queued in the
+// Indexer, and buffered by its active indexers, for diagnosing where
+// a backlog of events is building up.
+type QueueState struct {
+	// Queues holds the number of events in each of the Indexer's queues,
+	// keyed by queue name: "default" for the shared queue, "priority" for
+	// the priority queue, "partition.<n>" for OrderByTrace partitions, and
+	// the data stream type for queues of data stream types configured in
+	// DataStreamFlush.
+	Queues map[string]QueueLength `json:"queues"`
+
+	// ActiveIndexers holds the events buffered by each active indexer,
+	// ordered by queue name.
+	ActiveIndexers []ActiveIndexerState `json:"active_indexers"`
+
+	// AvailableBulkRequests holds the number of bulk requests which are
+	// not buffering events or being flushed.
+	AvailableBulkRequests int64 `json:"available_bulk_requests"`
+}
+
+// QueueLength holds the number of events in a queue, and its capacity.
+type QueueLength struct {
+	Length   int `json:"length"`
+	Capacity int `json:"capacity"`
+}
+
+// ActiveIndexerState holds the events buffered by an active indexer.
+type ActiveIndexerState struct {
+	// Queue holds the name of the queue consumed by the active indexer.
+	Queue string `json:"queue"`
+
+	// Events holds the number of events in the active indexer's buffer.
+	Events int64 `json:"events"`
+
+	// Bytes holds the size of the active indexer's buffer in bytes,
+	// after any compression.
+	Bytes int64 `json:"bytes"`
+}
+
+// activeIndexerState holds the buffer sizes of an active indexer. It is
+// updated by the active indexer's goroutine, and read by QueueState.
+type activeIndexerState struct {
+	queue  string
+	events int64
+	bytes  int64
+}
+
+func (s *activeIndexerState) set(events, bytes int) {
+	atomic.StoreInt64(&s.events, int64(events))
+	atomic.StoreInt64(&s.bytes, int64(bytes))
+}
+
+func (i *Indexer) addActiveIndexerState(queue string) *activeIndexerState {
+	state := &activeIndexerState{queue: queue}
+	i.activeStatesMu.Lock()
+	defer i.activeStatesMu.Unlock()
+	i.activeStates[state] = struct{}{}
+	return state
+}
+
+func (i *Indexer) removeActiveIndexerState(state *activeIndexerState) {
+	i.activeStatesMu.Lock()
+	defer i.activeStatesMu.Unlock()
+	delete(i.activeStates, state)
+}
+
+// QueueState returns the current state of the Indexer's queues and
+// active indexers. QueueState is safe for concurrent use.
+func (i *Indexer) QueueState() QueueState {
+	queues := map[string]QueueLength{
+		"default": {Length: len(i.bulkItems), Capacity: cap(i.bulkItems)},
+	}
+	if i.priorityItems != nil {
+		queues["priority"] = QueueLength{Length: len(i.priorityItems), Capacity: cap(i.priorityItems)}
+	}
+	for p, items := range i.partitions {
+		queues[fmt.Sprintf("partition.%d", p)] = QueueLength{Length: len(items), Capacity: cap(items)}
+	}
+	for dataStreamType, items := range i.dataStreamItems {
+		queues[dataStreamType] = QueueLength{Length: len(items), Capacity: cap(items)}
+	}
+
+	i.activeStatesMu.Lock()
+	activeIndexers := make([]ActiveIndexerState, 0, len(i.activeStates))
+	for state := range i.activeStates {
+		activeIndexers = append(activeIndexers, ActiveIndexerState{
+			Queue:  state.queue,
+			Events: atomic.LoadInt64(&state.events),
+			Bytes:  atomic.LoadInt64(&state.bytes),
+		})
+	}
+	i.activeStatesMu.Unlock()
+	sort.SliceStable(activeIndexers, func(a, b int) bool {
+		return activeIndexers[a].Queue < activeIndexers[b].Queue
+	})
+
+	return QueueState{
+		Queues:                queues,
+		ActiveIndexers:        activeIndexers,
+		AvailableBulkRequests: atomic.LoadInt64(&i.availableBulkRequests),
+	}
+}
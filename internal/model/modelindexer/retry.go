@@ -0,0 +1,249 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// DocumentRetryConfig holds configuration for retrying documents which
+// Elasticsearch fails to index due to temporary unavailability, i.e. with
+// a 429 or 503 status in the bulk response.
+type DocumentRetryConfig struct {
+	// MaxAttempts holds the maximum number of times a document will be
+	// sent to Elasticsearch, including the first attempt. Documents which
+	// still fail with a retryable status after MaxAttempts are counted as
+	// failed.
+	//
+	// If MaxAttempts is less than or equal to 1, documents are not retried.
+	MaxAttempts int
+
+	// InitialBackoff holds the amount of time to wait before re-enqueuing
+	// a document for its first retry. The backoff is doubled for each
+	// subsequent retry, up to MaxBackoff.
+	//
+	// If InitialBackoff is zero, the default of 1 second will be used.
+	InitialBackoff time.Duration
+
+	// MaxBackoff holds the maximum amount of time to wait before
+	// re-enqueuing a document.
+	//
+	// If MaxBackoff is zero, the default of 1 minute will be used.
+	MaxBackoff time.Duration
+}
+
+// backoff returns the amount of time to wait before re-enqueuing a
+// document which has been sent the given number of times.
+func (cfg DocumentRetryConfig) backoff(attempts int) time.Duration {
+	backoff := cfg.InitialBackoff
+	for n := 1; n < attempts && backoff < cfg.MaxBackoff; n++ {
+		backoff *= 2
+	}
+	if backoff > cfg.MaxBackoff {
+		backoff = cfg.MaxBackoff
+	}
+	return backoff
+}
+
+// isRetryableStatus reports whether a bulk response item with the given
+// status may succeed if the document is sent again.
+func isRetryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// retryBody is the body of a bulk item being retried. It holds a copy of
+// the document retained by the bulk indexer, and the number of times the
+// document has been sent.
+type retryBody struct {
+	*bytes.Reader
+	attempts int
+}
+
+// retainedState holds the state of a retained item which is carried over
+// when the item is sent again: the number of times it has been sent, whether
+// it holds a dead letter document, and the time at which it was first held
+// while Elasticsearch was unavailable.
+type retainedState struct {
+	attempts   int
+	deadLetter bool
+	heldSince  time.Time
+}
+
+// retainedStateOf returns the state of an item added to a bulk request with
+// the given body.
+func retainedStateOf(body io.Reader) retainedState {
+	state := retainedState{attempts: 1}
+	switch body := body.(type) {
+	case *retryBody:
+		state.attempts = body.attempts
+	case *deadLetterBody:
+		state.deadLetter = true
+	case *heldBody:
+		state.attempts = body.attempts
+		state.deadLetter = body.deadLetter
+		state.heldSince = body.since
+	}
+	return state
+}
+
+// body returns a body for sending doc again, preserving the state.
+func (s retainedState) body(doc []byte) io.ReadSeeker {
+	r := bytes.NewReader(doc)
+	switch {
+	case !s.heldSince.IsZero():
+		return &heldBody{Reader: r, since: s.heldSince, attempts: s.attempts, deadLetter: s.deadLetter}
+	case s.deadLetter:
+		return &deadLetterBody{Reader: r}
+	case s.attempts > 1:
+		return &retryBody{Reader: r, attempts: s.attempts}
+	}
+	return r
+}
+
+// isDeadLetter reports whether the item at the given position in the bulk
+// request holds a dead letter document.
+func isDeadLetter(b *bulkIndexer, position int) bool {
+	item, _, ok := b.Document(position)
+	return ok && retainedStateOf(item.Body).deadLetter
+}
+
+// retryItem takes the item at the given position in the bulk request for
+// sending again, returning it along with the number of times it will then
+// have been sent. retryItem returns false if documents are not retained, or
+// the item has already been sent maxAttempts times.
+func retryItem(b *bulkIndexer, position, maxAttempts int) (elasticsearch.BulkIndexerItem, int, bool) {
+	item, _, ok := b.Document(position)
+	if !ok {
+		return elasticsearch.BulkIndexerItem{}, 0, false
+	}
+	attempts := retainedStateOf(item.Body).attempts
+	if attempts >= maxAttempts {
+		return elasticsearch.BulkIndexerItem{}, 0, false
+	}
+	item, doc, _ := b.Take(position)
+	item.Body = &retryBody{Reader: bytes.NewReader(doc), attempts: attempts + 1}
+	return item, attempts + 1, true
+}
+
+// retryItems re-enqueues items for retry after backing off, where attempts
+// holds the greatest number of times any of the items has been sent.
+//
+// If the Indexer is closed while waiting to re-enqueue the items, they are
+// counted as failed and their OnFailure callbacks are called with ErrClosed.
+func (i *Indexer) retryItems(items []elasticsearch.BulkIndexerItem, attempts int) {
+	atomic.AddInt64(&i.eventsRetried, int64(len(items)))
+	atomic.AddInt64(&i.eventsActive, int64(len(items)))
+	i.requeueAfter(items, i.config.DocumentRetry.backoff(attempts), nil)
+}
+
+// requeueAfter re-enqueues retained items after delay, in the background.
+// If the Indexer is closed before the items are re-enqueued, the items
+// which were not re-enqueued are counted as failed and their OnFailure
+// callbacks are called with ErrClosed.
+//
+// If release is non-nil, it is called once the delay ends or the Indexer
+// is closed, before the items are re-enqueued or failed.
+func (i *Indexer) requeueAfter(items []elasticsearch.BulkIndexerItem, delay time.Duration, release func()) {
+	i.errgroup.Go(func() error {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		remaining := items
+		select {
+		case <-i.closed:
+		case <-timer.C:
+			remaining = nil
+		}
+		if release != nil {
+			release()
+		}
+		if remaining == nil {
+			remaining = i.requeueItems(items, i.retainedItemChannel)
+		}
+		if len(remaining) > 0 {
+			i.failRetryItems(remaining)
+		}
+		return nil
+	})
+}
+
+// retainedItemChannel returns the bulk items channel for a retained item,
+// routing the item as an event of its data stream would be routed.
+func (i *Indexer) retainedItemChannel(item elasticsearch.BulkIndexerItem) chan<- elasticsearch.BulkIndexerItem {
+	return i.bulkItemsChannel(indexEvent(item.Index))
+}
+
+// requeueItems sends items to the bulk items channels returned by channel,
+// to be added to later bulk requests. Once the Indexer has started draining
+// its queues on close, no more items are sent, and requeueItems returns the
+// items which were not sent so the caller can account for them.
+func (i *Indexer) requeueItems(
+	items []elasticsearch.BulkIndexerItem,
+	channel func(elasticsearch.BulkIndexerItem) chan<- elasticsearch.BulkIndexerItem,
+) []elasticsearch.BulkIndexerItem {
+	for k, item := range items {
+		if !i.requeueItem(item, channel(item)) {
+			return items[k:]
+		}
+	}
+	return nil
+}
+
+// requeueItem sends item to bulkItems, reporting whether it was sent.
+func (i *Indexer) requeueItem(item elasticsearch.BulkIndexerItem, bulkItems chan<- elasticsearch.BulkIndexerItem) bool {
+	i.requeueMu.RLock()
+	defer i.requeueMu.RUnlock()
+	if i.requeueStopped {
+		return false
+	}
+	select {
+	case <-i.closed:
+		// The Indexer is closing; a blocked send could
+		// otherwise prevent the queues from being drained.
+		return false
+	case bulkItems <- item:
+		return true
+	}
+}
+
+// stopRequeue prevents items from being requeued, and waits for any
+// in-progress sends by requeueItems to complete.
+func (i *Indexer) stopRequeue() {
+	i.requeueMu.Lock()
+	defer i.requeueMu.Unlock()
+	i.requeueStopped = true
+}
+
+func (i *Indexer) failRetryItems(items []elasticsearch.BulkIndexerItem) {
+	atomic.AddInt64(&i.eventsActive, -int64(len(items)))
+	atomic.AddInt64(&i.eventsFailed, int64(len(items)))
+	for _, item := range items {
+		if item.OnFailure != nil {
+			item.OnFailure(
+				context.Background(), item,
+				elasticsearch.BulkIndexerResponseItem{}, ErrClosed,
+			)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// RolloverConfig holds configuration for rolling over data streams which
+// repeatedly fail to index documents due to mapping errors.
+//
+// Mapping errors may occur when a field has been dynamically mapped with
+// one type in the data stream's write index, and documents are later sent
+// with a different type for the same field. Rolling over the data stream
+// creates a new write index with fresh dynamic mappings.
+type RolloverConfig struct {
+	// Enabled controls whether data streams are rolled over after
+	// repeated mapping errors. Rolling over data streams requires
+	// the "manage" privilege for the data streams.
+	//
+	// Rollover is disabled by default.
+	Enabled bool
+
+	// Threshold holds the number of documents which must fail to be
+	// indexed into a data stream due to mapping errors, within Interval,
+	// before the data stream is rolled over.
+	//
+	// If Threshold is zero, the default of 100 will be used.
+	Threshold int
+
+	// Interval holds the period over which mapping errors are counted,
+	// and the minimum amount of time between rollovers of a data stream.
+	//
+	// If Interval is zero, the default of 1 hour will be used.
+	Interval time.Duration
+}
+
+// rolloverManager counts mapping errors per data stream, and rolls over
+// data streams whose mapping errors exceed the configured threshold.
+type rolloverManager struct {
+	client elasticsearch.Client
+	config RolloverConfig
+	logger *logp.Logger
+
+	rollovers int64
+	failed    int64
+
+	mu          sync.Mutex
+	dataStreams map[string]*dataStreamMappingErrors
+}
+
+type dataStreamMappingErrors struct {
+	windowStart  time.Time
+	count        int
+	lastRollover time.Time
+}
+
+func newRolloverManager(client elasticsearch.Client, cfg RolloverConfig, logger *logp.Logger) *rolloverManager {
+	return &rolloverManager{
+		client:      client,
+		config:      cfg,
+		logger:      logger,
+		dataStreams: make(map[string]*dataStreamMappingErrors),
+	}
+}
+
+// recordMappingErrors records the number of mapping errors for each data
+// stream in a bulk response, rolling over any data streams which have
+// exceeded the threshold.
+func (r *rolloverManager) recordMappingErrors(ctx context.Context, counts map[string]int, now time.Time) {
+	var rollover []string
+	r.mu.Lock()
+	for dataStream, n := range counts {
+		entry, ok := r.dataStreams[dataStream]
+		if !ok {
+			entry = &dataStreamMappingErrors{windowStart: now}
+			r.dataStreams[dataStream] = entry
+		}
+		if now.Sub(entry.windowStart) >= r.config.Interval {
+			entry.windowStart = now
+			entry.count = 0
+		}
+		entry.count += n
+		if entry.count < r.config.Threshold {
+			continue
+		}
+		if !entry.lastRollover.IsZero() && now.Sub(entry.lastRollover) < r.config.Interval {
+			continue
+		}
+		entry.lastRollover = now
+		entry.windowStart = now
+		entry.count = 0
+		rollover = append(rollover, dataStream)
+	}
+	r.mu.Unlock()
+
+	for _, dataStream := range rollover {
+		if err := r.rollover(ctx, dataStream); err != nil {
+			atomic.AddInt64(&r.failed, 1)
+			r.logger.Errorf("failed to roll over data stream %q: %v", dataStream, err)
+			continue
+		}
+		atomic.AddInt64(&r.rollovers, 1)
+		r.logger.Warnf(
+			"rolled over data stream %q after %d or more mapping errors",
+			dataStream, r.config.Threshold,
+		)
+	}
+}
+
+func (r *rolloverManager) rollover(ctx context.Context, dataStream string) error {
+	resp, err := esapi.IndicesRolloverRequest{Alias: dataStream}.Do(ctx, r.client)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		return errors.New(resp.String())
+	}
+	return nil
+}
+
+// isMappingError reports whether errorType is the type of a bulk item error
+// caused by the document conflicting with the index mappings.
+func isMappingError(errorType string) bool {
+	switch errorType {
+	case "mapper_parsing_exception", "document_parsing_exception":
+		return true
+	}
+	return false
+}
+
+// dataStreamName returns the name of the data stream for the given index,
+// which may be either a data stream name or the name of a data stream's
+// backing index: ".ds-<data-stream>-<yyyy.MM.dd>-<generation>".
+func dataStreamName(index string) string {
+	name := strings.TrimPrefix(index, ".ds-")
+	if name == index {
+		return index
+	}
+	for i := 0; i < 2; i++ {
+		if j := strings.LastIndexByte(name, '-'); j > 0 {
+			name = name[:j]
+		}
+	}
+	return name
+}
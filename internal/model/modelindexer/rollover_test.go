@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestModelIndexerRollover(t *testing.T) {
+	var mu sync.Mutex
+	var rollovers []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/_rollover") {
+			mu.Lock()
+			rollovers = append(rollovers, r.URL.Path)
+			mu.Unlock()
+			w.Write([]byte(`{"acknowledged":true,"rolled_over":true}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	})
+	modelindexertest.HandleBulk(mux, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		for _, itemsMap := range result.Items {
+			for k, item := range itemsMap {
+				result.HasErrors = true
+				item.Index = ".ds-logs-apm_server-testing-2022.01.01-000001"
+				item.Status = http.StatusBadRequest
+				item.Error.Type = "mapper_parsing_exception"
+				item.Error.Reason = "failed to parse field [labels.foo] of type [long]"
+				itemsMap[k] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+	config := elasticsearch.DefaultConfig()
+	config.Hosts = elasticsearch.Hosts{srv.URL}
+	config.Backoff.Max = time.Nanosecond
+	client, err := elasticsearch.NewClient(config)
+	require.NoError(t, err)
+
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushDocs:     5,
+		FlushInterval: time.Minute,
+		Rollover: modelindexer.RolloverConfig{
+			Enabled:   true,
+			Threshold: 2,
+			Interval:  time.Hour,
+		},
+	})
+	require.NoError(t, err)
+
+	batch := make(model.Batch, 5)
+	for i := range batch {
+		batch[i] = model.APMEvent{Timestamp: time.Now(), DataStream: model.DataStream{
+			Type:      "logs",
+			Dataset:   "apm_server",
+			Namespace: "testing",
+		}}
+	}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	require.NoError(t, indexer.Close(context.Background()))
+
+	// Each of the two bulk requests exceeds the threshold, but the data
+	// stream should only be rolled over once due to the rollover interval.
+	assert.Equal(t, []string{"/logs-apm_server-testing/_rollover"}, rollovers)
+	stats := indexer.Stats()
+	assert.Equal(t, int64(10), stats.Failed)
+	assert.Equal(t, modelindexer.RolloverStats{Rollovers: 1}, stats.Rollover)
+}
+
+func TestModelIndexerRolloverDisabled(t *testing.T) {
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		for _, itemsMap := range result.Items {
+			for k, item := range itemsMap {
+				result.HasErrors = true
+				item.Status = http.StatusBadRequest
+				item.Error.Type = "mapper_parsing_exception"
+				itemsMap[k] = item
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{})
+	require.NoError(t, err)
+
+	batch := model.Batch{{Timestamp: time.Now(), DataStream: model.DataStream{
+		Type:      "logs",
+		Dataset:   "apm_server",
+		Namespace: "testing",
+	}}}
+	err = indexer.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	require.NoError(t, indexer.Close(context.Background()))
+	assert.Zero(t, indexer.Stats().Rollover)
+}
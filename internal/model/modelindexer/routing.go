@@ -0,0 +1,35 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import "github.com/elastic/apm-server/internal/model"
+
+// RouteFunc overrides the index and ingest pipeline for an event, enabling
+// custom routing such as per-tenant indices without re-encoding documents
+// downstream.
+//
+// RouteFunc returns the name of the index or data stream into which event
+// should be indexed, and the name of the ingest pipeline through which it
+// should be processed. If index is empty, the event is indexed into its
+// data stream, "<type>-<dataset>-<namespace>". If pipeline is empty, the
+// pipeline configured for the event's data stream in Config.Pipeline or
+// Config.DataStreamPipelines applies, if any.
+//
+// RouteFunc is called synchronously for each event from ProcessBatch, and
+// must be safe for concurrent use. It must not modify the event.
+type RouteFunc func(event *model.APMEvent) (index, pipeline string)
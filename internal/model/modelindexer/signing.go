@@ -0,0 +1,172 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"sync"
+
+	"go.elastic.co/fastjson"
+)
+
+// SigningAlgorithm identifies the algorithm used for signing documents,
+// recorded in each document's signature.alg field.
+const SigningAlgorithm = "hmac-sha256"
+
+// SigningConfig holds configuration for signing documents, enabling
+// downstream consumers to verify that documents were not modified after
+// leaving APM Server.
+//
+// Each document is signed by computing an HMAC-SHA256 over its canonical
+// JSON encoding: object keys sorted lexicographically, no insignificant
+// whitespace, and numbers written exactly as encoded. The signature is
+// added to the document as a top-level "signature" object, with fields
+// "alg", "key_id" (if KeyID is non-empty), and "value" holding the
+// base64-encoded HMAC. The signature object itself is excluded from the
+// signed content.
+//
+// Only events added with ProcessBatch are signed; documents added with
+// IndexDocument are indexed as given.
+type SigningConfig struct {
+	// Key holds the secret key used for computing document signatures.
+	//
+	// If Key is empty, documents are not signed.
+	Key []byte
+
+	// KeyID holds an optional identifier for Key, recorded in each
+	// signature so consumers can select the key for verification, e.g.
+	// during key rotation.
+	KeyID string
+}
+
+// signer computes and appends signatures to encoded documents.
+type signer struct {
+	keyID string
+	pool  sync.Pool
+}
+
+type signerState struct {
+	mac       hash.Hash
+	canonical bytes.Buffer
+	encoder   *json.Encoder
+}
+
+func newSigner(cfg SigningConfig) *signer {
+	s := &signer{keyID: cfg.KeyID}
+	key := append([]byte(nil), cfg.Key...)
+	s.pool.New = func() interface{} {
+		state := &signerState{mac: hmac.New(sha256.New, key)}
+		state.encoder = json.NewEncoder(&state.canonical)
+		state.encoder.SetEscapeHTML(false)
+		return state
+	}
+	return s
+}
+
+// sign computes the signature of the JSON object encoded in w, and
+// appends it to the object as the top-level "signature" field.
+func (s *signer) sign(w *fastjson.Writer) error {
+	state := s.pool.Get().(*signerState)
+	defer s.pool.Put(state)
+
+	sum, err := state.sum(w.Bytes())
+	if err != nil {
+		return err
+	}
+	// Replace the object's closing brace with the signature field.
+	w.Rewind(w.Size() - 1)
+	if b := w.Bytes(); b[len(b)-1] != '{' {
+		w.RawByte(',')
+	}
+	w.RawString(`"signature":{"alg":`)
+	w.String(SigningAlgorithm)
+	if s.keyID != "" {
+		w.RawString(`,"key_id":`)
+		w.String(s.keyID)
+	}
+	w.RawString(`,"value":`)
+	w.String(base64.StdEncoding.EncodeToString(sum))
+	w.RawString("}}")
+	return nil
+}
+
+func (state *signerState) sum(doc []byte) ([]byte, error) {
+	canonical, err := state.canonicalize(doc)
+	if err != nil {
+		return nil, err
+	}
+	state.mac.Reset()
+	state.mac.Write(canonical)
+	return state.mac.Sum(nil), nil
+}
+
+// canonicalize returns the canonical encoding of the JSON object in doc.
+// The returned slice is only valid until the next call to canonicalize.
+func (state *signerState) canonicalize(doc []byte) ([]byte, error) {
+	var object map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil, err
+	}
+	// encoding/json sorts map keys, and json.Number is written verbatim.
+	state.canonical.Reset()
+	if err := state.encoder.Encode(object); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(state.canonical.Bytes(), []byte("\n")), nil
+}
+
+// VerifySignature reports whether doc, a JSON document produced by an
+// Indexer configured with the given signing key, carries a valid
+// signature. VerifySignature is intended for consumers of the signed
+// documents, and for testing.
+func VerifySignature(doc, key []byte) (bool, error) {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(doc, &object); err != nil {
+		return false, err
+	}
+	var signature struct {
+		Alg   string `json:"alg"`
+		Value []byte `json:"value"`
+	}
+	if raw, ok := object["signature"]; ok {
+		if err := json.Unmarshal(raw, &signature); err != nil {
+			return false, err
+		}
+	}
+	if signature.Alg != SigningAlgorithm {
+		return false, nil
+	}
+	delete(object, "signature")
+	unsigned, err := json.Marshal(object)
+	if err != nil {
+		return false, err
+	}
+	state := newSigner(SigningConfig{Key: key}).pool.Get().(*signerState)
+	sum, err := state.sum(unsigned)
+	if err != nil {
+		return false, err
+	}
+	return hmac.Equal(sum, signature.Value), nil
+}
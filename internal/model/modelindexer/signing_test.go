@@ -0,0 +1,111 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+func TestModelIndexerSigning(t *testing.T) {
+	requests := make(chan []modelindexertest.BulkRequestItem, 1)
+	client := modelindexertest.NewMockElasticsearchClient(t, func(w http.ResponseWriter, r *http.Request) {
+		items := modelindexertest.DecodeBulkRequestItems(r)
+		requests <- items
+		var result elasticsearch.BulkIndexerResponse
+		for _, item := range items {
+			result.Items = append(result.Items, map[string]esutil.BulkIndexerResponseItem{
+				item.Action: {Index: item.Index, Status: http.StatusCreated},
+			})
+		}
+		json.NewEncoder(w).Encode(result)
+	})
+	key := []byte("0123456789abcdef0123456789abcdef")
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Minute,
+		Signing:       modelindexer.SigningConfig{Key: key, KeyID: "key-1"},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{{
+		Timestamp:  time.Unix(123, 456789111).UTC(),
+		DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+		Message:    "<b>signed</b> & sealed",
+		Labels:     model.Labels{"b": {Value: "2"}, "a": {Value: "1"}},
+	}}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, indexer.Close(context.Background()))
+
+	items := <-requests
+	require.Len(t, items, 1)
+	doc := items[0].Document
+
+	var decoded struct {
+		Signature struct {
+			Alg   string `json:"alg"`
+			KeyID string `json:"key_id"`
+			Value string `json:"value"`
+		} `json:"signature"`
+	}
+	require.NoError(t, json.Unmarshal(doc, &decoded))
+	assert.Equal(t, "hmac-sha256", decoded.Signature.Alg)
+	assert.Equal(t, "key-1", decoded.Signature.KeyID)
+
+	// The signature is computed over the canonical encoding of the document
+	// without the signature: sorted keys, no whitespace, and no HTML escaping.
+	canonical := `{"@timestamp":"1970-01-01T00:02:03.456Z",` +
+		`"data_stream.dataset":"apm_server","data_stream.namespace":"testing","data_stream.type":"logs",` +
+		`"labels":{"a":"1","b":"2"},"message":"<b>signed</b> & sealed"}`
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(canonical))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), decoded.Signature.Value)
+
+	ok, err := modelindexer.VerifySignature(doc, key)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = modelindexer.VerifySignature(doc, []byte("wrong key"))
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	var modified map[string]interface{}
+	require.NoError(t, json.Unmarshal(doc, &modified))
+	modified["message"] = "tampered"
+	modifiedDoc, err := json.Marshal(modified)
+	require.NoError(t, err)
+	ok, err = modelindexer.VerifySignature(modifiedDoc, key)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
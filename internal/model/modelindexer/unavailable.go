@@ -0,0 +1,134 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+// UnavailableBufferConfig holds configuration for holding documents in
+// memory while Elasticsearch refuses connections, e.g. while it restarts,
+// rather than counting them as failed.
+type UnavailableBufferConfig struct {
+	// MaxBytes holds the maximum total size of the documents held in
+	// memory. Documents of bulk requests that fail once the limit is
+	// reached are counted as failed.
+	//
+	// If MaxBytes is zero, documents are not held.
+	MaxBytes int
+
+	// MaxAge holds the maximum amount of time for which a document is
+	// held, from the first failed bulk request in which it was sent.
+	// Documents still failing after MaxAge are counted as failed.
+	//
+	// If MaxAge is zero, the default of 30 seconds will be used.
+	MaxAge time.Duration
+
+	// RetryInterval holds the amount of time to wait before re-enqueuing
+	// held documents, to be sent in a later bulk request.
+	//
+	// If RetryInterval is zero, the default of 1 second will be used.
+	RetryInterval time.Duration
+}
+
+// isUnavailableError reports whether err, returned from a bulk request,
+// indicates that Elasticsearch could not be connected to, such that the
+// request was not processed.
+func isUnavailableError(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// heldBody is the body of a bulk item held while Elasticsearch was
+// unavailable. It holds a copy of the document retained by the bulk
+// indexer, along with the time at which the document was first held,
+// and the retry attempts and dead letter status of the original item.
+type heldBody struct {
+	*bytes.Reader
+	since      time.Time
+	attempts   int
+	deadLetter bool
+}
+
+// holdUnavailable holds the documents of a bulk request that failed
+// because Elasticsearch was unavailable, re-enqueuing them after
+// Config.UnavailableBuffer.RetryInterval. Documents are not held once
+// they have been held for MaxAge, or if holding them would exceed
+// MaxBytes.
+//
+// holdUnavailable returns the number of items in the request which were
+// not held, which the caller should count as failed.
+func (i *Indexer) holdUnavailable(b *bulkIndexer) int {
+	cfg := i.config.UnavailableBuffer
+	now := time.Now()
+	heldBytes := atomic.LoadInt64(&i.heldBytes)
+	var items []elasticsearch.BulkIndexerItem
+	var size int64
+	for position := 0; position < b.Items(); position++ {
+		item, n, ok := holdItem(b, position, now, cfg.MaxAge, cfg.MaxBytes-int(heldBytes+size))
+		if !ok {
+			continue
+		}
+		items = append(items, item)
+		size += int64(n)
+	}
+	if len(items) > 0 {
+		i.holdItems(items, size)
+	}
+	return b.Items() - len(items)
+}
+
+// holdItem takes the item at the given position in the bulk request for
+// sending again once Elasticsearch is available, returning it along with
+// the size of its document. holdItem returns false if documents are not
+// retained, the item was first held more than maxAge before now, or its
+// document is larger than maxBytes.
+func holdItem(b *bulkIndexer, position int, now time.Time, maxAge time.Duration, maxBytes int) (elasticsearch.BulkIndexerItem, int, bool) {
+	item, doc, ok := b.Document(position)
+	if !ok {
+		return elasticsearch.BulkIndexerItem{}, 0, false
+	}
+	state := retainedStateOf(item.Body)
+	if state.heldSince.IsZero() {
+		state.heldSince = now
+	}
+	if now.Sub(state.heldSince) >= maxAge || len(doc) > maxBytes {
+		return elasticsearch.BulkIndexerItem{}, 0, false
+	}
+	item, doc, _ = b.Take(position)
+	item.Body = state.body(doc)
+	return item, len(doc), true
+}
+
+// holdItems re-enqueues held items after the retry interval. If the
+// Indexer is closed first, the items are counted as failed and their
+// OnFailure callbacks are called with ErrClosed.
+func (i *Indexer) holdItems(items []elasticsearch.BulkIndexerItem, size int64) {
+	atomic.AddInt64(&i.eventsHeld, int64(len(items)))
+	atomic.AddInt64(&i.heldBytes, size)
+	atomic.AddInt64(&i.eventsActive, int64(len(items)))
+	i.requeueAfter(items, i.config.UnavailableBuffer.RetryInterval, func() {
+		atomic.AddInt64(&i.eventsHeld, -int64(len(items)))
+		atomic.AddInt64(&i.heldBytes, -size)
+	})
+}
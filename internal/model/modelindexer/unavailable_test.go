@@ -0,0 +1,231 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelindexer_test
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelindexer"
+	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+)
+
+// refusingTransport fails requests with ECONNREFUSED while refuse is set,
+// as if Elasticsearch were restarting.
+type refusingTransport struct {
+	http.RoundTripper
+	refuse int32
+}
+
+func (t *refusingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if atomic.LoadInt32(&t.refuse) == 1 {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	}
+	return t.RoundTripper.RoundTrip(req)
+}
+
+func newRefusingClient(t testing.TB, bulkHandler http.HandlerFunc) (elasticsearch.Client, *refusingTransport) {
+	config := modelindexertest.NewMockElasticsearchClientConfig(t, bulkHandler)
+	httpTransport, err := elasticsearch.NewHTTPTransport(config)
+	require.NoError(t, err)
+	transport := &refusingTransport{RoundTripper: httpTransport, refuse: 1}
+	client, err := elasticsearch.NewClientParams(elasticsearch.ClientParams{
+		Config:    config,
+		Transport: transport,
+	})
+	require.NoError(t, err)
+	return client, transport
+}
+
+func TestModelIndexerUnavailableBuffer(t *testing.T) {
+	var indexed int64
+	client, transport := newRefusingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		atomic.AddInt64(&indexed, int64(len(result.Items)))
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Millisecond,
+		UnavailableBuffer: modelindexer.UnavailableBufferConfig{
+			MaxBytes:      1024 * 1024,
+			MaxAge:        time.Minute,
+			RetryInterval: 10 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+
+	// Events are held while Elasticsearch refuses connections.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Held == 2
+	}, 10*time.Second, time.Millisecond)
+	atomic.StoreInt32(&transport.refuse, 0)
+
+	// Once Elasticsearch is available, the held events are indexed.
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt64(&indexed) == 2
+	}, 10*time.Second, time.Millisecond)
+	require.NoError(t, indexer.Close(context.Background()))
+
+	stats := indexer.Stats()
+	assert.Equal(t, int64(2), stats.Indexed)
+	assert.Equal(t, int64(0), stats.Failed)
+	assert.Equal(t, int64(0), stats.Held)
+	assert.Equal(t, int64(0), stats.Active)
+}
+
+func TestModelIndexerUnavailableBufferDataStreamFlush(t *testing.T) {
+	client, transport := newRefusingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, result := modelindexertest.DecodeBulkRequest(r)
+		json.NewEncoder(w).Encode(result)
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Hour,
+		DataStreamFlush: map[string]modelindexer.FlushConfig{
+			"logs": {FlushInterval: time.Millisecond},
+		},
+		UnavailableBuffer: modelindexer.UnavailableBufferConfig{
+			MaxBytes:      1024 * 1024,
+			MaxAge:        time.Minute,
+			RetryInterval: 10 * time.Millisecond,
+		},
+	})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{
+		{Timestamp: time.Now(), DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"}},
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Held == 1
+	}, 10*time.Second, time.Millisecond)
+	atomic.StoreInt32(&transport.refuse, 0)
+
+	// Held events are queued with other logs events, and flushed
+	// at the logs flush interval rather than the default.
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Indexed == 1
+	}, 10*time.Second, time.Millisecond)
+}
+
+func TestModelIndexerUnavailableBufferClosed(t *testing.T) {
+	client, _ := newRefusingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		panic("unexpected bulk request")
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{
+		FlushInterval: time.Millisecond,
+		UnavailableBuffer: modelindexer.UnavailableBufferConfig{
+			MaxBytes:      1024 * 1024,
+			MaxAge:        time.Minute,
+			RetryInterval: time.Microsecond,
+		},
+	})
+	require.NoError(t, err)
+
+	batch := make(model.Batch, 10)
+	for i := range batch {
+		batch[i] = model.APMEvent{
+			Timestamp:  time.Now(),
+			DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+		}
+	}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Held > 0
+	}, 10*time.Second, time.Millisecond)
+
+	// Events held when the indexer closes are counted as failed,
+	// and never left in the queues.
+	require.NoError(t, indexer.Close(context.Background()))
+	stats := indexer.Stats()
+	assert.Equal(t, int64(0), stats.Held)
+	assert.Equal(t, int64(0), stats.Active)
+	assert.Equal(t, int64(10), stats.Failed)
+}
+
+func TestModelIndexerUnavailableBufferLimits(t *testing.T) {
+	for name, cfg := range map[string]modelindexer.UnavailableBufferConfig{
+		"max_age":   {MaxBytes: 1024 * 1024, MaxAge: 50 * time.Millisecond, RetryInterval: time.Millisecond},
+		"max_bytes": {MaxBytes: 1, MaxAge: time.Minute, RetryInterval: time.Millisecond},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client, _ := newRefusingClient(t, func(w http.ResponseWriter, r *http.Request) {
+				panic("unexpected bulk request")
+			})
+			indexer, err := modelindexer.New(client, modelindexer.Config{
+				FlushInterval:     time.Millisecond,
+				UnavailableBuffer: cfg,
+			})
+			require.NoError(t, err)
+			defer indexer.Close(context.Background())
+
+			batch := model.Batch{{
+				Timestamp:  time.Now(),
+				DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+			}}
+			require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+
+			// Events are counted as failed once they cannot be held.
+			assert.Eventually(t, func() bool {
+				return indexer.Stats().Failed == 1
+			}, 10*time.Second, time.Millisecond)
+			assert.ErrorIs(t, indexer.Close(context.Background()), syscall.ECONNREFUSED)
+			stats := indexer.Stats()
+			assert.Equal(t, int64(0), stats.Held)
+			assert.Equal(t, int64(0), stats.Active)
+		})
+	}
+}
+
+func TestModelIndexerUnavailableBufferDisabled(t *testing.T) {
+	client, _ := newRefusingClient(t, func(w http.ResponseWriter, r *http.Request) {
+		panic("unexpected bulk request")
+	})
+	indexer, err := modelindexer.New(client, modelindexer.Config{FlushInterval: time.Millisecond})
+	require.NoError(t, err)
+	defer indexer.Close(context.Background())
+
+	batch := model.Batch{{
+		Timestamp:  time.Now(),
+		DataStream: model.DataStream{Type: "logs", Dataset: "apm_server", Namespace: "testing"},
+	}}
+	require.NoError(t, indexer.ProcessBatch(context.Background(), &batch))
+	assert.Eventually(t, func() bool {
+		return indexer.Stats().Failed == 1
+	}, 10*time.Second, time.Millisecond)
+	assert.Equal(t, int64(0), indexer.Stats().Held)
+}
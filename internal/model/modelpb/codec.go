@@ -0,0 +1,434 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelpb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"reflect"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// ErrUnsupportedVersion is returned by UnmarshalBatch when the batch was
+// encoded with a version other than Version.
+var ErrUnsupportedVersion = errors.New("unsupported batch encoding version")
+
+// MarshalBatch returns the protobuf encoding of batch, as a Batch message.
+func MarshalBatch(batch model.Batch) ([]byte, error) {
+	b := protowire.AppendTag(nil, batchVersionField, protowire.VarintType)
+	b = protowire.AppendVarint(b, Version)
+	for i := range batch {
+		b = appendMessage(b, batchEventsField, reflect.ValueOf(&batch[i]).Elem(), apmEventSchema.root, true)
+	}
+	return b, nil
+}
+
+// UnmarshalBatch decodes a Batch message from data, appending the decoded
+// events to batch.
+//
+// Unknown fields are ignored, so events encoded by a newer APM Server with
+// the same Version can be decoded.
+func UnmarshalBatch(data []byte, batch *model.Batch) error {
+	var version uint64
+	var events [][]byte
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == batchVersionField && typ == protowire.VarintType:
+			version, n = protowire.ConsumeVarint(data)
+		case num == batchEventsField && typ == protowire.BytesType:
+			var event []byte
+			event, n = protowire.ConsumeBytes(data)
+			events = append(events, event)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	if version != Version {
+		return fmt.Errorf("%w %d", ErrUnsupportedVersion, version)
+	}
+	offset := len(*batch)
+	if n := offset + len(events); n > cap(*batch) {
+		grown := make(model.Batch, offset, n)
+		copy(grown, *batch)
+		*batch = grown
+	}
+	*batch = (*batch)[:offset+len(events)]
+	for i, event := range events {
+		if err := decodeMessage(event, reflect.ValueOf(&(*batch)[offset+i]).Elem(), apmEventSchema.root); err != nil {
+			*batch = (*batch)[:offset]
+			return err
+		}
+	}
+	return nil
+}
+
+// appendMessage appends v, encoded as message m, as field num. If always is
+// false, nothing is appended if the message would be empty.
+func appendMessage(b []byte, num protowire.Number, v reflect.Value, m *message, always bool) []byte {
+	start := len(b)
+	b = appendFields(b, v, m)
+	n := len(b) - start
+	if n == 0 && !always {
+		return b
+	}
+	// Move the encoded fields to make room for the tag and length prefix.
+	var hdrbuf [16]byte
+	hdr := protowire.AppendTag(hdrbuf[:0], num, protowire.BytesType)
+	hdr = protowire.AppendVarint(hdr, uint64(n))
+	b = append(b, hdr...)
+	copy(b[start+len(hdr):], b[start:start+n])
+	copy(b[start:], hdr)
+	return b
+}
+
+func appendFields(b []byte, v reflect.Value, m *message) []byte {
+	for _, f := range m.fields {
+		fv := v
+		if f.index >= 0 {
+			fv = v.Field(f.index)
+		}
+		switch {
+		case f.repeated:
+			b = appendRepeated(b, f, fv)
+		case f.pointer:
+			if !fv.IsNil() {
+				b = appendValue(b, f.number, fv.Elem(), f.value, true)
+			}
+		default:
+			b = appendValue(b, f.number, fv, f.value, false)
+		}
+	}
+	return b
+}
+
+func appendRepeated(b []byte, f *field, v reflect.Value) []byte {
+	n := v.Len()
+	if n == 0 {
+		return b
+	}
+	if f.value.packed() {
+		var packed []byte
+		for i := 0; i < n; i++ {
+			packed = appendScalar(packed, v.Index(i), f.value)
+		}
+		b = protowire.AppendTag(b, f.number, protowire.BytesType)
+		return protowire.AppendBytes(b, packed)
+	}
+	for i := 0; i < n; i++ {
+		elem := v.Index(i)
+		if elem.Kind() == reflect.Pointer {
+			if elem.IsNil() {
+				elem = reflect.New(elem.Type().Elem())
+			}
+			elem = elem.Elem()
+		}
+		b = appendValue(b, f.number, elem, f.value, true)
+	}
+	return b
+}
+
+// appendValue appends v as field num. If always is false, zero values
+// are omitted.
+func appendValue(b []byte, num protowire.Number, v reflect.Value, vt *valueType, always bool) []byte {
+	switch vt.kind {
+	case kindMessage:
+		return appendMessage(b, num, v, vt.message, always)
+	case kindMap:
+		return appendMap(b, num, v, vt)
+	case kindString:
+		if !always && v.Len() == 0 {
+			return b
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendString(b, v.String())
+	case kindAddr:
+		addr := v.Interface().(netip.Addr)
+		if !always && !addr.IsValid() {
+			return b
+		}
+		data, _ := addr.MarshalBinary()
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, data)
+	case kindJSON:
+		if v.IsNil() {
+			return b
+		}
+		data, err := json.Marshal(v.Interface())
+		if err != nil {
+			// Values which cannot be encoded as JSON are
+			// also rejected when indexing; drop them.
+			return b
+		}
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		return protowire.AppendBytes(b, data)
+	}
+	if !always && v.IsZero() {
+		return b
+	}
+	if vt.kind == kindFloat {
+		b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	} else {
+		b = protowire.AppendTag(b, num, protowire.VarintType)
+	}
+	return appendScalar(b, v, vt)
+}
+
+// appendScalar appends the value of a scalar (varint or fixed64) field,
+// without a tag.
+func appendScalar(b []byte, v reflect.Value, vt *valueType) []byte {
+	switch vt.kind {
+	case kindBool:
+		return protowire.AppendVarint(b, protowire.EncodeBool(v.Bool()))
+	case kindInt, kindDuration:
+		return protowire.AppendVarint(b, uint64(v.Int()))
+	case kindTime:
+		return protowire.AppendVarint(b, uint64(v.Interface().(time.Time).UnixNano()))
+	case kindFloat:
+		return protowire.AppendFixed64(b, math.Float64bits(v.Float()))
+	}
+	panic(fmt.Errorf("unexpected scalar kind %d", vt.kind))
+}
+
+// appendMap appends the entries of map v, sorted by key, as repeated
+// map entry messages.
+func appendMap(b []byte, num protowire.Number, v reflect.Value, vt *valueType) []byte {
+	if v.Len() == 0 {
+		return b
+	}
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	for _, key := range keys {
+		start := len(b)
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, key.String())
+		b = appendValue(b, 2, v.MapIndex(key), vt.elem, true)
+
+		n := len(b) - start
+		var hdrbuf [16]byte
+		hdr := protowire.AppendTag(hdrbuf[:0], num, protowire.BytesType)
+		hdr = protowire.AppendVarint(hdr, uint64(n))
+		b = append(b, hdr...)
+		copy(b[start+len(hdr):], b[start:start+n])
+		copy(b[start:], hdr)
+	}
+	return b
+}
+
+func (vt *valueType) packed() bool {
+	switch vt.kind {
+	case kindBool, kindInt, kindFloat, kindTime, kindDuration:
+		return true
+	}
+	return false
+}
+
+func (vt *valueType) wireType() protowire.Type {
+	switch vt.kind {
+	case kindBool, kindInt, kindTime, kindDuration:
+		return protowire.VarintType
+	case kindFloat:
+		return protowire.Fixed64Type
+	}
+	return protowire.BytesType
+}
+
+// decodeMessage decodes the fields of message m from data into v.
+func decodeMessage(data []byte, v reflect.Value, m *message) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		if f, ok := m.byNumber[num]; ok {
+			var err error
+			n, err = decodeField(data, typ, v, f)
+			if err != nil {
+				return fmt.Errorf("error decoding %s.%s: %w", m.name, f.name, err)
+			}
+		} else {
+			// Unknown field, added in a newer version.
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+func decodeField(data []byte, typ protowire.Type, v reflect.Value, f *field) (int, error) {
+	fv := v
+	if f.index >= 0 {
+		fv = v.Field(f.index)
+	}
+	if f.repeated && typ == protowire.BytesType && f.value.packed() {
+		packed, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return n, nil
+		}
+		for len(packed) > 0 {
+			elem := reflect.New(fv.Type().Elem()).Elem()
+			m, err := decodeScalar(packed, f.value.wireType(), elem, f.value)
+			if m < 0 || err != nil {
+				return m, err
+			}
+			fv.Set(reflect.Append(fv, elem))
+			packed = packed[m:]
+		}
+		return n, nil
+	}
+	if typ != f.value.wireType() {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	switch {
+	case f.repeated:
+		elemType := fv.Type().Elem()
+		elem := reflect.New(elemType).Elem()
+		target := elem
+		if elemType.Kind() == reflect.Pointer {
+			elem.Set(reflect.New(elemType.Elem()))
+			target = elem.Elem()
+		}
+		n, err := decodeValue(data, typ, target, f.value)
+		if n >= 0 && err == nil {
+			fv.Set(reflect.Append(fv, elem))
+		}
+		return n, err
+	case f.pointer:
+		if fv.IsNil() {
+			fv.Set(reflect.New(fv.Type().Elem()))
+		}
+		return decodeValue(data, typ, fv.Elem(), f.value)
+	}
+	return decodeValue(data, typ, fv, f.value)
+}
+
+func decodeValue(data []byte, typ protowire.Type, v reflect.Value, vt *valueType) (int, error) {
+	if typ != protowire.BytesType {
+		return decodeScalar(data, typ, v, vt)
+	}
+	b, n := protowire.ConsumeBytes(data)
+	if n < 0 {
+		return n, nil
+	}
+	switch vt.kind {
+	case kindString:
+		v.SetString(string(b))
+	case kindAddr:
+		var addr netip.Addr
+		if err := addr.UnmarshalBinary(b); err != nil {
+			return n, err
+		}
+		v.Set(reflect.ValueOf(addr))
+	case kindJSON:
+		ptr := reflect.New(v.Type())
+		if err := json.Unmarshal(b, ptr.Interface()); err != nil {
+			return n, err
+		}
+		v.Set(ptr.Elem())
+	case kindMessage:
+		if err := decodeMessage(b, v, vt.message); err != nil {
+			return n, err
+		}
+	case kindMap:
+		if err := decodeMapEntry(b, v, vt); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func decodeScalar(data []byte, typ protowire.Type, v reflect.Value, vt *valueType) (int, error) {
+	if typ != vt.wireType() {
+		return 0, fmt.Errorf("unexpected wire type %d", typ)
+	}
+	if vt.kind == kindFloat {
+		x, n := protowire.ConsumeFixed64(data)
+		if n >= 0 {
+			v.SetFloat(math.Float64frombits(x))
+		}
+		return n, nil
+	}
+	x, n := protowire.ConsumeVarint(data)
+	if n < 0 {
+		return n, nil
+	}
+	switch vt.kind {
+	case kindBool:
+		v.SetBool(protowire.DecodeBool(x))
+	case kindInt, kindDuration:
+		v.SetInt(int64(x))
+	case kindTime:
+		v.Set(reflect.ValueOf(time.Unix(0, int64(x)).UTC()))
+	}
+	return n, nil
+}
+
+func decodeMapEntry(data []byte, v reflect.Value, vt *valueType) error {
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(v.Type()))
+	}
+	var key string
+	value := reflect.New(v.Type().Elem()).Elem()
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(data)
+		case num == 2:
+			if typ != vt.elem.wireType() {
+				return fmt.Errorf("unexpected wire type %d", typ)
+			}
+			var err error
+			if n, err = decodeValue(data, typ, value, vt.elem); err != nil {
+				return err
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		data = data[n:]
+	}
+	v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), value)
+	return nil
+}
@@ -0,0 +1,369 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelpb
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestProtoUpToDate(t *testing.T) {
+	expected, err := os.ReadFile("apmevent.proto")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(Proto()), "apmevent.proto is out of date, run `make go-generate`")
+}
+
+func TestFieldNumbersFrozen(t *testing.T) {
+	f, err := os.Open("testdata/fieldnumbers.txt")
+	require.NoError(t, err)
+	defer f.Close()
+
+	frozen := make(map[string]protowire.Number)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, number, ok := strings.Cut(line, " ")
+		require.True(t, ok, line)
+		n, err := strconv.Atoi(number)
+		require.NoError(t, err, line)
+		frozen[name] = protowire.Number(n)
+	}
+	require.NoError(t, scanner.Err())
+
+	current := make(map[string]protowire.Number)
+	for _, m := range apmEventSchema.messages {
+		for _, f := range m.fields {
+			name := m.name + "." + f.name
+			current[name] = f.number
+			number, ok := frozen[name]
+			if !ok {
+				t.Errorf("field %s has no frozen number, append %q to testdata/fieldnumbers.txt", name, fmt.Sprintf("%s %d", name, f.number))
+				continue
+			}
+			assert.Equal(t, number, f.number, "field number of %s changed", name)
+		}
+	}
+	for name, number := range frozen {
+		if _, ok := current[name]; ok {
+			continue
+		}
+		// Removed fields must remain reserved.
+		messageName, _, _ := strings.Cut(name, ".")
+		typ, ok := apmEventSchema.byName[messageName]
+		if !ok {
+			continue
+		}
+		assert.Contains(t, apmEventSchema.byType[typ].reserved, number, "number of removed field %s is not reserved", name)
+	}
+}
+
+func TestAssignNumbers(t *testing.T) {
+	type Message struct {
+		C string
+		A string
+		D string
+	}
+	previous := parseFieldNumbers(`
+message Message {
+  string a = 1;
+  string b = 2; // removed
+  string c = 3;
+  reserved 4;
+  reserved "x";
+}
+`)
+	s := newSchema(reflect.TypeOf(Message{}), previous)
+	numbers := make(map[string]protowire.Number)
+	for _, f := range s.root.fields {
+		numbers[f.name] = f.number
+	}
+	assert.Equal(t, map[string]protowire.Number{"a": 1, "c": 3, "d": 5}, numbers)
+	assert.Equal(t, []protowire.Number{2, 4}, s.root.reserved)
+	assert.Equal(t, []string{"b", "x"}, s.root.reservedNames)
+	assert.Contains(t, string(s.proto()), "  string d = 5;\n  reserved 2, 4;\n  reserved \"b\", \"x\";\n}\n")
+
+	// Regenerating from the output must not change any numbers.
+	regenerated := newSchema(reflect.TypeOf(Message{}), parseFieldNumbers(string(s.proto())))
+	assert.Equal(t, s.proto(), regenerated.proto())
+}
+
+func TestMarshalUnmarshalBatch(t *testing.T) {
+	var event model.APMEvent
+	setFieldValues(t, reflect.ValueOf(&event).Elem())
+	in := model.Batch{event, {Message: "second", Processor: model.LogProcessor}}
+
+	data, err := MarshalBatch(in)
+	require.NoError(t, err)
+
+	out := model.Batch{{Message: "existing"}}
+	require.NoError(t, UnmarshalBatch(data, &out))
+	require.Len(t, out, 3)
+	assert.Equal(t, "existing", out[0].Message)
+	assert.Equal(t, in, out[1:])
+}
+
+func TestMarshalBatchZeroValues(t *testing.T) {
+	zero, one := 0, 1
+	in := model.Batch{{
+		Transaction: &model.Transaction{},
+		Span:        &model.Span{Stacktrace: model.Stacktrace{{Lineno: &zero}, {Lineno: &one}}},
+	}}
+	data, err := MarshalBatch(in)
+	require.NoError(t, err)
+
+	var out model.Batch
+	require.NoError(t, UnmarshalBatch(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestUnmarshalBatchUnsupportedVersion(t *testing.T) {
+	data := protowire.AppendTag(nil, batchVersionField, protowire.VarintType)
+	data = protowire.AppendVarint(data, Version+1)
+
+	var out model.Batch
+	err := UnmarshalBatch(data, &out)
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	assert.Empty(t, out)
+}
+
+func TestUnmarshalBatchUnknownFields(t *testing.T) {
+	in := model.Batch{{Message: "hello"}}
+	data, err := MarshalBatch(in)
+	require.NoError(t, err)
+
+	// Append an unknown field to the event, as would be encoded
+	// by a newer version with a field added to model.APMEvent.
+	var event []byte
+	event = protowire.AppendTag(event, 31, protowire.BytesType)
+	event = protowire.AppendString(event, "hello")
+	event = protowire.AppendTag(event, 1000, protowire.VarintType)
+	event = protowire.AppendVarint(event, 123)
+	data = protowire.AppendTag(data[:2], batchEventsField, protowire.BytesType)
+	data = protowire.AppendBytes(data, event)
+
+	var out model.Batch
+	require.NoError(t, UnmarshalBatch(data, &out))
+	assert.Equal(t, in, out)
+}
+
+func TestUnmarshalBatchInvalid(t *testing.T) {
+	in := model.Batch{{Message: "hello"}}
+	data, err := MarshalBatch(in)
+	require.NoError(t, err)
+
+	var out model.Batch
+	assert.Error(t, UnmarshalBatch(data[:len(data)-1], &out))
+	assert.Empty(t, out)
+}
+
+// BenchmarkMarshalBatch compares MarshalBatch with the JSON encoding
+// previously used for forwarding events.
+func BenchmarkMarshalBatch(b *testing.B) {
+	batch := benchmarkBatch()
+	b.Run("proto", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := MarshalBatch(batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := json.Marshal(batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkUnmarshalBatch compares UnmarshalBatch with the JSON decoding
+// previously used for forwarding events.
+func BenchmarkUnmarshalBatch(b *testing.B) {
+	batch := benchmarkBatch()
+	b.Run("proto", func(b *testing.B) {
+		data, err := MarshalBatch(batch)
+		require.NoError(b, err)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out model.Batch
+			if err := UnmarshalBatch(data, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		data, err := json.Marshal(batch)
+		require.NoError(b, err)
+		b.SetBytes(int64(len(data)))
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var out model.Batch
+			if err := jsoniter.ConfigFastest.Unmarshal(data, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// benchmarkBatch returns a batch of transactions and spans with the
+// fields typically set by agents.
+func benchmarkBatch() model.Batch {
+	timestamp := time.Unix(1234, 5678).UTC()
+	event := model.APMEvent{
+		Timestamp: timestamp,
+		Agent:     model.Agent{Name: "go", Version: "2.1.0"},
+		Service: model.Service{
+			Name:        "opbeans-go",
+			Version:     "1.0.0",
+			Environment: "production",
+			Language:    model.Language{Name: "go", Version: "1.19"},
+			Runtime:     model.Runtime{Name: "gc", Version: "1.19"},
+		},
+		Host:    model.Host{Hostname: "host-1", OS: model.OS{Platform: "linux"}},
+		Process: model.Process{Pid: 123, Title: "opbeans-go"},
+		Labels:  model.Labels{"region": {Value: "eu-1"}},
+		Trace:   model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Event:   model.Event{Duration: 123 * time.Millisecond, Outcome: "success"},
+	}
+	var batch model.Batch
+	for i := 0; i < 10; i++ {
+		transaction := event
+		transaction.Processor = model.TransactionProcessor
+		transaction.Transaction = &model.Transaction{
+			ID:        "0102030405060708",
+			Name:      "GET /api/products",
+			Type:      "request",
+			Result:    "HTTP 2xx",
+			Sampled:   true,
+			SpanCount: model.SpanCount{Started: newInt(9)},
+		}
+		transaction.HTTP = model.HTTP{
+			Request:  &model.HTTPRequest{Method: "GET"},
+			Response: &model.HTTPResponse{StatusCode: 200},
+		}
+		transaction.URL = model.URL{Original: "/api/products", Path: "/api/products", Scheme: "http"}
+		batch = append(batch, transaction)
+		for j := 0; j < 9; j++ {
+			span := event
+			span.Processor = model.SpanProcessor
+			span.Parent = model.Parent{ID: "0102030405060708"}
+			span.Transaction = &model.Transaction{ID: "0102030405060708"}
+			span.Span = &model.Span{
+				ID:      "0102030405060709",
+				Name:    "SELECT FROM products",
+				Type:    "db",
+				Subtype: "postgresql",
+				DB:      &model.DB{Statement: "SELECT * FROM products", Type: "sql"},
+				Stacktrace: model.Stacktrace{{
+					Function:     "main.handleProducts",
+					Filename:     "main.go",
+					AbsPath:      "/src/opbeans-go/main.go",
+					Lineno:       newInt(123),
+					LibraryFrame: false,
+				}},
+			}
+			batch = append(batch, span)
+		}
+	}
+	return batch
+}
+
+func newInt(v int) *int {
+	return &v
+}
+
+// setFieldValues sets all fields in v to non-zero values, recursively.
+// Recursive types (e.g. exception causes) are populated to a limited depth.
+func setFieldValues(t testing.TB, v reflect.Value) {
+	setFieldValuesDepth(t, v, make(map[reflect.Type]int))
+}
+
+func setFieldValuesDepth(t testing.TB, v reflect.Value, depth map[reflect.Type]int) {
+	switch {
+	case v.Type() == timeType:
+		v.Set(reflect.ValueOf(time.Unix(123, 456).UTC()))
+		return
+	case v.Type() == addrType:
+		v.Set(reflect.ValueOf(netip.MustParseAddr("10.1.2.3")))
+		return
+	case v.Type() == mapstrType:
+		v.Set(reflect.ValueOf(mapstr.M{"key": map[string]interface{}{"nested": "value"}}))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		v.SetInt(-123)
+	case reflect.Float64:
+		v.SetFloat(1.5)
+	case reflect.String:
+		v.SetString("value")
+	case reflect.Interface:
+		v.Set(reflect.ValueOf("value"))
+	case reflect.Pointer:
+		v.Set(reflect.New(v.Type().Elem()))
+		setFieldValuesDepth(t, v.Elem(), depth)
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		for i := 0; i < v.Len(); i++ {
+			setFieldValuesDepth(t, v.Index(i), depth)
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		key := reflect.New(v.Type().Key()).Elem()
+		setFieldValuesDepth(t, key, depth)
+		elem := reflect.New(v.Type().Elem()).Elem()
+		setFieldValuesDepth(t, elem, depth)
+		v.SetMapIndex(key, elem)
+	case reflect.Struct:
+		if depth[v.Type()] >= 2 {
+			return
+		}
+		depth[v.Type()]++
+		defer func() { depth[v.Type()]-- }()
+		for i := 0; i < v.NumField(); i++ {
+			setFieldValuesDepth(t, v.Field(i), depth)
+		}
+	default:
+		t.Fatalf("unhandled type %s", v.Type())
+	}
+}
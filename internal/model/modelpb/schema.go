@@ -0,0 +1,494 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package modelpb defines the canonical protobuf wire representation of
+// model.APMEvent, used for exchanging decoded events between APM Servers.
+//
+// The protobuf schema (apmevent.proto) is generated from the model types,
+// and events are encoded and decoded according to the same derived schema,
+// so the schema and the wire format cannot diverge. Field numbers are read
+// from the checked-in apmevent.proto, so model fields may be reordered
+// freely: new fields are assigned the next unused number when the schema
+// is regenerated, and the numbers of removed fields are reserved. Numbers
+// released with a given Version are recorded in testdata/fieldnumbers.txt,
+// and must never change.
+package modelpb
+
+import (
+	_ "embed"
+	"fmt"
+	"net/netip"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/elastic/elastic-agent-libs/mapstr"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	// Version holds the version of the wire representation. Version is
+	// encoded in every batch, and batches with a different version are
+	// rejected by UnmarshalBatch.
+	Version = 1
+
+	// protoPackage holds the name of the protobuf package.
+	protoPackage = "elastic.apm.model.v1"
+
+	batchMessageName  = "Batch"
+	batchVersionField = 1
+	batchEventsField  = 2
+)
+
+// kind describes how a Go value is represented on the wire.
+type kind int
+
+const (
+	kindBool kind = iota
+	kindInt
+	kindFloat
+	kindString
+	kindTime
+	kindDuration
+	kindAddr
+	kindJSON
+	kindMessage
+	kindMap
+)
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+	addrType     = reflect.TypeOf(netip.Addr{})
+	mapstrType   = reflect.TypeOf(mapstr.M{})
+)
+
+// valueType describes the wire representation of a Go type.
+type valueType struct {
+	kind kind
+	typ  reflect.Type
+
+	// message is set for kindMessage.
+	message *message
+
+	// elem is set for kindMap, and describes the map values.
+	// Map keys are always strings.
+	elem *valueType
+}
+
+// message describes a protobuf message derived from a Go type.
+type message struct {
+	name   string
+	typ    reflect.Type
+	fields []*field
+
+	// byNumber holds fields keyed by their field number.
+	byNumber map[protowire.Number]*field
+
+	// reserved holds the field numbers and names of fields which have
+	// been removed, and which must not be reused.
+	reserved      []protowire.Number
+	reservedNames []string
+
+	// wrapper is true if the message wraps a single, non-struct value
+	// (i.e. a slice or map) which cannot be used directly as a map value.
+	// The message's only field refers to the wrapped value itself.
+	wrapper bool
+}
+
+// field describes a protobuf message field.
+type field struct {
+	name   string
+	number protowire.Number
+
+	// index holds the index of the Go struct field, or -1 for the
+	// value of a wrapper message.
+	index int
+
+	// pointer is true if the Go field is a pointer, in which case
+	// the field is encoded whenever it is non-nil, including zero
+	// values.
+	pointer bool
+
+	// repeated is true if the Go field is a slice.
+	repeated bool
+
+	value *valueType
+}
+
+// schema holds the messages derived from model.APMEvent.
+type schema struct {
+	root     *message
+	messages []*message
+	byName   map[string]reflect.Type
+	byType   map[reflect.Type]*message
+	numbers  map[string]*messageNumbers
+}
+
+// messageNumbers holds the field numbers assigned to a message
+// in a previously generated schema.
+type messageNumbers struct {
+	fields        map[string]protowire.Number
+	reserved      []protowire.Number
+	reservedNames []string
+}
+
+//go:embed apmevent.proto
+var checkedInProto string
+
+var apmEventSchema = newSchema(reflect.TypeOf(model.APMEvent{}), parseFieldNumbers(checkedInProto))
+
+func newSchema(root reflect.Type, numbers map[string]*messageNumbers) *schema {
+	s := &schema{
+		byName:  map[string]reflect.Type{batchMessageName: nil},
+		byType:  make(map[reflect.Type]*message),
+		numbers: numbers,
+	}
+	s.root = s.structMessage(root)
+	return s
+}
+
+func (s *schema) addMessage(name string, typ reflect.Type) *message {
+	if existing, ok := s.byName[name]; ok {
+		panic(fmt.Errorf("protobuf message name %q for %s conflicts with %v", name, typ, existing))
+	}
+	m := &message{name: name, typ: typ, byNumber: make(map[protowire.Number]*field)}
+	s.byName[name] = typ
+	s.byType[typ] = m
+	s.messages = append(s.messages, m)
+	return m
+}
+
+func (s *schema) structMessage(typ reflect.Type) *message {
+	if m, ok := s.byType[typ]; ok {
+		return m
+	}
+	m := s.addMessage(typ.Name(), typ)
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if !sf.IsExported() {
+			panic(fmt.Errorf("unexported field %s.%s cannot be encoded", typ, sf.Name))
+		}
+		f := &field{name: snakeCase(sf.Name), index: i}
+		ftyp := sf.Type
+		switch {
+		case ftyp.Kind() == reflect.Pointer:
+			f.pointer = true
+			ftyp = ftyp.Elem()
+		case ftyp.Kind() == reflect.Slice:
+			f.repeated = true
+			ftyp = ftyp.Elem()
+			if ftyp.Kind() == reflect.Pointer {
+				ftyp = ftyp.Elem()
+			}
+		}
+		f.value = s.valueType(ftyp, fmt.Sprintf("%s.%s", typ, sf.Name))
+		if f.repeated && f.value.kind == kindMap {
+			panic(fmt.Errorf("%s.%s: slices of maps are not supported", typ, sf.Name))
+		}
+		m.fields = append(m.fields, f)
+	}
+	s.assignNumbers(m)
+	return m
+}
+
+// assignNumbers assigns field numbers to m's fields, using the numbers
+// assigned by the checked-in schema. Fields which are new are assigned
+// the next unused number, and the numbers of fields which no longer
+// exist are reserved.
+func (s *schema) assignNumbers(m *message) {
+	previous, ok := s.numbers[m.name]
+	if !ok {
+		previous = &messageNumbers{}
+	}
+	var max protowire.Number
+	for _, number := range previous.fields {
+		if number > max {
+			max = number
+		}
+	}
+	for _, number := range previous.reserved {
+		if number > max {
+			max = number
+		}
+	}
+
+	current := make(map[string]bool, len(m.fields))
+	for _, f := range m.fields {
+		current[f.name] = true
+		if number, ok := previous.fields[f.name]; ok {
+			f.number = number
+			continue
+		}
+		max++
+		f.number = max
+	}
+	for _, f := range m.fields {
+		m.byNumber[f.number] = f
+	}
+
+	m.reserved = append(m.reserved, previous.reserved...)
+	m.reservedNames = append(m.reservedNames, previous.reservedNames...)
+	for name, number := range previous.fields {
+		if !current[name] {
+			m.reserved = append(m.reserved, number)
+			m.reservedNames = append(m.reservedNames, name)
+		}
+	}
+	sort.Slice(m.reserved, func(i, j int) bool { return m.reserved[i] < m.reserved[j] })
+	sort.Strings(m.reservedNames)
+}
+
+// parseFieldNumbers parses the field numbers and reserved fields of each
+// message from a schema previously generated by Proto.
+func parseFieldNumbers(proto string) map[string]*messageNumbers {
+	result := make(map[string]*messageNumbers)
+	var current *messageNumbers
+	for _, line := range strings.Split(proto, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "message "):
+			name := strings.TrimSuffix(strings.TrimPrefix(line, "message "), " {")
+			current = &messageNumbers{fields: make(map[string]protowire.Number)}
+			result[name] = current
+		case line == "}":
+			current = nil
+		case current == nil || line == "":
+		case strings.HasPrefix(line, "reserved "):
+			list := strings.TrimSuffix(strings.TrimPrefix(line, "reserved "), ";")
+			for _, item := range strings.Split(list, ", ") {
+				if name, err := strconv.Unquote(item); err == nil {
+					current.reservedNames = append(current.reservedNames, name)
+					continue
+				}
+				current.reserved = append(current.reserved, parseFieldNumber(item, line))
+			}
+		default:
+			// [label] type name = number;
+			eq := strings.LastIndex(line, " = ")
+			if eq < 0 {
+				panic(fmt.Errorf("invalid protobuf field declaration %q", line))
+			}
+			decl := line[:eq]
+			name := decl[strings.LastIndexByte(decl, ' ')+1:]
+			current.fields[name] = parseFieldNumber(strings.TrimSuffix(line[eq+3:], ";"), line)
+		}
+	}
+	return result
+}
+
+func parseFieldNumber(s, line string) protowire.Number {
+	n, err := strconv.Atoi(s)
+	if err != nil || !protowire.Number(n).IsValid() {
+		panic(fmt.Errorf("invalid protobuf field number in %q", line))
+	}
+	return protowire.Number(n)
+}
+
+// wrapperMessage returns a message wrapping a slice or map type, for use
+// as a map value type.
+func (s *schema) wrapperMessage(typ reflect.Type, context string) *message {
+	if m, ok := s.byType[typ]; ok {
+		return m
+	}
+	name := typ.Name()
+	if name == "" {
+		if typ.Kind() != reflect.Slice {
+			panic(fmt.Errorf("%s: unnamed map values of type %s are not supported", context, typ))
+		}
+		elemName := []rune(typ.Elem().Name())
+		elemName[0] = unicode.ToUpper(elemName[0])
+		name = string(elemName) + "List"
+	}
+	m := s.addMessage(name, typ)
+	m.wrapper = true
+	f := &field{name: "values", number: 1, index: -1}
+	elemType := typ
+	if typ.Kind() == reflect.Slice {
+		f.repeated = true
+		elemType = typ.Elem()
+	}
+	f.value = s.valueType(elemType, context)
+	m.fields = []*field{f}
+	m.byNumber[f.number] = f
+	return m
+}
+
+func (s *schema) valueType(typ reflect.Type, context string) *valueType {
+	v := &valueType{typ: typ}
+	switch {
+	case typ == timeType:
+		v.kind = kindTime
+	case typ == durationType:
+		v.kind = kindDuration
+	case typ == addrType:
+		v.kind = kindAddr
+	case typ == mapstrType || typ.Kind() == reflect.Interface:
+		v.kind = kindJSON
+	default:
+		switch typ.Kind() {
+		case reflect.Bool:
+			v.kind = kindBool
+		case reflect.Int, reflect.Int64:
+			v.kind = kindInt
+		case reflect.Float64:
+			v.kind = kindFloat
+		case reflect.String:
+			v.kind = kindString
+		case reflect.Struct:
+			v.kind = kindMessage
+			v.message = s.structMessage(typ)
+		case reflect.Map:
+			if typ.Key().Kind() != reflect.String {
+				panic(fmt.Errorf("%s: map keys of type %s are not supported", context, typ.Key()))
+			}
+			v.kind = kindMap
+			elem := typ.Elem()
+			if (elem.Kind() == reflect.Slice || elem.Kind() == reflect.Map) && elem != mapstrType {
+				// Protobuf map values cannot be repeated or maps,
+				// so they must be wrapped in a message.
+				v.elem = &valueType{kind: kindMessage, typ: elem, message: s.wrapperMessage(elem, context)}
+			} else {
+				v.elem = s.valueType(elem, context)
+			}
+		default:
+			panic(fmt.Errorf("%s: type %s is not supported", context, typ))
+		}
+	}
+	return v
+}
+
+// snakeCase converts a Go identifier to a protobuf field name,
+// e.g. "TraceID" to "trace_id", and "HTTP" to "http".
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var sb strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			if i > 0 && (unicode.IsLower(runes[i-1]) ||
+				(i+1 < len(runes) && unicode.IsLower(runes[i+1]))) {
+				sb.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Proto returns the protobuf schema for the wire representation of
+// model.APMEvent, and the Batch envelope used for encoding events.
+func Proto() []byte {
+	return apmEventSchema.proto()
+}
+
+func (s *schema) proto() []byte {
+	var sb strings.Builder
+	sb.WriteString("// Code generated by generate_schema.go. DO NOT EDIT.\n\n")
+	sb.WriteString("syntax = \"proto3\";\n\n")
+	fmt.Fprintf(&sb, "package %s;\n\n", protoPackage)
+	fmt.Fprintf(&sb, "// %s holds a batch of events, and the version of their encoding.\n", batchMessageName)
+	fmt.Fprintf(&sb, "message %s {\n", batchMessageName)
+	fmt.Fprintf(&sb, "  uint32 version = %d;\n", batchVersionField)
+	fmt.Fprintf(&sb, "  repeated %s events = %d;\n", s.root.name, batchEventsField)
+	sb.WriteString("}\n")
+
+	messages := make([]*message, len(s.messages))
+	copy(messages, s.messages)
+	sort.SliceStable(messages[1:], func(i, j int) bool {
+		return messages[i+1].name < messages[j+1].name
+	})
+	for _, m := range messages {
+		fmt.Fprintf(&sb, "\n// %s is derived from %s.\n", m.name, m.typ)
+		fmt.Fprintf(&sb, "message %s {\n", m.name)
+		for _, f := range m.fields {
+			var label string
+			switch {
+			case f.repeated:
+				label = "repeated "
+			case f.pointer && f.value.kind != kindMessage:
+				label = "optional "
+			}
+			fmt.Fprintf(&sb, "  %s%s %s = %d;", label, f.value.protoType(), f.name, f.number)
+			if comment := f.value.comment(); comment != "" {
+				fmt.Fprintf(&sb, " // %s", comment)
+			}
+			sb.WriteByte('\n')
+		}
+		if len(m.reserved) > 0 {
+			numbers := make([]string, len(m.reserved))
+			for i, number := range m.reserved {
+				numbers[i] = strconv.Itoa(int(number))
+			}
+			fmt.Fprintf(&sb, "  reserved %s;\n", strings.Join(numbers, ", "))
+		}
+		if len(m.reservedNames) > 0 {
+			names := make([]string, len(m.reservedNames))
+			for i, name := range m.reservedNames {
+				names[i] = strconv.Quote(name)
+			}
+			fmt.Fprintf(&sb, "  reserved %s;\n", strings.Join(names, ", "))
+		}
+		sb.WriteString("}\n")
+	}
+	return []byte(sb.String())
+}
+
+func (v *valueType) protoType() string {
+	switch v.kind {
+	case kindBool:
+		return "bool"
+	case kindInt, kindTime, kindDuration:
+		return "int64"
+	case kindFloat:
+		return "double"
+	case kindString:
+		return "string"
+	case kindAddr, kindJSON:
+		return "bytes"
+	case kindMessage:
+		return v.message.name
+	case kindMap:
+		return fmt.Sprintf("map<string, %s>", v.elem.protoType())
+	}
+	panic("unreachable")
+}
+
+func (v *valueType) comment() string {
+	switch v.kind {
+	case kindTime:
+		return "nanoseconds since the Unix epoch"
+	case kindDuration:
+		return "nanoseconds"
+	case kindAddr:
+		return "IP address, in netip.Addr binary form"
+	case kindJSON:
+		return "JSON-encoded"
+	case kindMap:
+		return v.elem.comment()
+	}
+	return ""
+}
@@ -0,0 +1,248 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// CostAccounting is a model.BatchProcessor that measures the wall time and
+// CPU time spent processing a sample of batches with a wrapped processor, attributing
+// the cost of each sampled batch evenly to its events, by service name and
+// event type.
+//
+// CPU time is measured for the processing goroutine's OS thread, and is only
+// available on Linux. Work that the processor performs in other goroutines, such
+// as asynchronous indexing, is not included, so CPU time is an approximation
+// suitable for comparing services and event types rather than an exact cost.
+type CostAccounting struct {
+	processor   model.BatchProcessor
+	sampleRate  float64
+	maxServices int
+
+	mu         sync.Mutex
+	costs      map[eventCostKey]*eventCost
+	services   map[string]struct{}
+	batches    int64
+	overflowed int64
+}
+
+type eventCostKey struct {
+	serviceName string
+	eventType   string
+}
+
+type eventCost struct {
+	events   int64
+	wallTime time.Duration
+	cpuTime  time.Duration
+}
+
+// EventCost holds the processing cost of the sampled events of a service
+// and event type.
+type EventCost struct {
+	ServiceName string
+	EventType   string
+
+	// Events holds the number of sampled events.
+	Events int64
+
+	// WallTime and CPUTime hold the total wall time and CPU time
+	// attributed to the sampled events.
+	WallTime time.Duration
+	CPUTime  time.Duration
+}
+
+// NewCostAccounting returns a CostAccounting which measures the cost of
+// processing sampleRate of the batches passed to processor, for at most
+// maxServices distinct service names. Events of additional services are
+// counted as overflowed.
+func NewCostAccounting(processor model.BatchProcessor, sampleRate float64, maxServices int) *CostAccounting {
+	return &CostAccounting{
+		processor:   processor,
+		sampleRate:  sampleRate,
+		maxServices: maxServices,
+		costs:       make(map[eventCostKey]*eventCost),
+		services:    make(map[string]struct{}),
+	}
+}
+
+// ProcessBatch processes b with the wrapped processor, measuring the cost
+// of processing if the batch is sampled.
+func (c *CostAccounting) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	if len(*b) == 0 || (c.sampleRate < 1 && rand.Float64() >= c.sampleRate) {
+		return c.processor.ProcessBatch(ctx, b)
+	}
+
+	// Count the events before processing, as the processor may
+	// modify or remove them. Keys are recorded in the order in
+	// which they first appear in the batch.
+	var keys []eventCostKey
+	counts := make(map[eventCostKey]int64)
+	for i := range *b {
+		event := &(*b)[i]
+		key := eventCostKey{
+			serviceName: event.Service.Name,
+			eventType:   event.Processor.Event,
+		}
+		if _, ok := counts[key]; !ok {
+			keys = append(keys, key)
+		}
+		counts[key]++
+	}
+	n := len(*b)
+
+	// Lock the goroutine to its thread, so the thread's CPU time
+	// reflects the time spent by this goroutine.
+	runtime.LockOSThread()
+	cpuStart, cpuOK := threadCPUTime()
+	start := time.Now()
+	err := c.processor.ProcessBatch(ctx, b)
+	wallTime := time.Since(start)
+	var cpuTime time.Duration
+	if cpuEnd, ok := threadCPUTime(); ok && cpuOK {
+		cpuTime = cpuEnd - cpuStart
+	}
+	runtime.UnlockOSThread()
+
+	c.record(keys, counts, n, wallTime, cpuTime)
+	return err
+}
+
+func (c *CostAccounting) record(keys []eventCostKey, counts map[eventCostKey]int64, n int, wallTime, cpuTime time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.batches++
+	for _, key := range keys {
+		count := counts[key]
+		cost, ok := c.costs[key]
+		if !ok {
+			if _, ok := c.services[key.serviceName]; !ok {
+				if len(c.services) >= c.maxServices {
+					c.overflowed += count
+					continue
+				}
+				c.services[key.serviceName] = struct{}{}
+			}
+			cost = &eventCost{}
+			c.costs[key] = cost
+		}
+		cost.events += count
+		cost.wallTime += wallTime * time.Duration(count) / time.Duration(n)
+		cost.cpuTime += cpuTime * time.Duration(count) / time.Duration(n)
+	}
+}
+
+// Costs returns the cumulative processing cost of the sampled events,
+// ordered by service name and event type, and the number of sampled
+// events which could not be recorded due to the maximum number of
+// services being reached.
+func (c *CostAccounting) Costs() (costs []EventCost, overflowed int64) {
+	c.mu.Lock()
+	costs = make([]EventCost, 0, len(c.costs))
+	for key, cost := range c.costs {
+		costs = append(costs, EventCost{
+			ServiceName: key.serviceName,
+			EventType:   key.eventType,
+			Events:      cost.events,
+			WallTime:    cost.wallTime,
+			CPUTime:     cost.cpuTime,
+		})
+	}
+	overflowed = c.overflowed
+	c.mu.Unlock()
+	sort.Slice(costs, func(i, j int) bool {
+		if costs[i].ServiceName != costs[j].ServiceName {
+			return costs[i].ServiceName < costs[j].ServiceName
+		}
+		return costs[i].EventType < costs[j].EventType
+	})
+	return costs, overflowed
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// The number of sampled batches and overflowed events are reported, along
+// with the number of sampled events, and their total wall time and CPU time
+// in microseconds, by event type and by service name and event type.
+func (c *CostAccounting) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	c.mu.Lock()
+	batches := c.batches
+	c.mu.Unlock()
+	costs, overflowed := c.Costs()
+	monitoring.ReportInt(V, "batches", batches)
+	monitoring.ReportInt(V, "overflowed", overflowed)
+
+	totals := make(map[string]*EventCost)
+	var eventTypes []string
+	for _, cost := range costs {
+		total, ok := totals[cost.EventType]
+		if !ok {
+			total = &EventCost{EventType: cost.EventType}
+			totals[cost.EventType] = total
+			eventTypes = append(eventTypes, cost.EventType)
+		}
+		total.Events += cost.Events
+		total.WallTime += cost.WallTime
+		total.CPUTime += cost.CPUTime
+	}
+	sort.Strings(eventTypes)
+	monitoring.ReportNamespace(V, "events", func() {
+		for _, eventType := range eventTypes {
+			reportEventCost(V, *totals[eventType])
+		}
+	})
+	monitoring.ReportNamespace(V, "services", func() {
+		for i := 0; i < len(costs); {
+			serviceName := costs[i].ServiceName
+			if serviceName == "" {
+				// Events without a service name are
+				// only included in the event totals.
+				for ; i < len(costs) && costs[i].ServiceName == ""; i++ {
+				}
+				continue
+			}
+			monitoring.ReportNamespace(V, serviceName, func() {
+				for ; i < len(costs) && costs[i].ServiceName == serviceName; i++ {
+					reportEventCost(V, costs[i])
+				}
+			})
+		}
+	})
+}
+
+func reportEventCost(V monitoring.Visitor, cost EventCost) {
+	monitoring.ReportNamespace(V, cost.EventType, func() {
+		monitoring.ReportInt(V, "sampled", cost.Events)
+		monitoring.ReportInt(V, "wall_time.us", cost.WallTime.Microseconds())
+		monitoring.ReportInt(V, "cpu_time.us", cost.CPUTime.Microseconds())
+	})
+}
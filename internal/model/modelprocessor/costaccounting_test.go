@@ -0,0 +1,95 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestCostAccounting(t *testing.T) {
+	var processed int
+	processor := model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
+		processed += len(*b)
+		*b = (*b)[:0] // costs are attributed to the events received
+		time.Sleep(20 * time.Millisecond)
+		return nil
+	})
+	costAccounting := NewCostAccounting(processor, 1, 1)
+
+	transaction := model.APMEvent{
+		Service:   model.Service{Name: "frontend"},
+		Processor: model.TransactionProcessor,
+	}
+	span := model.APMEvent{
+		Service:   model.Service{Name: "frontend"},
+		Processor: model.SpanProcessor,
+	}
+	other := model.APMEvent{
+		Service:   model.Service{Name: "backend"},
+		Processor: model.SpanProcessor,
+	}
+	batch := model.Batch{transaction, transaction, span, other}
+	require.NoError(t, costAccounting.ProcessBatch(context.Background(), &batch))
+	assert.Equal(t, 4, processed)
+
+	costs, overflowed := costAccounting.Costs()
+	assert.Equal(t, int64(1), overflowed)
+	require.Len(t, costs, 2)
+	assert.Equal(t, "frontend", costs[0].ServiceName)
+	assert.Equal(t, "span", costs[0].EventType)
+	assert.Equal(t, int64(1), costs[0].Events)
+	assert.Equal(t, "frontend", costs[1].ServiceName)
+	assert.Equal(t, "transaction", costs[1].EventType)
+	assert.Equal(t, int64(2), costs[1].Events)
+
+	// The wall time of the batch is attributed evenly to its events.
+	assert.GreaterOrEqual(t, costs[0].WallTime, 5*time.Millisecond)
+	assert.InDelta(t, 2*costs[0].WallTime, costs[1].WallTime, float64(time.Microsecond))
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "cost", costAccounting.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(1), snapshot.Ints["cost.batches"])
+	assert.Equal(t, int64(1), snapshot.Ints["cost.overflowed"])
+	assert.Equal(t, int64(1), snapshot.Ints["cost.events.span.sampled"])
+	assert.Equal(t, int64(2), snapshot.Ints["cost.events.transaction.sampled"])
+	assert.Equal(t, int64(2), snapshot.Ints["cost.services.frontend.transaction.sampled"])
+	assert.Equal(t, costs[1].WallTime.Microseconds(), snapshot.Ints["cost.services.frontend.transaction.wall_time.us"])
+	assert.Contains(t, snapshot.Ints, "cost.services.frontend.transaction.cpu_time.us")
+}
+
+func TestCostAccountingUnsampled(t *testing.T) {
+	costAccounting := NewCostAccounting(model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+		return nil
+	}), 0.0000001, 10)
+	batch := model.Batch{{Service: model.Service{Name: "frontend"}, Processor: model.SpanProcessor}}
+	for i := 0; i < 100; i++ {
+		require.NoError(t, costAccounting.ProcessBatch(context.Background(), &batch))
+	}
+	costs, _ := costAccounting.Costs()
+	assert.Empty(t, costs)
+}
@@ -42,12 +42,18 @@ const (
 // data streams.
 type SetDataStream struct {
 	Namespace string
+
+	// NamespaceFromEnvironment controls whether the data stream namespace
+	// is derived from service.environment. If true, events with a service
+	// environment are routed to a namespace matching the environment, and
+	// Namespace is used only for events without a service environment.
+	NamespaceFromEnvironment bool
 }
 
 // ProcessBatch sets data stream fields for each event in b.
 func (s *SetDataStream) ProcessBatch(ctx context.Context, b *model.Batch) error {
 	for i := range *b {
-		(*b)[i].DataStream.Namespace = s.Namespace
+		(*b)[i].DataStream.Namespace = s.namespace(&(*b)[i])
 		if (*b)[i].DataStream.Type == "" || (*b)[i].DataStream.Dataset == "" {
 			s.setDataStream(&(*b)[i])
 		}
@@ -55,6 +61,13 @@ func (s *SetDataStream) ProcessBatch(ctx context.Context, b *model.Batch) error
 	return nil
 }
 
+func (s *SetDataStream) namespace(event *model.APMEvent) string {
+	if s.NamespaceFromEnvironment && event.Service.Environment != "" {
+		return normalizeServiceName(event.Service.Environment)
+	}
+	return s.Namespace
+}
+
 func (s *SetDataStream) setDataStream(event *model.APMEvent) {
 	switch event.Processor {
 	case model.SpanProcessor, model.TransactionProcessor:
@@ -135,8 +148,8 @@ func metricsetDataset(event *model.APMEvent) string {
 	return dataset.String()
 }
 
-// normalizeServiceName translates serviceName into a string suitable
-// for inclusion in a data stream name.
+// normalizeServiceName translates serviceName, or service environment,
+// into a string suitable for inclusion in a data stream name.
 //
 // Concretely, this function will lowercase the string and replace any
 // reserved characters with "_".
@@ -178,3 +178,25 @@ func TestSetDataStreamServiceName(t *testing.T) {
 	assert.Equal(t, "apm.app.upper_case", batch[0].DataStream.Dataset)
 	assert.Equal(t, "apm.app.____________", batch[1].DataStream.Dataset)
 }
+
+func TestSetDataStreamNamespaceFromEnvironment(t *testing.T) {
+	for _, test := range []struct {
+		environment string
+		namespace   string
+	}{
+		{environment: "", namespace: "custom"},
+		{environment: "production", namespace: "production"},
+		{environment: "Pre-Prod EU", namespace: "pre_prod_eu"},
+	} {
+		batch := model.Batch{{
+			Processor: model.TransactionProcessor,
+			Service:   model.Service{Environment: test.environment},
+		}}
+		processor := modelprocessor.SetDataStream{Namespace: "custom", NamespaceFromEnvironment: true}
+		err := processor.ProcessBatch(context.Background(), &batch)
+		assert.NoError(t, err)
+		assert.Equal(t, model.DataStream{
+			Type: "traces", Dataset: "apm", Namespace: test.namespace,
+		}, batch[0].DataStream, test.environment)
+	}
+}
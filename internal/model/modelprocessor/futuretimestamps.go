@@ -0,0 +1,152 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// FutureTimestampAction defines how FutureTimestamps handles events
+// timestamped in the future.
+type FutureTimestampAction string
+
+const (
+	// FutureTimestampActionAccept accepts events timestamped in the
+	// future unchanged, only recording them.
+	FutureTimestampActionAccept FutureTimestampAction = "accept"
+
+	// FutureTimestampActionClamp sets the timestamp of events timestamped
+	// in the future to the current time.
+	FutureTimestampActionClamp FutureTimestampAction = "clamp"
+
+	// FutureTimestampActionDrop drops events timestamped in the future.
+	FutureTimestampActionDrop FutureTimestampAction = "drop"
+)
+
+// futureTimestampsMaxServices holds the maximum number of services for
+// which future-timestamped events are counted individually. Events of
+// further services are counted under futureTimestampsOtherService.
+const futureTimestampsMaxServices = 1000
+
+const futureTimestampsOtherService = "_other"
+
+// FutureTimestamps is a model.BatchProcessor that handles events which are
+// timestamped further in the future than a maximum skew, as such events
+// break the expectations of data stream rollover and dashboards.
+type FutureTimestamps struct {
+	action  FutureTimestampAction
+	maxSkew time.Duration
+	now     func() time.Time
+
+	mu       sync.Mutex
+	accepted int64
+	clamped  int64
+	dropped  int64
+	services map[string]int64
+}
+
+// NewFutureTimestamps returns a FutureTimestamps that handles events
+// timestamped more than maxSkew in the future according to action, which
+// defaults to FutureTimestampActionAccept.
+func NewFutureTimestamps(action FutureTimestampAction, maxSkew time.Duration) *FutureTimestamps {
+	if action == "" {
+		action = FutureTimestampActionAccept
+	}
+	return &FutureTimestamps{
+		action:   action,
+		maxSkew:  maxSkew,
+		now:      time.Now,
+		services: make(map[string]int64),
+	}
+}
+
+// ProcessBatch handles events in b which are timestamped in the future,
+// according to the configured action.
+func (p *FutureTimestamps) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	now := p.now()
+	limit := now.Add(p.maxSkew)
+	events := (*b)[:0]
+	for _, event := range *b {
+		if event.Timestamp.After(limit) {
+			if !p.handle(&event, now) {
+				continue
+			}
+		}
+		events = append(events, event)
+	}
+	*b = events
+	return nil
+}
+
+// handle records an event timestamped in the future, and handles it
+// according to the configured action. If the event should be dropped,
+// handle returns false.
+func (p *FutureTimestamps) handle(event *model.APMEvent, now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	service := event.Service.Name
+	if _, ok := p.services[service]; !ok && len(p.services) >= futureTimestampsMaxServices {
+		service = futureTimestampsOtherService
+	}
+	p.services[service]++
+	switch p.action {
+	case FutureTimestampActionDrop:
+		p.dropped++
+		return false
+	case FutureTimestampActionClamp:
+		p.clamped++
+		event.Timestamp = now
+	default:
+		p.accepted++
+	}
+	return true
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// The number of future-timestamped events which were accepted, clamped,
+// and dropped are reported, along with the number of such events of each
+// service under `services`.
+func (p *FutureTimestamps) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	monitoring.ReportInt(V, "accepted", p.accepted)
+	monitoring.ReportInt(V, "clamped", p.clamped)
+	monitoring.ReportInt(V, "dropped", p.dropped)
+	services := make([]string, 0, len(p.services))
+	for service := range p.services {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	monitoring.ReportNamespace(V, "services", func() {
+		for _, service := range services {
+			monitoring.ReportInt(V, service, p.services[service])
+		}
+	})
+}
@@ -0,0 +1,120 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestFutureTimestamps(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	withinSkew := now.Add(30 * time.Second)
+	future := now.Add(time.Hour)
+	newBatch := func() model.Batch {
+		return model.Batch{
+			{Service: model.Service{Name: "a"}, Timestamp: past},
+			{Service: model.Service{Name: "a"}, Timestamp: withinSkew},
+			{Service: model.Service{Name: "a"}, Timestamp: future},
+			{Service: model.Service{Name: "b"}, Timestamp: future},
+			{Service: model.Service{Name: "b"}, Timestamp: future},
+		}
+	}
+
+	for _, test := range []struct {
+		action  modelprocessor.FutureTimestampAction
+		check   func(t *testing.T, batch model.Batch)
+		metrics map[string]int64
+	}{{
+		action: modelprocessor.FutureTimestampActionAccept,
+		check: func(t *testing.T, batch model.Batch) {
+			assert.Equal(t, newBatch(), batch)
+		},
+		metrics: map[string]int64{"accepted": 3},
+	}, {
+		action: modelprocessor.FutureTimestampActionDrop,
+		check: func(t *testing.T, batch model.Batch) {
+			assert.Equal(t, newBatch()[:2], batch)
+		},
+		metrics: map[string]int64{"dropped": 3},
+	}, {
+		action: modelprocessor.FutureTimestampActionClamp,
+		check: func(t *testing.T, batch model.Batch) {
+			require.Len(t, batch, 5)
+			assert.Equal(t, past, batch[0].Timestamp)
+			assert.Equal(t, withinSkew, batch[1].Timestamp)
+			for _, event := range batch[2:] {
+				assert.WithinDuration(t, time.Now(), event.Timestamp, time.Minute)
+			}
+		},
+		metrics: map[string]int64{"clamped": 3},
+	}} {
+		t.Run(string(test.action), func(t *testing.T) {
+			processor := modelprocessor.NewFutureTimestamps(test.action, time.Minute)
+			batch := newBatch()
+			require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+			test.check(t, batch)
+
+			registry := monitoring.NewRegistry()
+			monitoring.NewFunc(registry, "future_timestamps", processor.CollectMonitoring)
+			snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+			expected := map[string]int64{
+				"future_timestamps.accepted":   0,
+				"future_timestamps.clamped":    0,
+				"future_timestamps.dropped":    0,
+				"future_timestamps.services.a": 1,
+				"future_timestamps.services.b": 2,
+			}
+			for k, v := range test.metrics {
+				expected["future_timestamps."+k] = v
+			}
+			assert.Equal(t, expected, snapshot.Ints)
+		})
+	}
+}
+
+func TestFutureTimestampsMaxServices(t *testing.T) {
+	processor := modelprocessor.NewFutureTimestamps(modelprocessor.FutureTimestampActionAccept, 0)
+	future := time.Now().Add(time.Hour)
+	var batch model.Batch
+	for i := 0; i < 1002; i++ {
+		batch = append(batch, model.APMEvent{
+			Service:   model.Service{Name: fmt.Sprintf("service%d", i)},
+			Timestamp: future,
+		})
+	}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "future_timestamps", processor.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(1002), snapshot.Ints["future_timestamps.accepted"])
+	assert.Equal(t, int64(2), snapshot.Ints["future_timestamps.services._other"])
+	assert.Len(t, snapshot.Ints, 3+1001)
+}
@@ -0,0 +1,59 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// SetGlobalLabels is a model.BatchProcessor that sets server-defined
+// global labels on events.
+//
+// Labels with keys already set on an event, of any type, are not
+// overridden. The labels are marked as global, so they are retained
+// in aggregated metrics.
+type SetGlobalLabels struct {
+	// Labels holds the labels to set on events.
+	Labels map[string]string
+}
+
+// ProcessBatch sets the configured labels on events which do not already
+// have labels with the same keys.
+func (s *SetGlobalLabels) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	for i := range *b {
+		event := &(*b)[i]
+		for k, v := range s.Labels {
+			if _, ok := event.Labels[k]; ok {
+				continue
+			}
+			if _, ok := event.NumericLabels[k]; ok {
+				continue
+			}
+			if _, ok := event.BooleanLabels[k]; ok {
+				continue
+			}
+			if event.Labels == nil {
+				event.Labels = make(model.Labels)
+			}
+			event.Labels[k] = model.LabelValue{Value: v, Global: true}
+		}
+	}
+	return nil
+}
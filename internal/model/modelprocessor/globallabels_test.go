@@ -0,0 +1,53 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"testing"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestSetGlobalLabels(t *testing.T) {
+	processor := modelprocessor.SetGlobalLabels{
+		Labels: map[string]string{
+			"cluster": "eu-1",
+			"region":  "europe-west1",
+			"zone":    "a",
+		},
+	}
+	testProcessBatch(t, &processor, model.APMEvent{}, model.APMEvent{
+		Labels: model.Labels{
+			"cluster": {Value: "eu-1", Global: true},
+			"region":  {Value: "europe-west1", Global: true},
+			"zone":    {Value: "a", Global: true},
+		},
+	})
+
+	// Agent-provided labels are not overridden, regardless of type.
+	testProcessBatch(t, &processor, model.APMEvent{
+		Labels:        model.Labels{"cluster": {Value: "agent"}},
+		NumericLabels: model.NumericLabels{"region": {Value: 1}},
+		BooleanLabels: model.BooleanLabels{"zone": {Value: true}},
+	}, model.APMEvent{
+		Labels:        model.Labels{"cluster": {Value: "agent"}},
+		NumericLabels: model.NumericLabels{"region": {Value: 1}},
+		BooleanLabels: model.BooleanLabels{"zone": {Value: true}},
+	})
+}
@@ -97,6 +97,32 @@ func isInternalMetricName(name string) bool {
 		return true
 	case "jvm.thread.count":
 		return true
+	case "mobile.anr.count":
+		return true
+	case "mobile.app_launch.count":
+		return true
+	case "mobile.app_launch.time.avg":
+		return true
+	case "mobile.app_launch.time.max":
+		return true
+	case "mobile.crashes.count":
+		return true
+	case "mobile.sessions.anr":
+		return true
+	case "mobile.sessions.anr_ratio":
+		return true
+	case "mobile.sessions.count":
+		return true
+	case "mobile.sessions.crash_free_ratio":
+		return true
+	case "mobile.sessions.crashed":
+		return true
+	case "mobile.users.count":
+		return true
+	case "mobile.users.crash_free_ratio":
+		return true
+	case "mobile.users.crashed":
+		return true
 	case "nodejs.eventloop.delay.avg.ms":
 		return true
 	case "nodejs.handles.active":
@@ -0,0 +1,221 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// maxServiceInventoryEntries limits the number of distinct service, agent,
+// and environment combinations tracked by ServiceInventory, protecting
+// against unbounded memory usage due to arbitrary service metadata.
+const maxServiceInventoryEntries = 1000
+
+// ServiceInventory is a model.BatchProcessor that maintains an in-memory
+// inventory of the services which sent events within a sliding window,
+// dimensioned by service name and environment, and agent name and version.
+type ServiceInventory struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu         sync.Mutex
+	services   map[serviceInventoryKey]*serviceInventoryEntry
+	overflowed int64
+}
+
+type serviceInventoryKey struct {
+	serviceName        string
+	serviceEnvironment string
+	agentName          string
+	agentVersion       string
+}
+
+type serviceInventoryEntry struct {
+	firstSeen time.Time
+	lastSeen  time.Time
+
+	// minutes and counts hold a ring of per-minute event counts
+	// covering the window, used for calculating event rates.
+	minutes []int64
+	counts  []int64
+}
+
+// ServiceInventoryEntry holds details of a service in the inventory.
+type ServiceInventoryEntry struct {
+	ServiceName        string    `json:"service_name"`
+	ServiceEnvironment string    `json:"service_environment,omitempty"`
+	AgentName          string    `json:"agent_name,omitempty"`
+	AgentVersion       string    `json:"agent_version,omitempty"`
+	FirstSeen          time.Time `json:"first_seen"`
+	LastSeen           time.Time `json:"last_seen"`
+
+	// Events holds the number of events received within the window.
+	Events int64 `json:"events"`
+
+	// EventsPerMinute holds the average number of events received per
+	// minute within the window.
+	EventsPerMinute float64 `json:"events_per_minute"`
+}
+
+// NewServiceInventory returns a ServiceInventory that tracks services which
+// sent events within the given window, rounded up to a whole minute.
+func NewServiceInventory(window time.Duration) *ServiceInventory {
+	if window < time.Minute {
+		window = time.Minute
+	}
+	if rem := window % time.Minute; rem != 0 {
+		window += time.Minute - rem
+	}
+	return &ServiceInventory{
+		window:   window,
+		now:      time.Now,
+		services: make(map[serviceInventoryKey]*serviceInventoryEntry),
+	}
+}
+
+// ProcessBatch records the services of events in b in the inventory.
+// Events without a service name are ignored.
+func (s *ServiceInventory) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	now := s.now()
+	minute := now.Unix() / 60
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range *b {
+		event := &(*b)[i]
+		if event.Service.Name == "" {
+			continue
+		}
+		key := serviceInventoryKey{
+			serviceName:        event.Service.Name,
+			serviceEnvironment: event.Service.Environment,
+			agentName:          event.Agent.Name,
+			agentVersion:       event.Agent.Version,
+		}
+		entry, ok := s.services[key]
+		if !ok {
+			if len(s.services) >= maxServiceInventoryEntries {
+				s.expire(now)
+			}
+			if len(s.services) >= maxServiceInventoryEntries {
+				s.overflowed++
+				continue
+			}
+			n := s.windowMinutes()
+			entry = &serviceInventoryEntry{
+				firstSeen: now,
+				minutes:   make([]int64, n),
+				counts:    make([]int64, n),
+			}
+			s.services[key] = entry
+		}
+		entry.lastSeen = now
+		bucket := minute % int64(len(entry.minutes))
+		if entry.minutes[bucket] != minute {
+			entry.minutes[bucket] = minute
+			entry.counts[bucket] = 0
+		}
+		entry.counts[bucket]++
+	}
+	return nil
+}
+
+// Services returns the services which sent events within the window,
+// ordered by service name, environment, agent name, and agent version,
+// and the number of events which could not be recorded due to the
+// maximum number of entries being reached.
+func (s *ServiceInventory) Services() (services []ServiceInventoryEntry, overflowed int64) {
+	now := s.now()
+	minute := now.Unix() / 60
+	s.mu.Lock()
+	s.expire(now)
+	services = make([]ServiceInventoryEntry, 0, len(s.services))
+	for key, entry := range s.services {
+		var events int64
+		for i, m := range entry.minutes {
+			if m > minute-int64(len(entry.minutes)) {
+				events += entry.counts[i]
+			}
+		}
+		services = append(services, ServiceInventoryEntry{
+			ServiceName:        key.serviceName,
+			ServiceEnvironment: key.serviceEnvironment,
+			AgentName:          key.agentName,
+			AgentVersion:       key.agentVersion,
+			FirstSeen:          entry.firstSeen,
+			LastSeen:           entry.lastSeen,
+			Events:             events,
+			EventsPerMinute:    float64(events) / float64(len(entry.minutes)),
+		})
+	}
+	overflowed = s.overflowed
+	s.mu.Unlock()
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].ServiceName != services[j].ServiceName {
+			return services[i].ServiceName < services[j].ServiceName
+		}
+		if services[i].ServiceEnvironment != services[j].ServiceEnvironment {
+			return services[i].ServiceEnvironment < services[j].ServiceEnvironment
+		}
+		if services[i].AgentName != services[j].AgentName {
+			return services[i].AgentName < services[j].AgentName
+		}
+		return services[i].AgentVersion < services[j].AgentVersion
+	})
+	return services, overflowed
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// The number of services in the inventory is reported along with the number
+// of events they sent within the window, and the number of events which could
+// not be recorded.
+func (s *ServiceInventory) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	services, overflowed := s.Services()
+	var events int64
+	for _, service := range services {
+		events += service.Events
+	}
+	monitoring.ReportInt(V, "services", int64(len(services)))
+	monitoring.ReportInt(V, "events", events)
+	monitoring.ReportInt(V, "overflowed", overflowed)
+}
+
+// expire removes services which have not sent events within the window.
+// This must be called with s.mu held.
+func (s *ServiceInventory) expire(now time.Time) {
+	for key, entry := range s.services {
+		if now.Sub(entry.lastSeen) > s.window {
+			delete(s.services, key)
+		}
+	}
+}
+
+func (s *ServiceInventory) windowMinutes() int {
+	return int(s.window / time.Minute)
+}
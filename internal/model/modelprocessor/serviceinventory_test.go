@@ -0,0 +1,106 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestServiceInventory(t *testing.T) {
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	inventory := NewServiceInventory(90 * time.Second)
+	inventory.now = func() time.Time { return now }
+
+	goEvent := model.APMEvent{
+		Service: model.Service{Name: "frontend", Environment: "production"},
+		Agent:   model.Agent{Name: "go", Version: "2.0.0"},
+	}
+	javaEvent := model.APMEvent{
+		Service: model.Service{Name: "backend"},
+		Agent:   model.Agent{Name: "java", Version: "1.30.0"},
+	}
+	process := func(events ...model.APMEvent) {
+		batch := model.Batch(events)
+		require.NoError(t, inventory.ProcessBatch(context.Background(), &batch))
+	}
+
+	process(goEvent, javaEvent, model.APMEvent{}) // events without a service are ignored
+	now = now.Add(time.Minute)
+	process(goEvent, goEvent)
+	now = now.Add(time.Minute)
+	process(goEvent)
+
+	// The window is rounded up to 2 minutes, so the events received
+	// in the first minute are no longer counted.
+	services, overflowed := inventory.Services()
+	assert.Zero(t, overflowed)
+	assert.Equal(t, []ServiceInventoryEntry{{
+		ServiceName:  "backend",
+		AgentName:    "java",
+		AgentVersion: "1.30.0",
+		FirstSeen:    now.Add(-2 * time.Minute),
+		LastSeen:     now.Add(-2 * time.Minute),
+	}, {
+		ServiceName:        "frontend",
+		ServiceEnvironment: "production",
+		AgentName:          "go",
+		AgentVersion:       "2.0.0",
+		FirstSeen:          now.Add(-2 * time.Minute),
+		LastSeen:           now,
+		Events:             3,
+		EventsPerMinute:    1.5,
+	}}, services)
+
+	// Services which have not sent events within the window are removed.
+	now = now.Add(time.Minute)
+	services, _ = inventory.Services()
+	require.Len(t, services, 1)
+	assert.Equal(t, "frontend", services[0].ServiceName)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "service_inventory", inventory.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"service_inventory.services":   1,
+		"service_inventory.events":     1,
+		"service_inventory.overflowed": 0,
+	}, snapshot.Ints)
+}
+
+func TestServiceInventoryOverflow(t *testing.T) {
+	inventory := NewServiceInventory(time.Minute)
+	batch := make(model.Batch, maxServiceInventoryEntries+1)
+	for i := range batch {
+		batch[i].Service.Name = fmt.Sprintf("service_%d", i)
+	}
+	require.NoError(t, inventory.ProcessBatch(context.Background(), &batch))
+
+	services, overflowed := inventory.Services()
+	assert.Len(t, services, maxServiceInventoryEntries)
+	assert.Equal(t, int64(1), overflowed)
+}
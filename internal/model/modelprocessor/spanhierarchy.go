@@ -0,0 +1,261 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// SpanHierarchyAction defines how SpanHierarchyValidator handles spans
+// which violate the span hierarchy.
+type SpanHierarchyAction string
+
+const (
+	// SpanHierarchyActionFix repairs invalid spans: orphaned spans are
+	// re-parented to their transaction, spans are clamped to the bounds
+	// of their parent, and invalid composite values are corrected.
+	SpanHierarchyActionFix SpanHierarchyAction = "fix"
+
+	// SpanHierarchyActionFlag records the violations of invalid spans in
+	// the `span_hierarchy_violations` label, leaving the spans unchanged.
+	SpanHierarchyActionFlag SpanHierarchyAction = "flag"
+
+	// SpanHierarchyActionDrop drops invalid spans.
+	SpanHierarchyActionDrop SpanHierarchyAction = "drop"
+)
+
+// SpanHierarchyViolationsLabel holds the label key used for recording
+// span hierarchy violations with SpanHierarchyActionFlag.
+const SpanHierarchyViolationsLabel = "span_hierarchy_violations"
+
+// spanHierarchyTolerance holds the amount of time by which a span may
+// exceed the bounds of its parent before it is considered invalid, to
+// allow for rounding of timestamps and durations by agents.
+const spanHierarchyTolerance = time.Millisecond
+
+const (
+	violationOrphaned         = "orphaned"
+	violationOutOfBounds      = "out_of_bounds"
+	violationInvalidComposite = "invalid_composite"
+)
+
+// SpanHierarchyValidator is a model.BatchProcessor that validates the
+// parent/child relationships of spans, to keep trace waterfalls coherent
+// when agents report inconsistent spans.
+//
+// Spans are validated against the transactions and spans in the same
+// batch, as agents report a transaction's spans along with it or shortly
+// before it. A span is considered:
+//
+//   - orphaned, if its parent is not in the batch, but its transaction is;
+//   - out of bounds, if it starts before its parent, or if it is synchronous
+//     and ends after its parent. Asynchronous spans may outlive their parent;
+//   - an invalid composite, if it is a composite span with a count less than
+//     two, or with a sum of durations that is negative or exceeds its duration.
+type SpanHierarchyValidator struct {
+	action SpanHierarchyAction
+
+	orphaned          *monitoring.Int
+	outOfBounds       *monitoring.Int
+	invalidComposites *monitoring.Int
+	fixed             *monitoring.Int
+	flagged           *monitoring.Int
+	dropped           *monitoring.Int
+}
+
+// NewSpanHierarchyValidator returns a SpanHierarchyValidator that handles
+// invalid spans according to action, which defaults to SpanHierarchyActionFix.
+//
+// Violations are recorded as `processor.span_hierarchy.<violation>` metrics
+// under the given registry, along with the number of spans `fixed`, `flagged`,
+// and `dropped`.
+func NewSpanHierarchyValidator(action SpanHierarchyAction, registry *monitoring.Registry) *SpanHierarchyValidator {
+	if action == "" {
+		action = SpanHierarchyActionFix
+	}
+	counter := func(name string) *monitoring.Int {
+		// Metrics may exist in the registry if a validator was
+		// previously created, e.g. when the server is reloaded.
+		name = "processor.span_hierarchy." + name
+		if c, ok := registry.Get(name).(*monitoring.Int); ok {
+			return c
+		}
+		return monitoring.NewInt(registry, name)
+	}
+	return &SpanHierarchyValidator{
+		action:            action,
+		orphaned:          counter(violationOrphaned),
+		outOfBounds:       counter(violationOutOfBounds),
+		invalidComposites: counter(violationInvalidComposite),
+		fixed:             counter("fixed"),
+		flagged:           counter("flagged"),
+		dropped:           counter("dropped"),
+	}
+}
+
+type spanHierarchyKey struct {
+	traceID string
+	id      string
+}
+
+type spanHierarchyBounds struct {
+	start, end time.Time
+}
+
+// ProcessBatch validates the span hierarchy of spans in b, handling
+// invalid spans according to the configured action.
+func (v *SpanHierarchyValidator) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	// Record the bounds of all potential parents before modifying
+	// any spans, so the outcome does not depend on the event order.
+	parents := make(map[spanHierarchyKey]spanHierarchyBounds)
+	transactions := make(map[spanHierarchyKey]struct{})
+	for i := range *b {
+		event := &(*b)[i]
+		var id string
+		switch event.Processor {
+		case model.TransactionProcessor:
+			if event.Transaction == nil {
+				continue
+			}
+			id = event.Transaction.ID
+			transactions[spanHierarchyKey{event.Trace.ID, id}] = struct{}{}
+		case model.SpanProcessor:
+			if event.Span == nil {
+				continue
+			}
+			id = event.Span.ID
+		default:
+			continue
+		}
+		if id != "" {
+			parents[spanHierarchyKey{event.Trace.ID, id}] = spanHierarchyBounds{
+				start: event.Timestamp,
+				end:   event.Timestamp.Add(event.Event.Duration),
+			}
+		}
+	}
+
+	events := (*b)[:0]
+	for _, event := range *b {
+		if event.Processor == model.SpanProcessor && event.Span != nil {
+			if !v.processSpan(&event, parents, transactions) {
+				continue
+			}
+		}
+		events = append(events, event)
+	}
+	*b = events
+	return nil
+}
+
+// processSpan validates a span event, and handles any violations. If the
+// span should be dropped, processSpan returns false.
+func (v *SpanHierarchyValidator) processSpan(
+	event *model.APMEvent,
+	parents map[spanHierarchyKey]spanHierarchyBounds,
+	transactions map[spanHierarchyKey]struct{},
+) bool {
+	var violations []string
+	fix := v.action == SpanHierarchyActionFix
+
+	if composite := event.Span.Composite; composite != nil {
+		sum := time.Duration(composite.Sum * float64(time.Millisecond))
+		if composite.Count < 2 || sum < 0 || sum > event.Event.Duration+spanHierarchyTolerance {
+			violations = append(violations, violationInvalidComposite)
+			v.invalidComposites.Inc()
+			if fix {
+				if composite.Count < 2 {
+					event.Span.Composite = nil
+				} else {
+					composite.Sum = clampSum(composite.Sum, event.Event.Duration)
+				}
+			}
+		}
+	}
+
+	parent, found := parents[spanHierarchyKey{event.Trace.ID, event.Parent.ID}]
+	if !found && event.Transaction != nil && event.Transaction.ID != "" {
+		transactionKey := spanHierarchyKey{event.Trace.ID, event.Transaction.ID}
+		if _, ok := transactions[transactionKey]; ok {
+			violations = append(violations, violationOrphaned)
+			v.orphaned.Inc()
+			parent, found = parents[transactionKey]
+			if fix {
+				event.Parent.ID = event.Transaction.ID
+			}
+		}
+	}
+	if found {
+		start := event.Timestamp
+		end := start.Add(event.Event.Duration)
+		async := event.Span.Sync != nil && !*event.Span.Sync
+		startsEarly := start.Before(parent.start.Add(-spanHierarchyTolerance))
+		endsLate := !async && end.After(parent.end.Add(spanHierarchyTolerance))
+		if startsEarly || endsLate {
+			violations = append(violations, violationOutOfBounds)
+			v.outOfBounds.Inc()
+			if fix {
+				if startsEarly {
+					start = parent.start
+				}
+				if endsLate {
+					end = parent.end
+				}
+				if end.Before(start) {
+					end = start
+				}
+				event.Timestamp = start
+				event.Event.Duration = end.Sub(start)
+			}
+		}
+	}
+
+	if len(violations) == 0 {
+		return true
+	}
+	switch v.action {
+	case SpanHierarchyActionDrop:
+		v.dropped.Inc()
+		return false
+	case SpanHierarchyActionFlag:
+		v.flagged.Inc()
+		if event.Labels == nil {
+			event.Labels = make(model.Labels)
+		}
+		event.Labels.SetSlice(SpanHierarchyViolationsLabel, violations)
+	default:
+		v.fixed.Inc()
+	}
+	return true
+}
+
+// clampSum returns sum, in milliseconds, clamped to the range [0, duration].
+func clampSum(sum float64, duration time.Duration) float64 {
+	if sum < 0 {
+		return 0
+	}
+	if max := float64(duration) / float64(time.Millisecond); sum > max {
+		return max
+	}
+	return sum
+}
@@ -0,0 +1,186 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestSpanHierarchyValidator(t *testing.T) {
+	now := time.Now()
+	newTransaction := func() model.APMEvent {
+		return model.APMEvent{
+			Processor:   model.TransactionProcessor,
+			Timestamp:   now,
+			Trace:       model.Trace{ID: "trace"},
+			Event:       model.Event{Duration: 100 * time.Millisecond},
+			Transaction: &model.Transaction{ID: "tx"},
+		}
+	}
+	newSpan := func(id, parentID string, start, duration time.Duration) model.APMEvent {
+		return model.APMEvent{
+			Processor:   model.SpanProcessor,
+			Timestamp:   now.Add(start),
+			Trace:       model.Trace{ID: "trace"},
+			Parent:      model.Parent{ID: parentID},
+			Event:       model.Event{Duration: duration},
+			Transaction: &model.Transaction{ID: "tx"},
+			Span:        &model.Span{ID: id},
+		}
+	}
+	async := false
+
+	type test struct {
+		name       string
+		span       model.APMEvent
+		fixed      model.APMEvent
+		violations []string
+	}
+	var tests []test
+	for _, valid := range []model.APMEvent{
+		newSpan("valid", "tx", 10*time.Millisecond, 50*time.Millisecond),
+		newSpan("valid_nested", "parent", 20*time.Millisecond, 10*time.Millisecond),
+		// Rounding within the tolerance is not a violation.
+		newSpan("valid_rounded", "tx", -500*time.Microsecond, 100*time.Millisecond),
+		// Spans without their transaction in the batch cannot be validated.
+		func() model.APMEvent {
+			span := newSpan("other_transaction", "unknown", 0, time.Second)
+			span.Transaction.ID = "other"
+			return span
+		}(),
+		// Asynchronous spans may outlive their parent.
+		func() model.APMEvent {
+			span := newSpan("async", "tx", 50*time.Millisecond, time.Second)
+			span.Span.Sync = &async
+			return span
+		}(),
+	} {
+		tests = append(tests, test{name: valid.Span.ID, span: valid, fixed: valid})
+	}
+
+	orphaned := newSpan("orphaned", "unknown", 10*time.Millisecond, 10*time.Millisecond)
+	fixedOrphaned := newSpan("orphaned", "tx", 10*time.Millisecond, 10*time.Millisecond)
+	tests = append(tests, test{
+		name: "orphaned", span: orphaned, fixed: fixedOrphaned,
+		violations: []string{"orphaned"},
+	})
+
+	tests = append(tests, test{
+		name:       "starts_early",
+		span:       newSpan("starts_early", "tx", -10*time.Millisecond, 50*time.Millisecond),
+		fixed:      newSpan("starts_early", "tx", 0, 40*time.Millisecond),
+		violations: []string{"out_of_bounds"},
+	}, test{
+		name:       "ends_late",
+		span:       newSpan("ends_late", "parent", 20*time.Millisecond, 50*time.Millisecond),
+		fixed:      newSpan("ends_late", "parent", 20*time.Millisecond, 40*time.Millisecond),
+		violations: []string{"out_of_bounds"},
+	})
+
+	invalidComposite := newSpan("invalid_composite", "tx", 0, 10*time.Millisecond)
+	invalidComposite.Span.Composite = &model.Composite{Count: 2, Sum: 20}
+	fixedComposite := newSpan("invalid_composite", "tx", 0, 10*time.Millisecond)
+	fixedComposite.Span.Composite = &model.Composite{Count: 2, Sum: 10}
+	singleComposite := newSpan("single_composite", "tx", 0, 10*time.Millisecond)
+	singleComposite.Span.Composite = &model.Composite{Count: 1, Sum: 10}
+	tests = append(tests, test{
+		name: "invalid_composite", span: invalidComposite, fixed: fixedComposite,
+		violations: []string{"invalid_composite"},
+	}, test{
+		name: "single_composite", span: singleComposite, fixed: newSpan("single_composite", "tx", 0, 10*time.Millisecond),
+		violations: []string{"invalid_composite"},
+	})
+
+	parent := newSpan("parent", "tx", 10*time.Millisecond, 50*time.Millisecond)
+	for _, test := range tests {
+		for _, action := range []modelprocessor.SpanHierarchyAction{
+			modelprocessor.SpanHierarchyActionFix,
+			modelprocessor.SpanHierarchyActionFlag,
+			modelprocessor.SpanHierarchyActionDrop,
+		} {
+			t.Run(test.name+"_"+string(action), func(t *testing.T) {
+				span := test.span
+				spanCopy := *span.Span
+				span.Span = &spanCopy
+				if span.Span.Composite != nil {
+					compositeCopy := *span.Span.Composite
+					span.Span.Composite = &compositeCopy
+				}
+				batch := model.Batch{newTransaction(), parent, span}
+				processor := modelprocessor.NewSpanHierarchyValidator(action, monitoring.NewRegistry())
+				require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+
+				expected := model.Batch{newTransaction(), parent}
+				switch {
+				case len(test.violations) == 0:
+					expected = append(expected, test.span)
+				case action == modelprocessor.SpanHierarchyActionFix:
+					expected = append(expected, test.fixed)
+				case action == modelprocessor.SpanHierarchyActionFlag:
+					flagged := test.span
+					flagged.Labels = model.Labels{}
+					flagged.Labels.SetSlice(modelprocessor.SpanHierarchyViolationsLabel, test.violations)
+					expected = append(expected, flagged)
+				}
+				assert.Equal(t, expected, batch)
+			})
+		}
+	}
+}
+
+func TestSpanHierarchyValidatorMetrics(t *testing.T) {
+	registry := monitoring.NewRegistry()
+	processor := modelprocessor.NewSpanHierarchyValidator(modelprocessor.SpanHierarchyActionDrop, registry)
+
+	batch := model.Batch{{
+		Processor:   model.TransactionProcessor,
+		Trace:       model.Trace{ID: "trace"},
+		Transaction: &model.Transaction{ID: "tx"},
+	}, {
+		Processor:   model.SpanProcessor,
+		Trace:       model.Trace{ID: "trace"},
+		Parent:      model.Parent{ID: "unknown"},
+		Transaction: &model.Transaction{ID: "tx"},
+		Span:        &model.Span{ID: "orphaned"},
+	}}
+	require.NoError(t, processor.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 1)
+
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"processor.span_hierarchy.orphaned":          1,
+		"processor.span_hierarchy.out_of_bounds":     0,
+		"processor.span_hierarchy.invalid_composite": 0,
+		"processor.span_hierarchy.fixed":             0,
+		"processor.span_hierarchy.flagged":           0,
+		"processor.span_hierarchy.dropped":           1,
+	}, snapshot.Ints)
+
+	// Creating another validator with the same registry reuses the metrics.
+	modelprocessor.NewSpanHierarchyValidator(modelprocessor.SpanHierarchyActionDrop, registry)
+}
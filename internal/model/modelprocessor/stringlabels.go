@@ -0,0 +1,97 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// StringLabels is a model.BatchProcessor that converts numeric and boolean
+// labels with specific keys to string labels.
+//
+// This may be used to retain the keyword mapping of labels which were
+// previously indexed as strings, e.g. boolean labels prior to their
+// types being preserved.
+type StringLabels struct {
+	keys map[string]struct{}
+}
+
+// NewStringLabels returns a StringLabels that converts numeric and boolean
+// labels with the given keys to string labels.
+func NewStringLabels(keys []string) *StringLabels {
+	m := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		m[k] = struct{}{}
+	}
+	return &StringLabels{keys: m}
+}
+
+// ProcessBatch converts numeric and boolean labels with the configured keys
+// to string labels.
+func (s *StringLabels) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	for i := range *b {
+		event := &(*b)[i]
+		for k, v := range event.NumericLabels {
+			if _, ok := s.keys[k]; !ok {
+				continue
+			}
+			label := model.LabelValue{Global: v.Global}
+			if len(v.Values) > 0 {
+				label.Values = make([]string, len(v.Values))
+				for j, value := range v.Values {
+					label.Values[j] = formatFloat(value)
+				}
+			} else {
+				label.Value = formatFloat(v.Value)
+			}
+			setStringLabel(event, k, label)
+			delete(event.NumericLabels, k)
+		}
+		for k, v := range event.BooleanLabels {
+			if _, ok := s.keys[k]; !ok {
+				continue
+			}
+			label := model.LabelValue{Global: v.Global}
+			if len(v.Values) > 0 {
+				label.Values = make([]string, len(v.Values))
+				for j, value := range v.Values {
+					label.Values[j] = strconv.FormatBool(value)
+				}
+			} else {
+				label.Value = strconv.FormatBool(v.Value)
+			}
+			setStringLabel(event, k, label)
+			delete(event.BooleanLabels, k)
+		}
+	}
+	return nil
+}
+
+func setStringLabel(event *model.APMEvent, k string, v model.LabelValue) {
+	if event.Labels == nil {
+		event.Labels = make(model.Labels)
+	}
+	event.Labels[k] = v
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
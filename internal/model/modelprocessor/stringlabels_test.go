@@ -0,0 +1,64 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"testing"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestStringLabels(t *testing.T) {
+	processor := modelprocessor.NewStringLabels([]string{"build", "flag", "flags", "missing"})
+	testProcessBatch(t, processor, model.APMEvent{
+		Labels: model.Labels{
+			"a": {Value: "b"},
+		},
+		NumericLabels: model.NumericLabels{
+			"build": {Value: 1234, Global: true},
+			"count": {Value: 1.5},
+		},
+		BooleanLabels: model.BooleanLabels{
+			"flag":    {Value: true},
+			"flags":   {Values: []bool{true, false}},
+			"enabled": {Value: false},
+		},
+	}, model.APMEvent{
+		Labels: model.Labels{
+			"a":     {Value: "b"},
+			"build": {Value: "1234", Global: true},
+			"flag":  {Value: "true"},
+			"flags": {Values: []string{"true", "false"}},
+		},
+		NumericLabels: model.NumericLabels{
+			"count": {Value: 1.5},
+		},
+		BooleanLabels: model.BooleanLabels{
+			"enabled": {Value: false},
+		},
+	})
+
+	// Labels are created if the event has no string labels.
+	testProcessBatch(t, processor, model.APMEvent{
+		NumericLabels: model.NumericLabels{"build": {Values: []float64{1, 2.5}}},
+	}, model.APMEvent{
+		Labels:        model.Labels{"build": {Values: []string{"1", "2.5"}}},
+		NumericLabels: model.NumericLabels{},
+	})
+}
@@ -0,0 +1,47 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// Traced is a model.BatchProcessor that wraps another model.BatchProcessor,
+// recording a span for each call to ProcessBatch if the context holds a
+// sampled transaction.
+type Traced struct {
+	// Processor holds the model.BatchProcessor to wrap.
+	Processor model.BatchProcessor
+
+	// Name holds the name of the recorded spans.
+	Name string
+
+	// Type holds the type of the recorded spans.
+	Type string
+}
+
+// ProcessBatch calls t.Processor.ProcessBatch within a span.
+func (t *Traced) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	span, ctx := apm.StartSpan(ctx, t.Name, t.Type)
+	defer span.End()
+	return t.Processor.ProcessBatch(ctx, batch)
+}
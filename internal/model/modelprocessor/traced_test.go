@@ -0,0 +1,66 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestTraced(t *testing.T) {
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+
+	var processorCtx context.Context
+	processorErr := errors.New("boom")
+	processor := &modelprocessor.Traced{
+		Name: "Enqueue",
+		Type: "output",
+		Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+			processorCtx = ctx
+			return processorErr
+		}),
+	}
+
+	// Without a transaction in the context, no spans are recorded.
+	err := processor.ProcessBatch(context.Background(), &model.Batch{})
+	assert.Equal(t, processorErr, err)
+	assert.Nil(t, apm.SpanFromContext(processorCtx))
+
+	tx := tracer.StartTransaction("name", "type")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+	err = processor.ProcessBatch(ctx, &model.Batch{})
+	assert.Equal(t, processorErr, err)
+	assert.NotNil(t, apm.SpanFromContext(processorCtx))
+	tx.End()
+	tracer.Flush(nil)
+
+	payloads := tracer.Payloads()
+	require.Len(t, payloads.Spans, 1)
+	assert.Equal(t, "Enqueue", payloads.Spans[0].Name)
+	assert.Equal(t, "output", payloads.Spans[0].Type)
+}
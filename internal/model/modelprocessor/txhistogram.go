@@ -0,0 +1,148 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-hdrhistogram"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	// transactionDurationHistogram holds the name of metricset samples
+	// holding transaction duration histograms, in microseconds.
+	transactionDurationHistogram = "transaction.duration.histogram"
+
+	// maxTransactionDurationHistogramValue holds the maximum value recorded
+	// in transaction duration histograms. This matches the range used by
+	// transaction metrics aggregation; larger values are clamped.
+	maxTransactionDurationHistogramValue = time.Hour
+)
+
+// TransactionDurationHistograms is a model.BatchProcessor that validates
+// transaction duration histograms in metricsets sent by agents, and
+// re-buckets them to match the HDR histogram configuration used by
+// transaction metrics aggregation.
+//
+// Histograms with mismatched values and counts, negative counts, or
+// values which are negative or not finite are rejected: the histogram
+// sample is removed, and metricsets with no remaining samples are
+// dropped. Valid histograms are re-bucketed, correcting inconsistent
+// or unordered bucket boundaries, so that they may be combined with
+// histograms produced by the server.
+type TransactionDurationHistograms struct {
+	significantFigures int
+	logger             *logp.Logger
+}
+
+// NewTransactionDurationHistograms returns a new TransactionDurationHistograms,
+// re-bucketing histograms to HDR histograms with the given number of significant
+// figures, which must be in the range [1,5].
+func NewTransactionDurationHistograms(significantFigures int) (*TransactionDurationHistograms, error) {
+	if significantFigures < 1 || significantFigures > 5 {
+		return nil, fmt.Errorf("significant figures (%d) outside range [1,5]", significantFigures)
+	}
+	return &TransactionDurationHistograms{
+		significantFigures: significantFigures,
+		logger:             logp.NewLogger("transaction_histograms"),
+	}, nil
+}
+
+// ProcessBatch validates and re-buckets transaction duration histograms.
+func (p *TransactionDurationHistograms) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	events := (*b)[:0]
+	for _, event := range *b {
+		if event.Metricset != nil && !p.processMetricset(event.Metricset) {
+			continue
+		}
+		events = append(events, event)
+	}
+	*b = events
+	return nil
+}
+
+// processMetricset processes the transaction duration histogram samples in
+// the metricset, returning false if the metricset should be dropped.
+func (p *TransactionDurationHistograms) processMetricset(metricset *model.Metricset) bool {
+	var rejected bool
+	samples := metricset.Samples[:0]
+	for _, sample := range metricset.Samples {
+		if sample.Name != transactionDurationHistogram {
+			samples = append(samples, sample)
+			continue
+		}
+		histogram, err := p.rebucket(sample.Histogram)
+		if err != nil {
+			p.logger.Debugf("rejecting %s: %s", transactionDurationHistogram, err)
+			rejected = true
+			continue
+		}
+		sample.Histogram = histogram
+		samples = append(samples, sample)
+	}
+	metricset.Samples = samples
+	return !rejected || len(samples) > 0
+}
+
+func (p *TransactionDurationHistograms) rebucket(in model.Histogram) (model.Histogram, error) {
+	if len(in.Values) != len(in.Counts) {
+		return model.Histogram{}, fmt.Errorf(
+			"values and counts have different lengths (%d != %d)",
+			len(in.Values), len(in.Counts),
+		)
+	}
+	h := hdrhistogram.New(0, maxTransactionDurationHistogramValue.Microseconds(), p.significantFigures)
+	for i, value := range in.Values {
+		count := in.Counts[i]
+		if count < 0 {
+			return model.Histogram{}, fmt.Errorf("invalid count %d", count)
+		}
+		if value < 0 || math.IsNaN(value) || math.IsInf(value, 0) {
+			return model.Histogram{}, fmt.Errorf("invalid value %v", value)
+		}
+		v := int64(math.Round(value))
+		if max := h.HighestTrackableValue(); v > max {
+			v = max
+		}
+		if err := h.RecordValues(v, count); err != nil {
+			return model.Histogram{}, err
+		}
+	}
+
+	// As in transaction metrics aggregation, values hold the upper
+	// limit of each non-empty bucket.
+	var out model.Histogram
+	for _, bar := range h.Distribution() {
+		if bar.Count <= 0 {
+			continue
+		}
+		out.Counts = append(out.Counts, bar.Count)
+		out.Values = append(out.Values, float64(bar.To))
+	}
+	if len(out.Counts) == 0 {
+		return model.Histogram{}, errors.New("histogram is empty")
+	}
+	return out, nil
+}
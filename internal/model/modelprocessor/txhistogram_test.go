@@ -0,0 +1,119 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestTransactionDurationHistogramsRebucket(t *testing.T) {
+	processor, err := modelprocessor.NewTransactionDurationHistograms(2)
+	require.NoError(t, err)
+
+	otherSample := model.MetricsetSample{Name: "other", Value: 1}
+	testProcessBatch(t, processor, model.APMEvent{
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{Samples: []model.MetricsetSample{
+			otherSample,
+			{
+				Name: "transaction.duration.histogram",
+				Type: model.MetricTypeHistogram,
+				Histogram: model.Histogram{
+					// Unordered values, values sharing an HDR bucket, zero counts,
+					// and values exceeding the maximum transaction duration.
+					Values: []float64{1000.4, 100, 1001, 5e10, 200},
+					Counts: []int64{1, 2, 3, 4, 0},
+				},
+			},
+		}},
+	}, model.APMEvent{
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{Samples: []model.MetricsetSample{
+			otherSample,
+			{
+				Name: "transaction.duration.histogram",
+				Type: model.MetricTypeHistogram,
+				Histogram: model.Histogram{
+					Values: []float64{100, 1003, 3607101439},
+					Counts: []int64{2, 4, 4},
+				},
+			},
+		}},
+	})
+
+	// Events without transaction duration histograms are unmodified.
+	testProcessBatch(t, processor,
+		model.APMEvent{Metricset: &model.Metricset{Samples: []model.MetricsetSample{otherSample}}},
+		model.APMEvent{Metricset: &model.Metricset{Samples: []model.MetricsetSample{otherSample}}},
+	)
+	testProcessBatch(t, processor,
+		model.APMEvent{Transaction: &model.Transaction{Name: "GET /"}},
+		model.APMEvent{Transaction: &model.Transaction{Name: "GET /"}},
+	)
+}
+
+func TestTransactionDurationHistogramsReject(t *testing.T) {
+	processor, err := modelprocessor.NewTransactionDurationHistograms(2)
+	require.NoError(t, err)
+
+	for name, histogram := range map[string]model.Histogram{
+		"mismatched_lengths": {Values: []float64{1, 2}, Counts: []int64{1}},
+		"negative_count":     {Values: []float64{1, 2}, Counts: []int64{1, -1}},
+		"negative_value":     {Values: []float64{-1}, Counts: []int64{1}},
+		"nan_value":          {Values: []float64{math.NaN()}, Counts: []int64{1}},
+		"inf_value":          {Values: []float64{math.Inf(1)}, Counts: []int64{1}},
+		"empty":              {},
+		"zero_counts":        {Values: []float64{1, 2}, Counts: []int64{0, 0}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			histogramSample := model.MetricsetSample{
+				Name:      "transaction.duration.histogram",
+				Type:      model.MetricTypeHistogram,
+				Histogram: histogram,
+			}
+			otherSample := model.MetricsetSample{Name: "other", Value: 1}
+			batch := model.Batch{{
+				// The histogram is removed, leaving the other sample.
+				Metricset: &model.Metricset{Samples: []model.MetricsetSample{histogramSample, otherSample}},
+			}, {
+				// The histogram is removed, and the metricset is dropped.
+				Metricset: &model.Metricset{Samples: []model.MetricsetSample{histogramSample}},
+			}}
+			err := processor.ProcessBatch(context.Background(), &batch)
+			require.NoError(t, err)
+			assert.Equal(t, model.Batch{{
+				Metricset: &model.Metricset{Samples: []model.MetricsetSample{otherSample}},
+			}}, batch)
+		})
+	}
+}
+
+func TestNewTransactionDurationHistogramsInvalid(t *testing.T) {
+	_, err := modelprocessor.NewTransactionDurationHistograms(0)
+	assert.EqualError(t, err, "significant figures (0) outside range [1,5]")
+	_, err = modelprocessor.NewTransactionDurationHistograms(6)
+	assert.EqualError(t, err, "significant figures (6) outside range [1,5]")
+}
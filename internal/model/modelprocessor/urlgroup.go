@@ -0,0 +1,187 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// urlGroupID is the segment with which SetURLGroup replaces ID-like
+// path segments.
+const urlGroupID = ":id"
+
+// SetURLGroup is a model.BatchProcessor that groups the URL paths and
+// transaction names of RUM events, collapsing high cardinality paths
+// such as "/users/123/orders/456" into "/users/:id/orders/:id" when
+// the agent cannot infer routes.
+//
+// A path is grouped with the first of Templates that matches it, or
+// else the first of Rules whose pattern matches it, or else by replacing
+// ID-like segments if ReplaceIDs is true.
+type SetURLGroup struct {
+	// Templates holds path templates, such as "/users/:id/orders/:id".
+	// Template segments that start with ':', or are '*', match any
+	// single path segment; other segments match literally. A path
+	// matching a template is replaced with the template.
+	Templates []string
+
+	// Rules holds regular expression replacement rules.
+	Rules []URLGroupRule
+
+	// ReplaceIDs controls whether path segments that look like IDs,
+	// such as numbers, UUIDs, and hex strings, are replaced with ":id"
+	// if no template or rule matches the path.
+	ReplaceIDs bool
+
+	// StripQueryParams controls whether the query string and fragment
+	// are stripped from transaction names.
+	StripQueryParams bool
+}
+
+// URLGroupRule is a rule for grouping URL paths with a regular expression.
+type URLGroupRule struct {
+	// Pattern holds the regular expression matched against paths.
+	Pattern *regexp.Regexp
+
+	// Replacement holds the replacement for matches of Pattern,
+	// which may refer to submatches as in regexp.Regexp.Expand.
+	Replacement string
+}
+
+// ProcessBatch groups the URL paths of events in b, and the names of
+// transactions in b that are URL paths.
+func (s SetURLGroup) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	for i := range *b {
+		event := &(*b)[i]
+		if event.URL.Path != "" {
+			event.URL.Path = s.group(event.URL.Path)
+		}
+		if event.Transaction != nil {
+			s.processTransactionName(event.Transaction)
+		}
+	}
+	return nil
+}
+
+// processTransactionName groups the transaction name if it is a URL
+// path. Other names, such as those of user interaction transactions,
+// are left unchanged.
+func (s SetURLGroup) processTransactionName(tx *model.Transaction) {
+	if !strings.HasPrefix(tx.Name, "/") {
+		return
+	}
+	name := tx.Name
+	if s.StripQueryParams {
+		if i := strings.IndexAny(name, "?#"); i >= 0 {
+			name = name[:i]
+		}
+	}
+	tx.Name = s.group(name)
+}
+
+func (s SetURLGroup) group(path string) string {
+	for _, template := range s.Templates {
+		if matchURLTemplate(template, path) {
+			return template
+		}
+	}
+	for _, rule := range s.Rules {
+		if rule.Pattern.MatchString(path) {
+			return rule.Pattern.ReplaceAllString(path, rule.Replacement)
+		}
+	}
+	if s.ReplaceIDs {
+		return replaceURLIDs(path)
+	}
+	return path
+}
+
+// matchURLTemplate reports whether path matches template, segment by segment.
+func matchURLTemplate(template, path string) bool {
+	for {
+		templateSegment, templateRest, templateMore := strings.Cut(template, "/")
+		pathSegment, pathRest, pathMore := strings.Cut(path, "/")
+		if templateMore != pathMore {
+			return false
+		}
+		if strings.HasPrefix(templateSegment, ":") || templateSegment == "*" {
+			if pathSegment == "" {
+				return false
+			}
+		} else if templateSegment != pathSegment {
+			return false
+		}
+		if !templateMore {
+			return true
+		}
+		template, path = templateRest, pathRest
+	}
+}
+
+// replaceURLIDs replaces the ID-like segments of path with ":id".
+func replaceURLIDs(path string) string {
+	var b strings.Builder
+	var replaced bool
+	for i, segment := range strings.Split(path, "/") {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		if isURLID(segment) {
+			b.WriteString(urlGroupID)
+			replaced = true
+			continue
+		}
+		b.WriteString(segment)
+	}
+	if !replaced {
+		return path
+	}
+	return b.String()
+}
+
+// isURLID reports whether segment looks like an ID: a number, a UUID,
+// or a hex string of at least 8 characters containing a digit.
+func isURLID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	var digits, dashes int
+	for _, r := range segment {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r >= 'a' && r <= 'f', r >= 'A' && r <= 'F':
+		case r == '-':
+			dashes++
+		default:
+			return false
+		}
+	}
+	switch {
+	case digits == len(segment):
+		return true
+	case dashes == 0:
+		return digits > 0 && len(segment) >= 8
+	default:
+		return dashes == 4 && len(segment) == 36
+	}
+}
@@ -0,0 +1,88 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package modelprocessor_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
+)
+
+func TestSetURLGroup(t *testing.T) {
+	processor := modelprocessor.SetURLGroup{
+		Templates: []string{"/users/:id/orders/:id", "/static/*"},
+		Rules: []modelprocessor.URLGroupRule{{
+			Pattern:     regexp.MustCompile("^/blog/[^/]+$"),
+			Replacement: "/blog/:slug",
+		}},
+		ReplaceIDs:       true,
+		StripQueryParams: true,
+	}
+
+	for _, test := range []struct {
+		path, expected string
+	}{
+		{path: "/users/123/orders/456", expected: "/users/:id/orders/:id"},
+		{path: "/users/alice/orders/latest", expected: "/users/:id/orders/:id"},
+		{path: "/users/123/orders/", expected: "/users/:id/orders/"},
+		{path: "/static/app.js", expected: "/static/*"},
+		{path: "/static/js/app.js", expected: "/static/js/app.js"},
+		{path: "/blog/hello-world", expected: "/blog/:slug"},
+		{path: "/products/42", expected: "/products/:id"},
+		{path: "/products/9f86d081884c7d65", expected: "/products/:id"},
+		{path: "/products/deadbeef", expected: "/products/deadbeef"},
+		{path: "/carts/123e4567-e89b-12d3-a456-426614174000/items", expected: "/carts/:id/items"},
+		{path: "/products/shoes", expected: "/products/shoes"},
+		{path: "/", expected: "/"},
+	} {
+		batch := model.Batch{{URL: model.URL{Path: test.path}}}
+		err := processor.ProcessBatch(context.Background(), &batch)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, batch[0].URL.Path, test.path)
+	}
+}
+
+func TestSetURLGroupTransactionName(t *testing.T) {
+	processor := modelprocessor.SetURLGroup{ReplaceIDs: true, StripQueryParams: true}
+	batch := model.Batch{
+		{Transaction: &model.Transaction{Name: "/users/123?tab=orders#top"}},
+		{Transaction: &model.Transaction{Name: "Click - button"}},
+		{Transaction: &model.Transaction{Name: "/users/:id"}},
+		{Span: &model.Span{Name: "GET /users/123"}},
+	}
+	err := processor.ProcessBatch(context.Background(), &batch)
+	assert.NoError(t, err)
+	assert.Equal(t, model.Batch{
+		{Transaction: &model.Transaction{Name: "/users/:id"}},
+		{Transaction: &model.Transaction{Name: "Click - button"}},
+		{Transaction: &model.Transaction{Name: "/users/:id"}},
+		{Span: &model.Span{Name: "GET /users/123"}},
+	}, batch)
+
+	// Query parameters are retained if StripQueryParams is false.
+	processor.StripQueryParams = false
+	batch = model.Batch{{Transaction: &model.Transaction{Name: "/users/123?tab=orders"}}}
+	err = processor.ProcessBatch(context.Background(), &batch)
+	assert.NoError(t, err)
+	assert.Equal(t, "/users/123?tab=orders", batch[0].Transaction.Name)
+}
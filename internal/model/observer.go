@@ -30,6 +30,16 @@ type Observer struct {
 	Name     string
 	Type     string
 	Version  string
+
+	// EphemeralID holds an identifier of the observer instance, which
+	// changes each time the observer is restarted.
+	EphemeralID string
+
+	// PipelineVersion holds the version of the ingest pipelines installed
+	// by the observer.
+	//
+	// PipelineVersion is only added as a field if greater than zero.
+	PipelineVersion int
 }
 
 func (o *Observer) Fields() mapstr.M {
@@ -38,5 +48,9 @@ func (o *Observer) Fields() mapstr.M {
 	fields.maybeSetString("name", o.Name)
 	fields.maybeSetString("type", o.Type)
 	fields.maybeSetString("version", o.Version)
+	fields.maybeSetString("ephemeral_id", o.EphemeralID)
+	if o.PipelineVersion > 0 {
+		fields.set("pipeline_version", o.PipelineVersion)
+	}
 	return mapstr.M(fields)
 }
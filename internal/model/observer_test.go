@@ -40,12 +40,17 @@ func TestObserverFields(t *testing.T) {
 				Name:     "observer_name",
 				Type:     "observer_type",
 				Version:  "observer_version",
+
+				EphemeralID:     "observer_ephemeral_id",
+				PipelineVersion: 1,
 			},
 			Fields: mapstr.M{
-				"hostname": "observer_hostname",
-				"name":     "observer_name",
-				"type":     "observer_type",
-				"version":  "observer_version",
+				"hostname":         "observer_hostname",
+				"name":             "observer_name",
+				"type":             "observer_type",
+				"version":          "observer_version",
+				"ephemeral_id":     "observer_ephemeral_id",
+				"pipeline_version": 1,
 			},
 		},
 	}
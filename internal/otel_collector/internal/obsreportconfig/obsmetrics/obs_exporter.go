@@ -37,6 +37,9 @@ const (
 	SentLogRecordsKey = "sent_log_records"
 	// FailedToSendLogRecordsKey used to track logs that failed to be sent by exporters.
 	FailedToSendLogRecordsKey = "send_failed_log_records"
+
+	// SendFailedRequestsKey used to track requests that failed to be sent by exporters.
+	SendFailedRequestsKey = "send_failed_requests"
 )
 
 var (
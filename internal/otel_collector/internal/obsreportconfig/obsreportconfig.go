@@ -62,7 +62,15 @@ func Configure(level configtelemetry.Level) *ObsMetrics {
 }
 
 // allViews return the list of all views that needs to be configured.
+//
+// When the UseOtelForInternalMetricsfeatureGateID feature gate is enabled,
+// the obsreport package records metrics with OpenTelemetry instruments
+// created from the component's MeterProvider, and no views are needed.
 func allViews() []*view.View {
+	if featuregate.GetRegistry().IsEnabled(UseOtelForInternalMetricsfeatureGateID) {
+		return nil
+	}
+
 	var views []*view.View
 	var measures []*stats.Int64Measure
 	var tagKeys []tag.Key
@@ -91,7 +99,7 @@ func allViews() []*view.View {
 	views = append(views, genViews(measures, tagKeys, view.Sum())...)
 
 	errorNumberView := &view.View{
-		Name:        obsmetrics.ExporterPrefix + "send_failed_requests",
+		Name:        obsmetrics.ExporterPrefix + obsmetrics.SendFailedRequestsKey,
 		Description: "number of times exporters failed to send requests to the destination",
 		Measure:     obsmetrics.ExporterFailedToSendSpans,
 		Aggregation: view.Count(),
@@ -117,10 +125,6 @@ func allViews() []*view.View {
 }
 
 func receiverViews() []*view.View {
-	if featuregate.GetRegistry().IsEnabled(UseOtelForInternalMetricsfeatureGateID) {
-		return nil
-	}
-
 	measures := []*stats.Int64Measure{
 		obsmetrics.ReceiverAcceptedSpans,
 		obsmetrics.ReceiverRefusedSpans,
@@ -20,13 +20,27 @@ import (
 
 	"go.opencensus.io/stats"
 	"go.opencensus.io/tag"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/metric/instrument"
+	"go.opentelemetry.io/otel/metric/instrument/syncint64"
+	"go.opentelemetry.io/otel/metric/unit"
+	"go.uber.org/zap"
 
 	"go.opentelemetry.io/collector/component"
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/config/configtelemetry"
+	"go.opentelemetry.io/collector/featuregate"
+	"go.opentelemetry.io/collector/internal/obsreportconfig"
 	"go.opentelemetry.io/collector/internal/obsreportconfig/obsmetrics"
 )
 
+const (
+	processorName = "processor"
+
+	processorScope = scopeName + nameSep + processorName
+)
+
 // BuildProcessorCustomMetricName is used to be build a metric name following
 // the standards used in the Collector. The configType should be the same
 // value used to identify the type on the config.
@@ -45,6 +59,21 @@ func BuildProcessorCustomMetricName(configType, metric string) string {
 type Processor struct {
 	level    configtelemetry.Level
 	mutators []tag.Mutator
+	meter    metric.Meter
+	logger   *zap.Logger
+
+	useOtelForMetrics bool
+	otelAttrs         []attribute.KeyValue
+
+	acceptedSpansCounter        syncint64.Counter
+	refusedSpansCounter         syncint64.Counter
+	droppedSpansCounter         syncint64.Counter
+	acceptedMetricPointsCounter syncint64.Counter
+	refusedMetricPointsCounter  syncint64.Counter
+	droppedMetricPointsCounter  syncint64.Counter
+	acceptedLogRecordsCounter   syncint64.Counter
+	refusedLogRecordsCounter    syncint64.Counter
+	droppedLogRecordsCounter    syncint64.Counter
 }
 
 // ProcessorSettings are settings for creating a Processor.
@@ -55,134 +84,152 @@ type ProcessorSettings struct {
 
 // NewProcessor creates a new Processor.
 func NewProcessor(cfg ProcessorSettings) *Processor {
-	return &Processor{
+	por := &Processor{
 		level:    cfg.ProcessorCreateSettings.MetricsLevel,
 		mutators: []tag.Mutator{tag.Upsert(obsmetrics.TagKeyProcessor, cfg.ProcessorID.String(), tag.WithTTL(tag.TTLNoPropagation))},
+		meter:    cfg.ProcessorCreateSettings.MeterProvider.Meter(processorScope),
+		logger:   cfg.ProcessorCreateSettings.Logger,
+
+		useOtelForMetrics: featuregate.GetRegistry().IsEnabled(obsreportconfig.UseOtelForInternalMetricsfeatureGateID),
+		otelAttrs: []attribute.KeyValue{
+			attribute.String(obsmetrics.ProcessorKey, cfg.ProcessorID.String()),
+		},
 	}
+
+	por.createOtelMetrics()
+
+	return por
 }
 
-// TracesAccepted reports that the trace data was accepted.
-func (por *Processor) TracesAccepted(ctx context.Context, numSpans int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedSpans.M(int64(numSpans)),
-			obsmetrics.ProcessorRefusedSpans.M(0),
-			obsmetrics.ProcessorDroppedSpans.M(0),
+func (por *Processor) createOtelMetrics() {
+	if !por.useOtelForMetrics {
+		return
+	}
+
+	newCounter := func(name, description string) syncint64.Counter {
+		counter, err := por.meter.SyncInt64().Counter(
+			obsmetrics.ProcessorPrefix+name,
+			instrument.WithDescription(description),
+			instrument.WithUnit(unit.Dimensionless),
 		)
+		if err != nil {
+			por.logger.Warn("failed to create otel instrument", zap.Error(err), zap.String("metric", obsmetrics.ProcessorPrefix+name))
+		}
+		return counter
+	}
+
+	por.acceptedSpansCounter = newCounter(obsmetrics.AcceptedSpansKey, "Number of spans successfully pushed into the next component in the pipeline.")
+	por.refusedSpansCounter = newCounter(obsmetrics.RefusedSpansKey, "Number of spans that were rejected by the next component in the pipeline.")
+	por.droppedSpansCounter = newCounter(obsmetrics.DroppedSpansKey, "Number of spans that were dropped.")
+	por.acceptedMetricPointsCounter = newCounter(obsmetrics.AcceptedMetricPointsKey, "Number of metric points successfully pushed into the next component in the pipeline.")
+	por.refusedMetricPointsCounter = newCounter(obsmetrics.RefusedMetricPointsKey, "Number of metric points that were rejected by the next component in the pipeline.")
+	por.droppedMetricPointsCounter = newCounter(obsmetrics.DroppedMetricPointsKey, "Number of metric points that were dropped.")
+	por.acceptedLogRecordsCounter = newCounter(obsmetrics.AcceptedLogRecordsKey, "Number of log records successfully pushed into the next component in the pipeline.")
+	por.refusedLogRecordsCounter = newCounter(obsmetrics.RefusedLogRecordsKey, "Number of log records that were rejected by the next component in the pipeline.")
+	por.droppedLogRecordsCounter = newCounter(obsmetrics.DroppedLogRecordsKey, "Number of log records that were dropped.")
+}
+
+func (por *Processor) recordData(ctx context.Context, dataType config.DataType, accepted, refused, dropped int64) {
+	if por.level == configtelemetry.LevelNone {
+		return
+	}
+	if por.useOtelForMetrics {
+		por.recordWithOtel(ctx, dataType, accepted, refused, dropped)
+	} else {
+		por.recordWithOC(ctx, dataType, accepted, refused, dropped)
 	}
 }
 
+func (por *Processor) recordWithOtel(ctx context.Context, dataType config.DataType, accepted, refused, dropped int64) {
+	var acceptedCounter, refusedCounter, droppedCounter syncint64.Counter
+	switch dataType {
+	case config.TracesDataType:
+		acceptedCounter = por.acceptedSpansCounter
+		refusedCounter = por.refusedSpansCounter
+		droppedCounter = por.droppedSpansCounter
+	case config.MetricsDataType:
+		acceptedCounter = por.acceptedMetricPointsCounter
+		refusedCounter = por.refusedMetricPointsCounter
+		droppedCounter = por.droppedMetricPointsCounter
+	case config.LogsDataType:
+		acceptedCounter = por.acceptedLogRecordsCounter
+		refusedCounter = por.refusedLogRecordsCounter
+		droppedCounter = por.droppedLogRecordsCounter
+	}
+
+	acceptedCounter.Add(ctx, accepted, por.otelAttrs...)
+	refusedCounter.Add(ctx, refused, por.otelAttrs...)
+	droppedCounter.Add(ctx, dropped, por.otelAttrs...)
+}
+
+func (por *Processor) recordWithOC(ctx context.Context, dataType config.DataType, accepted, refused, dropped int64) {
+	var acceptedMeasure, refusedMeasure, droppedMeasure *stats.Int64Measure
+	switch dataType {
+	case config.TracesDataType:
+		acceptedMeasure = obsmetrics.ProcessorAcceptedSpans
+		refusedMeasure = obsmetrics.ProcessorRefusedSpans
+		droppedMeasure = obsmetrics.ProcessorDroppedSpans
+	case config.MetricsDataType:
+		acceptedMeasure = obsmetrics.ProcessorAcceptedMetricPoints
+		refusedMeasure = obsmetrics.ProcessorRefusedMetricPoints
+		droppedMeasure = obsmetrics.ProcessorDroppedMetricPoints
+	case config.LogsDataType:
+		acceptedMeasure = obsmetrics.ProcessorAcceptedLogRecords
+		refusedMeasure = obsmetrics.ProcessorRefusedLogRecords
+		droppedMeasure = obsmetrics.ProcessorDroppedLogRecords
+	}
+
+	// ignore the error for now; should not happen
+	_ = stats.RecordWithTags(
+		ctx,
+		por.mutators,
+		acceptedMeasure.M(accepted),
+		refusedMeasure.M(refused),
+		droppedMeasure.M(dropped),
+	)
+}
+
+// TracesAccepted reports that the trace data was accepted.
+func (por *Processor) TracesAccepted(ctx context.Context, numSpans int) {
+	por.recordData(ctx, config.TracesDataType, int64(numSpans), 0, 0)
+}
+
 // TracesRefused reports that the trace data was refused.
 func (por *Processor) TracesRefused(ctx context.Context, numSpans int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedSpans.M(0),
-			obsmetrics.ProcessorRefusedSpans.M(int64(numSpans)),
-			obsmetrics.ProcessorDroppedSpans.M(0),
-		)
-	}
+	por.recordData(ctx, config.TracesDataType, 0, int64(numSpans), 0)
 }
 
 // TracesDropped reports that the trace data was dropped.
 func (por *Processor) TracesDropped(ctx context.Context, numSpans int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedSpans.M(0),
-			obsmetrics.ProcessorRefusedSpans.M(0),
-			obsmetrics.ProcessorDroppedSpans.M(int64(numSpans)),
-		)
-	}
+	por.recordData(ctx, config.TracesDataType, 0, 0, int64(numSpans))
 }
 
 // MetricsAccepted reports that the metrics were accepted.
 func (por *Processor) MetricsAccepted(ctx context.Context, numPoints int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedMetricPoints.M(int64(numPoints)),
-			obsmetrics.ProcessorRefusedMetricPoints.M(0),
-			obsmetrics.ProcessorDroppedMetricPoints.M(0),
-		)
-	}
+	por.recordData(ctx, config.MetricsDataType, int64(numPoints), 0, 0)
 }
 
 // MetricsRefused reports that the metrics were refused.
 func (por *Processor) MetricsRefused(ctx context.Context, numPoints int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedMetricPoints.M(0),
-			obsmetrics.ProcessorRefusedMetricPoints.M(int64(numPoints)),
-			obsmetrics.ProcessorDroppedMetricPoints.M(0),
-		)
-	}
+	por.recordData(ctx, config.MetricsDataType, 0, int64(numPoints), 0)
 }
 
 // MetricsDropped reports that the metrics were dropped.
 func (por *Processor) MetricsDropped(ctx context.Context, numPoints int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedMetricPoints.M(0),
-			obsmetrics.ProcessorRefusedMetricPoints.M(0),
-			obsmetrics.ProcessorDroppedMetricPoints.M(int64(numPoints)),
-		)
-	}
+	por.recordData(ctx, config.MetricsDataType, 0, 0, int64(numPoints))
 }
 
 // LogsAccepted reports that the logs were accepted.
 func (por *Processor) LogsAccepted(ctx context.Context, numRecords int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedLogRecords.M(int64(numRecords)),
-			obsmetrics.ProcessorRefusedLogRecords.M(0),
-			obsmetrics.ProcessorDroppedLogRecords.M(0),
-		)
-	}
+	por.recordData(ctx, config.LogsDataType, int64(numRecords), 0, 0)
 }
 
 // LogsRefused reports that the logs were refused.
 func (por *Processor) LogsRefused(ctx context.Context, numRecords int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedLogRecords.M(0),
-			obsmetrics.ProcessorRefusedLogRecords.M(int64(numRecords)),
-			obsmetrics.ProcessorDroppedMetricPoints.M(0),
-		)
-	}
+	por.recordData(ctx, config.LogsDataType, 0, int64(numRecords), 0)
 }
 
 // LogsDropped reports that the logs were dropped.
 func (por *Processor) LogsDropped(ctx context.Context, numRecords int) {
-	if por.level != configtelemetry.LevelNone {
-		// ignore the error for now; should not happen
-		_ = stats.RecordWithTags(
-			ctx,
-			por.mutators,
-			obsmetrics.ProcessorAcceptedLogRecords.M(0),
-			obsmetrics.ProcessorRefusedLogRecords.M(0),
-			obsmetrics.ProcessorDroppedLogRecords.M(int64(numRecords)),
-		)
-	}
+	por.recordData(ctx, config.LogsDataType, 0, 0, int64(numRecords))
 }
@@ -27,6 +27,8 @@ import (
 const (
 	dataFormatProtobuf = "protobuf"
 	receiverTransport  = "grpc"
+
+	receiverTransportHTTP = "http"
 )
 
 // Receiver is the type used to handle spans from OpenTelemetry exporters.
@@ -37,11 +39,21 @@ type Receiver struct {
 
 // New creates a new Receiver reference.
 func New(id config.ComponentID, nextConsumer consumer.Traces, set component.ReceiverCreateSettings) *Receiver {
+	return newReceiver(id, receiverTransport, nextConsumer, set)
+}
+
+// NewHTTP creates a new Receiver reference for requests received over HTTP,
+// which are reported with the "http" transport.
+func NewHTTP(id config.ComponentID, nextConsumer consumer.Traces, set component.ReceiverCreateSettings) *Receiver {
+	return newReceiver(id, receiverTransportHTTP, nextConsumer, set)
+}
+
+func newReceiver(id config.ComponentID, transport string, nextConsumer consumer.Traces, set component.ReceiverCreateSettings) *Receiver {
 	return &Receiver{
 		nextConsumer: nextConsumer,
 		obsrecv: obsreport.NewReceiver(obsreport.ReceiverSettings{
 			ReceiverID:             id,
-			Transport:              receiverTransport,
+			Transport:              transport,
 			ReceiverCreateSettings: set,
 		}),
 	}
@@ -26,6 +26,8 @@ import (
 	"go.opentelemetry.io/collector/config"
 	"go.opentelemetry.io/collector/config/configtelemetry"
 	"go.opentelemetry.io/collector/consumer"
+	"go.opentelemetry.io/collector/featuregate"
+	"go.opentelemetry.io/collector/internal/obsreportconfig"
 	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
 	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
 	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
@@ -50,6 +52,23 @@ var settings = component.ReceiverCreateSettings{
 	},
 }
 
+// SetMeterProvider sets the MeterProvider with which receivers record their
+// internal metrics, and enables the telemetry.useOtelForInternalMetrics
+// feature gate so obsreport uses OpenTelemetry instruments rather than
+// OpenCensus views.
+//
+// SetMeterProvider must be called before any receivers are created.
+func SetMeterProvider(mp metric.MeterProvider) error {
+	if err := featuregate.GetRegistry().Apply(map[string]bool{
+		obsreportconfig.UseOtelForInternalMetricsfeatureGateID: true,
+	}); err != nil {
+		return err
+	}
+	settings.MeterProvider = mp
+	settings.MetricsLevel = configtelemetry.LevelBasic
+	return nil
+}
+
 type HTTPHandlers struct {
 	TraceHandler   http.HandlerFunc
 	MetricsHandler http.HandlerFunc
@@ -79,21 +98,21 @@ func LogsService(consumer consumer.Logs) plogotlp.GRPCServer {
 // HTTP Receivers
 
 func TracesHTTPHandler(ctx context.Context, consumer consumer.Traces) (http.HandlerFunc, error) {
-	receiver := trace.New(config.NewComponentID("otlp"), consumer, settings)
+	receiver := trace.NewHTTP(config.NewComponentID("otlp"), consumer, settings)
 	return func(w http.ResponseWriter, r *http.Request) {
 		handleTraces(w, r, receiver, pbEncoder)
 	}, nil
 }
 
 func MetricsHTTPHandler(ctx context.Context, consumer consumer.Metrics) (http.HandlerFunc, error) {
-	receiver := metrics.New(config.NewComponentID("otlp"), consumer, settings)
+	receiver := metrics.NewHTTP(config.NewComponentID("otlp"), consumer, settings)
 	return func(w http.ResponseWriter, r *http.Request) {
 		handleMetrics(w, r, receiver, pbEncoder)
 	}, nil
 }
 
 func LogsHTTPHandler(ctx context.Context, consumer consumer.Logs) (http.HandlerFunc, error) {
-	receiver := logs.New(config.NewComponentID("otlp"), consumer, settings)
+	receiver := logs.NewHTTP(config.NewComponentID("otlp"), consumer, settings)
 	return func(w http.ResponseWriter, r *http.Request) {
 		handleLogs(w, r, receiver, pbEncoder)
 	}, nil
@@ -0,0 +1,142 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package otelmetrics provides an OpenTelemetry MeterProvider whose
+// metrics are reported through libbeat monitoring, and from there to
+// the configured monitoring backend.
+package otelmetrics
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// MeterProvider is an OpenTelemetry metric.MeterProvider which records
+// metrics in memory, for collection as libbeat monitoring metrics.
+type MeterProvider struct {
+	*sdkmetric.MeterProvider
+	reader sdkmetric.Reader
+}
+
+// NewMeterProvider returns a new MeterProvider.
+func NewMeterProvider() *MeterProvider {
+	reader := sdkmetric.NewManualReader()
+	return &MeterProvider{
+		MeterProvider: sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)),
+		reader:        reader,
+	}
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// Sum and gauge metrics are reported, with cumulative values. Metric names
+// are split on "/" into nested namespaces, and the values of the metric's
+// attributes are appended in order of their keys. For example, the metric
+// "receiver/accepted_spans" with attributes {receiver=otlp, transport=grpc}
+// is reported as "receiver.accepted_spans.otlp.grpc". Other metrics, such
+// as histograms, are ignored.
+func (mp *MeterProvider) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	rm, err := mp.reader.Collect(context.Background())
+	if err != nil {
+		logp.NewLogger(logs.Beater).Warnf("failed to collect OpenTelemetry metrics: %v", err)
+		return
+	}
+	root := make(namespace)
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			path := strings.Split(m.Name, "/")
+			switch data := m.Data.(type) {
+			case metricdata.Sum[int64]:
+				addDataPoints(root, path, data.DataPoints)
+			case metricdata.Sum[float64]:
+				addDataPoints(root, path, data.DataPoints)
+			case metricdata.Gauge[int64]:
+				addDataPoints(root, path, data.DataPoints)
+			case metricdata.Gauge[float64]:
+				addDataPoints(root, path, data.DataPoints)
+			}
+		}
+	}
+	root.report(V)
+}
+
+// namespace holds nested metric values, keyed by name. Values are
+// either int64, float64, or namespace.
+type namespace map[string]interface{}
+
+func addDataPoints[N int64 | float64](root namespace, path []string, dataPoints []metricdata.DataPoint[N]) {
+	for _, dp := range dataPoints {
+		dpPath := path
+		iter := dp.Attributes.Iter()
+		for iter.Next() {
+			dpPath = append(dpPath[:len(dpPath):len(dpPath)], iter.Attribute().Value.Emit())
+		}
+		root.add(dpPath, dp.Value)
+	}
+}
+
+// add adds value to ns at the given path, creating namespaces as needed.
+// Values which conflict with existing values or namespaces are ignored.
+func (ns namespace) add(path []string, value interface{}) {
+	for _, name := range path[:len(path)-1] {
+		switch child := ns[name].(type) {
+		case namespace:
+			ns = child
+		case nil:
+			newChild := make(namespace)
+			ns[name] = newChild
+			ns = newChild
+		default:
+			return
+		}
+	}
+	name := path[len(path)-1]
+	if _, ok := ns[name]; !ok {
+		ns[name] = value
+	}
+}
+
+func (ns namespace) report(V monitoring.Visitor) {
+	names := make([]string, 0, len(ns))
+	for name := range ns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		switch value := ns[name].(type) {
+		case namespace:
+			monitoring.ReportNamespace(V, name, func() { value.report(V) })
+		case int64:
+			monitoring.ReportInt(V, name, value)
+		case float64:
+			monitoring.ReportFloat(V, name, value)
+		}
+	}
+}
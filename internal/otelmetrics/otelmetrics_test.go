@@ -0,0 +1,80 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otelmetrics
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+func TestMeterProvider(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMeterProvider()
+	meter := mp.Meter("test")
+
+	accepted, err := meter.SyncInt64().Counter("receiver/accepted_spans")
+	require.NoError(t, err)
+	accepted.Add(ctx, 1, attribute.String("transport", "grpc"), attribute.String("receiver", "otlp"))
+	accepted.Add(ctx, 2, attribute.String("transport", "grpc"), attribute.String("receiver", "otlp"))
+	accepted.Add(ctx, 4, attribute.String("transport", "http"), attribute.String("receiver", "otlp"))
+
+	ratio, err := meter.SyncFloat64().UpDownCounter("ratio")
+	require.NoError(t, err)
+	ratio.Add(ctx, 0.5)
+
+	// Histograms are not reported.
+	histogram, err := meter.SyncInt64().Histogram("histogram")
+	require.NoError(t, err)
+	histogram.Record(ctx, 1)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "otel", mp.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"otel.receiver.accepted_spans.otlp.grpc": 3,
+		"otel.receiver.accepted_spans.otlp.http": 4,
+	}, snapshot.Ints)
+	assert.Equal(t, map[string]float64{"otel.ratio": 0.5}, snapshot.Floats)
+
+	// Values are cumulative.
+	accepted.Add(ctx, 1, attribute.String("transport", "grpc"), attribute.String("receiver", "otlp"))
+	snapshot = monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, int64(4), snapshot.Ints["otel.receiver.accepted_spans.otlp.grpc"])
+}
+
+func TestMeterProviderConflictingNames(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMeterProvider()
+	meter := mp.Meter("test")
+
+	parent, err := meter.SyncInt64().Counter("parent")
+	require.NoError(t, err)
+	parent.Add(ctx, 1, attribute.String("key", "value"))
+	parent.Add(ctx, 2)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "otel", mp.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Len(t, snapshot.Ints, 1)
+}
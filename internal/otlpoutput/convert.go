@@ -0,0 +1,422 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlpoutput
+
+import (
+	"encoding/hex"
+	"hash/fnv"
+
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	semconv "go.opentelemetry.io/collector/semconv/v1.5.0"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// scopeName holds the instrumentation scope name of exported telemetry.
+const scopeName = "apm-server"
+
+// converter converts events to OTLP, grouping them by resource.
+type converter struct {
+	traces  ptrace.Traces
+	logs    plog.Logs
+	metrics pmetric.Metrics
+
+	spans      map[resource]ptrace.SpanSlice
+	logRecords map[resource]plog.LogRecordSlice
+	metricList map[resource]pmetric.MetricSlice
+
+	// metricEvents holds the number of metricset events converted.
+	metricEvents int
+
+	// skipped holds the number of events which could not be converted.
+	skipped int
+}
+
+func newConverter() *converter {
+	return &converter{
+		traces:     ptrace.NewTraces(),
+		logs:       plog.NewLogs(),
+		metrics:    pmetric.NewMetrics(),
+		spans:      make(map[resource]ptrace.SpanSlice),
+		logRecords: make(map[resource]plog.LogRecordSlice),
+		metricList: make(map[resource]pmetric.MetricSlice),
+	}
+}
+
+// add converts event, and adds it to the traces, logs, or metrics.
+func (c *converter) add(event *model.APMEvent) {
+	var ok bool
+	switch event.Processor {
+	case model.TransactionProcessor:
+		ok = event.Transaction != nil && c.addTransaction(event)
+	case model.SpanProcessor:
+		ok = event.Span != nil && c.addSpan(event)
+	case model.ErrorProcessor:
+		ok = event.Error != nil && c.addError(event)
+	case model.LogProcessor:
+		ok = c.addLog(event)
+	case model.MetricsetProcessor:
+		ok = event.Metricset != nil && c.addMetricset(event)
+	}
+	if !ok {
+		c.skipped++
+	}
+}
+
+func (c *converter) addTransaction(event *model.APMEvent) bool {
+	kind := ptrace.SpanKindServer
+	if event.Transaction.Type == "messaging" {
+		kind = ptrace.SpanKindConsumer
+	}
+	return c.addSpanEvent(event, event.Transaction.ID, event.Transaction.Name, kind)
+}
+
+func (c *converter) addSpan(event *model.APMEvent) bool {
+	// Spans with the kind SERVER or CONSUMER are received as transactions
+	// by APM Server, so they are exported as INTERNAL spans instead.
+	kind := ptrace.SpanKindInternal
+	switch event.Span.Kind {
+	case "CLIENT":
+		kind = ptrace.SpanKindClient
+	case "PRODUCER":
+		kind = ptrace.SpanKindProducer
+	}
+	return c.addSpanEvent(event, event.Span.ID, event.Span.Name, kind)
+}
+
+func (c *converter) addSpanEvent(event *model.APMEvent, id, name string, kind ptrace.SpanKind) bool {
+	traceID, ok := parseTraceID(event.Trace.ID)
+	if !ok {
+		return false
+	}
+	spanID, ok := parseSpanID(id)
+	if !ok {
+		return false
+	}
+	res := newResource(event)
+	spans, ok := c.spans[res]
+	if !ok {
+		rs := c.traces.ResourceSpans().AppendEmpty()
+		res.copyTo(rs.Resource().Attributes())
+		ss := rs.ScopeSpans().AppendEmpty()
+		ss.Scope().SetName(scopeName)
+		spans = ss.Spans()
+		c.spans[res] = spans
+	}
+
+	span := spans.AppendEmpty()
+	span.SetTraceID(traceID)
+	span.SetSpanID(spanID)
+	if parentID, ok := parseSpanID(event.Parent.ID); ok {
+		span.SetParentSpanID(parentID)
+	}
+	span.SetName(name)
+	span.SetKind(kind)
+	span.SetStartTimestamp(pcommon.NewTimestampFromTime(event.Timestamp))
+	span.SetEndTimestamp(pcommon.NewTimestampFromTime(event.Timestamp.Add(event.Event.Duration)))
+	switch event.Event.Outcome {
+	case "success":
+		span.Status().SetCode(ptrace.StatusCodeOk)
+	case "failure":
+		span.Status().SetCode(ptrace.StatusCodeError)
+	}
+
+	attrs := span.Attributes()
+	putLabels(attrs, event)
+	if event.HTTP.Request != nil && event.HTTP.Request.Method != "" {
+		attrs.PutStr(semconv.AttributeHTTPMethod, event.HTTP.Request.Method)
+	}
+	if event.HTTP.Response != nil && event.HTTP.Response.StatusCode > 0 {
+		attrs.PutInt(semconv.AttributeHTTPStatusCode, int64(event.HTTP.Response.StatusCode))
+	}
+	if event.URL.Full != "" {
+		attrs.PutStr(semconv.AttributeHTTPURL, event.URL.Full)
+	}
+	if event.Span != nil && event.Span.DB != nil {
+		if event.Span.Subtype != "" {
+			attrs.PutStr(semconv.AttributeDBSystem, event.Span.Subtype)
+		}
+		if event.Span.DB.Instance != "" {
+			attrs.PutStr(semconv.AttributeDBName, event.Span.DB.Instance)
+		}
+		if event.Span.DB.Statement != "" {
+			attrs.PutStr(semconv.AttributeDBStatement, event.Span.DB.Statement)
+		}
+	}
+	return true
+}
+
+func (c *converter) addError(event *model.APMEvent) bool {
+	record := c.logRecord(event)
+	record.SetSeverityNumber(plog.SeverityNumberError)
+	record.SetSeverityText("ERROR")
+	if spanID, ok := parseSpanID(event.Parent.ID); ok {
+		record.SetSpanID(spanID)
+	}
+
+	// Errors are exported as log records with exception attributes,
+	// as there is no OTLP representation of standalone errors.
+	attrs := record.Attributes()
+	message := event.Error.Message
+	var exceptionType string
+	if event.Error.Exception != nil {
+		exceptionType = event.Error.Exception.Type
+		if event.Error.Exception.Message != "" {
+			message = event.Error.Exception.Message
+		}
+	} else if event.Error.Log != nil && event.Error.Log.Message != "" {
+		message = event.Error.Log.Message
+	}
+	if exceptionType == "" {
+		exceptionType = event.Error.Type
+	}
+	if exceptionType != "" {
+		attrs.PutStr(semconv.AttributeExceptionType, exceptionType)
+	}
+	if message != "" {
+		attrs.PutStr(semconv.AttributeExceptionMessage, message)
+		record.Body().SetStr(message)
+	}
+	if event.Error.StackTrace != "" {
+		attrs.PutStr(semconv.AttributeExceptionStacktrace, event.Error.StackTrace)
+	}
+	return true
+}
+
+func (c *converter) addLog(event *model.APMEvent) bool {
+	record := c.logRecord(event)
+	record.Body().SetStr(event.Message)
+	if event.Log.Level != "" {
+		record.SetSeverityText(event.Log.Level)
+	}
+	var spanID string
+	if event.Span != nil {
+		spanID = event.Span.ID
+	} else if event.Transaction != nil {
+		spanID = event.Transaction.ID
+	}
+	if spanID, ok := parseSpanID(spanID); ok {
+		record.SetSpanID(spanID)
+	}
+	return true
+}
+
+// logRecord appends a new log record for event, with its timestamp,
+// trace ID, and labels set.
+func (c *converter) logRecord(event *model.APMEvent) plog.LogRecord {
+	res := newResource(event)
+	records, ok := c.logRecords[res]
+	if !ok {
+		rl := c.logs.ResourceLogs().AppendEmpty()
+		res.copyTo(rl.Resource().Attributes())
+		sl := rl.ScopeLogs().AppendEmpty()
+		sl.Scope().SetName(scopeName)
+		records = sl.LogRecords()
+		c.logRecords[res] = records
+	}
+	record := records.AppendEmpty()
+	record.SetTimestamp(pcommon.NewTimestampFromTime(event.Timestamp))
+	if traceID, ok := parseTraceID(event.Trace.ID); ok {
+		record.SetTraceID(traceID)
+	}
+	putLabels(record.Attributes(), event)
+	return record
+}
+
+// addMetricset adds the samples of the metricset as gauges or sums.
+//
+// Metricsets without samples hold metrics aggregated by APM Server,
+// which are not exported. Histogram and summary samples are also not
+// exported.
+func (c *converter) addMetricset(event *model.APMEvent) bool {
+	var added bool
+	for _, sample := range event.Metricset.Samples {
+		if len(sample.Histogram.Values) > 0 || sample.SummaryMetric.Count > 0 {
+			continue
+		}
+		var dp pmetric.NumberDataPoint
+		switch sample.Type {
+		case model.MetricTypeHistogram, model.MetricTypeSummary:
+			continue
+		case model.MetricTypeCounter:
+			sum := c.metric(event, sample).SetEmptySum()
+			sum.SetIsMonotonic(true)
+			sum.SetAggregationTemporality(pmetric.AggregationTemporalityCumulative)
+			dp = sum.DataPoints().AppendEmpty()
+		default:
+			dp = c.metric(event, sample).SetEmptyGauge().DataPoints().AppendEmpty()
+		}
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(event.Timestamp))
+		dp.SetDoubleValue(sample.Value)
+		putLabels(dp.Attributes(), event)
+		added = true
+	}
+	if added {
+		c.metricEvents++
+	}
+	return added
+}
+
+func (c *converter) metric(event *model.APMEvent, sample model.MetricsetSample) pmetric.Metric {
+	res := newResource(event)
+	metrics, ok := c.metricList[res]
+	if !ok {
+		rm := c.metrics.ResourceMetrics().AppendEmpty()
+		res.copyTo(rm.Resource().Attributes())
+		sm := rm.ScopeMetrics().AppendEmpty()
+		sm.Scope().SetName(scopeName)
+		metrics = sm.Metrics()
+		c.metricList[res] = metrics
+	}
+	metric := metrics.AppendEmpty()
+	metric.SetName(sample.Name)
+	metric.SetUnit(sample.Unit)
+	return metric
+}
+
+// putLabels adds the event's labels to attrs.
+func putLabels(attrs pcommon.Map, event *model.APMEvent) {
+	for k, v := range event.Labels {
+		if v.Values != nil {
+			values := attrs.PutEmptySlice(k)
+			for _, value := range v.Values {
+				values.AppendEmpty().SetStr(value)
+			}
+		} else {
+			attrs.PutStr(k, v.Value)
+		}
+	}
+	for k, v := range event.NumericLabels {
+		if v.Values != nil {
+			values := attrs.PutEmptySlice(k)
+			for _, value := range v.Values {
+				values.AppendEmpty().SetDouble(value)
+			}
+		} else {
+			attrs.PutDouble(k, v.Value)
+		}
+	}
+	for k, v := range event.BooleanLabels {
+		if v.Values != nil {
+			values := attrs.PutEmptySlice(k)
+			for _, value := range v.Values {
+				values.AppendEmpty().SetBool(value)
+			}
+		} else {
+			attrs.PutBool(k, v.Value)
+		}
+	}
+}
+
+// resource holds the attributes identifying the source of an event,
+// which are exported as OTLP resource attributes.
+type resource struct {
+	serviceName        string
+	serviceVersion     string
+	serviceEnvironment string
+	serviceNodeName    string
+	languageName       string
+	agentName          string
+	agentVersion       string
+	hostname           string
+	containerID        string
+	kubernetesPodName  string
+	cloudProvider      string
+	cloudRegion        string
+}
+
+func newResource(event *model.APMEvent) resource {
+	return resource{
+		serviceName:        event.Service.Name,
+		serviceVersion:     event.Service.Version,
+		serviceEnvironment: event.Service.Environment,
+		serviceNodeName:    event.Service.Node.Name,
+		languageName:       event.Service.Language.Name,
+		agentName:          event.Agent.Name,
+		agentVersion:       event.Agent.Version,
+		hostname:           event.Host.Hostname,
+		containerID:        event.Container.ID,
+		kubernetesPodName:  event.Kubernetes.PodName,
+		cloudProvider:      event.Cloud.Provider,
+		cloudRegion:        event.Cloud.Region,
+	}
+}
+
+func (r resource) copyTo(attrs pcommon.Map) {
+	for _, attr := range [...]struct{ key, value string }{
+		{semconv.AttributeServiceName, r.serviceName},
+		{semconv.AttributeServiceVersion, r.serviceVersion},
+		{semconv.AttributeDeploymentEnvironment, r.serviceEnvironment},
+		{semconv.AttributeServiceInstanceID, r.serviceNodeName},
+		{semconv.AttributeTelemetrySDKLanguage, r.languageName},
+		{semconv.AttributeTelemetrySDKName, r.agentName},
+		{semconv.AttributeTelemetrySDKVersion, r.agentVersion},
+		{semconv.AttributeHostName, r.hostname},
+		{semconv.AttributeContainerID, r.containerID},
+		{semconv.AttributeK8SPodName, r.kubernetesPodName},
+		{semconv.AttributeCloudProvider, r.cloudProvider},
+		{semconv.AttributeCloudRegion, r.cloudRegion},
+	} {
+		if attr.value != "" {
+			attrs.PutStr(attr.key, attr.value)
+		}
+	}
+}
+
+// parseTraceID returns the OTLP trace ID for s, or false if s is empty.
+func parseTraceID(s string) (pcommon.TraceID, bool) {
+	var id pcommon.TraceID
+	if s == "" {
+		return id, false
+	}
+	if !parseID(id[:], s) {
+		h := fnv.New128a()
+		h.Write([]byte(s))
+		h.Sum(id[:0])
+	}
+	return id, true
+}
+
+// parseSpanID returns the OTLP span ID for s, or false if s is empty.
+func parseSpanID(s string) (pcommon.SpanID, bool) {
+	var id pcommon.SpanID
+	if s == "" {
+		return id, false
+	}
+	if !parseID(id[:], s) {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		h.Sum(id[:0])
+	}
+	return id, true
+}
+
+// parseID decodes the hex-encoded ID s into out, returning false if s is
+// not exactly as long as out once decoded. Intake events may have IDs of
+// any length, which are instead hashed to the length of OTLP IDs, so that
+// references between events remain consistent.
+func parseID(out []byte, s string) bool {
+	if len(s) != hex.EncodedLen(len(out)) {
+		return false
+	}
+	_, err := hex.Decode(out, []byte(s))
+	return err == nil
+}
@@ -0,0 +1,204 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package otlpoutput provides a model.BatchProcessor which forwards events
+// over OTLP/gRPC, to another APM Server or any other OTLP endpoint, rather
+// than indexing them into Elasticsearch.
+package otlpoutput
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// Config holds configuration for Output.
+type Config struct {
+	// Endpoint holds the gRPC address (host:port) of the OTLP endpoint.
+	Endpoint string
+
+	// TLS holds optional TLS configuration for connecting to the
+	// endpoint. If TLS is nil, connections are insecure.
+	TLS *tls.Config
+
+	// Headers holds optional headers to send with each request, e.g.
+	// "Authorization" for authenticating with another APM Server.
+	Headers map[string]string
+
+	// Timeout holds the maximum amount of time to wait for the events
+	// of a batch to be exported.
+	//
+	// If Timeout is zero, the default of 30 seconds will be used.
+	Timeout time.Duration
+}
+
+// Output is a model.BatchProcessor which converts events to OTLP traces,
+// logs, and metrics, and exports them to an OTLP/gRPC endpoint.
+//
+// Events are exported synchronously by ProcessBatch, so backpressure from
+// the endpoint is propagated to the clients sending the events.
+type Output struct {
+	conn    *grpc.ClientConn
+	traces  ptraceotlp.GRPCClient
+	logs    plogotlp.GRPCClient
+	metrics pmetricotlp.GRPCClient
+	headers metadata.MD
+	timeout time.Duration
+
+	requests  int64
+	forwarded int64
+	failed    int64
+	skipped   int64
+}
+
+// New returns a new Output with the given configuration.
+func New(cfg Config) (*Output, error) {
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint must be specified")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	creds := insecure.NewCredentials()
+	if cfg.TLS != nil {
+		creds = credentials.NewTLS(cfg.TLS)
+	}
+	// grpc.Dial does not block; connections are established lazily.
+	conn, err := grpc.Dial(cfg.Endpoint, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	return &Output{
+		conn:    conn,
+		traces:  ptraceotlp.NewGRPCClient(conn),
+		logs:    plogotlp.NewGRPCClient(conn),
+		metrics: pmetricotlp.NewGRPCClient(conn),
+		headers: metadata.New(cfg.Headers),
+		timeout: cfg.Timeout,
+	}, nil
+}
+
+// ProcessBatch exports the events in batch, returning once the endpoint
+// has accepted them, or an error if any of the export requests failed.
+//
+// Events which cannot be represented in OTLP, such as metrics aggregated
+// by APM Server, are not exported; they will be aggregated again by the
+// receiving APM Server.
+func (o *Output) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	c := newConverter()
+	for i := range *batch {
+		c.add(&(*batch)[i])
+	}
+	if c.skipped > 0 {
+		atomic.AddInt64(&o.skipped, int64(c.skipped))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	if len(o.headers) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, o.headers)
+	}
+	if n := c.traces.SpanCount(); n > 0 {
+		if _, err := o.traces.Export(ctx, ptraceotlp.NewExportRequestFromTraces(c.traces)); err != nil {
+			return o.exportFailed(n, "traces", err)
+		}
+		o.exported(n)
+	}
+	if n := c.logs.LogRecordCount(); n > 0 {
+		if _, err := o.logs.Export(ctx, plogotlp.NewExportRequestFromLogs(c.logs)); err != nil {
+			return o.exportFailed(n, "logs", err)
+		}
+		o.exported(n)
+	}
+	if n := c.metricEvents; n > 0 {
+		if _, err := o.metrics.Export(ctx, pmetricotlp.NewExportRequestFromMetrics(c.metrics)); err != nil {
+			return o.exportFailed(n, "metrics", err)
+		}
+		o.exported(n)
+	}
+	return nil
+}
+
+func (o *Output) exported(n int) {
+	atomic.AddInt64(&o.requests, 1)
+	atomic.AddInt64(&o.forwarded, int64(n))
+}
+
+func (o *Output) exportFailed(n int, signal string, err error) error {
+	atomic.AddInt64(&o.requests, 1)
+	atomic.AddInt64(&o.failed, int64(n))
+	return fmt.Errorf("failed to export %s: %w", signal, err)
+}
+
+// Close closes the connection to the endpoint.
+func (o *Output) Close(context.Context) error {
+	return o.conn.Close()
+}
+
+// Stats holds statistics about the events processed by Output.
+type Stats struct {
+	// Requests holds the number of export requests made.
+	Requests int64
+
+	// Forwarded holds the number of events accepted by the endpoint.
+	Forwarded int64
+
+	// Failed holds the number of events in export requests that failed.
+	Failed int64
+
+	// Skipped holds the number of events which were not exported,
+	// because they cannot be represented in OTLP.
+	Skipped int64
+}
+
+// Stats returns the current statistics.
+func (o *Output) Stats() Stats {
+	return Stats{
+		Requests:  atomic.LoadInt64(&o.requests),
+		Forwarded: atomic.LoadInt64(&o.forwarded),
+		Failed:    atomic.LoadInt64(&o.failed),
+		Skipped:   atomic.LoadInt64(&o.skipped),
+	}
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+func (o *Output) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	stats := o.Stats()
+	monitoring.ReportInt(V, "requests", stats.Requests)
+	monitoring.ReportInt(V, "forwarded", stats.Forwarded)
+	monitoring.ReportInt(V, "failed", stats.Failed)
+	monitoring.ReportInt(V, "skipped", stats.Skipped)
+}
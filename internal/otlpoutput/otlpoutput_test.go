@@ -0,0 +1,300 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package otlpoutput_test
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/plog"
+	"go.opentelemetry.io/collector/pdata/plog/plogotlp"
+	"go.opentelemetry.io/collector/pdata/pmetric"
+	"go.opentelemetry.io/collector/pdata/pmetric/pmetricotlp"
+	"go.opentelemetry.io/collector/pdata/ptrace"
+	"go.opentelemetry.io/collector/pdata/ptrace/ptraceotlp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/otlpoutput"
+	"github.com/elastic/apm-server/internal/processor/otel"
+)
+
+const (
+	traceID       = "0102030405060708090a0b0c0d0e0f10"
+	transactionID = "0102030405060708"
+	spanID        = "1112131415161718"
+)
+
+type receiver struct {
+	mu      sync.Mutex
+	traces  []ptrace.Traces
+	logs    []plog.Logs
+	metrics []pmetric.Metrics
+	headers []metadata.MD
+	err     error
+}
+
+func (r *receiver) record(ctx context.Context, f func()) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	r.headers = append(r.headers, md)
+	if r.err != nil {
+		return r.err
+	}
+	f()
+	return nil
+}
+
+type tracesReceiver struct{ *receiver }
+type logsReceiver struct{ *receiver }
+type metricsReceiver struct{ *receiver }
+
+func (r tracesReceiver) Export(ctx context.Context, req ptraceotlp.ExportRequest) (ptraceotlp.ExportResponse, error) {
+	return ptraceotlp.NewExportResponse(), r.record(ctx, func() { r.traces = append(r.traces, req.Traces()) })
+}
+
+func (r logsReceiver) Export(ctx context.Context, req plogotlp.ExportRequest) (plogotlp.ExportResponse, error) {
+	return plogotlp.NewExportResponse(), r.record(ctx, func() { r.logs = append(r.logs, req.Logs()) })
+}
+
+func (r metricsReceiver) Export(ctx context.Context, req pmetricotlp.ExportRequest) (pmetricotlp.ExportResponse, error) {
+	return pmetricotlp.NewExportResponse(), r.record(ctx, func() { r.metrics = append(r.metrics, req.Metrics()) })
+}
+
+func newReceiver(t testing.TB) (*receiver, string) {
+	lis, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	srv := grpc.NewServer()
+	r := &receiver{}
+	ptraceotlp.RegisterGRPCServer(srv, tracesReceiver{r})
+	plogotlp.RegisterGRPCServer(srv, logsReceiver{r})
+	pmetricotlp.RegisterGRPCServer(srv, metricsReceiver{r})
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return r, lis.Addr().String()
+}
+
+func newOutput(t testing.TB, cfg otlpoutput.Config) *otlpoutput.Output {
+	out, err := otlpoutput.New(cfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { out.Close(context.Background()) })
+	return out
+}
+
+func testBatch() model.Batch {
+	now := time.Now().Truncate(time.Millisecond)
+	base := model.APMEvent{
+		Timestamp: now,
+		Service:   model.Service{Name: "service_name", Environment: "production", Language: model.Language{Name: "go"}},
+		Agent:     model.Agent{Name: "go", Version: "2.0.0"},
+		Trace:     model.Trace{ID: traceID},
+		Labels:    model.Labels{"key": {Value: "value"}},
+	}
+	transaction := base
+	transaction.Processor = model.TransactionProcessor
+	transaction.Event.Duration = time.Second
+	transaction.Event.Outcome = "success"
+	transaction.Transaction = &model.Transaction{ID: transactionID, Name: "GET /", Type: "request"}
+
+	span := base
+	span.Processor = model.SpanProcessor
+	span.Parent.ID = transactionID
+	span.Event.Duration = time.Millisecond
+	span.Event.Outcome = "failure"
+	span.Span = &model.Span{
+		ID: spanID, Name: "SELECT FROM table", Type: "db", Subtype: "postgresql",
+		DB: &model.DB{Statement: "SELECT * FROM table"},
+	}
+
+	errorEvent := base
+	errorEvent.Processor = model.ErrorProcessor
+	errorEvent.Parent.ID = spanID
+	errorEvent.Error = &model.Error{Exception: &model.Exception{Type: "SQLError", Message: "boom"}}
+
+	logEvent := base
+	logEvent.Processor = model.LogProcessor
+	logEvent.Message = "hello"
+	logEvent.Log.Level = "info"
+
+	metricset := base
+	metricset.Processor = model.MetricsetProcessor
+	metricset.Metricset = &model.Metricset{Samples: []model.MetricsetSample{
+		{Name: "system.memory.total", Type: model.MetricTypeGauge, Value: 1024},
+		{Name: "requests", Type: model.MetricTypeCounter, Value: 5},
+	}}
+
+	// Metrics aggregated by APM Server have no samples.
+	aggregated := base
+	aggregated.Processor = model.MetricsetProcessor
+	aggregated.Metricset = &model.Metricset{Name: "transaction", DocCount: 1}
+
+	return model.Batch{transaction, span, errorEvent, logEvent, metricset, aggregated}
+}
+
+func TestOutput(t *testing.T) {
+	r, addr := newReceiver(t)
+	out := newOutput(t, otlpoutput.Config{
+		Endpoint: addr,
+		Headers:  map[string]string{"Authorization": "Bearer abc123"},
+	})
+
+	batch := testBatch()
+	require.NoError(t, out.ProcessBatch(context.Background(), &batch))
+	assert.Equal(t, otlpoutput.Stats{Requests: 3, Forwarded: 5, Skipped: 1}, out.Stats())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.Len(t, r.headers, 3)
+	for _, md := range r.headers {
+		assert.Equal(t, []string{"Bearer abc123"}, md.Get("authorization"))
+	}
+
+	require.Len(t, r.traces, 1)
+	require.Equal(t, 1, r.traces[0].ResourceSpans().Len())
+	rs := r.traces[0].ResourceSpans().At(0)
+	assert.Equal(t, map[string]interface{}{
+		"service.name":           "service_name",
+		"deployment.environment": "production",
+		"telemetry.sdk.language": "go",
+		"telemetry.sdk.name":     "go",
+		"telemetry.sdk.version":  "2.0.0",
+	}, rs.Resource().Attributes().AsRaw())
+	spans := rs.ScopeSpans().At(0).Spans()
+	require.Equal(t, 2, spans.Len())
+	assert.Equal(t, "GET /", spans.At(0).Name())
+	assert.Equal(t, ptrace.SpanKindServer, spans.At(0).Kind())
+	assert.Equal(t, ptrace.StatusCodeOk, spans.At(0).Status().Code())
+	assert.Equal(t, time.Second, spans.At(0).EndTimestamp().AsTime().Sub(spans.At(0).StartTimestamp().AsTime()))
+	assert.Equal(t, transactionID, spans.At(1).ParentSpanID().HexString())
+	assert.Equal(t, ptrace.StatusCodeError, spans.At(1).Status().Code())
+	assert.Equal(t, map[string]interface{}{
+		"key":          "value",
+		"db.system":    "postgresql",
+		"db.statement": "SELECT * FROM table",
+	}, spans.At(1).Attributes().AsRaw())
+
+	require.Len(t, r.logs, 1)
+	records := r.logs[0].ResourceLogs().At(0).ScopeLogs().At(0).LogRecords()
+	require.Equal(t, 2, records.Len())
+	assert.Equal(t, "boom", records.At(0).Body().Str())
+	assert.Equal(t, spanID, records.At(0).SpanID().HexString())
+	assert.Equal(t, map[string]interface{}{
+		"key":               "value",
+		"exception.type":    "SQLError",
+		"exception.message": "boom",
+	}, records.At(0).Attributes().AsRaw())
+	assert.Equal(t, "hello", records.At(1).Body().Str())
+	assert.Equal(t, "info", records.At(1).SeverityText())
+
+	require.Len(t, r.metrics, 1)
+	metrics := r.metrics[0].ResourceMetrics().At(0).ScopeMetrics().At(0).Metrics()
+	require.Equal(t, 2, metrics.Len())
+	assert.Equal(t, "system.memory.total", metrics.At(0).Name())
+	assert.Equal(t, float64(1024), metrics.At(0).Gauge().DataPoints().At(0).DoubleValue())
+	assert.Equal(t, "requests", metrics.At(1).Name())
+	assert.True(t, metrics.At(1).Sum().IsMonotonic())
+}
+
+func TestOutputRoundTrip(t *testing.T) {
+	// Traces exported by Output are received by APM Server as
+	// the same transactions and spans.
+	r, addr := newReceiver(t)
+	out := newOutput(t, otlpoutput.Config{Endpoint: addr})
+	batch := testBatch()[:2]
+	require.NoError(t, out.ProcessBatch(context.Background(), &batch))
+
+	var received model.Batch
+	consumer := &otel.Consumer{Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		received = append(received, *batch...)
+		return nil
+	})}
+	r.mu.Lock()
+	require.Len(t, r.traces, 1)
+	require.NoError(t, consumer.ConsumeTraces(context.Background(), r.traces[0]))
+	r.mu.Unlock()
+
+	require.Len(t, received, 2)
+	assert.Equal(t, model.TransactionProcessor, received[0].Processor)
+	assert.Equal(t, transactionID, received[0].Transaction.ID)
+	assert.Equal(t, "GET /", received[0].Transaction.Name)
+	assert.Equal(t, "success", received[0].Event.Outcome)
+	assert.Equal(t, "service_name", received[0].Service.Name)
+	assert.Equal(t, model.SpanProcessor, received[1].Processor)
+	assert.Equal(t, spanID, received[1].Span.ID)
+	assert.Equal(t, transactionID, received[1].Parent.ID)
+	assert.Equal(t, traceID, received[1].Trace.ID)
+	assert.Equal(t, "db", received[1].Span.Type)
+}
+
+func TestOutputNonHexIDs(t *testing.T) {
+	r, addr := newReceiver(t)
+	out := newOutput(t, otlpoutput.Config{Endpoint: addr})
+	batch := testBatch()[:2]
+	batch[0].Trace.ID = "trace"
+	batch[0].Transaction.ID = "99"
+	batch[1].Trace.ID = "trace"
+	batch[1].Parent.ID = "99"
+	require.NoError(t, out.ProcessBatch(context.Background(), &batch))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	require.Len(t, r.traces, 1)
+	spans := r.traces[0].ResourceSpans().At(0).ScopeSpans().At(0).Spans()
+	require.Equal(t, 2, spans.Len())
+	assert.False(t, spans.At(0).SpanID().IsEmpty())
+	assert.Equal(t, spans.At(0).SpanID(), spans.At(1).ParentSpanID())
+	assert.Equal(t, spans.At(0).TraceID(), spans.At(1).TraceID())
+}
+
+func TestOutputExportFailed(t *testing.T) {
+	r, addr := newReceiver(t)
+	r.err = status.Error(codes.Unavailable, "unavailable")
+	out := newOutput(t, otlpoutput.Config{Endpoint: addr})
+
+	batch := testBatch()
+	err := out.ProcessBatch(context.Background(), &batch)
+	assert.ErrorContains(t, err, "failed to export traces")
+	assert.ErrorContains(t, err, "unavailable")
+	assert.Equal(t, otlpoutput.Stats{Requests: 1, Failed: 2, Skipped: 1}, out.Stats())
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "otlp", out.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"otlp.requests":  1,
+		"otlp.forwarded": 0,
+		"otlp.failed":    2,
+		"otlp.skipped":   1,
+	}, snapshot.Ints)
+}
+
+func TestNewOutputNoEndpoint(t *testing.T) {
+	_, err := otlpoutput.New(otlpoutput.Config{})
+	assert.EqualError(t, err, "endpoint must be specified")
+}
@@ -0,0 +1,211 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package outbound instruments the HTTP clients with which APM Server
+// sends requests to other services, such as Elasticsearch and Kibana,
+// recording consistent metrics for each under "apm-server.outbound".
+package outbound
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+)
+
+// Names of the instrumented clients, under which their metrics are recorded.
+const (
+	// Elasticsearch identifies Elasticsearch clients, used for bulk
+	// indexing, tail-based sampling, source maps, and other queries.
+	Elasticsearch = "elasticsearch"
+
+	// Kibana identifies the Kibana client, used for agent configuration,
+	// source maps, and other Kibana APIs.
+	Kibana = "kibana"
+
+	// FleetServer identifies the client used for fetching source maps
+	// from Fleet Server.
+	FleetServer = "fleet_server"
+
+	// Webhook identifies the client used for sending alert webhooks.
+	Webhook = "webhook"
+)
+
+var (
+	clientsMu sync.Mutex
+	clients   = make(map[string]*Metrics)
+)
+
+func init() {
+	monitoring.NewFunc(monitoring.Default, "apm-server.outbound", collect, monitoring.Report)
+}
+
+// Metrics records metrics for the requests sent by an outbound HTTP client.
+type Metrics struct {
+	requests     int64
+	errors       int64
+	retries      int64
+	duration     int64
+	lastDuration int64
+
+	// responses holds the number of responses by status class,
+	// indexed by the first digit of the status code, minus one.
+	responses [5]int64
+}
+
+// Stats holds a snapshot of a client's Metrics.
+type Stats struct {
+	// Requests holds the number of requests sent, including retries.
+	Requests int64
+
+	// Errors holds the number of requests that failed without a
+	// response, e.g. due to a connection error or timeout.
+	Errors int64
+
+	// Retries holds the number of times a request was retried.
+	Retries int64
+
+	// Responses holds the number of responses received, keyed by status
+	// class: "1xx", "2xx", "3xx", "4xx", or "5xx".
+	Responses map[string]int64
+
+	// Duration holds the total time spent waiting for responses, from
+	// sending a request until its response headers are received.
+	Duration time.Duration
+
+	// LastDuration holds the time spent waiting for the last response.
+	LastDuration time.Duration
+}
+
+// ClientMetrics returns the Metrics for the named client, creating them on
+// first use. Clients with the same name share their Metrics.
+func ClientMetrics(name string) *Metrics {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	m, ok := clients[name]
+	if !ok {
+		m = &Metrics{}
+		clients[name] = m
+	}
+	return m
+}
+
+// WrapRoundTripper returns an http.RoundTripper which sends requests with
+// rt, recording metrics for the named client.
+func WrapRoundTripper(name string, rt http.RoundTripper) http.RoundTripper {
+	return ClientMetrics(name).WrapRoundTripper(rt)
+}
+
+// WrapRoundTripper returns an http.RoundTripper which sends requests with
+// rt, recording metrics in m. If rt is nil, http.DefaultTransport is used.
+func (m *Metrics) WrapRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	return &roundTripper{next: rt, metrics: m}
+}
+
+// RecordRetry records that a request is being retried. Clients which retry
+// requests themselves should call RecordRetry before each retry.
+func (m *Metrics) RecordRetry() {
+	atomic.AddInt64(&m.retries, 1)
+}
+
+// Stats returns a snapshot of m.
+func (m *Metrics) Stats() Stats {
+	stats := Stats{
+		Requests:     atomic.LoadInt64(&m.requests),
+		Errors:       atomic.LoadInt64(&m.errors),
+		Retries:      atomic.LoadInt64(&m.retries),
+		Duration:     time.Duration(atomic.LoadInt64(&m.duration)),
+		LastDuration: time.Duration(atomic.LoadInt64(&m.lastDuration)),
+	}
+	for i := range m.responses {
+		if n := atomic.LoadInt64(&m.responses[i]); n > 0 {
+			if stats.Responses == nil {
+				stats.Responses = make(map[string]int64)
+			}
+			stats.Responses[statusClass(i)] = n
+		}
+	}
+	return stats
+}
+
+func (m *Metrics) record(resp *http.Response, err error, duration time.Duration) {
+	atomic.AddInt64(&m.requests, 1)
+	atomic.AddInt64(&m.duration, int64(duration))
+	atomic.StoreInt64(&m.lastDuration, int64(duration))
+	if err != nil {
+		atomic.AddInt64(&m.errors, 1)
+		return
+	}
+	if class := resp.StatusCode/100 - 1; class >= 0 && class < len(m.responses) {
+		atomic.AddInt64(&m.responses[class], 1)
+	}
+}
+
+func statusClass(i int) string {
+	return strconv.Itoa(i+1) + "xx"
+}
+
+type roundTripper struct {
+	next    http.RoundTripper
+	metrics *Metrics
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := r.next.RoundTrip(req)
+	r.metrics.record(resp, err, time.Since(start))
+	return resp, err
+}
+
+func collect(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	clientsMu.Lock()
+	names := make([]string, 0, len(clients))
+	for name := range clients {
+		names = append(names, name)
+	}
+	clientsMu.Unlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		stats := ClientMetrics(name).Stats()
+		monitoring.ReportNamespace(V, name, func() {
+			monitoring.ReportNamespace(V, "requests", func() {
+				monitoring.ReportInt(V, "count", stats.Requests)
+				monitoring.ReportInt(V, "errors", stats.Errors)
+				monitoring.ReportInt(V, "retries", stats.Retries)
+				monitoring.ReportInt(V, "duration.ms", stats.Duration.Milliseconds())
+				monitoring.ReportInt(V, "last_duration.ms", stats.LastDuration.Milliseconds())
+			})
+			monitoring.ReportNamespace(V, "responses", func() {
+				for i := 0; i < len(Metrics{}.responses); i++ {
+					class := statusClass(i)
+					monitoring.ReportInt(V, class, stats.Responses[class])
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package outbound_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/outbound"
+)
+
+func TestWrapRoundTripper(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: outbound.WrapRoundTripper("test", nil)}
+	for _, path := range []string{"/", "/", "/error"} {
+		resp, err := client.Get(srv.URL + path)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	_, err := client.Get(closed.URL)
+	require.Error(t, err)
+	metrics := outbound.ClientMetrics("test")
+	metrics.RecordRetry()
+
+	stats := metrics.Stats()
+	assert.Equal(t, int64(4), stats.Requests)
+	assert.Equal(t, int64(1), stats.Errors)
+	assert.Equal(t, int64(1), stats.Retries)
+	assert.Equal(t, map[string]int64{"2xx": 2, "5xx": 1}, stats.Responses)
+	assert.NotZero(t, stats.Duration)
+	assert.NotZero(t, stats.LastDuration)
+
+	snapshot := monitoring.CollectStructSnapshot(monitoring.Default.GetRegistry("apm-server"), monitoring.Full, false)
+	assert.Equal(t, map[string]interface{}{
+		"1xx": int64(0),
+		"2xx": int64(2),
+		"3xx": int64(0),
+		"4xx": int64(0),
+		"5xx": int64(1),
+	}, snapshot["outbound"].(map[string]interface{})["test"].(map[string]interface{})["responses"])
+	requests := snapshot["outbound"].(map[string]interface{})["test"].(map[string]interface{})["requests"].(map[string]interface{})
+	assert.Equal(t, int64(4), requests["count"])
+	assert.Equal(t, int64(1), requests["errors"])
+	assert.Equal(t, int64(1), requests["retries"])
+}
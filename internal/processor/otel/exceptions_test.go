@@ -117,6 +117,7 @@ Caused by: LowLevelException
 		Timestamp:     timestamp,
 		Labels:        model.Labels{},
 		NumericLabels: model.NumericLabels{},
+		BooleanLabels: model.BooleanLabels{},
 		Processor:     model.ErrorProcessor,
 		Trace:         transactionEvent.Trace,
 		Parent:        model.Parent{ID: transactionEvent.Transaction.ID},
@@ -169,6 +170,7 @@ Caused by: LowLevelException
 		Timestamp:     timestamp,
 		Labels:        model.Labels{},
 		NumericLabels: model.NumericLabels{},
+		BooleanLabels: model.BooleanLabels{},
 		Processor:     model.ErrorProcessor,
 		Trace:         transactionEvent.Trace,
 		Parent:        model.Parent{ID: transactionEvent.Transaction.ID},
@@ -327,6 +329,7 @@ func TestEncodeSpanEventsNonJavaExceptions(t *testing.T) {
 		Timestamp:     timestamp,
 		Labels:        model.Labels{},
 		NumericLabels: model.NumericLabels{},
+		BooleanLabels: model.BooleanLabels{},
 		Processor:     model.ErrorProcessor,
 		Trace:         transactionEvent.Trace,
 		Parent:        model.Parent{ID: transactionEvent.Transaction.ID},
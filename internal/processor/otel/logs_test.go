@@ -79,6 +79,7 @@ func TestConsumerConsumeLogs(t *testing.T) {
 		Trace:         model.Trace{ID: "01000000000000000000000000000000"},
 		Labels:        model.Labels{},
 		NumericLabels: model.NumericLabels{},
+		BooleanLabels: model.BooleanLabels{},
 	}
 	test := func(name string, body interface{}, expectedMessage string) {
 		t.Run(name, func(t *testing.T) {
@@ -21,7 +21,6 @@ import (
 	"fmt"
 	"net/netip"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"go.opentelemetry.io/collector/pdata/pcommon"
@@ -154,6 +153,9 @@ func translateResourceMetadata(resource pcommon.Resource, out *model.APMEvent) {
 			if out.NumericLabels == nil {
 				out.NumericLabels = make(model.NumericLabels)
 			}
+			if out.BooleanLabels == nil {
+				out.BooleanLabels = make(model.BooleanLabels)
+			}
 			setLabel(replaceDots(k), out, ifaceAttributeValue(v))
 		}
 		return true
@@ -235,7 +237,7 @@ func ifaceAttributeValue(v pcommon.Value) interface{} {
 	case pcommon.ValueTypeStr:
 		return truncate(v.Str())
 	case pcommon.ValueTypeBool:
-		return strconv.FormatBool(v.Bool())
+		return v.Bool()
 	case pcommon.ValueTypeInt:
 		return float64(v.Int())
 	case pcommon.ValueTypeDouble:
@@ -258,6 +260,7 @@ func ifaceAttributeValueSlice(slice pcommon.Slice) []interface{} {
 func initEventLabels(e *model.APMEvent) {
 	e.Labels = e.Labels.Clone()
 	e.NumericLabels = e.NumericLabels.Clone()
+	e.BooleanLabels = e.BooleanLabels.Clone()
 }
 
 func setLabel(key string, event *model.APMEvent, v interface{}) {
@@ -265,7 +268,7 @@ func setLabel(key string, event *model.APMEvent, v interface{}) {
 	case string:
 		event.Labels.Set(key, v)
 	case bool:
-		event.Labels.Set(key, strconv.FormatBool(v))
+		event.BooleanLabels.Set(key, v)
 	case float64:
 		event.NumericLabels.Set(key, v)
 	case int64:
@@ -287,6 +290,12 @@ func setLabel(key string, event *model.APMEvent, v interface{}) {
 				value[i] = v[i].(float64)
 			}
 			event.NumericLabels.SetSlice(key, value)
+		case bool:
+			value := make([]bool, len(v))
+			for i := range v {
+				value[i] = v[i].(bool)
+			}
+			event.BooleanLabels.SetSlice(key, value)
 		}
 	}
 }
@@ -236,7 +236,12 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 	if b.freeMemoryBytes.value > 0 {
 		ms.upsertOne(
 			b.freeMemoryBytes.timestamp, pcommon.NewMap(),
-			model.MetricsetSample{Name: "system.memory.actual.free", Value: b.freeMemoryBytes.value},
+			model.MetricsetSample{
+				Name:  "system.memory.actual.free",
+				Type:  model.MetricTypeGauge,
+				Unit:  "byte",
+				Value: b.freeMemoryBytes.value,
+			},
 		)
 	}
 	// system.memory.total
@@ -245,7 +250,12 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 	if totalMemoryBytes > 0 {
 		ms.upsertOne(
 			b.freeMemoryBytes.timestamp, pcommon.NewMap(),
-			model.MetricsetSample{Name: "system.memory.total", Value: totalMemoryBytes},
+			model.MetricsetSample{
+				Name:  "system.memory.total",
+				Type:  model.MetricTypeGauge,
+				Unit:  "byte",
+				Value: totalMemoryBytes,
+			},
 		)
 	}
 	// system.cpu.total.norm.pct
@@ -253,7 +263,12 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 	if b.nonIdleCPUUtilizationSum.value > 0 && b.cpuCount > 0 {
 		ms.upsertOne(
 			b.nonIdleCPUUtilizationSum.timestamp, pcommon.NewMap(),
-			model.MetricsetSample{Name: "system.cpu.total.norm.pct", Value: b.nonIdleCPUUtilizationSum.value / float64(b.cpuCount)},
+			model.MetricsetSample{
+				Name:  "system.cpu.total.norm.pct",
+				Type:  model.MetricTypeGauge,
+				Unit:  "percent",
+				Value: b.nonIdleCPUUtilizationSum.value / float64(b.cpuCount),
+			},
 		)
 	}
 	// jvm.gc.time
@@ -263,7 +278,12 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 		elasticapmAttributes.PutStr("name", k)
 		ms.upsertOne(
 			v.timestamp, elasticapmAttributes,
-			model.MetricsetSample{Name: "jvm.gc.time", Value: v.value},
+			model.MetricsetSample{
+				Name:  "jvm.gc.time",
+				Type:  model.MetricTypeGauge,
+				Unit:  "ms",
+				Value: v.value,
+			},
 		)
 	}
 	// jvm.gc.count
@@ -273,7 +293,11 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 		elasticapmAttributes.PutStr("name", k)
 		ms.upsertOne(
 			v.timestamp, elasticapmAttributes,
-			model.MetricsetSample{Name: "jvm.gc.count", Value: v.value},
+			model.MetricsetSample{
+				Name:  "jvm.gc.count",
+				Type:  model.MetricTypeGauge,
+				Value: v.value,
+			},
 		)
 	}
 	// jvm.memory.<area>.<type>
@@ -289,7 +313,12 @@ func (b *apmMetricsBuilder) emit(ms metricsets) {
 		}
 		ms.upsertOne(
 			v.timestamp, elasticapmAttributes,
-			model.MetricsetSample{Name: elasticapmMetricName, Value: v.value},
+			model.MetricsetSample{
+				Name:  elasticapmMetricName,
+				Type:  model.MetricTypeGauge,
+				Unit:  "byte",
+				Value: v.value,
+			},
 		)
 	}
 }
@@ -332,6 +361,9 @@ func (c *Consumer) convertScopeMetrics(
 			if len(event.NumericLabels) == 0 {
 				event.NumericLabels = nil
 			}
+			if len(event.BooleanLabels) == 0 {
+				event.BooleanLabels = nil
+			}
 		}
 		*out = append(*out, event)
 	}
@@ -341,7 +373,11 @@ func (c *Consumer) convertScopeMetrics(
 }
 
 func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
-	// TODO(axw) support units
+	name, ok := metricName(metric.Name())
+	if !ok {
+		return false
+	}
+	unit := metricUnit(metric.Unit())
 	anyDropped := false
 	switch metric.Type() {
 	case pmetric.MetricTypeGauge:
@@ -349,7 +385,8 @@ func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
 		for i := 0; i < dps.Len(); i++ {
 			dp := dps.At(i)
 			if sample, ok := numberSample(dp, model.MetricTypeGauge); ok {
-				sample.Name = metric.Name()
+				sample.Name = name
+				sample.Unit = unit
 				ms.upsert(dp.Timestamp().AsTime(), dp.Attributes(), sample)
 			} else {
 				anyDropped = true
@@ -361,7 +398,8 @@ func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
 		for i := 0; i < dps.Len(); i++ {
 			dp := dps.At(i)
 			if sample, ok := numberSample(dp, model.MetricTypeCounter); ok {
-				sample.Name = metric.Name()
+				sample.Name = name
+				sample.Unit = unit
 				ms.upsert(dp.Timestamp().AsTime(), dp.Attributes(), sample)
 			} else {
 				anyDropped = true
@@ -373,7 +411,8 @@ func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
 		for i := 0; i < dps.Len(); i++ {
 			dp := dps.At(i)
 			if sample, ok := histogramSample(dp.BucketCounts(), dp.ExplicitBounds()); ok {
-				sample.Name = metric.Name()
+				sample.Name = name
+				sample.Unit = unit
 				ms.upsert(dp.Timestamp().AsTime(), dp.Attributes(), sample)
 			} else {
 				anyDropped = true
@@ -384,7 +423,8 @@ func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
 		for i := 0; i < dps.Len(); i++ {
 			dp := dps.At(i)
 			sample := summarySample(dp)
-			sample.Name = metric.Name()
+			sample.Name = name
+			sample.Unit = unit
 			ms.upsert(dp.Timestamp().AsTime(), dp.Attributes(), sample)
 		}
 	default:
@@ -394,6 +434,43 @@ func (c *Consumer) addMetric(metric pmetric.Metric, ms metricsets) bool {
 	return !anyDropped
 }
 
+// metricName returns name with any empty path segments removed, such
+// that it may be used as an Elasticsearch field name, e.g. "a..b." is
+// returned as "a.b". If name has no non-empty segments, metricName
+// returns false.
+func metricName(name string) (string, bool) {
+	if !strings.HasPrefix(name, ".") && !strings.HasSuffix(name, ".") && !strings.Contains(name, "..") {
+		return name, name != ""
+	}
+	segments := strings.FieldsFunc(name, func(r rune) bool { return r == '.' })
+	if len(segments) == 0 {
+		return "", false
+	}
+	return strings.Join(segments, "."), true
+}
+
+// metricUnit translates an OpenTelemetry metric unit, expressed using
+// the Unified Code for Units of Measure, to its Elastic APM equivalent.
+// Units with no equivalent are translated to the empty string.
+//
+// Note that "%" is not translated to "percent", as Elastic APM percent
+// values are expected to be in the range [0,1].
+func metricUnit(unit string) string {
+	switch unit {
+	case "By":
+		return "byte"
+	case "ns":
+		return "nanos"
+	case "us":
+		return "micros"
+	case "ms", "s", "h", "d":
+		return unit
+	case "min":
+		return "m"
+	}
+	return ""
+}
+
 func numberSample(dp pmetric.NumberDataPoint, metricType model.MetricType) (model.MetricsetSample, bool) {
 	var value float64
 	switch dp.ValueType() {
@@ -234,6 +234,51 @@ func TestConsumeMetricsNaN(t *testing.T) {
 	assert.Empty(t, events)
 }
 
+func TestConsumeMetricsUnitsAndNames(t *testing.T) {
+	timestamp := time.Unix(123, 0).UTC()
+	metrics := pmetric.NewMetrics()
+	resourceMetrics := metrics.ResourceMetrics().AppendEmpty()
+	scopeMetrics := resourceMetrics.ScopeMetrics().AppendEmpty()
+	metricSlice := scopeMetrics.Metrics()
+	appendGauge := func(name, unit string) {
+		metric := metricSlice.AppendEmpty()
+		metric.SetName(name)
+		metric.SetUnit(unit)
+		dp := metric.SetEmptyGauge().DataPoints().AppendEmpty()
+		dp.SetTimestamp(pcommon.NewTimestampFromTime(timestamp))
+		dp.SetIntValue(1)
+	}
+	appendGauge("bytes", "By")
+	appendGauge("nanos", "ns")
+	appendGauge("micros", "us")
+	appendGauge("millis", "ms")
+	appendGauge("minutes", "min")
+	appendGauge("percent", "%")
+	appendGauge("requests", "{requests}")
+	appendGauge(".leading..and.trailing.", "s")
+	appendGauge("..", "")
+
+	events, stats := transformMetrics(t, metrics)
+	assert.Equal(t, int64(1), stats.UnsupportedMetricsDropped)
+	require.Len(t, events, 1)
+
+	units := make(map[string]string)
+	for _, sample := range events[0].Metricset.Samples {
+		assert.Equal(t, model.MetricTypeGauge, sample.Type)
+		units[sample.Name] = sample.Unit
+	}
+	assert.Equal(t, map[string]string{
+		"bytes":                "byte",
+		"nanos":                "nanos",
+		"micros":               "micros",
+		"millis":               "ms",
+		"minutes":              "m",
+		"percent":              "",
+		"requests":             "",
+		"leading.and.trailing": "s",
+	}, units)
+}
+
 func TestConsumeMetricsHostCPU(t *testing.T) {
 	metrics := pmetric.NewMetrics()
 	resourceMetrics := metrics.ResourceMetrics().AppendEmpty()
@@ -495,6 +540,8 @@ func TestConsumeMetricsHostCPU(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "system.cpu.total.norm.pct",
+					Type:  "gauge",
+					Unit:  "percent",
 					Value: 0.39000000000000007,
 				},
 			},
@@ -568,10 +615,14 @@ func TestConsumeMetricsHostMemory(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "system.memory.actual.free",
+					Type:  "gauge",
+					Unit:  "byte",
 					Value: 4773351424,
 				},
 				{
 					Name:  "system.memory.total",
+					Type:  "gauge",
+					Unit:  "byte",
 					Value: 8337129472,
 				},
 			},
@@ -659,10 +710,13 @@ func TestConsumeMetrics_JVM(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "jvm.gc.time",
+					Type:  "gauge",
+					Unit:  "ms",
 					Value: 9,
 				},
 				{
 					Name:  "jvm.gc.count",
+					Type:  "gauge",
 					Value: 2,
 				},
 			},
@@ -691,6 +745,8 @@ func TestConsumeMetrics_JVM(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "jvm.memory.heap.used",
+					Type:  "gauge",
+					Unit:  "byte",
 					Value: 42,
 				},
 			},
@@ -720,6 +776,8 @@ func TestConsumeMetrics_JVM(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "jvm.memory.heap.pool.used",
+					Type:  "gauge",
+					Unit:  "byte",
 					Value: 24,
 				},
 			},
@@ -749,6 +807,8 @@ func TestConsumeMetrics_JVM(t *testing.T) {
 			Samples: []model.MetricsetSample{
 				{
 					Name:  "jvm.memory.heap.pool.max",
+					Type:  "gauge",
+					Unit:  "byte",
 					Value: 20000,
 				},
 			},
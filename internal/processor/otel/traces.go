@@ -230,11 +230,15 @@ func (c *Consumer) convertSpan(
 	if len(event.NumericLabels) == 0 {
 		event.NumericLabels = nil
 	}
+	if len(event.BooleanLabels) == 0 {
+		event.BooleanLabels = nil
+	}
 	*out = append(*out, event)
 
 	events := otelSpan.Events()
 	event.Labels = baseEvent.Labels               // only copy common labels to span events
 	event.NumericLabels = baseEvent.NumericLabels // only copy common labels to span events
+	event.BooleanLabels = baseEvent.BooleanLabels // only copy common labels to span events
 	event.Event = model.Event{}                   // don't copy event.* to span events
 	event.Destination = model.Destination{}       // don't set destination for span events
 	for i := 0; i < events.Len(); i++ {
@@ -545,7 +549,7 @@ func TranslateSpan(spanKind ptrace.SpanKind, attributes pcommon.Map, event *mode
 				messageTempDestination = v.Bool()
 				fallthrough
 			default:
-				setLabel(k, event, strconv.FormatBool(v.Bool()))
+				setLabel(k, event, v.Bool())
 			}
 		case pcommon.ValueTypeDouble:
 			setLabel(k, event, v.Double())
@@ -862,7 +866,7 @@ func parseSamplerAttributes(samplerType, samplerParam pcommon.Value, event *mode
 		event.Labels.Set("sampler_type", samplerType)
 		switch samplerParam.Type() {
 		case pcommon.ValueTypeBool:
-			event.Labels.Set("sampler_param", strconv.FormatBool(samplerParam.Bool()))
+			event.BooleanLabels.Set("sampler_param", samplerParam.Bool())
 		case pcommon.ValueTypeDouble:
 			event.NumericLabels.Set("sampler_param", samplerParam.Double())
 		}
@@ -925,6 +929,14 @@ func convertSpanEvent(
 	} else {
 		event.Processor = model.LogProcessor
 		event.Message = spanEvent.Name()
+		// Record the span as the parent of the log event, so the
+		// event can be associated with the span, e.g. for applying
+		// tail-based sampling decisions.
+		if parent.Transaction != nil {
+			event.Parent.ID = parent.Transaction.ID
+		} else if parent.Span != nil {
+			event.Parent.ID = parent.Span.ID
+		}
 		spanEvent.Attributes().Range(func(k string, v pcommon.Value) bool {
 			k = replaceDots(k)
 			if isJaeger && k == "message" {
@@ -674,13 +674,15 @@ func TestArrayLabels(t *testing.T) {
 		"float_array":  floatArray,
 	})
 	assert.Equal(t, model.Labels{
-		"bool_array":   {Values: []string{"false", "true"}},
 		"string_array": {Values: []string{"string1", "string2"}},
 	}, txEvent.Labels)
 	assert.Equal(t, model.NumericLabels{
 		"int_array":   {Values: []float64{1234, 5678}},
 		"float_array": {Values: []float64{1234.5678, 9123.234123123}},
 	}, txEvent.NumericLabels)
+	assert.Equal(t, model.BooleanLabels{
+		"bool_array": {Values: []bool{false, true}},
+	}, txEvent.BooleanLabels)
 
 	spanEvent := transformSpanWithAttributes(t, map[string]interface{}{
 		"string_array": stringArray,
@@ -689,13 +691,15 @@ func TestArrayLabels(t *testing.T) {
 		"float_array":  floatArray,
 	})
 	assert.Equal(t, model.Labels{
-		"bool_array":   {Values: []string{"false", "true"}},
 		"string_array": {Values: []string{"string1", "string2"}},
 	}, spanEvent.Labels)
 	assert.Equal(t, model.NumericLabels{
 		"int_array":   {Values: []float64{1234, 5678}},
 		"float_array": {Values: []float64{1234.5678, 9123.234123123}},
 	}, spanEvent.NumericLabels)
+	assert.Equal(t, model.BooleanLabels{
+		"bool_array": {Values: []bool{false, true}},
+	}, spanEvent.BooleanLabels)
 }
 
 func TestConsumeTracesExportTimestamp(t *testing.T) {
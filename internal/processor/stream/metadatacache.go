@@ -0,0 +1,95 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package stream
+
+import (
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/decoder"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// defaultMetadataCacheExpiration is the expiration used for cached
+// metadata when MetadataCacheConfig.Expiration is unspecified.
+const defaultMetadataCacheExpiration = time.Minute
+
+type decodeCacheableMetadataFunc func(decoder.Decoder) (func(*model.APMEvent), error)
+
+// MetadataCacheConfig holds configuration for caching decoded metadata.
+type MetadataCacheConfig struct {
+	// Size holds the maximum number of distinct metadata objects cached.
+	// If Size is zero, metadata is not cached.
+	Size int
+
+	// Expiration holds the amount of time after which cached metadata
+	// is evicted.
+	Expiration time.Duration
+
+	// Hits and Misses, if non-nil, are incremented for each metadata
+	// object found and not found in the cache, respectively.
+	Hits   *monitoring.Int
+	Misses *monitoring.Int
+}
+
+// metadataCache holds decoded and validated metadata, keyed by the raw
+// metadata line, for reuse by successive requests carrying identical
+// metadata, such as those sent by RUM agents during a page session.
+type metadataCache struct {
+	cache  *gocache.Cache
+	size   int
+	hits   *monitoring.Int
+	misses *monitoring.Int
+}
+
+func newMetadataCache(cfg MetadataCacheConfig) *metadataCache {
+	expiration := cfg.Expiration
+	if expiration <= 0 {
+		expiration = defaultMetadataCacheExpiration
+	}
+	return &metadataCache{
+		cache:  gocache.New(expiration, expiration),
+		size:   cfg.Size,
+		hits:   cfg.Hits,
+		misses: cfg.Misses,
+	}
+}
+
+func (c *metadataCache) get(line []byte) (func(*model.APMEvent), bool) {
+	value, ok := c.cache.Get(string(line))
+	if !ok {
+		if c.misses != nil {
+			c.misses.Inc()
+		}
+		return nil, false
+	}
+	if c.hits != nil {
+		c.hits.Inc()
+	}
+	return value.(func(*model.APMEvent)), true
+}
+
+func (c *metadataCache) add(line []byte, apply func(*model.APMEvent)) {
+	if c.cache.ItemCount() >= c.size {
+		return
+	}
+	c.cache.SetDefault(string(line), apply)
+}
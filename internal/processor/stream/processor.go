@@ -15,6 +15,12 @@
 // specific language governing permissions and limitations
 // under the License.
 
+// Package stream decodes intake ND-JSON streams, consisting of a metadata
+// object followed by events, into batches of model.APMEvent.
+//
+// Processor.HandleStream is used by the intake API, while DecodeStream
+// provides the decoding on its own, for tools and tests which need to
+// decode intake payloads exactly as the server does.
 package stream
 
 import (
@@ -22,25 +28,33 @@ import (
 	"context"
 	"io"
 	"sync"
+	"time"
 
 	"go.elastic.co/apm/v2"
 
+	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 
 	"github.com/elastic/apm-server/internal/decoder"
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/apm-server/internal/model"
 	"github.com/elastic/apm-server/internal/model/modeldecoder"
+	"github.com/elastic/apm-server/internal/model/modeldecoder/nullable"
 	"github.com/elastic/apm-server/internal/model/modeldecoder/rumv3"
 	v2 "github.com/elastic/apm-server/internal/model/modeldecoder/v2"
 	"github.com/elastic/apm-server/internal/publish"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 var (
 	errUnrecognizedObject = errors.New("did not recognize object type")
 )
 
+// coercionLogInterval is the minimum interval between logging
+// values coerced when decoding leniently.
+const coercionLogInterval = time.Minute
+
 const (
 	errorEventType            = "error"
 	metricsetEventType        = "metricset"
@@ -53,6 +67,8 @@ const (
 
 type decodeMetadataFunc func(decoder.Decoder, *model.APMEvent) error
 
+type decodeEventFunc func(decoder.Decoder, *modeldecoder.Input, *model.Batch) error
+
 // Processor decodes a streams and is safe for concurrent use. The processor
 // accepts a channel that is used as a semaphore to control the maximum
 // concurrent number of stream decode operations that can happen at any time.
@@ -62,8 +78,15 @@ type Processor struct {
 	streamReaderPool sync.Pool
 	batchPool        sync.Pool
 	decodeMetadata   decodeMetadataFunc
+	decodeEvent      decodeEventFunc
+	metadataCache    *metadataCache
+	decodeCacheable  decodeCacheableMetadataFunc
 	sem              chan struct{}
 	logger           *logp.Logger
+	validation       *ValidationFailures
+	jsonAPI          jsoniter.API
+	coerced          func(field, value string)
+	timestampPolicy  modeldecoder.TimestampPolicy
 	MaxEventSize     int
 }
 
@@ -75,37 +98,94 @@ type Config struct {
 	// Semaphore holds a channel to which Processor.HandleStream
 	// will send an item before proceeding, to limit concurrency.
 	Semaphore chan struct{}
+
+	// ValidationFailures, if non-nil, records events which
+	// fail validation, by field and agent name and version.
+	ValidationFailures *ValidationFailures
+
+	// Lenient controls whether events are decoded leniently, coercing
+	// minor schema violations rather than rejecting events: numbers and
+	// booleans encoded as strings are parsed, and unrecognised enum
+	// values are replaced with "unknown".
+	Lenient bool
+
+	// Coerced, if non-nil, is incremented for each value coerced
+	// when decoding leniently.
+	Coerced *monitoring.Int
+
+	// TimestampPolicy controls the timestamps assigned to events
+	// decoded without a timestamp.
+	TimestampPolicy modeldecoder.TimestampPolicy
+
+	// MetadataCache holds configuration for caching decoded metadata,
+	// which is reused by subsequent streams with identical metadata.
+	// MetadataCache is used only by the RUM processors.
+	MetadataCache MetadataCacheConfig
 }
 
 func BackendProcessor(cfg Config) *Processor {
-	return &Processor{
-		MaxEventSize:   cfg.MaxEventSize,
-		decodeMetadata: v2.DecodeNestedMetadata,
-		sem:            cfg.Semaphore,
-		logger:         logp.NewLogger(logs.Processor),
-	}
+	return newProcessor(cfg, v2.DecodeNestedMetadata)
 }
 
 func RUMV2Processor(cfg Config) *Processor {
-	return &Processor{
-		MaxEventSize:   cfg.MaxEventSize,
-		decodeMetadata: v2.DecodeNestedMetadata,
-		sem:            cfg.Semaphore,
-		logger:         logp.NewLogger(logs.Processor),
-	}
+	p := newProcessor(cfg, v2.DecodeNestedMetadata)
+	p.setMetadataCache(cfg.MetadataCache, v2.DecodeNestedMetadataFunc)
+	return p
 }
 
 func RUMV3Processor(cfg Config) *Processor {
-	return &Processor{
-		MaxEventSize:   cfg.MaxEventSize,
-		decodeMetadata: rumv3.DecodeNestedMetadata,
-		sem:            cfg.Semaphore,
-		logger:         logp.NewLogger(logs.Processor),
+	p := newProcessor(cfg, rumv3.DecodeNestedMetadata)
+	p.setMetadataCache(cfg.MetadataCache, rumv3.DecodeNestedMetadataFunc)
+	return p
+}
+
+// ECSLogsProcessor returns a Processor for streams of ECS-JSON log lines,
+// as produced by ECS logging libraries, following a metadata object.
+// Each line following the metadata is decoded as a log event, without
+// requiring the event type key used by the other processors.
+func ECSLogsProcessor(cfg Config) *Processor {
+	p := newProcessor(cfg, v2.DecodeNestedMetadata)
+	p.decodeEvent = v2.DecodeLog
+	return p
+}
+
+func newProcessor(cfg Config, decodeMetadata decodeMetadataFunc) *Processor {
+	p := &Processor{
+		MaxEventSize:    cfg.MaxEventSize,
+		decodeMetadata:  decodeMetadata,
+		sem:             cfg.Semaphore,
+		logger:          logp.NewLogger(logs.Processor),
+		validation:      cfg.ValidationFailures,
+		timestampPolicy: cfg.TimestampPolicy,
+	}
+	if cfg.Lenient {
+		// Coercions are logged at most once per interval, as they
+		// are expected to recur for every event sent by an agent.
+		logger := logp.NewLogger(logs.Processor, logs.WithRateLimit(coercionLogInterval))
+		p.coerced = func(field, value string) {
+			if cfg.Coerced != nil {
+				cfg.Coerced.Inc()
+			}
+			logger.Warnf("coerced %s value %q while decoding leniently", field, value)
+		}
+		p.jsonAPI = decoder.NewJSONAPI(nullable.NewLenientExtension(p.coerced))
+	}
+	return p
+}
+
+// setMetadataCache enables caching of metadata decoded with decode,
+// if cfg.Size is positive.
+func (p *Processor) setMetadataCache(cfg MetadataCacheConfig, decode decodeCacheableMetadataFunc) {
+	if cfg.Size <= 0 {
+		return
 	}
+	p.metadataCache = newMetadataCache(cfg)
+	p.decodeCacheable = decode
 }
 
 func (p *Processor) readMetadata(reader *streamReader, out *model.APMEvent) error {
-	if err := p.decodeMetadata(reader, out); err != nil {
+	if err := p.decodeMetadataCached(reader, out); err != nil {
+		p.recordValidationFailure(err, out.Agent)
 		err = reader.wrapError(err)
 		if err == io.EOF {
 			return &InvalidInputError{
@@ -124,6 +204,33 @@ func (p *Processor) readMetadata(reader *streamReader, out *model.APMEvent) erro
 	return nil
 }
 
+// decodeMetadataCached decodes metadata from reader into out, reusing
+// previously decoded metadata if the metadata cache is enabled and holds
+// an entry for an identical metadata line.
+func (p *Processor) decodeMetadataCached(reader *streamReader, out *model.APMEvent) error {
+	if p.metadataCache == nil {
+		return p.decodeMetadata(reader, out)
+	}
+	line, err := reader.ReadAhead()
+	if err != nil || len(line) == 0 {
+		// Leave errors and metadata-only streams to the
+		// uncached decoder, which handles them consistently.
+		return p.decodeMetadata(reader, out)
+	}
+	if apply, ok := p.metadataCache.get(line); ok {
+		reader.Skip()
+		apply(out)
+		return nil
+	}
+	apply, err := p.decodeCacheable(reader)
+	if err != nil {
+		return err
+	}
+	p.metadataCache.add(line, apply)
+	apply(out)
+	return nil
+}
+
 // identifyEventType takes a reader and reads ahead the first key of the
 // underlying json input. This method makes some assumptions met by the
 // input format:
@@ -161,6 +268,24 @@ func (p *Processor) readBatch(
 	result *Result,
 ) (int, error) {
 
+	// When the request is traced, record the time spent decoding the
+	// batch, and the time spent validating events within it. Validation
+	// is interleaved with decoding, so the validation span's duration is
+	// the sum of the time spent validating each event.
+	start := time.Now()
+	span, ctx := apm.StartSpanOptions(ctx, "Decode", "app", apm.SpanOptions{Start: start})
+	defer span.End()
+	var validationDuration time.Duration
+	var validationDurationPtr *time.Duration
+	if !span.Dropped() {
+		validationDurationPtr = &validationDuration
+		defer func() {
+			validationSpan, _ := apm.StartSpanOptions(ctx, "Validate", "app", apm.SpanOptions{Start: start})
+			validationSpan.Duration = validationDuration
+			validationSpan.End()
+		}()
+	}
+
 	// input events are decoded and appended to the batch
 	origLen := len(*batch)
 	for i := 0; i < batchSize && !reader.IsEOF(); i++ {
@@ -180,27 +305,20 @@ func (p *Processor) readBatch(
 			continue
 		}
 		// We copy the event for each iteration of the batch, as to avoid
-		// shallow copies of Labels and NumericLabels.
-		input := modeldecoder.Input{Base: copyEvent(baseEvent)}
-		switch eventType := p.identifyEventType(body); string(eventType) {
-		case errorEventType:
-			err = v2.DecodeNestedError(reader, &input, batch)
-		case metricsetEventType:
-			err = v2.DecodeNestedMetricset(reader, &input, batch)
-		case spanEventType:
-			err = v2.DecodeNestedSpan(reader, &input, batch)
-		case transactionEventType:
-			err = v2.DecodeNestedTransaction(reader, &input, batch)
-		case logEventType:
-			err = v2.DecodeNestedLog(reader, &input, batch)
-		case rumv3ErrorEventType:
-			err = rumv3.DecodeNestedError(reader, &input, batch)
-		case rumv3TransactionEventType:
-			err = rumv3.DecodeNestedTransaction(reader, &input, batch)
-		default:
-			err = errors.Wrap(errUnrecognizedObject, string(eventType))
+		// shallow copies of Labels, NumericLabels, and BooleanLabels.
+		input := modeldecoder.Input{
+			Base:               copyEvent(baseEvent),
+			ValidationDuration: validationDurationPtr,
+			Coerced:            p.coerced,
+			TimestampPolicy:    p.timestampPolicy,
+		}
+		if p.decodeEvent != nil {
+			err = p.decodeEvent(reader, &input, batch)
+		} else {
+			err = p.decodeNestedEvent(body, reader, &input, batch)
 		}
 		if err != nil && err != io.EOF {
+			p.recordValidationFailure(err, input.Base.Agent)
 			result.LimitedAdd(&InvalidInputError{
 				Message:  err.Error(),
 				Document: string(reader.LatestLine()),
@@ -213,6 +331,100 @@ func (p *Processor) readBatch(
 	return len(*batch) - origLen, nil
 }
 
+// decodeNestedEvent decodes an event from reader, identifying its type
+// by the root key of the event's JSON object in body.
+func (p *Processor) decodeNestedEvent(body []byte, reader *streamReader, input *modeldecoder.Input, batch *model.Batch) error {
+	switch eventType := p.identifyEventType(body); string(eventType) {
+	case errorEventType:
+		return v2.DecodeNestedError(reader, input, batch)
+	case metricsetEventType:
+		return v2.DecodeNestedMetricset(reader, input, batch)
+	case spanEventType:
+		return v2.DecodeNestedSpan(reader, input, batch)
+	case transactionEventType:
+		return v2.DecodeNestedTransaction(reader, input, batch)
+	case logEventType:
+		return v2.DecodeNestedLog(reader, input, batch)
+	case rumv3ErrorEventType:
+		return rumv3.DecodeNestedError(reader, input, batch)
+	case rumv3TransactionEventType:
+		return rumv3.DecodeNestedTransaction(reader, input, batch)
+	default:
+		return errors.Wrap(errUnrecognizedObject, string(eventType))
+	}
+}
+
+// BatchHandler is called by DecodeStream with each batch of decoded events.
+//
+// The batch and its events are only valid until BatchHandler returns, as
+// they may be reused for subsequent batches; implementations must copy any
+// events they need to retain.
+type BatchHandler func(ctx context.Context, batch *model.Batch) error
+
+// DecodeStream decodes an ND-JSON intake stream read from reader, calling
+// handle with batches of up to batchSize decoded events. DecodeStream may
+// be used independently of HandleStream, for example by tools which replay
+// or validate intake payloads, and does not acquire the Processor's
+// semaphore.
+//
+// The first object in the stream must be the metadata object, which is
+// decoded into baseEvent; the remaining objects are decoded as events,
+// each starting as a copy of baseEvent. Events which cannot be decoded are
+// skipped and recorded in result, along with the number of events accepted
+// by handle. result may be nil if the caller does not require it.
+//
+// DecodeStream returns nil once the stream has been fully decoded and all
+// events handled. If the metadata cannot be decoded, if reading the stream
+// fails, or if handle returns an error, DecodeStream stops and returns the
+// error; in this case result only covers the events handled until then.
+//
+// Callers must not access result concurrently with DecodeStream.
+func (p *Processor) DecodeStream(
+	ctx context.Context,
+	baseEvent model.APMEvent,
+	reader io.Reader,
+	batchSize int,
+	handle BatchHandler,
+	result *Result,
+) error {
+	if result == nil {
+		result = &Result{}
+	}
+	sr := p.getStreamReader(reader)
+	defer sr.release()
+
+	// first item is the metadata object
+	if err := p.readMetadata(sr, &baseEvent); err != nil {
+		// no point in continuing if we couldn't read the metadata
+		return err
+	}
+	result.ServiceName = baseEvent.Service.Name
+
+	sp, ctx := apm.StartSpan(ctx, "Stream", "Reporter")
+	defer sp.End()
+
+	for {
+		batch := p.getBatch()
+		n, readErr := p.readBatch(ctx, baseEvent, batchSize, batch, sr, result)
+		if n > 0 {
+			err := handle(ctx, batch)
+			p.batchPool.Put(batch)
+			if err != nil {
+				return err
+			}
+			result.AddAccepted(n)
+		} else {
+			p.batchPool.Put(batch)
+		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				return nil
+			}
+			return readErr
+		}
+	}
+}
+
 // HandleStream processes a stream of events in batches of batchSize at a time,
 // updating result as events are accepted, or per-event errors occur.
 //
@@ -241,10 +453,16 @@ func (p *Processor) HandleStream(
 	if err := p.semAcquire(ctx, async); err != nil {
 		return err
 	}
+	if !async {
+		defer p.semRelease()
+		return p.DecodeStream(ctx, baseEvent, reader, batchSize, func(ctx context.Context, batch *model.Batch) error {
+			return p.processBatch(ctx, processor, batch)
+		}, result)
+	}
 	sr := p.getStreamReader(reader)
 
 	// Release the semaphore on early exit; this will be set to false
-	// for asynchronous requests once we may no longer exit early.
+	// once we may no longer exit early.
 	shouldReleaseSemaphore := true
 	defer func() {
 		sr.release()
@@ -258,17 +476,16 @@ func (p *Processor) HandleStream(
 		// no point in continuing if we couldn't read the metadata
 		return err
 	}
+	result.ServiceName = baseEvent.Service.Name
 
 	sp, ctx := apm.StartSpan(ctx, "Stream", "Reporter")
 	defer sp.End()
 
-	if async {
-		// The semaphore is released by handleStream
-		shouldReleaseSemaphore = false
-	}
+	// The semaphore is released by handleStreamAsync
+	shouldReleaseSemaphore = false
 	first := true
 	for {
-		err := p.handleStream(ctx, async, baseEvent, batchSize, sr, processor, result, first)
+		err := p.handleStreamAsync(ctx, baseEvent, batchSize, sr, processor, result, first)
 		if err != nil {
 			if errors.Is(err, io.EOF) {
 				return nil
@@ -281,9 +498,10 @@ func (p *Processor) HandleStream(
 	}
 }
 
-func (p *Processor) handleStream(
+// handleStreamAsync decodes a batch of events from sr, and processes it in
+// the background.
+func (p *Processor) handleStreamAsync(
 	ctx context.Context,
-	async bool,
 	baseEvent model.APMEvent,
 	batchSize int,
 	sr *streamReader,
@@ -297,63 +515,76 @@ func (p *Processor) handleStream(
 	// The first iteration will not acquire the semaphore since it's already
 	// acquired in the caller function.
 	var n int
-	if async {
-		if !first {
-			if err := p.semAcquire(ctx, async); err != nil {
-				return err
-			}
+	if !first {
+		if err := p.semAcquire(ctx, true); err != nil {
+			return err
 		}
-		defer func() {
-			// If no events have been read on an asynchronous request, release
-			// the semaphore since the processing goroutine isn't scheduled.
-			if n == 0 {
-				p.semRelease()
-			}
-		}()
 	}
-	var batch model.Batch
-	if b, ok := p.batchPool.Get().(*model.Batch); ok {
-		batch = (*b)[:0]
-	}
-	n, readErr = p.readBatch(ctx, baseEvent, batchSize, &batch, sr, result)
+	defer func() {
+		// If no events have been read on an asynchronous request, release
+		// the semaphore since the processing goroutine isn't scheduled.
+		if n == 0 {
+			p.semRelease()
+		}
+	}()
+	batch := p.getBatch()
+	n, readErr = p.readBatch(ctx, baseEvent, batchSize, batch, sr, result)
 	if n == 0 {
 		// No events to process, return the batch to the pool.
-		p.batchPool.Put(&batch)
+		p.batchPool.Put(batch)
 		return readErr
 	}
 	// Async requests are processed in the background and once the batch has
 	// been processed, the semaphore is released.
-	if async {
-		go func() {
-			defer p.semRelease()
-			if err := p.processBatch(ctx, processor, &batch); err != nil {
-				p.logger.Errorf("failed handling async request: %v", err)
-			}
-		}()
-	} else {
-		if err := p.processBatch(ctx, processor, &batch); err != nil {
-			return err
+	go func() {
+		defer p.semRelease()
+		defer p.batchPool.Put(batch)
+		if err := p.processBatch(ctx, processor, batch); err != nil {
+			p.logger.Errorf("failed handling async request: %v", err)
 		}
-		result.AddAccepted(n)
-	}
+	}()
 	return readErr
 }
 
-// processBatch processes the batch and returns it to the pool after it's been processed.
+// getBatch returns an empty batch from the pool, or a new one.
+func (p *Processor) getBatch() *model.Batch {
+	if b, ok := p.batchPool.Get().(*model.Batch); ok {
+		*b = (*b)[:0]
+		return b
+	}
+	return &model.Batch{}
+}
+
+// processBatch processes the batch with processor.
 func (p *Processor) processBatch(ctx context.Context, processor model.BatchProcessor, batch *model.Batch) error {
-	defer p.batchPool.Put(batch)
+	span, ctx := apm.StartSpan(ctx, "Process", "app")
+	defer span.End()
 	return processor.ProcessBatch(ctx, batch)
 }
 
+// recordValidationFailure records err in p.validation, if err is a
+// validation error and validation failures are being recorded.
+func (p *Processor) recordValidationFailure(err error, agent model.Agent) {
+	if p.validation != nil {
+		p.validation.record(err, agent)
+	}
+}
+
 // getStreamReader returns a streamReader that reads ND-JSON lines from r.
 func (p *Processor) getStreamReader(r io.Reader) *streamReader {
 	if sr, ok := p.streamReaderPool.Get().(*streamReader); ok {
 		sr.Reset(r)
 		return sr
 	}
+	var dec *decoder.NDJSONStreamDecoder
+	if p.jsonAPI != nil {
+		dec = decoder.NewNDJSONStreamDecoderAPI(r, p.MaxEventSize, p.jsonAPI)
+	} else {
+		dec = decoder.NewNDJSONStreamDecoder(r, p.MaxEventSize)
+	}
 	return &streamReader{
 		processor:           p,
-		NDJSONStreamDecoder: decoder.NewNDJSONStreamDecoder(r, p.MaxEventSize),
+		NDJSONStreamDecoder: dec,
 	}
 }
 
@@ -414,7 +645,7 @@ func (sr *streamReader) wrapError(err error) error {
 }
 
 // copyEvent returns a shallow copy of the APMEvent with a deep copy of the
-// labels and numeric labels.
+// labels, numeric labels, and boolean labels.
 func copyEvent(e model.APMEvent) model.APMEvent {
 	var out = e
 	if out.Labels != nil {
@@ -423,5 +654,8 @@ func copyEvent(e model.APMEvent) model.APMEvent {
 	if out.NumericLabels != nil {
 		out.NumericLabels = out.NumericLabels.Clone()
 	}
+	if out.BooleanLabels != nil {
+		out.BooleanLabels = out.BooleanLabels.Clone()
+	}
 	return out
 }
@@ -20,6 +20,7 @@ package stream
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"net/netip"
 	"os"
@@ -33,10 +34,15 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.elastic.co/apm/v2"
+	"go.elastic.co/apm/v2/apmtest"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
 
 	"github.com/elastic/apm-server/internal/approvaltest"
 	"github.com/elastic/apm-server/internal/model"
 	"github.com/elastic/apm-server/internal/model/modelindexer/modelindexertest"
+	"github.com/elastic/apm-server/internal/model/modelprocessor"
 	"github.com/elastic/apm-server/internal/publish"
 )
 
@@ -60,7 +66,7 @@ func TestHandlerReadStreamError(t *testing.T) {
 	var actualResult Result
 	err = sp.HandleStream(context.Background(), false, model.APMEvent{}, timeoutReader, 10, processor, &actualResult)
 	assert.EqualError(t, err, "timeout")
-	assert.Equal(t, Result{Accepted: accepted}, actualResult)
+	assert.Equal(t, Result{Accepted: accepted, ServiceName: "1234_service-12a3"}, actualResult)
 }
 
 func TestHandlerReportingStreamError(t *testing.T) {
@@ -91,55 +97,69 @@ func TestHandlerReportingStreamError(t *testing.T) {
 			bytes.NewReader(payload), 10, processor, &actualResult,
 		)
 		assert.Equal(t, test.err, err)
-		assert.Zero(t, actualResult)
+		assert.Equal(t, Result{ServiceName: "1234_service-12a3"}, actualResult)
 	}
 }
 
 func TestIntegrationESOutput(t *testing.T) {
 	for _, test := range []struct {
-		name   string
-		path   string
-		errors []error // per-event errors
-		err    error   // stream-level error
+		name        string
+		path        string
+		serviceName string
+		errors      []error // per-event errors
+		err         error   // stream-level error
 	}{{
-		name: "Errors",
-		path: "errors.ndjson",
+		name:        "Errors",
+		path:        "errors.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "Transactions",
-		path: "transactions.ndjson",
+		name:        "Transactions",
+		path:        "transactions.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "TransactionsHugeTraces",
-		path: "transactions-huge_traces.ndjson",
+		name:        "TransactionsHugeTraces",
+		path:        "transactions-huge_traces.ndjson",
+		serviceName: "chatty-service",
 	}, {
-		name: "Spans",
-		path: "spans.ndjson",
+		name:        "Spans",
+		path:        "spans.ndjson",
+		serviceName: "backendspans",
 	}, {
-		name: "Metricsets",
-		path: "metricsets.ndjson",
+		name:        "Metricsets",
+		path:        "metricsets.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "Events",
-		path: "events.ndjson",
+		name:        "Events",
+		path:        "events.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "Logs",
-		path: "logs.ndjson",
+		name:        "Logs",
+		path:        "logs.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "MinimalService",
-		path: "minimal-service.ndjson",
+		name:        "MinimalService",
+		path:        "minimal-service.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "MetadataNullValues",
-		path: "metadata-null-values.ndjson",
+		name:        "MetadataNullValues",
+		path:        "metadata-null-values.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "OptionalTimestamps",
-		path: "optional-timestamps.ndjson",
+		name:        "OptionalTimestamps",
+		path:        "optional-timestamps.ndjson",
+		serviceName: "backendspans",
 	}, {
-		name: "OpenTelemetryBridge",
-		path: "otel-bridge.ndjson",
+		name:        "OpenTelemetryBridge",
+		path:        "otel-bridge.ndjson",
+		serviceName: "chatty-service",
 	}, {
-		name: "SpanLinks",
-		path: "span-links.ndjson",
+		name:        "SpanLinks",
+		path:        "span-links.ndjson",
+		serviceName: "1234_service-12a3",
 	}, {
-		name: "InvalidEvent",
-		path: "invalid-event.ndjson",
+		name:        "InvalidEvent",
+		path:        "invalid-event.ndjson",
+		serviceName: "1234_service-12a3",
 		errors: []error{
 			&InvalidInputError{
 				Message:  `decode error: data read error: v2.transactionRoot.Transaction: v2.transaction.ID: ReadString: expects " or n,`,
@@ -147,8 +167,9 @@ func TestIntegrationESOutput(t *testing.T) {
 			},
 		},
 	}, {
-		name: "InvalidJSONEvent",
-		path: "invalid-json-event.ndjson",
+		name:        "InvalidJSONEvent",
+		path:        "invalid-json-event.ndjson",
+		serviceName: "1234_service-12a3",
 		errors: []error{
 			&InvalidInputError{
 				Message:  "invalid-json: did not recognize object type",
@@ -177,8 +198,9 @@ func TestIntegrationESOutput(t *testing.T) {
 			Document: `{"not": "metadata"}`,
 		},
 	}, {
-		name: "UnrecognizedEvent",
-		path: "invalid-event-type.ndjson",
+		name:        "UnrecognizedEvent",
+		path:        "invalid-event-type.ndjson",
+		serviceName: "1234_service-12a3",
 		errors: []error{
 			&InvalidInputError{
 				Message:  "tennis-court: did not recognize object type",
@@ -211,18 +233,19 @@ func TestIntegrationESOutput(t *testing.T) {
 			} else {
 				require.NoError(t, err)
 			}
-			assert.Equal(t, Result{Accepted: accepted, Errors: test.errors}, actualResult)
+			assert.Equal(t, Result{Accepted: accepted, Errors: test.errors, ServiceName: test.serviceName}, actualResult)
 		})
 	}
 }
 
 func TestIntegrationRum(t *testing.T) {
 	for _, test := range []struct {
-		path string
-		name string
+		path        string
+		name        string
+		serviceName string
 	}{
-		{path: "errors_rum.ndjson", name: "RumErrors"},
-		{path: "transactions_spans_rum.ndjson", name: "RumTransactions"},
+		{path: "errors_rum.ndjson", name: "RumErrors", serviceName: "apm-agent-js"},
+		{path: "transactions_spans_rum.ndjson", name: "RumTransactions", serviceName: "apm-agent-js"},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			payload, err := os.ReadFile(filepath.Join("../../../testdata/intake-v2", test.path))
@@ -247,18 +270,19 @@ func TestIntegrationRum(t *testing.T) {
 			var actualResult Result
 			err = p.HandleStream(context.Background(), false, baseEvent, bytes.NewReader(payload), 10, batchProcessor, &actualResult)
 			require.NoError(t, err)
-			assert.Equal(t, Result{Accepted: accepted}, actualResult)
+			assert.Equal(t, Result{Accepted: accepted, ServiceName: test.serviceName}, actualResult)
 		})
 	}
 }
 
 func TestRUMV3(t *testing.T) {
 	for _, test := range []struct {
-		path string
-		name string
+		path        string
+		name        string
+		serviceName string
 	}{
-		{path: "rum_errors.ndjson", name: "RUMV3Errors"},
-		{path: "rum_events.ndjson", name: "RUMV3Events"},
+		{path: "rum_errors.ndjson", name: "RUMV3Errors", serviceName: "apm-a-rum-test-e2e-general-usecase"},
+		{path: "rum_events.ndjson", name: "RUMV3Events", serviceName: "apm-a-rum-test-e2e-general-usecase"},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			payload, err := os.ReadFile(filepath.Join("../../../testdata/intake-v3", test.path))
@@ -283,11 +307,68 @@ func TestRUMV3(t *testing.T) {
 			var actualResult Result
 			err = p.HandleStream(context.Background(), false, baseEvent, bytes.NewReader(payload), 10, batchProcessor, &actualResult)
 			require.NoError(t, err)
-			assert.Equal(t, Result{Accepted: accepted}, actualResult)
+			assert.Equal(t, Result{Accepted: accepted, ServiceName: test.serviceName}, actualResult)
 		})
 	}
 }
 
+func TestRUMV3MetadataCache(t *testing.T) {
+	payload, err := os.ReadFile("../../../testdata/intake-v3/rum_events.ndjson")
+	require.NoError(t, err)
+
+	registry := monitoring.NewRegistry()
+	hits := monitoring.NewInt(registry, "hits")
+	misses := monitoring.NewInt(registry, "misses")
+	p := RUMV3Processor(Config{
+		MaxEventSize: 100 * 1024,
+		Semaphore:    make(chan struct{}, 1),
+		MetadataCache: MetadataCacheConfig{
+			Size:   1,
+			Hits:   hits,
+			Misses: misses,
+		},
+	})
+	baseEvent := model.APMEvent{
+		UserAgent: model.UserAgent{Original: "rum-2.0"},
+		Source:    model.Source{IP: netip.MustParseAddr("192.0.0.1")},
+		Client:    model.Client{IP: netip.MustParseAddr("192.0.0.2")}, // X-Forwarded-For
+		Timestamp: time.Date(2018, 8, 1, 10, 0, 0, 0, time.UTC),
+	}
+
+	// Events decoded with cached metadata must be identical to
+	// those decoded without the cache.
+	for i := 0; i < 2; i++ {
+		var accepted int
+		batchProcessor := makeApproveEventsBatchProcessor(t, "test_approved_es_documents/testIntakeRUMV3Events", &accepted)
+		var result Result
+		err := p.HandleStream(context.Background(), false, baseEvent, bytes.NewReader(payload), 10, batchProcessor, &result)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Accepted: accepted, ServiceName: "apm-a-rum-test-e2e-general-usecase"}, result)
+	}
+	assert.Equal(t, int64(1), hits.Get())
+	assert.Equal(t, int64(1), misses.Get())
+
+	// Distinct metadata is not cached once the cache is full.
+	otherMetadata := `{"m": {"se": {"n": "other", "a": {"n": "js-base", "ve": "4.8.1"}}}}`
+	for i := 0; i < 2; i++ {
+		var result Result
+		err := p.HandleStream(context.Background(), false, baseEvent, strings.NewReader(otherMetadata+"\n"), 10, modelprocessor.Nop{}, &result)
+		require.NoError(t, err)
+		assert.Equal(t, "other", result.ServiceName)
+	}
+	assert.Equal(t, int64(1), hits.Get())
+	assert.Equal(t, int64(3), misses.Get())
+
+	// Invalid metadata is rejected and never cached.
+	invalidMetadata := `{"m": {"se": {"a": {"n": "js-base", "ve": "4.8.1"}}}}` + "\n"
+	for i := 0; i < 2; i++ {
+		err := p.HandleStream(context.Background(), false, baseEvent, strings.NewReader(invalidMetadata), 10, modelprocessor.Nop{}, &Result{})
+		assert.ErrorContains(t, err, "validation error")
+	}
+	assert.Equal(t, int64(1), hits.Get())
+	assert.Equal(t, int64(5), misses.Get())
+}
+
 func TestLabelLeak(t *testing.T) {
 	payload := `{"metadata": {"service": {"name": "testsvc", "environment": "staging", "version": null, "agent": {"name": "python", "version": "6.9.1"}, "language": {"name": "python", "version": "3.10.4"}, "runtime": {"name": "CPython", "version": "3.10.4"}, "framework": {"name": "flask", "version": "2.1.1"}}, "process": {"pid": 2112739, "ppid": 2112738, "argv": ["/home/stuart/workspace/sdh/581/venv/lib/python3.10/site-packages/flask/__main__.py", "run"], "title": null}, "system": {"hostname": "slaptop", "architecture": "x86_64", "platform": "linux"}, "labels": {"ci_commit": "unknown", "numeric": 1}}}
 {"transaction": {"id": "88dee29a6571b948", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "name": "GET /withlabels", "type": "request", "duration": 1.6199999999999999, "result": "HTTP 2xx", "timestamp": 1652185276804681, "outcome": "success", "sampled": true, "span_count": {"started": 0, "dropped": 0}, "sample_rate": 1.0, "context": {"request": {"env": {"REMOTE_ADDR": "127.0.0.1", "SERVER_NAME": "127.0.0.1", "SERVER_PORT": "5000"}, "method": "GET", "socket": {"remote_address": "127.0.0.1"}, "cookies": {}, "headers": {"host": "localhost:5000", "user-agent": "curl/7.81.0", "accept": "*/*", "app-os": "Android", "content-type": "application/json; charset=utf-8", "content-length": "29"}, "url": {"full": "http://localhost:5000/withlabels?second_with_labels", "protocol": "http:", "hostname": "localhost", "pathname": "/withlabels", "port": "5000", "search": "?second_with_labels"}}, "response": {"status_code": 200, "headers": {"Content-Type": "application/json", "Content-Length": "14"}}, "tags": {"appOs": "Android", "email_set": "hello@hello.com", "time_set": 1652185276}}}}
@@ -329,6 +410,180 @@ func TestLabelLeak(t *testing.T) {
 	assert.Equal(t, model.Labels{"ci_commit": {Global: true, Value: "unknown"}}, txs[1].Labels)
 }
 
+func TestLenientDecoding(t *testing.T) {
+	payload := `{"metadata": {"service": {"name": "testsvc", "agent": {"name": "homegrown", "version": "0.1"}}, "process": {"pid": "123"}}}
+{"transaction": {"id": "88dee29a6571b948", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "type": "request", "duration": "1.5", "outcome": "ok", "sampled": "true", "span_count": {"started": "1"}}}
+{"span": {"id": "0123456789abcdef", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "parent_id": "88dee29a6571b948", "name": "SELECT", "type": "db", "duration": 1, "outcome": "failed", "start": "0.5"}}
+{"span": {"id": "0123456789abcdef", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "parent_id": "88dee29a6571b948", "name": "SELECT", "type": "db", "duration": "one"}}`
+
+	handleStream := func(t *testing.T, cfg Config) (model.Batch, Result) {
+		var processed model.Batch
+		batchProcessor := model.ProcessBatchFunc(func(_ context.Context, b *model.Batch) error {
+			processed = append(processed, *b...)
+			return nil
+		})
+		cfg.MaxEventSize = 100 * 1024
+		cfg.Semaphore = make(chan struct{}, 1)
+		p := BackendProcessor(cfg)
+		var result Result
+		err := p.HandleStream(context.Background(), false, model.APMEvent{}, strings.NewReader(payload), 10, batchProcessor, &result)
+		require.NoError(t, err)
+		return processed, result
+	}
+
+	t.Run("strict", func(t *testing.T) {
+		err := BackendProcessor(Config{MaxEventSize: 100 * 1024, Semaphore: make(chan struct{}, 1)}).HandleStream(
+			context.Background(), false, model.APMEvent{}, strings.NewReader(payload), 10, modelprocessor.Nop{}, &Result{},
+		)
+		assert.ErrorContains(t, err, "decode error")
+	})
+
+	t.Run("lenient", func(t *testing.T) {
+		var coerced monitoring.Int
+		processed, result := handleStream(t, Config{Lenient: true, Coerced: &coerced})
+		require.Len(t, processed, 2)
+		assert.Equal(t, 2, result.Accepted)
+		require.Len(t, result.Errors, 1)
+		assert.Contains(t, result.Errors[0].Error(), `cannot coerce "one" to nullable.Float64`)
+
+		assert.Equal(t, 123, processed[0].Process.Pid)
+		assert.Equal(t, 1500*time.Microsecond, processed[0].Event.Duration)
+		assert.Equal(t, "unknown", processed[0].Event.Outcome)
+		assert.True(t, processed[0].Transaction.Sampled)
+		assert.Equal(t, "unknown", processed[1].Event.Outcome)
+
+		// process.pid, duration, sampled, span_count.started,
+		// and outcome for the transaction, and outcome and start
+		// for the first span.
+		assert.Equal(t, int64(7), coerced.Get())
+	})
+}
+
+func TestDecodeStream(t *testing.T) {
+	payload := `{"metadata": {"service": {"name": "testsvc", "agent": {"name": "go", "version": "2.0"}}}}
+{"transaction": {"id": "88dee29a6571b948", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "type": "request", "duration": 1, "span_count": {"started": 1}}}
+{"span": {"id": "0123456789abcdef", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "parent_id": "88dee29a6571b948", "name": "SELECT", "type": "db", "duration": 1, "start": 0.5}}
+{"unknown": {}}
+{"span": {"id": "0123456789abcdef", "trace_id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "parent_id": "88dee29a6571b948", "name": "SELECT", "type": "db", "duration": 1, "start": 0.5}}`
+
+	// DecodeStream does not require a semaphore.
+	p := BackendProcessor(Config{MaxEventSize: 100 * 1024})
+
+	var batchSizes []int
+	var decoded model.Batch
+	var result Result
+	err := p.DecodeStream(context.Background(), model.APMEvent{}, strings.NewReader(payload), 2,
+		func(ctx context.Context, batch *model.Batch) error {
+			batchSizes = append(batchSizes, len(*batch))
+			decoded = append(decoded, *batch...)
+			return nil
+		}, &result,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []int{2, 1}, batchSizes)
+	assert.Equal(t, "testsvc", result.ServiceName)
+	assert.Equal(t, 3, result.Accepted)
+	require.Len(t, result.Errors, 1)
+	assert.ErrorContains(t, result.Errors[0], "did not recognize object type")
+
+	require.Len(t, decoded, 3)
+	assert.Equal(t, model.TransactionProcessor, decoded[0].Processor)
+	assert.Equal(t, model.SpanProcessor, decoded[1].Processor)
+	assert.Equal(t, model.SpanProcessor, decoded[2].Processor)
+	for _, event := range decoded {
+		assert.Equal(t, "testsvc", event.Service.Name)
+		assert.Equal(t, "go", event.Agent.Name)
+	}
+
+	// Errors returned by the handler stop decoding. result may be nil.
+	handlerErr := errors.New("handler failed")
+	var calls int
+	err = p.DecodeStream(context.Background(), model.APMEvent{}, strings.NewReader(payload), 2,
+		func(ctx context.Context, batch *model.Batch) error {
+			calls++
+			return handlerErr
+		}, nil,
+	)
+	assert.Equal(t, handlerErr, err)
+	assert.Equal(t, 1, calls)
+
+	// The metadata object is required.
+	err = p.DecodeStream(context.Background(), model.APMEvent{}, strings.NewReader(`{"transaction": {}}`), 2,
+		func(ctx context.Context, batch *model.Batch) error { return nil }, nil,
+	)
+	assert.Error(t, err)
+}
+
+func TestECSLogs(t *testing.T) {
+	payload := `{"metadata": {"service": {"name": "testsvc", "environment": "prod", "agent": {"name": "java", "version": "1.0"}}}}
+{"@timestamp": "2022-09-08T06:02:51.123Z", "log.level": "INFO", "message": "first", "ecs.version": "1.2.0", "trace.id": "ba7f5d18ac4c7f39d1ff070c79b2bea5", "transaction.id": "88dee29a6571b948"}
+{"@timestamp": "2022-09-08T06:02:52.123Z", "log": {"level": "WARN", "logger": "testLogger"}, "message": "second", "service.name": "othersvc"}
+{"transaction": {"id": "88dee29a6571b948"}}
+{"log.level": 1}`
+
+	var processed model.Batch
+	batchProcessor := model.ProcessBatchFunc(func(_ context.Context, b *model.Batch) error {
+		processed = append(processed, *b...)
+		return nil
+	})
+	p := ECSLogsProcessor(Config{MaxEventSize: 100 * 1024, Semaphore: make(chan struct{}, 1)})
+	var result Result
+	err := p.HandleStream(context.Background(), false, model.APMEvent{}, strings.NewReader(payload), 10, batchProcessor, &result)
+	require.NoError(t, err)
+
+	// Lines which are not valid ECS log lines, including events
+	// of other types, are rejected.
+	assert.Equal(t, 2, result.Accepted)
+	require.Len(t, result.Errors, 2)
+	require.Len(t, processed, 2)
+
+	for _, event := range processed {
+		assert.Equal(t, model.LogProcessor, event.Processor)
+		assert.Equal(t, "prod", event.Service.Environment)
+		assert.Equal(t, "java", event.Agent.Name)
+	}
+	assert.Equal(t, "first", processed[0].Message)
+	assert.Equal(t, "INFO", processed[0].Log.Level)
+	assert.Equal(t, "testsvc", processed[0].Service.Name)
+	assert.Equal(t, "ba7f5d18ac4c7f39d1ff070c79b2bea5", processed[0].Trace.ID)
+	assert.Equal(t, "88dee29a6571b948", processed[0].Transaction.ID)
+	assert.Equal(t, "second", processed[1].Message)
+	assert.Equal(t, "WARN", processed[1].Log.Level)
+	assert.Equal(t, "testLogger", processed[1].Log.Logger)
+	assert.Equal(t, "othersvc", processed[1].Service.Name)
+}
+
+func TestHandleStreamTracing(t *testing.T) {
+	payload, err := os.ReadFile("../../../testdata/intake-v2/transactions.ndjson")
+	require.NoError(t, err)
+
+	tracer := apmtest.NewRecordingTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransaction("POST /intake/v2/events", "request")
+	ctx := apm.ContextWithTransaction(context.Background(), tx)
+
+	p := BackendProcessor(Config{
+		MaxEventSize: 100 * 1024,
+		Semaphore:    make(chan struct{}, 1),
+	})
+	var actualResult Result
+	err = p.HandleStream(ctx, false, model.APMEvent{}, bytes.NewReader(payload), 10, modelprocessor.Nop{}, &actualResult)
+	require.NoError(t, err)
+	tx.End()
+	tracer.Flush(nil)
+
+	spanNames := make(map[string]int)
+	for _, span := range tracer.Payloads().Spans {
+		spanNames[span.Name]++
+	}
+	assert.Equal(t, map[string]int{
+		"Stream":   1,
+		"Decode":   1,
+		"Validate": 1,
+		"Process":  1,
+	}, spanNames)
+}
+
 func makeApproveEventsBatchProcessor(t *testing.T, name string, count *int) model.BatchProcessor {
 	return model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
 		docs := modelindexertest.AppendEncodedBatch(t, nil, *b)
@@ -37,6 +37,10 @@ var (
 type Result struct {
 	Accepted int
 	Errors   []error
+
+	// ServiceName holds the service name recorded in the stream's
+	// metadata, if the metadata was decoded successfully.
+	ServiceName string
 }
 
 func (r *Result) LimitedAdd(err error) {
@@ -0,0 +1,210 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package stream
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modeldecoder"
+)
+
+// maxValidationFailureEntries limits the number of distinct field, agent name,
+// and agent version combinations tracked by ValidationFailures, protecting
+// against unbounded memory usage due to arbitrary agent metadata.
+const maxValidationFailureEntries = 1000
+
+// ValidationFailures holds counts of events which failed validation,
+// dimensioned by the failing field and the agent name and version.
+type ValidationFailures struct {
+	mu         sync.RWMutex
+	counts     map[validationFailureKey]*int64
+	overflowed int64
+}
+
+type validationFailureKey struct {
+	field        string
+	agentName    string
+	agentVersion string
+}
+
+// ValidationFailure holds the number of events which failed validation for
+// a field, sent by a specific agent name and version.
+type ValidationFailure struct {
+	Field        string `json:"field"`
+	AgentName    string `json:"agent_name"`
+	AgentVersion string `json:"agent_version"`
+	Count        int64  `json:"count"`
+}
+
+// NewValidationFailures returns a new ValidationFailures.
+func NewValidationFailures() *ValidationFailures {
+	return &ValidationFailures{counts: make(map[validationFailureKey]*int64)}
+}
+
+// record records err if it is a modeldecoder.ValidationError, attributing
+// the failure to the given agent.
+func (v *ValidationFailures) record(err error, agent model.Agent) {
+	var validationErr modeldecoder.ValidationError
+	if !errors.As(err, &validationErr) {
+		return
+	}
+	key := validationFailureKey{
+		field:        validationErrorField(validationErr.Unwrap()),
+		agentName:    agent.Name,
+		agentVersion: agent.Version,
+	}
+	if count := v.count(key); count != nil {
+		atomic.AddInt64(count, 1)
+	}
+}
+
+// count returns the counter for key, creating it if necessary.
+// If the maximum number of entries has been reached, count returns nil.
+func (v *ValidationFailures) count(key validationFailureKey) *int64 {
+	v.mu.RLock()
+	count, ok := v.counts[key]
+	v.mu.RUnlock()
+	if ok {
+		return count
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if count, ok := v.counts[key]; ok {
+		return count
+	}
+	if len(v.counts) >= maxValidationFailureEntries {
+		atomic.AddInt64(&v.overflowed, 1)
+		return nil
+	}
+	count = new(int64)
+	v.counts[key] = count
+	return count
+}
+
+// Failures returns the recorded validation failures, ordered by
+// descending count, and the number of failures which could not
+// be recorded due to the maximum number of entries being reached.
+func (v *ValidationFailures) Failures() (failures []ValidationFailure, overflowed int64) {
+	v.mu.RLock()
+	failures = make([]ValidationFailure, 0, len(v.counts))
+	for key, count := range v.counts {
+		failures = append(failures, ValidationFailure{
+			Field:        key.field,
+			AgentName:    key.agentName,
+			AgentVersion: key.agentVersion,
+			Count:        atomic.LoadInt64(count),
+		})
+	}
+	v.mu.RUnlock()
+	sort.Slice(failures, func(i, j int) bool {
+		if failures[i].Count != failures[j].Count {
+			return failures[i].Count > failures[j].Count
+		}
+		if failures[i].Field != failures[j].Field {
+			return failures[i].Field < failures[j].Field
+		}
+		if failures[i].AgentName != failures[j].AgentName {
+			return failures[i].AgentName < failures[j].AgentName
+		}
+		return failures[i].AgentVersion < failures[j].AgentVersion
+	})
+	return failures, atomic.LoadInt64(&v.overflowed)
+}
+
+// CollectMonitoring may be called to collect monitoring metrics. It is
+// intended to be used with libbeat/monitoring.NewFunc.
+//
+// Failures are reported by agent name, agent version, and field. Failures
+// for events with unknown agent names or versions are reported under
+// "unknown".
+func (v *ValidationFailures) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	agents := make(map[string]map[string]map[string]int64)
+	for key, count := range v.counts {
+		name := key.agentName
+		if name == "" {
+			name = "unknown"
+		}
+		version := key.agentVersion
+		if version == "" {
+			version = "unknown"
+		}
+		versions, ok := agents[name]
+		if !ok {
+			versions = make(map[string]map[string]int64)
+			agents[name] = versions
+		}
+		fields, ok := versions[version]
+		if !ok {
+			fields = make(map[string]int64)
+			versions[version] = fields
+		}
+		fields[key.field] += atomic.LoadInt64(count)
+	}
+	for name, versions := range agents {
+		monitoring.ReportNamespace(V, name, func() {
+			for version, fields := range versions {
+				monitoring.ReportNamespace(V, version, func() {
+					for field, count := range fields {
+						monitoring.ReportInt(V, field, count)
+					}
+				})
+			}
+		})
+	}
+	monitoring.ReportInt(V, "overflowed", atomic.LoadInt64(&v.overflowed))
+}
+
+// validationErrorField returns the dotted path of the field which failed
+// validation, parsed from a validation error produced by the generated
+// modeldecoder code, e.g. "span: context: 'name': validation rule ..."
+// returns "span.context.name". Rules that apply to multiple fields, such
+// as "requires at least one of the fields ...", return the path of the
+// object containing the fields.
+func validationErrorField(err error) string {
+	var path []string
+	for _, segment := range strings.Split(err.Error(), ": ") {
+		if strings.HasPrefix(segment, "'") {
+			if end := strings.IndexByte(segment[1:], '\''); end >= 0 {
+				path = append(path, segment[1:end+1])
+			}
+			break
+		}
+		if strings.ContainsAny(segment, "' ") {
+			break
+		}
+		path = append(path, segment)
+	}
+	if len(path) == 0 {
+		return "unknown"
+	}
+	return strings.Join(path, ".")
+}
@@ -0,0 +1,138 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package stream
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/model/modeldecoder"
+)
+
+func TestValidationFailures(t *testing.T) {
+	payload := strings.Join([]string{
+		`{"metadata": {"service": {"name": "svc", "agent": {"name": "go", "version": "2.0.0"}}}}`,
+		`{"transaction": {"trace_id": "0123456789abcdef0123456789abcdef", "type": "request", "duration": 1, "span_count": {"started": 0}}}`,
+		`{"transaction": {"trace_id": "0123456789abcdef0123456789abcdef", "type": "request", "duration": 1, "span_count": {"started": 0}}}`,
+		`{"span": {"id": "0123456789abcdef", "trace_id": "0123456789abcdef0123456789abcdef", "parent_id": "0123456789abcdef", "type": "db", "start": 0, "duration": 1}}`,
+		`{"span": {"invalid-json"}}`,
+	}, "\n")
+
+	failures := NewValidationFailures()
+	p := BackendProcessor(Config{
+		MaxEventSize:       100 * 1024,
+		Semaphore:          make(chan struct{}, 1),
+		ValidationFailures: failures,
+	})
+	var result Result
+	err := p.HandleStream(
+		context.Background(), false, model.APMEvent{},
+		strings.NewReader(payload), 10, nopBatchProcessor{}, &result,
+	)
+	require.NoError(t, err)
+	require.Len(t, result.Errors, 4)
+
+	recorded, overflowed := failures.Failures()
+	assert.Zero(t, overflowed)
+	assert.Equal(t, []ValidationFailure{{
+		Field:        "transaction.id",
+		AgentName:    "go",
+		AgentVersion: "2.0.0",
+		Count:        2,
+	}, {
+		Field:        "span.name",
+		AgentName:    "go",
+		AgentVersion: "2.0.0",
+		Count:        1,
+	}}, recorded)
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "validation_failures", failures.CollectMonitoring, monitoring.Report)
+	assert.Equal(t, map[string]interface{}{
+		"validation_failures": map[string]interface{}{
+			"go": map[string]interface{}{
+				"2.0.0": map[string]interface{}{
+					"transaction.id": int64(2),
+					"span.name":      int64(1),
+				},
+			},
+			"overflowed": int64(0),
+		},
+	}, monitoring.CollectStructSnapshot(registry, monitoring.Full, false))
+}
+
+func TestValidationFailuresMetadata(t *testing.T) {
+	failures := NewValidationFailures()
+	p := BackendProcessor(Config{
+		MaxEventSize:       100 * 1024,
+		Semaphore:          make(chan struct{}, 1),
+		ValidationFailures: failures,
+	})
+	var result Result
+	err := p.HandleStream(
+		context.Background(), false, model.APMEvent{},
+		strings.NewReader(`{"metadata": {"service": {"agent": {"name": "go", "version": "2.0.0"}}}}`),
+		10, nopBatchProcessor{}, &result,
+	)
+	require.Error(t, err)
+
+	recorded, _ := failures.Failures()
+	require.Len(t, recorded, 1)
+	assert.Equal(t, "metadata.service.name", recorded[0].Field)
+	assert.Equal(t, int64(1), recorded[0].Count)
+}
+
+func TestValidationFailuresOverflow(t *testing.T) {
+	failures := NewValidationFailures()
+	err := modeldecoder.NewValidationErr(errors.New("span: 'name' required"))
+	for i := 0; i < maxValidationFailureEntries+2; i++ {
+		failures.record(err, model.Agent{Name: "go", Version: fmt.Sprint(i)})
+	}
+	// Errors other than validation errors are ignored.
+	failures.record(errors.New("span: 'name' required"), model.Agent{Name: "go", Version: "-1"})
+
+	recorded, overflowed := failures.Failures()
+	assert.Len(t, recorded, maxValidationFailureEntries)
+	assert.Equal(t, int64(2), overflowed)
+}
+
+func TestValidationErrorField(t *testing.T) {
+	for _, test := range []struct {
+		err   string
+		field string
+	}{
+		{err: "'metadata' required", field: "metadata"},
+		{err: "span: 'name' required", field: "span.name"},
+		{err: "metadata: service: 'name': validation rule 'maxLength(1024)' violated", field: "metadata.service.name"},
+		{err: "span: context: db: 'rows_affected': validation rule 'min(0)' violated", field: "span.context.db.rows_affected"},
+		{err: "error: 'transaction_id' required when 'parent_id' is set", field: "error.transaction_id"},
+		{err: "error: requires at least one of the fields 'exception;log'", field: "error"},
+		{err: "something went wrong", field: "unknown"},
+	} {
+		assert.Equal(t, test.field, validationErrorField(errors.New(test.err)), test.err)
+	}
+}
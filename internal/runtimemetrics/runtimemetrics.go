@@ -0,0 +1,189 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package runtimemetrics provides periodic publishing of the APM Server's
+// own Go runtime and process metrics as APM metricsets, so APM Server
+// instances can be monitored like any other service.
+package runtimemetrics
+
+import (
+	"context"
+	"os"
+	"runtime"
+	"time"
+
+	sysinfo "github.com/elastic/go-sysinfo"
+	"go.elastic.co/apm/v2"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/version"
+)
+
+// ServiceName holds the service name of published metricsets.
+const ServiceName = "apm-server"
+
+var (
+	monitoringRegistry = monitoring.Default.NewRegistry("apm-server.runtime_metrics")
+	publishedCounter   = monitoring.NewInt(monitoringRegistry, "published")
+	failedCounter      = monitoring.NewInt(monitoringRegistry, "failed")
+)
+
+// Publisher periodically publishes Go runtime and process metrics.
+//
+// Metrics are named like those of the Elastic APM Go Agent, and are
+// recorded in the internal metrics data stream.
+type Publisher struct {
+	processor model.BatchProcessor
+	interval  time.Duration
+	logger    *logp.Logger
+
+	service model.Service
+	host    model.Host
+	process model.Process
+
+	// lastCPU and lastCPUTime hold the process CPU time and the time at
+	// which it was last sampled, for calculating CPU usage.
+	lastCPU     time.Duration
+	lastCPUTime time.Time
+}
+
+// NewPublisher returns a new Publisher which sends metricsets to
+// batchProcessor at the given interval.
+func NewPublisher(batchProcessor model.BatchProcessor, interval time.Duration) *Publisher {
+	hostname, _ := os.Hostname()
+	return &Publisher{
+		processor: batchProcessor,
+		interval:  interval,
+		logger:    logp.NewLogger(logs.Beater).Named("runtimemetrics"),
+		service: model.Service{
+			Name:     ServiceName,
+			Version:  version.Version,
+			Language: model.Language{Name: "go", Version: runtime.Version()},
+			Runtime:  model.Runtime{Name: "gc", Version: runtime.Version()},
+			Node:     model.ServiceNode{Name: hostname},
+		},
+		host:    model.Host{Hostname: hostname},
+		process: model.Process{Pid: os.Getpid()},
+	}
+}
+
+// Run publishes metrics periodically until ctx is cancelled.
+func (p *Publisher) Run(ctx context.Context) error {
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case now := <-t.C:
+			if err := p.publish(ctx, now); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				failedCounter.Inc()
+				p.logger.Warnf("failed to publish runtime metrics: %v", err)
+				continue
+			}
+			publishedCounter.Inc()
+		}
+	}
+}
+
+// publish collects metrics and sends them as a single metricset.
+func (p *Publisher) publish(ctx context.Context, now time.Time) error {
+	samples := goRuntimeSamples()
+	samples = append(samples, p.processSamples(now)...)
+	batch := model.Batch{{
+		Timestamp: now,
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{Samples: samples},
+		Service:   p.service,
+		// Identify the Go agent, for the APM app to present the
+		// Go runtime metrics of the service.
+		Agent:   model.Agent{Name: "go", Version: apm.AgentVersion},
+		Host:    p.host,
+		Process: p.process,
+	}}
+	return p.processor.ProcessBatch(ctx, &batch)
+}
+
+func goRuntimeSamples() []model.MetricsetSample {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	return []model.MetricsetSample{
+		{Name: "golang.goroutines", Value: float64(runtime.NumGoroutine())},
+		{Name: "golang.heap.allocations.mallocs", Value: float64(mem.Mallocs)},
+		{Name: "golang.heap.allocations.frees", Value: float64(mem.Frees)},
+		{Name: "golang.heap.allocations.objects", Value: float64(mem.HeapObjects)},
+		{Name: "golang.heap.allocations.total", Value: float64(mem.TotalAlloc)},
+		{Name: "golang.heap.allocations.allocated", Value: float64(mem.HeapAlloc)},
+		{Name: "golang.heap.allocations.idle", Value: float64(mem.HeapIdle)},
+		{Name: "golang.heap.allocations.active", Value: float64(mem.HeapInuse)},
+		{Name: "golang.heap.system.total", Value: float64(mem.Sys)},
+		{Name: "golang.heap.system.obtained", Value: float64(mem.HeapSys)},
+		{Name: "golang.heap.system.stack", Value: float64(mem.StackSys)},
+		{Name: "golang.heap.system.released", Value: float64(mem.HeapReleased)},
+		{Name: "golang.heap.gc.next_gc_limit", Value: float64(mem.NextGC)},
+		{Name: "golang.heap.gc.total_count", Value: float64(mem.NumGC)},
+		{Name: "golang.heap.gc.total_pause.ns", Value: float64(mem.PauseTotalNs)},
+		{Name: "golang.heap.gc.cpu_fraction", Value: mem.GCCPUFraction},
+	}
+}
+
+// processSamples returns process and system memory metrics, and the CPU
+// usage of the process since the previous call. Metrics which cannot be
+// obtained on the current platform are omitted.
+func (p *Publisher) processSamples(now time.Time) []model.MetricsetSample {
+	var samples []model.MetricsetSample
+	if host, err := sysinfo.Host(); err == nil {
+		if mem, err := host.Memory(); err == nil {
+			samples = append(samples,
+				model.MetricsetSample{Name: "system.memory.total", Value: float64(mem.Total)},
+				model.MetricsetSample{Name: "system.memory.actual.free", Value: float64(mem.Available)},
+			)
+		}
+	}
+	self, err := sysinfo.Self()
+	if err != nil {
+		return samples
+	}
+	if mem, err := self.Memory(); err == nil {
+		samples = append(samples,
+			model.MetricsetSample{Name: "system.process.memory.size", Value: float64(mem.Virtual)},
+			model.MetricsetSample{Name: "system.process.memory.rss.bytes", Value: float64(mem.Resident)},
+		)
+	}
+	if cpu, err := self.CPUTime(); err == nil {
+		total := cpu.Total()
+		if !p.lastCPUTime.IsZero() {
+			elapsed := now.Sub(p.lastCPUTime) * time.Duration(runtime.NumCPU())
+			if elapsed > 0 {
+				samples = append(samples, model.MetricsetSample{
+					Name:  "system.process.cpu.total.norm.pct",
+					Value: float64(total-p.lastCPU) / float64(elapsed),
+				})
+			}
+		}
+		p.lastCPU = total
+		p.lastCPUTime = now
+	}
+	return samples
+}
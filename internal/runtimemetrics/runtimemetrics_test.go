@@ -0,0 +1,89 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package runtimemetrics
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestPublisher(t *testing.T) {
+	batches := make(chan model.Batch, 10)
+	processor := model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
+		batches <- *b
+		return nil
+	})
+	publisher := NewPublisher(processor, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+
+	var batch model.Batch
+	for i := 0; i < 2; i++ {
+		select {
+		case batch = <-batches:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for metrics")
+		}
+	}
+	require.Len(t, batch, 1)
+	event := batch[0]
+	assert.Equal(t, model.MetricsetProcessor, event.Processor)
+	assert.Equal(t, ServiceName, event.Service.Name)
+	assert.Equal(t, "go", event.Service.Language.Name)
+	assert.Equal(t, "go", event.Agent.Name)
+	assert.NotZero(t, event.Process.Pid)
+
+	samples := make(map[string]float64)
+	for _, sample := range event.Metricset.Samples {
+		samples[sample.Name] = sample.Value
+	}
+	assert.NotZero(t, samples["golang.goroutines"])
+	assert.NotZero(t, samples["golang.heap.allocations.allocated"])
+	assert.Contains(t, samples, "golang.heap.gc.total_pause.ns")
+	if _, ok := samples["system.process.memory.rss.bytes"]; ok {
+		// Process CPU usage is reported from the second metricset.
+		assert.Contains(t, samples, "system.process.cpu.total.norm.pct")
+	}
+}
+
+func TestPublisherFailed(t *testing.T) {
+	failed := failedCounter.Get()
+	processor := model.ProcessBatchFunc(func(ctx context.Context, b *model.Batch) error {
+		return errors.New("boom")
+	})
+	publisher := NewPublisher(processor, 10*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+	assert.Eventually(t, func() bool {
+		snapshot := monitoring.CollectFlatSnapshot(monitoringRegistry, monitoring.Full, false)
+		return snapshot.Ints["failed"] > failed
+	}, 10*time.Second, 10*time.Millisecond)
+}
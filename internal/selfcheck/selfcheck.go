@@ -0,0 +1,203 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+// Package selfcheck provides a read-your-own-ingest verification mode,
+// which periodically indexes a synthetic marker document through the
+// normal event processing pipeline and searches for it in Elasticsearch,
+// recording the round-trip lag.
+package selfcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gofrs/uuid"
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	// markerLabel is the label holding the unique ID of a marker document.
+	markerLabel = "apm_server_self_check_id"
+
+	// defaultPollInterval is the interval between searches for a marker
+	// document while waiting for it to become searchable.
+	defaultPollInterval = time.Second
+)
+
+var (
+	monitoringRegistry = monitoring.Default.NewRegistry("apm-server.self_check")
+	checksCounter      = monitoring.NewInt(monitoringRegistry, "checks")
+	succeededCounter   = monitoring.NewInt(monitoringRegistry, "succeeded")
+	failedCounter      = monitoring.NewInt(monitoringRegistry, "failed")
+	lagGauge           = monitoring.NewInt(monitoringRegistry, "lag.ms")
+)
+
+// Checker periodically indexes marker documents and searches for them in
+// Elasticsearch, measuring the time taken for them to become searchable.
+type Checker struct {
+	client       elasticsearch.Client
+	processor    model.BatchProcessor
+	index        string
+	interval     time.Duration
+	timeout      time.Duration
+	pollInterval time.Duration
+	logger       *logp.Logger
+}
+
+// NewChecker returns a new Checker which sends marker documents through
+// batchProcessor, and searches for them using client. Marker documents are
+// application logs, and will be searched for in the application logs data
+// stream for the given namespace.
+func NewChecker(
+	client elasticsearch.Client,
+	batchProcessor model.BatchProcessor,
+	namespace string,
+	interval, timeout time.Duration,
+) *Checker {
+	return &Checker{
+		client:       client,
+		processor:    batchProcessor,
+		index:        "logs-apm.app-" + namespace,
+		interval:     interval,
+		timeout:      timeout,
+		pollInterval: defaultPollInterval,
+		logger:       logp.NewLogger(logs.Beater).Named("selfcheck"),
+	}
+}
+
+// Run runs self-checks periodically until ctx is cancelled.
+func (c *Checker) Run(ctx context.Context) error {
+	t := time.NewTicker(c.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		checksCounter.Inc()
+		lag, err := c.check(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			failedCounter.Inc()
+			c.logger.Warnf("self-check failed: %v", err)
+			continue
+		}
+		succeededCounter.Inc()
+		lagGauge.Set(lag.Milliseconds())
+		c.logger.Debugf("self-check marker document searchable after %s", lag)
+	}
+}
+
+// check indexes a single marker document, and waits for it to become
+// searchable, returning the time elapsed since the document was sent.
+func (c *Checker) check(ctx context.Context) (time.Duration, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return 0, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	batch := model.Batch{{
+		Timestamp: start,
+		Processor: model.LogProcessor,
+		Message:   "apm-server self-check",
+		Service:   model.Service{Name: "apm-server"},
+		Labels:    model.Labels{markerLabel: {Value: id.String()}},
+	}}
+	if err := c.processor.ProcessBatch(ctx, &batch); err != nil {
+		return 0, errors.Wrap(err, "failed to send marker document")
+	}
+
+	t := time.NewTicker(c.pollInterval)
+	defer t.Stop()
+	for {
+		found, err := c.search(ctx, id.String())
+		if err != nil {
+			return 0, errors.Wrap(err, "failed to search for marker document")
+		}
+		if found {
+			return time.Since(start), nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, errors.Wrap(ctx.Err(), "marker document not found")
+		case <-t.C:
+		}
+	}
+}
+
+// search reports whether the marker document with the given ID is searchable.
+func (c *Checker) search(ctx context.Context, id string) (bool, error) {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]interface{}{
+		"size": 0,
+		"query": map[string]interface{}{
+			"term": map[string]interface{}{"labels." + markerLabel: id},
+		},
+	}); err != nil {
+		return false, err
+	}
+	ignoreUnavailable := true
+	req := esapi.SearchRequest{
+		Index:             []string{c.index},
+		Body:              &buf,
+		TrackTotalHits:    true,
+		IgnoreUnavailable: &ignoreUnavailable,
+	}
+	resp, err := req.Do(ctx, c.client)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// The data stream has not been created yet.
+		return false, nil
+	}
+	if resp.IsError() {
+		body, _ := io.ReadAll(resp.Body)
+		return false, fmt.Errorf("search request failed with status code %d: %s", resp.StatusCode, body)
+	}
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int `json:"value"`
+			} `json:"total"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Hits.Total.Value > 0, nil
+}
@@ -0,0 +1,130 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package selfcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestCheckerRun(t *testing.T) {
+	var mu sync.Mutex
+	indexed := make(map[string]bool)
+	var searches int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/logs-apm.app-testing/_search", r.URL.Path)
+		var body struct {
+			Query struct {
+				Term map[string]string `json:"term"`
+			} `json:"query"`
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		id := body.Query.Term["labels."+markerLabel]
+
+		mu.Lock()
+		defer mu.Unlock()
+		searches++
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if searches == 1 {
+			// Simulate the data stream not existing yet.
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		total := 0
+		if indexed[id] {
+			total = 1
+		}
+		fmt.Fprintf(w, `{"hits":{"total":{"value":%d}}}`, total)
+	}))
+	defer srv.Close()
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+
+	var batches []model.Batch
+	processor := model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		mu.Lock()
+		defer mu.Unlock()
+		batches = append(batches, *batch)
+		indexed[(*batch)[0].Labels[markerLabel].Value] = true
+		return nil
+	})
+
+	checker := NewChecker(client, processor, "testing", 10*time.Millisecond, time.Second)
+	checker.pollInterval = time.Millisecond
+
+	before := monitoring.CollectFlatSnapshot(monitoringRegistry, monitoring.Full, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- checker.Run(ctx) }()
+
+	assert.Eventually(t, func() bool {
+		snapshot := monitoring.CollectFlatSnapshot(monitoringRegistry, monitoring.Full, false)
+		return snapshot.Ints["succeeded"]-before.Ints["succeeded"] >= 2
+	}, 10*time.Second, 10*time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-done)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.GreaterOrEqual(t, len(batches), 2)
+	event := batches[0][0]
+	assert.Equal(t, model.LogProcessor, event.Processor)
+	assert.Equal(t, "apm-server", event.Service.Name)
+	assert.NotEqual(t, batches[0][0].Labels[markerLabel], batches[1][0].Labels[markerLabel])
+
+	snapshot := monitoring.CollectFlatSnapshot(monitoringRegistry, monitoring.Full, false)
+	assert.Equal(t, before.Ints["failed"], snapshot.Ints["failed"])
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		fmt.Fprint(w, `{"hits":{"total":{"value":0}}}`)
+	}))
+	defer srv.Close()
+
+	cfg := elasticsearch.DefaultConfig()
+	cfg.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(cfg)
+	require.NoError(t, err)
+
+	processor := model.ProcessBatchFunc(func(context.Context, *model.Batch) error { return nil })
+	checker := NewChecker(client, processor, "default", time.Minute, 50*time.Millisecond)
+	checker.pollInterval = time.Millisecond
+
+	_, err = checker.check(context.Background())
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
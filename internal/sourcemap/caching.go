@@ -22,6 +22,7 @@ import (
 	"math"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-sourcemap/sourcemap"
@@ -30,10 +31,16 @@ import (
 
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/monitoring"
 )
 
 const (
 	minCleanupIntervalSeconds float64 = 60
+
+	// minFailureBackoff is the backoff applied after the first temporary
+	// failure to fetch a source map. The backoff doubles with each
+	// consecutive failure, up to CachingFetcherConfig.MaxFailureBackoff.
+	minFailureBackoff = time.Second
 )
 
 var (
@@ -51,28 +58,67 @@ type Fetcher interface {
 }
 
 // CachingFetcher wraps a Fetcher, caching source maps in memory and fetching from the wrapped Fetcher on cache misses.
+//
+// Missing source maps are cached too, so that events referencing a bundle with no source map do not
+// each query the wrapped Fetcher. Temporary failures are not cached; instead, fetching is backed off
+// for the bundle, returning the last failure until the backoff has elapsed.
 type CachingFetcher struct {
-	cache   *gocache.Cache
-	backend Fetcher
-	logger  *logp.Logger
+	cache             *gocache.Cache
+	backend           Fetcher
+	logger            *logp.Logger
+	missExpiration    time.Duration
+	maxFailureBackoff time.Duration
 
 	mu       sync.Mutex
 	inflight map[string]chan struct{}
+	failures map[string]*fetchFailure
+
+	hits         int64
+	misses       int64
+	fetches      int64
+	fetchFailed  int64
+	fetchBackoff int64
+}
+
+// CachingFetcherConfig holds configuration for CachingFetcher.
+type CachingFetcherConfig struct {
+	// Expiration holds the duration for which fetched source maps are cached.
+	Expiration time.Duration
+
+	// MissExpiration holds the duration for which missing source maps, and
+	// non-temporary failures to fetch them, are cached. If MissExpiration is
+	// zero, Expiration is used.
+	MissExpiration time.Duration
+
+	// MaxFailureBackoff holds the maximum duration for which fetching a source
+	// map is skipped after consecutive temporary failures to fetch it. If
+	// MaxFailureBackoff is zero, fetching is never backed off.
+	MaxFailureBackoff time.Duration
 }
 
-// NewCachingFetcher returns a CachingFetcher that wraps backend, caching results for the configured cacheExpiration.
-func NewCachingFetcher(
-	backend Fetcher,
-	cacheExpiration time.Duration,
-) (*CachingFetcher, error) {
-	if cacheExpiration < 0 {
+type fetchFailure struct {
+	err      error
+	failures int
+	until    time.Time
+}
+
+// NewCachingFetcher returns a CachingFetcher that wraps backend, caching results as configured by cfg.
+func NewCachingFetcher(backend Fetcher, cfg CachingFetcherConfig) (*CachingFetcher, error) {
+	if cfg.Expiration < 0 || cfg.MissExpiration < 0 || cfg.MaxFailureBackoff < 0 {
 		return nil, errInit
 	}
+	missExpiration := cfg.MissExpiration
+	if missExpiration == 0 {
+		missExpiration = cfg.Expiration
+	}
 	return &CachingFetcher{
-		cache:    gocache.New(cacheExpiration, cleanupInterval(cacheExpiration)),
-		backend:  backend,
-		logger:   logp.NewLogger(logs.Sourcemap),
-		inflight: make(map[string]chan struct{}),
+		cache:             gocache.New(cfg.Expiration, cleanupInterval(cfg.Expiration)),
+		backend:           backend,
+		logger:            logp.NewLogger(logs.Sourcemap),
+		missExpiration:    missExpiration,
+		maxFailureBackoff: cfg.MaxFailureBackoff,
+		inflight:          make(map[string]chan struct{}),
+		failures:          make(map[string]*fetchFailure),
 	}, nil
 }
 
@@ -83,12 +129,23 @@ func (s *CachingFetcher) Fetch(ctx context.Context, name, version, path string)
 	// fetch from cache
 	if val, found := s.cache.Get(key); found {
 		consumer, _ := val.(*sourcemap.Consumer)
+		if consumer != nil {
+			atomic.AddInt64(&s.hits, 1)
+		} else {
+			atomic.AddInt64(&s.misses, 1)
+		}
 		return consumer, nil
 	}
 
 	// if the value hasn't been found, check to see if there's an inflight
 	// request to update the value.
 	s.mu.Lock()
+	if failure, ok := s.failures[key]; ok && time.Now().Before(failure.until) {
+		// fetching recently failed, and is being backed off.
+		s.mu.Unlock()
+		atomic.AddInt64(&s.fetchBackoff, 1)
+		return nil, failure.err
+	}
 	wait, ok := s.inflight[key]
 	if ok {
 		// found an inflight request, wait for it to complete.
@@ -120,19 +177,79 @@ func (s *CachingFetcher) Fetch(ctx context.Context, name, version, path string)
 	}()
 
 	// fetch from the store and ensure caching for all non-temporary results
+	atomic.AddInt64(&s.fetches, 1)
 	consumer, err := s.backend.Fetch(ctx, name, version, path)
 	if err != nil {
-		if !strings.Contains(err.Error(), errMsgFailure) {
-			s.add(key, nil)
+		if strings.Contains(err.Error(), errMsgFailure) {
+			atomic.AddInt64(&s.fetchFailed, 1)
+			s.recordFailure(key, err)
+			return nil, err
 		}
+		s.clearFailure(key)
+		s.add(key, nil)
 		return nil, err
 	}
+	s.clearFailure(key)
 	s.add(key, consumer)
 	return consumer, nil
 }
 
+// recordFailure records a temporary failure to fetch the source map
+// identified by key, backing off subsequent fetches exponentially.
+func (s *CachingFetcher) recordFailure(key string, err error) {
+	if s.maxFailureBackoff <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	failure, ok := s.failures[key]
+	if !ok {
+		failure = &fetchFailure{}
+		s.failures[key] = failure
+	}
+	failure.err = err
+	failure.failures++
+	backoff := s.maxFailureBackoff
+	if shift := failure.failures - 1; shift < 32 && minFailureBackoff<<shift < backoff {
+		backoff = minFailureBackoff << shift
+	}
+	failure.until = time.Now().Add(backoff)
+	s.logger.Debugf("Fetching %v failed %d times, backing off for %v.", key, failure.failures, backoff)
+}
+
+// clearFailure clears any recorded failures to fetch the
+// source map identified by key, ending its backoff.
+func (s *CachingFetcher) clearFailure(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.failures, key)
+}
+
+// CollectMonitoring may be called to collect monitoring metrics from the
+// fetcher. It is intended to be used with libbeat/monitoring.NewFunc.
+//
+// The metrics should be added to the "apm-server.sourcemap" registry.
+func (s *CachingFetcher) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+	V.OnRegistryStart()
+	defer V.OnRegistryFinished()
+
+	monitoring.ReportNamespace(V, "cache", func() {
+		monitoring.ReportInt(V, "hits", atomic.LoadInt64(&s.hits))
+		monitoring.ReportInt(V, "misses", atomic.LoadInt64(&s.misses))
+	})
+	monitoring.ReportNamespace(V, "fetch", func() {
+		monitoring.ReportInt(V, "total", atomic.LoadInt64(&s.fetches))
+		monitoring.ReportInt(V, "failed", atomic.LoadInt64(&s.fetchFailed))
+		monitoring.ReportInt(V, "backoff", atomic.LoadInt64(&s.fetchBackoff))
+	})
+}
+
 func (s *CachingFetcher) add(key string, consumer *sourcemap.Consumer) {
-	s.cache.SetDefault(key, consumer)
+	if consumer == nil {
+		s.cache.Set(key, consumer, s.missExpiration)
+	} else {
+		s.cache.SetDefault(key, consumer)
+	}
 	if !s.logger.IsDebug() {
 		return
 	}
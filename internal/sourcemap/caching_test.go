@@ -35,6 +35,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/elastic/elastic-agent-libs/monitoring"
+
 	"github.com/elastic/apm-server/internal/elasticsearch"
 )
 
@@ -49,12 +51,17 @@ var unsupportedVersionSourcemap = `{
 }`
 
 func Test_NewCachingFetcher(t *testing.T) {
-	_, err := NewCachingFetcher(nil, -1)
+	_, err := NewCachingFetcher(nil, CachingFetcherConfig{Expiration: -1})
+	require.Error(t, err)
+	_, err = NewCachingFetcher(nil, CachingFetcherConfig{Expiration: 100, MissExpiration: -1})
+	require.Error(t, err)
+	_, err = NewCachingFetcher(nil, CachingFetcherConfig{Expiration: 100, MaxFailureBackoff: -1})
 	require.Error(t, err)
 
-	f, err := NewCachingFetcher(nil, 100)
+	f, err := NewCachingFetcher(nil, CachingFetcherConfig{Expiration: 100})
 	require.NoError(t, err)
 	assert.NotNil(t, f.cache)
+	assert.Equal(t, time.Duration(100), f.missExpiration)
 }
 
 func TestStore_Fetch(t *testing.T) {
@@ -195,7 +202,7 @@ func TestFetchContext(t *testing.T) {
 	}})
 	assert.NoError(t, err)
 
-	store, err := NewCachingFetcher(fleetFetcher, time.Minute)
+	store, err := NewCachingFetcher(fleetFetcher, CachingFetcherConfig{Expiration: time.Minute})
 	require.NoError(t, err)
 
 	ctx, cancel := context.WithCancel(context.Background())
@@ -268,7 +275,7 @@ func TestConcurrentFetch(t *testing.T) {
 		}})
 		assert.NoError(t, err)
 
-		fetcher, err := NewCachingFetcher(fleetFetcher, time.Minute)
+		fetcher, err := NewCachingFetcher(fleetFetcher, CachingFetcherConfig{Expiration: time.Minute})
 		assert.NoError(t, err)
 
 		var wg sync.WaitGroup
@@ -333,7 +340,118 @@ func TestCleanupInterval(t *testing.T) {
 
 func testCachingFetcher(t *testing.T, client elasticsearch.Client) *CachingFetcher {
 	esFetcher := NewElasticsearchFetcher(client, "apm-*sourcemap*")
-	cachingFetcher, err := NewCachingFetcher(esFetcher, time.Minute)
+	cachingFetcher, err := NewCachingFetcher(esFetcher, CachingFetcherConfig{Expiration: time.Minute})
 	require.NoError(t, err)
 	return cachingFetcher
 }
+
+func TestMissExpiration(t *testing.T) {
+	var calls int
+	fetcher := fetcherFunc(func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		calls++
+		return nil, nil
+	})
+	store, err := NewCachingFetcher(fetcher, CachingFetcherConfig{
+		Expiration:     time.Minute,
+		MissExpiration: 25 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	// missing source maps are cached for MissExpiration.
+	for i := 0; i < 2; i++ {
+		mapper, err := store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+		require.NoError(t, err)
+		assert.Nil(t, mapper)
+	}
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, int64(1), store.misses)
+
+	time.Sleep(25 * time.Millisecond)
+	_, err = store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+}
+
+func TestFailureBackoff(t *testing.T) {
+	var calls int
+	var fetchErr error = errors.New(errMsgESFailure)
+	fetcher := fetcherFunc(func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		calls++
+		if fetchErr != nil {
+			return nil, fetchErr
+		}
+		return &sourcemap.Consumer{}, nil
+	})
+	store, err := NewCachingFetcher(fetcher, CachingFetcherConfig{
+		Expiration:        time.Minute,
+		MaxFailureBackoff: time.Minute,
+	})
+	require.NoError(t, err)
+
+	// temporary failures are not cached, but back off fetching
+	// for the bundle, returning the last error in the meantime.
+	for i := 0; i < 3; i++ {
+		mapper, err := store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+		assert.Equal(t, fetchErr, err)
+		assert.Nil(t, mapper)
+	}
+	assert.Equal(t, 1, calls)
+	_, found := store.cache.Get("foo_1.0.1_/tmp")
+	assert.False(t, found)
+
+	// other bundles are unaffected.
+	_, err = store.Fetch(context.Background(), "foo", "1.0.1", "/other")
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, 2, calls)
+
+	// the backoff doubles with each consecutive failure.
+	store.failures["foo_1.0.1_/tmp"].until = time.Now()
+	_, err = store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+	assert.Equal(t, fetchErr, err)
+	assert.Equal(t, 3, calls)
+	assert.WithinDuration(t, time.Now().Add(2*minFailureBackoff), store.failures["foo_1.0.1_/tmp"].until, time.Second)
+
+	// a successful fetch after the backoff ends it.
+	fetchErr = nil
+	store.failures["foo_1.0.1_/tmp"].until = time.Now()
+	mapper, err := store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+	require.NoError(t, err)
+	assert.NotNil(t, mapper)
+	assert.NotContains(t, store.failures, "foo_1.0.1_/tmp")
+
+	registry := monitoring.NewRegistry()
+	monitoring.NewFunc(registry, "sourcemap", store.CollectMonitoring)
+	snapshot := monitoring.CollectFlatSnapshot(registry, monitoring.Full, false)
+	assert.Equal(t, map[string]int64{
+		"sourcemap.cache.hits":    0,
+		"sourcemap.cache.misses":  0,
+		"sourcemap.fetch.total":   4,
+		"sourcemap.fetch.failed":  3,
+		"sourcemap.fetch.backoff": 2,
+	}, snapshot.Ints)
+}
+
+func TestFailureBackoffMax(t *testing.T) {
+	fetcher := fetcherFunc(func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		return nil, errors.New(errMsgESFailure)
+	})
+	store, err := NewCachingFetcher(fetcher, CachingFetcherConfig{
+		Expiration:        time.Minute,
+		MaxFailureBackoff: 3 * time.Second,
+	})
+	require.NoError(t, err)
+
+	for i := 0; i < 40; i++ {
+		if failure, ok := store.failures["foo_1.0.1_/tmp"]; ok {
+			failure.until = time.Now()
+		}
+		store.Fetch(context.Background(), "foo", "1.0.1", "/tmp")
+	}
+	assert.WithinDuration(t, time.Now().Add(3*time.Second), store.failures["foo_1.0.1_/tmp"].until, time.Second)
+}
+
+type fetcherFunc func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error)
+
+func (f fetcherFunc) Fetch(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+	return f(ctx, name, version, path)
+}
@@ -57,6 +57,17 @@ func NewKibanaFetcher(c *kibana.Client) Fetcher {
 
 // Fetch fetches a source map from Kibana.
 func (s *kibanaFetcher) Fetch(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+	artifact, err := s.fetchArtifact(ctx, name, version, path)
+	if err != nil || artifact == nil {
+		return nil, err
+	}
+	return parseSourceMap(string(artifact.Body.SourceMap))
+}
+
+// fetchArtifact fetches the Kibana source map artifact matching the given
+// service name, service version, and bundle filepath. If there is no matching
+// artifact, fetchArtifact returns nil.
+func (s *kibanaFetcher) fetchArtifact(ctx context.Context, name, version, path string) (*kibanaSourceMapArtifact, error) {
 	resp, err := s.client.Send(ctx, "GET", "/api/apm/sourcemaps", nil, nil, nil)
 	if err != nil {
 		return nil, err
@@ -74,12 +85,12 @@ func (s *kibanaFetcher) Fetch(ctx context.Context, name, version, path string) (
 		return nil, err
 	}
 	path = maybeParseURLPath(path)
-	for _, a := range result.Artifacts {
+	for i, a := range result.Artifacts {
 		if a.Type != sourcemapArtifactType {
 			continue
 		}
 		if a.Body.ServiceName == name && a.Body.ServiceVersion == version && maybeParseURLPath(a.Body.BundleFilepath) == path {
-			return parseSourceMap(string(a.Body.SourceMap))
+			return &result.Artifacts[i], nil
 		}
 	}
 	return nil, nil
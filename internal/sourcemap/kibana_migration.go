@@ -0,0 +1,216 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sourcemap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-sourcemap/sourcemap"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/kibana"
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// kibanaUnavailableBackoff is the amount of time for which source maps will
+// be fetched only from Elasticsearch after a request to Kibana fails.
+const kibanaUnavailableBackoff = 30 * time.Second
+
+type kibanaMigrationFetcher struct {
+	kibana *kibanaFetcher
+	es     Fetcher
+	client elasticsearch.Client
+	index  string
+	logger *logp.Logger
+	now    func() time.Time
+
+	mu                 sync.Mutex
+	migrated           map[string]struct{}
+	kibanaRetryAfter   time.Time
+	kibanaUnavailable  bool
+	migratedSourcemaps int
+}
+
+// NewKibanaMigrationFetcher returns a Fetcher for migrating source maps
+// stored by Kibana to Elasticsearch.
+//
+// Source maps are fetched from Kibana, falling back to Elasticsearch when
+// Kibana has no matching source map or cannot be reached. Source maps found
+// in Kibana are indexed into the given Elasticsearch index, such that they
+// may later be fetched from Elasticsearch with the same search index pattern
+// used by searchIndex.
+//
+// When a request to Kibana fails, source maps will be fetched only from
+// Elasticsearch for a short period of time, to avoid adding latency to each
+// fetch while Kibana is unavailable.
+func NewKibanaMigrationFetcher(
+	kibanaClient *kibana.Client,
+	esClient elasticsearch.Client,
+	searchIndex, migrationIndex string,
+) Fetcher {
+	logger := logp.NewLogger(logs.Sourcemap)
+	return &kibanaMigrationFetcher{
+		kibana:   &kibanaFetcher{kibanaClient, logger},
+		es:       &esFetcher{esClient, searchIndex, logger},
+		client:   esClient,
+		index:    migrationIndex,
+		logger:   logger,
+		now:      time.Now,
+		migrated: make(map[string]struct{}),
+	}
+}
+
+// Fetch fetches a source map from Kibana or Elasticsearch, migrating source
+// maps found in Kibana to Elasticsearch.
+func (f *kibanaMigrationFetcher) Fetch(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+	var kibanaErr error
+	if f.kibanaAvailable() {
+		artifact, err := f.kibana.fetchArtifact(ctx, name, version, path)
+		if err != nil {
+			f.setKibanaUnavailable(err)
+			kibanaErr = err
+		} else {
+			f.setKibanaAvailable()
+			if artifact != nil {
+				consumer, err := parseSourceMap(string(artifact.Body.SourceMap))
+				if err != nil {
+					return nil, err
+				}
+				f.migrate(ctx, artifact)
+				return consumer, nil
+			}
+		}
+	}
+	consumer, err := f.es.Fetch(ctx, name, version, path)
+	if err != nil {
+		return nil, err
+	}
+	if consumer == nil && kibanaErr != nil {
+		return nil, kibanaErr
+	}
+	return consumer, nil
+}
+
+func (f *kibanaMigrationFetcher) kibanaAvailable() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.now().Before(f.kibanaRetryAfter)
+}
+
+func (f *kibanaMigrationFetcher) setKibanaAvailable() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.kibanaUnavailable {
+		f.kibanaUnavailable = false
+		f.logger.Info("Kibana available again, resuming source map migration")
+	}
+}
+
+func (f *kibanaMigrationFetcher) setKibanaUnavailable(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kibanaRetryAfter = f.now().Add(kibanaUnavailableBackoff)
+	if !f.kibanaUnavailable {
+		f.kibanaUnavailable = true
+		f.logger.Warnf(
+			"failed to fetch source maps from Kibana, fetching only from Elasticsearch for %s: %v",
+			kibanaUnavailableBackoff, err,
+		)
+	}
+}
+
+// migrate indexes a source map artifact fetched from Kibana into
+// Elasticsearch, if it has not already been migrated. Failure to
+// migrate a source map is logged, and does not fail the fetch.
+func (f *kibanaMigrationFetcher) migrate(ctx context.Context, artifact *kibanaSourceMapArtifact) {
+	name := artifact.Body.ServiceName
+	version := artifact.Body.ServiceVersion
+	path := artifact.Body.BundleFilepath
+	key := cacheKey([]string{name, version, path})
+	f.mu.Lock()
+	if _, ok := f.migrated[key]; ok {
+		f.mu.Unlock()
+		return
+	}
+	f.migrated[key] = struct{}{}
+	f.mu.Unlock()
+
+	if err := f.indexArtifact(ctx, artifact); err != nil {
+		f.mu.Lock()
+		delete(f.migrated, key)
+		f.mu.Unlock()
+		f.logger.Errorf(
+			"failed to migrate source map for service %s version %s and file %s to Elasticsearch: %v",
+			name, version, path, err,
+		)
+		return
+	}
+
+	f.mu.Lock()
+	f.migratedSourcemaps++
+	migrated := f.migratedSourcemaps
+	f.mu.Unlock()
+	f.logger.Infof(
+		"migrated source map for service %s version %s and file %s from Kibana to Elasticsearch index %s (%d migrated)",
+		name, version, path, f.index, migrated,
+	)
+}
+
+// indexArtifact indexes a source map artifact fetched from Kibana into
+// Elasticsearch, using the same document structure as source maps
+// uploaded to older versions of apm-server.
+func (f *kibanaMigrationFetcher) indexArtifact(ctx context.Context, artifact *kibanaSourceMapArtifact) error {
+	doc := map[string]interface{}{
+		"@timestamp": f.now().UTC().Format(time.RFC3339Nano),
+		"processor": map[string]interface{}{
+			"name":  "sourcemap",
+			"event": "sourcemap",
+		},
+		"sourcemap": map[string]interface{}{
+			"service": map[string]interface{}{
+				"name":    artifact.Body.ServiceName,
+				"version": artifact.Body.ServiceVersion,
+			},
+			"bundle_filepath": artifact.Body.BundleFilepath,
+			"sourcemap":       string(artifact.Body.SourceMap),
+		},
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(doc); err != nil {
+		return err
+	}
+	resp, err := esapi.IndexRequest{Index: f.index, Body: &buf}.Do(ctx, f.client)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.IsError() {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("failed to index source map (%s): %s", resp.Status(), body)
+	}
+	return nil
+}
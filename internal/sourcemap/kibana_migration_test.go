@@ -0,0 +1,176 @@
+// Licensed to Elasticsearch B.V. under one or more contributor
+// license agreements. See the NOTICE file distributed with
+// this work for additional information regarding copyright
+// ownership. Elasticsearch B.V. licenses this file to you under
+// the Apache License, Version 2.0 (the "License"); you may
+// not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing,
+// software distributed under the License is distributed on an
+// "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY
+// KIND, either express or implied.  See the License for the
+// specific language governing permissions and limitations
+// under the License.
+
+package sourcemap
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/elasticsearch"
+)
+
+func TestKibanaMigrationFetcher(t *testing.T) {
+	var kibanaRequests int
+	kibanaClient := newTestKibanaClient(t, func(w http.ResponseWriter, r *http.Request) {
+		kibanaRequests++
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"artifacts": []interface{}{map[string]interface{}{
+				"type": "sourcemap",
+				"body": map[string]interface{}{
+					"serviceName":    "service_name",
+					"serviceVersion": "service_version",
+					"bundleFilepath": "http://another_host:456/path",
+					"sourceMap":      json.RawMessage(validSourcemap),
+				},
+			}},
+		})
+	})
+	es := newTestMigrationElasticsearch(t)
+	fetcher := NewKibanaMigrationFetcher(kibanaClient, es.client, "apm-*-sourcemap*", "apm-8.0.0-sourcemap")
+
+	for i := 0; i < 2; i++ {
+		consumer, err := fetcher.Fetch(context.Background(), "service_name", "service_version", "http://host:123/path")
+		require.NoError(t, err)
+		assert.NotNil(t, consumer)
+	}
+	assert.Equal(t, 2, kibanaRequests)
+	assert.Zero(t, es.searches)
+
+	// The source map should be migrated only once.
+	require.Len(t, es.indexed, 1)
+	assert.Equal(t, "/apm-8.0.0-sourcemap/_doc", es.indexed[0].path)
+	doc := es.indexed[0].doc
+	assert.Contains(t, doc, "@timestamp")
+	delete(doc, "@timestamp")
+	sourcemapFields := doc["sourcemap"].(map[string]interface{})
+	assert.JSONEq(t, validSourcemap, sourcemapFields["sourcemap"].(string))
+	delete(sourcemapFields, "sourcemap")
+	assert.Equal(t, map[string]interface{}{
+		"processor": map[string]interface{}{
+			"name":  "sourcemap",
+			"event": "sourcemap",
+		},
+		"sourcemap": map[string]interface{}{
+			"service": map[string]interface{}{
+				"name":    "service_name",
+				"version": "service_version",
+			},
+			"bundle_filepath": "http://another_host:456/path",
+		},
+	}, doc)
+}
+
+func TestKibanaMigrationFetcherNotFound(t *testing.T) {
+	kibanaClient := newTestKibanaClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"artifacts":[]}`))
+	})
+	es := newTestMigrationElasticsearch(t)
+	fetcher := NewKibanaMigrationFetcher(kibanaClient, es.client, "apm-*-sourcemap*", "apm-8.0.0-sourcemap")
+
+	consumer, err := fetcher.Fetch(context.Background(), "service_name", "service_version", "http://host:123/path")
+	require.NoError(t, err)
+	assert.NotNil(t, consumer)
+	assert.Equal(t, 1, es.searches)
+	assert.Empty(t, es.indexed)
+}
+
+func TestKibanaMigrationFetcherKibanaUnavailable(t *testing.T) {
+	var kibanaRequests int
+	kibanaClient := newTestKibanaClient(t, func(w http.ResponseWriter, r *http.Request) {
+		kibanaRequests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	es := newTestMigrationElasticsearch(t)
+	fetcher := NewKibanaMigrationFetcher(kibanaClient, es.client, "apm-*-sourcemap*", "apm-8.0.0-sourcemap")
+	now := time.Now()
+	fetcher.(*kibanaMigrationFetcher).now = func() time.Time { return now }
+
+	fetch := func() {
+		consumer, err := fetcher.Fetch(context.Background(), "service_name", "service_version", "http://host:123/path")
+		require.NoError(t, err)
+		assert.NotNil(t, consumer)
+	}
+
+	// Source maps are fetched from Elasticsearch when Kibana fails, and
+	// Kibana is not queried again until the backoff period has elapsed.
+	fetch()
+	fetch()
+	assert.Equal(t, 1, kibanaRequests)
+	assert.Equal(t, 2, es.searches)
+
+	now = now.Add(kibanaUnavailableBackoff)
+	fetch()
+	assert.Equal(t, 2, kibanaRequests)
+	assert.Equal(t, 3, es.searches)
+	assert.Empty(t, es.indexed)
+}
+
+type testMigrationElasticsearch struct {
+	client elasticsearch.Client
+
+	mu       sync.Mutex
+	searches int
+	indexed  []indexedSourcemap
+}
+
+type indexedSourcemap struct {
+	path string
+	doc  map[string]interface{}
+}
+
+// newTestMigrationElasticsearch returns a testMigrationElasticsearch with an
+// elasticsearch.Client configured to send requests to an httptest.Server that
+// responds to source map search requests with a valid source map, and records
+// indexed documents.
+func newTestMigrationElasticsearch(t testing.TB) *testMigrationElasticsearch {
+	es := &testMigrationElasticsearch{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		es.mu.Lock()
+		defer es.mu.Unlock()
+		if r.Method == http.MethodPost && r.URL.Path == "/apm-*-sourcemap*/_search" {
+			es.searches++
+			io.Copy(w, sourcemapSearchResponseBody(1, []map[string]interface{}{sourcemapHit(validSourcemap)}))
+			return
+		}
+		var doc map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		es.indexed = append(es.indexed, indexedSourcemap{path: r.URL.Path, doc: doc})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"result":"created"}`))
+	}))
+	t.Cleanup(srv.Close)
+	config := elasticsearch.DefaultConfig()
+	config.Hosts = []string{srv.URL}
+	client, err := elasticsearch.NewClient(config)
+	require.NoError(t, err)
+	es.client = client
+	return es
+}
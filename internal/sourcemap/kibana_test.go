@@ -135,6 +135,10 @@ func TestKibanaFetcherServerError(t *testing.T) {
 }
 
 func newTestKibanaFetcher(t testing.TB, h http.HandlerFunc) Fetcher {
+	return NewKibanaFetcher(newTestKibanaClient(t, h))
+}
+
+func newTestKibanaClient(t testing.TB, h http.HandlerFunc) *kibana.Client {
 	mux := http.NewServeMux()
 	mux.HandleFunc("/api/apm/sourcemaps", h)
 	srv := httptest.NewServer(mux)
@@ -144,5 +148,5 @@ func newTestKibanaFetcher(t testing.TB, h http.HandlerFunc) Fetcher {
 		Host: srv.Listener.Addr().String(),
 	})
 	require.NoError(t, err)
-	return NewKibanaFetcher(kibanaClient)
+	return kibanaClient
 }
@@ -30,6 +30,10 @@ import (
 	"github.com/elastic/apm-server/internal/model"
 )
 
+// errEventTimeout is set as the source map error of the frames of events
+// which could not be source mapped within BatchProcessor.EventTimeout.
+const errEventTimeout = "timed out applying source maps"
+
 // BatchProcessor is a model.BatchProcessor that performs source mapping for
 // span and error events. Any errors fetching source maps, including the
 // timeout expiring, will result in the StacktraceFrame.SourcemapError field
@@ -43,6 +47,23 @@ type BatchProcessor struct {
 	//
 	// If Timeout is <= 0, it will be ignored.
 	Timeout time.Duration
+
+	// EventTimeout holds a timeout for source mapping each event. If it
+	// expires, the event's stack trace frames are left unmapped, so that
+	// an event with frames from a huge bundle does not hold up the rest
+	// of the batch.
+	//
+	// If EventTimeout is <= 0, it will be ignored.
+	EventTimeout time.Duration
+
+	// Semaphore, if non-nil, bounds the number of events being source
+	// mapped concurrently. Events are source mapped in parallel, each
+	// one acquiring the semaphore by sending to it. The semaphore is
+	// intended to be shared by all BatchProcessors.
+	//
+	// If Semaphore is nil, events are source mapped sequentially by
+	// the goroutine calling ProcessBatch.
+	Semaphore chan struct{}
 }
 
 // ProcessBatch processes spans and errors, applying source maps
@@ -53,29 +74,126 @@ func (p BatchProcessor) ProcessBatch(ctx context.Context, batch *model.Batch) er
 		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
 		defer cancel()
 	}
-	for _, event := range *batch {
+	var wg sync.WaitGroup
+	for i := range *batch {
+		event := &(*batch)[i]
 		if event.Service.Name == "" || event.Service.Version == "" {
 			continue
 		}
-		switch {
-		case event.Span != nil:
-			p.processStacktraceFrames(ctx, &event.Service, event.Span.Stacktrace...)
-		case event.Error != nil:
-			if event.Error.Log != nil {
-				p.processStacktraceFrames(ctx, &event.Service, event.Error.Log.Stacktrace...)
+		stacktraces := eventStacktraces(event)
+		if len(stacktraces) == 0 {
+			continue
+		}
+		if p.Semaphore == nil {
+			p.processStacktraces(ctx, &event.Service, stacktraces, func() {})
+			continue
+		}
+		select {
+		case p.Semaphore <- struct{}{}:
+		case <-ctx.Done():
+			setSourcemapError(stacktraces, ctx.Err().Error())
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.processStacktraces(ctx, &event.Service, stacktraces, func() { <-p.Semaphore })
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// eventStacktraces returns the stack traces of a span or error event.
+func eventStacktraces(event *model.APMEvent) [][]*model.StacktraceFrame {
+	var stacktraces [][]*model.StacktraceFrame
+	add := func(stacktrace model.Stacktrace) {
+		if len(stacktrace) > 0 {
+			stacktraces = append(stacktraces, stacktrace)
+		}
+	}
+	switch {
+	case event.Span != nil:
+		add(event.Span.Stacktrace)
+	case event.Error != nil:
+		if event.Error.Log != nil {
+			add(event.Error.Log.Stacktrace)
+		}
+		if event.Error.Exception != nil {
+			var addException func(*model.Exception)
+			addException = func(exception *model.Exception) {
+				add(exception.Stacktrace)
+				for i := range exception.Cause {
+					addException(&exception.Cause[i])
+				}
 			}
-			if event.Error.Exception != nil {
-				p.processException(ctx, &event.Service, event.Error.Exception)
+			addException(event.Error.Exception)
+		}
+	}
+	return stacktraces
+}
+
+// processStacktraces applies source maps to the stack traces of an event,
+// calling release when done.
+//
+// If EventTimeout is positive, the frames are source mapped on copies in
+// a separate goroutine, and only updated if that completes in time. On
+// timeout, processStacktraces returns with the frames left unmapped, and
+// release is called later, when the goroutine completes.
+func (p BatchProcessor) processStacktraces(
+	ctx context.Context,
+	service *model.Service,
+	stacktraces [][]*model.StacktraceFrame,
+	release func(),
+) {
+	if p.EventTimeout <= 0 {
+		defer release()
+		for _, frames := range stacktraces {
+			p.processStacktraceFrames(ctx, service, frames...)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.EventTimeout)
+	defer cancel()
+	mapped := make([][]*model.StacktraceFrame, len(stacktraces))
+	for i, frames := range stacktraces {
+		mapped[i] = make([]*model.StacktraceFrame, len(frames))
+		for j, frame := range frames {
+			frameCopy := *frame
+			mapped[i][j] = &frameCopy
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer release()
+		defer close(done)
+		for _, frames := range mapped {
+			p.processStacktraceFrames(ctx, service, frames...)
+		}
+	}()
+	select {
+	case <-done:
+		for i, frames := range stacktraces {
+			for j, frame := range frames {
+				*frame = *mapped[i][j]
 			}
 		}
+	case <-ctx.Done():
+		setSourcemapError(stacktraces, errEventTimeout)
+		getProcessorLogger().Debugf("failed to apply source maps: %s", errEventTimeout)
 	}
-	return nil
 }
 
-func (p BatchProcessor) processException(ctx context.Context, service *model.Service, exception *model.Exception) {
-	p.processStacktraceFrames(ctx, service, exception.Stacktrace...)
-	for _, cause := range exception.Cause {
-		p.processException(ctx, service, &cause)
+// setSourcemapError sets the source map error of the frames which would
+// otherwise have been source mapped.
+func setSourcemapError(stacktraces [][]*model.StacktraceFrame, err string) {
+	for _, frames := range stacktraces {
+		for _, frame := range frames {
+			if frame.Colno != nil && frame.Lineno != nil && frame.AbsPath != "" {
+				frame.SourcemapError = err
+			}
+		}
 	}
 }
 
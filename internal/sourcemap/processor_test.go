@@ -20,9 +20,11 @@ package sourcemap
 import (
 	"context"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/go-sourcemap/sourcemap"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -37,7 +39,7 @@ func TestBatchProcessor(t *testing.T) {
 		sourcemapSearchResponseBody(1, []map[string]interface{}{sourcemapHit(string(validSourcemap))}),
 	)
 	esFetcher := NewElasticsearchFetcher(client, "index")
-	fetcher, err := NewCachingFetcher(esFetcher, time.Minute)
+	fetcher, err := NewCachingFetcher(esFetcher, CachingFetcherConfig{Expiration: time.Minute})
 	require.NoError(t, err)
 
 	originalLinenoWithFilename := 1
@@ -296,6 +298,104 @@ func TestBatchProcessorTimeout(t *testing.T) {
 	assert.Less(t, taken, time.Second)
 }
 
+func TestBatchProcessorEventTimeout(t *testing.T) {
+	consumer, err := sourcemap.Parse("", []byte(validSourcemap))
+	require.NoError(t, err)
+	unblock := make(chan struct{})
+	defer close(unblock)
+	var fetcher fetcherFunc = func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		if name == "slow_service" {
+			// Ignore ctx, like applying a source map for a huge bundle.
+			<-unblock
+		}
+		return consumer, nil
+	}
+
+	newSpan := func(serviceName string) model.APMEvent {
+		return model.APMEvent{
+			Service: model.Service{Name: serviceName, Version: "service_version"},
+			Span: &model.Span{
+				Stacktrace: model.Stacktrace{{AbsPath: "bundle.js", Lineno: newInt(1), Colno: newInt(7)}},
+			},
+		}
+	}
+	batch := model.Batch{newSpan("slow_service"), newSpan("fast_service")}
+
+	processor := BatchProcessor{Fetcher: fetcher, EventTimeout: 50 * time.Millisecond}
+	err = processor.ProcessBatch(context.Background(), &batch)
+	assert.NoError(t, err)
+
+	slowFrame := batch[0].Span.Stacktrace[0]
+	assert.False(t, slowFrame.SourcemapUpdated)
+	assert.Equal(t, errEventTimeout, slowFrame.SourcemapError)
+	assert.Equal(t, 1, *slowFrame.Lineno)
+	assert.Equal(t, 7, *slowFrame.Colno)
+
+	fastFrame := batch[1].Span.Stacktrace[0]
+	assert.True(t, fastFrame.SourcemapUpdated)
+	assert.Empty(t, fastFrame.SourcemapError)
+	assert.Equal(t, "webpack:///bundle.js", fastFrame.Filename)
+}
+
+func TestBatchProcessorSemaphore(t *testing.T) {
+	var mu sync.Mutex
+	var active, maxActive, calls int
+	var fetcher fetcherFunc = func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		mu.Lock()
+		active++
+		calls++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return nil, nil
+	}
+
+	var batch model.Batch
+	for i := 0; i < 10; i++ {
+		batch = append(batch, model.APMEvent{
+			Service: model.Service{Name: "service_name", Version: "service_version"},
+			Error: &model.Error{
+				Exception: &model.Exception{
+					Stacktrace: model.Stacktrace{{AbsPath: "bundle.js", Lineno: newInt(1), Colno: newInt(1)}},
+				},
+			},
+		})
+	}
+	processor := BatchProcessor{
+		Fetcher:      fetcher,
+		EventTimeout: time.Minute,
+		Semaphore:    make(chan struct{}, 2),
+	}
+	err := processor.ProcessBatch(context.Background(), &batch)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, calls)
+	assert.Equal(t, 2, maxActive)
+	assert.Len(t, processor.Semaphore, 0)
+}
+
+func TestBatchProcessorSemaphoreTimeout(t *testing.T) {
+	semaphore := make(chan struct{}, 1)
+	semaphore <- struct{}{} // exhaust the semaphore
+	var fetcher fetcherFunc = func(ctx context.Context, name, version, path string) (*sourcemap.Consumer, error) {
+		panic("unexpected fetch")
+	}
+	span := model.APMEvent{
+		Service: model.Service{Name: "service_name", Version: "service_version"},
+		Span: &model.Span{
+			Stacktrace: model.Stacktrace{{AbsPath: "bundle.js", Lineno: newInt(1), Colno: newInt(1)}},
+		},
+	}
+	processor := BatchProcessor{Fetcher: fetcher, Timeout: 10 * time.Millisecond, Semaphore: semaphore}
+	err := processor.ProcessBatch(context.Background(), &model.Batch{span})
+	assert.NoError(t, err)
+	assert.Equal(t, context.DeadlineExceeded.Error(), span.Span.Stacktrace[0].SourcemapError)
+}
+
 func cloneFrame(frame model.StacktraceFrame) *model.StacktraceFrame {
 	return &frame
 }
@@ -114,6 +114,7 @@ func TestFleetIntegrationMonitoring(t *testing.T) {
 		"elasticsearch": map[string]interface{}{
 			"bulk_requests": map[string]interface{}{
 				"completed": 1.0,
+				"split":     0.0,
 			},
 			"indexers": map[string]interface{}{
 				"active":    float64(1),
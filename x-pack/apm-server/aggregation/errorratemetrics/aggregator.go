@@ -0,0 +1,289 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package errorratemetrics provides an aggregator which pre-aggregates error
+// counts and transaction outcomes by service, environment, and transaction
+// type, for cheap alerting on error rates without searching raw events.
+package errorratemetrics
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	metricsetName = "service_error_rate"
+
+	errorCountMetric       = "error_rate.error.count"
+	errorsPerMinuteMetric  = "error_rate.error.per_minute"
+	transactionCountMetric = "error_rate.transaction.count"
+	failureRatioMetric     = "error_rate.transaction.failure_ratio"
+)
+
+// AggregatorConfig holds configuration for creating an Aggregator.
+type AggregatorConfig struct {
+	// BatchProcessor is a model.BatchProcessor for asynchronously
+	// processing metrics documents.
+	BatchProcessor model.BatchProcessor
+
+	// MaxGroups is the maximum number of distinct groups to store within an
+	// aggregation period. Once this number of groups is reached, any new
+	// aggregation keys will cause individual metrics documents to be
+	// immediately published.
+	MaxGroups int
+
+	// Interval is the interval between publishing of aggregated metrics.
+	Interval time.Duration
+
+	// Logger is the logger for logging metrics aggregation/publishing.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+}
+
+// Validate validates the aggregator config.
+func (config AggregatorConfig) Validate() error {
+	if config.BatchProcessor == nil {
+		return errors.New("BatchProcessor unspecified")
+	}
+	if config.MaxGroups <= 0 {
+		return errors.New("MaxGroups unspecified or negative")
+	}
+	if config.Interval <= 0 {
+		return errors.New("Interval unspecified or negative")
+	}
+	return nil
+}
+
+// Aggregator aggregates error counts and transaction outcomes, periodically
+// publishing error rate metrics.
+type Aggregator struct {
+	stopMu   sync.Mutex
+	stopping chan struct{}
+	stopped  chan struct{}
+
+	config AggregatorConfig
+
+	mu sync.Mutex
+	// active holds the metrics being aggregated in the current period,
+	// and inactive the metrics being published. They are swapped when
+	// publishing.
+	active, inactive map[aggregationKey]*errorRateMetrics
+}
+
+// NewAggregator returns a new Aggregator with the given config.
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid aggregator config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.ErrorRateMetrics)
+	}
+	return &Aggregator{
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+		config:   config,
+		active:   make(map[aggregationKey]*errorRateMetrics),
+		inactive: make(map[aggregationKey]*errorRateMetrics),
+	}, nil
+}
+
+// Run runs the Aggregator, periodically publishing and clearing aggregated
+// metrics. Run returns when either a fatal error occurs, or the Aggregator's
+// Stop method is invoked.
+func (a *Aggregator) Run() error {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	defer func() {
+		a.stopMu.Lock()
+		defer a.stopMu.Unlock()
+		select {
+		case <-a.stopped:
+		default:
+			close(a.stopped)
+		}
+	}()
+	var stop bool
+	for !stop {
+		select {
+		case <-a.stopping:
+			stop = true
+		case <-ticker.C:
+		}
+		if err := a.publish(context.Background()); err != nil {
+			a.config.Logger.With(logp.Error(err)).Warnf(
+				"publishing error rate metrics failed: %s", err,
+			)
+		}
+	}
+	return nil
+}
+
+// Stop stops the Aggregator if it is running, waiting for it to flush any
+// aggregated metrics and return, or for the context to be cancelled.
+//
+// After Stop has been called the aggregator cannot be reused, as the Run
+// method will always return immediately.
+func (a *Aggregator) Stop(ctx context.Context) error {
+	a.stopMu.Lock()
+	select {
+	case <-a.stopped:
+	case <-a.stopping:
+		// Already stopping/stopped.
+	default:
+		close(a.stopping)
+	}
+	a.stopMu.Unlock()
+
+	select {
+	case <-a.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (a *Aggregator) publish(ctx context.Context) error {
+	a.mu.Lock()
+	a.active, a.inactive = a.inactive, a.active
+	a.mu.Unlock()
+
+	if len(a.inactive) == 0 {
+		a.config.Logger.Debugf("no error rate metrics to publish")
+		return nil
+	}
+	batch := make(model.Batch, 0, len(a.inactive))
+	for key, metrics := range a.inactive {
+		batch = append(batch, a.makeMetricset(key, *metrics))
+		delete(a.inactive, key)
+	}
+	a.config.Logger.Debugf("publishing %d metricsets", len(batch))
+	return a.config.BatchProcessor.ProcessBatch(ctx, &batch)
+}
+
+// ProcessBatch aggregates the errors and transactions in b. If the maximum
+// number of groups has been reached, metrics for events of new groups are
+// added to b, rather than being aggregated.
+func (a *Aggregator) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, event := range *b {
+		var metrics errorRateMetrics
+		switch event.Processor {
+		case model.ErrorProcessor:
+			metrics.errorCount = 1
+		case model.TransactionProcessor:
+			if event.Transaction == nil || event.Transaction.RepresentativeCount <= 0 {
+				continue
+			}
+			count := event.Transaction.RepresentativeCount
+			metrics.transactionCount = count
+			switch event.Event.Outcome {
+			case "failure":
+				metrics.failureCount = count
+			case "success":
+				metrics.successCount = count
+			}
+		default:
+			continue
+		}
+		key := makeAggregationKey(&event, a.config.Interval)
+		if existing, ok := a.active[key]; ok {
+			existing.add(metrics)
+			continue
+		}
+		switch n := len(a.active); n {
+		case a.config.MaxGroups:
+			*b = append(*b, a.makeMetricset(key, metrics))
+			continue
+		case a.config.MaxGroups/2 - 1:
+			a.config.Logger.Warn("error rate metrics groups reached 50% capacity")
+		case a.config.MaxGroups - 1:
+			a.config.Logger.Warn("error rate metrics groups reached 100% capacity")
+		}
+		a.active[key] = &metrics
+	}
+	return nil
+}
+
+type aggregationKey struct {
+	timestamp time.Time
+
+	serviceName        string
+	serviceEnvironment string
+	transactionType    string
+}
+
+func makeAggregationKey(event *model.APMEvent, interval time.Duration) aggregationKey {
+	key := aggregationKey{
+		// Group metrics by time interval.
+		timestamp: event.Timestamp.Truncate(interval),
+
+		serviceName:        event.Service.Name,
+		serviceEnvironment: event.Service.Environment,
+	}
+	if event.Transaction != nil {
+		key.transactionType = event.Transaction.Type
+	}
+	return key
+}
+
+type errorRateMetrics struct {
+	errorCount       float64
+	transactionCount float64
+	failureCount     float64
+	successCount     float64
+}
+
+func (m *errorRateMetrics) add(other errorRateMetrics) {
+	m.errorCount += other.errorCount
+	m.transactionCount += other.transactionCount
+	m.failureCount += other.failureCount
+	m.successCount += other.successCount
+}
+
+func (a *Aggregator) makeMetricset(key aggregationKey, metrics errorRateMetrics) model.APMEvent {
+	errorCount := math.Round(metrics.errorCount)
+	transactionCount := math.Round(metrics.transactionCount)
+	samples := []model.MetricsetSample{
+		{Name: errorCountMetric, Value: errorCount},
+		{Name: errorsPerMinuteMetric, Value: errorCount / a.config.Interval.Minutes()},
+		{Name: transactionCountMetric, Value: transactionCount},
+	}
+	// The failure ratio only considers transactions with a known
+	// outcome, and is omitted if there are none.
+	if outcomes := metrics.failureCount + metrics.successCount; outcomes > 0 {
+		samples = append(samples, model.MetricsetSample{
+			Name:  failureRatioMetric,
+			Value: metrics.failureCount / outcomes,
+		})
+	}
+	return model.APMEvent{
+		Timestamp: key.timestamp,
+		Service: model.Service{
+			Name:        key.serviceName,
+			Environment: key.serviceEnvironment,
+		},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     metricsetName,
+			DocCount: int64(errorCount + transactionCount),
+			Samples:  samples,
+		},
+		Transaction: &model.Transaction{
+			Type:         key.transactionType,
+			FailureCount: int(math.Round(metrics.failureCount)),
+			SuccessCount: int(math.Round(metrics.successCount)),
+		},
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package errorratemetrics
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestNewAggregatorConfigInvalid(t *testing.T) {
+	report := model.ProcessBatchFunc(func(context.Context, *model.Batch) error { return nil })
+	for _, test := range []struct {
+		config AggregatorConfig
+		err    string
+	}{{
+		config: AggregatorConfig{},
+		err:    "BatchProcessor unspecified",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report},
+		err:    "MaxGroups unspecified or negative",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report, MaxGroups: 1},
+		err:    "Interval unspecified or negative",
+	}} {
+		agg, err := NewAggregator(test.config)
+		assert.Nil(t, agg)
+		assert.EqualError(t, err, "invalid aggregator config: "+test.err)
+	}
+}
+
+func TestAggregatorRun(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       time.Minute,
+		MaxGroups:      1000,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	batch := model.Batch{
+		makeTransaction("backend", "production", "request", "success", 3, now),
+		makeTransaction("backend", "production", "request", "failure", 1, now),
+		makeTransaction("backend", "production", "request", "unknown", 2, now),
+		makeTransaction("backend", "production", "request", "failure", 0, now), // ignored
+		makeError("backend", "production", "request", now),
+		makeError("backend", "production", "request", now),
+		makeTransaction("backend", "staging", "messaging", "unknown", 1, now),
+		makeError("backend", "staging", "", now),
+		{Processor: model.SpanProcessor, Service: model.Service{Name: "backend"}}, // ignored
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 9) // no metricsets added
+
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	require.NoError(t, agg.Stop(context.Background()))
+
+	var published model.Batch
+	select {
+	case published = <-batches:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for metrics to be published")
+	}
+	sort.Slice(published, func(i, j int) bool {
+		ki, kj := published[i], published[j]
+		if ki.Service.Environment != kj.Service.Environment {
+			return ki.Service.Environment < kj.Service.Environment
+		}
+		return ki.Transaction.Type < kj.Transaction.Type
+	})
+
+	timestamp := now.Truncate(time.Minute)
+	assert.Equal(t, model.Batch{{
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "backend", Environment: "production"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_error_rate",
+			DocCount: 8,
+			Samples: []model.MetricsetSample{
+				{Name: "error_rate.error.count", Value: 2},
+				{Name: "error_rate.error.per_minute", Value: 2},
+				{Name: "error_rate.transaction.count", Value: 6},
+				{Name: "error_rate.transaction.failure_ratio", Value: 0.25},
+			},
+		},
+		Transaction: &model.Transaction{Type: "request", FailureCount: 1, SuccessCount: 3},
+	}, {
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "backend", Environment: "staging"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_error_rate",
+			DocCount: 1,
+			Samples: []model.MetricsetSample{
+				{Name: "error_rate.error.count", Value: 1},
+				{Name: "error_rate.error.per_minute", Value: 1},
+				{Name: "error_rate.transaction.count", Value: 0},
+			},
+		},
+		Transaction: &model.Transaction{},
+	}, {
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "backend", Environment: "staging"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_error_rate",
+			DocCount: 1,
+			Samples: []model.MetricsetSample{
+				{Name: "error_rate.error.count", Value: 0},
+				{Name: "error_rate.error.per_minute", Value: 0},
+				{Name: "error_rate.transaction.count", Value: 1},
+			},
+		},
+		Transaction: &model.Transaction{Type: "messaging"},
+	}}, published)
+}
+
+func TestAggregatorErrorsPerMinute(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       5 * time.Minute,
+		MaxGroups:      1000,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	var batch model.Batch
+	for i := 0; i < 10; i++ {
+		batch = append(batch, makeError("backend", "", "request", now))
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.publish(context.Background()))
+
+	published := <-batches
+	require.Len(t, published, 1)
+	assert.Equal(t, []model.MetricsetSample{
+		{Name: "error_rate.error.count", Value: 10},
+		{Name: "error_rate.error.per_minute", Value: 2},
+		{Name: "error_rate.transaction.count", Value: 0},
+	}, published[0].Metricset.Samples)
+}
+
+func TestAggregatorMaxGroups(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       time.Minute,
+		MaxGroups:      2,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	batch := model.Batch{
+		makeError("service1", "", "", now),
+		makeError("service2", "", "", now),
+		makeError("service1", "", "", now),
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 3)
+
+	// The third group exceeds the maximum, so a metricset
+	// is added to the batch for the event immediately.
+	batch = model.Batch{makeError("service3", "", "", now)}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.Len(t, batch, 2)
+	assert.Equal(t, "service3", batch[1].Service.Name)
+	assert.Equal(t, "service_error_rate", batch[1].Metricset.Name)
+	assert.Equal(t, int64(1), batch[1].Metricset.DocCount)
+
+	require.NoError(t, agg.publish(context.Background()))
+	published := <-batches
+	assert.Len(t, published, 2)
+}
+
+func makeTransaction(serviceName, serviceEnvironment, transactionType, outcome string, count float64, timestamp time.Time) model.APMEvent {
+	return model.APMEvent{
+		Timestamp: timestamp,
+		Service:   model.Service{Name: serviceName, Environment: serviceEnvironment},
+		Event:     model.Event{Outcome: outcome},
+		Processor: model.TransactionProcessor,
+		Transaction: &model.Transaction{
+			Type:                transactionType,
+			RepresentativeCount: count,
+		},
+	}
+}
+
+func makeError(serviceName, serviceEnvironment, transactionType string, timestamp time.Time) model.APMEvent {
+	event := model.APMEvent{
+		Timestamp: timestamp,
+		Service:   model.Service{Name: serviceName, Environment: serviceEnvironment},
+		Processor: model.ErrorProcessor,
+		Error:     &model.Error{},
+	}
+	if transactionType != "" {
+		event.Transaction = &model.Transaction{Type: transactionType}
+	}
+	return event
+}
+
+func makeChanBatchProcessor(ch chan<- model.Batch) model.BatchProcessor {
+	return model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- *batch:
+			return nil
+		}
+	})
+}
@@ -18,6 +18,8 @@ type AggregatedGlobalLabels struct {
 	Labels           model.Labels
 	numericLabelKeys []string
 	NumericLabels    model.NumericLabels
+	booleanLabelKeys []string
+	BooleanLabels    model.BooleanLabels
 }
 
 func (a *AggregatedGlobalLabels) Write(w io.Writer) {
@@ -47,6 +49,24 @@ func (a *AggregatedGlobalLabels) Write(w io.Writer) {
 			w.Write(b[:])
 		}
 	}
+	for _, key := range a.booleanLabelKeys {
+		label := a.BooleanLabels[key]
+		io.WriteString(w, key)
+		if len(label.Values) == 0 {
+			w.Write([]byte{boolByte(label.Value)})
+			continue
+		}
+		for _, v := range label.Values {
+			w.Write([]byte{boolByte(v)})
+		}
+	}
+}
+
+func boolByte(v bool) byte {
+	if v {
+		return 1
+	}
+	return 0
 }
 
 func (a *AggregatedGlobalLabels) Read(event *model.APMEvent) {
@@ -78,12 +98,29 @@ func (a *AggregatedGlobalLabels) Read(event *model.APMEvent) {
 		}
 		a.numericLabelKeys = append(a.numericLabelKeys, k)
 	}
+	for k, v := range event.BooleanLabels {
+		if !v.Global {
+			continue
+		}
+		if a.BooleanLabels == nil {
+			a.BooleanLabels = make(model.BooleanLabels)
+		}
+		if len(v.Values) > 0 {
+			a.BooleanLabels.SetSlice(k, v.Values)
+		} else {
+			a.BooleanLabels.Set(k, v.Value)
+		}
+		a.booleanLabelKeys = append(a.booleanLabelKeys, k)
+	}
 	sort.Strings(a.labelKeys)
 	sort.Strings(a.numericLabelKeys)
+	sort.Strings(a.booleanLabelKeys)
 }
 
 func (a *AggregatedGlobalLabels) Equals(x *AggregatedGlobalLabels) bool {
-	return equalLabels(a.Labels, x.Labels) && equalNumericLabels(a.NumericLabels, x.NumericLabels)
+	return equalLabels(a.Labels, x.Labels) &&
+		equalNumericLabels(a.NumericLabels, x.NumericLabels) &&
+		equalBooleanLabels(a.BooleanLabels, x.BooleanLabels)
 }
 
 // equalLabels returns true if the labels are equal. The Global property is
@@ -139,3 +176,30 @@ func equalNumericLabels(l, labels model.NumericLabels) bool {
 	}
 	return true
 }
+
+// equalBooleanLabels returns true if the labels are equal. The Global property
+// is ignored since only global labels are compared.
+func equalBooleanLabels(l, labels model.BooleanLabels) bool {
+	if len(l) != len(labels) {
+		return false
+	}
+	for key, localV := range l {
+		v, ok := labels[key]
+		if !ok {
+			return false
+		}
+		// If the slice value is set, ignore the Value field.
+		if len(v.Values) == 0 && v.Value != localV.Value {
+			return false
+		}
+		if len(v.Values) != len(localV.Values) {
+			return false
+		}
+		for i, value := range v.Values {
+			if localV.Values[i] != value {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -183,3 +183,49 @@ func TestNumericLabelsEqual(t *testing.T) {
 		assert.False(t, equalNumericLabels(b, a))
 	})
 }
+
+func TestBooleanLabelsEqual(t *testing.T) {
+	t.Run("true", func(t *testing.T) {
+		a := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: true},
+			"b": model.BooleanLabelValue{Values: []bool{true, false}},
+		}
+		b := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: true},
+			"b": model.BooleanLabelValue{Values: []bool{true, false}, Value: true},
+		}
+		assert.True(t, equalBooleanLabels(a, b))
+		assert.True(t, equalBooleanLabels(b, a))
+	})
+	t.Run("false-length", func(t *testing.T) {
+		a := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: true},
+		}
+		b := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: true},
+			"b": model.BooleanLabelValue{Value: false},
+		}
+		assert.False(t, equalBooleanLabels(a, b))
+		assert.False(t, equalBooleanLabels(b, a))
+	})
+	t.Run("false-values-not-match", func(t *testing.T) {
+		a := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: true},
+		}
+		b := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Value: false},
+		}
+		assert.False(t, equalBooleanLabels(a, b))
+		assert.False(t, equalBooleanLabels(b, a))
+	})
+	t.Run("false-slices-elements-not-match", func(t *testing.T) {
+		a := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Values: []bool{true, false}},
+		}
+		b := model.BooleanLabels{
+			"a": model.BooleanLabelValue{Values: []bool{true, true}},
+		}
+		assert.False(t, equalBooleanLabels(a, b))
+		assert.False(t, equalBooleanLabels(b, a))
+	})
+}
@@ -0,0 +1,418 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package mobilemetrics provides an aggregator which computes mobile vitals
+// metrics, such as crash-free sessions and users, ANR rates, and application
+// launch times, from the events of mobile agents, so they can be charted
+// without searching raw events.
+package mobilemetrics
+
+import (
+	"context"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	metricsetName = "service_mobile_vitals"
+
+	// launchTimeMetric is the name of the application launch time
+	// metric reported by the mobile agents.
+	launchTimeMetric = "application.launch.time"
+
+	sessionsCountMetric          = "mobile.sessions.count"
+	sessionsCrashedMetric        = "mobile.sessions.crashed"
+	sessionsCrashFreeRatioMetric = "mobile.sessions.crash_free_ratio"
+	sessionsANRMetric            = "mobile.sessions.anr"
+	sessionsANRRatioMetric       = "mobile.sessions.anr_ratio"
+	usersCountMetric             = "mobile.users.count"
+	usersCrashedMetric           = "mobile.users.crashed"
+	usersCrashFreeRatioMetric    = "mobile.users.crash_free_ratio"
+	crashesCountMetric           = "mobile.crashes.count"
+	anrCountMetric               = "mobile.anr.count"
+	appLaunchCountMetric         = "mobile.app_launch.count"
+	appLaunchTimeAvgMetric       = "mobile.app_launch.time.avg"
+	appLaunchTimeMaxMetric       = "mobile.app_launch.time.max"
+)
+
+// AggregatorConfig holds configuration for creating an Aggregator.
+type AggregatorConfig struct {
+	// BatchProcessor is a model.BatchProcessor for asynchronously
+	// processing metrics documents.
+	BatchProcessor model.BatchProcessor
+
+	// MaxGroups is the maximum number of distinct groups to store within an
+	// aggregation period. Once this number of groups is reached, events of
+	// new groups are not aggregated until the next period.
+	MaxGroups int
+
+	// MaxSessions is the maximum number of distinct sessions, and of
+	// distinct users, to track for each group within an aggregation
+	// period. Once this number is reached, new sessions or users of the
+	// group are not counted, and the crash-free ratios are computed from
+	// the sessions and users tracked.
+	MaxSessions int
+
+	// Interval is the interval between publishing of aggregated metrics.
+	Interval time.Duration
+
+	// Logger is the logger for logging metrics aggregation/publishing.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+}
+
+// Validate validates the aggregator config.
+func (config AggregatorConfig) Validate() error {
+	if config.BatchProcessor == nil {
+		return errors.New("BatchProcessor unspecified")
+	}
+	if config.MaxGroups <= 0 {
+		return errors.New("MaxGroups unspecified or negative")
+	}
+	if config.MaxSessions <= 0 {
+		return errors.New("MaxSessions unspecified or negative")
+	}
+	if config.Interval <= 0 {
+		return errors.New("Interval unspecified or negative")
+	}
+	return nil
+}
+
+// Aggregator aggregates the events of mobile agents, periodically
+// publishing mobile vitals metrics.
+type Aggregator struct {
+	stopMu   sync.Mutex
+	stopping chan struct{}
+	stopped  chan struct{}
+
+	config AggregatorConfig
+
+	mu sync.Mutex
+	// active holds the metrics being aggregated in the current period,
+	// and inactive the metrics being published. They are swapped when
+	// publishing.
+	active, inactive map[aggregationKey]*mobileMetrics
+}
+
+// NewAggregator returns a new Aggregator with the given config.
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid aggregator config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.MobileMetrics)
+	}
+	return &Aggregator{
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+		config:   config,
+		active:   make(map[aggregationKey]*mobileMetrics),
+		inactive: make(map[aggregationKey]*mobileMetrics),
+	}, nil
+}
+
+// Run runs the Aggregator, periodically publishing and clearing aggregated
+// metrics. Run returns when either a fatal error occurs, or the Aggregator's
+// Stop method is invoked.
+func (a *Aggregator) Run() error {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	defer func() {
+		a.stopMu.Lock()
+		defer a.stopMu.Unlock()
+		select {
+		case <-a.stopped:
+		default:
+			close(a.stopped)
+		}
+	}()
+	var stop bool
+	for !stop {
+		select {
+		case <-a.stopping:
+			stop = true
+		case <-ticker.C:
+		}
+		if err := a.publish(context.Background()); err != nil {
+			a.config.Logger.With(logp.Error(err)).Warnf(
+				"publishing mobile vitals metrics failed: %s", err,
+			)
+		}
+	}
+	return nil
+}
+
+// Stop stops the Aggregator if it is running, waiting for it to flush any
+// aggregated metrics and return, or for the context to be cancelled.
+//
+// After Stop has been called the aggregator cannot be reused, as the Run
+// method will always return immediately.
+func (a *Aggregator) Stop(ctx context.Context) error {
+	a.stopMu.Lock()
+	select {
+	case <-a.stopped:
+	case <-a.stopping:
+		// Already stopping/stopped.
+	default:
+		close(a.stopping)
+	}
+	a.stopMu.Unlock()
+
+	select {
+	case <-a.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (a *Aggregator) publish(ctx context.Context) error {
+	a.mu.Lock()
+	a.active, a.inactive = a.inactive, a.active
+	a.mu.Unlock()
+
+	if len(a.inactive) == 0 {
+		a.config.Logger.Debugf("no mobile vitals metrics to publish")
+		return nil
+	}
+	batch := make(model.Batch, 0, len(a.inactive))
+	for key, metrics := range a.inactive {
+		batch = append(batch, makeMetricset(key, metrics))
+		delete(a.inactive, key)
+	}
+	a.config.Logger.Debugf("publishing %d metricsets", len(batch))
+	return a.config.BatchProcessor.ProcessBatch(ctx, &batch)
+}
+
+// ProcessBatch aggregates the events of mobile agents in b. Events of
+// other agents are ignored.
+func (a *Aggregator) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range *b {
+		event := &(*b)[i]
+		if !isMobileAgentName(event.Agent.Name) || event.Service.Name == "" {
+			continue
+		}
+		key := makeAggregationKey(event, a.config.Interval)
+		metrics, ok := a.active[key]
+		if !ok {
+			switch n := len(a.active); n {
+			case a.config.MaxGroups:
+				continue
+			case a.config.MaxGroups/2 - 1:
+				a.config.Logger.Warn("mobile vitals metrics groups reached 50% capacity")
+			case a.config.MaxGroups - 1:
+				a.config.Logger.Warn("mobile vitals metrics groups reached 100% capacity")
+			}
+			metrics = newMobileMetrics()
+			a.active[key] = metrics
+		}
+		metrics.add(event, a.config.MaxSessions)
+	}
+	return nil
+}
+
+// isMobileAgentName reports whether agentName is the name of a mobile agent.
+func isMobileAgentName(agentName string) bool {
+	switch agentName {
+	case "android/java", "iOS/swift":
+		return true
+	}
+	return false
+}
+
+type aggregationKey struct {
+	timestamp time.Time
+
+	agentName          string
+	serviceName        string
+	serviceVersion     string
+	serviceEnvironment string
+}
+
+func makeAggregationKey(event *model.APMEvent, interval time.Duration) aggregationKey {
+	return aggregationKey{
+		// Group metrics by time interval.
+		timestamp: event.Timestamp.Truncate(interval),
+
+		agentName:          event.Agent.Name,
+		serviceName:        event.Service.Name,
+		serviceVersion:     event.Service.Version,
+		serviceEnvironment: event.Service.Environment,
+	}
+}
+
+// sessionState records whether a session or user experienced a crash
+// or ANR within the aggregation period.
+type sessionState uint8
+
+const (
+	sessionCrashed sessionState = 1 << iota
+	sessionANR
+)
+
+type mobileMetrics struct {
+	sessions map[string]sessionState
+	users    map[string]sessionState
+
+	crashes float64
+	anrs    float64
+
+	launchCount float64
+	launchSum   float64
+	launchMax   float64
+}
+
+func newMobileMetrics() *mobileMetrics {
+	return &mobileMetrics{
+		sessions: make(map[string]sessionState),
+		users:    make(map[string]sessionState),
+	}
+}
+
+func (m *mobileMetrics) add(event *model.APMEvent, maxSessions int) {
+	var state sessionState
+	if event.Processor == model.ErrorProcessor && event.Error != nil {
+		switch {
+		case isANR(event.Error):
+			state = sessionANR
+			m.anrs++
+		case isCrash(event.Error):
+			state = sessionCrashed
+			m.crashes++
+		}
+	}
+	if event.Metricset != nil {
+		for _, sample := range event.Metricset.Samples {
+			if sample.Name == launchTimeMetric {
+				m.addLaunchTime(sample)
+			}
+		}
+	}
+	if event.Session.ID != "" {
+		trackSession(m.sessions, event.Session.ID, state, maxSessions)
+	}
+	userID := event.User.ID
+	if userID == "" {
+		userID = event.Device.ID
+	}
+	if userID != "" {
+		trackSession(m.users, userID, state, maxSessions)
+	}
+}
+
+func (m *mobileMetrics) addLaunchTime(sample model.MetricsetSample) {
+	if len(sample.Histogram.Counts) > 0 {
+		for i, count := range sample.Histogram.Counts {
+			if count <= 0 || i >= len(sample.Histogram.Values) {
+				continue
+			}
+			value := sample.Histogram.Values[i]
+			m.launchCount += float64(count)
+			m.launchSum += value * float64(count)
+			m.launchMax = math.Max(m.launchMax, value)
+		}
+		return
+	}
+	m.launchCount++
+	m.launchSum += sample.Value
+	m.launchMax = math.Max(m.launchMax, sample.Value)
+}
+
+// trackSession records state for the session or user with the given ID,
+// if it is already tracked or fewer than max are tracked.
+func trackSession(m map[string]sessionState, id string, state sessionState, max int) {
+	existing, ok := m[id]
+	if !ok && len(m) >= max {
+		return
+	}
+	m[id] = existing | state
+}
+
+// isCrash reports whether err is a crash: an error of type "crash",
+// or an unhandled exception.
+func isCrash(err *model.Error) bool {
+	if strings.EqualFold(err.Type, "crash") {
+		return true
+	}
+	return err.Exception != nil && err.Exception.Handled != nil && !*err.Exception.Handled
+}
+
+// isANR reports whether err is an Application Not Responding error.
+func isANR(err *model.Error) bool {
+	return strings.EqualFold(err.Type, "anr")
+}
+
+func makeMetricset(key aggregationKey, metrics *mobileMetrics) model.APMEvent {
+	var samples []model.MetricsetSample
+	if n := len(metrics.sessions); n > 0 {
+		crashed, anr := countStates(metrics.sessions)
+		samples = append(samples,
+			model.MetricsetSample{Name: sessionsCountMetric, Value: float64(n)},
+			model.MetricsetSample{Name: sessionsCrashedMetric, Value: crashed},
+			model.MetricsetSample{Name: sessionsCrashFreeRatioMetric, Value: (float64(n) - crashed) / float64(n)},
+			model.MetricsetSample{Name: sessionsANRMetric, Value: anr},
+			model.MetricsetSample{Name: sessionsANRRatioMetric, Value: anr / float64(n)},
+		)
+	}
+	if n := len(metrics.users); n > 0 {
+		crashed, _ := countStates(metrics.users)
+		samples = append(samples,
+			model.MetricsetSample{Name: usersCountMetric, Value: float64(n)},
+			model.MetricsetSample{Name: usersCrashedMetric, Value: crashed},
+			model.MetricsetSample{Name: usersCrashFreeRatioMetric, Value: (float64(n) - crashed) / float64(n)},
+		)
+	}
+	samples = append(samples,
+		model.MetricsetSample{Name: crashesCountMetric, Value: metrics.crashes},
+		model.MetricsetSample{Name: anrCountMetric, Value: metrics.anrs},
+	)
+	if metrics.launchCount > 0 {
+		samples = append(samples,
+			model.MetricsetSample{Name: appLaunchCountMetric, Value: math.Round(metrics.launchCount)},
+			model.MetricsetSample{Name: appLaunchTimeAvgMetric, Value: metrics.launchSum / metrics.launchCount},
+			model.MetricsetSample{Name: appLaunchTimeMaxMetric, Value: metrics.launchMax},
+		)
+	}
+	return model.APMEvent{
+		Timestamp: key.timestamp,
+		Agent:     model.Agent{Name: key.agentName},
+		Service: model.Service{
+			Name:        key.serviceName,
+			Version:     key.serviceVersion,
+			Environment: key.serviceEnvironment,
+		},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     metricsetName,
+			DocCount: int64(len(metrics.sessions)),
+			Samples:  samples,
+		},
+	}
+}
+
+// countStates returns the number of sessions or users which crashed,
+// and which experienced an ANR.
+func countStates(m map[string]sessionState) (crashed, anr float64) {
+	for _, state := range m {
+		if state&sessionCrashed != 0 {
+			crashed++
+		}
+		if state&sessionANR != 0 {
+			anr++
+		}
+	}
+	return crashed, anr
+}
@@ -0,0 +1,213 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package mobilemetrics
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestNewAggregatorConfigInvalid(t *testing.T) {
+	report := model.ProcessBatchFunc(func(context.Context, *model.Batch) error { return nil })
+	for _, test := range []struct {
+		config AggregatorConfig
+		err    string
+	}{{
+		config: AggregatorConfig{},
+		err:    "BatchProcessor unspecified",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report},
+		err:    "MaxGroups unspecified or negative",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report, MaxGroups: 1},
+		err:    "MaxSessions unspecified or negative",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report, MaxGroups: 1, MaxSessions: 1},
+		err:    "Interval unspecified or negative",
+	}} {
+		agg, err := NewAggregator(test.config)
+		assert.Nil(t, agg)
+		assert.EqualError(t, err, "invalid aggregator config: "+test.err)
+	}
+}
+
+func TestAggregatorRun(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       time.Minute,
+		MaxGroups:      1000,
+		MaxSessions:    1000,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	unhandled := false
+	batch := model.Batch{
+		makeEvent("session1", "user1", "", now),
+		makeEvent("session1", "user1", "", now),
+		makeEvent("session2", "user1", "", now),
+		makeEvent("session3", "", "device1", now),
+		makeEvent("session4", "user2", "", now),
+		makeErrorEvent("session1", "user1", "", &model.Error{Type: "crash"}, now),
+		makeErrorEvent("session3", "", "device1", &model.Error{Exception: &model.Exception{Handled: &unhandled}}, now),
+		makeErrorEvent("session4", "user2", "", &model.Error{Type: "ANR"}, now),
+		makeErrorEvent("session4", "user2", "", &model.Error{Type: "other"}, now),
+		makeLaunchTimeEvent(model.MetricsetSample{Value: 100}, now),
+		makeLaunchTimeEvent(model.MetricsetSample{Histogram: model.Histogram{
+			Values: []float64{200, 400},
+			Counts: []int64{2, 1},
+		}}, now),
+		{
+			Agent:     model.Agent{Name: "java"},
+			Service:   model.Service{Name: "backend"},
+			Processor: model.ErrorProcessor,
+			Error:     &model.Error{Type: "crash"},
+		}, // ignored
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 12) // no metricsets added
+
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	require.NoError(t, agg.Stop(context.Background()))
+
+	var published model.Batch
+	select {
+	case published = <-batches:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for metrics to be published")
+	}
+
+	assert.Equal(t, model.Batch{{
+		Timestamp: now.Truncate(time.Minute),
+		Agent:     model.Agent{Name: "android/java"},
+		Service:   model.Service{Name: "mobile-app", Version: "1.0", Environment: "production"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_mobile_vitals",
+			DocCount: 4,
+			Samples: []model.MetricsetSample{
+				{Name: "mobile.sessions.count", Value: 4},
+				{Name: "mobile.sessions.crashed", Value: 2},
+				{Name: "mobile.sessions.crash_free_ratio", Value: 0.5},
+				{Name: "mobile.sessions.anr", Value: 1},
+				{Name: "mobile.sessions.anr_ratio", Value: 0.25},
+				{Name: "mobile.users.count", Value: 3},
+				{Name: "mobile.users.crashed", Value: 2},
+				{Name: "mobile.users.crash_free_ratio", Value: 1.0 / 3},
+				{Name: "mobile.crashes.count", Value: 2},
+				{Name: "mobile.anr.count", Value: 1},
+				{Name: "mobile.app_launch.count", Value: 4},
+				{Name: "mobile.app_launch.time.avg", Value: 225},
+				{Name: "mobile.app_launch.time.max", Value: 400},
+			},
+		},
+	}}, published)
+}
+
+func TestAggregatorMaxGroups(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       time.Minute,
+		MaxGroups:      1,
+		MaxSessions:    1000,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	other := makeEvent("session2", "user2", "", now)
+	other.Service.Name = "other-app"
+	batch := model.Batch{makeEvent("session1", "user1", "", now), other}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 2)
+
+	require.NoError(t, agg.publish(context.Background()))
+	published := <-batches
+	require.Len(t, published, 1)
+	assert.Equal(t, "mobile-app", published[0].Service.Name)
+}
+
+func TestAggregatorMaxSessions(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor: makeChanBatchProcessor(batches),
+		Interval:       time.Minute,
+		MaxGroups:      1000,
+		MaxSessions:    2,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	batch := model.Batch{
+		makeEvent("session1", "user1", "", now),
+		makeEvent("session2", "user2", "", now),
+		makeEvent("session3", "user3", "", now), // not tracked
+		makeErrorEvent("session2", "user2", "", &model.Error{Type: "crash"}, now),
+		makeErrorEvent("session3", "user3", "", &model.Error{Type: "crash"}, now),
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.publish(context.Background()))
+
+	published := <-batches
+	require.Len(t, published, 1)
+	assert.Equal(t, []model.MetricsetSample{
+		{Name: "mobile.sessions.count", Value: 2},
+		{Name: "mobile.sessions.crashed", Value: 1},
+		{Name: "mobile.sessions.crash_free_ratio", Value: 0.5},
+		{Name: "mobile.sessions.anr", Value: 0},
+		{Name: "mobile.sessions.anr_ratio", Value: 0},
+		{Name: "mobile.users.count", Value: 2},
+		{Name: "mobile.users.crashed", Value: 1},
+		{Name: "mobile.users.crash_free_ratio", Value: 0.5},
+		{Name: "mobile.crashes.count", Value: 2},
+		{Name: "mobile.anr.count", Value: 0},
+	}, published[0].Metricset.Samples)
+}
+
+func makeEvent(sessionID, userID, deviceID string, timestamp time.Time) model.APMEvent {
+	return model.APMEvent{
+		Timestamp: timestamp,
+		Agent:     model.Agent{Name: "android/java"},
+		Service:   model.Service{Name: "mobile-app", Version: "1.0", Environment: "production"},
+		Session:   model.Session{ID: sessionID},
+		User:      model.User{ID: userID},
+		Device:    model.Device{ID: deviceID},
+		Processor: model.TransactionProcessor,
+	}
+}
+
+func makeErrorEvent(sessionID, userID, deviceID string, err *model.Error, timestamp time.Time) model.APMEvent {
+	event := makeEvent(sessionID, userID, deviceID, timestamp)
+	event.Processor = model.ErrorProcessor
+	event.Error = err
+	return event
+}
+
+func makeLaunchTimeEvent(sample model.MetricsetSample, timestamp time.Time) model.APMEvent {
+	event := makeEvent("", "", "", timestamp)
+	event.Processor = model.MetricsetProcessor
+	sample.Name = "application.launch.time"
+	event.Metricset = &model.Metricset{Samples: []model.MetricsetSample{sample}}
+	return event
+}
+
+func makeChanBatchProcessor(ch chan<- model.Batch) model.BatchProcessor {
+	return model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- *batch:
+			return nil
+		}
+	})
+}
@@ -366,6 +366,7 @@ func makeMetricset(key aggregationKey, metrics serviceMetrics) model.APMEvent {
 		},
 		Labels:        key.Labels,
 		NumericLabels: key.NumericLabels,
+		BooleanLabels: key.BooleanLabels,
 		Processor:     model.MetricsetProcessor,
 		Metricset: &model.Metricset{
 			DocCount: metricCount,
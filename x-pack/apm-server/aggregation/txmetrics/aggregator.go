@@ -439,6 +439,7 @@ func makeMetricset(key transactionAggregationKey, totalCount int64, counts []int
 		},
 		Labels:        key.AggregatedGlobalLabels.Labels,
 		NumericLabels: key.AggregatedGlobalLabels.NumericLabels,
+		BooleanLabels: key.AggregatedGlobalLabels.BooleanLabels,
 		Processor:     model.MetricsetProcessor,
 		Metricset: &model.Metricset{
 			Name:     metricsetName,
@@ -168,6 +168,9 @@ func TestAggregatorRun(t *testing.T) {
 				"user_id":     model.NumericLabelValue{Global: true, Value: 100},
 				"cost_center": model.NumericLabelValue{Global: true, Value: 10},
 			},
+			BooleanLabels: model.BooleanLabels{
+				"internal": model.BooleanLabelValue{Global: true, Value: true},
+			},
 			Transaction: &model.Transaction{
 				Name:                "T-1000",
 				RepresentativeCount: 1,
@@ -206,10 +209,14 @@ func TestAggregatorRun(t *testing.T) {
 		"user_id":     model.NumericLabelValue{Value: 100},
 		"cost_center": model.NumericLabelValue{Value: 10},
 	}, metricsets[0].NumericLabels)
+	assert.Equal(t, model.BooleanLabels{
+		"internal": model.BooleanLabelValue{Value: true},
+	}, metricsets[0].BooleanLabels)
 	assert.Equal(t, []int64{1000}, metricsets[0].Transaction.DurationHistogram.Counts)
 	assert.Equal(t, "T-800", metricsets[1].Transaction.Name)
 	assert.Empty(t, metricsets[1].Labels)
 	assert.Empty(t, metricsets[1].NumericLabels)
+	assert.Empty(t, metricsets[1].BooleanLabels)
 	assert.Equal(t, []int64{800}, metricsets[1].Transaction.DurationHistogram.Counts)
 
 	select {
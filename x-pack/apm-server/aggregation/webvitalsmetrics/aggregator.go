@@ -0,0 +1,409 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+// Package webvitalsmetrics provides an aggregator which computes Core Web
+// Vitals percentiles from RUM transactions, grouped by service and page,
+// so they can be charted without percentile aggregations over raw events.
+package webvitalsmetrics
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/go-hdrhistogram"
+
+	"github.com/elastic/apm-server/internal/logs"
+	"github.com/elastic/apm-server/internal/model"
+)
+
+const (
+	metricsetName = "service_web_vitals"
+
+	// largestContentfulPaintMark is the agent mark holding the
+	// Largest Contentful Paint (LCP) of page-load transactions.
+	largestContentfulPaintMark = "largestContentfulPaint"
+
+	// maxDurationMicros is the maximum LCP or INP value, in microseconds,
+	// recorded in histograms. Larger values are recorded as the maximum.
+	maxDurationMicros = int64(time.Hour / time.Microsecond)
+
+	// clsScale is the factor by which CLS values are multiplied before
+	// being recorded in histograms, as histograms record integers.
+	clsScale = 10000
+
+	// maxCLS is the maximum CLS value recorded in histograms.
+	// Larger values are recorded as the maximum.
+	maxCLS = 100
+)
+
+// vital describes a web vital metric.
+type vital struct {
+	// name is the prefix of the vital's metric names.
+	name string
+
+	// unit is the unit of the vital's metric values, if any.
+	unit string
+
+	// scale is the factor by which values are multiplied before being
+	// recorded in histograms.
+	scale float64
+
+	// max is the maximum value recorded in histograms, after scaling.
+	max int64
+}
+
+var (
+	lcpVital = vital{name: "web_vitals.lcp", unit: "ms", scale: 1000, max: maxDurationMicros}
+	inpVital = vital{name: "web_vitals.inp", unit: "ms", scale: 1000, max: maxDurationMicros}
+	clsVital = vital{name: "web_vitals.cls", scale: clsScale, max: maxCLS * clsScale}
+
+	vitals = [...]vital{lcpVital, inpVital, clsVital}
+)
+
+// PageGroup holds a rule for grouping the pages of RUM transactions.
+type PageGroup struct {
+	// Name holds the name of the page group, which is recorded as the
+	// transaction name of the metrics.
+	Name string
+
+	// Pattern holds the regular expression matched against the URL path
+	// of transactions.
+	Pattern *regexp.Regexp
+}
+
+// AggregatorConfig holds configuration for creating an Aggregator.
+type AggregatorConfig struct {
+	// BatchProcessor is a model.BatchProcessor for asynchronously
+	// processing metrics documents.
+	BatchProcessor model.BatchProcessor
+
+	// MaxGroups is the maximum number of distinct service and page group
+	// combinations to store within an aggregation period. Once this number
+	// of groups is reached, transactions of new groups are not aggregated
+	// until the next period.
+	MaxGroups int
+
+	// Interval is the interval between publishing of aggregated metrics.
+	Interval time.Duration
+
+	// HDRHistogramSignificantFigures is the number of significant figures
+	// to maintain in the HDR Histograms. HDRHistogramSignificantFigures
+	// must be in the range [1,5].
+	HDRHistogramSignificantFigures int
+
+	// PageGroups holds the rules for grouping pages, in order of
+	// precedence. The pages of transactions whose URL path does not
+	// match any rule are grouped by transaction name.
+	PageGroups []PageGroup
+
+	// Logger is the logger for logging metrics aggregation/publishing.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+}
+
+// Validate validates the aggregator config.
+func (config AggregatorConfig) Validate() error {
+	if config.BatchProcessor == nil {
+		return errors.New("BatchProcessor unspecified")
+	}
+	if config.MaxGroups <= 0 {
+		return errors.New("MaxGroups unspecified or negative")
+	}
+	if config.Interval <= 0 {
+		return errors.New("Interval unspecified or negative")
+	}
+	if n := config.HDRHistogramSignificantFigures; n < 1 || n > 5 {
+		return errors.Errorf("HDRHistogramSignificantFigures (%d) outside range [1,5]", n)
+	}
+	for _, group := range config.PageGroups {
+		if group.Name == "" || group.Pattern == nil {
+			return errors.New("PageGroups name or pattern unspecified")
+		}
+	}
+	return nil
+}
+
+// Aggregator aggregates the web vitals of RUM transactions, periodically
+// publishing percentile metrics.
+type Aggregator struct {
+	stopMu   sync.Mutex
+	stopping chan struct{}
+	stopped  chan struct{}
+
+	config AggregatorConfig
+
+	mu sync.Mutex
+	// active holds the metrics being aggregated in the current period,
+	// and inactive the metrics being published. They are swapped when
+	// publishing.
+	active, inactive map[aggregationKey]*webVitalsMetrics
+}
+
+// NewAggregator returns a new Aggregator with the given config.
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid aggregator config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.WebVitalsMetrics)
+	}
+	return &Aggregator{
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+		config:   config,
+		active:   make(map[aggregationKey]*webVitalsMetrics),
+		inactive: make(map[aggregationKey]*webVitalsMetrics),
+	}, nil
+}
+
+// Run runs the Aggregator, periodically publishing and clearing aggregated
+// metrics. Run returns when either a fatal error occurs, or the Aggregator's
+// Stop method is invoked.
+func (a *Aggregator) Run() error {
+	ticker := time.NewTicker(a.config.Interval)
+	defer ticker.Stop()
+	defer func() {
+		a.stopMu.Lock()
+		defer a.stopMu.Unlock()
+		select {
+		case <-a.stopped:
+		default:
+			close(a.stopped)
+		}
+	}()
+	var stop bool
+	for !stop {
+		select {
+		case <-a.stopping:
+			stop = true
+		case <-ticker.C:
+		}
+		if err := a.publish(context.Background()); err != nil {
+			a.config.Logger.With(logp.Error(err)).Warnf(
+				"publishing web vitals metrics failed: %s", err,
+			)
+		}
+	}
+	return nil
+}
+
+// Stop stops the Aggregator if it is running, waiting for it to flush any
+// aggregated metrics and return, or for the context to be cancelled.
+//
+// After Stop has been called the aggregator cannot be reused, as the Run
+// method will always return immediately.
+func (a *Aggregator) Stop(ctx context.Context) error {
+	a.stopMu.Lock()
+	select {
+	case <-a.stopped:
+	case <-a.stopping:
+		// Already stopping/stopped.
+	default:
+		close(a.stopping)
+	}
+	a.stopMu.Unlock()
+
+	select {
+	case <-a.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (a *Aggregator) publish(ctx context.Context) error {
+	a.mu.Lock()
+	a.active, a.inactive = a.inactive, a.active
+	a.mu.Unlock()
+
+	if len(a.inactive) == 0 {
+		a.config.Logger.Debugf("no web vitals metrics to publish")
+		return nil
+	}
+	batch := make(model.Batch, 0, len(a.inactive))
+	for key, metrics := range a.inactive {
+		batch = append(batch, makeMetricset(key, metrics))
+		delete(a.inactive, key)
+	}
+	a.config.Logger.Debugf("publishing %d metricsets", len(batch))
+	return a.config.BatchProcessor.ProcessBatch(ctx, &batch)
+}
+
+// ProcessBatch aggregates the web vitals of RUM transactions in b.
+// Other events are ignored.
+func (a *Aggregator) ProcessBatch(ctx context.Context, b *model.Batch) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for i := range *b {
+		event := &(*b)[i]
+		if event.Processor != model.TransactionProcessor || event.Transaction == nil {
+			continue
+		}
+		values, ok := webVitals(event)
+		if !ok {
+			continue
+		}
+		key := makeAggregationKey(event, a.pageGroup(event), a.config.Interval)
+		metrics, ok := a.active[key]
+		if !ok {
+			switch n := len(a.active); n {
+			case a.config.MaxGroups:
+				continue
+			case a.config.MaxGroups/2 - 1:
+				a.config.Logger.Warn("web vitals metrics groups reached 50% capacity")
+			case a.config.MaxGroups - 1:
+				a.config.Logger.Warn("web vitals metrics groups reached 100% capacity")
+			}
+			metrics = newWebVitalsMetrics(a.config.HDRHistogramSignificantFigures)
+			a.active[key] = metrics
+		}
+		metrics.transactions++
+		for i, value := range values {
+			if value >= 0 {
+				metrics.record(i, value)
+			}
+		}
+	}
+	return nil
+}
+
+// pageGroup returns the page group of the RUM transaction event: the name
+// of the first page group rule matching its URL path, or else the
+// transaction name.
+func (a *Aggregator) pageGroup(event *model.APMEvent) string {
+	if path := event.URL.Path; path != "" {
+		for _, group := range a.config.PageGroups {
+			if group.Pattern.MatchString(path) {
+				return group.Name
+			}
+		}
+	}
+	return event.Transaction.Name
+}
+
+// webVitals returns the web vitals of a RUM transaction, in the order of
+// vitals, with negative values for unknown vitals. webVitals returns false
+// if all vitals are unknown.
+func webVitals(event *model.APMEvent) (values [len(vitals)]float64, ok bool) {
+	values = [len(vitals)]float64{-1, -1, -1}
+	if lcp, found := event.Transaction.Marks["agent"][largestContentfulPaintMark]; found {
+		values[0] = lcp
+	}
+	if ux := event.Transaction.UserExperience; ux != nil {
+		values[1] = ux.InteractionToNextPaint
+		values[2] = ux.CumulativeLayoutShift
+	}
+	for _, value := range values {
+		if value >= 0 {
+			return values, true
+		}
+	}
+	return values, false
+}
+
+type aggregationKey struct {
+	timestamp time.Time
+
+	serviceName        string
+	serviceEnvironment string
+	pageGroup          string
+}
+
+func makeAggregationKey(event *model.APMEvent, pageGroup string, interval time.Duration) aggregationKey {
+	return aggregationKey{
+		// Group metrics by time interval.
+		timestamp: event.Timestamp.Truncate(interval),
+
+		serviceName:        event.Service.Name,
+		serviceEnvironment: event.Service.Environment,
+		pageGroup:          pageGroup,
+	}
+}
+
+type webVitalsMetrics struct {
+	transactions int64
+	histograms   [len(vitals)]*hdrhistogram.Histogram
+}
+
+func newWebVitalsMetrics(significantFigures int) *webVitalsMetrics {
+	var m webVitalsMetrics
+	for i, v := range vitals {
+		m.histograms[i] = hdrhistogram.New(1, v.max, significantFigures)
+	}
+	return &m
+}
+
+func (m *webVitalsMetrics) record(i int, value float64) {
+	v := vitals[i]
+	scaled := int64(math.Round(value * v.scale))
+	if scaled > v.max {
+		scaled = v.max
+	}
+	m.histograms[i].RecordValue(scaled)
+}
+
+func makeMetricset(key aggregationKey, metrics *webVitalsMetrics) model.APMEvent {
+	var samples []model.MetricsetSample
+	for i, v := range vitals {
+		h := metrics.histograms[i]
+		if h.TotalCount() == 0 {
+			continue
+		}
+		counts, values := histogramBuckets(h, v.scale)
+		samples = append(samples,
+			model.MetricsetSample{Name: v.name + ".count", Value: float64(h.TotalCount())},
+			model.MetricsetSample{Name: v.name + ".p50", Unit: v.unit, Value: percentile(h, 50, v.scale)},
+			model.MetricsetSample{Name: v.name + ".p75", Unit: v.unit, Value: percentile(h, 75, v.scale)},
+			model.MetricsetSample{Name: v.name + ".p95", Unit: v.unit, Value: percentile(h, 95, v.scale)},
+			model.MetricsetSample{
+				Name:      v.name + ".histogram",
+				Type:      model.MetricTypeHistogram,
+				Unit:      v.unit,
+				Histogram: model.Histogram{Counts: counts, Values: values},
+			},
+		)
+	}
+	return model.APMEvent{
+		Timestamp: key.timestamp,
+		Service: model.Service{
+			Name:        key.serviceName,
+			Environment: key.serviceEnvironment,
+		},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     metricsetName,
+			DocCount: metrics.transactions,
+			Samples:  samples,
+		},
+		Transaction: &model.Transaction{Name: key.pageGroup},
+	}
+}
+
+// percentile returns the value at percentile p of h, in the vital's unit.
+func percentile(h *hdrhistogram.Histogram, p float64, scale float64) float64 {
+	return float64(h.ValueAtQuantile(p)) / scale
+}
+
+// histogramBuckets returns the counts and upper limits of the non-empty
+// buckets of h, in the vital's unit, for indexing as a histogram field.
+func histogramBuckets(h *hdrhistogram.Histogram, scale float64) (counts []int64, values []float64) {
+	distribution := h.Distribution()
+	counts = make([]int64, 0, len(distribution))
+	values = make([]float64, 0, len(distribution))
+	for _, b := range distribution {
+		if b.Count <= 0 {
+			continue
+		}
+		counts = append(counts, b.Count)
+		values = append(values, float64(b.To)/scale)
+	}
+	return counts, values
+}
@@ -0,0 +1,212 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package webvitalsmetrics
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+func TestNewAggregatorConfigInvalid(t *testing.T) {
+	report := model.ProcessBatchFunc(func(context.Context, *model.Batch) error { return nil })
+	for _, test := range []struct {
+		config AggregatorConfig
+		err    string
+	}{{
+		config: AggregatorConfig{},
+		err:    "BatchProcessor unspecified",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report},
+		err:    "MaxGroups unspecified or negative",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report, MaxGroups: 1},
+		err:    "Interval unspecified or negative",
+	}, {
+		config: AggregatorConfig{BatchProcessor: report, MaxGroups: 1, Interval: time.Minute},
+		err:    "HDRHistogramSignificantFigures (0) outside range [1,5]",
+	}, {
+		config: AggregatorConfig{
+			BatchProcessor:                 report,
+			MaxGroups:                      1,
+			Interval:                       time.Minute,
+			HDRHistogramSignificantFigures: 2,
+			PageGroups:                     []PageGroup{{Name: "products"}},
+		},
+		err: "PageGroups name or pattern unspecified",
+	}} {
+		agg, err := NewAggregator(test.config)
+		assert.Nil(t, agg)
+		assert.EqualError(t, err, "invalid aggregator config: "+test.err)
+	}
+}
+
+func TestAggregatorRun(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor:                 makeChanBatchProcessor(batches),
+		Interval:                       time.Minute,
+		MaxGroups:                      1000,
+		HDRHistogramSignificantFigures: 5,
+		PageGroups: []PageGroup{{
+			Name:    "products",
+			Pattern: regexp.MustCompile("^/products/"),
+		}},
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	batch := model.Batch{
+		makeTransaction("/products/1", "/products/:id", 100, -1, 0.1, now),
+		makeTransaction("/products/2", "/products/:id", 200, 40, 0.2, now),
+		makeTransaction("/products/3", "/products/:id", 300, -1, -1, now),
+		makeTransaction("/checkout", "/checkout", -1, 80, -1, now),
+		makeTransaction("/checkout", "/checkout", -1, -1, -1, now),                 // ignored: no web vitals
+		{Processor: model.SpanProcessor, Service: model.Service{Name: "frontend"}}, // ignored
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	assert.Len(t, batch, 6) // no metricsets added
+
+	go agg.Run()
+	defer agg.Stop(context.Background())
+	require.NoError(t, agg.Stop(context.Background()))
+
+	var published model.Batch
+	select {
+	case published = <-batches:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for metrics to be published")
+	}
+	sort.Slice(published, func(i, j int) bool {
+		return published[i].Transaction.Name < published[j].Transaction.Name
+	})
+
+	timestamp := now.Truncate(time.Minute)
+	assert.Equal(t, model.Batch{{
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "frontend", Environment: "production"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_web_vitals",
+			DocCount: 1,
+			Samples: []model.MetricsetSample{
+				{Name: "web_vitals.inp.count", Value: 1},
+				{Name: "web_vitals.inp.p50", Unit: "ms", Value: 80},
+				{Name: "web_vitals.inp.p75", Unit: "ms", Value: 80},
+				{Name: "web_vitals.inp.p95", Unit: "ms", Value: 80},
+				{
+					Name: "web_vitals.inp.histogram", Type: model.MetricTypeHistogram, Unit: "ms",
+					Histogram: model.Histogram{Counts: []int64{1}, Values: []float64{80}},
+				},
+			},
+		},
+		Transaction: &model.Transaction{Name: "/checkout"},
+	}, {
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "frontend", Environment: "production"},
+		Processor: model.MetricsetProcessor,
+		Metricset: &model.Metricset{
+			Name:     "service_web_vitals",
+			DocCount: 3,
+			Samples: []model.MetricsetSample{
+				{Name: "web_vitals.lcp.count", Value: 3},
+				{Name: "web_vitals.lcp.p50", Unit: "ms", Value: 200},
+				{Name: "web_vitals.lcp.p75", Unit: "ms", Value: 200},
+				{Name: "web_vitals.lcp.p95", Unit: "ms", Value: 300.001}, // highest equivalent value
+
+				{
+					Name: "web_vitals.lcp.histogram", Type: model.MetricTypeHistogram, Unit: "ms",
+					Histogram: model.Histogram{Counts: []int64{1, 1, 1}, Values: []float64{100, 200, 300.001}},
+				},
+				{Name: "web_vitals.inp.count", Value: 1},
+				{Name: "web_vitals.inp.p50", Unit: "ms", Value: 40},
+				{Name: "web_vitals.inp.p75", Unit: "ms", Value: 40},
+				{Name: "web_vitals.inp.p95", Unit: "ms", Value: 40},
+				{
+					Name: "web_vitals.inp.histogram", Type: model.MetricTypeHistogram, Unit: "ms",
+					Histogram: model.Histogram{Counts: []int64{1}, Values: []float64{40}},
+				},
+				{Name: "web_vitals.cls.count", Value: 2},
+				{Name: "web_vitals.cls.p50", Value: 0.1},
+				{Name: "web_vitals.cls.p75", Value: 0.2},
+				{Name: "web_vitals.cls.p95", Value: 0.2},
+				{
+					Name: "web_vitals.cls.histogram", Type: model.MetricTypeHistogram,
+					Histogram: model.Histogram{Counts: []int64{1, 1}, Values: []float64{0.1, 0.2}},
+				},
+			},
+		},
+		Transaction: &model.Transaction{Name: "products"},
+	}}, published)
+}
+
+func TestAggregatorMaxGroups(t *testing.T) {
+	batches := make(chan model.Batch, 1)
+	agg, err := NewAggregator(AggregatorConfig{
+		BatchProcessor:                 makeChanBatchProcessor(batches),
+		Interval:                       time.Minute,
+		MaxGroups:                      1,
+		HDRHistogramSignificantFigures: 2,
+	})
+	require.NoError(t, err)
+
+	now := time.Now()
+	batch := model.Batch{
+		makeTransaction("/a", "/a", 100, -1, -1, now),
+		makeTransaction("/b", "/b", 100, -1, -1, now), // new group beyond the maximum
+		makeTransaction("/a", "/a", 200, -1, -1, now),
+	}
+	require.NoError(t, agg.ProcessBatch(context.Background(), &batch))
+	require.NoError(t, agg.publish(context.Background()))
+
+	published := <-batches
+	require.Len(t, published, 1)
+	assert.Equal(t, "/a", published[0].Transaction.Name)
+	assert.Equal(t, int64(2), published[0].Metricset.DocCount)
+}
+
+func makeTransaction(path, name string, lcp, inp, cls float64, timestamp time.Time) model.APMEvent {
+	event := model.APMEvent{
+		Timestamp: timestamp,
+		Service:   model.Service{Name: "frontend", Environment: "production"},
+		URL:       model.URL{Path: path},
+		Processor: model.TransactionProcessor,
+		Transaction: &model.Transaction{
+			Name: name,
+			Type: "page-load",
+			UserExperience: &model.UserExperience{
+				CumulativeLayoutShift:  cls,
+				FirstInputDelay:        -1,
+				TotalBlockingTime:      -1,
+				InteractionToNextPaint: inp,
+				Longtask:               model.LongtaskMetrics{Count: -1},
+			},
+		},
+	}
+	if lcp >= 0 {
+		event.Transaction.Marks = model.TransactionMarks{
+			"agent": {"largestContentfulPaint": lcp},
+		}
+	}
+	return event
+}
+
+func makeChanBatchProcessor(ch chan<- model.Batch) model.BatchProcessor {
+	return model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ch <- *batch:
+			return nil
+		}
+	})
+}
@@ -9,9 +9,11 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+	"regexp"
 	"sync"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/dgraph-io/badger/v2"
 	"github.com/gofrs/uuid"
 	"github.com/hashicorp/go-multierror"
@@ -24,24 +26,41 @@ import (
 	"github.com/elastic/elastic-agent-client/v7/pkg/proto"
 	"github.com/elastic/elastic-agent-libs/config"
 	"github.com/elastic/elastic-agent-libs/logp"
+	"github.com/elastic/elastic-agent-libs/mapstr"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 	"github.com/elastic/elastic-agent-libs/paths"
+	"github.com/elastic/elastic-agent-libs/transport/tlscommon"
 
 	"github.com/elastic/apm-server/internal/beatcmd"
 	"github.com/elastic/apm-server/internal/beater"
+	beaterconfig "github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/internal/beater/headers"
+	"github.com/elastic/apm-server/internal/beater/request"
 	"github.com/elastic/apm-server/internal/elasticsearch"
+	"github.com/elastic/apm-server/internal/forwarding"
+	"github.com/elastic/apm-server/internal/licensing"
 	"github.com/elastic/apm-server/internal/model"
 	"github.com/elastic/apm-server/internal/model/modelprocessor"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/errorratemetrics"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/mobilemetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/servicemetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/spanmetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/txmetrics"
+	"github.com/elastic/apm-server/x-pack/apm-server/aggregation/webvitalsmetrics"
 	"github.com/elastic/apm-server/x-pack/apm-server/profiling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
 )
 
 const (
 	tailSamplingStorageDir = "tail_sampling"
+
+	// tailSamplingForwardingServiceName holds the gRPC service name for
+	// forwarding trace events between tail-sampling cluster members,
+	// distinct from the service for accepting events forwarded by
+	// edge APM Servers.
+	tailSamplingForwardingServiceName = "elastic.apm.sampling.v1.Forwarding"
 )
 
 var (
@@ -51,6 +70,13 @@ var (
 	// will hopefully disappear in the future, when agents no longer send unsampled transactions.
 	samplingMonitoringRegistry = monitoring.Default.GetRegistry("apm-server.sampling")
 
+	// forwardingMonitoringMap holds request metrics for the gRPC service
+	// used for forwarding trace events between tail-sampling cluster members.
+	forwardingMonitoringMap = request.MonitoringMapForRegistry(
+		samplingMonitoringRegistry.NewRegistry("forwarding.grpc"),
+		forwarding.MonitoringResultIDs,
+	)
+
 	// badgerDB holds the badger database to use when tail-based sampling is configured.
 	badgerMu sync.Mutex
 	badgerDB *badger.DB
@@ -88,6 +114,10 @@ func init() {
 type namedProcessor struct {
 	processor
 	name string
+
+	// queueState, if non-nil, returns the state of the events
+	// buffered by the processor, for the queues endpoint.
+	queueState func() interface{}
 }
 
 type processor interface {
@@ -141,6 +171,59 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		processors = append(processors, namedProcessor{name: spanName, processor: serviceAggregator})
 	}
 
+	if args.Config.Aggregation.ErrorRate.Enabled {
+		const errorRateName = "error rate metrics aggregation"
+		args.Logger.Infof("creating %s with config: %+v", errorRateName, args.Config.Aggregation.ErrorRate)
+		errorRateAggregator, err := errorratemetrics.NewAggregator(errorratemetrics.AggregatorConfig{
+			BatchProcessor: args.BatchProcessor,
+			Interval:       args.Config.Aggregation.ErrorRate.Interval,
+			MaxGroups:      args.Config.Aggregation.ErrorRate.MaxGroups,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating %s", errorRateName)
+		}
+		processors = append(processors, namedProcessor{name: errorRateName, processor: errorRateAggregator})
+	}
+
+	if args.Config.Aggregation.MobileVitals.Enabled {
+		const mobileVitalsName = "mobile vitals metrics aggregation"
+		args.Logger.Infof("creating %s with config: %+v", mobileVitalsName, args.Config.Aggregation.MobileVitals)
+		mobileVitalsAggregator, err := mobilemetrics.NewAggregator(mobilemetrics.AggregatorConfig{
+			BatchProcessor: args.BatchProcessor,
+			Interval:       args.Config.Aggregation.MobileVitals.Interval,
+			MaxGroups:      args.Config.Aggregation.MobileVitals.MaxGroups,
+			MaxSessions:    args.Config.Aggregation.MobileVitals.MaxSessions,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating %s", mobileVitalsName)
+		}
+		processors = append(processors, namedProcessor{name: mobileVitalsName, processor: mobileVitalsAggregator})
+	}
+
+	if args.Config.Aggregation.WebVitals.Enabled {
+		const webVitalsName = "web vitals metrics aggregation"
+		args.Logger.Infof("creating %s with config: %+v", webVitalsName, args.Config.Aggregation.WebVitals)
+		pageGroups := make([]webvitalsmetrics.PageGroup, len(args.Config.Aggregation.WebVitals.PageGroups))
+		for i, group := range args.Config.Aggregation.WebVitals.PageGroups {
+			pattern, err := regexp.Compile(group.Pattern)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error creating %s", webVitalsName)
+			}
+			pageGroups[i] = webvitalsmetrics.PageGroup{Name: group.Name, Pattern: pattern}
+		}
+		webVitalsAggregator, err := webvitalsmetrics.NewAggregator(webvitalsmetrics.AggregatorConfig{
+			BatchProcessor:                 args.BatchProcessor,
+			Interval:                       args.Config.Aggregation.WebVitals.Interval,
+			MaxGroups:                      args.Config.Aggregation.WebVitals.MaxGroups,
+			HDRHistogramSignificantFigures: args.Config.Aggregation.WebVitals.HDRHistogramSignificantFigures,
+			PageGroups:                     pageGroups,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "error creating %s", webVitalsName)
+		}
+		processors = append(processors, namedProcessor{name: webVitalsName, processor: webVitalsAggregator})
+	}
+
 	if args.Config.Sampling.Tail.Enabled {
 		const name = "tail sampler"
 		sampler, err := newTailSamplingProcessor(args)
@@ -149,24 +232,78 @@ func newProcessors(args beater.ServerParams) ([]namedProcessor, error) {
 		}
 		samplingMonitoringRegistry.Remove("tail")
 		monitoring.NewFunc(samplingMonitoringRegistry, "tail", sampler.CollectMonitoring, monitoring.Report)
-		processors = append(processors, namedProcessor{name: name, processor: sampler})
+		processors = append(processors, namedProcessor{
+			name: name,
+			processor: licensedProcessor{
+				processor: sampler,
+				feature:   licensing.FeatureTailSampling,
+				checker:   args.LicenseChecker,
+			},
+			queueState: func() interface{} { return sampler.QueueState() },
+		})
 	}
 	return processors, nil
 }
 
-func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, error) {
+func newTailSamplingProcessor(args beater.ServerParams) (*tailSamplingProcessor, error) {
 	tailSamplingConfig := args.Config.Sampling.Tail
-	es, err := args.NewElasticsearchClient(tailSamplingConfig.ESConfig)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to create Elasticsearch client for tail-sampling")
+	var err error
+	var es elasticsearch.Client
+	var kafkaClient sarama.Client
+	var remotePubsub sampling.Pubsub
+	if tailSamplingConfig.Kafka.Enabled {
+		kafkaClient, err = newTailSamplingKafkaClient(tailSamplingConfig.Kafka)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Kafka client for tail-sampling")
+		}
+		kafkaPubsub, err := pubsub.NewKafka(pubsub.KafkaConfig{
+			Client:   kafkaClient,
+			Topic:    tailSamplingConfig.Kafka.Topic,
+			ServerID: samplerUUID.String(),
+		})
+		if err != nil {
+			kafkaClient.Close()
+			return nil, err
+		}
+		remotePubsub = kafkaPubsub
+	} else {
+		es, err = args.NewElasticsearchClient(tailSamplingConfig.ESConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create Elasticsearch client for tail-sampling")
+		}
+	}
+	var forwardingClient *forwarding.Client
+	var clusterConfig sampling.ClusterConfig
+	if tailSamplingConfig.Cluster.Enabled {
+		forwardingClient, err = newTailSamplingForwardingClient(tailSamplingConfig.Cluster)
+		if err != nil {
+			if kafkaClient != nil {
+				kafkaClient.Close()
+			}
+			return nil, errors.Wrap(err, "failed to create forwarding client for tail-sampling")
+		}
+		clusterConfig = sampling.ClusterConfig{
+			Members:   tailSamplingConfig.Cluster.Members,
+			Self:      tailSamplingConfig.Cluster.Self,
+			Forwarder: forwardingClient,
+		}
+	}
+	closeClients := func() {
+		if kafkaClient != nil {
+			kafkaClient.Close()
+		}
+		if forwardingClient != nil {
+			forwardingClient.Close()
+		}
 	}
 
 	storageDir := paths.Resolve(paths.Data, tailSamplingStorageDir)
-	badgerDB, err = getBadgerDB(storageDir)
+	badgerDB, err = getBadgerDB(storageDir, newBadgerOptions(tailSamplingConfig.Storage))
 	if err != nil {
+		closeClients()
 		return nil, errors.Wrap(err, "failed to get Badger database")
 	}
-	readWriters := getStorage(badgerDB)
+	readWriters := getStorage(badgerDB, tailSamplingConfig.Storage.Compression)
 
 	policies := make([]sampling.Policy, len(tailSamplingConfig.Policies))
 	for i, in := range tailSamplingConfig.Policies {
@@ -181,17 +318,19 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 		}
 	}
 
-	return sampling.NewProcessor(sampling.Config{
+	processor, err := sampling.NewProcessor(sampling.Config{
 		BatchProcessor: args.BatchProcessor,
 		LocalSamplingConfig: sampling.LocalSamplingConfig{
 			FlushInterval:         tailSamplingConfig.Interval,
 			MaxDynamicServices:    1000,
 			Policies:              policies,
 			IngestRateDecayFactor: tailSamplingConfig.IngestRateDecayFactor,
+			DryRun:                tailSamplingConfig.DryRun,
 		},
 		RemoteSamplingConfig: sampling.RemoteSamplingConfig{
 			CompressionLevel: tailSamplingConfig.ESConfig.CompressionLevel,
 			Elasticsearch:    es,
+			Pubsub:           remotePubsub,
 			SampledTracesDataStream: sampling.DataStreamConfig{
 				Type:      "traces",
 				Dataset:   "apm.sampled",
@@ -207,14 +346,145 @@ func newTailSamplingProcessor(args beater.ServerParams) (*sampling.Processor, er
 			StorageLimit:      tailSamplingConfig.StorageLimitParsed,
 			TTL:               tailSamplingConfig.TTL,
 		},
+		ClusterConfig: clusterConfig,
 	})
+	if err != nil {
+		closeClients()
+		return nil, err
+	}
+	if forwardingClient != nil {
+		forwarding.RegisterServer(args.GRPCServer, forwarding.ServerConfig{
+			ServiceName: tailSamplingForwardingServiceName,
+			Processor: requireLicenseProcessor{
+				processor: model.ProcessBatchFunc(processor.ProcessForwardedBatch),
+				feature:   licensing.FeatureTailSampling,
+				checker:   args.LicenseChecker,
+			},
+			MonitoringMap: forwardingMonitoringMap,
+		})
+	}
+	return &tailSamplingProcessor{
+		Processor:        processor,
+		kafkaClient:      kafkaClient,
+		forwardingClient: forwardingClient,
+	}, nil
+}
+
+// tailSamplingProcessor wraps sampling.Processor, closing the Kafka client
+// used for sharing sampling decisions, and the client used for forwarding
+// trace events to other cluster members, if any, once the processor stops.
+type tailSamplingProcessor struct {
+	*sampling.Processor
+	kafkaClient      sarama.Client
+	forwardingClient *forwarding.Client
+}
+
+func (p *tailSamplingProcessor) Stop(ctx context.Context) error {
+	err := p.Processor.Stop(ctx)
+	if p.kafkaClient != nil {
+		if closeErr := p.kafkaClient.Close(); closeErr != nil {
+			err = multierror.Append(err, closeErr)
+		}
+	}
+	if p.forwardingClient != nil {
+		if closeErr := p.forwardingClient.Close(); closeErr != nil {
+			err = multierror.Append(err, closeErr)
+		}
+	}
+	return err
 }
 
-func getBadgerDB(storageDir string) (*badger.DB, error) {
+// licensedProcessor wraps a processor for a feature which requires a license
+// level higher than Basic, passing batches through it only while the feature
+// is enabled by the Elasticsearch license. Otherwise, batches are passed on to
+// the next processor in the chain unmodified.
+type licensedProcessor struct {
+	processor
+	feature string
+	checker *licensing.Checker
+}
+
+func (p licensedProcessor) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	if p.checker != nil && !p.checker.Enabled(p.feature) {
+		return nil
+	}
+	return p.processor.ProcessBatch(ctx, batch)
+}
+
+// requireLicenseProcessor wraps a processor for a feature which requires a
+// license level higher than Basic, failing batches while the feature is not
+// enabled by the Elasticsearch license. Unlike licensedProcessor, this is for
+// processors which are not part of a chain, such as the processor for trace
+// events forwarded by other tail-sampling cluster members, which then sample
+// the events themselves.
+type requireLicenseProcessor struct {
+	processor model.BatchProcessor
+	feature   string
+	checker   *licensing.Checker
+}
+
+func (p requireLicenseProcessor) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	if p.checker != nil && !p.checker.Enabled(p.feature) {
+		return errors.Errorf("%s is not enabled by the license", p.feature)
+	}
+	return p.processor.ProcessBatch(ctx, batch)
+}
+
+// newTailSamplingForwardingClient returns a new client for forwarding trace
+// events to other tail-sampling cluster members.
+func newTailSamplingForwardingClient(cfg beaterconfig.TailSamplingClusterConfig) (*forwarding.Client, error) {
+	clientConfig := forwarding.ClientConfig{ServiceName: tailSamplingForwardingServiceName}
+	switch {
+	case cfg.APIKey != "":
+		clientConfig.Authorization = headers.APIKey + " " + cfg.APIKey
+	case cfg.SecretToken != "":
+		clientConfig.Authorization = headers.Bearer + " " + cfg.SecretToken
+	}
+	if cfg.TLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		clientConfig.TLS = tlsConfig.BuildModuleClientConfig("")
+	}
+	return forwarding.NewClient(clientConfig), nil
+}
+
+// newTailSamplingKafkaClient returns a new Kafka client for publishing and
+// subscribing to tail-sampling decisions.
+func newTailSamplingKafkaClient(cfg beaterconfig.TailSamplingKafkaConfig) (sarama.Client, error) {
+	saramaConfig := sarama.NewConfig()
+	saramaConfig.ClientID = "apm-server"
+	if cfg.Username != "" {
+		saramaConfig.Net.SASL.Enable = true
+		saramaConfig.Net.SASL.User = cfg.Username
+		saramaConfig.Net.SASL.Password = cfg.Password
+	}
+	if cfg.TLS.IsEnabled() {
+		tlsConfig, err := tlscommon.LoadTLSConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		saramaConfig.Net.TLS.Enable = true
+		saramaConfig.Net.TLS.Config = tlsConfig.BuildModuleClientConfig("")
+	}
+	return sarama.NewClient(cfg.Hosts, saramaConfig)
+}
+
+func newBadgerOptions(cfg beaterconfig.TailSamplingStorageConfig) eventstorage.BadgerOptions {
+	return eventstorage.BadgerOptions{
+		ValueLogFileSize: int64(cfg.ValueLogFileSizeParsed),
+		MaxTableSize:     int64(cfg.MaxTableSizeParsed),
+		NumMemtables:     cfg.NumMemtables,
+		Compression:      cfg.Compression,
+	}
+}
+
+func getBadgerDB(storageDir string, opts eventstorage.BadgerOptions) (*badger.DB, error) {
 	badgerMu.Lock()
 	defer badgerMu.Unlock()
 	if badgerDB == nil {
-		db, err := eventstorage.OpenBadger(storageDir, -1)
+		db, err := eventstorage.OpenBadger(storageDir, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -223,11 +493,14 @@ func getBadgerDB(storageDir string) (*badger.DB, error) {
 	return badgerDB, nil
 }
 
-func getStorage(db *badger.DB) *eventstorage.ShardedReadWriter {
+func getStorage(db *badger.DB, compression bool) *eventstorage.ShardedReadWriter {
 	storageMu.Lock()
 	defer storageMu.Unlock()
 	if storage == nil {
-		eventCodec := eventstorage.JSONCodec{}
+		var eventCodec eventstorage.Codec = eventstorage.JSONCodec{}
+		if compression {
+			eventCodec = eventstorage.ZstdCodec{Codec: eventCodec}
+		}
 		storage = eventstorage.New(db, eventCodec).NewShardedReadWriter()
 	}
 	return storage
@@ -371,6 +644,26 @@ func wrapServer(args beater.ServerParams, runServer beater.RunServerFunc) (beate
 	processorChain[len(processors)] = args.BatchProcessor
 	args.BatchProcessor = processorChain
 
+	// Report the state of events buffered by processors alongside
+	// the server's own queues.
+	queueState := args.QueueState
+	args.QueueState = func() mapstr.M {
+		state := mapstr.M{}
+		if queueState != nil {
+			state = queueState()
+		}
+		processorStates := mapstr.M{}
+		for _, p := range processors {
+			if p.queueState != nil {
+				processorStates[p.name] = p.queueState()
+			}
+		}
+		if len(processorStates) > 0 {
+			state["processors"] = processorStates
+		}
+		return state
+	}
+
 	wrappedRunServer := func(ctx context.Context, args beater.ServerParams) error {
 		if args.Config.Profiling.Enabled {
 			profilingCollector, cleanup, err := newProfilingCollector(args)
@@ -13,8 +13,10 @@ import (
 
 // newXPackRootCommand returns the Elastic licensed "apm-server" root command.
 func newXPackRootCommand(newRunner beatcmd.NewRunnerFunc) *cobra.Command {
-	return beatcmd.NewRootCommand(beatcmd.BeatParams{
+	rootCmd := beatcmd.NewRootCommand(beatcmd.BeatParams{
 		NewRunner:       newRunner,
 		ElasticLicensed: true,
 	})
+	rootCmd.AddCommand(genTailSamplingCmd())
+	return rootCmd
 }
@@ -23,9 +23,12 @@ func TestSubCommands(t *testing.T) {
 
 	assert.ElementsMatch(t, []string{
 		"apikey",
+		"bench",
 		"export",
 		"keystore",
+		"replay",
 		"run",
+		"tail-sampling",
 		"test",
 		"version",
 	}, commands)
@@ -0,0 +1,29 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"hash/fnv"
+)
+
+// traceOwner returns the member which owns the trace with the given ID.
+//
+// Ownership is decided by rendezvous (highest random weight) hashing,
+// so that when a member is added or removed, only the traces owned by
+// that member change ownership.
+func traceOwner(members []string, traceID string) string {
+	var owner string
+	var maxWeight uint64
+	for _, member := range members {
+		h := fnv.New64a()
+		h.Write([]byte(member))
+		h.Write([]byte{0})
+		h.Write([]byte(traceID))
+		if weight := h.Sum64(); owner == "" || weight > maxWeight {
+			owner, maxWeight = member, weight
+		}
+	}
+	return owner
+}
@@ -0,0 +1,39 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"testing"
+
+	"github.com/gofrs/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTraceOwner(t *testing.T) {
+	members := []string{"apm-1:8200", "apm-2:8200", "apm-3:8200"}
+	reversed := []string{"apm-3:8200", "apm-2:8200", "apm-1:8200"}
+
+	const N = 3000
+	owners := make(map[string]string, N)
+	counts := make(map[string]int)
+	for i := 0; i < N; i++ {
+		traceID := uuid.Must(uuid.NewV4()).String()
+		owner := traceOwner(members, traceID)
+		assert.Equal(t, owner, traceOwner(reversed, traceID), "ownership depends on member order")
+		owners[traceID] = owner
+		counts[owner]++
+	}
+	for _, member := range members {
+		assert.InDelta(t, N/len(members), counts[member], N/10, member)
+	}
+
+	// Removing a member only changes ownership of its traces.
+	for traceID, owner := range owners {
+		newOwner := traceOwner(members[:2], traceID)
+		if owner != members[2] {
+			assert.Equal(t, owner, newOwner)
+		}
+	}
+}
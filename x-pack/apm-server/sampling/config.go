@@ -5,6 +5,7 @@
 package sampling
 
 import (
+	"context"
 	"time"
 
 	"github.com/dgraph-io/badger/v2"
@@ -25,6 +26,7 @@ type Config struct {
 	LocalSamplingConfig
 	RemoteSamplingConfig
 	StorageConfig
+	ClusterConfig
 }
 
 // LocalSamplingConfig holds Processor configuration related to local reservoir sampling.
@@ -55,6 +57,12 @@ type LocalSamplingConfig struct {
 	// the exponentially weighted moving average (EWMA) ingest rate for each trace
 	// group.
 	IngestRateDecayFactor float64
+
+	// DryRun, if true, causes all trace events to be indexed immediately,
+	// regardless of sampling decisions. Events are still stored, and sampling
+	// decisions are still made and shared, so that metrics reflect the decisions
+	// that would have been made; events are not indexed again upon a decision.
+	DryRun bool
 }
 
 // RemoteSamplingConfig holds Processor configuration related to publishing and
@@ -66,8 +74,16 @@ type RemoteSamplingConfig struct {
 
 	// Elasticsearch holds the Elasticsearch client to use for publishing
 	// and subscribing to remote sampling decisions.
+	//
+	// Elasticsearch is required unless Pubsub is specified.
 	Elasticsearch elasticsearch.Client
 
+	// Pubsub holds an optional Pubsub to use for publishing and subscribing
+	// to remote sampling decisions, such as a pubsub.KafkaPubsub. If Pubsub
+	// is nil, sampling decisions are published and subscribed to through the
+	// Elasticsearch data stream identified by SampledTracesDataStream.
+	Pubsub Pubsub
+
 	// SampledTracesDataStream holds the identifiers for the Elasticsearch
 	// data stream for storing and searching sampled trace IDs.
 	SampledTracesDataStream DataStreamConfig
@@ -82,6 +98,55 @@ type RemoteSamplingConfig struct {
 	UUID string
 }
 
+// Pubsub provides a means of publishing and subscribing to sampled trace IDs,
+// for sharing sampling decisions between APM Server instances.
+type Pubsub interface {
+	// PublishSampledTraceIDs receives trace IDs from the traceIDs channel,
+	// publishing them for other subscribers. PublishSampledTraceIDs returns
+	// when ctx is canceled.
+	PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error
+
+	// SubscribeSampledTraceIDs subscribes to sampled trace IDs published by
+	// other servers after the given position, sending them to the traceIDs
+	// channel, and sending the most recently observed position (on change)
+	// to the positions channel.
+	SubscribeSampledTraceIDs(
+		ctx context.Context,
+		pos pubsub.SubscriberPosition,
+		traceIDs chan<- string,
+		positions chan<- pubsub.SubscriberPosition,
+	) error
+}
+
+// ClusterConfig holds Processor configuration related to coordinating
+// tail-sampling between multiple APM Server instances, such that each
+// trace is owned, and sampled, by a single instance.
+type ClusterConfig struct {
+	// Members holds the addresses of all APM Server instances in the
+	// cluster, including this one. Each trace is owned by one member,
+	// chosen by rendezvous hashing of the trace ID, and trace events
+	// received by other members are forwarded to the owner.
+	//
+	// If Members is empty, all traces are sampled locally.
+	Members []string
+
+	// Self holds the address of this instance, as it appears in Members.
+	Self string
+
+	// Forwarder holds the Forwarder for forwarding trace events to the
+	// member which owns the trace. Forwarder is required if Members is
+	// non-empty.
+	Forwarder Forwarder
+}
+
+// Forwarder provides a means of forwarding trace events to another
+// APM Server instance.
+type Forwarder interface {
+	// ForwardEvents forwards events to the APM Server instance
+	// with the given address.
+	ForwardEvents(ctx context.Context, addr string, events model.Batch) error
+}
+
 // DataStreamConfig holds configuration to identify a data stream.
 type DataStreamConfig struct {
 	// Type holds the data stream's type.
@@ -183,6 +248,9 @@ func (config Config) Validate() error {
 	if err := config.StorageConfig.validate(); err != nil {
 		return errors.Wrap(err, "invalid storage config")
 	}
+	if err := config.ClusterConfig.validate(); err != nil {
+		return errors.Wrap(err, "invalid cluster config")
+	}
 	return nil
 }
 
@@ -218,11 +286,13 @@ func (config RemoteSamplingConfig) validate() error {
 	if config.CompressionLevel < -1 || config.CompressionLevel > 9 {
 		return errors.New("CompressionLevel out of range [-1,9]")
 	}
-	if config.Elasticsearch == nil {
-		return errors.New("Elasticsearch unspecified")
-	}
-	if err := config.SampledTracesDataStream.validate(); err != nil {
-		return errors.New("SampledTracesDataStream unspecified or invalid")
+	if config.Pubsub == nil {
+		if config.Elasticsearch == nil {
+			return errors.New("Elasticsearch unspecified")
+		}
+		if err := config.SampledTracesDataStream.validate(); err != nil {
+			return errors.New("SampledTracesDataStream unspecified or invalid")
+		}
 	}
 	if config.UUID == "" {
 		return errors.New("UUID unspecified")
@@ -230,6 +300,26 @@ func (config RemoteSamplingConfig) validate() error {
 	return nil
 }
 
+func (config ClusterConfig) validate() error {
+	if len(config.Members) == 0 {
+		return nil
+	}
+	var foundSelf bool
+	for _, member := range config.Members {
+		if member == config.Self {
+			foundSelf = true
+			break
+		}
+	}
+	if !foundSelf {
+		return errors.New("Self unspecified or not in Members")
+	}
+	if config.Forwarder == nil {
+		return errors.New("Forwarder unspecified")
+	}
+	return nil
+}
+
 func (config DataStreamConfig) validate() error {
 	return pubsub.DataStreamConfig(config).Validate()
 }
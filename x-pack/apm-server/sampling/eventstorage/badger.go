@@ -6,6 +6,7 @@ package eventstorage
 
 import (
 	"github.com/dgraph-io/badger/v2"
+	"github.com/dgraph-io/badger/v2/options"
 
 	"github.com/elastic/apm-server/internal/logs"
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -13,14 +14,36 @@ import (
 
 const (
 	defaultValueLogFileSize = 64 * 1024 * 1024
+	defaultMaxTableSize     = 16 * 1024 * 1024
+	defaultNumMemtables     = 4
 )
 
+// BadgerOptions holds options for opening a Badger database with OpenBadger.
+type BadgerOptions struct {
+	// ValueLogFileSize holds the maximum size of each value log file.
+	// If ValueLogFileSize is <= 0, the default of 64MB will be used.
+	ValueLogFileSize int64
+
+	// MaxTableSize holds the maximum size of each LSM tree table.
+	// If MaxTableSize is <= 0, the default of 16MB will be used.
+	MaxTableSize int64
+
+	// NumMemtables holds the maximum number of in-memory tables, and the
+	// number of level 0 tables at which compaction starts. If NumMemtables
+	// is <= 0, the default of 4 will be used.
+	NumMemtables int
+
+	// Compression controls whether LSM tree table blocks are compressed
+	// with Snappy. Badger does not compress values stored in the value
+	// log; use a compressing codec such as ZstdCodec for that.
+	Compression bool
+}
+
 // OpenBadger creates or opens a Badger database with the specified location
-// and value log file size. If the value log file size is <= 0, the default
-// of 64MB will be used.
+// and options.
 //
 // NOTE(axw) only one badger.DB for a given storage directory may be open at any given time.
-func OpenBadger(storageDir string, valueLogFileSize int64) (*badger.DB, error) {
+func OpenBadger(storageDir string, opts BadgerOptions) (*badger.DB, error) {
 	logger := logp.NewLogger(logs.Sampling)
 	// Tunable memory options:
 	//  - NumMemtables - default 5 in-mem tables (MaxTableSize default)
@@ -28,18 +51,45 @@ func OpenBadger(storageDir string, valueLogFileSize int64) (*badger.DB, error) {
 	//  - NumLevelZeroTablesStall - number of L0 tables before writing stalls (waiting for compaction).
 	//  - IndexCacheSize - default all in mem, Each table has its own bloom filter and each bloom filter is approximately of 5 MB.
 	//  - MaxTableSize - Default 64MB
-	if valueLogFileSize <= 0 {
-		valueLogFileSize = defaultValueLogFileSize
+	if opts.ValueLogFileSize <= 0 {
+		opts.ValueLogFileSize = defaultValueLogFileSize
+	}
+	if opts.MaxTableSize <= 0 {
+		opts.MaxTableSize = defaultMaxTableSize
 	}
-	const tableLimit = 4
+	if opts.NumMemtables <= 0 {
+		opts.NumMemtables = defaultNumMemtables
+	}
+	compression := options.None
+	if opts.Compression {
+		compression = options.Snappy
+	}
+	tableLimit := opts.NumMemtables
 	badgerOpts := badger.DefaultOptions(storageDir).
 		WithLogger(&LogpAdaptor{Logger: logger}).
 		WithTruncate(true).                          // Truncate unreadable files which cannot be read.
 		WithNumMemtables(tableLimit).                // in-memory tables.
 		WithNumLevelZeroTables(tableLimit).          // L0 tables.
 		WithNumLevelZeroTablesStall(tableLimit * 3). // Maintain the default 1-to-3 ratio before stalling.
-		WithMaxTableSize(int64(16 << 20)).           // Max LSM table or file size.
-		WithValueLogFileSize(valueLogFileSize)       // vlog file size.
+		WithMaxTableSize(opts.MaxTableSize).         // Max LSM table or file size.
+		WithValueLogFileSize(opts.ValueLogFileSize). // vlog file size.
+		WithCompression(compression)                 // LSM table block compression.
 
 	return badger.Open(badgerOpts)
 }
+
+// RunValueLogGC garbage collects the Badger value log, using the given
+// discard ratio, until there are no more value log files to rewrite.
+// RunValueLogGC returns the number of value log files rewritten.
+func RunValueLogGC(db *badger.DB, discardRatio float64) (int, error) {
+	var rewrites int
+	for {
+		if err := db.RunValueLogGC(discardRatio); err != nil {
+			if err == badger.ErrNoRewrite {
+				err = nil
+			}
+			return rewrites, err
+		}
+		rewrites++
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage
+
+import (
+	"bytes"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/elastic/apm-server/internal/model"
+)
+
+// zstdMagic holds the magic number at the start of every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+var (
+	// zstdEncoder and zstdDecoder are safe for concurrent use
+	// with EncodeAll and DecodeAll respectively.
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest))
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// ZstdCodec is an implementation of Codec which compresses the events
+// encoded by another Codec with zstd.
+//
+// ZstdCodec decodes both compressed and uncompressed data, so compression
+// may be enabled for existing storage.
+type ZstdCodec struct {
+	Codec Codec
+}
+
+// DecodeEvent decompresses data if it is zstd-compressed, and then
+// decodes it into event with c.Codec.
+func (c ZstdCodec) DecodeEvent(data []byte, event *model.APMEvent) error {
+	if bytes.HasPrefix(data, zstdMagic) {
+		decompressed, err := zstdDecoder.DecodeAll(data, nil)
+		if err != nil {
+			return err
+		}
+		data = decompressed
+	}
+	return c.Codec.DecodeEvent(data, event)
+}
+
+// EncodeEvent encodes event with c.Codec, and compresses the result with zstd.
+func (c ZstdCodec) EncodeEvent(event *model.APMEvent) ([]byte, error) {
+	data, err := c.Codec.EncodeEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	return zstdEncoder.EncodeAll(data, make([]byte, 0, len(data)/2)), nil
+}
@@ -0,0 +1,39 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package eventstorage_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+)
+
+func TestZstdCodec(t *testing.T) {
+	codec := eventstorage.ZstdCodec{Codec: eventstorage.JSONCodec{}}
+	event := &model.APMEvent{
+		Transaction: &model.Transaction{Name: strings.Repeat("transaction", 100)},
+	}
+
+	encoded, err := codec.EncodeEvent(event)
+	require.NoError(t, err)
+	uncompressed, err := eventstorage.JSONCodec{}.EncodeEvent(event)
+	require.NoError(t, err)
+	assert.Less(t, len(encoded), len(uncompressed))
+
+	var decoded model.APMEvent
+	require.NoError(t, codec.DecodeEvent(encoded, &decoded))
+	assert.Equal(t, event, &decoded)
+
+	// Uncompressed data, e.g. written before compression was
+	// enabled, is decoded with the underlying codec.
+	decoded = model.APMEvent{}
+	require.NoError(t, codec.DecodeEvent(uncompressed, &decoded))
+	assert.Equal(t, event, &decoded)
+}
@@ -9,6 +9,7 @@ import (
 	"math"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elastic/apm-server/internal/model"
@@ -39,10 +40,49 @@ type traceGroups struct {
 
 type policyGroup struct {
 	policy  Policy
+	stats   *policyStats
 	g       *traceGroup            // nil for catch-all
 	dynamic map[string]*traceGroup // nil for static
 }
 
+// policyStats holds statistics about the root transactions matched,
+// and the traces sampled, by a policy.
+type policyStats struct {
+	// matched and sampled hold the total number of root transactions
+	// matched by the policy, and the number of traces sampled by the
+	// policy, respectively. These must be accessed atomically.
+	matched int64
+	sampled int64
+
+	// lastMatched holds the value of matched at the end of the most
+	// recent sampling interval, and effectiveSampleRate holds the
+	// fraction of matched traces that were sampled in the most recent
+	// interval in which any traces were matched. These are protected
+	// by traceGroups.mu.
+	lastMatched         int64
+	effectiveSampleRate float64
+}
+
+// finalizeInterval records the number of traces sampled by the policy
+// in the interval that is ending. This must be called with traceGroups.mu
+// held for writing.
+func (s *policyStats) finalizeInterval(sampled int) {
+	matched := atomic.LoadInt64(&s.matched)
+	if n := matched - s.lastMatched; n > 0 {
+		s.effectiveSampleRate = float64(sampled) / float64(n)
+	}
+	s.lastMatched = matched
+	atomic.AddInt64(&s.sampled, int64(sampled))
+}
+
+// policyStatsSnapshot holds a point-in-time copy of a policy's statistics.
+type policyStatsSnapshot struct {
+	sampleRate          float64
+	matched             int64
+	sampled             int64
+	effectiveSampleRate float64
+}
+
 func (g *policyGroup) match(transactionEvent *model.APMEvent) bool {
 	if g.policy.ServiceName != "" && g.policy.ServiceName != transactionEvent.Service.Name {
 		return false
@@ -70,7 +110,7 @@ func newTraceGroups(
 		policyGroups:            make([]policyGroup, len(policies)),
 	}
 	for i, policy := range policies {
-		pg := policyGroup{policy: policy}
+		pg := policyGroup{policy: policy, stats: &policyStats{}}
 		if policy.ServiceName != "" {
 			pg.g = newTraceGroup(policy.SampleRate)
 		} else {
@@ -137,6 +177,7 @@ func (g *traceGroups) getTraceGroup(transactionEvent *model.APMEvent) (*traceGro
 	if pg == nil {
 		return nil, errNoMatchingPolicy
 	}
+	atomic.AddInt64(&pg.stats.matched, 1)
 	if pg.g != nil {
 		return pg.g, nil
 	}
@@ -169,6 +210,47 @@ func (g *traceGroup) sampleTrace(transactionEvent *model.APMEvent) (bool, error)
 	), nil
 }
 
+// pendingTraces returns the number of root transactions currently held in
+// the groups' sampling reservoirs, awaiting a local sampling decision.
+func (g *traceGroups) pendingTraces() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	var n int
+	for _, pg := range g.policyGroups {
+		if pg.g != nil {
+			n += pg.g.pendingTraces()
+			continue
+		}
+		for _, group := range pg.dynamic {
+			n += group.pendingTraces()
+		}
+	}
+	return n
+}
+
+// policyStats returns a snapshot of the statistics for each policy,
+// in the order the policies were configured.
+func (g *traceGroups) policyStats() []policyStatsSnapshot {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	stats := make([]policyStatsSnapshot, len(g.policyGroups))
+	for i, pg := range g.policyGroups {
+		stats[i] = policyStatsSnapshot{
+			sampleRate:          pg.policy.SampleRate,
+			matched:             atomic.LoadInt64(&pg.stats.matched),
+			sampled:             atomic.LoadInt64(&pg.stats.sampled),
+			effectiveSampleRate: pg.stats.effectiveSampleRate,
+		}
+	}
+	return stats
+}
+
+func (g *traceGroup) pendingTraces() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.reservoir.Len()
+}
+
 // finalizeSampledTraces locks the groups, appends their current trace IDs to
 // traceIDs, and returns the extended slice. On return the groups' sampling
 // reservoirs will be reset.
@@ -182,9 +264,9 @@ func (g *traceGroups) finalizeSampledTraces(traceIDs []string) []string {
 	defer g.mu.Unlock()
 	maxDynamicServiceGroupsReached := g.numDynamicServiceGroups == g.maxDynamicServiceGroups
 	for _, pg := range g.policyGroups {
+		n := len(traceIDs)
 		if pg.g != nil {
 			traceIDs = pg.g.finalizeSampledTraces(traceIDs, g.ingestRateDecayFactor)
-			continue
 		}
 		for serviceName, group := range pg.dynamic {
 			total := group.total
@@ -194,6 +276,7 @@ func (g *traceGroups) finalizeSampledTraces(traceIDs []string) []string {
 				delete(pg.dynamic, serviceName)
 			}
 		}
+		pg.stats.finalizeInterval(len(traceIDs) - n)
 	}
 	return traceIDs
 }
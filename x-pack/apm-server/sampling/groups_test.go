@@ -285,3 +285,46 @@ func BenchmarkTraceGroups(b *testing.B) {
 		}
 	})
 }
+
+func TestTraceGroupsPolicyStats(t *testing.T) {
+	policies := []Policy{
+		{PolicyCriteria: PolicyCriteria{ServiceName: "static-service"}, SampleRate: 0.5},
+		{SampleRate: 0.1},
+	}
+	groups := newTraceGroups(policies, 1000, 1.0)
+
+	sampleTraces := func(serviceName string, n int) {
+		for i := 0; i < n; i++ {
+			_, err := groups.sampleTrace(&model.APMEvent{
+				Service:     model.Service{Name: serviceName},
+				Processor:   model.TransactionProcessor,
+				Trace:       model.Trace{ID: uuid.Must(uuid.NewV4()).String()},
+				Event:       model.Event{Duration: time.Millisecond},
+				Transaction: &model.Transaction{ID: uuid.Must(uuid.NewV4()).String()},
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	sampleTraces("static-service", 100)
+	sampleTraces("dynamic-service", 1000)
+	assert.Equal(t, []policyStatsSnapshot{
+		{sampleRate: 0.5, matched: 100},
+		{sampleRate: 0.1, matched: 1000},
+	}, groups.policyStats())
+
+	groups.finalizeSampledTraces(nil)
+	assert.Equal(t, []policyStatsSnapshot{
+		{sampleRate: 0.5, matched: 100, sampled: 50, effectiveSampleRate: 0.5},
+		{sampleRate: 0.1, matched: 1000, sampled: 100, effectiveSampleRate: 0.1},
+	}, groups.policyStats())
+
+	// The effective sample rate is retained for intervals
+	// in which no traces matched the policy.
+	sampleTraces("static-service", 10)
+	groups.finalizeSampledTraces(nil)
+	assert.Equal(t, []policyStatsSnapshot{
+		{sampleRate: 0.5, matched: 110, sampled: 55, effectiveSampleRate: 0.5},
+		{sampleRate: 0.1, matched: 1000, sampled: 100, effectiveSampleRate: 0.1},
+	}, groups.policyStats())
+}
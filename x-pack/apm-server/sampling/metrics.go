@@ -0,0 +1,131 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"sync"
+	"time"
+
+	"github.com/elastic/go-hdrhistogram"
+)
+
+const (
+	// eventsWindowBuckets holds the number of buckets in an eventsWindow.
+	eventsWindowBuckets = 60
+
+	// maxDecisionLatency holds the maximum decision latency recorded by
+	// latencyHistogram; greater latencies are recorded as this value.
+	maxDecisionLatency = time.Hour
+)
+
+// eventsWindow counts events over a sliding window of time, such as the
+// events written to local storage within the storage TTL.
+type eventsWindow struct {
+	mu             sync.Mutex
+	bucketDuration time.Duration
+	buckets        [eventsWindowBuckets]int64
+	head           int       // index of the current bucket
+	headTime       time.Time // start time of the current bucket
+	total          int64
+}
+
+func newEventsWindow(window time.Duration) *eventsWindow {
+	bucketDuration := window / eventsWindowBuckets
+	if bucketDuration <= 0 {
+		bucketDuration = 1
+	}
+	return &eventsWindow{bucketDuration: bucketDuration, headTime: time.Now()}
+}
+
+// add records n events at the given time.
+func (w *eventsWindow) add(now time.Time, n int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(now)
+	w.buckets[w.head] += n
+	w.total += n
+}
+
+// sum returns the number of events recorded within the window ending now.
+func (w *eventsWindow) sum(now time.Time) int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.advance(now)
+	return w.total
+}
+
+// advance expires buckets that have fallen outside the window ending now.
+func (w *eventsWindow) advance(now time.Time) {
+	for i := 0; now.Sub(w.headTime) >= w.bucketDuration; i++ {
+		if i == len(w.buckets) {
+			// All buckets have expired.
+			w.headTime = now
+			break
+		}
+		w.head = (w.head + 1) % len(w.buckets)
+		w.headTime = w.headTime.Add(w.bucketDuration)
+		w.total -= w.buckets[w.head]
+		w.buckets[w.head] = 0
+	}
+}
+
+// latencyHistogram records the distribution of latencies, in milliseconds,
+// over the current and previous intervals.
+type latencyHistogram struct {
+	mu       sync.Mutex
+	current  *hdrhistogram.Histogram
+	previous *hdrhistogram.Histogram
+	count    int64
+}
+
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		current:  hdrhistogram.New(1, maxDecisionLatency.Milliseconds(), 2),
+		previous: hdrhistogram.New(1, maxDecisionLatency.Milliseconds(), 2),
+	}
+}
+
+// record records a latency in the current interval.
+func (h *latencyHistogram) record(d time.Duration) {
+	if d < 0 {
+		d = 0
+	} else if d > maxDecisionLatency {
+		d = maxDecisionLatency
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current.RecordValue(d.Milliseconds())
+	h.count++
+}
+
+// rotate ends the current interval.
+func (h *latencyHistogram) rotate() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.current, h.previous = h.previous, h.current
+	h.current.Reset()
+}
+
+// latencySnapshot holds the total number of latencies recorded, and
+// the distribution of latencies recorded in the previous interval.
+type latencySnapshot struct {
+	count int64
+	p50   int64
+	p90   int64
+	p99   int64
+	max   int64
+}
+
+func (h *latencyHistogram) snapshot() latencySnapshot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return latencySnapshot{
+		count: h.count,
+		p50:   h.previous.ValueAtQuantile(50),
+		p90:   h.previous.ValueAtQuantile(90),
+		p99:   h.previous.ValueAtQuantile(99),
+		max:   h.previous.Max(),
+	}
+}
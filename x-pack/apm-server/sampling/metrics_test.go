@@ -0,0 +1,51 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package sampling
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventsWindow(t *testing.T) {
+	w := newEventsWindow(time.Minute)
+	start := w.headTime
+
+	w.add(start, 1)
+	w.add(start.Add(30*time.Second), 2)
+	assert.Equal(t, int64(3), w.sum(start.Add(30*time.Second)))
+
+	// The first bucket expires once the window has elapsed.
+	assert.Equal(t, int64(2), w.sum(start.Add(time.Minute)))
+
+	// All buckets expire after a long period of inactivity.
+	assert.Equal(t, int64(0), w.sum(start.Add(time.Hour)))
+	w.add(start.Add(time.Hour), 4)
+	assert.Equal(t, int64(4), w.sum(start.Add(time.Hour+time.Second)))
+}
+
+func TestLatencyHistogram(t *testing.T) {
+	h := newLatencyHistogram()
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	h.record(2 * maxDecisionLatency)
+
+	// Percentiles are reported for the previous interval.
+	assert.Equal(t, latencySnapshot{count: 101}, h.snapshot())
+
+	h.rotate()
+	snapshot := h.snapshot()
+	assert.Equal(t, int64(101), snapshot.count)
+	assert.Equal(t, int64(51), snapshot.p50)
+	assert.Equal(t, int64(91), snapshot.p90)
+	assert.Equal(t, int64(100), snapshot.p99)
+	assert.InDelta(t, maxDecisionLatency.Milliseconds(), snapshot.max, float64(maxDecisionLatency.Milliseconds())/100)
+
+	h.rotate()
+	assert.Equal(t, latencySnapshot{count: 101}, h.snapshot())
+}
@@ -7,13 +7,14 @@ package sampling
 import (
 	"context"
 	"encoding/json"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/dgraph-io/badger/v2"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
 
@@ -46,8 +47,18 @@ type Processor struct {
 	rateLimitedLogger *logp.Logger
 	groups            *traceGroups
 
-	eventStore   *wrappedRW
-	eventMetrics *eventMetrics // heap-allocated for 64-bit alignment
+	eventStore     *wrappedRW
+	eventMetrics   *eventMetrics   // heap-allocated for 64-bit alignment
+	storageMetrics *storageMetrics // heap-allocated for 64-bit alignment
+	clusterMetrics *clusterMetrics // heap-allocated for 64-bit alignment
+
+	// storedEvents counts the events written to local storage within
+	// the TTL, estimating the number of events buffered in storage.
+	storedEvents *eventsWindow
+
+	// decisionLatency records the time between the end of a sampled
+	// trace's most recent stored event and its events being reported.
+	decisionLatency *latencyHistogram
 
 	stopMu   sync.Mutex
 	stopping chan struct{}
@@ -65,6 +76,17 @@ type eventMetrics struct {
 	failedWrites  int64
 }
 
+type storageMetrics struct {
+	valueLogGCRuns     int64
+	valueLogGCRewrites int64
+}
+
+type clusterMetrics struct {
+	forwarded     int64
+	forwardErrors int64
+	received      int64
+}
+
 // NewProcessor returns a new Processor, for tail-sampling trace events.
 func NewProcessor(config Config) (*Processor, error) {
 	if err := config.Validate(); err != nil {
@@ -79,6 +101,10 @@ func NewProcessor(config Config) (*Processor, error) {
 		groups:            newTraceGroups(config.Policies, config.MaxDynamicServices, config.IngestRateDecayFactor),
 		eventStore:        newWrappedRW(config.Storage, config.TTL, int64(config.StorageLimit)),
 		eventMetrics:      &eventMetrics{},
+		storageMetrics:    &storageMetrics{},
+		clusterMetrics:    &clusterMetrics{},
+		storedEvents:      newEventsWindow(config.TTL),
+		decisionLatency:   newLatencyHistogram(),
 		stopping:          make(chan struct{}),
 		stopped:           make(chan struct{}),
 		// NOTE(marclop) This behavior should be configurable so users who
@@ -94,30 +120,81 @@ func NewProcessor(config Config) (*Processor, error) {
 // tail-sampling. It is intended to be used with libbeat/monitoring.NewFunc.
 //
 // The metrics should be added to the "apm-server.sampling.tail" registry.
-func (p *Processor) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
+//
+// Per-level storage table statistics are reported only in monitoring.Full
+// mode, i.e. through the expvar endpoint.
+func (p *Processor) CollectMonitoring(mode monitoring.Mode, V monitoring.Visitor) {
 	V.OnRegistryStart()
 	defer V.OnRegistryFinished()
 
-	// TODO(axw) it might be nice to also report some metrics about:
-	//
-	//   - The time between receiving events and when they are indexed.
-	//     This could be accomplished by recording the time when the
-	//     payload was received in the ECS field `event.created`. The
-	//     final metric would ideally be a distribution, which is not
-	//     currently an option in libbeat/monitoring.
-
 	p.groups.mu.RLock()
 	numDynamicGroups := p.groups.numDynamicServiceGroups
 	p.groups.mu.RUnlock()
 	monitoring.ReportInt(V, "dynamic_service_groups", int64(numDynamicGroups))
 
+	monitoring.ReportNamespace(V, "traces", func() {
+		monitoring.ReportInt(V, "pending", int64(p.groups.pendingTraces()))
+	})
+	monitoring.ReportNamespace(V, "policies", func() {
+		// Policies are identified by their index in the configuration.
+		for i, stats := range p.groups.policyStats() {
+			monitoring.ReportNamespace(V, strconv.Itoa(i), func() {
+				monitoring.ReportFloat(V, "sample_rate", stats.sampleRate)
+				monitoring.ReportInt(V, "matched", stats.matched)
+				monitoring.ReportInt(V, "sampled", stats.sampled)
+				monitoring.ReportFloat(V, "effective_sample_rate", stats.effectiveSampleRate)
+			})
+		}
+	})
+	monitoring.ReportNamespace(V, "decision_latency", func() {
+		latency := p.decisionLatency.snapshot()
+		monitoring.ReportInt(V, "count", latency.count)
+		monitoring.ReportNamespace(V, "ms", func() {
+			monitoring.ReportInt(V, "p50", latency.p50)
+			monitoring.ReportInt(V, "p90", latency.p90)
+			monitoring.ReportInt(V, "p99", latency.p99)
+			monitoring.ReportInt(V, "max", latency.max)
+		})
+	})
 	monitoring.ReportNamespace(V, "storage", func() {
 		lsmSize, valueLogSize := p.config.DB.Size()
 		monitoring.ReportInt(V, "lsm_size", int64(lsmSize))
 		monitoring.ReportInt(V, "value_log_size", int64(valueLogSize))
+
+		tables := p.config.DB.Tables(false)
+		levelTables := make(map[int]int64)
+		levelSizes := make(map[int]int64)
+		for _, table := range tables {
+			levelTables[table.Level]++
+			levelSizes[table.Level] += int64(table.EstimatedSz)
+		}
+		monitoring.ReportInt(V, "tables", int64(len(tables)))
+		// A growing number of level 0 tables indicates that
+		// compaction is not keeping up with writes.
+		monitoring.ReportInt(V, "level0_tables", levelTables[0])
+		if mode == monitoring.Full {
+			monitoring.ReportNamespace(V, "levels", func() {
+				for level, n := range levelTables {
+					monitoring.ReportNamespace(V, strconv.Itoa(level), func() {
+						monitoring.ReportInt(V, "tables", n)
+						monitoring.ReportInt(V, "size", levelSizes[level])
+					})
+				}
+			})
+		}
+		monitoring.ReportNamespace(V, "value_log_gc", func() {
+			monitoring.ReportInt(V, "runs", atomic.LoadInt64(&p.storageMetrics.valueLogGCRuns))
+			monitoring.ReportInt(V, "rewrites", atomic.LoadInt64(&p.storageMetrics.valueLogGCRewrites))
+		})
+	})
+	monitoring.ReportNamespace(V, "cluster", func() {
+		monitoring.ReportInt(V, "forwarded", atomic.LoadInt64(&p.clusterMetrics.forwarded))
+		monitoring.ReportInt(V, "forward_errors", atomic.LoadInt64(&p.clusterMetrics.forwardErrors))
+		monitoring.ReportInt(V, "received", atomic.LoadInt64(&p.clusterMetrics.received))
 	})
 	monitoring.ReportNamespace(V, "events", func() {
 		monitoring.ReportInt(V, "processed", atomic.LoadInt64(&p.eventMetrics.processed))
+		monitoring.ReportInt(V, "buffered", p.storedEvents.sum(time.Now()))
 		monitoring.ReportInt(V, "dropped", atomic.LoadInt64(&p.eventMetrics.dropped))
 		monitoring.ReportInt(V, "stored", atomic.LoadInt64(&p.eventMetrics.stored))
 		monitoring.ReportInt(V, "sampled", atomic.LoadInt64(&p.eventMetrics.sampled))
@@ -126,6 +203,37 @@ func (p *Processor) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
 	})
 }
 
+// QueueState holds the state of the events buffered by the tail-sampling
+// processor while awaiting sampling decisions.
+type QueueState struct {
+	// PendingTraces holds the number of root transactions held in
+	// reservoirs, awaiting the next sampling decision.
+	PendingTraces int `json:"pending_traces"`
+
+	// BufferedEvents holds the estimated number of events buffered in
+	// local storage, i.e. those written within the TTL.
+	BufferedEvents int64 `json:"buffered_events"`
+
+	// StorageBytes holds the size of local storage in bytes.
+	StorageBytes int64 `json:"storage_bytes"`
+
+	// StorageLimitBytes holds the local storage limit in bytes,
+	// or zero if there is no limit.
+	StorageLimitBytes int64 `json:"storage_limit_bytes"`
+}
+
+// QueueState returns the current state of the events buffered by the
+// processor. QueueState is safe for concurrent use.
+func (p *Processor) QueueState() QueueState {
+	lsmSize, valueLogSize := p.config.DB.Size()
+	return QueueState{
+		PendingTraces:     p.groups.pendingTraces(),
+		BufferedEvents:    p.storedEvents.sum(time.Now()),
+		StorageBytes:      lsmSize + valueLogSize,
+		StorageLimitBytes: int64(p.config.StorageLimit),
+	}
+}
+
 // ProcessBatch tail-samples transactions and spans.
 //
 // Any events remaining in the batch after the processor returns
@@ -137,8 +245,98 @@ func (p *Processor) CollectMonitoring(_ monitoring.Mode, V monitoring.Visitor) {
 //
 // All other trace events will either be dropped (e.g. known to not
 // be tail-sampled), or stored for possible later publication.
+//
+// In dry-run mode, all events remain in the batch. Metrics are
+// updated as if events would have been dropped or stored.
+//
+// If cluster members are configured, trace events for traces owned by
+// other members are first removed from the batch and forwarded to them.
 func (p *Processor) ProcessBatch(ctx context.Context, batch *model.Batch) error {
+	if len(p.config.Members) > 0 {
+		p.forwardEvents(ctx, batch)
+	}
+	return p.processBatch(ctx, batch)
+}
+
+// ProcessForwardedBatch tail-samples trace events forwarded by another
+// cluster member, for traces owned by this instance. Any events remaining
+// in the batch after tail-sampling are published immediately with the
+// configured BatchProcessor.
+func (p *Processor) ProcessForwardedBatch(ctx context.Context, batch *model.Batch) error {
+	atomic.AddInt64(&p.clusterMetrics.received, int64(len(*batch)))
+	if err := p.processBatch(ctx, batch); err != nil {
+		return err
+	}
+	if len(*batch) == 0 {
+		return nil
+	}
+	return p.config.BatchProcessor.ProcessBatch(ctx, batch)
+}
+
+// forwardEvents removes trace events for traces owned by other cluster
+// members from the batch, and forwards them to their owners. Events which
+// cannot be forwarded are returned to the batch, and tail-sampled locally.
+func (p *Processor) forwardEvents(ctx context.Context, batch *model.Batch) {
 	events := *batch
+	var forward map[string]model.Batch
+	for i := 0; i < len(events); i++ {
+		event := &events[i]
+		switch event.Processor {
+		case model.TransactionProcessor:
+			if !event.Transaction.Sampled {
+				// (Head-based) unsampled transactions are
+				// passed through by the tail sampler.
+				continue
+			}
+		case model.SpanProcessor:
+		case model.LogProcessor:
+			if !isSpanEvent(event) {
+				continue
+			}
+		default:
+			continue
+		}
+		owner := traceOwner(p.config.Members, event.Trace.ID)
+		if owner == p.config.Self {
+			continue
+		}
+		if forward == nil {
+			forward = make(map[string]model.Batch)
+		}
+		forward[owner] = append(forward[owner], *event)
+		n := len(events)
+		events[i], events[n-1] = events[n-1], events[i]
+		events = events[:n-1]
+		i--
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for owner, ownerEvents := range forward {
+		owner, ownerEvents := owner, ownerEvents
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := p.config.Forwarder.ForwardEvents(ctx, owner, ownerEvents); err != nil {
+				atomic.AddInt64(&p.clusterMetrics.forwardErrors, int64(len(ownerEvents)))
+				p.rateLimitedLogger.With(logp.Error(err)).Warnf(
+					"failed to forward events to %s, sampling locally", owner,
+				)
+				mu.Lock()
+				events = append(events, ownerEvents...)
+				mu.Unlock()
+				return
+			}
+			atomic.AddInt64(&p.clusterMetrics.forwarded, int64(len(ownerEvents)))
+		}()
+	}
+	wg.Wait()
+	*batch = events
+}
+
+func (p *Processor) processBatch(ctx context.Context, batch *model.Batch) error {
+	events := *batch
+	var numStored int64
 	for i := 0; i < len(events); i++ {
 		event := &events[i]
 		var report, stored, failed bool
@@ -149,7 +347,15 @@ func (p *Processor) ProcessBatch(ctx context.Context, batch *model.Batch) error
 			report, stored, err = p.processTransaction(event)
 		case model.SpanProcessor:
 			atomic.AddInt64(&p.eventMetrics.processed, 1)
-			report, stored, err = p.processSpan(event)
+			report, stored, err = p.processTraceEvent(event, event.Span.ID)
+		case model.LogProcessor:
+			if !isSpanEvent(event) {
+				// Logs which are not span events are
+				// passed through by the tail sampler.
+				continue
+			}
+			atomic.AddInt64(&p.eventMetrics.processed, 1)
+			report, stored, err = p.processTraceEvent(event, spanEventID(event))
 		default:
 			continue
 		}
@@ -168,15 +374,21 @@ func (p *Processor) ProcessBatch(ctx context.Context, batch *model.Batch) error
 			}
 		}
 
-		if !report {
+		p.updateProcessorMetrics(report, stored, failed)
+		if stored {
+			numStored++
+		}
+
+		if !report && !p.config.DryRun {
 			// We shouldn't report this event, so remove it from the slice.
 			n := len(events)
 			events[i], events[n-1] = events[n-1], events[i]
 			events = events[:n-1]
 			i--
 		}
-
-		p.updateProcessorMetrics(report, stored, failed)
+	}
+	if numStored > 0 {
+		p.storedEvents.add(time.Now(), numStored)
 	}
 	*batch = events
 	return nil
@@ -269,12 +481,14 @@ sampling policies without service name specified.
 	return false, true, p.eventStore.WriteTraceEvent(event.Trace.ID, event.Transaction.ID, event)
 }
 
-func (p *Processor) processSpan(event *model.APMEvent) (report, stored bool, _ error) {
+// processTraceEvent processes a non-transaction trace event, i.e. a span or
+// span event, storing it with the given ID until a sampling decision is made.
+func (p *Processor) processTraceEvent(event *model.APMEvent, id string) (report, stored bool, _ error) {
 	traceSampled, err := p.eventStore.IsTraceSampled(event.Trace.ID)
 	if err != nil {
 		if err == eventstorage.ErrNotFound {
 			// Tail-sampling decision has not yet been made, write event to local storage.
-			return false, true, p.eventStore.WriteTraceEvent(event.Trace.ID, event.Span.ID, event)
+			return false, true, p.eventStore.WriteTraceEvent(event.Trace.ID, id, event)
 		}
 		return false, false, err
 	}
@@ -285,6 +499,24 @@ func (p *Processor) processSpan(event *model.APMEvent) (report, stored bool, _ e
 	return traceSampled, false, nil
 }
 
+// isSpanEvent reports whether event is a log event recorded within a span,
+// e.g. an OpenTelemetry span event, which must be sampled along with its trace.
+// Span events are identified by having both a trace ID and a parent ID.
+func isSpanEvent(event *model.APMEvent) bool {
+	return event.Processor == model.LogProcessor && event.Trace.ID != "" && event.Parent.ID != ""
+}
+
+// spanEventID returns an ID for storing the span event. Span events do not
+// have their own IDs, so we derive one from the parent span ID, timestamp,
+// and message.
+func spanEventID(event *model.APMEvent) string {
+	h := fnv.New64a()
+	h.Write([]byte(event.Message))
+	return event.Parent.ID + "." +
+		strconv.FormatInt(event.Timestamp.UnixNano(), 16) + "." +
+		strconv.FormatUint(h.Sum64(), 16)
+}
+
 // Stop stops the processor, flushing event storage. Note that the underlying
 // badger.DB must be closed independently to ensure writes are synced to disk.
 func (p *Processor) Stop(ctx context.Context) error {
@@ -342,20 +574,24 @@ func (p *Processor) Run() error {
 		return err
 	}
 	subscriberPositions := make(chan pubsub.SubscriberPosition)
-	pubsub, err := pubsub.New(pubsub.Config{
-		ServerID:   p.config.UUID,
-		Client:     p.config.Elasticsearch,
-		DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
-		Logger:     p.logger,
-
-		// Issue pubsub subscriber search requests at twice the frequency
-		// of publishing, so each server observes each other's sampled
-		// trace IDs soon after they are published.
-		SearchInterval: p.config.FlushInterval / 2,
-		FlushInterval:  bulkIndexerFlushInterval,
-	})
-	if err != nil {
-		return err
+	remotePubsub := p.config.Pubsub
+	if remotePubsub == nil {
+		esPubsub, err := pubsub.New(pubsub.Config{
+			ServerID:   p.config.UUID,
+			Client:     p.config.Elasticsearch,
+			DataStream: pubsub.DataStreamConfig(p.config.SampledTracesDataStream),
+			Logger:     p.logger,
+
+			// Issue pubsub subscriber search requests at twice the frequency
+			// of publishing, so each server observes each other's sampled
+			// trace IDs soon after they are published.
+			SearchInterval: p.config.FlushInterval / 2,
+			FlushInterval:  bulkIndexerFlushInterval,
+		})
+		if err != nil {
+			return err
+		}
+		remotePubsub = esPubsub
 	}
 
 	remoteSampledTraceIDs := make(chan string)
@@ -387,13 +623,10 @@ func (p *Processor) Run() error {
 				return ctx.Err()
 			case <-ticker.C:
 				const discardRatio = 0.5
-				var err error
-				for err == nil {
-					// Keep garbage collecting until there are no more rewrites,
-					// or garbage collection fails.
-					err = p.config.DB.RunValueLogGC(discardRatio)
-				}
-				if err != nil && err != badger.ErrNoRewrite {
+				atomic.AddInt64(&p.storageMetrics.valueLogGCRuns, 1)
+				rewrites, err := eventstorage.RunValueLogGC(p.config.DB, discardRatio)
+				atomic.AddInt64(&p.storageMetrics.valueLogGCRewrites, int64(rewrites))
+				if err != nil {
 					return err
 				}
 			}
@@ -401,10 +634,10 @@ func (p *Processor) Run() error {
 	})
 	g.Go(func() error {
 		defer close(subscriberPositions)
-		return pubsub.SubscribeSampledTraceIDs(ctx, initialSubscriberPosition, remoteSampledTraceIDs, subscriberPositions)
+		return remotePubsub.SubscribeSampledTraceIDs(ctx, initialSubscriberPosition, remoteSampledTraceIDs, subscriberPositions)
 	})
 	g.Go(func() error {
-		return pubsub.PublishSampledTraceIDs(ctx, publishSampledTraceIDs)
+		return remotePubsub.PublishSampledTraceIDs(ctx, publishSampledTraceIDs)
 	})
 	g.Go(func() error {
 		ticker := time.NewTicker(p.config.FlushInterval)
@@ -412,6 +645,7 @@ func (p *Processor) Run() error {
 		var traceIDs []string
 
 		publishDecisions := func() error {
+			p.decisionLatency.rotate()
 			p.logger.Debug("finalizing local sampling reservoirs")
 			traceIDs = p.groups.finalizeSampledTraces(traceIDs)
 			if len(traceIDs) == 0 {
@@ -487,10 +721,19 @@ func (p *Processor) Run() error {
 							if err := p.eventStore.DeleteTraceEvent(event.Trace.ID, event.Span.ID); err != nil {
 								return errors.Wrap(err, "failed to delete span from local storage")
 							}
+						case model.LogProcessor:
+							if err := p.eventStore.DeleteTraceEvent(event.Trace.ID, spanEventID(&event)); err != nil {
+								return errors.Wrap(err, "failed to delete span event from local storage")
+							}
 						}
 					}
 				}
 				atomic.AddInt64(&p.eventMetrics.sampled, int64(len(events)))
+				p.decisionLatency.record(time.Since(latestEventEnd(events)))
+				if p.config.DryRun {
+					// The events were indexed when they were received.
+					continue
+				}
 				if err := p.config.BatchProcessor.ProcessBatch(ctx, &events); err != nil {
 					p.logger.With(logp.Error(err)).Warn("failed to report events")
 				}
@@ -542,6 +785,17 @@ func writeSubscriberPosition(storageDir string, pos pubsub.SubscriberPosition) e
 	return os.WriteFile(filepath.Join(storageDir, subscriberPositionFile), data, 0644)
 }
 
+// latestEventEnd returns the latest end time of the given events.
+func latestEventEnd(events model.Batch) time.Time {
+	var latest time.Time
+	for _, event := range events {
+		if end := event.Timestamp.Add(event.Event.Duration); end.After(latest) {
+			latest = end
+		}
+	}
+	return latest
+}
+
 func sendTraceIDs(ctx context.Context, out chan<- string, traceIDs []string) error {
 	for _, traceID := range traceIDs {
 		select {
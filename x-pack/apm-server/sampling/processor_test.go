@@ -21,10 +21,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/collector/pdata/pcommon"
+	"go.opentelemetry.io/collector/pdata/ptrace"
 
 	"github.com/elastic/apm-server/internal/model"
+	"github.com/elastic/apm-server/internal/processor/otel"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub"
 	"github.com/elastic/apm-server/x-pack/apm-server/sampling/pubsub/pubsubtest"
 	"github.com/elastic/elastic-agent-libs/monitoring"
 )
@@ -132,6 +136,7 @@ func TestProcessAlreadyTailSampled(t *testing.T) {
 	expectedMonitoring.Ints["sampling.events.processed"] = 4
 	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 0
 	expectedMonitoring.Ints["sampling.events.stored"] = 2
+	expectedMonitoring.Ints["sampling.events.buffered"] = 2
 	expectedMonitoring.Ints["sampling.events.sampled"] = 2
 	expectedMonitoring.Ints["sampling.events.dropped"] = 0
 	expectedMonitoring.Ints["sampling.events.failed_writes"] = 0
@@ -203,6 +208,10 @@ func TestProcessLocalTailSampling(t *testing.T) {
 	require.NoError(t, err)
 	assert.Empty(t, in)
 
+	queueState := processor.QueueState()
+	queueState.StorageBytes = 0 // depends on storage internals
+	assert.Equal(t, sampling.QueueState{PendingTraces: 2, BufferedEvents: 4}, queueState)
+
 	// Start periodic tail-sampling. We start the processor after processing
 	// events to ensure all events are processed before any local sampling
 	// decisions are made, such that we have a single tail-sampling decision
@@ -238,6 +247,7 @@ func TestProcessLocalTailSampling(t *testing.T) {
 	expectedMonitoring := monitoring.MakeFlatSnapshot()
 	expectedMonitoring.Ints["sampling.events.processed"] = 4
 	expectedMonitoring.Ints["sampling.events.stored"] = 4
+	expectedMonitoring.Ints["sampling.events.buffered"] = 4
 	expectedMonitoring.Ints["sampling.events.sampled"] = 2
 	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 0
 	expectedMonitoring.Ints["sampling.events.dropped"] = 0
@@ -390,6 +400,69 @@ func TestProcessLocalTailSamplingPolicyOrder(t *testing.T) {
 	}
 }
 
+func TestProcessLocalTailSamplingDryRun(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{{SampleRate: 1}}
+	config.FlushInterval = 10 * time.Millisecond
+	config.DryRun = true
+	config.BatchProcessor = model.ProcessBatchFunc(func(context.Context, *model.Batch) error {
+		t.Error("unexpected publication of stored events in dry-run mode")
+		return nil
+	})
+	published := make(chan string)
+	config.Elasticsearch = pubsubtest.Client(pubsubtest.PublisherChan(published), nil)
+
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+
+	trace := model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"}
+	in := model.Batch{{
+		Processor: model.TransactionProcessor,
+		Trace:     trace,
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Transaction: &model.Transaction{
+			ID:      "0102030405060708",
+			Sampled: true,
+		},
+	}, {
+		Processor: model.SpanProcessor,
+		Trace:     trace,
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Span: &model.Span{
+			ID: "0102030405060709",
+		},
+	}}
+	events := append(model.Batch{}, in...)
+	err = processor.ProcessBatch(context.Background(), &in)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, events, in) // all events are indexed immediately
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	// Sampling decisions are still made and published.
+	select {
+	case traceID := <-published:
+		assert.Equal(t, trace.ID, traceID)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for publication")
+	}
+	assert.Eventually(t, func() bool {
+		metrics := collectProcessorMetrics(processor)
+		return metrics.Ints["sampling.events.sampled"] == 2
+	}, 10*time.Second, 10*time.Millisecond)
+
+	expectedMonitoring := monitoring.MakeFlatSnapshot()
+	expectedMonitoring.Ints["sampling.events.processed"] = 2
+	expectedMonitoring.Ints["sampling.events.stored"] = 2
+	expectedMonitoring.Ints["sampling.events.buffered"] = 2
+	expectedMonitoring.Ints["sampling.events.sampled"] = 2
+	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 0
+	expectedMonitoring.Ints["sampling.events.dropped"] = 0
+	expectedMonitoring.Ints["sampling.events.failed_writes"] = 0
+	assertMonitoring(t, processor, expectedMonitoring, `sampling.events.*`)
+}
+
 func TestProcessRemoteTailSampling(t *testing.T) {
 	config := newTempdirConfig(t)
 	config.Policies = []sampling.Policy{{SampleRate: 0.5}}
@@ -428,6 +501,11 @@ func TestProcessRemoteTailSampling(t *testing.T) {
 		Span: &model.Span{
 			ID: "0102030405060709",
 		},
+	}, {
+		Processor: model.LogProcessor,
+		Trace:     model.Trace{ID: traceID1},
+		Parent:    model.Parent{ID: "0102030405060709"},
+		Message:   "span event",
 	}}
 
 	in := trace1Events[:]
@@ -460,15 +538,16 @@ func TestProcessRemoteTailSampling(t *testing.T) {
 	assert.Empty(t, published) // remote decisions don't get republished
 
 	expectedMonitoring := monitoring.MakeFlatSnapshot()
-	expectedMonitoring.Ints["sampling.events.processed"] = 1
-	expectedMonitoring.Ints["sampling.events.stored"] = 1
-	expectedMonitoring.Ints["sampling.events.sampled"] = 1
+	expectedMonitoring.Ints["sampling.events.processed"] = 2
+	expectedMonitoring.Ints["sampling.events.stored"] = 2
+	expectedMonitoring.Ints["sampling.events.buffered"] = 2
+	expectedMonitoring.Ints["sampling.events.sampled"] = 2
 	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 0
 	expectedMonitoring.Ints["sampling.events.dropped"] = 0
 	expectedMonitoring.Ints["sampling.events.failed_writes"] = 0
 	assertMonitoring(t, processor, expectedMonitoring, `sampling.events.*`)
 
-	assert.Equal(t, trace1Events, events)
+	assert.ElementsMatch(t, trace1Events, events)
 
 	storage := eventstorage.New(config.DB, eventstorage.JSONCodec{})
 	reader := storage.NewReadWriter()
@@ -493,6 +572,314 @@ func TestProcessRemoteTailSampling(t *testing.T) {
 	assert.Empty(t, batch)
 }
 
+func TestProcessRemoteTailSamplingPubsub(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{{SampleRate: 0.5}}
+	config.FlushInterval = 10 * time.Millisecond
+
+	remote := make(chan string)
+	config.Elasticsearch = nil
+	config.Pubsub = chanPubsub{remote: remote}
+
+	reported := make(chan model.Batch)
+	config.BatchProcessor = model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reported <- *batch:
+			return nil
+		}
+	})
+
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	traceID := "0102030405060708090a0b0c0d0e0f10"
+	traceEvents := model.Batch{{
+		Processor: model.SpanProcessor,
+		Trace:     model.Trace{ID: traceID},
+		Event:     model.Event{Duration: 123 * time.Millisecond},
+		Span: &model.Span{
+			ID: "0102030405060709",
+		},
+	}}
+	in := traceEvents[:]
+	err = processor.ProcessBatch(context.Background(), &in)
+	require.NoError(t, err)
+	assert.Empty(t, in)
+
+	remote <- traceID
+	select {
+	case events := <-reported:
+		assert.Equal(t, traceEvents, events)
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for reporting")
+	}
+}
+
+// chanPubsub is a sampling.Pubsub which discards published trace IDs,
+// and subscribes to trace IDs received on a channel.
+type chanPubsub struct {
+	remote <-chan string
+}
+
+func (p chanPubsub) PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-traceIDs:
+		}
+	}
+}
+
+func (p chanPubsub) SubscribeSampledTraceIDs(
+	ctx context.Context,
+	pos pubsub.SubscriberPosition,
+	traceIDs chan<- string,
+	positions chan<- pubsub.SubscriberPosition,
+) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case id := <-p.remote:
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case traceIDs <- id:
+			}
+		}
+	}
+}
+
+func TestProcessForwarding(t *testing.T) {
+	var forwarded []model.Batch
+	var forwardErr error
+	var numFailed int
+	config := newTempdirConfig(t)
+	config.Members = []string{"apm-1:8200", "apm-2:8200"}
+	config.Self = "apm-1:8200"
+	config.Forwarder = forwarderFunc(func(ctx context.Context, addr string, events model.Batch) error {
+		assert.Equal(t, "apm-2:8200", addr)
+		if forwardErr != nil {
+			numFailed += len(events)
+			return forwardErr
+		}
+		forwarded = append(forwarded, events)
+		return nil
+	})
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+
+	makeBatch := func() model.Batch {
+		var batch model.Batch
+		for i := 0; i < 20; i++ {
+			traceID := uuid.Must(uuid.NewV4()).String()
+			batch = append(batch, model.APMEvent{
+				Processor:   model.TransactionProcessor,
+				Trace:       model.Trace{ID: traceID},
+				Transaction: &model.Transaction{ID: traceID[:16], Sampled: true},
+			}, model.APMEvent{
+				Processor: model.SpanProcessor,
+				Trace:     model.Trace{ID: traceID},
+				Parent:    model.Parent{ID: traceID[:16]},
+				Span:      &model.Span{ID: traceID[16:32]},
+			})
+		}
+		// Head-based unsampled transactions are not forwarded.
+		return append(batch, model.APMEvent{
+			Processor:   model.TransactionProcessor,
+			Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+			Transaction: &model.Transaction{ID: "0102030405060708", Sampled: false},
+		})
+	}
+
+	batch := makeBatch()
+	err = processor.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	require.Len(t, forwarded, 1)
+	assert.Len(t, batch, 1) // head-based unsampled transaction
+
+	// All events for a forwarded trace are forwarded together.
+	forwardedTraces := make(map[string]int)
+	for _, event := range forwarded[0] {
+		forwardedTraces[event.Trace.ID]++
+	}
+	for traceID, n := range forwardedTraces {
+		assert.Equal(t, 2, n, traceID)
+	}
+	numForwarded := len(forwarded[0])
+	assert.NotZero(t, numForwarded)
+	assert.Less(t, numForwarded, 40)
+
+	// Events which cannot be forwarded are sampled locally.
+	forwardErr = errors.New("boom")
+	batch = makeBatch()
+	err = processor.ProcessBatch(context.Background(), &batch)
+	require.NoError(t, err)
+	assert.Len(t, batch, 1)
+	assert.NotZero(t, numFailed)
+
+	expectedMonitoring := monitoring.MakeFlatSnapshot()
+	expectedMonitoring.Ints["sampling.cluster.forwarded"] = int64(numForwarded)
+	expectedMonitoring.Ints["sampling.cluster.forward_errors"] = int64(numFailed)
+	expectedMonitoring.Ints["sampling.cluster.received"] = 0
+	expectedMonitoring.Ints["sampling.events.stored"] = 80 - int64(numForwarded)
+	assertMonitoring(t, processor, expectedMonitoring, `sampling.cluster.*`, `sampling.events.stored`)
+}
+
+func TestProcessForwardedBatch(t *testing.T) {
+	var published model.Batch
+	config := newTempdirConfig(t)
+	config.BatchProcessor = model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		published = append(published, *batch...)
+		return nil
+	})
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+
+	sampled := model.APMEvent{
+		Processor:   model.TransactionProcessor,
+		Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f10"},
+		Transaction: &model.Transaction{ID: "0102030405060708", Sampled: true},
+	}
+	unsampled := model.APMEvent{
+		Processor:   model.TransactionProcessor,
+		Trace:       model.Trace{ID: "0102030405060708090a0b0c0d0e0f11"},
+		Transaction: &model.Transaction{ID: "0102030405060709", Sampled: false},
+	}
+	batch := model.Batch{sampled, unsampled}
+	err = processor.ProcessForwardedBatch(context.Background(), &batch)
+	require.NoError(t, err)
+
+	// Events remaining after tail-sampling are published immediately.
+	assert.Equal(t, model.Batch{unsampled}, published)
+
+	expectedMonitoring := monitoring.MakeFlatSnapshot()
+	expectedMonitoring.Ints["sampling.cluster.received"] = 2
+	expectedMonitoring.Ints["sampling.events.stored"] = 1
+	assertMonitoring(t, processor, expectedMonitoring, `sampling.cluster.received`, `sampling.events.stored`)
+}
+
+func TestProcessOTLPTraces(t *testing.T) {
+	config := newTempdirConfig(t)
+	config.Policies = []sampling.Policy{
+		{PolicyCriteria: sampling.PolicyCriteria{TraceName: "sampled"}, SampleRate: 1},
+		{SampleRate: 0},
+	}
+	config.FlushInterval = 10 * time.Millisecond
+	reported := make(chan model.Batch)
+	config.BatchProcessor = model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case reported <- *batch:
+			return nil
+		}
+	})
+	processor, err := sampling.NewProcessor(config)
+	require.NoError(t, err)
+
+	// Translate OTLP traces to events in the same way as the OTLP intake,
+	// and send them through the tail-sampling processor.
+	var passed model.Batch
+	consumer := otel.Consumer{Processor: model.ProcessBatchFunc(func(ctx context.Context, batch *model.Batch) error {
+		if err := processor.ProcessBatch(ctx, batch); err != nil {
+			return err
+		}
+		passed = append(passed, *batch...)
+		return nil
+	})}
+	traces := ptrace.NewTraces()
+	scopeSpans := traces.ResourceSpans().AppendEmpty().ScopeSpans().AppendEmpty()
+	startTime := time.Unix(123, 0)
+	addTrace := func(traceID pcommon.TraceID, name string) {
+		spanStartTime := pcommon.NewTimestampFromTime(startTime)
+		spanEndTime := pcommon.NewTimestampFromTime(startTime.Add(time.Second))
+		root := scopeSpans.Spans().AppendEmpty()
+		root.SetTraceID(traceID)
+		root.SetSpanID(pcommon.SpanID{traceID[0], 1})
+		root.SetName(name)
+		root.SetStartTimestamp(spanStartTime)
+		root.SetEndTimestamp(spanEndTime)
+		root.Events().AppendEmpty().SetName("a_span_event")
+		exception := root.Events().AppendEmpty()
+		exception.SetName("exception")
+		exception.Attributes().PutStr("exception.message", "boom")
+
+		for i, kind := range []ptrace.SpanKind{ptrace.SpanKindClient, ptrace.SpanKindServer} {
+			child := scopeSpans.Spans().AppendEmpty()
+			child.SetTraceID(traceID)
+			child.SetSpanID(pcommon.SpanID{traceID[0], byte(i + 2)})
+			child.SetParentSpanID(root.SpanID())
+			child.SetKind(kind)
+			child.SetStartTimestamp(spanStartTime)
+			child.SetEndTimestamp(spanEndTime)
+		}
+	}
+	sampledTraceID := pcommon.TraceID{1}
+	unsampledTraceID := pcommon.TraceID{2}
+	addTrace(sampledTraceID, "sampled")
+	addTrace(unsampledTraceID, "unsampled")
+	require.NoError(t, consumer.ConsumeTraces(context.Background(), traces))
+
+	// Errors are passed through by the tail sampler, while transactions,
+	// spans, and span events are held back until a sampling decision is
+	// made, as for events received from Elastic APM agents.
+	require.Len(t, passed, 2)
+	for _, event := range passed {
+		assert.Equal(t, model.ErrorProcessor, event.Processor)
+	}
+
+	go processor.Run()
+	defer processor.Stop(context.Background())
+
+	var events model.Batch
+	select {
+	case events = <-reported:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for reporting")
+	}
+	select {
+	case <-reported:
+		t.Fatal("unexpected reporting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	var processorEvents []string
+	for _, event := range events {
+		assert.Equal(t, sampledTraceID.HexString(), event.Trace.ID)
+		processorEvents = append(processorEvents, event.Processor.Event)
+		switch event.Processor {
+		case model.TransactionProcessor:
+			assert.Equal(t, 1.0, event.Transaction.RepresentativeCount)
+		case model.SpanProcessor:
+			assert.Equal(t, 1.0, event.Span.RepresentativeCount)
+		}
+	}
+	sort.Strings(processorEvents)
+	assert.Equal(t, []string{"log", "span", "transaction", "transaction"}, processorEvents)
+
+	expectedMonitoring := monitoring.MakeFlatSnapshot()
+	expectedMonitoring.Ints["sampling.events.processed"] = 8
+	expectedMonitoring.Ints["sampling.events.stored"] = 7
+	expectedMonitoring.Ints["sampling.events.buffered"] = 7
+	expectedMonitoring.Ints["sampling.events.sampled"] = 4
+	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 0
+	expectedMonitoring.Ints["sampling.events.dropped"] = 1
+	expectedMonitoring.Ints["sampling.events.failed_writes"] = 0
+	assertMonitoring(t, processor, expectedMonitoring, `sampling.events.*`)
+}
+
+type forwarderFunc func(ctx context.Context, addr string, events model.Batch) error
+
+func (f forwarderFunc) ForwardEvents(ctx context.Context, addr string, events model.Batch) error {
+	return f(ctx, addr, events)
+}
+
 func TestGroupsMonitoring(t *testing.T) {
 	config := newTempdirConfig(t)
 	config.MaxDynamicServices = 5
@@ -522,6 +909,7 @@ func TestGroupsMonitoring(t *testing.T) {
 	expectedMonitoring.Ints["sampling.dynamic_service_groups"] = int64(config.MaxDynamicServices)
 	expectedMonitoring.Ints["sampling.events.processed"] = int64(config.MaxDynamicServices) + 2
 	expectedMonitoring.Ints["sampling.events.stored"] = int64(config.MaxDynamicServices)
+	expectedMonitoring.Ints["sampling.events.buffered"] = int64(config.MaxDynamicServices)
 	expectedMonitoring.Ints["sampling.events.dropped"] = 1 // final event dropped, after service limit reached
 	expectedMonitoring.Ints["sampling.events.sampled"] = 0
 	expectedMonitoring.Ints["sampling.events.head_unsampled"] = 1
@@ -575,7 +963,7 @@ func TestStorageGC(t *testing.T) {
 
 	// Create a new badger DB with smaller value log files so we can test GC.
 	config.DB.Close()
-	badgerDB, err := eventstorage.OpenBadger(config.StorageDir, 1024*1024)
+	badgerDB, err := eventstorage.OpenBadger(config.StorageDir, eventstorage.BadgerOptions{ValueLogFileSize: 1024 * 1024})
 	require.NoError(t, err)
 	t.Cleanup(func() { badgerDB.Close() })
 	config.DB = badgerDB
@@ -686,7 +1074,7 @@ func TestStorageLimit(t *testing.T) {
 
 	// Open a new instance of the badgerDB and check the size.
 	var err error
-	config.DB, err = eventstorage.OpenBadger(config.StorageDir, 1024*1024)
+	config.DB, err = eventstorage.OpenBadger(config.StorageDir, eventstorage.BadgerOptions{ValueLogFileSize: 1024 * 1024})
 	require.NoError(t, err)
 	t.Cleanup(func() { config.DB.Close() })
 
@@ -780,7 +1168,7 @@ func newTempdirConfig(tb testing.TB) sampling.Config {
 	require.NoError(tb, err)
 	tb.Cleanup(func() { os.RemoveAll(tempdir) })
 
-	badgerDB, err := eventstorage.OpenBadger(tempdir, 0)
+	badgerDB, err := eventstorage.OpenBadger(tempdir, eventstorage.BadgerOptions{})
 	require.NoError(tb, err)
 	tb.Cleanup(func() { badgerDB.Close() })
 
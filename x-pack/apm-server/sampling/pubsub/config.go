@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/pkg/errors"
 
 	"github.com/elastic/elastic-agent-libs/logp"
@@ -85,6 +86,47 @@ func (config Config) Validate() error {
 	return nil
 }
 
+// KafkaConfig holds configuration for KafkaPubsub.
+type KafkaConfig struct {
+	// Client holds a Kafka client, for producing and consuming trace ID
+	// observations. Client will not be closed by KafkaPubsub.
+	//
+	// The client must be configured to return producer errors, and not
+	// to return producer successes.
+	Client sarama.Client
+
+	// Topic holds the Kafka topic to which sampled trace IDs are published.
+	//
+	// The topic's retention should be no less than the TTL for events in
+	// local storage, and not much greater, as subscribers without a recorded
+	// position consume the topic from the oldest available offset.
+	Topic string
+
+	// ServerID holds the APM Server's unique ID, used for filtering out
+	// local observations in the subscriber. ServerID may be ephemeral.
+	ServerID string
+
+	// Logger is used for logging publish and subscribe operations -- particularly
+	// errors that occur asynchronously.
+	//
+	// If Logger is nil, a new logger will be constructed.
+	Logger *logp.Logger
+}
+
+// Validate validates the configuration.
+func (config KafkaConfig) Validate() error {
+	if config.Client == nil {
+		return errors.New("Client unspecified")
+	}
+	if config.Topic == "" {
+		return errors.New("Topic unspecified")
+	}
+	if config.ServerID == "" {
+		return errors.New("ServerID unspecified")
+	}
+	return nil
+}
+
 // Validate validates the configuration.
 func (config DataStreamConfig) Validate() error {
 	if config.Type == "" {
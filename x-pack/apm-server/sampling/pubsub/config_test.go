@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/Shopify/sarama"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -90,3 +91,35 @@ func TestConfigInvalid(t *testing.T) {
 		assert.EqualError(t, err, "invalid pubsub config: "+test.err)
 	}
 }
+
+func TestKafkaConfigInvalid(t *testing.T) {
+	var kafkaClient struct {
+		sarama.Client
+	}
+
+	type test struct {
+		config pubsub.KafkaConfig
+		err    string
+	}
+
+	for _, test := range []test{{
+		config: pubsub.KafkaConfig{},
+		err:    "Client unspecified",
+	}, {
+		config: pubsub.KafkaConfig{
+			Client: kafkaClient,
+		},
+		err: "Topic unspecified",
+	}, {
+		config: pubsub.KafkaConfig{
+			Client: kafkaClient,
+			Topic:  "topic",
+		},
+		err: "ServerID unspecified",
+	}} {
+		pubsub, err := pubsub.NewKafka(test.config)
+		require.Error(t, err)
+		require.Nil(t, pubsub)
+		assert.EqualError(t, err, "invalid kafka pubsub config: "+test.err)
+	}
+}
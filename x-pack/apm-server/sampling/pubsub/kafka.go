@@ -0,0 +1,224 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Shopify/sarama"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+
+	"github.com/elastic/apm-server/internal/logs"
+)
+
+// KafkaPubsub provides a means of publishing and subscribing to sampled trace
+// IDs, using a Kafka topic for transport.
+//
+// Unlike Pubsub, KafkaPubsub does not poll for sampled trace IDs: they are
+// delivered to subscribers as soon as they are published, and without placing
+// any load on Elasticsearch.
+type KafkaPubsub struct {
+	config KafkaConfig
+
+	newProducer func() (sarama.AsyncProducer, error)
+	newConsumer func() (sarama.Consumer, error)
+}
+
+// NewKafka returns a new KafkaPubsub which can publish and subscribe sampled
+// trace IDs, using Kafka for transport.
+//
+// Every subscriber consumes all partitions of the topic, without a consumer
+// group, so that each server observes every other server's sampled trace IDs.
+func NewKafka(config KafkaConfig) (*KafkaPubsub, error) {
+	if err := config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid kafka pubsub config")
+	}
+	if config.Logger == nil {
+		config.Logger = logp.NewLogger(logs.Sampling)
+	}
+	return &KafkaPubsub{
+		config: config,
+		newProducer: func() (sarama.AsyncProducer, error) {
+			return sarama.NewAsyncProducerFromClient(config.Client)
+		},
+		newConsumer: func() (sarama.Consumer, error) {
+			return sarama.NewConsumerFromClient(config.Client)
+		},
+	}, nil
+}
+
+// PublishSampledTraceIDs receives trace IDs from the traceIDs channel,
+// producing them to the Kafka topic. PublishSampledTraceIDs returns when
+// ctx is canceled.
+func (p *KafkaPubsub) PublishSampledTraceIDs(ctx context.Context, traceIDs <-chan string) error {
+	producer, err := p.newProducer()
+	if err != nil {
+		return err
+	}
+	errorsDone := make(chan struct{})
+	go func() {
+		defer close(errorsDone)
+		for err := range producer.Errors() {
+			p.config.Logger.With(logp.Error(err.Err)).Debug("failed to publish sampled trace id")
+		}
+	}()
+	defer func() {
+		// Flush buffered messages, and wait for any errors to be logged.
+		producer.AsyncClose()
+		<-errorsDone
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := ctx.Err(); err != context.Canceled {
+				return err
+			}
+			return nil
+		case id := <-traceIDs:
+			var doc traceIDDocument
+			doc.Agent.EphemeralID = p.config.ServerID
+			doc.Trace.ID = id
+			value, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			msg := &sarama.ProducerMessage{
+				Topic: p.config.Topic,
+				Key:   sarama.StringEncoder(id),
+				Value: sarama.ByteEncoder(value),
+			}
+			select {
+			case <-ctx.Done():
+			case producer.Input() <- msg:
+			}
+		}
+	}
+}
+
+// SubscribeSampledTraceIDs subscribes to sampled trace IDs after the given position,
+// sending them to the traceIDs channel, and sending the most recently observed position
+// (on change) to the positions channel.
+//
+// Partitions with no recorded position are consumed from the oldest available offset.
+func (p *KafkaPubsub) SubscribeSampledTraceIDs(
+	ctx context.Context,
+	pos SubscriberPosition,
+	traceIDs chan<- string,
+	positions chan<- SubscriberPosition,
+) error {
+	consumer, err := p.newConsumer()
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := consumer.Partitions(p.config.Topic)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get partitions for topic %q", p.config.Topic)
+	}
+
+	partitionConsumers := make([]sarama.PartitionConsumer, 0, len(partitions))
+	defer func() {
+		for _, partitionConsumer := range partitionConsumers {
+			partitionConsumer.Close()
+		}
+	}()
+	for _, partition := range partitions {
+		partitionConsumer, err := p.consumePartition(consumer, partition, pos)
+		if err != nil {
+			return err
+		}
+		partitionConsumers = append(partitionConsumers, partitionConsumer)
+	}
+
+	// Copy pos because it will be mutated below.
+	pos = copyPosition(pos)
+	messages := make(chan *sarama.ConsumerMessage)
+	g, ctx := errgroup.WithContext(ctx)
+	for _, partitionConsumer := range partitionConsumers {
+		partitionConsumer := partitionConsumer // copy for closure
+		g.Go(func() error {
+			for {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case msg, ok := <-partitionConsumer.Messages():
+					if !ok {
+						return nil
+					}
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case messages <- msg:
+					}
+				}
+			}
+		})
+	}
+	g.Go(func() error {
+		// Only send positions on change.
+		var positionsOut chan<- SubscriberPosition
+		positionsOut = positions
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case positionsOut <- pos:
+				// Copy pos because it will be mutated below.
+				pos = copyPosition(pos)
+				positionsOut = nil
+			case msg := <-messages:
+				var doc traceIDDocument
+				if err := json.Unmarshal(msg.Value, &doc); err != nil {
+					p.config.Logger.With(logp.Error(err)).Debug("failed to decode sampled trace id")
+				} else if doc.Agent.EphemeralID != p.config.ServerID {
+					select {
+					case <-ctx.Done():
+						return ctx.Err()
+					case traceIDs <- doc.Trace.ID:
+					}
+				}
+				pos.observedSeqnos[kafkaPartitionKey(msg.Topic, msg.Partition)] = msg.Offset
+				positionsOut = positions
+			}
+		}
+	})
+	return g.Wait()
+}
+
+// consumePartition starts consuming partition after its most recently observed
+// offset, or from the oldest available offset if there is none, or if it is no
+// longer available.
+func (p *KafkaPubsub) consumePartition(
+	consumer sarama.Consumer, partition int32, pos SubscriberPosition,
+) (sarama.PartitionConsumer, error) {
+	offset := sarama.OffsetOldest
+	if observed, ok := pos.observedSeqnos[kafkaPartitionKey(p.config.Topic, partition)]; ok {
+		offset = observed + 1
+	}
+	partitionConsumer, err := consumer.ConsumePartition(p.config.Topic, partition, offset)
+	if err == sarama.ErrOffsetOutOfRange && offset != sarama.OffsetOldest {
+		p.config.Logger.Debugf("offset %d out of range for partition %d, consuming from oldest", offset, partition)
+		partitionConsumer, err = consumer.ConsumePartition(p.config.Topic, partition, sarama.OffsetOldest)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to consume partition %d", partition)
+	}
+	return partitionConsumer, nil
+}
+
+// kafkaPartitionKey returns the key under which the most recently observed
+// offset for a topic partition is recorded in SubscriberPosition. Kafka topic
+// partitions are recorded alongside Elasticsearch index names, which cannot
+// contain '/', so the two can never collide.
+func kafkaPartitionKey(topic string, partition int32) string {
+	return fmt.Sprintf("%s/%d", topic, partition)
+}
@@ -0,0 +1,125 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sync/errgroup"
+
+	"github.com/elastic/elastic-agent-libs/logp"
+)
+
+const kafkaTopic = "apm-sampled-traces"
+
+func TestKafkaPublishSampledTraceIDs(t *testing.T) {
+	producer := mocks.NewAsyncProducer(t, nil)
+	published := make(chan *sarama.ProducerMessage, 3)
+	for i := 0; i < cap(published); i++ {
+		producer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(msg *sarama.ProducerMessage) error {
+			published <- msg
+			return nil
+		})
+	}
+	pub := newKafkaPubsub(t, producer, nil)
+
+	ids := make(chan string)
+	ctx, cancel := context.WithCancel(context.Background())
+	var g errgroup.Group
+	g.Go(func() error {
+		return pub.PublishSampledTraceIDs(ctx, ids)
+	})
+	for _, id := range []string{"trace_1", "trace_2", "trace_3"} {
+		ids <- id
+	}
+	for _, id := range []string{"trace_1", "trace_2", "trace_3"} {
+		var msg *sarama.ProducerMessage
+		select {
+		case msg = <-published:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for message to be published")
+		}
+		assert.Equal(t, kafkaTopic, msg.Topic)
+		assert.Equal(t, sarama.StringEncoder(id), msg.Key)
+		value, err := msg.Value.Encode()
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"agent":{"ephemeral_id":"server_id"},"trace":{"id":"`+id+`"}}`, string(value))
+	}
+	cancel()
+	assert.NoError(t, g.Wait())
+}
+
+func TestKafkaSubscribeSampledTraceIDs(t *testing.T) {
+	consumer := mocks.NewConsumer(t, nil)
+	consumer.SetTopicMetadata(map[string][]int32{kafkaTopic: {0, 1}})
+	partition0 := consumer.ExpectConsumePartition(kafkaTopic, 0, sarama.OffsetOldest)
+	partition1 := consumer.ExpectConsumePartition(kafkaTopic, 1, 6) // resume after offset 5
+	partition0.YieldMessage(newKafkaMessage("other_server_id", "trace_1"))
+	partition0.YieldMessage(newKafkaMessage("server_id", "local_trace")) // local observation
+	partition1.YieldMessage(newKafkaMessage("other_server_id", "trace_2"))
+
+	var pos SubscriberPosition
+	require.NoError(t, json.Unmarshal([]byte(`{"apm-sampled-traces/1":5}`), &pos))
+	sub := newKafkaPubsub(t, nil, consumer)
+
+	ids := make(chan string)
+	positions := make(chan SubscriberPosition)
+	ctx, cancel := context.WithCancel(context.Background())
+	var g errgroup.Group
+	g.Go(func() error {
+		return sub.SubscribeSampledTraceIDs(ctx, pos, ids, positions)
+	})
+
+	var received []string
+	var lastPosition []byte
+	timeout := time.After(10 * time.Second)
+	for len(received) < 2 || string(lastPosition) != `{"apm-sampled-traces/0":2,"apm-sampled-traces/1":1}` {
+		select {
+		case id := <-ids:
+			received = append(received, id)
+		case pos := <-positions:
+			data, err := json.Marshal(pos)
+			require.NoError(t, err)
+			lastPosition = data
+		case <-timeout:
+			t.Fatalf("timed out waiting for trace IDs and position, received %q at %s", received, lastPosition)
+		}
+	}
+	assert.ElementsMatch(t, []string{"trace_1", "trace_2"}, received)
+
+	cancel()
+	assert.Equal(t, context.Canceled, g.Wait())
+}
+
+func newKafkaMessage(serverID, traceID string) *sarama.ConsumerMessage {
+	var doc traceIDDocument
+	doc.Agent.EphemeralID = serverID
+	doc.Trace.ID = traceID
+	value, _ := json.Marshal(doc)
+	return &sarama.ConsumerMessage{Key: []byte(traceID), Value: value}
+}
+
+func newKafkaPubsub(t testing.TB, producer sarama.AsyncProducer, consumer sarama.Consumer) *KafkaPubsub {
+	return &KafkaPubsub{
+		config: KafkaConfig{
+			Topic:    kafkaTopic,
+			ServerID: "server_id",
+			Logger:   logp.NewLogger("pubsub"),
+		},
+		newProducer: func() (sarama.AsyncProducer, error) {
+			return producer, nil
+		},
+		newConsumer: func() (sarama.Consumer, error) {
+			return consumer, nil
+		},
+	}
+}
@@ -11,7 +11,8 @@ import "encoding/json"
 //
 // The zero value is valid, and can be used to subscribe to all sampled trace IDs.
 type SubscriberPosition struct {
-	// observedSeqnos maps index names to its greatest observed _seq_no.
+	// observedSeqnos maps index names to its greatest observed _seq_no,
+	// and Kafka topic partitions to their greatest observed offset.
 	observedSeqnos map[string]int64
 }
 
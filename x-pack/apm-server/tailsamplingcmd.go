@@ -0,0 +1,113 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/cobra"
+
+	"github.com/elastic/beats/v7/libbeat/common/cli"
+	"github.com/elastic/elastic-agent-libs/paths"
+
+	"github.com/elastic/apm-server/internal/beatcmd"
+	beaterconfig "github.com/elastic/apm-server/internal/beater/config"
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+)
+
+func genTailSamplingCmd() *cobra.Command {
+	tailSamplingCmd := &cobra.Command{
+		Use:   "tail-sampling",
+		Short: "Manage tail-based sampling local storage",
+	}
+	gcCmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Garbage collect tail-based sampling local storage",
+		Long: `Garbage collect tail-based sampling local storage.
+
+Storage is compacted, discarding expired events, and then the value log is
+garbage collected to reclaim disk space. APM Server must not be running.`,
+		Run: cli.RunWith(func(cmd *cobra.Command, args []string) error {
+			return tailSamplingGC(cmd.OutOrStdout())
+		}),
+	}
+	tailSamplingCmd.AddCommand(gcCmd)
+	return tailSamplingCmd
+}
+
+func tailSamplingGC(w io.Writer) error {
+	cfg, _, _, err := beatcmd.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	beaterConfig, err := beaterconfig.NewConfig(cfg.APMServer, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	storageDir := paths.Resolve(paths.Data, tailSamplingStorageDir)
+	sizeBefore, err := dirSize(storageDir)
+	if err != nil {
+		return fmt.Errorf("failed to read tail-based sampling storage: %w", err)
+	}
+	rewrites, err := runStorageGC(storageDir, newBadgerOptions(beaterConfig.Sampling.Tail.Storage))
+	if err != nil {
+		return err
+	}
+	sizeAfter, err := dirSize(storageDir)
+	if err != nil {
+		return fmt.Errorf("failed to read tail-based sampling storage: %w", err)
+	}
+	fmt.Fprintf(w,
+		"Rewrote %d value log files, storage size reduced from %s to %s\n",
+		rewrites, humanize.IBytes(uint64(sizeBefore)), humanize.IBytes(uint64(sizeAfter)),
+	)
+	return nil
+}
+
+// runStorageGC compacts the Badger database in storageDir, discarding
+// expired events, and then garbage collects the value log. runStorageGC
+// returns the number of value log files rewritten.
+func runStorageGC(storageDir string, opts eventstorage.BadgerOptions) (int, error) {
+	db, err := eventstorage.OpenBadger(storageDir, opts)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open tail-based sampling storage (is APM Server running?): %w", err)
+	}
+	defer db.Close()
+
+	// Compacting all levels discards expired events, and records
+	// the space that can be reclaimed from the value log.
+	if err := db.Flatten(1); err != nil {
+		return 0, fmt.Errorf("failed to compact tail-based sampling storage: %w", err)
+	}
+	const discardRatio = 0.5
+	rewrites, err := eventstorage.RunValueLogGC(db, discardRatio)
+	if err != nil {
+		return rewrites, fmt.Errorf("failed to garbage collect tail-based sampling storage: %w", err)
+	}
+	return rewrites, nil
+}
+
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
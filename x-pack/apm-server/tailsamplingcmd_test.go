@@ -0,0 +1,45 @@
+// Copyright Elasticsearch B.V. and/or licensed to Elasticsearch B.V. under one
+// or more contributor license agreements. Licensed under the Elastic License 2.0;
+// you may not use this file except in compliance with the Elastic License 2.0.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/elastic/apm-server/x-pack/apm-server/sampling/eventstorage"
+)
+
+func TestRunStorageGC(t *testing.T) {
+	storageDir := t.TempDir()
+	opts := eventstorage.BadgerOptions{ValueLogFileSize: 1024 * 1024}
+
+	db, err := eventstorage.OpenBadger(storageDir, opts)
+	require.NoError(t, err)
+	value := make([]byte, 4096)
+	for i := 0; i < 1000; i++ {
+		err := db.Update(func(txn *badger.Txn) error {
+			key := []byte{byte(i >> 8), byte(i)}
+			return txn.SetEntry(badger.NewEntry(key, value).WithTTL(time.Second))
+		})
+		require.NoError(t, err)
+	}
+	require.NoError(t, db.Close())
+	sizeBefore, err := dirSize(storageDir)
+	require.NoError(t, err)
+
+	// Wait for the entries to expire.
+	time.Sleep(time.Second)
+
+	rewrites, err := runStorageGC(storageDir, opts)
+	require.NoError(t, err)
+	assert.NotZero(t, rewrites)
+	sizeAfter, err := dirSize(storageDir)
+	require.NoError(t, err)
+	assert.Less(t, sizeAfter, sizeBefore)
+}